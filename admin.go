@@ -0,0 +1,341 @@
+package mocrelay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+const AdminContentType = "application/nostr+json+rpc"
+
+var (
+	ErrAdminMethodNotFound = errors.New("method not found")
+	ErrAdminInvalidParams  = errors.New("invalid params")
+)
+
+type AdminHandler struct {
+	Moderator *Moderator
+
+	// Admins are the pubkeys allowed to call methods with NIP-98 authorization.
+	// If empty, all requests are rejected.
+	Admins []string
+
+	// NIP98 is used to verify the authorization. The payload is always required.
+	NIP98 *NIP98Option
+
+	// Key signs notices passed to Broadcast. Both are required for "broadcast".
+	Key       *Keypair
+	Broadcast func(event *Event)
+
+	// TopPubkeys and TopIPs are the most active pubkeys and IPs
+	// for "toppubkeys" and "topips".
+	TopPubkeys *TopK
+	TopIPs     *TopK
+
+	// DebugTap is tapped by "tapconn", "untapconn", "listtaps" and "tapframes".
+	DebugTap *DebugTap
+}
+
+type AdminRequest struct {
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+type AdminResponse struct {
+	Result any    `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+type adminMethod func(ctx context.Context, params []json.RawMessage) (any, error)
+
+func (h *AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", AdminContentType)
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(&AdminResponse{Error: "method not allowed"})
+		return
+	}
+
+	if err := h.authorize(r); err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(&AdminResponse{Error: err.Error()})
+		return
+	}
+
+	var req AdminRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(&AdminResponse{Error: "invalid request"})
+		return
+	}
+
+	result, err := h.Call(r.Context(), &req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(&AdminResponse{Error: err.Error()})
+		return
+	}
+
+	json.NewEncoder(w).Encode(&AdminResponse{Result: result})
+}
+
+func (h *AdminHandler) authorize(r *http.Request) error {
+	if len(h.Admins) == 0 {
+		return fmt.Errorf("%w: no admins", ErrInvalidNIP98Auth)
+	}
+
+	var opt NIP98Option
+	if h.NIP98 != nil {
+		opt = *h.NIP98
+	}
+	opt.RequirePayload = true
+
+	event, err := VerifyNIP98WithOption(r, &opt)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(h.Admins, event.Pubkey) {
+		return fmt.Errorf("%w: pubkey is not an admin", ErrInvalidNIP98Auth)
+	}
+	return nil
+}
+
+// Call runs req and returns the result.
+func (h *AdminHandler) Call(ctx context.Context, req *AdminRequest) (any, error) {
+	methods := h.methods()
+
+	if req.Method == "supportedmethods" {
+		ret := []string{"supportedmethods"}
+		for name := range methods {
+			ret = append(ret, name)
+		}
+		slices.Sort(ret)
+		return ret, nil
+	}
+
+	method, ok := methods[req.Method]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrAdminMethodNotFound, req.Method)
+	}
+	return method(ctx, req.Params)
+}
+
+func (h *AdminHandler) methods() map[string]adminMethod {
+	ret := make(map[string]adminMethod)
+
+	if h.Moderator != nil {
+		ret["banevent"] = h.banEvent
+		ret["allowevent"] = h.allowEvent
+		ret["listbannedevents"] = h.listBannedEvents
+		ret["banpubkey"] = h.banPubkey
+		ret["unbanpubkey"] = h.unbanPubkey
+		ret["listbannedpubkeys"] = h.listBannedPubkeys
+		ret["purgepubkey"] = h.purgePubkey
+	}
+
+	if h.Key != nil && h.Broadcast != nil {
+		ret["broadcast"] = h.broadcast
+	}
+
+	if h.TopPubkeys != nil {
+		ret["toppubkeys"] = topKMethod(h.TopPubkeys)
+	}
+	if h.TopIPs != nil {
+		ret["topips"] = topKMethod(h.TopIPs)
+	}
+
+	if h.DebugTap != nil {
+		ret["tapconn"] = h.tapConn
+		ret["untapconn"] = h.untapConn
+		ret["listtaps"] = h.listTaps
+		ret["tapframes"] = h.tapFrames
+	}
+
+	return ret
+}
+
+func parseAdminParams(params []json.RawMessage, required int, dst ...any) error {
+	if len(params) < required {
+		return fmt.Errorf(
+			"%w: need %d params but got %d",
+			ErrAdminInvalidParams,
+			required,
+			len(params),
+		)
+	}
+	for i := 0; i < len(dst) && i < len(params); i++ {
+		if err := json.Unmarshal(params[i], dst[i]); err != nil {
+			return fmt.Errorf("%w: %w", ErrAdminInvalidParams, err)
+		}
+	}
+	return nil
+}
+
+func (h *AdminHandler) banEvent(ctx context.Context, params []json.RawMessage) (any, error) {
+	var id, reason string
+	if err := parseAdminParams(params, 1, &id, &reason); err != nil {
+		return nil, err
+	}
+	if !validID(id) {
+		return nil, fmt.Errorf("%w: invalid event id", ErrAdminInvalidParams)
+	}
+
+	h.Moderator.DeleteEvent(id, reason)
+	return true, nil
+}
+
+func (h *AdminHandler) allowEvent(ctx context.Context, params []json.RawMessage) (any, error) {
+	var id string
+	if err := parseAdminParams(params, 1, &id); err != nil {
+		return nil, err
+	}
+
+	if err := h.Moderator.UndeleteEvent(id); err != nil {
+		return nil, err
+	}
+	return true, nil
+}
+
+func (h *AdminHandler) listBannedEvents(
+	ctx context.Context,
+	params []json.RawMessage,
+) (any, error) {
+	type entry struct {
+		ID     string `json:"id"`
+		Reason string `json:"reason,omitempty"`
+	}
+
+	actions := h.Moderator.DeletedEvents()
+	ret := make([]entry, len(actions))
+	for i, action := range actions {
+		ret[i] = entry{ID: action.Target, Reason: action.Reason}
+	}
+	return ret, nil
+}
+
+func (h *AdminHandler) banPubkey(ctx context.Context, params []json.RawMessage) (any, error) {
+	var pubkey, reason string
+	if err := parseAdminParams(params, 1, &pubkey, &reason); err != nil {
+		return nil, err
+	}
+	if !validPubkey(pubkey) {
+		return nil, fmt.Errorf("%w: invalid pubkey", ErrAdminInvalidParams)
+	}
+
+	h.Moderator.BanPubkey(pubkey, reason)
+	return true, nil
+}
+
+func (h *AdminHandler) purgePubkey(ctx context.Context, params []json.RawMessage) (any, error) {
+	var pubkey, reason string
+	if err := parseAdminParams(params, 1, &pubkey, &reason); err != nil {
+		return nil, err
+	}
+	if !validPubkey(pubkey) {
+		return nil, fmt.Errorf("%w: invalid pubkey", ErrAdminInvalidParams)
+	}
+
+	return h.Moderator.PurgePubkey(ctx, pubkey, reason)
+}
+
+func (h *AdminHandler) unbanPubkey(ctx context.Context, params []json.RawMessage) (any, error) {
+	var pubkey string
+	if err := parseAdminParams(params, 1, &pubkey); err != nil {
+		return nil, err
+	}
+
+	if err := h.Moderator.UnbanPubkey(pubkey); err != nil {
+		return nil, err
+	}
+	return true, nil
+}
+
+func (h *AdminHandler) listBannedPubkeys(
+	ctx context.Context,
+	params []json.RawMessage,
+) (any, error) {
+	type entry struct {
+		Pubkey string `json:"pubkey"`
+		Reason string `json:"reason,omitempty"`
+	}
+
+	actions := h.Moderator.BannedPubkeys()
+	ret := make([]entry, len(actions))
+	for i, action := range actions {
+		ret[i] = entry{Pubkey: action.Target, Reason: action.Reason}
+	}
+	return ret, nil
+}
+
+func (h *AdminHandler) broadcast(ctx context.Context, params []json.RawMessage) (any, error) {
+	var content string
+	if err := parseAdminParams(params, 1, &content); err != nil {
+		return nil, err
+	}
+
+	event, err := h.Key.NewNoticeEvent(content)
+	if err != nil {
+		return nil, err
+	}
+	h.Broadcast(event)
+	return event.ID, nil
+}
+
+// topKMethod returns a method which returns the top entries of t.
+// The optional param is the number of them. The default is 20.
+func topKMethod(t *TopK) adminMethod {
+	return func(ctx context.Context, params []json.RawMessage) (any, error) {
+		n := 20
+		if err := parseAdminParams(params, 0, &n); err != nil {
+			return nil, err
+		}
+		if n <= 0 {
+			return nil, fmt.Errorf("%w: n must be positive", ErrAdminInvalidParams)
+		}
+		return t.Top(n), nil
+	}
+}
+
+func (h *AdminHandler) tapConn(ctx context.Context, params []json.RawMessage) (any, error) {
+	var connID string
+	if err := parseAdminParams(params, 1, &connID); err != nil {
+		return nil, err
+	}
+
+	if err := h.DebugTap.Start(connID); err != nil {
+		return nil, err
+	}
+	return true, nil
+}
+
+func (h *AdminHandler) untapConn(ctx context.Context, params []json.RawMessage) (any, error) {
+	var connID string
+	if err := parseAdminParams(params, 1, &connID); err != nil {
+		return nil, err
+	}
+
+	if err := h.DebugTap.Stop(connID); err != nil {
+		return nil, err
+	}
+	return true, nil
+}
+
+func (h *AdminHandler) listTaps(ctx context.Context, params []json.RawMessage) (any, error) {
+	return h.DebugTap.Tapped(), nil
+}
+
+// tapFrames returns the frames of the tapped connection.
+// The optional second param is the last sequence number already got.
+func (h *AdminHandler) tapFrames(ctx context.Context, params []json.RawMessage) (any, error) {
+	var connID string
+	var since uint64
+	if err := parseAdminParams(params, 1, &connID, &since); err != nil {
+		return nil, err
+	}
+
+	return h.DebugTap.Frames(connID, since)
+}
@@ -0,0 +1,271 @@
+package mocrelay
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestAdminRequest returns a request to an AdminHandler authorized by
+// the pubkey of signTestEvent.
+func newTestAdminRequest(t *testing.T, method, body string) *http.Request {
+	t.Helper()
+
+	const url = "http://relay.example.com/"
+
+	sum := sha256.Sum256([]byte(body))
+	event := newTestNIP98Event(t, HTTPAuthEventKind, time.Now(), []Tag{
+		{"u", url},
+		{"method", method},
+		{"payload", hex.EncodeToString(sum[:])},
+	})
+	return newTestNIP98Request(t, method, url, body, event)
+}
+
+func TestAdminHandler(t *testing.T) {
+	pubkey := "dbf0becf24bf8dd7d779d7fb547e6112964ff042b77a42cc2d8488636eed9f5e"
+
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		status int
+		want   string
+	}{
+		{
+			name:   "supportedmethods",
+			method: http.MethodPost,
+			body:   `{"method":"supportedmethods","params":[]}`,
+			status: http.StatusOK,
+			want:   `{"result":["allowevent","banevent","banpubkey","listbannedevents","listbannedpubkeys","purgepubkey","supportedmethods","unbanpubkey"]}`,
+		},
+		{
+			name:   "banpubkey",
+			method: http.MethodPost,
+			body:   `{"method":"banpubkey","params":["` + pubkey + `","spam"]}`,
+			status: http.StatusOK,
+			want:   `{"result":true}`,
+		},
+		{
+			name:   "listbannedpubkeys",
+			method: http.MethodPost,
+			body:   `{"method":"listbannedpubkeys","params":[]}`,
+			status: http.StatusOK,
+			want:   `{"result":[{"pubkey":"` + pubkey + `","reason":"spam"}]}`,
+		},
+		{
+			name:   "unbanpubkey",
+			method: http.MethodPost,
+			body:   `{"method":"unbanpubkey","params":["` + pubkey + `"]}`,
+			status: http.StatusOK,
+			want:   `{"result":true}`,
+		},
+		{
+			name:   "ng: unbanpubkey: not found",
+			method: http.MethodPost,
+			body:   `{"method":"unbanpubkey","params":["` + pubkey + `"]}`,
+			status: http.StatusBadRequest,
+			want:   `{"result":null,"error":"moderation action not found"}`,
+		},
+		{
+			name:   "purgepubkey",
+			method: http.MethodPost,
+			body:   `{"method":"purgepubkey","params":["` + pubkey + `","illegal"]}`,
+			status: http.StatusOK,
+			want:   `{"result":0}`,
+		},
+		{
+			name:   "ng: unbanpubkey: purged",
+			method: http.MethodPost,
+			body:   `{"method":"unbanpubkey","params":["` + pubkey + `"]}`,
+			status: http.StatusBadRequest,
+			want:   `{"result":null,"error":"moderation undo window expired"}`,
+		},
+		{
+			name:   "ng: banevent: invalid id",
+			method: http.MethodPost,
+			body:   `{"method":"banevent","params":["xxx"]}`,
+			status: http.StatusBadRequest,
+			want:   `{"result":null,"error":"invalid params: invalid event id"}`,
+		},
+		{
+			name:   "ng: unknown method",
+			method: http.MethodPost,
+			body:   `{"method":"powa","params":[]}`,
+			status: http.StatusBadRequest,
+			want:   `{"result":null,"error":"method not found: powa"}`,
+		},
+		{
+			name:   "ng: get",
+			method: http.MethodGet,
+			body:   "",
+			status: http.StatusMethodNotAllowed,
+			want:   `{"result":null,"error":"method not allowed"}`,
+		},
+	}
+
+	admin := signTestEvent(t, &Event{}).Pubkey
+	h := &AdminHandler{Moderator: NewModerator(nil), Admins: []string{admin}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestAdminRequest(t, tt.method, tt.body)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.status, w.Code)
+			assert.Equal(t, AdminContentType, w.Header().Get("Content-Type"))
+			assert.JSONEq(t, tt.want, w.Body.String())
+		})
+	}
+}
+
+func TestAdminHandler_nip98(t *testing.T) {
+	const url = "http://relay.example.com/"
+	const body = `{"method":"supportedmethods","params":[]}`
+
+	sum := sha256.Sum256([]byte(body))
+	newEvent := func() *Event {
+		return newTestNIP98Event(t, HTTPAuthEventKind, time.Now(), []Tag{
+			{"u", url},
+			{"method", "POST"},
+			{"payload", hex.EncodeToString(sum[:])},
+		})
+	}
+	admin := newEvent().Pubkey
+
+	tests := []struct {
+		name   string
+		admins []string
+		event  *Event
+		status int
+	}{
+		{"ng: no admins", nil, newEvent(), http.StatusUnauthorized},
+		{"ok", []string{admin}, newEvent(), http.StatusOK},
+		{"ng: no authorization", []string{admin}, nil, http.StatusUnauthorized},
+		{"ng: not admin", []string{strings.Repeat("a", 64)}, newEvent(), http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &AdminHandler{Moderator: NewModerator(nil), Admins: tt.admins}
+
+			r := newTestNIP98Request(t, http.MethodPost, url, body, tt.event)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.status, w.Code)
+		})
+	}
+}
+
+func TestAdminHandler_broadcast(t *testing.T) {
+	key, err := ParseKeypair(strings.Repeat("01", 32))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var got []*Event
+	h := &AdminHandler{
+		Key:       key,
+		Broadcast: func(event *Event) { got = append(got, event) },
+	}
+
+	ret, err := h.Call(context.Background(), &AdminRequest{
+		Method: "broadcast",
+		Params: []json.RawMessage{json.RawMessage(`"maintenance at 12:00"`)},
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, got, 1) {
+		assert.Equal(t, got[0].ID, ret)
+		assert.Equal(t, key.Pubkey(), got[0].Pubkey)
+		assert.Equal(t, "maintenance at 12:00", got[0].Content)
+	}
+
+	_, err = (&AdminHandler{Key: key}).Call(
+		context.Background(),
+		&AdminRequest{Method: "broadcast"},
+	)
+	assert.ErrorIs(t, err, ErrAdminMethodNotFound)
+}
+
+func TestAdminHandler_topK(t *testing.T) {
+	ips := NewTopK(10)
+	ips.Add("192.0.2.1")
+	ips.Add("192.0.2.2")
+	ips.Add("192.0.2.2")
+
+	h := &AdminHandler{TopIPs: ips}
+
+	ret, err := h.Call(context.Background(), &AdminRequest{
+		Method: "topips",
+		Params: []json.RawMessage{json.RawMessage(`1`)},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []TopKEntry{{Key: "192.0.2.2", Count: 2}}, ret)
+
+	ret, err = h.Call(context.Background(), &AdminRequest{Method: "topips"})
+	assert.NoError(t, err)
+	assert.Len(t, ret, 2)
+
+	_, err = h.Call(context.Background(), &AdminRequest{
+		Method: "topips",
+		Params: []json.RawMessage{json.RawMessage(`0`)},
+	})
+	assert.ErrorIs(t, err, ErrAdminInvalidParams)
+
+	_, err = h.Call(context.Background(), &AdminRequest{Method: "toppubkeys"})
+	assert.ErrorIs(t, err, ErrAdminMethodNotFound)
+}
+
+func TestAdminHandler_debugTap(t *testing.T) {
+	tap := NewDebugTap(nil)
+	h := &AdminHandler{DebugTap: tap}
+	ctx := ctxWithTestSession(context.Background(), "")
+	id := GetRequestID(ctx)
+	param := json.RawMessage(`"` + id + `"`)
+
+	ret, err := h.Call(context.Background(), &AdminRequest{
+		Method: "tapconn",
+		Params: []json.RawMessage{param},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, true, ret)
+
+	ret, err = h.Call(context.Background(), &AdminRequest{Method: "listtaps"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{id}, ret)
+
+	tap.recordRecv(ctx, WSMessageText, []byte(`["REQ","sub"]`))
+	tap.recordSend(ctx, []byte(`["EOSE","sub"]`))
+
+	ret, err = h.Call(context.Background(), &AdminRequest{
+		Method: "tapframes",
+		Params: []json.RawMessage{param, json.RawMessage(`1`)},
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, ret, 1) {
+		assert.Equal(t, `["EOSE","sub"]`, ret.([]DebugFrame)[0].Data)
+	}
+
+	ret, err = h.Call(context.Background(), &AdminRequest{
+		Method: "untapconn",
+		Params: []json.RawMessage{param},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, true, ret)
+
+	_, err = h.Call(context.Background(), &AdminRequest{
+		Method: "tapframes",
+		Params: []json.RawMessage{param},
+	})
+	assert.ErrorIs(t, err, ErrDebugTapNotFound)
+}
@@ -0,0 +1,146 @@
+package mocrelay
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Policies recorded in AuditRecord.Policy.
+const (
+	AuditPolicyHandler       = "handler"
+	AuditPolicyValidation    = "validation"
+	AuditPolicyContentPolicy = "content_policy"
+	AuditPolicyVerifierQueue = "verifier_queue"
+	AuditPolicyRateLimit     = "rate_limit"
+)
+
+type AuditRecord struct {
+	Time      time.Time `json:"time"`
+	ConnID    string    `json:"conn"`
+	RealIP    string    `json:"ip,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	EventID   string    `json:"event_id"`
+	Pubkey    string    `json:"pubkey"`
+	Kind      int64     `json:"kind"`
+	Accepted  bool      `json:"accepted"`
+	Prefix    string    `json:"prefix,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	Policy    string    `json:"policy"`
+}
+
+// AuditLog writes a JSON line for every EVENT decision.
+// Decisions made by the relay itself are recorded with their policy and
+// the others with AuditPolicyHandler when the OK message is sent.
+type AuditLog struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error
+	// map[connID]map[eventID]event
+	pending map[string]map[string]*Event
+}
+
+func NewAuditLog(w io.Writer) *AuditLog {
+	return &AuditLog{
+		enc:     json.NewEncoder(w),
+		pending: make(map[string]map[string]*Event),
+	}
+}
+
+func (l *AuditLog) record(
+	ctx context.Context,
+	event *Event,
+	accepted bool,
+	prefix, reason, policy string,
+) {
+	r := AuditRecord{
+		Time:     time.Now(),
+		ConnID:   GetRequestID(ctx),
+		RealIP:   GetRealIP(ctx),
+		EventID:  event.ID,
+		Pubkey:   event.Pubkey,
+		Kind:     event.Kind,
+		Accepted: accepted,
+		Prefix:   prefix,
+		Reason:   reason,
+		Policy:   policy,
+	}
+	if header := GetHTTPHeader(ctx); header != nil {
+		r.UserAgent = header.Get("User-Agent")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.err != nil {
+		return
+	}
+	l.err = l.enc.Encode(&r)
+}
+
+// reject records a rejection decided by the relay.
+func (l *AuditLog) reject(ctx context.Context, event *Event, prefix, reason, policy string) {
+	if l == nil {
+		return
+	}
+	l.record(ctx, event, false, prefix, reason, policy)
+}
+
+// track remembers event passed to the handler until its OK message is sent.
+func (l *AuditLog) track(ctx context.Context, msg ClientMsg) {
+	m, ok := msg.(*ClientEventMsg)
+	if l == nil || !ok {
+		return
+	}
+
+	connID := GetRequestID(ctx)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	events := l.pending[connID]
+	if events == nil {
+		events = make(map[string]*Event)
+		l.pending[connID] = events
+	}
+	events[m.Event.ID] = m.Event
+}
+
+func (l *AuditLog) recordSend(ctx context.Context, msg ServerMsg) {
+	m, ok := msg.(*ServerOKMsg)
+	if l == nil || !ok {
+		return
+	}
+
+	connID := GetRequestID(ctx)
+
+	l.mu.Lock()
+	event := l.pending[connID][m.EventID]
+	delete(l.pending[connID], m.EventID)
+	l.mu.Unlock()
+
+	if event == nil {
+		return
+	}
+	l.record(ctx, event, m.Accepted, m.MsgPrefix, m.Msg, AuditPolicyHandler)
+}
+
+// forget drops events of the connection which were not answered.
+func (l *AuditLog) forget(ctx context.Context) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.pending, GetRequestID(ctx))
+}
+
+// Err returns the first write error. Logging stops after an error.
+func (l *AuditLog) Err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.err
+}
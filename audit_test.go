@@ -0,0 +1,153 @@
+package mocrelay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) records(t *testing.T) []AuditRecord {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var ret []AuditRecord
+	dec := json.NewDecoder(bytes.NewReader(b.buf.Bytes()))
+	for dec.More() {
+		var r AuditRecord
+		require.NoError(t, dec.Decode(&r))
+		ret = append(ret, r)
+	}
+	return ret
+}
+
+func TestAuditLog(t *testing.T) {
+	var buf syncBuffer
+	l := NewAuditLog(&buf)
+
+	ctx := ctxWithTestSession(context.Background(), "")
+	event := &Event{ID: "id", Pubkey: "pubkey", Kind: 1}
+
+	// Not tracked
+	l.recordSend(ctx, NewServerOKMsg("id", true, ServerOKMsgPrefixNoPrefix, ""))
+	assert.Empty(t, buf.records(t))
+
+	l.track(ctx, &ClientEventMsg{Event: event})
+	l.recordSend(ctx, NewServerOKMsg("id", false, ServerOKMsgPrefixDuplicate, "have it"))
+	l.recordSend(ctx, NewServerOKMsg("id", false, ServerOKMsgPrefixDuplicate, "have it"))
+
+	l.reject(ctx, event, ServerOkMsgPrefixRateLimited, "slow down", AuditPolicyRateLimit)
+
+	l.track(ctx, &ClientEventMsg{Event: event})
+	l.forget(ctx)
+	l.recordSend(ctx, NewServerOKMsg("id", true, ServerOKMsgPrefixNoPrefix, ""))
+
+	records := buf.records(t)
+	require.Len(t, records, 2)
+
+	assert.Equal(t, GetRequestID(ctx), records[0].ConnID)
+	assert.Equal(t, "id", records[0].EventID)
+	assert.Equal(t, "pubkey", records[0].Pubkey)
+	assert.Equal(t, int64(1), records[0].Kind)
+	assert.False(t, records[0].Accepted)
+	assert.Equal(t, ServerOKMsgPrefixDuplicate, records[0].Prefix)
+	assert.Equal(t, "have it", records[0].Reason)
+	assert.Equal(t, AuditPolicyHandler, records[0].Policy)
+
+	assert.Equal(t, AuditPolicyRateLimit, records[1].Policy)
+	assert.Equal(t, "slow down", records[1].Reason)
+
+	assert.NoError(t, l.Err())
+
+	var nilLog *AuditLog
+	nilLog.reject(ctx, event, "", "", AuditPolicyRateLimit)
+	nilLog.track(ctx, &ClientEventMsg{Event: event})
+	nilLog.recordSend(ctx, NewServerOKMsg("id", true, "", ""))
+	nilLog.forget(ctx)
+}
+
+func TestRelay_auditLog(t *testing.T) {
+	h := HandlerFunc(func(r *http.Request, recv <-chan ClientMsg, send chan<- ServerMsg) error {
+		for msg := range recv {
+			if m, ok := msg.(*ClientEventMsg); ok {
+				send <- NewServerOKMsg(m.Event.ID, true, ServerOKMsgPrefixNoPrefix, "")
+			}
+		}
+		return nil
+	})
+
+	var buf syncBuffer
+	relay := NewRelay(h, &RelayOption{
+		AuditLog:      NewAuditLog(&buf),
+		ContentPolicy: &ContentPolicy{MaxContentLength: 10},
+	})
+	srv := httptest.NewServer(relay)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	require.NoError(t, err)
+	defer conn.Close(websocket.StatusNormalClosure, "")
+
+	accepted := signTestEvent(t, &Event{CreatedAt: time.Now().Unix(), Kind: 1, Tags: []Tag{}})
+	tooLong := signTestEvent(t, &Event{
+		CreatedAt: time.Now().Unix(),
+		Kind:      1,
+		Tags:      []Tag{},
+		Content:   strings.Repeat("a", 11),
+	})
+	tampered := *accepted
+	tampered.Sig = tooLong.Sig
+
+	for _, ev := range []*Event{accepted, tooLong, &tampered} {
+		b, err := json.Marshal([]any{"EVENT", ev})
+		require.NoError(t, err)
+		require.NoError(t, conn.Write(ctx, websocket.MessageText, b))
+		_, _, err = conn.Read(ctx)
+		require.NoError(t, err)
+	}
+
+	var records []AuditRecord
+	assert.Eventually(t, func() bool {
+		records = buf.records(t)
+		return len(records) == 3
+	}, time.Second, 10*time.Millisecond)
+
+	policies := make(map[string]AuditRecord)
+	for _, r := range records {
+		policies[r.Policy] = r
+	}
+
+	assert.True(t, policies[AuditPolicyHandler].Accepted)
+	assert.Equal(t, accepted.ID, policies[AuditPolicyHandler].EventID)
+	assert.Equal(t, "127.0.0.1", policies[AuditPolicyHandler].RealIP)
+
+	assert.False(t, policies[AuditPolicyContentPolicy].Accepted)
+	assert.Equal(t, tooLong.ID, policies[AuditPolicyContentPolicy].EventID)
+	assert.Equal(t, ServerOkMsgPrefixRateInvalid, policies[AuditPolicyContentPolicy].Prefix)
+
+	assert.False(t, policies[AuditPolicyValidation].Accepted)
+	assert.Equal(t, accepted.ID, policies[AuditPolicyValidation].EventID)
+}
@@ -0,0 +1,279 @@
+package mocrelay
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrInvalidAuthChallenge = errors.New("invalid auth challenge")
+
+type AuthOption struct {
+	// ChallengeTTL is how long an issued challenge can be answered.
+	// The default is 10 minutes.
+	ChallengeTTL time.Duration
+
+	// RelayURLs are the URLs accepted in the relay tags of AUTH events in addition to
+	// Session.RelayURL, such as the onion address of a clearnet relay.
+	RelayURLs []string
+
+	// Reauth is the period after which authenticated connections lose their pubkeys
+	// and are challenged again on their next message. Zero disables it.
+	Reauth time.Duration
+}
+
+func (opt *AuthOption) challengeTTL() time.Duration {
+	if opt == nil || opt.ChallengeTTL <= 0 {
+		return 10 * time.Minute
+	}
+	return opt.ChallengeTTL
+}
+
+func (opt *AuthOption) relayURLs() []string {
+	if opt == nil {
+		return nil
+	}
+	return opt.RelayURLs
+}
+
+func (opt *AuthOption) reauth() time.Duration {
+	if opt == nil {
+		return 0
+	}
+	return opt.Reauth
+}
+
+// authChallenges are the challenges issued by a relay. A challenge can be answered
+// only once, only before it expires and only on the session and IP it is issued to,
+// so that AUTH events cannot be replayed on other connections.
+// A new challenge replaces the unused one of the same session.
+type authChallenges struct {
+	ttl time.Duration
+
+	mu sync.Mutex
+	m  map[string]*authChallenge
+	// map[sessionID]challenge of the latest challenge of each session
+	latest    map[string]string
+	lastSweep time.Time
+}
+
+type authChallenge struct {
+	sessionID string
+	ip        string
+	expires   time.Time
+	used      bool
+}
+
+func newAuthChallenges(ttl time.Duration) *authChallenges {
+	return &authChallenges{
+		ttl:    ttl,
+		m:      make(map[string]*authChallenge),
+		latest: make(map[string]string),
+	}
+}
+
+// issue returns a new challenge for sess.
+func (c *authChallenges) issue(sess *Session, now time.Time) string {
+	challenge := uuid.NewString()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sweep(now)
+	c.release(sess)
+	c.m[challenge] = &authChallenge{
+		sessionID: sess.ID,
+		ip:        sess.RealIP,
+		expires:   now.Add(c.ttl),
+	}
+	c.latest[sess.ID] = challenge
+	return challenge
+}
+
+// release deletes the latest challenge of sess unless it is used.
+// c.mu must be held.
+func (c *authChallenges) release(sess *Session) {
+	challenge, ok := c.latest[sess.ID]
+	if !ok {
+		return
+	}
+	delete(c.latest, sess.ID)
+
+	if ch := c.m[challenge]; ch != nil && !ch.used {
+		delete(c.m, challenge)
+	}
+}
+
+// close releases the challenges of the finished session sess.
+func (c *authChallenges) close(sess *Session) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.release(sess)
+}
+
+// sweep deletes expired challenges at most once per ttl.
+func (c *authChallenges) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < c.ttl {
+		return
+	}
+	c.lastSweep = now
+
+	for challenge, ch := range c.m {
+		if !now.Before(ch.expires) {
+			delete(c.m, challenge)
+			if c.latest[ch.sessionID] == challenge {
+				delete(c.latest, ch.sessionID)
+			}
+		}
+	}
+}
+
+// consume marks challenge answered on sess.
+func (c *authChallenges) consume(sess *Session, challenge string, now time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch, ok := c.m[challenge]
+	if !ok || !now.Before(ch.expires) {
+		return fmt.Errorf("%w: unknown or expired challenge", ErrInvalidAuthChallenge)
+	}
+	if ch.sessionID != sess.ID || ch.ip != sess.RealIP {
+		return fmt.Errorf("%w: challenge issued to another connection", ErrInvalidAuthChallenge)
+	}
+	if ch.used {
+		return fmt.Errorf("%w: challenge already used", ErrInvalidAuthChallenge)
+	}
+
+	// Used challenges are kept until they expire to detect replays.
+	ch.used = true
+	return nil
+}
+
+func (c *authChallenges) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.m)
+}
+
+type AuthMiddleware Middleware
+
+// NewAuthMiddleware authenticates clients with NIP-42 and sets Session.Pubkey.
+// Each connection is challenged when it starts and after a failed AUTH.
+func NewAuthMiddleware(option *AuthOption) AuthMiddleware {
+	challenges := newAuthChallenges(option.challengeTTL())
+
+	return func(h Handler) Handler {
+		return HandlerFunc(
+			func(r *http.Request, recv <-chan ClientMsg, send chan<- ServerMsg) error {
+				sm := newSimpleAuthMiddleware(option, challenges)
+				sendServerMsgCtx(r.Context(), send, sm.challenge(r, time.Now()))
+				m := NewSimpleMiddleware(sm)
+				return m(h).Handle(r, recv, send)
+			},
+		)
+	}
+}
+
+var _ SimpleMiddlewareInterface = (*simpleAuthMiddleware)(nil)
+
+type simpleAuthMiddleware struct {
+	relayURLs  []string
+	reauth     time.Duration
+	challenges *authChallenges
+
+	// authedAt is when the connection is authenticated or zero.
+	// It is only accessed by the goroutine handling client messages.
+	authedAt time.Time
+}
+
+func newSimpleAuthMiddleware(
+	option *AuthOption,
+	challenges *authChallenges,
+) *simpleAuthMiddleware {
+	return &simpleAuthMiddleware{
+		relayURLs:  option.relayURLs(),
+		reauth:     option.reauth(),
+		challenges: challenges,
+	}
+}
+
+func (m *simpleAuthMiddleware) challenge(r *http.Request, now time.Time) ServerMsg {
+	return NewServerAuthChallengeMsg(m.challenges.issue(GetSession(r.Context()), now))
+}
+
+func (m *simpleAuthMiddleware) HandleStart(r *http.Request) (*http.Request, error) {
+	return r, nil
+}
+
+func (m *simpleAuthMiddleware) HandleStop(r *http.Request) error {
+	m.challenges.close(GetSession(r.Context()))
+	return nil
+}
+
+func (m *simpleAuthMiddleware) HandleClientMsg(
+	r *http.Request,
+	msg ClientMsg,
+) (<-chan ClientMsg, <-chan ServerMsg, error) {
+	now := time.Now()
+
+	var smsgs []ServerMsg
+	if m.reauth > 0 && !m.authedAt.IsZero() && now.Sub(m.authedAt) >= m.reauth {
+		GetSession(r.Context()).SetPubkey("")
+		m.authedAt = time.Time{}
+		smsgs = append(smsgs, m.challenge(r, now))
+	}
+
+	authMsg, ok := msg.(*ClientAuthMsg)
+	if !ok || authMsg.Event == nil {
+		var smsgCh <-chan ServerMsg
+		if len(smsgs) > 0 {
+			smsgCh = newClosedBufCh(smsgs...)
+		}
+		return newClosedBufCh(msg), smsgCh, nil
+	}
+
+	if err := m.authenticate(r, authMsg.Event, now); err != nil {
+		okMsg := NewServerOKMsg(authMsg.Event.ID, false, ServerOkMsgPrefixRateInvalid, err.Error())
+		smsgs = append(smsgs, okMsg, m.challenge(r, now))
+		return nil, newClosedBufCh(smsgs...), nil
+	}
+
+	okMsg := NewServerOKMsg(authMsg.Event.ID, true, ServerOKMsgPrefixNoPrefix, "")
+	smsgs = append(smsgs, okMsg)
+	return nil, newClosedBufCh(smsgs...), nil
+}
+
+func (m *simpleAuthMiddleware) authenticate(r *http.Request, event *Event, now time.Time) error {
+	sess := GetSession(r.Context())
+
+	var challenge string
+	for _, tag := range event.Tags {
+		if len(tag) >= 2 && tag[0] == "challenge" {
+			challenge = tag[1]
+			break
+		}
+	}
+
+	relayURLs := append([]string{sess.RelayURL}, m.relayURLs...)
+	if err := ValidateAuthEventURLs(event, relayURLs, challenge); err != nil {
+		return err
+	}
+	if err := m.challenges.consume(sess, challenge, now); err != nil {
+		return err
+	}
+
+	sess.SetPubkey(event.Pubkey)
+	m.authedAt = now
+	return nil
+}
+
+func (m *simpleAuthMiddleware) HandleServerMsg(
+	r *http.Request,
+	msg ServerMsg,
+) (<-chan ServerMsg, error) {
+	return newClosedBufCh(msg), nil
+}
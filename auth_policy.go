@@ -0,0 +1,167 @@
+package mocrelay
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+// AuthPolicyOption restricts kinds to authenticated clients
+// as NIP-17 DM relays do. Clients are authenticated by Session.Pubkey.
+type AuthPolicyOption struct {
+	// ReqKinds are the kinds only their participants can read,
+	// such as 4 (encrypted direct messages) and 1059 (gift wraps).
+	// REQ and COUNT filters with them need AUTH and must be limited to
+	// the authenticated pubkey by authors or #p. Events of them are sent
+	// only to their authors and p-tagged pubkeys.
+	ReqKinds []int64
+
+	// EventKinds are the kinds whose EVENTs need AUTH.
+	EventKinds []int64
+}
+
+type AuthPolicyMiddleware Middleware
+
+func NewAuthPolicyMiddleware(option *AuthPolicyOption) AuthPolicyMiddleware {
+	m := newSimpleAuthPolicyMiddleware(option)
+	return AuthPolicyMiddleware(NewSimpleMiddleware(m))
+}
+
+var _ SimpleMiddlewareInterface = (*simpleAuthPolicyMiddleware)(nil)
+
+type simpleAuthPolicyMiddleware struct {
+	reqKinds   map[int64]bool
+	eventKinds map[int64]bool
+}
+
+func newSimpleAuthPolicyMiddleware(option *AuthPolicyOption) *simpleAuthPolicyMiddleware {
+	ret := &simpleAuthPolicyMiddleware{
+		reqKinds:   make(map[int64]bool),
+		eventKinds: make(map[int64]bool),
+	}
+	if option != nil {
+		for _, kind := range option.ReqKinds {
+			ret.reqKinds[kind] = true
+		}
+		for _, kind := range option.EventKinds {
+			ret.eventKinds[kind] = true
+		}
+	}
+	return ret
+}
+
+func (m *simpleAuthPolicyMiddleware) HandleStart(r *http.Request) (*http.Request, error) {
+	return r, nil
+}
+
+func (m *simpleAuthPolicyMiddleware) HandleStop(r *http.Request) error {
+	return nil
+}
+
+func (m *simpleAuthPolicyMiddleware) HandleClientMsg(
+	r *http.Request,
+	msg ClientMsg,
+) (<-chan ClientMsg, <-chan ServerMsg, error) {
+	pubkey := GetSession(r.Context()).Pubkey()
+
+	var subID string
+	var filters []*ReqFilter
+
+	switch msg := msg.(type) {
+	case *ClientEventMsg:
+		kind := msg.Event.Kind
+		if !m.eventKinds[kind] || pubkey != "" {
+			return newClosedBufCh[ClientMsg](msg), nil, nil
+		}
+		okMsg := NewServerOKMsg(
+			msg.Event.ID,
+			false,
+			ServerOkMsgPrefixAuthRequired,
+			fmt.Sprintf("kind %d needs authentication", kind),
+		)
+		return nil, newClosedBufCh[ServerMsg](okMsg), nil
+
+	case *ClientReqMsg:
+		subID, filters = msg.SubscriptionID, msg.ReqFilters
+	case *ClientCountMsg:
+		subID, filters = msg.SubscriptionID, msg.ReqFilters
+	default:
+		return newClosedBufCh(msg), nil, nil
+	}
+
+	for _, f := range filters {
+		kind, ok := m.protectedKind(f)
+		if !ok {
+			continue
+		}
+
+		var closedMsg *ServerClosedMsg
+		if pubkey == "" {
+			closedMsg = NewServerClosedMsg(
+				subID,
+				ServerClosedMsgPrefixAuthRequired,
+				fmt.Sprintf("kind %d needs authentication", kind),
+			)
+		} else if !limitedToPubkey(f, pubkey) {
+			closedMsg = NewServerClosedMsg(
+				subID,
+				ServerClosedMsgPrefixRestricted,
+				fmt.Sprintf("kind %d can only be read by its participants", kind),
+			)
+		}
+		if closedMsg != nil {
+			return nil, newClosedBufCh[ServerMsg](closedMsg), nil
+		}
+	}
+
+	return newClosedBufCh(msg), nil, nil
+}
+
+// protectedKind returns the first kind of f in ReqKinds.
+func (m *simpleAuthPolicyMiddleware) protectedKind(f *ReqFilter) (int64, bool) {
+	for _, kind := range f.Kinds {
+		if m.reqKinds[kind] {
+			return kind, true
+		}
+	}
+	return 0, false
+}
+
+// limitedToPubkey reports whether f only matches events authored by
+// or p-tagging pubkey.
+func limitedToPubkey(f *ReqFilter, pubkey string) bool {
+	only := func(vals []string) bool {
+		return len(vals) > 0 &&
+			!slices.ContainsFunc(vals, func(v string) bool { return v != pubkey })
+	}
+	return only(f.Authors) || only(f.Tags["#p"])
+}
+
+func (m *simpleAuthPolicyMiddleware) HandleServerMsg(
+	r *http.Request,
+	msg ServerMsg,
+) (<-chan ServerMsg, error) {
+	if msg, ok := msg.(*ServerEventMsg); ok && m.reqKinds[msg.Event.Kind] {
+		if !participates(msg.Event, GetSession(r.Context()).Pubkey()) {
+			return nil, nil
+		}
+	}
+
+	return newClosedBufCh(msg), nil
+}
+
+// participates reports whether pubkey is the author of event or p-tagged by it.
+func participates(event *Event, pubkey string) bool {
+	if pubkey == "" {
+		return false
+	}
+	if event.Pubkey == pubkey {
+		return true
+	}
+	for _, tag := range event.Tags {
+		if len(tag) >= 2 && tag[0] == "p" && tag[1] == pubkey {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,149 @@
+package mocrelay
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newAuthPolicyTestRequest(pubkey string) *http.Request {
+	sess := &Session{}
+	sess.SetPubkey(pubkey)
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	return r.WithContext(ctxWithSession(r.Context(), sess))
+}
+
+func TestSimpleAuthPolicyMiddleware_HandleClientMsg(t *testing.T) {
+	alice := "alice"
+
+	tests := []struct {
+		name   string
+		pubkey string
+		msg    ClientMsg
+		want   ServerMsg
+	}{
+		{
+			name: "event: auth required",
+			msg:  &ClientEventMsg{Event: &Event{ID: "id", Kind: 1059}},
+			want: NewServerOKMsg(
+				"id",
+				false,
+				ServerOkMsgPrefixAuthRequired,
+				"kind 1059 needs authentication",
+			),
+		},
+		{
+			name:   "event: authenticated",
+			pubkey: alice,
+			msg:    &ClientEventMsg{Event: &Event{ID: "id", Kind: 1059}},
+		},
+		{
+			name: "event: other kind",
+			msg:  &ClientEventMsg{Event: &Event{ID: "id", Kind: 1}},
+		},
+		{
+			name: "req: auth required",
+			msg: &ClientReqMsg{
+				SubscriptionID: "sub",
+				ReqFilters:     []*ReqFilter{{Kinds: []int64{1}}, {Kinds: []int64{1, 4}}},
+			},
+			want: NewServerClosedMsg(
+				"sub",
+				ServerClosedMsgPrefixAuthRequired,
+				"kind 4 needs authentication",
+			),
+		},
+		{
+			name:   "req: not a participant",
+			pubkey: alice,
+			msg: &ClientReqMsg{
+				SubscriptionID: "sub",
+				ReqFilters:     []*ReqFilter{{Kinds: []int64{4}, Authors: []string{alice, "bob"}}},
+			},
+			want: NewServerClosedMsg(
+				"sub",
+				ServerClosedMsgPrefixRestricted,
+				"kind 4 can only be read by its participants",
+			),
+		},
+		{
+			name:   "req: author",
+			pubkey: alice,
+			msg: &ClientReqMsg{
+				SubscriptionID: "sub",
+				ReqFilters:     []*ReqFilter{{Kinds: []int64{4}, Authors: []string{alice}}},
+			},
+		},
+		{
+			name:   "count: tagged",
+			pubkey: alice,
+			msg: &ClientCountMsg{
+				SubscriptionID: "sub",
+				ReqFilters: []*ReqFilter{
+					{Kinds: []int64{4}, Tags: map[string][]string{"#p": {alice}}},
+				},
+			},
+		},
+		{
+			name: "req: other kinds",
+			msg: &ClientReqMsg{
+				SubscriptionID: "sub",
+				ReqFilters:     []*ReqFilter{{Kinds: []int64{1}}, {}},
+			},
+		},
+	}
+
+	m := newSimpleAuthPolicyMiddleware(&AuthPolicyOption{
+		ReqKinds:   []int64{4, 1059},
+		EventKinds: []int64{1059},
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmsgCh, smsgCh, err := m.HandleClientMsg(newAuthPolicyTestRequest(tt.pubkey), tt.msg)
+			assert.NoError(t, err)
+
+			if tt.want != nil {
+				assert.Nil(t, cmsgCh)
+				assert.Equal(t, tt.want, <-smsgCh)
+			} else {
+				assert.Nil(t, smsgCh)
+				assert.Equal(t, tt.msg, <-cmsgCh)
+			}
+		})
+	}
+}
+
+func TestSimpleAuthPolicyMiddleware_HandleServerMsg(t *testing.T) {
+	m := newSimpleAuthPolicyMiddleware(&AuthPolicyOption{ReqKinds: []int64{1059}})
+	wrap := &Event{Pubkey: "random", Kind: 1059, Tags: []Tag{{"p", "alice"}}}
+	note := &Event{Pubkey: "random", Kind: 1}
+
+	tests := []struct {
+		name   string
+		pubkey string
+		event  *Event
+		want   bool
+	}{
+		{name: "tagged", pubkey: "alice", event: wrap, want: true},
+		{name: "author", pubkey: "random", event: wrap, want: true},
+		{name: "other", pubkey: "bob", event: wrap, want: false},
+		{name: "unauthenticated", event: wrap, want: false},
+		{name: "other kind", event: note, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := NewServerEventMsg("sub", tt.event)
+			ch, err := m.HandleServerMsg(newAuthPolicyTestRequest(tt.pubkey), msg)
+			assert.NoError(t, err)
+			if tt.want {
+				assert.Equal(t, ServerMsg(msg), <-ch)
+			} else {
+				assert.Nil(t, ch)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package mocrelay
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthChallenges(t *testing.T) {
+	now := time.Unix(0, 0)
+	c := newAuthChallenges(time.Minute)
+	alice := &Session{ID: "alice", RealIP: "192.0.2.1"}
+	bob := &Session{ID: "bob", RealIP: "192.0.2.1"}
+	moved := &Session{ID: "alice", RealIP: "192.0.2.2"}
+
+	challenge := c.issue(alice, now)
+	assert.ErrorIs(t, c.consume(bob, challenge, now), ErrInvalidAuthChallenge)
+	assert.ErrorIs(t, c.consume(moved, challenge, now), ErrInvalidAuthChallenge)
+	assert.NoError(t, c.consume(alice, challenge, now))
+	assert.ErrorContains(t, c.consume(alice, challenge, now), "already used")
+
+	expired := c.issue(alice, now)
+	assert.ErrorContains(
+		t,
+		c.consume(alice, expired, now.Add(time.Minute)),
+		"unknown or expired",
+	)
+	assert.ErrorIs(t, c.consume(alice, "unknown", now), ErrInvalidAuthChallenge)
+
+	assert.Equal(t, 2, c.len())
+	c.issue(alice, now.Add(2*time.Minute))
+	assert.Equal(t, 1, c.len(), "expired challenges are swept")
+
+	c.close(alice)
+	assert.Equal(t, 0, c.len())
+}
+
+func TestAuthChallenges_replace(t *testing.T) {
+	now := time.Unix(0, 0)
+	c := newAuthChallenges(time.Minute)
+	alice := &Session{ID: "alice", RealIP: "192.0.2.1"}
+	bob := &Session{ID: "bob", RealIP: "192.0.2.1"}
+
+	used := c.issue(alice, now)
+	require.NoError(t, c.consume(alice, used, now))
+
+	old := c.issue(alice, now)
+	c.issue(bob, now)
+	latest := c.issue(alice, now)
+	assert.Equal(t, 3, c.len(), "the used and the latest of alice and the one of bob")
+	assert.ErrorContains(t, c.consume(alice, old, now), "unknown or expired")
+	assert.ErrorContains(t, c.consume(alice, used, now), "already used")
+	assert.NoError(t, c.consume(alice, latest, now))
+}
+
+func TestSimpleAuthMiddleware(t *testing.T) {
+	const relayURL = "wss://relay.example.com"
+
+	challenges := newAuthChallenges(time.Minute)
+	newRequest := func(id string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		sess := &Session{ID: id, RealIP: "192.0.2.1", RelayURL: relayURL}
+		return r.WithContext(ctxWithSession(r.Context(), sess))
+	}
+	newAuthMsg := func(challenge string) *ClientAuthMsg {
+		return &ClientAuthMsg{Event: signTestEvent(t, &Event{
+			CreatedAt: time.Now().Unix(),
+			Kind:      AuthEventKind,
+			Tags:      []Tag{{"relay", relayURL}, {"challenge", challenge}},
+		})}
+	}
+	recvAll := func(ch <-chan ServerMsg) []ServerMsg {
+		var ret []ServerMsg
+		for msg := range ch {
+			ret = append(ret, msg)
+		}
+		return ret
+	}
+
+	r := newRequest("alice")
+	m := newSimpleAuthMiddleware(&AuthOption{Reauth: time.Hour}, challenges)
+	challenge := m.challenge(r, time.Now()).(*ServerAuthChallengeMsg).Challenge
+
+	auth := newAuthMsg(challenge)
+	cmsgCh, smsgCh, err := m.HandleClientMsg(r, auth)
+	require.NoError(t, err)
+	assert.Nil(t, cmsgCh)
+	assert.Equal(
+		t,
+		[]ServerMsg{NewServerOKMsg(auth.Event.ID, true, ServerOKMsgPrefixNoPrefix, "")},
+		recvAll(smsgCh),
+	)
+	assert.Equal(t, auth.Event.Pubkey, GetSession(r.Context()).Pubkey())
+
+	// The AUTH event is replayed on another connection.
+	other := newRequest("bob")
+	_, smsgCh, err = newSimpleAuthMiddleware(nil, challenges).HandleClientMsg(other, auth)
+	require.NoError(t, err)
+	msgs := recvAll(smsgCh)
+	require.Len(t, msgs, 2)
+	assert.False(t, msgs[0].(*ServerOKMsg).Accepted)
+	assert.IsType(t, &ServerAuthChallengeMsg{}, msgs[1])
+	assert.Empty(t, GetSession(other.Context()).Pubkey())
+
+	// The connection is challenged again after Reauth.
+	m.authedAt = time.Now().Add(-time.Hour)
+	req := &ClientReqMsg{SubscriptionID: "sub"}
+	cmsgCh, smsgCh, err = m.HandleClientMsg(r, req)
+	require.NoError(t, err)
+	assert.Equal(t, ClientMsg(req), <-cmsgCh)
+	msgs = recvAll(smsgCh)
+	require.Len(t, msgs, 1)
+	assert.NotEqual(t, challenge, msgs[0].(*ServerAuthChallengeMsg).Challenge)
+	assert.Empty(t, GetSession(r.Context()).Pubkey())
+
+	// Repeated failed AUTHs do not pile up challenges.
+	n := challenges.len()
+	for i := 0; i < 10; i++ {
+		_, smsgCh, err = m.HandleClientMsg(r, newAuthMsg("wrong"))
+		require.NoError(t, err)
+		assert.Len(t, recvAll(smsgCh), 2)
+	}
+	assert.Equal(t, n, challenges.len())
+
+	require.NoError(t, m.HandleStop(r))
+	assert.Equal(t, n-1, challenges.len())
+}
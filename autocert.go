@@ -0,0 +1,85 @@
+package mocrelay
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+var ErrAutocertNoHosts = errors.New("autocert needs at least one host")
+
+type AutocertOption struct {
+	// Hosts is the allowlist of hostnames to get certificates for.
+	Hosts []string
+
+	// CacheDir is the directory certificates are stored in.
+	// If empty, certificates are only kept in memory.
+	CacheDir string
+
+	// Email is the contact address sent to the CA. Optional.
+	Email string
+
+	// HTTPAddr is the address to serve ACME HTTP-01 challenges and
+	// redirects to https on. Default is ":80". "-" disables it.
+	HTTPAddr string
+}
+
+func (opt *AutocertOption) httpAddr() string {
+	if opt.HTTPAddr == "" {
+		return ":80"
+	}
+	return opt.HTTPAddr
+}
+
+// NewAutocertManager creates a Let's Encrypt certificate manager from opt.
+func NewAutocertManager(opt *AutocertOption) (*autocert.Manager, error) {
+	if opt == nil || len(opt.Hosts) == 0 {
+		return nil, ErrAutocertNoHosts
+	}
+
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(opt.Hosts...),
+		Email:      opt.Email,
+	}
+	if opt.CacheDir != "" {
+		m.Cache = autocert.DirCache(opt.CacheDir)
+	}
+
+	return m, nil
+}
+
+// ListenAndServeAutocert serves srv with TLS certificates from Let's Encrypt.
+// srv.Addr defaults to ":443".
+// Like http.Server.ListenAndServeTLS, it returns http.ErrServerClosed after srv is shut down.
+func ListenAndServeAutocert(srv *http.Server, opt *AutocertOption) error {
+	m, err := NewAutocertManager(opt)
+	if err != nil {
+		return err
+	}
+
+	srv.TLSConfig = m.TLSConfig()
+	if srv.Addr == "" {
+		srv.Addr = ":443"
+	}
+
+	if addr := opt.httpAddr(); addr != "-" {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return fmt.Errorf("failed to listen for acme challenges: %w", err)
+		}
+
+		challenge := &http.Server{
+			Handler:           m.HTTPHandler(nil),
+			ReadHeaderTimeout: srv.ReadHeaderTimeout,
+			ErrorLog:          srv.ErrorLog,
+		}
+		go challenge.Serve(ln)
+		defer challenge.Close()
+	}
+
+	return srv.ListenAndServeTLS("", "")
+}
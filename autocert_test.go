@@ -0,0 +1,35 @@
+package mocrelay
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func TestNewAutocertManager(t *testing.T) {
+	_, err := NewAutocertManager(nil)
+	assert.ErrorIs(t, err, ErrAutocertNoHosts)
+
+	_, err = NewAutocertManager(&AutocertOption{})
+	assert.ErrorIs(t, err, ErrAutocertNoHosts)
+
+	dir := t.TempDir()
+	m, err := NewAutocertManager(&AutocertOption{
+		Hosts:    []string{"relay.example.com"},
+		CacheDir: dir,
+		Email:    "admin@example.com",
+	})
+	require.NoError(t, err)
+
+	assert.NoError(t, m.HostPolicy(context.Background(), "relay.example.com"))
+	assert.Error(t, m.HostPolicy(context.Background(), "evil.example.com"))
+	assert.Equal(t, autocert.DirCache(dir), m.Cache)
+	assert.Equal(t, "admin@example.com", m.Email)
+
+	m, err = NewAutocertManager(&AutocertOption{Hosts: []string{"relay.example.com"}})
+	require.NoError(t, err)
+	assert.Nil(t, m.Cache)
+}
@@ -0,0 +1,352 @@
+package mocrelay
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+var ErrBanned = errors.New("banned")
+
+// Offenses counted by BanList.
+const (
+	StrikeProtocol  = "protocol"
+	StrikeInvalid   = "invalid_event"
+	StrikeRateLimit = "rate_limit"
+)
+
+// Ban targets.
+const (
+	BanTargetIP     = "ip"
+	BanTargetPubkey = "pubkey"
+)
+
+type BanListOption struct {
+	// MaxStrikes is the number of strikes in StrikeWindow which bans the target.
+	// The default is 10.
+	MaxStrikes int
+	// StrikeWindow is the default 10 minutes.
+	StrikeWindow time.Duration
+	// StrikeWeights are the number of strikes each offense counts as. The default is 1.
+	StrikeWeights map[string]int
+
+	// BanDuration is the duration of the first ban. It doubles on each repeat
+	// offense up to MaxBanDuration. The defaults are 10 minutes and 7 days.
+	BanDuration    time.Duration
+	MaxBanDuration time.Duration
+
+	// Path is the JSON file bans are persisted to. Empty disables persistence.
+	Path string
+}
+
+func (opt *BanListOption) maxStrikes() int {
+	if opt == nil || opt.MaxStrikes == 0 {
+		return 10
+	}
+	return opt.MaxStrikes
+}
+
+func (opt *BanListOption) strikeWindow() time.Duration {
+	if opt == nil || opt.StrikeWindow == 0 {
+		return 10 * time.Minute
+	}
+	return opt.StrikeWindow
+}
+
+func (opt *BanListOption) strikeWeight(offense string) int {
+	if opt == nil {
+		return 1
+	}
+	if w, ok := opt.StrikeWeights[offense]; ok {
+		return w
+	}
+	return 1
+}
+
+func (opt *BanListOption) banDuration() time.Duration {
+	if opt == nil || opt.BanDuration == 0 {
+		return 10 * time.Minute
+	}
+	return opt.BanDuration
+}
+
+func (opt *BanListOption) maxBanDuration() time.Duration {
+	if opt == nil || opt.MaxBanDuration == 0 {
+		return 7 * 24 * time.Hour
+	}
+	return opt.MaxBanDuration
+}
+
+func (opt *BanListOption) path() string {
+	if opt == nil {
+		return ""
+	}
+	return opt.Path
+}
+
+type BanEntry struct {
+	Target string    `json:"target"`
+	Value  string    `json:"value"`
+	Until  time.Time `json:"until"`
+	// Offenses is the number of times the target has been banned.
+	Offenses int `json:"offenses"`
+}
+
+// BanList counts strikes per IP and pubkey and bans them temporarily
+// with escalating durations.
+type BanList struct {
+	opt *BanListOption
+
+	mu sync.Mutex
+	// map[target:value]entry
+	entries map[string]*banEntry
+	// forgets schedules when entries are forgotten.
+	forgets *expiryQueue[string]
+	err     error
+}
+
+type banEntry struct {
+	BanEntry
+	strikes []time.Time
+}
+
+// NewBanList returns a BanList with the bans loaded from option.Path.
+func NewBanList(option *BanListOption) (*BanList, error) {
+	b := &BanList{
+		opt:     option,
+		entries: make(map[string]*banEntry),
+		forgets: newExpiryQueue[string](),
+	}
+	if err := b.load(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func banKey(target, value string) string { return target + ":" + value }
+
+func (b *BanList) load() error {
+	path := b.opt.path()
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read ban list: %w", err)
+	}
+
+	var entries []BanEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("failed to parse ban list: %w", err)
+	}
+	for _, e := range entries {
+		key := banKey(e.Target, e.Value)
+		b.entries[key] = &banEntry{BanEntry: e}
+		b.scheduleForget(key)
+	}
+	return nil
+}
+
+// save writes the bans to the file. b.mu must be locked.
+func (b *BanList) save(now time.Time) {
+	path := b.opt.path()
+	if path == "" {
+		return
+	}
+
+	data, err := json.Marshal(b.bans(now))
+	if err != nil {
+		b.err = err
+		return
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		b.err = err
+		return
+	}
+	_, err = tmp.Write(data)
+	err = errors.Join(err, tmp.Close())
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	b.err = err
+}
+
+// Err returns the last error of persisting bans.
+func (b *BanList) Err() error {
+	if b == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.err
+}
+
+// Strike records an offense of the target and returns true if it is banned.
+func (b *BanList) Strike(target, value, offense string) bool {
+	if b == nil || value == "" {
+		return false
+	}
+
+	now := time.Now()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.prune(now)
+
+	key := banKey(target, value)
+	e, ok := b.entries[key]
+	if !ok {
+		e = &banEntry{BanEntry: BanEntry{Target: target, Value: value}}
+		b.entries[key] = e
+	}
+	if now.Before(e.Until) {
+		return true
+	}
+
+	window := now.Add(-b.opt.strikeWindow())
+	idx := slices.IndexFunc(e.strikes, func(t time.Time) bool { return t.After(window) })
+	if idx < 0 {
+		e.strikes = e.strikes[:0]
+	} else {
+		e.strikes = e.strikes[idx:]
+	}
+	for i := 0; i < b.opt.strikeWeight(offense); i++ {
+		e.strikes = append(e.strikes, now)
+	}
+	b.scheduleForget(key)
+
+	if len(e.strikes) < b.opt.maxStrikes() {
+		return false
+	}
+
+	b.ban(e, now, b.escalate(e.Offenses))
+	return true
+}
+
+// prune forgets entries without recent strikes or bans. b.mu must be locked.
+func (b *BanList) prune(now time.Time) {
+	for _, key := range b.forgets.PopExpired(now) {
+		delete(b.entries, key)
+	}
+}
+
+// scheduleForget schedules forgetting the entry of key when both its last strike
+// leaves the strike window and its ban is older than MaxBanDuration. b.mu must be locked.
+func (b *BanList) scheduleForget(key string) {
+	e := b.entries[key]
+
+	var at time.Time
+	if e.Offenses > 0 {
+		at = e.Until.Add(b.opt.maxBanDuration())
+	}
+	if len(e.strikes) > 0 {
+		if last := e.strikes[len(e.strikes)-1].Add(b.opt.strikeWindow()); last.After(at) {
+			at = last
+		}
+	}
+	b.forgets.Set(key, at)
+}
+
+func (b *BanList) escalate(offenses int) time.Duration {
+	d := b.opt.banDuration()
+	for i := 0; i < offenses && d < b.opt.maxBanDuration(); i++ {
+		d *= 2
+	}
+	return min(d, b.opt.maxBanDuration())
+}
+
+// ban bans e for d. b.mu must be locked.
+func (b *BanList) ban(e *banEntry, now time.Time, d time.Duration) {
+	e.Until = now.Add(d)
+	e.Offenses++
+	e.strikes = nil
+	b.scheduleForget(banKey(e.Target, e.Value))
+	b.save(now)
+}
+
+// Ban bans the target for d regardless of strikes.
+func (b *BanList) Ban(target, value string, d time.Duration) {
+	now := time.Now()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	key := banKey(target, value)
+	e, ok := b.entries[key]
+	if !ok {
+		e = &banEntry{BanEntry: BanEntry{Target: target, Value: value}}
+		b.entries[key] = e
+	}
+	b.ban(e, now, d)
+}
+
+// Unban lifts the ban of the target and forgets its offenses.
+func (b *BanList) Unban(target, value string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	key := banKey(target, value)
+	delete(b.entries, key)
+	b.forgets.Delete(key)
+	b.save(time.Now())
+}
+
+// Banned returns true if the target is banned.
+func (b *BanList) Banned(target, value string) bool {
+	if b == nil || value == "" {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	e, ok := b.entries[banKey(target, value)]
+	return ok && time.Now().Before(e.Until)
+}
+
+// Bans returns the targets which have been banned.
+// Expired bans are kept to escalate repeat offenses until MaxBanDuration passes.
+func (b *BanList) Bans() []BanEntry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.bans(time.Now())
+}
+
+// bans returns the banned entries. b.mu must be locked.
+func (b *BanList) bans(now time.Time) []BanEntry {
+	b.prune(now)
+
+	var ret []BanEntry
+	for _, e := range b.entries {
+		if e.Offenses > 0 {
+			ret = append(ret, e.BanEntry)
+		}
+	}
+
+	slices.SortFunc(ret, func(a, b BanEntry) int {
+		if c := cmp.Compare(a.Target, b.Target); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Value, b.Value)
+	})
+	return ret
+}
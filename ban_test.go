@@ -0,0 +1,129 @@
+package mocrelay
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBanList_Strike(t *testing.T) {
+	b, err := NewBanList(&BanListOption{
+		MaxStrikes:    3,
+		StrikeWeights: map[string]int{StrikeInvalid: 2},
+	})
+	require.NoError(t, err)
+
+	assert.False(t, b.Strike(BanTargetIP, "192.0.2.1", StrikeProtocol))
+	assert.False(t, b.Strike(BanTargetIP, "192.0.2.1", StrikeRateLimit))
+	assert.False(t, b.Banned(BanTargetIP, "192.0.2.1"))
+	assert.True(t, b.Strike(BanTargetIP, "192.0.2.1", StrikeProtocol))
+	assert.True(t, b.Banned(BanTargetIP, "192.0.2.1"))
+	assert.False(t, b.Banned(BanTargetPubkey, "192.0.2.1"))
+
+	assert.False(t, b.Strike(BanTargetPubkey, "pubkey", StrikeRateLimit))
+	assert.True(t, b.Strike(BanTargetPubkey, "pubkey", StrikeInvalid))
+
+	assert.False(t, b.Strike(BanTargetPubkey, "", StrikeInvalid))
+	assert.False(t, (*BanList)(nil).Strike(BanTargetIP, "192.0.2.1", StrikeInvalid))
+
+	bans := b.Bans()
+	if assert.Len(t, bans, 2) {
+		assert.Equal(t, BanTargetIP, bans[0].Target)
+		assert.Equal(t, "192.0.2.1", bans[0].Value)
+		assert.Equal(t, 1, bans[0].Offenses)
+		assert.WithinDuration(t, time.Now().Add(10*time.Minute), bans[0].Until, time.Minute)
+		assert.Equal(t, BanTargetPubkey, bans[1].Target)
+	}
+
+	b.Unban(BanTargetIP, "192.0.2.1")
+	assert.False(t, b.Banned(BanTargetIP, "192.0.2.1"))
+}
+
+func TestBanList_escalate(t *testing.T) {
+	b, err := NewBanList(&BanListOption{
+		MaxStrikes:     1,
+		BanDuration:    20 * time.Millisecond,
+		MaxBanDuration: time.Hour,
+	})
+	require.NoError(t, err)
+
+	assert.True(t, b.Strike(BanTargetIP, "192.0.2.1", StrikeProtocol))
+	assert.Eventually(
+		t,
+		func() bool { return !b.Banned(BanTargetIP, "192.0.2.1") },
+		time.Second,
+		time.Millisecond,
+	)
+
+	start := time.Now()
+	assert.True(t, b.Strike(BanTargetIP, "192.0.2.1", StrikeProtocol))
+	bans := b.Bans()
+	if assert.Len(t, bans, 1) {
+		assert.Equal(t, 2, bans[0].Offenses)
+		assert.WithinDuration(t, start.Add(40*time.Millisecond), bans[0].Until, 10*time.Millisecond)
+	}
+
+	assert.Equal(t, 20*time.Millisecond, b.escalate(0))
+	assert.Equal(t, 80*time.Millisecond, b.escalate(2))
+	assert.Equal(t, time.Hour, b.escalate(100))
+}
+
+func TestBanList_prune(t *testing.T) {
+	b, err := NewBanList(&BanListOption{
+		MaxStrikes:     2,
+		StrikeWindow:   time.Minute,
+		BanDuration:    time.Hour,
+		MaxBanDuration: 2 * time.Hour,
+	})
+	require.NoError(t, err)
+
+	now := time.Now()
+	b.Strike(BanTargetIP, "192.0.2.1", StrikeProtocol)
+	b.Strike(BanTargetIP, "192.0.2.2", StrikeProtocol)
+	b.Strike(BanTargetIP, "192.0.2.2", StrikeProtocol)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.prune(now.Add(30 * time.Second))
+	assert.Len(t, b.entries, 2)
+
+	b.prune(now.Add(2 * time.Minute))
+	assert.Len(t, b.entries, 1, "strikes left the window")
+
+	b.prune(now.Add(3*time.Hour - time.Minute))
+	assert.Len(t, b.entries, 1, "ban is kept for MaxBanDuration after it ends")
+
+	b.prune(now.Add(3*time.Hour + time.Minute))
+	assert.Empty(t, b.entries)
+	assert.Equal(t, 0, b.forgets.Len())
+}
+
+func TestBanList_persist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bans.json")
+
+	b, err := NewBanList(&BanListOption{MaxStrikes: 1, Path: path})
+	require.NoError(t, err)
+
+	b.Strike(BanTargetIP, "192.0.2.1", StrikeProtocol)
+	b.Ban(BanTargetPubkey, "pubkey", time.Hour)
+	require.NoError(t, b.Err())
+
+	b, err = NewBanList(&BanListOption{MaxStrikes: 1, Path: path})
+	require.NoError(t, err)
+	assert.True(t, b.Banned(BanTargetIP, "192.0.2.1"))
+	assert.True(t, b.Banned(BanTargetPubkey, "pubkey"))
+
+	b.Unban(BanTargetPubkey, "pubkey")
+	b, err = NewBanList(&BanListOption{Path: path})
+	require.NoError(t, err)
+	assert.False(t, b.Banned(BanTargetPubkey, "pubkey"))
+
+	require.NoError(t, os.WriteFile(path, []byte("powa"), 0o600))
+	_, err = NewBanList(&BanListOption{Path: path})
+	assert.Error(t, err)
+}
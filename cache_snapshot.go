@@ -0,0 +1,117 @@
+package mocrelay
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// maxSnapshotEventSize is the max length of an event in a snapshot.
+const maxSnapshotEventSize = 16 << 20
+
+// WriteSnapshot writes the cached events to w, oldest first.
+// Each event is its raw JSON prefixed with the uvarint length.
+func (h *CacheHandler) WriteSnapshot(w io.Writer) error {
+	events := h.c.c.Events()
+
+	bw := bufio.NewWriter(w)
+	var buf []byte
+	for i := len(events) - 1; i >= 0; i-- {
+		raw, err := events[i].MarshalRaw()
+		if err != nil {
+			return fmt.Errorf("failed to marshal event: %w", err)
+		}
+		buf = binary.AppendUvarint(buf[:0], uint64(len(raw)))
+		buf = append(buf, raw...)
+
+		if _, err := bw.Write(buf); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// ReadSnapshot adds the events written by WriteSnapshot from r to the cache
+// and returns the number of them kept in the cache.
+func (h *CacheHandler) ReadSnapshot(r io.Reader) (int, error) {
+	br := bufio.NewReader(r)
+
+	var events []*Event
+	for {
+		l, err := binary.ReadUvarint(br)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to read snapshot: %w", err)
+		}
+		if l > maxSnapshotEventSize {
+			return 0, fmt.Errorf("too large event in snapshot: %d bytes", l)
+		}
+
+		b := make([]byte, l)
+		if _, err := io.ReadFull(br, b); err != nil {
+			return 0, fmt.Errorf("failed to read snapshot: %w", err)
+		}
+
+		var ev Event
+		if err := json.Unmarshal(b, &ev); err != nil {
+			return 0, fmt.Errorf("failed to parse event in snapshot: %w", err)
+		}
+		h.c.interner.Event(&ev)
+		events = append(events, &ev)
+	}
+
+	for _, ev := range events {
+		h.c.c.Add(ev)
+	}
+	// Older events can be evicted by newer ones in a small cache.
+	var n int
+	for _, ev := range events {
+		if h.c.c.Has(ev) {
+			n++
+		}
+	}
+	if h.c.counts != nil {
+		h.c.counts.Clear()
+	}
+	return n, nil
+}
+
+// SaveSnapshot writes the snapshot of the cache to the file at path atomically.
+func (h *CacheHandler) SaveSnapshot(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot: %w", err)
+	}
+	err = h.WriteSnapshot(tmp)
+	err = errors.Join(err, tmp.Close())
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to save snapshot: %w", err)
+	}
+	return nil
+}
+
+// LoadSnapshot adds the events in the snapshot file at path to the cache
+// and returns the number of them. It does nothing if the file does not exist.
+func (h *CacheHandler) LoadSnapshot(path string) (int, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer f.Close()
+
+	return h.ReadSnapshot(f)
+}
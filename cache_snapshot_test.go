@@ -0,0 +1,62 @@
+package mocrelay
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheHandler_Snapshot(t *testing.T) {
+	newEvent := func(id string, createdAt int64) *Event {
+		return &Event{ID: id, Pubkey: "pub", Kind: 1, CreatedAt: createdAt, Tags: []Tag{}}
+	}
+	events := []*Event{newEvent("id0", 0), newEvent("id1", 1), newEvent("id2", 2)}
+
+	src := NewCacheHandler(10)
+	for _, ev := range events {
+		src.c.c.Add(ev)
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, src.WriteSnapshot(&buf))
+
+	dst := NewCacheHandler(2)
+	n, err := dst.ReadSnapshot(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	got := dst.c.c.Events()
+	if assert.Len(t, got, 2) {
+		assert.EqualExportedValues(t, *events[2], *got[0])
+		assert.EqualExportedValues(t, *events[1], *got[1])
+	}
+
+	_, err = dst.ReadSnapshot(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+	assert.Error(t, err)
+}
+
+func TestCacheHandler_SaveSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.snapshot")
+
+	h := NewCacheHandler(10)
+	n, err := h.LoadSnapshot(path)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	h.c.c.Add(&Event{ID: "id0", Pubkey: "pub", Kind: 1, Tags: []Tag{}})
+	require.NoError(t, h.SaveSnapshot(path))
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "no temporary files are left")
+
+	h2 := NewCacheHandler(10)
+	n, err = h2.LoadSnapshot(path)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, "id0", h2.c.c.Events()[0].ID)
+}
@@ -0,0 +1,237 @@
+package mocrelay
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+type CachedStoreOption struct {
+	// MaxEntries is the max number of cached REQ results. The default is 1024.
+	MaxEntries int
+	// MaxEvents is the max number of events in a cached result.
+	// Larger results are not cached. The default is 500.
+	MaxEvents int
+	// TTL is how long results are cached. The default is 1 minute.
+	TTL time.Duration
+	// IDMatchMode is how the ids and authors of cached filters match saved events
+	// to invalidate results. It should be the same as the one of the store.
+	IDMatchMode IDMatchMode
+}
+
+func (opt *CachedStoreOption) maxEntries() int {
+	if opt == nil || opt.MaxEntries == 0 {
+		return 1024
+	}
+	return opt.MaxEntries
+}
+
+func (opt *CachedStoreOption) maxEvents() int {
+	if opt == nil || opt.MaxEvents == 0 {
+		return 500
+	}
+	return opt.MaxEvents
+}
+
+func (opt *CachedStoreOption) ttl() time.Duration {
+	if opt == nil || opt.TTL == 0 {
+		return time.Minute
+	}
+	return opt.TTL
+}
+
+func (opt *CachedStoreOption) idMatchMode() IDMatchMode {
+	if opt == nil {
+		return IDMatchExact
+	}
+	return opt.IDMatchMode
+}
+
+var _ EventStore = (*CachedStore)(nil)
+
+// CachedStore is an EventStore which caches Query and Count results of another EventStore
+// in memory. Results are populated on misses and invalidated by saved events which
+// can change them, and by deletions. Writes by other processes are seen after TTL.
+type CachedStore struct {
+	store     EventStore
+	opt       *CachedStoreOption
+	maxEvents int
+	queries   *queryCache
+	counts    *countCache
+
+	// namespaces are shared by the stores of all namespaces.
+	namespaces *cachedStoreNamespaces
+}
+
+type cachedStoreNamespaces struct {
+	mu sync.Mutex
+	m  map[string]*CachedStore
+}
+
+func NewCachedStore(store EventStore, option *CachedStoreOption) *CachedStore {
+	if store == nil {
+		panic("store must be non-nil")
+	}
+	s := newCachedStore(store, option)
+	s.namespaces = &cachedStoreNamespaces{m: map[string]*CachedStore{"": s}}
+	return s
+}
+
+func newCachedStore(store EventStore, option *CachedStoreOption) *CachedStore {
+	s := &CachedStore{
+		store:     store,
+		opt:       option,
+		maxEvents: option.maxEvents(),
+		queries:   newQueryCache(option.maxEntries(), option.ttl()),
+		counts:    newCountCache(option.maxEntries(), option.ttl()),
+	}
+	s.queries.idMatchMode = option.idMatchMode()
+	s.counts.idMatchMode = option.idMatchMode()
+	return s
+}
+
+// Namespace returns the CachedStore of ns. The stores of the same namespace share their caches.
+func (s *CachedStore) Namespace(ns string) EventStore {
+	s.namespaces.mu.Lock()
+	defer s.namespaces.mu.Unlock()
+
+	ret, ok := s.namespaces.m[ns]
+	if !ok {
+		ret = newCachedStore(s.store.Namespace(ns), s.opt)
+		ret.namespaces = s.namespaces
+		s.namespaces.m[ns] = ret
+	}
+	return ret
+}
+
+func (s *CachedStore) Save(ctx context.Context, event *Event) (bool, error) {
+	saved, err := s.store.Save(ctx, event)
+	if saved {
+		s.invalidate(event)
+	}
+	return saved, err
+}
+
+func (s *CachedStore) Delete(ctx context.Context, deletion *Event) error {
+	// Invalidate even on errors since some events may be deleted.
+	defer s.clear()
+	return s.store.Delete(ctx, deletion)
+}
+
+func (s *CachedStore) PurgePubkey(ctx context.Context, pubkey string) (int, error) {
+	defer s.clear()
+	return s.store.PurgePubkey(ctx, pubkey)
+}
+
+func (s *CachedStore) Query(ctx context.Context, filters []*ReqFilter) ([]*Event, error) {
+	key := countCacheKey(filters)
+	evs, gen, ok := s.queries.Get(key)
+	if ok {
+		return slices.Clone(evs), nil
+	}
+
+	evs, err := s.store.Query(ctx, filters)
+	if err != nil {
+		return evs, err
+	}
+	if len(evs) <= s.maxEvents {
+		s.queries.Set(key, filters, slices.Clone(evs), gen)
+	}
+	return evs, nil
+}
+
+func (s *CachedStore) Count(ctx context.Context, filters []*ReqFilter) (uint64, error) {
+	key := countCacheKey(filters)
+	n, gen, ok := s.counts.Get(key)
+	if ok {
+		return n, nil
+	}
+
+	n, err := s.store.Count(ctx, filters)
+	if err != nil {
+		return n, err
+	}
+	s.counts.Set(key, filters, n, gen)
+	return n, nil
+}
+
+func (s *CachedStore) invalidate(event *Event) {
+	if event.Kind == 5 || event.EventType() != EventTypeRegular {
+		// Deleted or replaced events can match any filter.
+		s.clear()
+		return
+	}
+	s.queries.Invalidate(event)
+	s.counts.Invalidate(event)
+}
+
+func (s *CachedStore) clear() {
+	s.queries.Clear()
+	s.counts.Clear()
+}
+
+// queryCache caches query results keyed by countCacheKey.
+type queryCache struct {
+	mu sync.Mutex
+	c  *lruCache[string, *queryCacheEntry]
+	// gen is incremented on every invalidation.
+	gen uint64
+	// idMatchMode is how the ids and authors of filters match events.
+	idMatchMode IDMatchMode
+}
+
+type queryCacheEntry struct {
+	matcher EventMatcher
+	events  []*Event
+}
+
+func newQueryCache(capacity int, ttl time.Duration) *queryCache {
+	c := newLRUCache[string, *queryCacheEntry](capacity)
+	c.TTL = ttl
+	return &queryCache{c: c}
+}
+
+// Get returns the cached events of key.
+// On miss, it returns the generation to be passed to Set.
+func (c *queryCache) Get(key string) (events []*Event, gen uint64, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.c.Get(key)
+	if !ok {
+		return nil, c.gen, false
+	}
+	return e.events, c.gen, true
+}
+
+// Set caches events unless the cache is invalidated after gen.
+func (c *queryCache) Set(key string, filters []*ReqFilter, events []*Event, gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if gen != c.gen {
+		return
+	}
+	c.c.Set(key, &queryCacheEntry{
+		matcher: NewReqFiltersEventMatchersWithMode(filters, c.idMatchMode),
+		events:  events,
+	})
+}
+
+// Invalidate deletes the results which event can change.
+func (c *queryCache) Invalidate(event *Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	c.c.DeleteFunc(func(_ string, e *queryCacheEntry) bool { return e.matcher.Match(event) })
+}
+
+func (c *queryCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	c.c.Clear()
+}
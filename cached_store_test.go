@@ -0,0 +1,143 @@
+package mocrelay
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingEventStore counts Query and Count calls.
+type countingEventStore struct {
+	EventStore
+	queries, counts int
+}
+
+func (s *countingEventStore) Query(ctx context.Context, filters []*ReqFilter) ([]*Event, error) {
+	s.queries++
+	return s.EventStore.Query(ctx, filters)
+}
+
+func (s *countingEventStore) Count(ctx context.Context, filters []*ReqFilter) (uint64, error) {
+	s.counts++
+	return s.EventStore.Count(ctx, filters)
+}
+
+func TestCachedStore(t *testing.T) {
+	ctx := context.Background()
+	kind1 := []*ReqFilter{{Kinds: []int64{1}}}
+	kind7 := []*ReqFilter{{Kinds: []int64{7}}}
+
+	ev1 := &Event{ID: "id1", Pubkey: "pub", CreatedAt: 1, Kind: 1, Tags: []Tag{}}
+	ev2 := &Event{ID: "id2", Pubkey: "pub", CreatedAt: 2, Kind: 1, Tags: []Tag{}}
+	ev3 := &Event{ID: "id3", Pubkey: "pub", CreatedAt: 3, Kind: 7, Tags: []Tag{}}
+
+	inner := &countingEventStore{EventStore: newTestEventStore()}
+	s := NewCachedStore(inner, nil)
+
+	_, err := s.Save(ctx, ev1)
+	assert.NoError(t, err)
+
+	// miss and hit
+	for i := 0; i < 2; i++ {
+		evs, err := s.Query(ctx, kind1)
+		assert.NoError(t, err)
+		assert.Equal(t, []*Event{ev1}, evs)
+		n, err := s.Count(ctx, kind1)
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(1), n)
+	}
+	_, err = s.Query(ctx, kind7)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, inner.queries)
+	assert.Equal(t, 1, inner.counts)
+
+	// ev2 invalidates kind1 only
+	_, err = s.Save(ctx, ev2)
+	assert.NoError(t, err)
+	evs, err := s.Query(ctx, kind1)
+	assert.NoError(t, err)
+	assert.Equal(t, []*Event{ev2, ev1}, evs)
+	_, err = s.Query(ctx, kind7)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, inner.queries)
+
+	// duplicates do not invalidate
+	_, err = s.Save(ctx, ev2)
+	assert.NoError(t, err)
+	_, err = s.Query(ctx, kind1)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, inner.queries)
+
+	// deletion clears all
+	_, err = s.Save(ctx, ev3)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Delete(ctx, &Event{
+		ID:     "del",
+		Pubkey: "pub",
+		Kind:   5,
+		Tags:   []Tag{{"e", "id1"}},
+	}))
+	evs, err = s.Query(ctx, kind1)
+	assert.NoError(t, err)
+	assert.Equal(t, []*Event{ev2}, evs)
+	n, err := s.Count(ctx, kind1)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), n)
+	assert.Equal(t, 4, inner.queries)
+	assert.Equal(t, 2, inner.counts)
+}
+
+func TestCachedStore_Namespace(t *testing.T) {
+	ctx := context.Background()
+	all := []*ReqFilter{{}}
+	ev := &Event{ID: "id", Pubkey: "pub", CreatedAt: 1, Kind: 1, Tags: []Tag{}}
+
+	s := NewCachedStore(newTestEventStore(), nil)
+	a := s.Namespace("a")
+	assert.Same(t, a, s.Namespace("a"))
+	assert.Same(t, s, s.Namespace(""))
+	assert.Same(t, a, s.Namespace("b").Namespace("a"))
+
+	// Cached empty results of s are not served for a and vice versa.
+	evs, err := s.Query(ctx, all)
+	assert.NoError(t, err)
+	assert.Empty(t, evs)
+
+	_, err = a.Save(ctx, ev)
+	assert.NoError(t, err)
+	evs, err = a.Query(ctx, all)
+	assert.NoError(t, err)
+	assert.Equal(t, []*Event{ev}, evs)
+
+	evs, err = s.Query(ctx, all)
+	assert.NoError(t, err)
+	assert.Empty(t, evs)
+}
+
+func TestQueryCache(t *testing.T) {
+	filters := func(kind int64) []*ReqFilter { return []*ReqFilter{{Kinds: []int64{kind}}} }
+	c := newQueryCache(2, time.Minute)
+
+	for kind := int64(1); kind <= 3; kind++ {
+		_, gen, ok := c.Get(countCacheKey(filters(kind)))
+		assert.False(t, ok)
+		c.Set(countCacheKey(filters(kind)), filters(kind), nil, gen)
+	}
+
+	_, _, ok := c.Get(countCacheKey(filters(1)))
+	assert.False(t, ok, "least recently used entry is evicted")
+	_, _, ok = c.Get(countCacheKey(filters(3)))
+	assert.True(t, ok)
+
+	_, gen, _ := c.Get(countCacheKey(filters(4)))
+	c.Invalidate(&Event{Kind: 3})
+	c.Set(countCacheKey(filters(4)), filters(4), nil, gen)
+	_, _, ok = c.Get(countCacheKey(filters(4)))
+	assert.False(t, ok, "stale results are not cached")
+	_, _, ok = c.Get(countCacheKey(filters(3)))
+	assert.False(t, ok)
+	_, _, ok = c.Get(countCacheKey(filters(2)))
+	assert.True(t, ok)
+}
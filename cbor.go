@@ -0,0 +1,362 @@
+package mocrelay
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"unicode/utf8"
+)
+
+// CBORSubprotocol is the websocket subprotocol on which messages are CBOR (RFC 8949)
+// encodings of the JSON messages sent as binary messages.
+const CBORSubprotocol = "nostr.cbor"
+
+var ErrInvalidCBOR = errors.New("invalid cbor")
+
+// cborMaxDepth is the max nesting depth of arrays and maps.
+const cborMaxDepth = 64
+
+const (
+	cborUint   = 0
+	cborNegint = 1
+	cborText   = 3
+	cborArray  = 4
+	cborMap    = 5
+	cborSimple = 7
+)
+
+func appendCBORHead(dst []byte, major byte, n uint64) []byte {
+	major <<= 5
+	switch {
+	case n < 24:
+		return append(dst, major|byte(n))
+	case n <= math.MaxUint8:
+		return append(dst, major|24, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(dst, major|25), uint16(n))
+	case n <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(dst, major|26), uint32(n))
+	default:
+		return binary.BigEndian.AppendUint64(append(dst, major|27), n)
+	}
+}
+
+// jsonToCBOR converts a JSON value into CBOR.
+// Integers are encoded as integers and other numbers as float64.
+func jsonToCBOR(b []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return appendCBORValue(nil, v)
+}
+
+func appendCBORValue(dst []byte, v any) ([]byte, error) {
+	var err error
+
+	switch v := v.(type) {
+	case nil:
+		return append(dst, cborSimple<<5|22), nil
+
+	case bool:
+		if v {
+			return append(dst, cborSimple<<5|21), nil
+		}
+		return append(dst, cborSimple<<5|20), nil
+
+	case json.Number:
+		if n, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			if n < 0 {
+				return appendCBORHead(dst, cborNegint, uint64(-(n + 1))), nil
+			}
+			return appendCBORHead(dst, cborUint, uint64(n)), nil
+		}
+		if n, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			return appendCBORHead(dst, cborUint, n), nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, err
+		}
+		dst = append(dst, cborSimple<<5|27)
+		return binary.BigEndian.AppendUint64(dst, math.Float64bits(f)), nil
+
+	case string:
+		dst = appendCBORHead(dst, cborText, uint64(len(v)))
+		return append(dst, v...), nil
+
+	case []any:
+		dst = appendCBORHead(dst, cborArray, uint64(len(v)))
+		for _, elem := range v {
+			if dst, err = appendCBORValue(dst, elem); err != nil {
+				return nil, err
+			}
+		}
+		return dst, nil
+
+	case map[string]any:
+		dst = appendCBORHead(dst, cborMap, uint64(len(v)))
+		for k, elem := range v {
+			dst = appendCBORHead(dst, cborText, uint64(len(k)))
+			dst = append(dst, k...)
+			if dst, err = appendCBORValue(dst, elem); err != nil {
+				return nil, err
+			}
+		}
+		return dst, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported json value %T", v)
+	}
+}
+
+// cborToJSON converts a CBOR data item into JSON.
+// Only the items representable in JSON are accepted:
+// integers, finite floats, text strings, definite length arrays and maps with text keys,
+// booleans and null.
+func cborToJSON(b []byte) ([]byte, error) {
+	d := cborDecoder{b: b}
+	ret, err := d.appendJSON(nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(d.b) {
+		return nil, fmt.Errorf("%w: trailing data", ErrInvalidCBOR)
+	}
+	return ret, nil
+}
+
+type cborDecoder struct {
+	b   []byte
+	pos int
+}
+
+func (d *cborDecoder) next(n int) ([]byte, error) {
+	if n < 0 || len(d.b)-d.pos < n {
+		return nil, fmt.Errorf("%w: unexpected end", ErrInvalidCBOR)
+	}
+	ret := d.b[d.pos : d.pos+n]
+	d.pos += n
+	return ret, nil
+}
+
+// head reads the major type and the argument of the next data item.
+func (d *cborDecoder) head() (major, info byte, arg uint64, err error) {
+	b, err := d.next(1)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	major, info = b[0]>>5, b[0]&0x1f
+
+	switch {
+	case info < 24:
+		return major, info, uint64(info), nil
+	case info <= 27:
+		b, err := d.next(1 << (info - 24))
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		for _, c := range b {
+			arg = arg<<8 | uint64(c)
+		}
+		return major, info, arg, nil
+	default:
+		return 0, 0, 0, fmt.Errorf("%w: unsupported additional info %d", ErrInvalidCBOR, info)
+	}
+}
+
+func (d *cborDecoder) text() (string, error) {
+	major, _, n, err := d.head()
+	if err != nil {
+		return "", err
+	}
+	if major != cborText {
+		return "", fmt.Errorf("%w: map key must be a text string", ErrInvalidCBOR)
+	}
+	return d.textBody(n)
+}
+
+func (d *cborDecoder) textBody(n uint64) (string, error) {
+	if n > uint64(len(d.b)) {
+		return "", fmt.Errorf("%w: unexpected end", ErrInvalidCBOR)
+	}
+	b, err := d.next(int(n))
+	if err != nil {
+		return "", err
+	}
+	if !utf8.Valid(b) {
+		return "", fmt.Errorf("%w: invalid utf-8", ErrInvalidCBOR)
+	}
+	return string(b), nil
+}
+
+func (d *cborDecoder) appendJSON(dst []byte, depth int) ([]byte, error) {
+	if depth > cborMaxDepth {
+		return nil, fmt.Errorf("%w: too deep", ErrInvalidCBOR)
+	}
+
+	major, info, arg, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case cborUint:
+		return strconv.AppendUint(dst, arg, 10), nil
+
+	case cborNegint:
+		if arg == math.MaxUint64 {
+			return append(dst, "-18446744073709551616"...), nil
+		}
+		dst = append(dst, '-')
+		return strconv.AppendUint(dst, arg+1, 10), nil
+
+	case cborText:
+		s, err := d.textBody(arg)
+		if err != nil {
+			return nil, err
+		}
+		return appendJSONString(dst, s), nil
+
+	case cborArray:
+		// Each element takes at least a byte.
+		if arg > uint64(len(d.b)) {
+			return nil, fmt.Errorf("%w: unexpected end", ErrInvalidCBOR)
+		}
+		dst = append(dst, '[')
+		for i := uint64(0); i < arg; i++ {
+			if i > 0 {
+				dst = append(dst, ',')
+			}
+			if dst, err = d.appendJSON(dst, depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return append(dst, ']'), nil
+
+	case cborMap:
+		if arg > uint64(len(d.b)) {
+			return nil, fmt.Errorf("%w: unexpected end", ErrInvalidCBOR)
+		}
+		dst = append(dst, '{')
+		for i := uint64(0); i < arg; i++ {
+			if i > 0 {
+				dst = append(dst, ',')
+			}
+			k, err := d.text()
+			if err != nil {
+				return nil, err
+			}
+			dst = append(appendJSONString(dst, k), ':')
+			if dst, err = d.appendJSON(dst, depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return append(dst, '}'), nil
+
+	case cborSimple:
+		var f float64
+		switch info {
+		case 20:
+			return append(dst, "false"...), nil
+		case 21:
+			return append(dst, "true"...), nil
+		case 22:
+			return append(dst, "null"...), nil
+		case 25:
+			f = float16ToFloat64(uint16(arg))
+		case 26:
+			f = float64(math.Float32frombits(uint32(arg)))
+		case 27:
+			f = math.Float64frombits(arg)
+		default:
+			return nil, fmt.Errorf("%w: unsupported simple value %d", ErrInvalidCBOR, info)
+		}
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, fmt.Errorf("%w: non-finite float", ErrInvalidCBOR)
+		}
+		return strconv.AppendFloat(dst, f, 'g', -1, 64), nil
+
+	default:
+		return nil, fmt.Errorf("%w: unsupported major type %d", ErrInvalidCBOR, major)
+	}
+}
+
+func float16ToFloat64(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 0x1f:
+		f = math.Inf(1)
+		if mant != 0 {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		return -f
+	}
+	return f
+}
+
+// WSBinaryWriter is implemented by WSConns which can write binary messages.
+type WSBinaryWriter interface {
+	WriteBinary(ctx context.Context, b []byte) error
+}
+
+// NewCBORWSConn wraps conn negotiated on CBORSubprotocol.
+// Binary messages from conn are read as JSON text messages and text messages are written
+// as CBOR binary messages. Text messages from conn are read as they are.
+// conn must implement WSBinaryWriter.
+func NewCBORWSConn(conn WSConn) WSConn {
+	if _, ok := conn.(WSBinaryWriter); !ok {
+		panic("conn must implement WSBinaryWriter")
+	}
+	return &cborWSConn{WSConn: conn}
+}
+
+type cborWSConn struct {
+	WSConn
+}
+
+// Reader returns a binary message as a text message of its JSON.
+// A message which cannot be converted is returned as an empty text message,
+// which is rejected as invalid JSON.
+func (c *cborWSConn) Reader(ctx context.Context) (WSMessageType, io.Reader, error) {
+	typ, r, err := c.WSConn.Reader(ctx)
+	if err != nil || typ != WSMessageBinary {
+		return typ, r, err
+	}
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	j, err := cborToJSON(b)
+	if err != nil {
+		return WSMessageText, bytes.NewReader(nil), nil
+	}
+	return WSMessageText, bytes.NewReader(j), nil
+}
+
+func (c *cborWSConn) Write(ctx context.Context, b []byte) error {
+	cb, err := jsonToCBOR(b)
+	if err != nil {
+		return fmt.Errorf("failed to encode cbor: %w", err)
+	}
+	return c.WSConn.(WSBinaryWriter).WriteBinary(ctx, cb)
+}
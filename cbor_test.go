@@ -0,0 +1,174 @@
+package mocrelay
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONToCBOR(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "small uint",
+			input: `10`,
+			want:  "0a",
+		},
+		{
+			name:  "uint",
+			input: `1000000`,
+			want:  "1a000f4240",
+		},
+		{
+			name:  "negint",
+			input: `-100`,
+			want:  "3863",
+		},
+		{
+			name:  "float",
+			input: `1.5`,
+			want:  "fb3ff8000000000000",
+		},
+		{
+			name:  "text",
+			input: `"IETF"`,
+			want:  "6449455446",
+		},
+		{
+			name:  "array",
+			input: `["EOSE","sub",null,true,false]`,
+			want:  "8564454f534563737562f6f5f4",
+		},
+		{
+			name:  "map",
+			input: `{"a":[]}`,
+			want:  "a1616180",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := jsonToCBOR([]byte(tt.input))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, hex.EncodeToString(got))
+		})
+	}
+}
+
+func TestCBORToJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "uint64",
+			input: "1bffffffffffffffff",
+			want:  `18446744073709551615`,
+		},
+		{
+			name:  "negint",
+			input: "3863",
+			want:  `-100`,
+		},
+		{
+			name:  "float16",
+			input: "f93e00",
+			want:  `1.5`,
+		},
+		{
+			name:  "float32",
+			input: "fa47c35000",
+			want:  `100000`,
+		},
+		{
+			name:  "client msg",
+			input: "836352455163737562a1652374616773816161",
+			want:  `["REQ","sub",{"#tags":["a"]}]`,
+		},
+		{
+			name:  "escaped text",
+			input: "62223c",
+			want:  `"\"\u003c"`,
+		},
+		{
+			name:    "byte string",
+			input:   "4161",
+			wantErr: true,
+		},
+		{
+			name:    "non-text key",
+			input:   "a10101",
+			wantErr: true,
+		},
+		{
+			name:    "indefinite length",
+			input:   "9f01ff",
+			wantErr: true,
+		},
+		{
+			name:    "nan",
+			input:   "f97e00",
+			wantErr: true,
+		},
+		{
+			name:    "invalid utf-8",
+			input:   "61ff",
+			wantErr: true,
+		},
+		{
+			name:    "truncated",
+			input:   "9a7fffffff",
+			wantErr: true,
+		},
+		{
+			name:    "trailing data",
+			input:   "0101",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := hex.DecodeString(tt.input)
+			if !assert.NoError(t, err) {
+				return
+			}
+			got, err := cborToJSON(b)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidCBOR)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestCBORToJSON_roundTrip(t *testing.T) {
+	input := `["EVENT","sub",{"id":"49d58222bd85ddabfc19b8052d35bcce2bad8f1f3030c0bc7dc9f10dba82a8a2","created_at":1693157791,"kind":1,"tags":[["e","a","wss://r"]],"content":"こんにちは\n"}]`
+
+	b, err := jsonToCBOR([]byte(input))
+	if !assert.NoError(t, err) {
+		return
+	}
+	got, err := cborToJSON(b)
+	assert.NoError(t, err)
+	assert.JSONEq(t, input, string(got))
+}
+
+func TestCBORToJSON_tooDeep(t *testing.T) {
+	b := make([]byte, cborMaxDepth+2)
+	for i := range b {
+		b[i] = 0x81
+	}
+	b[len(b)-1] = 0x00
+
+	_, err := cborToJSON(b)
+	assert.ErrorIs(t, err, ErrInvalidCBOR)
+}
@@ -0,0 +1,148 @@
+package mocrelay
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var ErrChaosDisconnect = errors.New("disconnected by chaos middleware")
+
+// ChaosOption injects faults into the handler chain to test the reconnect and backoff
+// behavior of clients. Never use it in production.
+type ChaosOption struct {
+	// Latency delays each server message. Messages are delayed in order,
+	// so latency accumulates under load like a congested relay.
+	Latency time.Duration
+
+	// Jitter is the max random delay added to Latency.
+	Jitter time.Duration
+
+	// DropRate is the probability of dropping a server message such as OK and EVENT.
+	DropRate float64
+
+	// DisconnectRate is the probability of disconnecting on a client message.
+	DisconnectRate float64
+
+	// MaxLifetime disconnects each connection after a random duration up to it.
+	// Zero means no limit.
+	MaxLifetime time.Duration
+
+	// Seed is the seed of the faults. Zero means a random seed.
+	Seed uint64
+}
+
+type ChaosMiddleware Middleware
+
+func NewChaosMiddleware(option *ChaosOption) ChaosMiddleware {
+	if option == nil {
+		panic("option must be non-nil pointer")
+	}
+
+	seed := option.Seed
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+	rng := &lockedRand{r: rand.New(rand.NewPCG(seed, seed))}
+
+	return func(h Handler) Handler {
+		return HandlerFunc(
+			func(r *http.Request, recv <-chan ClientMsg, send chan<- ServerMsg) error {
+				sm := newSimpleChaosMiddleware(option, rng)
+				m := NewSimpleMiddleware(sm)
+				return m(h).Handle(r, recv, send)
+			},
+		)
+	}
+}
+
+// lockedRand is a rand.Rand shared by connections.
+type lockedRand struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+func (r *lockedRand) Float64() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.Float64()
+}
+
+// Duration returns a random duration in [0, d).
+func (r *lockedRand) Duration(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return time.Duration(r.r.Int64N(int64(d)))
+}
+
+var _ SimpleMiddlewareInterface = (*simpleChaosMiddleware)(nil)
+
+type simpleChaosMiddleware struct {
+	opt    *ChaosOption
+	rng    *lockedRand
+	cancel context.CancelFunc
+}
+
+func newSimpleChaosMiddleware(option *ChaosOption, rng *lockedRand) *simpleChaosMiddleware {
+	return &simpleChaosMiddleware{opt: option, rng: rng}
+}
+
+func (m *simpleChaosMiddleware) HandleStart(r *http.Request) (*http.Request, error) {
+	if m.opt.MaxLifetime <= 0 {
+		return r, nil
+	}
+
+	lifetime := m.rng.Duration(m.opt.MaxLifetime)
+	ctx, cancel := context.WithCancelCause(r.Context())
+	t := time.AfterFunc(lifetime, func() { cancel(ErrChaosDisconnect) })
+	m.cancel = func() {
+		t.Stop()
+		cancel(nil)
+	}
+	return r.WithContext(ctx), nil
+}
+
+func (m *simpleChaosMiddleware) HandleStop(r *http.Request) error {
+	if m.cancel != nil {
+		m.cancel()
+	}
+	return nil
+}
+
+func (m *simpleChaosMiddleware) HandleClientMsg(
+	r *http.Request,
+	msg ClientMsg,
+) (<-chan ClientMsg, <-chan ServerMsg, error) {
+	if m.opt.DisconnectRate > 0 && m.rng.Float64() < m.opt.DisconnectRate {
+		return nil, nil, ErrChaosDisconnect
+	}
+	return newClosedBufCh(msg), nil, nil
+}
+
+func (m *simpleChaosMiddleware) HandleServerMsg(
+	r *http.Request,
+	msg ServerMsg,
+) (<-chan ServerMsg, error) {
+	if m.opt.DropRate > 0 && m.rng.Float64() < m.opt.DropRate {
+		return nil, nil
+	}
+
+	if delay := m.opt.Latency + m.rng.Duration(m.opt.Jitter); delay > 0 {
+		t := time.NewTimer(delay)
+		defer t.Stop()
+
+		select {
+		case <-r.Context().Done():
+			return nil, context.Cause(r.Context())
+		case <-t.C:
+		}
+	}
+
+	return newClosedBufCh(msg), nil
+}
@@ -0,0 +1,72 @@
+package mocrelay
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChaosMiddleware(t *testing.T) {
+	echo := HandlerFunc(func(r *http.Request, recv <-chan ClientMsg, send chan<- ServerMsg) error {
+		for msg := range recv {
+			if msg, ok := msg.(*ClientEventMsg); ok {
+				send <- NewServerOKMsg(msg.Event.ID, true, ServerOKMsgPrefixNoPrefix, "")
+			}
+		}
+		return ErrRecvClosed
+	})
+
+	start := func(option *ChaosOption) (chan ClientMsg, chan ServerMsg, chan error) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		t.Cleanup(cancel)
+
+		r := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+		recv := make(chan ClientMsg)
+		send := make(chan ServerMsg, 100)
+		done := make(chan error, 1)
+		go func() { done <- NewChaosMiddleware(option)(echo).Handle(r, recv, send) }()
+		return recv, send, done
+	}
+
+	t.Run("latency", func(t *testing.T) {
+		recv, send, _ := start(&ChaosOption{Latency: 50 * time.Millisecond, Seed: 1})
+
+		begin := time.Now()
+		recv <- &ClientEventMsg{Event: &Event{ID: "a"}}
+		<-send
+		assert.GreaterOrEqual(t, time.Since(begin), 50*time.Millisecond)
+	})
+
+	t.Run("drop", func(t *testing.T) {
+		recv, send, _ := start(&ChaosOption{DropRate: 0.5, Seed: 1})
+
+		for i := 0; i < 100; i++ {
+			recv <- &ClientEventMsg{Event: &Event{ID: "a"}}
+		}
+		time.Sleep(50 * time.Millisecond)
+		assert.Greater(t, len(send), 20)
+		assert.Less(t, len(send), 80)
+	})
+
+	t.Run("disconnect", func(t *testing.T) {
+		recv, _, done := start(&ChaosOption{DisconnectRate: 1, Seed: 1})
+
+		recv <- &ClientEventMsg{Event: &Event{ID: "a"}}
+		assert.ErrorIs(t, <-done, ErrChaosDisconnect)
+	})
+
+	t.Run("max lifetime", func(t *testing.T) {
+		_, _, done := start(&ChaosOption{MaxLifetime: 50 * time.Millisecond, Seed: 1})
+
+		select {
+		case err := <-done:
+			assert.Error(t, err)
+		case <-time.After(500 * time.Millisecond):
+			t.Fatal("not disconnected")
+		}
+	})
+}
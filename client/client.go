@@ -0,0 +1,677 @@
+// Package client is a nostr client of a single relay.
+//
+// A Client keeps the connection to the relay. It reconnects with backoff when
+// the connection is lost and sends the active subscriptions again. It answers
+// AUTH challenges if a keypair is given, and retries publishes and subscriptions
+// rejected with "auth-required:" once after authentication.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"nhooyr.io/websocket"
+
+	"github.com/high-moctane/mocrelay"
+)
+
+var (
+	ErrClosed       = errors.New("client closed")
+	ErrDisconnected = errors.New("disconnected from relay")
+)
+
+// RejectedError is the error of an event rejected with OK false.
+type RejectedError struct {
+	EventID string
+	Message string
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("event %s rejected: %s", e.EventID, e.Message)
+}
+
+// ClosedError is the error of a subscription closed by the relay with CLOSED.
+type ClosedError struct {
+	SubscriptionID string
+	Message        string
+}
+
+func (e *ClosedError) Error() string {
+	return fmt.Sprintf("subscription %s closed: %s", e.SubscriptionID, e.Message)
+}
+
+type Option struct {
+	// Keypair answers AUTH challenges if not nil.
+	Keypair *mocrelay.Keypair
+
+	// PingInterval is the interval of pings to keep the connection alive.
+	// The default is 30 seconds. Negative disables pings.
+	PingInterval time.Duration
+
+	// PingTimeout is how long to wait for a pong before reconnecting.
+	// The default is 10 seconds.
+	PingTimeout time.Duration
+
+	// MinBackoff and MaxBackoff are the range of the interval of reconnection,
+	// which doubles on every failure. The defaults are 1 second and 1 minute.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// MaxMessageLength is the max length of a message from the relay. The default is 1 MiB.
+	MaxMessageLength int64
+
+	// SubscriptionBuffer is the buffer length of the events of a subscription.
+	// Events are dropped while the buffer is full. The default is 1000.
+	SubscriptionBuffer int
+
+	// HTTPHeader is sent on the handshakes.
+	HTTPHeader http.Header
+
+	// Logger logs reconnections and notices if not nil.
+	Logger *slog.Logger
+}
+
+func (opt *Option) pingInterval() time.Duration {
+	if opt == nil || opt.PingInterval == 0 {
+		return 30 * time.Second
+	}
+	return opt.PingInterval
+}
+
+func (opt *Option) pingTimeout() time.Duration {
+	if opt == nil || opt.PingTimeout == 0 {
+		return 10 * time.Second
+	}
+	return opt.PingTimeout
+}
+
+func (opt *Option) minBackoff() time.Duration {
+	if opt == nil || opt.MinBackoff == 0 {
+		return time.Second
+	}
+	return opt.MinBackoff
+}
+
+func (opt *Option) maxBackoff() time.Duration {
+	if opt == nil || opt.MaxBackoff == 0 {
+		return time.Minute
+	}
+	return opt.MaxBackoff
+}
+
+func (opt *Option) maxMessageLength() int64 {
+	if opt == nil || opt.MaxMessageLength == 0 {
+		return 1 << 20
+	}
+	return opt.MaxMessageLength
+}
+
+func (opt *Option) subscriptionBuffer() int {
+	if opt == nil || opt.SubscriptionBuffer == 0 {
+		return 1000
+	}
+	return opt.SubscriptionBuffer
+}
+
+func (opt *Option) keypair() *mocrelay.Keypair {
+	if opt == nil {
+		return nil
+	}
+	return opt.Keypair
+}
+
+func (opt *Option) httpHeader() http.Header {
+	if opt == nil {
+		return nil
+	}
+	return opt.HTTPHeader
+}
+
+func (opt *Option) logger() *slog.Logger {
+	if opt == nil {
+		return nil
+	}
+	return opt.Logger
+}
+
+type okResult struct {
+	accepted bool
+	msg      string
+	err      error
+}
+
+// Client is a connection to a relay. It is safe for concurrent use.
+type Client struct {
+	url string
+	opt *Option
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu sync.Mutex
+	// conn is nil while reconnecting.
+	conn *websocket.Conn
+	// connected is closed when conn is set.
+	connected chan struct{}
+	// lost is closed when the current connection is lost.
+	lost chan struct{}
+	// authed is closed when the relay accepts AUTH of the current connection.
+	authed      chan struct{}
+	authEventID string
+	nextSubID   int
+	// map[subID]sub
+	subs map[string]*Subscription
+	// map[eventID]waiters
+	pending map[string][]chan okResult
+}
+
+// Dial connects to the relay at url. The first connection must succeed.
+func Dial(ctx context.Context, url string, option *Option) (*Client, error) {
+	c := &Client{
+		url:       url,
+		opt:       option,
+		done:      make(chan struct{}),
+		connected: make(chan struct{}),
+		lost:      make(chan struct{}),
+		authed:    make(chan struct{}),
+		subs:      make(map[string]*Subscription),
+		pending:   make(map[string][]chan okResult),
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+
+	conn, err := c.dial(ctx)
+	if err != nil {
+		c.cancel()
+		return nil, err
+	}
+
+	c.connect(conn)
+	go c.run(conn, nil)
+	return c, nil
+}
+
+func (c *Client) dial(ctx context.Context) (*websocket.Conn, error) {
+	conn, _, err := websocket.Dial(ctx, c.url, &websocket.DialOptions{
+		HTTPHeader: c.opt.httpHeader(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial %s: %w", c.url, err)
+	}
+	conn.SetReadLimit(c.opt.maxMessageLength())
+	return conn, nil
+}
+
+// Close closes the connection. Subscriptions end with ErrClosed.
+func (c *Client) Close() error {
+	c.cancel()
+	<-c.done
+	return nil
+}
+
+// run serves connections until the client is closed.
+func (c *Client) run(conn *websocket.Conn, subs []*Subscription) {
+	defer close(c.done)
+	defer c.closeSubs(ErrClosed)
+
+	backoff := c.opt.minBackoff()
+	for {
+		start := time.Now()
+		err := c.serve(conn, subs)
+		c.disconnected()
+		if c.ctx.Err() != nil {
+			return
+		}
+		c.logWarn("disconnected", "err", err)
+
+		// Connections which have lasted long reset the backoff.
+		if time.Since(start) > c.opt.maxBackoff() {
+			backoff = c.opt.minBackoff()
+		}
+
+		for {
+			t := time.NewTimer(backoff)
+			select {
+			case <-c.ctx.Done():
+				t.Stop()
+				return
+			case <-t.C:
+			}
+			backoff = min(backoff*2, c.opt.maxBackoff())
+
+			conn, err = c.dial(c.ctx)
+			if err == nil {
+				subs = c.connect(conn)
+				break
+			}
+			c.logWarn("failed to reconnect", "err", err)
+		}
+	}
+}
+
+// connect makes conn the current connection.
+// It returns the subscriptions to be sent again on conn.
+func (c *Client) connect(conn *websocket.Conn) []*Subscription {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.conn = conn
+	close(c.connected)
+
+	subs := make([]*Subscription, 0, len(c.subs))
+	for _, sub := range c.subs {
+		subs = append(subs, sub)
+	}
+	return subs
+}
+
+// serve sends subs again, reads conn and keeps it alive until it fails.
+func (c *Client) serve(conn *websocket.Conn, subs []*Subscription) error {
+	ctx, cancel := context.WithCancel(c.ctx)
+	defer cancel()
+	defer conn.Close(websocket.StatusNormalClosure, "")
+
+	errs := make(chan error, 2)
+	go func() { errs <- c.read(ctx, conn) }()
+	go func() { errs <- c.ping(ctx, conn) }()
+
+	for _, sub := range subs {
+		if err := write(ctx, conn, sub.reqMsg()...); err != nil {
+			return err
+		}
+	}
+
+	return <-errs
+}
+
+// disconnected resets the state of the connection and fails the pending publishes.
+func (c *Client) disconnected() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.conn = nil
+	c.connected = make(chan struct{})
+	close(c.lost)
+	c.lost = make(chan struct{})
+	c.authed = make(chan struct{})
+	c.authEventID = ""
+
+	for id, waiters := range c.pending {
+		for _, ch := range waiters {
+			ch <- okResult{err: ErrDisconnected}
+		}
+		delete(c.pending, id)
+	}
+}
+
+func (c *Client) ping(ctx context.Context, conn *websocket.Conn) error {
+	interval := c.opt.pingInterval()
+	if interval < 0 {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			pingCtx, cancel := context.WithTimeout(ctx, c.opt.pingTimeout())
+			err := conn.Ping(pingCtx)
+			cancel()
+			if err != nil {
+				return fmt.Errorf("ping failed: %w", err)
+			}
+		}
+	}
+}
+
+func write(ctx context.Context, conn *websocket.Conn, msg ...any) error {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return conn.Write(ctx, websocket.MessageText, b)
+}
+
+// send writes msg to the current connection. It waits for a reconnection if disconnected.
+func (c *Client) send(ctx context.Context, msg ...any) error {
+	for {
+		c.mu.Lock()
+		conn, connected := c.conn, c.connected
+		c.mu.Unlock()
+
+		if conn != nil {
+			return write(ctx, conn, msg...)
+		}
+
+		select {
+		case <-c.ctx.Done():
+			return ErrClosed
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-connected:
+		}
+	}
+}
+
+// Publish sends event and waits for its OK.
+// It returns *RejectedError if the relay rejects event.
+func (c *Client) Publish(ctx context.Context, event *mocrelay.Event) error {
+	for retried := false; ; retried = true {
+		res, err := c.publish(ctx, event)
+		if err != nil {
+			return err
+		}
+		if res.accepted {
+			return nil
+		}
+
+		if !retried && c.opt.keypair() != nil && isAuthRequired(res.msg) {
+			c.mu.Lock()
+			authed, lost := c.authed, c.lost
+			c.mu.Unlock()
+			if err := c.waitAuth(ctx, authed, lost); err != nil {
+				return err
+			}
+			continue
+		}
+		return &RejectedError{EventID: event.ID, Message: res.msg}
+	}
+}
+
+func (c *Client) publish(ctx context.Context, event *mocrelay.Event) (okResult, error) {
+	ch := make(chan okResult, 1)
+	c.mu.Lock()
+	c.pending[event.ID] = append(c.pending[event.ID], ch)
+	c.mu.Unlock()
+
+	if err := c.send(ctx, "EVENT", event); err != nil {
+		c.removePending(event.ID, ch)
+		return okResult{}, err
+	}
+
+	select {
+	case <-ctx.Done():
+		c.removePending(event.ID, ch)
+		return okResult{}, ctx.Err()
+	case res := <-ch:
+		return res, res.err
+	}
+}
+
+func (c *Client) removePending(eventID string, ch chan okResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	waiters := c.pending[eventID]
+	for i, w := range waiters {
+		if w == ch {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(waiters) == 0 {
+		delete(c.pending, eventID)
+	} else {
+		c.pending[eventID] = waiters
+	}
+}
+
+// waitAuth waits until the relay accepts AUTH of the connection of authed and lost.
+func (c *Client) waitAuth(ctx context.Context, authed, lost chan struct{}) error {
+	select {
+	case <-c.ctx.Done():
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-lost:
+		return ErrDisconnected
+	case <-authed:
+		return nil
+	}
+}
+
+// Subscribe sends REQ with filters. The subscription is sent again on reconnections,
+// so events may be received more than once. While disconnected, REQ is sent on the
+// next connection.
+func (c *Client) Subscribe(
+	ctx context.Context,
+	filters []*mocrelay.ReqFilter,
+) (*Subscription, error) {
+	c.mu.Lock()
+	id := "sub" + strconv.Itoa(c.nextSubID)
+	c.nextSubID++
+	sub := newSubscription(c, id, filters, c.opt.subscriptionBuffer())
+	c.subs[id] = sub
+	conn := c.conn
+	c.mu.Unlock()
+
+	if c.ctx.Err() != nil {
+		c.removeSub(id)
+		return nil, ErrClosed
+	}
+	if conn == nil {
+		return sub, nil
+	}
+
+	// A failed write loses the connection, and the subscription is sent on the next one.
+	if err := write(ctx, conn, sub.reqMsg()...); err != nil && ctx.Err() != nil {
+		c.removeSub(id)
+		return nil, err
+	}
+	return sub, nil
+}
+
+func (c *Client) removeSub(id string) *Subscription {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sub := c.subs[id]
+	delete(c.subs, id)
+	return sub
+}
+
+func (c *Client) sub(id string) *Subscription {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.subs[id]
+}
+
+func (c *Client) closeSubs(err error) {
+	c.mu.Lock()
+	subs := c.subs
+	c.subs = make(map[string]*Subscription)
+	c.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.end(err)
+	}
+}
+
+// read dispatches messages from the relay until conn fails.
+func (c *Client) read(ctx context.Context, conn *websocket.Conn) error {
+	for {
+		_, b, err := conn.Read(ctx)
+		if err != nil {
+			return err
+		}
+
+		var elems []json.RawMessage
+		if err := json.Unmarshal(b, &elems); err != nil || len(elems) == 0 {
+			c.logWarn("invalid message", "msg", string(b))
+			continue
+		}
+		var label string
+		if err := json.Unmarshal(elems[0], &label); err != nil {
+			c.logWarn("invalid message", "msg", string(b))
+			continue
+		}
+
+		if err := c.handle(ctx, label, elems[1:]); err != nil {
+			c.logWarn("invalid message", "msg", string(b), "err", err)
+		}
+	}
+}
+
+func (c *Client) handle(ctx context.Context, label string, elems []json.RawMessage) error {
+	switch label {
+	case "EVENT":
+		var subID string
+		var event mocrelay.Event
+		if err := unmarshalElems(elems, &subID, &event); err != nil {
+			return err
+		}
+		if sub := c.sub(subID); sub != nil {
+			sub.deliver(&event)
+		}
+
+	case "EOSE":
+		var subID string
+		if err := unmarshalElems(elems, &subID); err != nil {
+			return err
+		}
+		if sub := c.sub(subID); sub != nil {
+			sub.setEOSE()
+		}
+
+	case "OK":
+		var res okResult
+		var eventID string
+		if err := unmarshalElems(elems, &eventID, &res.accepted, &res.msg); err != nil {
+			return err
+		}
+		c.handleOK(eventID, res)
+
+	case "CLOSED":
+		var subID, msg string
+		if err := unmarshalElems(elems, &subID, &msg); err != nil {
+			return err
+		}
+		c.handleClosed(ctx, subID, msg)
+
+	case "AUTH":
+		var challenge string
+		if err := unmarshalElems(elems, &challenge); err != nil {
+			return err
+		}
+		return c.auth(ctx, challenge)
+
+	case "NOTICE":
+		var msg string
+		if err := unmarshalElems(elems, &msg); err != nil {
+			return err
+		}
+		c.logInfo("notice", "msg", msg)
+	}
+
+	return nil
+}
+
+func (c *Client) handleOK(eventID string, res okResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if eventID == c.authEventID && c.authEventID != "" {
+		c.authEventID = ""
+		if res.accepted {
+			close(c.authed)
+		} else if logger := c.opt.logger(); logger != nil {
+			logger.Warn("auth rejected", "msg", res.msg)
+		}
+		return
+	}
+
+	waiters := c.pending[eventID]
+	delete(c.pending, eventID)
+	for _, ch := range waiters {
+		ch <- res
+	}
+}
+
+// handleClosed ends the subscription or sends it again after authentication.
+func (c *Client) handleClosed(ctx context.Context, subID, msg string) {
+	c.mu.Lock()
+	sub := c.subs[subID]
+	authed, lost := c.authed, c.lost
+	c.mu.Unlock()
+
+	if sub == nil {
+		return
+	}
+
+	if c.opt.keypair() != nil && isAuthRequired(msg) && sub.markAuthRetry() {
+		go func() {
+			// The subscription is sent again anyway if the connection is lost.
+			if err := c.waitAuth(ctx, authed, lost); err != nil {
+				return
+			}
+			if err := c.send(ctx, sub.reqMsg()...); err != nil {
+				c.logWarn("failed to resubscribe", "err", err)
+			}
+		}()
+		return
+	}
+
+	c.removeSub(subID)
+	sub.end(&ClosedError{SubscriptionID: subID, Message: msg})
+}
+
+// auth answers the challenge with the keypair.
+func (c *Client) auth(ctx context.Context, challenge string) error {
+	key := c.opt.keypair()
+	if key == nil {
+		return nil
+	}
+
+	msg, err := key.NewAuthMsg(c.url, challenge)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.authEventID = msg.Event.ID
+	c.mu.Unlock()
+
+	return c.send(ctx, "AUTH", msg.Event)
+}
+
+func (c *Client) logInfo(msg string, args ...any) {
+	if logger := c.opt.logger(); logger != nil {
+		logger.Info(msg, append([]any{"relay", c.url}, args...)...)
+	}
+}
+
+func (c *Client) logWarn(msg string, args ...any) {
+	if logger := c.opt.logger(); logger != nil {
+		logger.Warn(msg, append([]any{"relay", c.url}, args...)...)
+	}
+}
+
+func isAuthRequired(msg string) bool {
+	return strings.HasPrefix(msg, mocrelay.ServerClosedMsgPrefixAuthRequired)
+}
+
+// unmarshalElems unmarshals elems into vs in order. Extra elements are ignored.
+func unmarshalElems(elems []json.RawMessage, vs ...any) error {
+	if len(elems) < len(vs) {
+		return fmt.Errorf("want %d elements but got %d", len(vs), len(elems))
+	}
+	for i, v := range vs {
+		if err := json.Unmarshal(elems[i], v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
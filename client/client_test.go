@@ -0,0 +1,271 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+
+	"github.com/high-moctane/mocrelay"
+	"github.com/high-moctane/mocrelay/mocrelaytest"
+)
+
+const testSeckey = "0000000000000000000000000000000000000000000000000000000000000001"
+
+func testKeypair(t *testing.T) *mocrelay.Keypair {
+	key, err := mocrelay.ParseKeypair(testSeckey)
+	require.NoError(t, err)
+	return key
+}
+
+func recvEvent(t *testing.T, sub *Subscription) *mocrelay.Event {
+	t.Helper()
+
+	select {
+	case event, ok := <-sub.Events():
+		require.True(t, ok, "subscription ended: %v", sub.Err())
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event")
+	}
+	return nil
+}
+
+func TestClient_PublishSubscribe(t *testing.T) {
+	srv := mocrelaytest.NewServer(t, nil, nil)
+	key := testKeypair(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c, err := Dial(ctx, srv.WSURL(), nil)
+	require.NoError(t, err)
+	defer c.Close()
+
+	stored := mocrelaytest.SignedEvent(key, &mocrelay.Event{Kind: 1, Content: "stored"})
+	require.NoError(t, c.Publish(ctx, stored))
+
+	sub, err := c.Subscribe(ctx, []*mocrelay.ReqFilter{{Kinds: []int64{1}}})
+	require.NoError(t, err)
+
+	assert.Equal(t, stored.ID, recvEvent(t, sub).ID)
+	select {
+	case <-sub.EOSE():
+	case <-ctx.Done():
+		t.Fatal("no eose")
+	}
+
+	live := mocrelaytest.SignedEvent(key, &mocrelay.Event{Kind: 1, Content: "live"})
+	require.NoError(t, c.Publish(ctx, live))
+	assert.Equal(t, live.ID, recvEvent(t, sub).ID)
+
+	require.NoError(t, sub.Close(ctx))
+	<-sub.Done()
+	assert.NoError(t, sub.Err())
+}
+
+func TestClient_Publish_rejected(t *testing.T) {
+	h := mocrelaytest.NewHandler(mocrelaytest.RejectAll(mocrelay.ServerOkMsgPrefixBlocked, "no"))
+	srv := mocrelaytest.NewServer(t, h, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c, err := Dial(ctx, srv.WSURL(), nil)
+	require.NoError(t, err)
+	defer c.Close()
+
+	event := mocrelaytest.SignedEvent(testKeypair(t), &mocrelay.Event{Kind: 1})
+	err = c.Publish(ctx, event)
+	var rejected *RejectedError
+	require.ErrorAs(t, err, &rejected)
+	assert.Equal(t, event.ID, rejected.EventID)
+	assert.Equal(t, "blocked: no", rejected.Message)
+
+	sub, err := c.Subscribe(ctx, []*mocrelay.ReqFilter{{}})
+	require.NoError(t, err)
+	<-sub.Done()
+	var closed *ClosedError
+	require.ErrorAs(t, sub.Err(), &closed)
+	assert.Equal(t, "blocked: no", closed.Message)
+}
+
+func TestClient_reconnect(t *testing.T) {
+	// The connection is lost when a kind 0 event is received.
+	h := mocrelay.HandlerFunc(
+		func(r *http.Request, recv <-chan mocrelay.ClientMsg, send chan<- mocrelay.ServerMsg) error {
+			inner := mocrelaytest.NewRelayHandler()
+
+			filtered := make(chan mocrelay.ClientMsg)
+			errs := make(chan error, 1)
+			go func() { errs <- inner.Handle(r, filtered, send) }()
+
+			for {
+				select {
+				case err := <-errs:
+					return err
+				case msg, ok := <-recv:
+					if !ok {
+						return mocrelay.ErrRecvClosed
+					}
+					if msg, ok := msg.(*mocrelay.ClientEventMsg); ok && msg.Event.Kind == 0 {
+						return errors.New("disconnect")
+					}
+					filtered <- msg
+				}
+			}
+		},
+	)
+	srv := mocrelaytest.NewServer(t, h, nil)
+	key := testKeypair(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	c, err := Dial(ctx, srv.WSURL(), &Option{MinBackoff: 10 * time.Millisecond})
+	require.NoError(t, err)
+	defer c.Close()
+
+	sub, err := c.Subscribe(ctx, []*mocrelay.ReqFilter{{Kinds: []int64{1}}})
+	require.NoError(t, err)
+	<-sub.EOSE()
+
+	disconnect := mocrelaytest.SignedEvent(key, &mocrelay.Event{Kind: 0})
+	assert.ErrorIs(t, c.Publish(ctx, disconnect), ErrDisconnected)
+
+	// The subscription is sent again on the new connection.
+	event := mocrelaytest.SignedEvent(key, &mocrelay.Event{Kind: 1, Content: "after"})
+	require.Eventually(t, func() bool {
+		err := c.Publish(ctx, event)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, event.ID, recvEvent(t, sub).ID)
+}
+
+// newAuthServer returns a relay which requires AUTH for EVENT and REQ.
+func newAuthServer(t *testing.T, challenge string) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close(websocket.StatusNormalClosure, "")
+		ctx := r.Context()
+
+		write := func(msg ...any) {
+			b, _ := json.Marshal(msg)
+			conn.Write(ctx, websocket.MessageText, b)
+		}
+		write("AUTH", challenge)
+
+		authed := false
+		for {
+			_, b, err := conn.Read(ctx)
+			if err != nil {
+				return
+			}
+			var elems []json.RawMessage
+			if err := json.Unmarshal(b, &elems); err != nil || len(elems) < 2 {
+				continue
+			}
+			var label string
+			json.Unmarshal(elems[0], &label)
+
+			switch label {
+			case "AUTH":
+				var event mocrelay.Event
+				json.Unmarshal(elems[1], &event)
+				ok, _ := event.Verify()
+				authed = ok && event.Kind == mocrelay.AuthEventKind &&
+					len(event.Tags) == 2 && event.Tags[1][1] == challenge
+				write("OK", event.ID, authed, "")
+
+			case "EVENT":
+				var event mocrelay.Event
+				json.Unmarshal(elems[1], &event)
+				if authed {
+					write("OK", event.ID, true, "")
+				} else {
+					write("OK", event.ID, false, "auth-required: please auth")
+				}
+
+			case "REQ":
+				var subID string
+				json.Unmarshal(elems[1], &subID)
+				if authed {
+					write("EOSE", subID)
+				} else {
+					write("CLOSED", subID, "auth-required: please auth")
+				}
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestClient_auth(t *testing.T) {
+	srv := newAuthServer(t, "challenge")
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	t.Run("with keypair", func(t *testing.T) {
+		c, err := Dial(ctx, url, &Option{Keypair: testKeypair(t)})
+		require.NoError(t, err)
+		defer c.Close()
+
+		sub, err := c.Subscribe(ctx, []*mocrelay.ReqFilter{{}})
+		require.NoError(t, err)
+		select {
+		case <-sub.EOSE():
+		case <-sub.Done():
+			t.Fatalf("subscription ended: %v", sub.Err())
+		case <-ctx.Done():
+			t.Fatal("no eose")
+		}
+
+		event := mocrelaytest.SignedEvent(testKeypair(t), &mocrelay.Event{Kind: 1})
+		assert.NoError(t, c.Publish(ctx, event))
+	})
+
+	t.Run("without keypair", func(t *testing.T) {
+		c, err := Dial(ctx, url, nil)
+		require.NoError(t, err)
+		defer c.Close()
+
+		event := mocrelaytest.SignedEvent(testKeypair(t), &mocrelay.Event{Kind: 1})
+		var rejected *RejectedError
+		require.ErrorAs(t, c.Publish(ctx, event), &rejected)
+		assert.Equal(t, "auth-required: please auth", rejected.Message)
+	})
+}
+
+func TestClient_Close(t *testing.T) {
+	srv := mocrelaytest.NewServer(t, nil, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c, err := Dial(ctx, srv.WSURL(), nil)
+	require.NoError(t, err)
+
+	sub, err := c.Subscribe(ctx, []*mocrelay.ReqFilter{{}})
+	require.NoError(t, err)
+
+	require.NoError(t, c.Close())
+	<-sub.Done()
+	assert.ErrorIs(t, sub.Err(), ErrClosed)
+
+	event := mocrelaytest.SignedEvent(testKeypair(t), &mocrelay.Event{Kind: 1})
+	assert.ErrorIs(t, c.Publish(ctx, event), ErrClosed)
+}
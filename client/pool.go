@@ -0,0 +1,550 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/high-moctane/mocrelay"
+)
+
+var ErrNoRelay = errors.New("no relay available")
+
+type PoolOption struct {
+	// Client is the option of the connections to the relays.
+	Client *Option
+
+	// DefaultRelays are used for the pubkeys without relay lists
+	// and the filters without authors or "#p" tags.
+	DefaultRelays []string
+
+	// MaxRelaysPerPubkey is the max number of the relays of a pubkey used for routing.
+	// The default is 3.
+	MaxRelaysPerPubkey int
+
+	// SeenSize is the number of event IDs remembered by a subscription
+	// to deduplicate events across relays. The default is 10000.
+	SeenSize int
+}
+
+func (opt *PoolOption) client() *Option {
+	if opt == nil {
+		return nil
+	}
+	return opt.Client
+}
+
+func (opt *PoolOption) defaultRelays() []string {
+	if opt == nil {
+		return nil
+	}
+	return opt.DefaultRelays
+}
+
+func (opt *PoolOption) maxRelaysPerPubkey() int {
+	if opt == nil || opt.MaxRelaysPerPubkey == 0 {
+		return 3
+	}
+	return opt.MaxRelaysPerPubkey
+}
+
+func (opt *PoolOption) seenSize() int {
+	if opt == nil || opt.SeenSize == 0 {
+		return 10000
+	}
+	return opt.SeenSize
+}
+
+// RelayPool manages the connections to many relays.
+// Publishes and subscriptions without explicit relays are routed by the outbox model
+// with the NIP-65 relay lists known to the pool.
+type RelayPool struct {
+	opt *PoolOption
+
+	mu     sync.Mutex
+	closed bool
+	// map[url]conn
+	conns map[string]*poolConn
+	// map[pubkey]list
+	lists map[string]*mocrelay.RelayList
+}
+
+type poolConn struct {
+	ready chan struct{}
+	c     *Client
+	err   error
+}
+
+func NewRelayPool(option *PoolOption) *RelayPool {
+	return &RelayPool{
+		opt:   option,
+		conns: make(map[string]*poolConn),
+		lists: make(map[string]*mocrelay.RelayList),
+	}
+}
+
+// Client returns the connection to the relay at url. It dials the relay on the first call.
+func (p *RelayPool) Client(ctx context.Context, url string) (*Client, error) {
+	u, ok := mocrelay.NormalizeRelayURL(url)
+	if !ok {
+		return nil, fmt.Errorf("invalid relay url: %q", url)
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, ErrClosed
+	}
+	pc, ok := p.conns[u]
+	if !ok {
+		pc = &poolConn{ready: make(chan struct{})}
+		p.conns[u] = pc
+	}
+	p.mu.Unlock()
+
+	if !ok {
+		p.dial(ctx, u, pc)
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-pc.ready:
+		return pc.c, pc.err
+	}
+}
+
+func (p *RelayPool) dial(ctx context.Context, url string, pc *poolConn) {
+	defer close(pc.ready)
+
+	c, err := Dial(ctx, url, p.opt.client())
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch {
+	case err != nil:
+		// A failed relay is dialed again on the next call.
+		delete(p.conns, url)
+		pc.err = err
+	case p.closed:
+		c.Close()
+		pc.err = ErrClosed
+	default:
+		pc.c = c
+	}
+}
+
+// Close closes all the connections.
+func (p *RelayPool) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	conns := p.conns
+	p.conns = make(map[string]*poolConn)
+	p.mu.Unlock()
+
+	for _, pc := range conns {
+		<-pc.ready
+		if pc.c != nil {
+			pc.c.Close()
+		}
+	}
+	return nil
+}
+
+// SetRelayList sets the relay list of the author of a kind 10002 event if it is newer
+// than the known one. It does not verify event.
+func (p *RelayPool) SetRelayList(event *mocrelay.Event) error {
+	list, err := mocrelay.ParseRelayList(event)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if old := p.lists[list.Pubkey]; old == nil || old.CreatedAt < list.CreatedAt {
+		p.lists[list.Pubkey] = list
+	}
+	return nil
+}
+
+// RelayList returns the relay list of pubkey or nil if unknown.
+func (p *RelayPool) RelayList(pubkey string) *mocrelay.RelayList {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lists[pubkey]
+}
+
+// relaysOf returns the write or read relays of pubkey.
+// It returns the default relays if the relay list is unknown.
+func (p *RelayPool) relaysOf(pubkey string, write bool) []string {
+	p.mu.Lock()
+	list := p.lists[pubkey]
+	p.mu.Unlock()
+
+	var urls []string
+	if list != nil {
+		urls = list.Read
+		if write {
+			urls = list.Write
+		}
+	}
+	if len(urls) == 0 {
+		return p.opt.defaultRelays()
+	}
+
+	if n := p.opt.maxRelaysPerPubkey(); n > 0 && len(urls) > n {
+		urls = urls[:n]
+	}
+	return urls
+}
+
+// PublishRelays returns the relays event is published to by the outbox model:
+// the write relays of the author and the read relays of the pubkeys in the "p" tags.
+func (p *RelayPool) PublishRelays(event *mocrelay.Event) []string {
+	var ret []string
+	seen := make(map[string]bool)
+	add := func(urls []string) {
+		for _, url := range urls {
+			u, ok := mocrelay.NormalizeRelayURL(url)
+			if ok && !seen[u] {
+				seen[u] = true
+				ret = append(ret, u)
+			}
+		}
+	}
+
+	add(p.relaysOf(event.Pubkey, true))
+	for _, pubkey := range event.Tags.Values("p") {
+		add(p.relaysOf(pubkey, false))
+	}
+	return ret
+}
+
+// SubscribeRelays returns the filters sent to each relay by the outbox model.
+// A filter with authors is sent to the write relays of each author with only the authors
+// writing there. A filter with "#p" tags is sent to the read relays of the pubkeys.
+// Other filters are sent to the default relays.
+func (p *RelayPool) SubscribeRelays(
+	filters []*mocrelay.ReqFilter,
+) map[string][]*mocrelay.ReqFilter {
+	ret := make(map[string][]*mocrelay.ReqFilter)
+	addAll := func(urls []string, fil *mocrelay.ReqFilter) {
+		for _, url := range urls {
+			if u, ok := mocrelay.NormalizeRelayURL(url); ok {
+				ret[u] = append(ret[u], fil)
+			}
+		}
+	}
+
+	for _, fil := range filters {
+		switch {
+		case len(fil.Authors) > 0:
+			// map[url]authors
+			authors := make(map[string][]string)
+			var order []string
+			for _, author := range fil.Authors {
+				for _, url := range p.relaysOf(author, true) {
+					u, ok := mocrelay.NormalizeRelayURL(url)
+					if !ok {
+						continue
+					}
+					if _, ok := authors[u]; !ok {
+						order = append(order, u)
+					}
+					authors[u] = append(authors[u], author)
+				}
+			}
+			for _, u := range order {
+				f := *fil
+				f.Authors = authors[u]
+				ret[u] = append(ret[u], &f)
+			}
+
+		case len(fil.Tags["#p"]) > 0:
+			seen := make(map[string]bool)
+			for _, pubkey := range fil.Tags["#p"] {
+				for _, url := range p.relaysOf(pubkey, false) {
+					if !seen[url] {
+						seen[url] = true
+						addAll([]string{url}, fil)
+					}
+				}
+			}
+
+		default:
+			addAll(p.opt.defaultRelays(), fil)
+		}
+	}
+
+	return ret
+}
+
+// PublishResult is the result of a publish to a relay.
+type PublishResult struct {
+	Relay string
+	Err   error
+}
+
+// Publish publishes event to relays concurrently and returns the results in the order of relays.
+// Empty relays means PublishRelays. A kind 10002 event also updates the relay list of the pool.
+func (p *RelayPool) Publish(
+	ctx context.Context,
+	event *mocrelay.Event,
+	relays ...string,
+) []PublishResult {
+	if event.Kind == mocrelay.RelayListEventKind {
+		p.SetRelayList(event)
+	}
+	if len(relays) == 0 {
+		relays = p.PublishRelays(event)
+	}
+
+	ret := make([]PublishResult, len(relays))
+	var wg sync.WaitGroup
+	for i, url := range relays {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			ret[i].Relay = url
+			c, err := p.Client(ctx, url)
+			if err != nil {
+				ret[i].Err = err
+				return
+			}
+			ret[i].Err = c.Publish(ctx, event)
+		}()
+	}
+	wg.Wait()
+
+	return ret
+}
+
+// Subscribe sends filters to relays and merges the events deduplicated by ID.
+// Empty relays means SubscribeRelays. It fails only if no relay is available,
+// and the relays failed to connect are reported by Errors.
+func (p *RelayPool) Subscribe(
+	ctx context.Context,
+	filters []*mocrelay.ReqFilter,
+	relays ...string,
+) (*PoolSubscription, error) {
+	routes := make(map[string][]*mocrelay.ReqFilter)
+	if len(relays) == 0 {
+		routes = p.SubscribeRelays(filters)
+	} else {
+		for _, url := range relays {
+			routes[url] = filters
+		}
+	}
+
+	ps := newPoolSubscription(p, p.opt.client().subscriptionBuffer(), p.opt.seenSize())
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	subs := make(map[string]*Subscription)
+	for url, fils := range routes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			c, err := p.Client(ctx, url)
+			if err == nil {
+				var sub *Subscription
+				sub, err = c.Subscribe(ctx, fils)
+				if err == nil {
+					mu.Lock()
+					subs[url] = sub
+					mu.Unlock()
+					return
+				}
+			}
+
+			mu.Lock()
+			ps.errs[url] = err
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if len(subs) == 0 {
+		errs := []error{ErrNoRelay}
+		for _, err := range ps.errs {
+			errs = append(errs, err)
+		}
+		return nil, errors.Join(errs...)
+	}
+
+	ps.start(subs)
+	return ps, nil
+}
+
+// PoolEvent is an event received from a relay.
+type PoolEvent struct {
+	Relay string
+	Event *mocrelay.Event
+}
+
+// PoolSubscription is a subscription to many relays.
+type PoolSubscription struct {
+	pool   *RelayPool
+	events chan *PoolEvent
+	eose   chan struct{}
+	done   chan struct{}
+	// closing stops forwarding on Close.
+	closing   chan struct{}
+	closeOnce sync.Once
+	seen      *seenIDs
+
+	// subs and errs are not modified after start.
+	subs map[string]*Subscription
+	errs map[string]error
+}
+
+func newPoolSubscription(p *RelayPool, buflen, seenSize int) *PoolSubscription {
+	return &PoolSubscription{
+		pool:    p,
+		events:  make(chan *PoolEvent, buflen),
+		eose:    make(chan struct{}),
+		done:    make(chan struct{}),
+		closing: make(chan struct{}),
+		seen:    newSeenIDs(seenSize),
+		errs:    make(map[string]error),
+	}
+}
+
+func (ps *PoolSubscription) start(subs map[string]*Subscription) {
+	ps.subs = subs
+
+	var eoseWG, forwardWG sync.WaitGroup
+	for url, sub := range subs {
+		eoseWG.Add(1)
+		forwardWG.Add(1)
+		go func() {
+			defer forwardWG.Done()
+			ps.forward(url, sub, sync.OnceFunc(eoseWG.Done))
+		}()
+	}
+
+	go func() {
+		eoseWG.Wait()
+		close(ps.eose)
+	}()
+	go func() {
+		forwardWG.Wait()
+		close(ps.events)
+		close(ps.done)
+	}()
+}
+
+// forward sends the events of sub to Events and calls eose after the events before EOSE,
+// so that EOSE is closed after the stored events are in Events.
+func (ps *PoolSubscription) forward(url string, sub *Subscription, eose func()) {
+	defer eose()
+
+	eoseCh := sub.EOSE()
+	for {
+		select {
+		case event, ok := <-sub.Events():
+			if !ok || !ps.send(url, event) {
+				return
+			}
+
+		case <-eoseCh:
+			// The events before EOSE are already buffered in sub.
+			for drained := false; !drained; {
+				select {
+				case event, ok := <-sub.Events():
+					if !ok || !ps.send(url, event) {
+						return
+					}
+				default:
+					drained = true
+				}
+			}
+			eose()
+			eoseCh = nil
+		}
+	}
+}
+
+// send reports false if the subscription is closing.
+func (ps *PoolSubscription) send(url string, event *mocrelay.Event) bool {
+	if !ps.seen.add(event.ID) {
+		return true
+	}
+	if event.Kind == mocrelay.RelayListEventKind {
+		if ok, _ := event.Verify(); ok {
+			ps.pool.SetRelayList(event)
+		}
+	}
+
+	select {
+	case <-ps.closing:
+		return false
+	case ps.events <- &PoolEvent{Relay: url, Event: event}:
+		return true
+	}
+}
+
+// Events returns the deduplicated events. It is closed when all the relays end.
+func (ps *PoolSubscription) Events() <-chan *PoolEvent { return ps.events }
+
+// EOSE returns a channel closed when all the relays have sent EOSE or ended.
+func (ps *PoolSubscription) EOSE() <-chan struct{} { return ps.eose }
+
+// Done returns a channel closed when all the relays end.
+func (ps *PoolSubscription) Done() <-chan struct{} { return ps.done }
+
+// Relays returns the subscription of each relay.
+func (ps *PoolSubscription) Relays() map[string]*Subscription { return ps.subs }
+
+// Errors returns the errors of the relays which failed to subscribe.
+func (ps *PoolSubscription) Errors() map[string]error { return ps.errs }
+
+// Close closes the subscriptions of all the relays.
+func (ps *PoolSubscription) Close(ctx context.Context) error {
+	ps.closeOnce.Do(func() { close(ps.closing) })
+
+	var errs []error
+	for _, sub := range ps.subs {
+		errs = append(errs, sub.Close(ctx))
+	}
+	<-ps.done
+	return errors.Join(errs...)
+}
+
+// seenIDs remembers the latest event IDs.
+type seenIDs struct {
+	mu   sync.Mutex
+	ids  map[string]struct{}
+	ring []string
+	next int
+}
+
+func newSeenIDs(size int) *seenIDs {
+	return &seenIDs{
+		ids:  make(map[string]struct{}, size),
+		ring: make([]string, size),
+	}
+}
+
+// add reports whether id is new.
+func (s *seenIDs) add(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.ids[id]; ok {
+		return false
+	}
+
+	if old := s.ring[s.next]; old != "" {
+		delete(s.ids, old)
+	}
+	s.ring[s.next] = id
+	s.next = (s.next + 1) % len(s.ring)
+	s.ids[id] = struct{}{}
+	return true
+}
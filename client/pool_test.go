@@ -0,0 +1,185 @@
+package client
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/high-moctane/mocrelay"
+	"github.com/high-moctane/mocrelay/mocrelaytest"
+)
+
+func newTestKeypair(t *testing.T, n int) *mocrelay.Keypair {
+	key, err := mocrelay.ParseKeypair(strings.Repeat("0", 63) + string(rune('0'+n)))
+	require.NoError(t, err)
+	return key
+}
+
+func relayListEvent(key *mocrelay.Keypair, tags ...mocrelay.Tag) *mocrelay.Event {
+	return mocrelaytest.SignedEvent(key, &mocrelay.Event{
+		CreatedAt: time.Now().Unix(),
+		Kind:      mocrelay.RelayListEventKind,
+		Tags:      tags,
+	})
+}
+
+func TestRelayPool_routing(t *testing.T) {
+	alice, bob := newTestKeypair(t, 1), newTestKeypair(t, 2)
+
+	p := NewRelayPool(&PoolOption{
+		DefaultRelays:      []string{"wss://default.example.com"},
+		MaxRelaysPerPubkey: 2,
+	})
+	defer p.Close()
+
+	require.NoError(t, p.SetRelayList(relayListEvent(alice,
+		mocrelay.Tag{"r", "wss://a1.example.com"},
+		mocrelay.Tag{"r", "wss://a2.example.com", "write"},
+		mocrelay.Tag{"r", "wss://a3.example.com", "write"},
+		mocrelay.Tag{"r", "wss://ainbox.example.com", "read"},
+	)))
+	require.NoError(t, p.SetRelayList(relayListEvent(bob,
+		mocrelay.Tag{"r", "wss://a1.example.com", "write"},
+		mocrelay.Tag{"r", "wss://binbox.example.com", "read"},
+	)))
+
+	// An older list is ignored.
+	old := mocrelaytest.SignedEvent(bob, &mocrelay.Event{
+		CreatedAt: 1,
+		Kind:      mocrelay.RelayListEventKind,
+		Tags:      []mocrelay.Tag{{"r", "wss://old.example.com"}},
+	})
+	require.NoError(t, p.SetRelayList(old))
+	assert.Equal(t, []string{"wss://binbox.example.com"}, p.RelayList(bob.Pubkey()).Read)
+
+	t.Run("publish", func(t *testing.T) {
+		event := &mocrelay.Event{
+			Pubkey: alice.Pubkey(),
+			Tags:   []mocrelay.Tag{{"p", bob.Pubkey()}, {"p", "unknown"}},
+		}
+		assert.Equal(t, []string{
+			"wss://a1.example.com",
+			"wss://a2.example.com",
+			"wss://binbox.example.com",
+			"wss://default.example.com",
+		}, p.PublishRelays(event))
+	})
+
+	t.Run("subscribe", func(t *testing.T) {
+		byAuthors := &mocrelay.ReqFilter{
+			Authors: []string{alice.Pubkey(), bob.Pubkey(), "unknown"},
+			Kinds:   []int64{1},
+		}
+		mentions := &mocrelay.ReqFilter{
+			Tags: map[string][]string{"#p": {alice.Pubkey()}},
+		}
+		global := &mocrelay.ReqFilter{Kinds: []int64{0}}
+
+		got := p.SubscribeRelays([]*mocrelay.ReqFilter{byAuthors, mentions, global})
+		assert.Equal(t, map[string][]*mocrelay.ReqFilter{
+			"wss://a1.example.com": {
+				{Authors: []string{alice.Pubkey(), bob.Pubkey()}, Kinds: []int64{1}},
+				mentions,
+			},
+			"wss://a2.example.com": {{
+				Authors: []string{alice.Pubkey()},
+				Kinds:   []int64{1},
+			}},
+			"wss://default.example.com": {
+				{Authors: []string{"unknown"}, Kinds: []int64{1}},
+				global,
+			},
+			"wss://ainbox.example.com": {mentions},
+		}, got)
+	})
+}
+
+func TestRelayPool_PublishSubscribe(t *testing.T) {
+	srv1 := mocrelaytest.NewServer(t, nil, nil)
+	srv2 := mocrelaytest.NewServer(t, nil, nil)
+	alice, bob := newTestKeypair(t, 1), newTestKeypair(t, 2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	p := NewRelayPool(&PoolOption{DefaultRelays: []string{srv1.WSURL()}})
+	defer p.Close()
+
+	// Bob writes to srv2. The list itself goes to the default relay.
+	list := relayListEvent(bob, mocrelay.Tag{"r", srv2.WSURL(), "write"})
+	for _, res := range p.Publish(ctx, list) {
+		require.NoError(t, res.Err)
+	}
+
+	note := mocrelaytest.SignedEvent(bob, &mocrelay.Event{Kind: 1, Content: "hello"})
+	res := p.Publish(ctx, note)
+	require.Len(t, res, 1)
+	u, _ := mocrelay.NormalizeRelayURL(srv2.WSURL())
+	assert.Equal(t, PublishResult{Relay: u}, res[0])
+
+	// The same event on both relays is received once.
+	both := mocrelaytest.SignedEvent(alice, &mocrelay.Event{Kind: 1, Content: "both"})
+	for _, res := range p.Publish(ctx, both, srv1.WSURL(), srv2.WSURL()) {
+		require.NoError(t, res.Err)
+	}
+
+	sub, err := p.Subscribe(
+		ctx,
+		[]*mocrelay.ReqFilter{{Kinds: []int64{1}}},
+		srv1.WSURL(),
+		srv2.WSURL(),
+	)
+	require.NoError(t, err)
+
+	var got []string
+	func() {
+		for {
+			select {
+			case ev := <-sub.Events():
+				got = append(got, ev.Event.Content)
+			case <-sub.EOSE():
+				// Drain the events received before EOSE.
+				for {
+					select {
+					case ev := <-sub.Events():
+						got = append(got, ev.Event.Content)
+					default:
+						return
+					}
+				}
+			case <-ctx.Done():
+				t.Fatal("no eose")
+			}
+		}
+	}()
+	assert.ElementsMatch(t, []string{"hello", "both"}, got)
+
+	// Bob's notes are routed to srv2 only.
+	outbox, err := p.Subscribe(ctx, []*mocrelay.ReqFilter{{Authors: []string{bob.Pubkey()}}})
+	require.NoError(t, err)
+	assert.Len(t, outbox.Relays(), 1)
+	assert.Contains(t, outbox.Relays(), u)
+
+	require.NoError(t, sub.Close(ctx))
+	require.NoError(t, outbox.Close(ctx))
+	_, ok := <-sub.Events()
+	assert.False(t, ok)
+}
+
+func TestRelayPool_Subscribe_noRelay(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	p := NewRelayPool(nil)
+	defer p.Close()
+
+	_, err := p.Subscribe(ctx, []*mocrelay.ReqFilter{{}})
+	assert.ErrorIs(t, err, ErrNoRelay)
+
+	_, err = p.Subscribe(ctx, []*mocrelay.ReqFilter{{}}, "ws://127.0.0.1:1")
+	assert.ErrorIs(t, err, ErrNoRelay)
+}
@@ -0,0 +1,133 @@
+package client
+
+import (
+	"context"
+	"sync"
+
+	"github.com/high-moctane/mocrelay"
+)
+
+// Subscription is a REQ to the relay.
+type Subscription struct {
+	ID      string
+	Filters []*mocrelay.ReqFilter
+
+	c      *Client
+	events chan *mocrelay.Event
+	eose   chan struct{}
+	done   chan struct{}
+
+	mu        sync.Mutex
+	eosed     bool
+	ended     bool
+	authRetry bool
+	err       error
+}
+
+func newSubscription(
+	c *Client,
+	id string,
+	filters []*mocrelay.ReqFilter,
+	buflen int,
+) *Subscription {
+	return &Subscription{
+		ID:      id,
+		Filters: filters,
+		c:       c,
+		events:  make(chan *mocrelay.Event, buflen),
+		eose:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// Events returns the events of the subscription. It is closed when the subscription ends.
+func (sub *Subscription) Events() <-chan *mocrelay.Event { return sub.events }
+
+// EOSE returns a channel closed when the relay sends the first EOSE.
+func (sub *Subscription) EOSE() <-chan struct{} { return sub.eose }
+
+// Done returns a channel closed when the subscription ends.
+func (sub *Subscription) Done() <-chan struct{} { return sub.done }
+
+// Err returns why the subscription ended. It is *ClosedError if the relay closed it.
+func (sub *Subscription) Err() error {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	return sub.err
+}
+
+// Close sends CLOSE and ends the subscription.
+func (sub *Subscription) Close(ctx context.Context) error {
+	if sub.c.removeSub(sub.ID) == nil {
+		return nil
+	}
+	sub.end(nil)
+
+	sub.c.mu.Lock()
+	connected := sub.c.conn != nil
+	sub.c.mu.Unlock()
+	if !connected {
+		return nil
+	}
+	return sub.c.send(ctx, "CLOSE", sub.ID)
+}
+
+func (sub *Subscription) reqMsg() []any {
+	msg := make([]any, 0, len(sub.Filters)+2)
+	msg = append(msg, "REQ", sub.ID)
+	for _, fil := range sub.Filters {
+		msg = append(msg, fil)
+	}
+	return msg
+}
+
+// deliver sends event to Events. It drops event if the buffer is full.
+func (sub *Subscription) deliver(event *mocrelay.Event) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	if sub.ended {
+		return
+	}
+	select {
+	case sub.events <- event:
+	default:
+		sub.c.logWarn("subscription buffer is full: event dropped", "sub", sub.ID, "id", event.ID)
+	}
+}
+
+func (sub *Subscription) setEOSE() {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	if !sub.eosed {
+		sub.eosed = true
+		close(sub.eose)
+	}
+}
+
+// markAuthRetry reports whether the subscription can be sent again after AUTH.
+// It is allowed only once.
+func (sub *Subscription) markAuthRetry() bool {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	if sub.authRetry {
+		return false
+	}
+	sub.authRetry = true
+	return true
+}
+
+func (sub *Subscription) end(err error) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	if sub.ended {
+		return
+	}
+	sub.ended = true
+	sub.err = err
+	close(sub.events)
+	close(sub.done)
+}
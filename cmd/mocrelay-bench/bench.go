@@ -0,0 +1,506 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"nhooyr.io/websocket"
+
+	"github.com/high-moctane/mocrelay"
+)
+
+const (
+	defaultDuration = 10 * time.Second
+	defaultDrain    = 2 * time.Second
+
+	// maxMessageLength is the max length of a message from the relay.
+	maxMessageLength = 1 << 20
+
+	// tickInterval is the interval at which events due by the rate are published.
+	tickInterval = 10 * time.Millisecond
+)
+
+type benchConfig struct {
+	URL         string
+	Conns       int
+	Subs        int
+	Rate        float64
+	Duration    time.Duration
+	Drain       time.Duration
+	Kind        int64
+	ContentSize int
+	MetricsURL  string
+}
+
+func (cfg *benchConfig) validate() error {
+	if cfg.Conns < 1 {
+		return fmt.Errorf("conns must be positive but got %d", cfg.Conns)
+	}
+	if cfg.Subs < 0 {
+		return fmt.Errorf("subs must not be negative but got %d", cfg.Subs)
+	}
+	if cfg.Rate <= 0 {
+		return fmt.Errorf("rate must be positive but got %g", cfg.Rate)
+	}
+	if cfg.Duration <= 0 {
+		return fmt.Errorf("duration must be positive but got %s", cfg.Duration)
+	}
+	return nil
+}
+
+type benchResult struct {
+	Published int64 `json:"published"`
+	Accepted  int64 `json:"accepted"`
+	Rejected  int64 `json:"rejected"`
+	// RejectReasons are the numbers of rejected events by the messages of OK.
+	RejectReasons map[string]int64 `json:"reject_reasons,omitempty"`
+	// Expected is the number of deliveries of the accepted events to the connections
+	// with subscriptions. An event is counted once per connection since relays may
+	// send it once even if multiple subscriptions of the connection match it.
+	Expected  int64 `json:"expected"`
+	Delivered int64 `json:"delivered"`
+
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+	// AcceptRate and DeliveryRate are per second.
+	AcceptRate   float64 `json:"accept_rate"`
+	DeliveryRate float64 `json:"delivery_rate"`
+
+	// OKLatency is from publishing to OK and DeliveryLatency is from publishing to EVENT.
+	OKLatency       latencySummary `json:"ok_latency_ms"`
+	DeliveryLatency latencySummary `json:"delivery_latency_ms"`
+
+	ClientHeapBytes uint64 `json:"client_heap_bytes"`
+	// RelayMemory are the memory metrics of the relay if the metrics endpoint is given.
+	RelayMemory map[string]float64 `json:"relay_memory,omitempty"`
+}
+
+func (res *benchResult) Print(w io.Writer) {
+	fmt.Fprintf(w, "published:  %d events in %.1fs\n", res.Published, res.ElapsedSeconds)
+	fmt.Fprintf(
+		w,
+		"accepted:   %d (%.1f/s), rejected %d\n",
+		res.Accepted,
+		res.AcceptRate,
+		res.Rejected,
+	)
+	for reason, n := range res.RejectReasons {
+		fmt.Fprintf(w, "  %d: %s\n", n, reason)
+	}
+	fmt.Fprintf(
+		w,
+		"delivered:  %d/%d (%.1f/s)\n",
+		res.Delivered,
+		res.Expected,
+		res.DeliveryRate,
+	)
+	fmt.Fprintf(w, "ok latency:       %s\n", res.OKLatency)
+	fmt.Fprintf(w, "delivery latency: %s\n", res.DeliveryLatency)
+	fmt.Fprintf(w, "client heap: %d bytes\n", res.ClientHeapBytes)
+	for _, name := range relayMemoryMetrics {
+		if v, ok := res.RelayMemory[name]; ok {
+			fmt.Fprintf(w, "relay %s: %.0f\n", name, v)
+		}
+	}
+}
+
+// latencySummary is in milliseconds.
+type latencySummary struct {
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+	Max float64 `json:"max"`
+}
+
+func (s latencySummary) String() string {
+	return fmt.Sprintf("p50 %.2fms, p90 %.2fms, p99 %.2fms, max %.2fms", s.P50, s.P90, s.P99, s.Max)
+}
+
+func summarizeLatency(ms []float64) latencySummary {
+	if len(ms) == 0 {
+		return latencySummary{}
+	}
+	slices.Sort(ms)
+	at := func(p float64) float64 {
+		return ms[min(len(ms)-1, int(p*float64(len(ms))))]
+	}
+	return latencySummary{P50: at(0.5), P90: at(0.9), P99: at(0.99), Max: ms[len(ms)-1]}
+}
+
+// latencies records latencies in milliseconds from multiple goroutines.
+type latencies struct {
+	mu sync.Mutex
+	ms []float64
+}
+
+func (l *latencies) Add(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.ms = append(l.ms, float64(d)/float64(time.Millisecond))
+}
+
+func (l *latencies) Summary() latencySummary {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return summarizeLatency(l.ms)
+}
+
+// bench is the state of a run shared by the connections.
+type bench struct {
+	cfg   *benchConfig
+	runID string
+	key   *mocrelay.Keypair
+
+	// map[eventID]time.Time
+	sent sync.Map
+
+	published, accepted, rejected, delivered atomic.Int64
+	eose                                     chan struct{}
+
+	okLatency, deliveryLatency latencies
+
+	mu sync.Mutex
+	// map[reason]count
+	rejectReasons map[string]int64
+}
+
+func runBench(ctx context.Context, cfg *benchConfig) (*benchResult, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	runID, err := randomHex(8)
+	if err != nil {
+		return nil, err
+	}
+	sk, err := randomHex(32)
+	if err != nil {
+		return nil, err
+	}
+	key, err := mocrelay.ParseKeypair(sk)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &bench{
+		cfg:   cfg,
+		runID: runID,
+		key:   key,
+		eose:  make(chan struct{}, cfg.Subs),
+
+		rejectReasons: make(map[string]int64),
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	conns := make([]*websocket.Conn, cfg.Conns)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for i := range conns {
+		conn, _, err := websocket.Dial(ctx, cfg.URL, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to dial %s: %w", cfg.URL, err)
+		}
+		conn.SetReadLimit(maxMessageLength)
+		defer conn.Close(websocket.StatusNormalClosure, "")
+		conns[i] = conn
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.read(ctx, conn)
+		}()
+	}
+
+	if err := b.subscribe(ctx, conns); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	if err := b.publish(ctx, conns); err != nil {
+		return nil, err
+	}
+	elapsed := time.Since(start)
+
+	b.drain(ctx)
+	cancel()
+	wg.Wait()
+
+	res := &benchResult{
+		Published:       b.published.Load(),
+		Accepted:        b.accepted.Load(),
+		Rejected:        b.rejected.Load(),
+		RejectReasons:   b.rejectReasons,
+		Expected:        b.accepted.Load() * b.subscribedConns(),
+		Delivered:       b.delivered.Load(),
+		ElapsedSeconds:  elapsed.Seconds(),
+		AcceptRate:      float64(b.accepted.Load()) / elapsed.Seconds(),
+		DeliveryRate:    float64(b.delivered.Load()) / elapsed.Seconds(),
+		OKLatency:       b.okLatency.Summary(),
+		DeliveryLatency: b.deliveryLatency.Summary(),
+	}
+
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	res.ClientHeapBytes = ms.HeapInuse
+
+	if cfg.MetricsURL != "" {
+		res.RelayMemory, err = fetchRelayMemory(context.WithoutCancel(ctx), cfg.MetricsURL)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+// subscribedConns returns the number of connections with subscriptions.
+func (b *bench) subscribedConns() int64 {
+	return int64(min(b.cfg.Subs, b.cfg.Conns))
+}
+
+// subscribe sends REQs of the run round robin and waits for their EOSEs.
+func (b *bench) subscribe(ctx context.Context, conns []*websocket.Conn) error {
+	filter, err := mocrelay.NewFilterBuilder().
+		Kinds(b.cfg.Kind).
+		Tag("t", b.runID).
+		Since(time.Now()).
+		Build()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < b.cfg.Subs; i++ {
+		subID := fmt.Sprintf("bench-%d", i)
+		if err := send(ctx, conns[i%len(conns)], "REQ", subID, filter); err != nil {
+			return err
+		}
+	}
+
+	timeout := time.NewTimer(10 * time.Second)
+	defer timeout.Stop()
+	for i := 0; i < b.cfg.Subs; i++ {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timeout.C:
+			return errors.New("timeout waiting for EOSE")
+		case <-b.eose:
+		}
+	}
+	return nil
+}
+
+// publish sends events at the rate round robin until the duration elapses.
+func (b *bench) publish(ctx context.Context, conns []*websocket.Conn) error {
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+
+	start := time.Now()
+	var n int64
+	for {
+		select {
+		case <-ctx.Done():
+			// Interrupted runs report what they have done.
+			return nil
+		case now := <-ticker.C:
+			elapsed := now.Sub(start)
+			if elapsed > b.cfg.Duration {
+				return nil
+			}
+			for due := int64(elapsed.Seconds() * b.cfg.Rate); n < due; n++ {
+				event, err := b.newEvent(n)
+				if err != nil {
+					return err
+				}
+				b.sent.Store(event.ID, time.Now())
+				if err := send(ctx, conns[n%int64(len(conns))], "EVENT", event); err != nil {
+					return err
+				}
+				b.published.Add(1)
+			}
+		}
+	}
+}
+
+func (b *bench) newEvent(seq int64) (*mocrelay.Event, error) {
+	content := strconv.FormatInt(seq, 10)
+	if pad := b.cfg.ContentSize - len(content); pad > 0 {
+		content += " " + strings.Repeat("x", pad-1)
+	}
+
+	event := &mocrelay.Event{
+		CreatedAt: time.Now().Unix(),
+		Kind:      b.cfg.Kind,
+		Tags:      []mocrelay.Tag{{"t", b.runID}},
+		Content:   content,
+	}
+	if err := b.key.Sign(event); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// drain waits until all the expected events are delivered or the drain timeout.
+func (b *bench) drain(ctx context.Context) {
+	timeout := time.NewTimer(b.cfg.Drain)
+	defer timeout.Stop()
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+
+	for {
+		done := b.accepted.Load()+b.rejected.Load() == b.published.Load() &&
+			b.delivered.Load() >= b.accepted.Load()*b.subscribedConns()
+		if done {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-timeout.C:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// read records OK, EVENT and EOSE of conn until ctx is done.
+func (b *bench) read(ctx context.Context, conn *websocket.Conn) {
+	// map[eventID]received
+	seen := make(map[string]bool)
+
+	for {
+		_, msg, err := conn.Read(ctx)
+		if err != nil {
+			return
+		}
+		now := time.Now()
+
+		var elems []json.RawMessage
+		if err := json.Unmarshal(msg, &elems); err != nil || len(elems) == 0 {
+			continue
+		}
+		var label string
+		if err := json.Unmarshal(elems[0], &label); err != nil {
+			continue
+		}
+
+		switch {
+		case label == "OK" && len(elems) >= 4:
+			var id, reason string
+			var ok bool
+			json.Unmarshal(elems[1], &id)
+			json.Unmarshal(elems[2], &ok)
+			json.Unmarshal(elems[3], &reason)
+			sentAt, found := b.sent.Load(id)
+			if !found {
+				continue
+			}
+			b.okLatency.Add(now.Sub(sentAt.(time.Time)))
+			if ok {
+				b.accepted.Add(1)
+			} else {
+				b.rejected.Add(1)
+				b.mu.Lock()
+				b.rejectReasons[reason]++
+				b.mu.Unlock()
+			}
+
+		case label == "EVENT" && len(elems) >= 3:
+			var event struct {
+				ID string `json:"id"`
+			}
+			json.Unmarshal(elems[2], &event)
+			if seen[event.ID] {
+				continue
+			}
+			seen[event.ID] = true
+			if sentAt, found := b.sent.Load(event.ID); found {
+				b.deliveryLatency.Add(now.Sub(sentAt.(time.Time)))
+				b.delivered.Add(1)
+			}
+
+		case label == "EOSE":
+			select {
+			case b.eose <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+func send(ctx context.Context, conn *websocket.Conn, msg ...any) error {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if err := conn.Write(ctx, websocket.MessageText, b); err != nil {
+		return fmt.Errorf("failed to send %s: %w", msg[0], err)
+	}
+	return nil
+}
+
+// relayMemoryMetrics are the metrics reported as the memory of the relay.
+var relayMemoryMetrics = []string{
+	"process_resident_memory_bytes",
+	"go_memstats_heap_inuse_bytes",
+	"mocrelay_cache_bytes",
+}
+
+// fetchRelayMemory returns relayMemoryMetrics from the prometheus endpoint.
+func fetchRelayMemory(ctx context.Context, url string) (map[string]float64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch metrics: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch metrics: %s", resp.Status)
+	}
+	return parseMetrics(resp.Body, relayMemoryMetrics)
+}
+
+// parseMetrics returns the values of the unlabeled metrics of names
+// in the prometheus text format.
+func parseMetrics(r io.Reader, names []string) (map[string]float64, error) {
+	ret := make(map[string]float64)
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		name, value, ok := strings.Cut(sc.Text(), " ")
+		if !ok || !slices.Contains(names, name) {
+			continue
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			continue
+		}
+		ret[name] = v
+	}
+	return ret, sc.Err()
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate random bytes: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
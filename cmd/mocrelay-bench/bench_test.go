@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/high-moctane/mocrelay/mocrelaytest"
+)
+
+func TestRunBench(t *testing.T) {
+	srv := mocrelaytest.NewServer(t, nil, nil)
+
+	res, err := runBench(context.Background(), &benchConfig{
+		URL:         srv.WSURL(),
+		Conns:       2,
+		Subs:        3,
+		Rate:        100,
+		Duration:    200 * time.Millisecond,
+		Drain:       time.Second,
+		Kind:        1,
+		ContentSize: 10,
+	})
+	require.NoError(t, err)
+
+	assert.Positive(t, res.Published)
+	assert.Equal(t, res.Published, res.Accepted)
+	assert.Equal(t, res.Accepted*2, res.Expected)
+	assert.Equal(t, res.Expected, res.Delivered)
+	assert.Positive(t, res.DeliveryLatency.Max)
+}
+
+func TestSummarizeLatency(t *testing.T) {
+	ms := make([]float64, 100)
+	for i := range ms {
+		ms[i] = float64(100 - i)
+	}
+	assert.Equal(t, latencySummary{P50: 51, P90: 91, P99: 100, Max: 100}, summarizeLatency(ms))
+	assert.Equal(t, latencySummary{}, summarizeLatency(nil))
+}
+
+func TestParseMetrics(t *testing.T) {
+	text := `# HELP process_resident_memory_bytes Resident memory size in bytes.
+# TYPE process_resident_memory_bytes gauge
+process_resident_memory_bytes 1.2e+07
+go_memstats_heap_inuse_bytes 4096
+mocrelay_events_total{type="EVENT"} 3
+`
+	got, err := parseMetrics(strings.NewReader(text), relayMemoryMetrics)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{
+		"process_resident_memory_bytes": 1.2e7,
+		"go_memstats_heap_inuse_bytes":  4096,
+	}, got)
+}
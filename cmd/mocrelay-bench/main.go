@@ -0,0 +1,68 @@
+// Command mocrelay-bench generates load on a relay and reports its performance.
+//
+// It opens connections, keeps subscriptions on them, and publishes signed events
+// at a target rate. The events are tagged with a random run id so that the
+// subscriptions receive only the events of the run.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"os/signal"
+
+	"github.com/spf13/cobra"
+)
+
+func main() {
+	var cfg benchConfig
+	var jsonOutput bool
+
+	cmd := &cobra.Command{
+		Use:           "mocrelay-bench",
+		Short:         "Load generator for nostr relays",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Args:          cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt)
+			defer stop()
+
+			res, err := runBench(ctx, &cfg)
+			if err != nil {
+				return err
+			}
+
+			if jsonOutput {
+				enc := json.NewEncoder(cmd.OutOrStdout())
+				enc.SetIndent("", "  ")
+				return enc.Encode(res)
+			}
+			res.Print(cmd.OutOrStdout())
+			return nil
+		},
+	}
+
+	f := cmd.Flags()
+	f.StringVar(&cfg.URL, "relay", "ws://localhost:8234", "relay url")
+	f.IntVar(&cfg.Conns, "conns", 10, "number of connections")
+	f.IntVar(&cfg.Subs, "subs", 10, "number of subscriptions spread over the connections")
+	f.Float64Var(&cfg.Rate, "rate", 100, "events published per second")
+	f.DurationVar(&cfg.Duration, "duration", defaultDuration, "duration of publishing")
+	f.DurationVar(&cfg.Drain, "drain", defaultDrain, "time to wait for deliveries after publishing")
+	f.Int64Var(&cfg.Kind, "kind", 1, "kind of published events")
+	f.IntVar(&cfg.ContentSize, "content-size", 100, "bytes of the content of published events")
+	f.StringVar(
+		&cfg.MetricsURL,
+		"metrics",
+		"",
+		"prometheus endpoint of the relay to report its memory such as http://localhost:8234/metrics",
+	)
+	f.BoolVar(&jsonOutput, "json", false, "print the result in JSON")
+
+	if err := cmd.ExecuteContext(context.Background()); err != nil {
+		slog.Error("mocrelay-bench terminated", "err", err)
+		os.Exit(1)
+	}
+}
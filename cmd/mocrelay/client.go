@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"nhooyr.io/websocket"
+)
+
+// relayConn is a minimal nostr client for import and export.
+type relayConn struct {
+	conn *websocket.Conn
+}
+
+func dialRelay(ctx context.Context, url string) (*relayConn, error) {
+	conn, _, err := websocket.Dial(ctx, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial %s: %w", url, err)
+	}
+	conn.SetReadLimit(maxEventLineLength)
+	return &relayConn{conn: conn}, nil
+}
+
+func (c *relayConn) Close() error {
+	return c.conn.Close(websocket.StatusNormalClosure, "")
+}
+
+func (c *relayConn) send(ctx context.Context, msg ...any) error {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return c.conn.Write(ctx, websocket.MessageText, b)
+}
+
+// recv returns the label and the rest elements of the next server message.
+func (c *relayConn) recv(ctx context.Context) (string, []json.RawMessage, error) {
+	typ, b, err := c.conn.Read(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	if typ != websocket.MessageText {
+		return "", nil, errors.New("received non-text message")
+	}
+
+	var elems []json.RawMessage
+	if err := json.Unmarshal(b, &elems); err != nil || len(elems) == 0 {
+		return "", nil, fmt.Errorf("invalid server message: %s", b)
+	}
+
+	var label string
+	if err := json.Unmarshal(elems[0], &label); err != nil {
+		return "", nil, fmt.Errorf("invalid server message: %s", b)
+	}
+
+	return label, elems[1:], nil
+}
@@ -0,0 +1,809 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/high-moctane/mocrelay"
+	"gopkg.in/yaml.v3"
+)
+
+const configEnvPrefix = "MOCRELAY_"
+
+var ErrInvalidConfig = errors.New("invalid config")
+
+type Config struct {
+	Listen   ListenConfig   `yaml:"listen"   toml:"listen"`
+	Info     InfoConfig     `yaml:"info"     toml:"info"`
+	Limits   LimitsConfig   `yaml:"limits"   toml:"limits"`
+	Storage  StorageConfig  `yaml:"storage"  toml:"storage"`
+	Policy   PolicyConfig   `yaml:"policy"   toml:"policy"`
+	Firehose FirehoseConfig `yaml:"firehose" toml:"firehose"`
+	Sink     SinkConfig     `yaml:"sink"     toml:"sink"`
+	Admin    AdminConfig    `yaml:"admin"    toml:"admin"`
+	Metrics  MetricsConfig  `yaml:"metrics"  toml:"metrics"`
+	Log      LogConfig      `yaml:"log"      toml:"log"`
+	Tenants  []TenantConfig `yaml:"tenants"  toml:"tenants"`
+}
+
+type ListenConfig struct {
+	Addr           string         `yaml:"addr"                toml:"addr"`
+	CanonicalURL   string         `yaml:"canonical_url"       toml:"canonical_url"`
+	ProxyProtocol  bool           `yaml:"proxy_protocol"      toml:"proxy_protocol"`
+	TrustedProxies []string       `yaml:"trusted_proxies"     toml:"trusted_proxies"`
+	Autocert       AutocertConfig `yaml:"autocert"            toml:"autocert"`
+	// Mode is one of "read-write", "read-only", "write-only" and "broadcast-only".
+	Mode string `yaml:"mode"                toml:"mode"`
+	// ReassembleMessages accepts several client messages in a websocket message
+	// and a client message split across websocket messages.
+	ReassembleMessages bool `yaml:"reassemble_messages" toml:"reassemble_messages"`
+	// CBOR accepts the "nostr.cbor" subprotocol on which messages are CBOR encoded.
+	CBOR bool `yaml:"cbor"                toml:"cbor"`
+}
+
+type AutocertConfig struct {
+	Hosts    []string `yaml:"hosts"     toml:"hosts"`
+	CacheDir string   `yaml:"cache_dir" toml:"cache_dir"`
+	Email    string   `yaml:"email"     toml:"email"`
+}
+
+type InfoConfig struct {
+	Name        string `yaml:"name"            toml:"name"`
+	Description string `yaml:"description"     toml:"description"`
+	Pubkey      string `yaml:"pubkey"          toml:"pubkey"`
+	Contact     string `yaml:"contact"         toml:"contact"`
+	// SecretKey or SecretKeyFile is the relay key in hex or nsec.
+	// Pubkey defaults to its pubkey.
+	SecretKey     string `yaml:"secret_key"      toml:"secret_key"`
+	SecretKeyFile string `yaml:"secret_key_file" toml:"secret_key_file"`
+}
+
+type LimitsConfig struct {
+	MaxConnections      int   `yaml:"max_connections"             toml:"max_connections"`
+	MaxConnectionsPerIP int   `yaml:"max_connections_per_ip"      toml:"max_connections_per_ip"`
+	MaxMessageLength    int64 `yaml:"max_message_length"          toml:"max_message_length"`
+	MaxSubscriptions    int   `yaml:"max_subscriptions"           toml:"max_subscriptions"`
+	MaxFilters          int   `yaml:"max_filters"                 toml:"max_filters"`
+	MaxLimit            int   `yaml:"max_limit"                   toml:"max_limit"`
+	MaxEventTags        int   `yaml:"max_event_tags"              toml:"max_event_tags"`
+	MaxContentLength    int   `yaml:"max_content_length"          toml:"max_content_length"`
+	SendQueueSize       int   `yaml:"send_queue_size"             toml:"send_queue_size"`
+	// WriteCoalesceInterval holds websocket frames for the interval to write them together.
+	// Zero writes each frame immediately.
+	WriteCoalesceInterval time.Duration `yaml:"write_coalesce_interval"     toml:"write_coalesce_interval"`
+	// FanoutWorkers delivers live events to subscriptions in turn on the workers.
+	// Zero delivers them synchronously.
+	FanoutWorkers int `yaml:"fanout_workers"              toml:"fanout_workers"`
+	// FanoutQueueSize is the max number of pending live events of each subscription
+	// with FanoutWorkers. Zero means 1000.
+	FanoutQueueSize int `yaml:"fanout_queue_size"           toml:"fanout_queue_size"`
+	// EgressBytesPerSec is the max outbound bytes per second of a connection.
+	// Zero means no limit.
+	EgressBytesPerSec int64 `yaml:"egress_bytes_per_sec"        toml:"egress_bytes_per_sec"`
+	// EgressGlobalBytesPerSec is the max outbound bytes per second of the relay.
+	// Zero means no limit.
+	EgressGlobalBytesPerSec int64 `yaml:"egress_global_bytes_per_sec" toml:"egress_global_bytes_per_sec"`
+	// MsgRateLimit rejects client messages exceeding the budget of the connection.
+	MsgRateLimit MsgRateLimitConfig `yaml:"msg_rate_limit"              toml:"msg_rate_limit"`
+
+	// MaxFilterIDs and the others cap the number of values of each filter condition.
+	// Zero means no limit.
+	MaxFilterIDs       int `yaml:"max_filter_ids"        toml:"max_filter_ids"`
+	MaxFilterAuthors   int `yaml:"max_filter_authors"    toml:"max_filter_authors"`
+	MaxFilterKinds     int `yaml:"max_filter_kinds"      toml:"max_filter_kinds"`
+	MaxFilterTagValues int `yaml:"max_filter_tag_values" toml:"max_filter_tag_values"`
+}
+
+type MsgRateLimitConfig struct {
+	// Rate is the number of tokens refilled per second. Zero disables the limit.
+	Rate float64 `yaml:"rate"        toml:"rate"`
+	// Burst is the max number of tokens. Zero means Rate but at least 1.
+	Burst float64 `yaml:"burst"       toml:"burst"`
+	// Costs of messages by type. Zero means 1.
+	EventCost float64 `yaml:"event_cost"  toml:"event_cost"`
+	ReqCost   float64 `yaml:"req_cost"    toml:"req_cost"`
+	CountCost float64 `yaml:"count_cost"  toml:"count_cost"`
+	CloseCost float64 `yaml:"close_cost"  toml:"close_cost"`
+	AuthCost  float64 `yaml:"auth_cost"   toml:"auth_cost"`
+	// FilterCost is the additional cost of each filter of REQ and COUNT after the first one.
+	FilterCost float64 `yaml:"filter_cost" toml:"filter_cost"`
+	// Notice sends a vendor NOTICE with the retry information for each rejected message.
+	Notice bool `yaml:"notice"      toml:"notice"`
+}
+
+type StorageConfig struct {
+	// Backend is the event storage, "memory" or "mysql".
+	Backend   string `yaml:"backend"                toml:"backend"`
+	CacheSize int    `yaml:"cache_size"             toml:"cache_size"`
+	// CacheMaxBytes is the approximate memory budget of the memory backend. Zero means no limit.
+	CacheMaxBytes int64 `yaml:"cache_max_bytes"        toml:"cache_max_bytes"`
+	// CacheShards is the number of partitions of the memory backend with their own locks.
+	// Zero means 1.
+	CacheShards int `yaml:"cache_shards"           toml:"cache_shards"`
+	// SnapshotPath is the file the memory backend periodically writes the cache to
+	// and loads it from on startup. Empty disables snapshots.
+	SnapshotPath string `yaml:"snapshot_path"          toml:"snapshot_path"`
+	// SnapshotInterval is the interval of snapshots of the memory backend.
+	SnapshotInterval time.Duration `yaml:"snapshot_interval"      toml:"snapshot_interval"`
+	// DSN is the data source name of the mysql backend.
+	DSN string `yaml:"dsn"                    toml:"dsn"`
+	// Namespace isolates the events of the mysql backend from the other relays
+	// sharing the database. Tenants default to their host.
+	Namespace string `yaml:"namespace"              toml:"namespace"`
+	// DisableCompression stores events of the mysql backend as plain JSON instead of zstd.
+	DisableCompression bool `yaml:"disable_compression"    toml:"disable_compression"`
+	// PartitionWindow is the created_at range of a partition of the mysql backend.
+	// Zero means 7 days.
+	PartitionWindow time.Duration `yaml:"partition_window"       toml:"partition_window"`
+	// Retention drops events of the mysql backend older than it. Zero keeps events forever.
+	Retention time.Duration `yaml:"retention"              toml:"retention"`
+	// QueryCacheTTL caches REQ and COUNT results of the mysql backend in memory.
+	// Zero disables the cache.
+	QueryCacheTTL time.Duration `yaml:"query_cache_ttl"        toml:"query_cache_ttl"`
+	// QueryTimeout limits each REQ query. Zero means no timeout.
+	QueryTimeout time.Duration `yaml:"query_timeout"          toml:"query_timeout"`
+	// MaxConcurrentQueries limits REQ queries run at the same time. Zero means GOMAXPROCS.
+	MaxConcurrentQueries int `yaml:"max_concurrent_queries" toml:"max_concurrent_queries"`
+	// CountCacheTTL is how long COUNT results are cached. Zero disables the cache.
+	CountCacheTTL time.Duration `yaml:"count_cache_ttl"        toml:"count_cache_ttl"`
+	// InternStrings is the max number of strings such as pubkeys and relay hints
+	// shared by the events in memory. Zero disables interning.
+	InternStrings int `yaml:"intern_strings"         toml:"intern_strings"`
+	// TombstonePath is the file the ids of deleted and purged events are kept in
+	// so that they are not accepted or served again, also after restarts.
+	// Empty disables tombstones.
+	TombstonePath string `yaml:"tombstone_path"         toml:"tombstone_path"`
+}
+
+func (c *StorageConfig) interner() *mocrelay.Interner {
+	if c.InternStrings == 0 {
+		return nil
+	}
+	return mocrelay.NewInterner(c.InternStrings)
+}
+
+type PolicyConfig struct {
+	// CreatedAtPast and CreatedAtFuture are the allowed skew of created_at.
+	CreatedAtPast   time.Duration `yaml:"created_at_past"   toml:"created_at_past"`
+	CreatedAtFuture time.Duration `yaml:"created_at_future" toml:"created_at_future"`
+
+	NoticeRate        time.Duration `yaml:"notice_rate"         toml:"notice_rate"`
+	NoticeBurst       int           `yaml:"notice_burst"        toml:"notice_burst"`
+	NoticeDedupWindow time.Duration `yaml:"notice_dedup_window" toml:"notice_dedup_window"`
+	MaxInvalidMsgs    int           `yaml:"max_invalid_msgs"    toml:"max_invalid_msgs"`
+
+	VerifierWorkers int `yaml:"verifier_workers"    toml:"verifier_workers"`
+	// VerifierCacheSize is the number of cached signature results. Zero disables the cache.
+	VerifierCacheSize int `yaml:"verifier_cache_size" toml:"verifier_cache_size"`
+
+	// BanMaxStrikes enables temporary bans of IPs and pubkeys which commit
+	// as many offenses in 10 minutes. Bans are persisted to BanPath if not empty.
+	BanMaxStrikes int    `yaml:"ban_max_strikes" toml:"ban_max_strikes"`
+	BanPath       string `yaml:"ban_path"        toml:"ban_path"`
+
+	// WoTSeeds enables the web-of-trust policy starting from the pubkeys.
+	WoTSeeds []string `yaml:"wot_seeds" toml:"wot_seeds"`
+	WoTDepth int      `yaml:"wot_depth" toml:"wot_depth"`
+
+	// ExpensiveFilterAction is "reject", "cap" or "auth" for REQ with full scan filters
+	// or time windows wider than ExpensiveFilterMaxWindow. Empty disables the guard.
+	ExpensiveFilterAction      string        `yaml:"expensive_filter_action"       toml:"expensive_filter_action"`
+	ExpensiveFilterMaxWindow   time.Duration `yaml:"expensive_filter_max_window"   toml:"expensive_filter_max_window"`
+	ExpensiveFilterCappedLimit int64         `yaml:"expensive_filter_capped_limit" toml:"expensive_filter_capped_limit"`
+
+	// Auth enables NIP-42 authentication. Challenges expire after AuthChallengeTTL
+	// (10 minutes if zero) and connections are challenged again every AuthReauth if not zero.
+	Auth             bool          `yaml:"auth"               toml:"auth"`
+	AuthChallengeTTL time.Duration `yaml:"auth_challenge_ttl" toml:"auth_challenge_ttl"`
+	AuthReauth       time.Duration `yaml:"auth_reauth"        toml:"auth_reauth"`
+	// AuthRelayURLs are the aliases of listen.canonical_url accepted in AUTH events,
+	// such as the onion address of a clearnet relay.
+	AuthRelayURLs []string `yaml:"auth_relay_urls"    toml:"auth_relay_urls"`
+
+	// AuthReqKinds are the kinds only authenticated participants can read, such as 4 and 1059
+	// for NIP-17 DM relays. AuthEventKinds are the kinds whose EVENTs need authentication.
+	// Both require Auth.
+	AuthReqKinds   []int64 `yaml:"auth_req_kinds"   toml:"auth_req_kinds"`
+	AuthEventKinds []int64 `yaml:"auth_event_kinds" toml:"auth_event_kinds"`
+
+	// IDMatch is how ids and authors of filters match events, "exact" (64 hex chars)
+	// or "prefix" (also hex prefixes as the legacy NIP-01).
+	IDMatch string `yaml:"id_match"         toml:"id_match"`
+	// TagNamePattern is the regexp of tag names (without "#") allowed in filters and indexed
+	// by the mysql backend. Empty allows single letters only.
+	TagNamePattern string `yaml:"tag_name_pattern" toml:"tag_name_pattern"`
+}
+
+func (cfg *PolicyConfig) idMatchMode() mocrelay.IDMatchMode {
+	if cfg.IDMatch == "prefix" {
+		return mocrelay.IDMatchPrefix
+	}
+	return mocrelay.IDMatchExact
+}
+
+// tagNamePattern returns the compiled TagNamePattern or nil if it is not configured.
+func (cfg *PolicyConfig) tagNamePattern() (*regexp.Regexp, error) {
+	if cfg.TagNamePattern == "" {
+		return nil, nil
+	}
+	return regexp.Compile(cfg.TagNamePattern)
+}
+
+type FirehoseConfig struct {
+	// Tokens are the bearer tokens of consumers of /firehose.
+	// Empty disables the endpoint.
+	Tokens []string `yaml:"tokens" toml:"tokens"`
+}
+
+type SinkConfig struct {
+	// ClickHouseDSN enables mirroring accepted events into ClickHouse for analytics.
+	ClickHouseDSN           string        `yaml:"clickhouse_dsn"            toml:"clickhouse_dsn"`
+	ClickHouseBatchSize     int           `yaml:"clickhouse_batch_size"     toml:"clickhouse_batch_size"`
+	ClickHouseFlushInterval time.Duration `yaml:"clickhouse_flush_interval" toml:"clickhouse_flush_interval"`
+	// AckPolicy is when OK is sent, "cache" (before the sinks write events)
+	// or "durable" (after the sinks write events).
+	AckPolicy string `yaml:"ack_policy"                toml:"ack_policy"`
+	// AckTimeout is the timeout of writing an event into the sinks.
+	AckTimeout time.Duration `yaml:"ack_timeout"               toml:"ack_timeout"`
+}
+
+type AdminConfig struct {
+	// Pubkeys are allowed to call the admin API at /admin with NIP-98 authorization.
+	// Empty disables the endpoint.
+	Pubkeys []string `yaml:"pubkeys" toml:"pubkeys"`
+	// URL is the public URL of /admin checked against NIP-98 events.
+	// If empty, it is built from requests.
+	URL string `yaml:"url"     toml:"url"`
+}
+
+type MetricsConfig struct {
+	// Sink is where the relay metrics are reported, "prometheus", "statsd" or "otlp".
+	// The other metrics are always served at /metrics.
+	Sink string `yaml:"sink"          toml:"sink"`
+	// StatsdAddr is the UDP address of the statsd server such as "localhost:8125".
+	StatsdAddr string `yaml:"statsd_addr"   toml:"statsd_addr"`
+	// OTLPEndpoint is the OTLP/HTTP endpoint of the collector such as "http://localhost:4318".
+	OTLPEndpoint string `yaml:"otlp_endpoint" toml:"otlp_endpoint"`
+	// Interval is the interval of sending metrics to statsd or otlp.
+	// Zero means the default of the sink.
+	Interval time.Duration `yaml:"interval"      toml:"interval"`
+}
+
+type LogConfig struct {
+	// Level is one of "debug", "info", "warn" and "error".
+	Level string `yaml:"level"      toml:"level"`
+	// Format is "text" or "json".
+	Format string `yaml:"format"     toml:"format"`
+	// AuditPath is the file EVENT decisions are appended to. Empty disables it.
+	AuditPath string `yaml:"audit_path" toml:"audit_path"`
+}
+
+// keypair returns the relay key or nil if it is not configured.
+// TenantConfig is a virtual relay served on the same listener to the requests for Host,
+// such as another community hosted on the same machine. Non-zero fields of Info, Storage
+// and Policy override the top-level ones. Limits apply to each relay. The verifier is
+// shared and configured by the top-level policy only.
+type TenantConfig struct {
+	// Host is the host name of the relay such as "community.example.com".
+	Host string `yaml:"host"          toml:"host"`
+	// CanonicalURL is listen.canonical_url of the relay. It is not inherited,
+	// and neither is admin.url which is built from requests.
+	CanonicalURL string        `yaml:"canonical_url" toml:"canonical_url"`
+	Info         InfoConfig    `yaml:"info"          toml:"info"`
+	Storage      StorageConfig `yaml:"storage"       toml:"storage"`
+	Policy       PolicyConfig  `yaml:"policy"        toml:"policy"`
+}
+
+// tenant returns the config of the relay of t.
+func (cfg *Config) tenant(t *TenantConfig) *Config {
+	ret := *cfg
+	ret.Tenants = nil
+	ret.Listen.CanonicalURL = t.CanonicalURL
+	ret.Admin.URL = ""
+
+	overlay := func(dst, src any) {
+		d, s := reflect.ValueOf(dst).Elem(), reflect.ValueOf(src).Elem()
+		for i := 0; i < s.NumField(); i++ {
+			if !s.Field(i).IsZero() {
+				d.Field(i).Set(s.Field(i))
+			}
+		}
+	}
+	overlay(&ret.Info, &t.Info)
+	overlay(&ret.Storage, &t.Storage)
+	overlay(&ret.Policy, &t.Policy)
+	if t.Storage.Namespace == "" {
+		ret.Storage.Namespace = mocrelay.NormalizeHost(t.Host)
+	}
+
+	return &ret
+}
+
+func (cfg *InfoConfig) keypair() (*mocrelay.Keypair, error) {
+	switch {
+	case cfg.SecretKey != "":
+		return mocrelay.ParseKeypair(cfg.SecretKey)
+	case cfg.SecretKeyFile != "":
+		return mocrelay.LoadKeypairFile(cfg.SecretKeyFile)
+	default:
+		return nil, nil
+	}
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		Listen: ListenConfig{
+			Addr: "localhost:8234",
+		},
+		Info: InfoConfig{
+			Name:        "mocrelay",
+			Description: "moctane's nostr relay",
+		},
+		Storage: StorageConfig{
+			Backend:          "memory",
+			CacheSize:        100,
+			SnapshotInterval: 5 * time.Minute,
+		},
+		Sink: SinkConfig{
+			AckPolicy: "cache",
+		},
+		Policy: PolicyConfig{
+			CreatedAtPast:     5 * time.Minute,
+			CreatedAtFuture:   1 * time.Minute,
+			NoticeRate:        time.Second,
+			NoticeBurst:       5,
+			NoticeDedupWindow: 10 * time.Second,
+			MaxInvalidMsgs:    50,
+			IDMatch:           "exact",
+		},
+		Metrics: MetricsConfig{
+			Sink: "prometheus",
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "text",
+		},
+	}
+}
+
+// LoadConfig loads the default config, overwritten by the file at path (if not empty)
+// and then by MOCRELAY_* environment variables, and validates it.
+func LoadConfig(path string) (*Config, error) {
+	cfg := DefaultConfig()
+
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.loadEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func (cfg *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open config: %w", err)
+	}
+	defer f.Close()
+
+	switch ext := filepath.Ext(path); ext {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(f)
+		dec.KnownFields(true)
+		if err := dec.Decode(cfg); err != nil {
+			return fmt.Errorf("%w: %s: %w", ErrInvalidConfig, path, err)
+		}
+
+	case ".toml":
+		md, err := toml.NewDecoder(f).Decode(cfg)
+		if err != nil {
+			return fmt.Errorf("%w: %s: %w", ErrInvalidConfig, path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("%w: %s: unknown fields %v", ErrInvalidConfig, path, undecoded)
+		}
+
+	default:
+		return fmt.Errorf("%w: unsupported config file extension %q", ErrInvalidConfig, ext)
+	}
+
+	return nil
+}
+
+// loadEnv overwrites fields by environment variables named after the yaml keys,
+// e.g. MOCRELAY_LIMITS_MAX_CONNECTIONS. Lists are comma-separated.
+func (cfg *Config) loadEnv(lookup func(string) (string, bool)) error {
+	var errs []error
+
+	var walk func(v reflect.Value, prefix string)
+	walk = func(v reflect.Value, prefix string) {
+		for i := 0; i < v.NumField(); i++ {
+			name := prefix + strings.ToUpper(v.Type().Field(i).Tag.Get("yaml"))
+			field := v.Field(i)
+
+			if field.Kind() == reflect.Struct {
+				walk(field, name+"_")
+				continue
+			}
+
+			s, ok := lookup(name)
+			if !ok {
+				continue
+			}
+			if err := setConfigField(field, s); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			}
+		}
+	}
+	walk(reflect.ValueOf(cfg).Elem(), configEnvPrefix)
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%w: %w", ErrInvalidConfig, errors.Join(errs...))
+	}
+	return nil
+}
+
+func (cfg *Config) validateTenants(check func(ok bool, key, format string, a ...any)) {
+	if len(cfg.Tenants) == 0 {
+		return
+	}
+
+	var autocertHosts []string
+	for _, host := range cfg.Listen.Autocert.Hosts {
+		autocertHosts = append(autocertHosts, mocrelay.NormalizeHost(host))
+	}
+
+	// Relays must not share their storages or ban lists.
+	files := make(map[string]string)
+	unique := func(key, v string) {
+		if v == "" {
+			return
+		}
+		other, ok := files[v]
+		check(!ok, key, "is also used by %s", other)
+		files[v] = key
+	}
+	uniqueStorage := func(prefix string, cfg *Config) {
+		if cfg.Storage.Backend == "mysql" {
+			unique(
+				prefix+"storage.namespace",
+				cfg.Storage.DSN+"\x00"+cfg.Storage.Namespace,
+			)
+		}
+		unique(prefix+"storage.snapshot_path", cfg.Storage.SnapshotPath)
+		unique(prefix+"storage.tombstone_path", cfg.Storage.TombstonePath)
+		unique(prefix+"policy.ban_path", cfg.Policy.BanPath)
+	}
+	uniqueStorage("", cfg)
+
+	hosts := make(map[string]bool)
+	for i := range cfg.Tenants {
+		t := &cfg.Tenants[i]
+		key := fmt.Sprintf("tenants[%d]", i)
+
+		host := mocrelay.NormalizeHost(t.Host)
+		check(host != "", key+".host", "must not be empty")
+		check(!hosts[host], key+".host", "duplicated host %q", t.Host)
+		hosts[host] = true
+		check(
+			len(autocertHosts) == 0 || slices.Contains(autocertHosts, host),
+			key+".host",
+			"must be in listen.autocert.hosts",
+		)
+
+		tcfg := cfg.tenant(t)
+		if err := tcfg.Validate(); err != nil {
+			check(false, key, "%v", err)
+		}
+		uniqueStorage(key+".", tcfg)
+	}
+}
+
+func setConfigField(field reflect.Value, s string) error {
+	switch field.Interface().(type) {
+	case time.Duration:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+
+	case string:
+		field.SetString(s)
+
+	case bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+
+	case float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+
+	case int, int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+
+	case []string:
+		var ss []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				ss = append(ss, item)
+			}
+		}
+		field.Set(reflect.ValueOf(ss))
+
+	case []int64:
+		var ns []int64
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			n, err := strconv.ParseInt(item, 10, 64)
+			if err != nil {
+				return err
+			}
+			ns = append(ns, n)
+		}
+		field.Set(reflect.ValueOf(ns))
+
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+
+	return nil
+}
+
+func (cfg *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, key, format string, a ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, a...)))
+		}
+	}
+	nonNegative := func(key string, v int64) {
+		check(v >= 0, key, "must not be negative but got %d", v)
+	}
+
+	check(cfg.Listen.Addr != "", "listen.addr", "must not be empty")
+	if _, err := mocrelay.NewRealIPResolver(cfg.Listen.TrustedProxies); err != nil {
+		check(false, "listen.trusted_proxies", "%v", err)
+	}
+	check(
+		cfg.Listen.Autocert.CacheDir == "" || len(cfg.Listen.Autocert.Hosts) > 0,
+		"listen.autocert.cache_dir",
+		"needs listen.autocert.hosts",
+	)
+
+	check(
+		!cfg.Listen.ProxyProtocol || len(cfg.Listen.Autocert.Hosts) == 0,
+		"listen.proxy_protocol",
+		"cannot be used with listen.autocert",
+	)
+
+	if _, err := mocrelay.ParseRelayMode(cfg.Listen.Mode); err != nil {
+		check(false, "listen.mode", "%v", err)
+	}
+
+	check(
+		cfg.Info.SecretKey == "" || cfg.Info.SecretKeyFile == "",
+		"info.secret_key",
+		"cannot be used with info.secret_key_file",
+	)
+	if cfg.Info.SecretKey != "" {
+		if _, err := mocrelay.ParseKeypair(cfg.Info.SecretKey); err != nil {
+			check(false, "info.secret_key", "%v", err)
+		}
+	}
+
+	nonNegative("limits.max_connections", int64(cfg.Limits.MaxConnections))
+	nonNegative("limits.max_connections_per_ip", int64(cfg.Limits.MaxConnectionsPerIP))
+	nonNegative("limits.max_message_length", cfg.Limits.MaxMessageLength)
+	nonNegative("limits.max_subscriptions", int64(cfg.Limits.MaxSubscriptions))
+	nonNegative("limits.max_filters", int64(cfg.Limits.MaxFilters))
+	nonNegative("limits.max_limit", int64(cfg.Limits.MaxLimit))
+	nonNegative("limits.max_event_tags", int64(cfg.Limits.MaxEventTags))
+	nonNegative("limits.max_content_length", int64(cfg.Limits.MaxContentLength))
+	nonNegative("limits.max_filter_ids", int64(cfg.Limits.MaxFilterIDs))
+	nonNegative("limits.max_filter_authors", int64(cfg.Limits.MaxFilterAuthors))
+	nonNegative("limits.max_filter_kinds", int64(cfg.Limits.MaxFilterKinds))
+	nonNegative("limits.max_filter_tag_values", int64(cfg.Limits.MaxFilterTagValues))
+	nonNegative("limits.send_queue_size", int64(cfg.Limits.SendQueueSize))
+	nonNegative("limits.write_coalesce_interval", int64(cfg.Limits.WriteCoalesceInterval))
+	nonNegative("limits.fanout_workers", int64(cfg.Limits.FanoutWorkers))
+	nonNegative("limits.fanout_queue_size", int64(cfg.Limits.FanoutQueueSize))
+	nonNegative("limits.egress_bytes_per_sec", cfg.Limits.EgressBytesPerSec)
+	nonNegative("limits.egress_global_bytes_per_sec", cfg.Limits.EgressGlobalBytesPerSec)
+	rl := cfg.Limits.MsgRateLimit
+	for _, f := range []struct {
+		key string
+		v   float64
+	}{
+		{"rate", rl.Rate},
+		{"burst", rl.Burst},
+		{"event_cost", rl.EventCost},
+		{"req_cost", rl.ReqCost},
+		{"count_cost", rl.CountCost},
+		{"close_cost", rl.CloseCost},
+		{"auth_cost", rl.AuthCost},
+		{"filter_cost", rl.FilterCost},
+	} {
+		check(f.v >= 0, "limits.msg_rate_limit."+f.key, "must not be negative but got %g", f.v)
+	}
+
+	check(
+		cfg.Storage.Backend == "memory" || cfg.Storage.Backend == "mysql",
+		"storage.backend",
+		"must be \"memory\" or \"mysql\" but got %q",
+		cfg.Storage.Backend,
+	)
+	check(
+		cfg.Storage.Backend != "mysql" || cfg.Storage.DSN != "",
+		"storage.dsn",
+		"must not be empty for the mysql backend",
+	)
+	check(
+		len(cfg.Storage.Namespace) <= 64,
+		"storage.namespace",
+		"must be at most 64 bytes but got %d",
+		len(cfg.Storage.Namespace),
+	)
+	check(
+		cfg.Storage.CacheSize > 0,
+		"storage.cache_size",
+		"must be positive but got %d",
+		cfg.Storage.CacheSize,
+	)
+	check(
+		cfg.Storage.SnapshotPath == "" || cfg.Storage.SnapshotInterval > 0,
+		"storage.snapshot_interval",
+		"must be positive with storage.snapshot_path",
+	)
+
+	nonNegative("storage.partition_window", int64(cfg.Storage.PartitionWindow))
+	nonNegative("storage.retention", int64(cfg.Storage.Retention))
+	nonNegative("storage.cache_max_bytes", cfg.Storage.CacheMaxBytes)
+	nonNegative("storage.cache_shards", int64(cfg.Storage.CacheShards))
+	nonNegative("storage.intern_strings", int64(cfg.Storage.InternStrings))
+	nonNegative("storage.query_cache_ttl", int64(cfg.Storage.QueryCacheTTL))
+	nonNegative("storage.query_timeout", int64(cfg.Storage.QueryTimeout))
+	nonNegative("storage.max_concurrent_queries", int64(cfg.Storage.MaxConcurrentQueries))
+	nonNegative("storage.count_cache_ttl", int64(cfg.Storage.CountCacheTTL))
+
+	nonNegative("sink.clickhouse_batch_size", int64(cfg.Sink.ClickHouseBatchSize))
+	nonNegative("sink.clickhouse_flush_interval", int64(cfg.Sink.ClickHouseFlushInterval))
+	nonNegative("sink.ack_timeout", int64(cfg.Sink.AckTimeout))
+	check(
+		cfg.Sink.AckPolicy == "cache" || cfg.Sink.AckPolicy == "durable",
+		"sink.ack_policy",
+		"must be \"cache\" or \"durable\" but got %q",
+		cfg.Sink.AckPolicy,
+	)
+
+	check(
+		cfg.Policy.IDMatch == "exact" || cfg.Policy.IDMatch == "prefix",
+		"policy.id_match",
+		"must be \"exact\" or \"prefix\" but got %q",
+		cfg.Policy.IDMatch,
+	)
+	if _, err := cfg.Policy.tagNamePattern(); err != nil {
+		check(false, "policy.tag_name_pattern", "%v", err)
+	}
+	nonNegative("policy.created_at_past", int64(cfg.Policy.CreatedAtPast))
+	nonNegative("policy.created_at_future", int64(cfg.Policy.CreatedAtFuture))
+	nonNegative("policy.notice_rate", int64(cfg.Policy.NoticeRate))
+	nonNegative("policy.notice_burst", int64(cfg.Policy.NoticeBurst))
+	nonNegative("policy.notice_dedup_window", int64(cfg.Policy.NoticeDedupWindow))
+	nonNegative("policy.max_invalid_msgs", int64(cfg.Policy.MaxInvalidMsgs))
+	nonNegative("policy.verifier_workers", int64(cfg.Policy.VerifierWorkers))
+	nonNegative("policy.verifier_cache_size", int64(cfg.Policy.VerifierCacheSize))
+	nonNegative("policy.ban_max_strikes", int64(cfg.Policy.BanMaxStrikes))
+	nonNegative("policy.wot_depth", int64(cfg.Policy.WoTDepth))
+	switch mocrelay.ExpensiveFilterAction(cfg.Policy.ExpensiveFilterAction) {
+	case "",
+		mocrelay.ExpensiveFilterReject,
+		mocrelay.ExpensiveFilterCap,
+		mocrelay.ExpensiveFilterAuth:
+	default:
+		check(
+			false,
+			"policy.expensive_filter_action",
+			"must be reject, cap or auth but got %q",
+			cfg.Policy.ExpensiveFilterAction,
+		)
+	}
+	nonNegative("policy.expensive_filter_max_window", int64(cfg.Policy.ExpensiveFilterMaxWindow))
+	nonNegative("policy.expensive_filter_capped_limit", cfg.Policy.ExpensiveFilterCappedLimit)
+	nonNegative("policy.auth_challenge_ttl", int64(cfg.Policy.AuthChallengeTTL))
+	nonNegative("policy.auth_reauth", int64(cfg.Policy.AuthReauth))
+	for _, u := range cfg.Policy.AuthRelayURLs {
+		_, ok := mocrelay.NormalizeRelayURL(u)
+		check(ok, "policy.auth_relay_urls", "invalid relay url %q", u)
+	}
+	for _, kind := range cfg.Policy.AuthReqKinds {
+		nonNegative("policy.auth_req_kinds", kind)
+	}
+	for _, kind := range cfg.Policy.AuthEventKinds {
+		nonNegative("policy.auth_event_kinds", kind)
+	}
+	// Nobody could read or write the kinds without the AUTH middleware.
+	check(
+		cfg.Policy.Auth || len(cfg.Policy.AuthReqKinds) == 0,
+		"policy.auth_req_kinds",
+		"requires policy.auth",
+	)
+	check(
+		cfg.Policy.Auth || len(cfg.Policy.AuthEventKinds) == 0,
+		"policy.auth_event_kinds",
+		"requires policy.auth",
+	)
+
+	switch cfg.Metrics.Sink {
+	case "prometheus":
+	case "statsd":
+		check(
+			cfg.Metrics.StatsdAddr != "",
+			"metrics.statsd_addr",
+			"must not be empty for the statsd sink",
+		)
+	case "otlp":
+		check(
+			cfg.Metrics.OTLPEndpoint != "",
+			"metrics.otlp_endpoint",
+			"must not be empty for the otlp sink",
+		)
+	default:
+		check(
+			false,
+			"metrics.sink",
+			"must be prometheus, statsd or otlp but got %q",
+			cfg.Metrics.Sink,
+		)
+	}
+	nonNegative("metrics.interval", int64(cfg.Metrics.Interval))
+
+	cfg.validateTenants(check)
+
+	switch cfg.Log.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		check(false, "log.level", "must be debug, info, warn or error but got %q", cfg.Log.Level)
+	}
+	check(
+		cfg.Log.Format == "text" || cfg.Log.Format == "json",
+		"log.format",
+		"must be text or json but got %q",
+		cfg.Log.Format,
+	)
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%w: %w", ErrInvalidConfig, errors.Join(errs...))
+	}
+	return nil
+}
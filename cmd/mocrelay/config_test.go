@@ -0,0 +1,351 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	yamlPath := writeTestConfig(t, "mocrelay.yaml", `
+listen:
+  addr: ":8080"
+  trusted_proxies: ["10.0.0.0/8"]
+limits:
+  max_connections: 1000
+policy:
+  created_at_past: 1h
+log:
+  format: json
+`)
+
+	tomlPath := writeTestConfig(t, "mocrelay.toml", `
+[listen]
+addr = ":8080"
+trusted_proxies = ["10.0.0.0/8"]
+
+[limits]
+max_connections = 1000
+
+[policy]
+created_at_past = "1h"
+
+[log]
+format = "json"
+`)
+
+	want := DefaultConfig()
+	want.Listen.Addr = ":8080"
+	want.Listen.TrustedProxies = []string{"10.0.0.0/8"}
+	want.Limits.MaxConnections = 1000
+	want.Policy.CreatedAtPast = time.Hour
+	want.Log.Format = "json"
+
+	for _, path := range []string{yamlPath, tomlPath} {
+		t.Run(filepath.Ext(path), func(t *testing.T) {
+			cfg, err := LoadConfig(path)
+			require.NoError(t, err)
+			assert.Equal(t, want, cfg)
+		})
+	}
+}
+
+func TestLoadConfig_default(t *testing.T) {
+	cfg, err := LoadConfig("")
+	require.NoError(t, err)
+	assert.Equal(t, DefaultConfig(), cfg)
+}
+
+func TestLoadConfig_unknownField(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"unknown.yaml", "listen:\n  adr: \":8080\"\n"},
+		{"unknown.toml", "[listen]\nadr = \":8080\"\n"},
+		{"unknown.json", "{}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadConfig(writeTestConfig(t, tt.name, tt.content))
+			assert.ErrorIs(t, err, ErrInvalidConfig)
+		})
+	}
+}
+
+func TestConfig_loadEnv(t *testing.T) {
+	env := map[string]string{
+		"MOCRELAY_LISTEN_ADDR":                ":9090",
+		"MOCRELAY_LISTEN_PROXY_PROTOCOL":      "true",
+		"MOCRELAY_LISTEN_AUTOCERT_HOSTS":      "a.example.com, b.example.com",
+		"MOCRELAY_LIMITS_MAX_MESSAGE_LENGTH":  "65536",
+		"MOCRELAY_POLICY_NOTICE_RATE":         "2s",
+		"MOCRELAY_LIMITS_MSG_RATE_LIMIT_RATE": "2.5",
+		"MOCRELAY_POLICY_AUTH_REQ_KINDS":      "4, 1059",
+	}
+	lookup := func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+
+	cfg := DefaultConfig()
+	require.NoError(t, cfg.loadEnv(lookup))
+
+	want := DefaultConfig()
+	want.Listen.Addr = ":9090"
+	want.Listen.ProxyProtocol = true
+	want.Listen.Autocert.Hosts = []string{"a.example.com", "b.example.com"}
+	want.Limits.MaxMessageLength = 65536
+	want.Policy.NoticeRate = 2 * time.Second
+	want.Limits.MsgRateLimit.Rate = 2.5
+	want.Policy.AuthReqKinds = []int64{4, 1059}
+	assert.Equal(t, want, cfg)
+
+	env = map[string]string{"MOCRELAY_LIMITS_MAX_CONNECTIONS": "many"}
+	err := DefaultConfig().loadEnv(lookup)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	assert.ErrorContains(t, err, "MOCRELAY_LIMITS_MAX_CONNECTIONS")
+}
+
+func TestConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(cfg *Config)
+		wantErr string
+	}{
+		{
+			name:   "default",
+			modify: func(cfg *Config) {},
+		},
+		{
+			name:    "empty addr",
+			modify:  func(cfg *Config) { cfg.Listen.Addr = "" },
+			wantErr: "listen.addr: must not be empty",
+		},
+		{
+			name:    "invalid trusted proxy",
+			modify:  func(cfg *Config) { cfg.Listen.TrustedProxies = []string{"proxy"} },
+			wantErr: "listen.trusted_proxies",
+		},
+		{
+			name: "proxy protocol with autocert",
+			modify: func(cfg *Config) {
+				cfg.Listen.ProxyProtocol = true
+				cfg.Listen.Autocert.Hosts = []string{"relay.example.com"}
+			},
+			wantErr: "listen.proxy_protocol: cannot be used with listen.autocert",
+		},
+		{
+			name:    "unknown mode",
+			modify:  func(cfg *Config) { cfg.Listen.Mode = "readonly" },
+			wantErr: "listen.mode: unknown relay mode",
+		},
+		{
+			name:    "unknown expensive filter action",
+			modify:  func(cfg *Config) { cfg.Policy.ExpensiveFilterAction = "drop" },
+			wantErr: "policy.expensive_filter_action: must be reject, cap or auth",
+		},
+		{
+			name:    "unknown id match",
+			modify:  func(cfg *Config) { cfg.Policy.IDMatch = "regexp" },
+			wantErr: `policy.id_match: must be "exact" or "prefix" but got "regexp"`,
+		},
+		{
+			name:    "invalid tag name pattern",
+			modify:  func(cfg *Config) { cfg.Policy.TagNamePattern = "[a-" },
+			wantErr: "policy.tag_name_pattern: error parsing regexp",
+		},
+		{
+			name:    "invalid secret key",
+			modify:  func(cfg *Config) { cfg.Info.SecretKey = "nsec" },
+			wantErr: "info.secret_key: invalid secret key",
+		},
+		{
+			name: "secret key with file",
+			modify: func(cfg *Config) {
+				cfg.Info.SecretKey = strings.Repeat("01", 32)
+				cfg.Info.SecretKeyFile = "key"
+			},
+			wantErr: "info.secret_key: cannot be used with info.secret_key_file",
+		},
+		{
+			name:    "negative limit",
+			modify:  func(cfg *Config) { cfg.Limits.MaxConnections = -1 },
+			wantErr: "limits.max_connections: must not be negative but got -1",
+		},
+		{
+			name:    "negative message cost",
+			modify:  func(cfg *Config) { cfg.Limits.MsgRateLimit.ReqCost = -1 },
+			wantErr: "limits.msg_rate_limit.req_cost: must not be negative but got -1",
+		},
+		{
+			name:    "unknown storage",
+			modify:  func(cfg *Config) { cfg.Storage.Backend = "sqlite" },
+			wantErr: `storage.backend: must be "memory" or "mysql" but got "sqlite"`,
+		},
+		{
+			name:    "mysql without dsn",
+			modify:  func(cfg *Config) { cfg.Storage.Backend = "mysql" },
+			wantErr: "storage.dsn: must not be empty for the mysql backend",
+		},
+		{
+			name: "too long namespace",
+			modify: func(cfg *Config) {
+				cfg.Storage.Namespace = strings.Repeat("a", 65)
+			},
+			wantErr: "storage.namespace: must be at most 64 bytes but got 65",
+		},
+		{
+			name:    "unknown ack policy",
+			modify:  func(cfg *Config) { cfg.Sink.AckPolicy = "never" },
+			wantErr: `sink.ack_policy: must be "cache" or "durable" but got "never"`,
+		},
+		{
+			name:    "auth kinds without auth",
+			modify:  func(cfg *Config) { cfg.Policy.AuthReqKinds = []int64{4} },
+			wantErr: "policy.auth_req_kinds: requires policy.auth",
+		},
+		{
+			name: "auth kinds",
+			modify: func(cfg *Config) {
+				cfg.Policy.Auth = true
+				cfg.Policy.AuthReqKinds = []int64{4, 1059}
+				cfg.Policy.AuthEventKinds = []int64{4}
+			},
+		},
+		{
+			name:    "invalid auth relay url",
+			modify:  func(cfg *Config) { cfg.Policy.AuthRelayURLs = []string{"relay.onion"} },
+			wantErr: `policy.auth_relay_urls: invalid relay url "relay.onion"`,
+		},
+		{
+			name:    "unknown metrics sink",
+			modify:  func(cfg *Config) { cfg.Metrics.Sink = "graphite" },
+			wantErr: `metrics.sink: must be prometheus, statsd or otlp but got "graphite"`,
+		},
+		{
+			name:    "statsd without addr",
+			modify:  func(cfg *Config) { cfg.Metrics.Sink = "statsd" },
+			wantErr: "metrics.statsd_addr: must not be empty for the statsd sink",
+		},
+		{
+			name: "tenants",
+			modify: func(cfg *Config) {
+				cfg.Tenants = []TenantConfig{{Host: "a.example.com"}, {Host: "b.example.com"}}
+			},
+		},
+		{
+			name:    "tenant without host",
+			modify:  func(cfg *Config) { cfg.Tenants = []TenantConfig{{}} },
+			wantErr: "tenants[0].host: must not be empty",
+		},
+		{
+			name: "duplicated tenant host",
+			modify: func(cfg *Config) {
+				cfg.Tenants = []TenantConfig{{Host: "a.example.com"}, {Host: "A.example.com."}}
+			},
+			wantErr: `tenants[1].host: duplicated host "A.example.com."`,
+		},
+		{
+			name: "tenant host without autocert",
+			modify: func(cfg *Config) {
+				cfg.Listen.Autocert.Hosts = []string{"example.com"}
+				cfg.Tenants = []TenantConfig{{Host: "a.example.com"}}
+			},
+			wantErr: "tenants[0].host: must be in listen.autocert.hosts",
+		},
+		{
+			name: "invalid tenant policy",
+			modify: func(cfg *Config) {
+				cfg.Tenants = []TenantConfig{
+					{Host: "a.example.com", Policy: PolicyConfig{IDMatch: "fuzzy"}},
+				}
+			},
+			wantErr: `tenants[0]: invalid config: policy.id_match: must be "exact" or "prefix"`,
+		},
+		{
+			name: "inherited snapshot path",
+			modify: func(cfg *Config) {
+				cfg.Storage.SnapshotPath = "cache.snapshot"
+				cfg.Tenants = []TenantConfig{{Host: "a.example.com"}}
+			},
+			wantErr: "tenants[0].storage.snapshot_path: is also used by storage.snapshot_path",
+		},
+		{
+			name: "tenants sharing mysql",
+			modify: func(cfg *Config) {
+				cfg.Storage.Backend = "mysql"
+				cfg.Storage.DSN = "mocrelay@/mocrelay"
+				cfg.Tenants = []TenantConfig{{Host: "a.example.com"}, {Host: "b.example.com"}}
+			},
+		},
+		{
+			name: "duplicated namespace",
+			modify: func(cfg *Config) {
+				cfg.Storage.Backend = "mysql"
+				cfg.Storage.DSN = "mocrelay@/mocrelay"
+				cfg.Tenants = []TenantConfig{
+					{Host: "a.example.com", Storage: StorageConfig{Namespace: "b.example.com"}},
+					{Host: "b.example.com"},
+				}
+			},
+			wantErr: "tenants[1].storage.namespace: is also used by tenants[0].storage.namespace",
+		},
+		{
+			name:    "invalid log level",
+			modify:  func(cfg *Config) { cfg.Log.Level = "trace" },
+			wantErr: `log.level: must be debug, info, warn or error but got "trace"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			tt.modify(cfg)
+
+			err := cfg.Validate()
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, ErrInvalidConfig)
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestConfig_tenant(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Listen.CanonicalURL = "wss://example.com"
+	cfg.Admin.URL = "https://example.com/admin"
+	cfg.Policy.Auth = true
+	cfg.Tenants = []TenantConfig{{
+		Host:    "a.example.com",
+		Info:    InfoConfig{Name: "a"},
+		Storage: StorageConfig{CacheSize: 10},
+		Policy:  PolicyConfig{WoTSeeds: []string{"seed"}},
+	}}
+
+	got := cfg.tenant(&cfg.Tenants[0])
+
+	want := DefaultConfig()
+	want.Info.Name = "a"
+	want.Storage.CacheSize = 10
+	want.Storage.Namespace = "a.example.com"
+	want.Policy.Auth = true
+	want.Policy.WoTSeeds = []string{"seed"}
+	assert.Equal(t, want, got)
+}
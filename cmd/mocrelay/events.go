@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/high-moctane/mocrelay"
+)
+
+const maxEventLineLength = 16 * 1024 * 1024
+
+// openInput opens name for reading. "" and "-" mean stdin.
+func openInput(name string) (io.ReadCloser, error) {
+	if name == "" || name == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(name)
+}
+
+// readEvents calls fn for each event in r, which is in JSONL form.
+// Empty lines are skipped.
+func readEvents(r io.Reader, fn func(line int, event *mocrelay.Event) error) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), maxEventLineLength)
+
+	for line := 1; sc.Scan(); line++ {
+		b := sc.Bytes()
+		if len(b) == 0 {
+			continue
+		}
+
+		var event mocrelay.Event
+		if err := json.Unmarshal(b, &event); err != nil {
+			return fmt.Errorf("line %d: invalid event: %w", line, err)
+		}
+		if err := fn(line, &event); err != nil {
+			return err
+		}
+	}
+
+	return sc.Err()
+}
+
+func writeEvent(w io.Writer, event *mocrelay.Event) error {
+	b, err := event.MarshalRaw()
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	_, err = w.Write(b)
+	return err
+}
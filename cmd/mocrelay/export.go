@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/high-moctane/mocrelay"
+	"github.com/spf13/cobra"
+)
+
+func newExportCmd() *cobra.Command {
+	var relayURL, filter string
+
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Write events stored in a relay to stdout in JSONL",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var fil mocrelay.ReqFilter
+			if err := json.Unmarshal([]byte(filter), &fil); err != nil {
+				return fmt.Errorf("invalid filter: %w", err)
+			}
+
+			ctx := cmd.Context()
+
+			conn, err := dialRelay(ctx, relayURL)
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+
+			const subID = "export"
+			if err := conn.send(ctx, "REQ", subID, json.RawMessage(filter)); err != nil {
+				return err
+			}
+
+			var n int
+			for {
+				label, elems, err := conn.recv(ctx)
+				if err != nil {
+					return err
+				}
+
+				switch label {
+				case "EVENT":
+					if len(elems) < 2 {
+						continue
+					}
+					var event mocrelay.Event
+					if err := json.Unmarshal(elems[1], &event); err != nil {
+						return fmt.Errorf("invalid event: %w", err)
+					}
+					if err := writeEvent(cmd.OutOrStdout(), &event); err != nil {
+						return err
+					}
+					n++
+
+				case "EOSE":
+					fmt.Fprintf(cmd.ErrOrStderr(), "exported %d events\n", n)
+					return conn.send(ctx, "CLOSE", subID)
+
+				case "CLOSED":
+					var reason string
+					if len(elems) >= 2 {
+						json.Unmarshal(elems[1], &reason)
+					}
+					return fmt.Errorf("subscription closed by relay: %s", reason)
+				}
+			}
+		},
+	}
+
+	cmd.Flags().StringVar(&relayURL, "relay", "ws://localhost:8234", "relay url")
+	cmd.Flags().StringVar(&filter, "filter", "{}", "REQ filter in JSON")
+
+	return cmd
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/high-moctane/mocrelay"
+	"github.com/spf13/cobra"
+)
+
+func newImportCmd() *cobra.Command {
+	var relayURL string
+	var timeout time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "import [file]",
+		Short: "Publish events in JSONL to a relay",
+		Long:  "Publish events in JSONL to a relay. Reads stdin if file is omitted or \"-\".",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var name string
+			if len(args) > 0 {
+				name = args[0]
+			}
+
+			r, err := openInput(name)
+			if err != nil {
+				return err
+			}
+			defer r.Close()
+
+			ctx := cmd.Context()
+
+			conn, err := dialRelay(ctx, relayURL)
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+
+			var accepted, rejected int
+			err = readEvents(r, func(line int, event *mocrelay.Event) error {
+				ok, msg, err := publish(ctx, conn, event, timeout)
+				if err != nil {
+					return fmt.Errorf("line %d: %w", line, err)
+				}
+				if ok {
+					accepted++
+				} else {
+					rejected++
+					fmt.Fprintf(cmd.OutOrStdout(), "line %d: %s: %s\n", line, event.ID, msg)
+				}
+				return nil
+			})
+
+			fmt.Fprintf(
+				cmd.ErrOrStderr(),
+				"imported %d events, %d rejected\n",
+				accepted,
+				rejected,
+			)
+			return err
+		},
+	}
+
+	cmd.Flags().StringVar(&relayURL, "relay", "ws://localhost:8234", "relay url")
+	cmd.Flags().DurationVar(&timeout, "timeout", 10*time.Second, "timeout to wait for each OK")
+
+	return cmd
+}
+
+// publish sends event and waits for its OK message.
+func publish(
+	ctx context.Context,
+	conn *relayConn,
+	event *mocrelay.Event,
+	timeout time.Duration,
+) (accepted bool, msg string, err error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if err := conn.send(ctx, "EVENT", event); err != nil {
+		return false, "", err
+	}
+
+	for {
+		label, elems, err := conn.recv(ctx)
+		if err != nil {
+			return false, "", err
+		}
+		if label != "OK" || len(elems) < 3 {
+			continue
+		}
+
+		var id string
+		if err := json.Unmarshal(elems[0], &id); err != nil || id != event.ID {
+			continue
+		}
+		if err := json.Unmarshal(elems[1], &accepted); err != nil {
+			return false, "", fmt.Errorf("invalid OK message: %w", err)
+		}
+		if err := json.Unmarshal(elems[2], &msg); err != nil {
+			return false, "", fmt.Errorf("invalid OK message: %w", err)
+		}
+		return accepted, msg, nil
+	}
+}
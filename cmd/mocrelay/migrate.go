@@ -0,0 +1,106 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/high-moctane/mocrelay/store/migrate"
+	"github.com/high-moctane/mocrelay/store/mysql"
+	"github.com/spf13/cobra"
+)
+
+func newMigrateCmd() *cobra.Command {
+	var configPath string
+	var status bool
+
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Apply schema migrations to the storage",
+		Long: "Apply pending schema migrations to the SQL storage backend in --config. " +
+			"serve also applies them on startup.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			db, err := openSQLStorage(configPath)
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			m, err := migrate.New(db, mysql.Migrations(), nil)
+			if err != nil {
+				return err
+			}
+
+			var version int
+			if status {
+				version, err = m.Version(cmd.Context())
+			} else {
+				version, err = m.Migrate(cmd.Context())
+			}
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "schema version %d (latest %d)\n", version, m.Latest())
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&configPath, "config", "", "path to a YAML or TOML config file")
+	cmd.Flags().BoolVar(&status, "status", false, "print the schema version without migrating")
+
+	return cmd
+}
+
+func newTrainDictCmd() *cobra.Command {
+	var configPath string
+	var samples int
+
+	cmd := &cobra.Command{
+		Use:   "train-dict",
+		Short: "Train a zstd dictionary for stored events",
+		Long: "Train a zstd dictionary from the latest events in the SQL storage backend in " +
+			"--config. New events are compressed with it once serve restarts.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			db, err := openSQLStorage(configPath)
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			store := mysql.New(db, nil)
+			if err := store.Migrate(cmd.Context()); err != nil {
+				return err
+			}
+			id, err := store.TrainDict(cmd.Context(), samples)
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "trained dictionary %d\n", id)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&configPath, "config", "", "path to a YAML or TOML config file")
+	cmd.Flags().IntVar(&samples, "samples", 10000, "number of events to train on")
+
+	return cmd
+}
+
+func openSQLStorage(configPath string) (*sql.DB, error) {
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Storage.Backend != "mysql" {
+		return nil, fmt.Errorf("storage.backend %q has no schema", cfg.Storage.Backend)
+	}
+
+	db, err := sql.Open("mysql", cfg.Storage.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open mysql: %w", err)
+	}
+	return db, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/high-moctane/mocrelay"
+	"github.com/spf13/cobra"
+)
+
+const adminKeyEnv = configEnvPrefix + "ADMIN_KEY"
+
+func newPurgeCmd() *cobra.Command {
+	var adminURL string
+
+	cmd := &cobra.Command{
+		Use:   "purge <pubkey> [reason]",
+		Short: "Delete all events by a pubkey and reject its future events",
+		Long: "Delete all events by a pubkey and reject its future events via the admin API.\n" +
+			"The request is signed with the secret key in hex or nsec in $" + adminKeyEnv + ".",
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key, err := mocrelay.ParseKeypair(os.Getenv(adminKeyEnv))
+			if err != nil {
+				return fmt.Errorf("%s: %w", adminKeyEnv, err)
+			}
+
+			var result int
+			if err := callAdmin(cmd, adminURL, key, "purgepubkey", args, &result); err != nil {
+				return err
+			}
+
+			fmt.Fprintf(cmd.ErrOrStderr(), "purged %d events\n", result)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&adminURL, "url", "http://localhost:8234/admin", "admin api url")
+
+	return cmd
+}
+
+// callAdmin calls method of the admin API with NIP-98 authorization signed by key.
+func callAdmin(
+	cmd *cobra.Command,
+	adminURL string,
+	key *mocrelay.Keypair,
+	method string,
+	params []string,
+	result any,
+) error {
+	body, err := json.Marshal(map[string]any{"method": method, "params": params})
+	if err != nil {
+		return err
+	}
+
+	auth, err := signNIP98(key, adminURL, http.MethodPost, body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(
+		cmd.Context(),
+		http.MethodPost,
+		adminURL,
+		bytes.NewReader(body),
+	)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", mocrelay.AdminContentType)
+	req.Header.Set("Authorization", auth)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call %s: %w", adminURL, err)
+	}
+	defer resp.Body.Close()
+
+	var res struct {
+		Result json.RawMessage `json:"result"`
+		Error  string          `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return fmt.Errorf("invalid admin response (%s): %w", resp.Status, err)
+	}
+	if res.Error != "" {
+		return fmt.Errorf("admin api error: %s", res.Error)
+	}
+	return json.Unmarshal(res.Result, result)
+}
+
+func signNIP98(key *mocrelay.Keypair, url, method string, body []byte) (string, error) {
+	payload := sha256.Sum256(body)
+
+	event := &mocrelay.Event{
+		CreatedAt: time.Now().Unix(),
+		Kind:      mocrelay.HTTPAuthEventKind,
+		Tags: []mocrelay.Tag{
+			{"u", url},
+			{"method", method},
+			{"payload", hex.EncodeToString(payload[:])},
+		},
+	}
+	if err := key.Sign(event); err != nil {
+		return "", err
+	}
+
+	b, err := json.Marshal(event)
+	if err != nil {
+		return "", err
+	}
+	return "Nostr " + base64.StdEncoding.EncodeToString(b), nil
+}
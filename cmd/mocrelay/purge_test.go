@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/high-moctane/mocrelay"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPurgeCmd(t *testing.T) {
+	key := strings.Repeat("01", 32)
+	keypair, err := mocrelay.ParseKeypair(key)
+	require.NoError(t, err)
+	admin := keypair.Pubkey()
+	target := strings.Repeat("a", 64)
+
+	moderator := mocrelay.NewModerator(nil)
+	srv := httptest.NewServer(&mocrelay.AdminHandler{
+		Moderator: moderator,
+		Admins:    []string{admin},
+	})
+	defer srv.Close()
+
+	t.Setenv(adminKeyEnv, key)
+
+	var stderr bytes.Buffer
+	cmd := newPurgeCmd()
+	cmd.SetArgs([]string{"--url", srv.URL + "/admin", target, "illegal"})
+	cmd.SetErr(&stderr)
+	require.NoError(t, cmd.ExecuteContext(context.Background()))
+
+	assert.Equal(t, "purged 0 events\n", stderr.String())
+	assert.True(t, moderator.IsPubkeyBanned(target))
+
+	t.Setenv(adminKeyEnv, strings.Repeat("02", 32))
+	cmd = newPurgeCmd()
+	cmd.SetArgs([]string{"--url", srv.URL + "/admin", target})
+	assert.ErrorContains(t, cmd.ExecuteContext(context.Background()), "pubkey is not an admin")
+}
@@ -0,0 +1,792 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	_ "github.com/ClickHouse/clickhouse-go"
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/high-moctane/mocrelay"
+	"github.com/high-moctane/mocrelay/metrics/otlp"
+	"github.com/high-moctane/mocrelay/metrics/statsd"
+	mocprom "github.com/high-moctane/mocrelay/middleware/prometheus"
+	"github.com/high-moctane/mocrelay/sink/clickhouse"
+	"github.com/high-moctane/mocrelay/store/mysql"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/spf13/cobra"
+)
+
+func newServeCmd() *cobra.Command {
+	var configPath, addr string
+
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Run the relay",
+		Long: "Run the relay. The config is loaded from --config and then overwritten by " +
+			"MOCRELAY_* environment variables (e.g. MOCRELAY_LISTEN_ADDR).",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := LoadConfig(configPath)
+			if err != nil {
+				return err
+			}
+			if cmd.Flags().Changed("addr") {
+				cfg.Listen.Addr = addr
+			}
+			return serve(cmd.Context(), cfg)
+		},
+	}
+
+	cmd.Flags().StringVar(&configPath, "config", "", "path to a YAML or TOML config file")
+	cmd.Flags().StringVar(&addr, "addr", "", "address to listen on (overrides listen.addr)")
+
+	return cmd
+}
+
+func newLogger(cfg *LogConfig) *slog.Logger {
+	var level slog.Level
+	level.UnmarshalText([]byte(cfg.Level))
+
+	opts := &slog.HandlerOptions{Level: level}
+	if cfg.Format == "json" {
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts))
+	}
+	return slog.New(slog.NewTextHandler(os.Stderr, opts))
+}
+
+func serve(ctx context.Context, cfg *Config) error {
+	ctx, cancel := signal.NotifyContext(ctx, syscall.SIGTERM, syscall.SIGINT)
+	defer cancel()
+
+	logger := newLogger(&cfg.Log)
+	slog.SetDefault(logger)
+
+	reg := prometheus.NewRegistry()
+
+	verifyCacheHits, verifyCacheMisses := mocprom.NewVerifierCacheCounters(reg)
+	verifier := mocrelay.NewVerifier(&mocrelay.VerifierOption{
+		Workers:     cfg.Policy.VerifierWorkers,
+		CacheSize:   cfg.Policy.VerifierCacheSize,
+		CacheHits:   verifyCacheHits,
+		CacheMisses: verifyCacheMisses,
+	})
+	defer verifier.Stop()
+	mocprom.RegisterVerifier(reg, verifier)
+
+	realIP, err := mocrelay.NewRealIPResolver(cfg.Listen.TrustedProxies)
+	if err != nil {
+		return err
+	}
+
+	var auditLog *mocrelay.AuditLog
+	if cfg.Log.AuditPath != "" {
+		f, err := os.OpenFile(cfg.Log.AuditPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			return fmt.Errorf("failed to open audit log: %w", err)
+		}
+		defer f.Close()
+		auditLog = mocrelay.NewAuditLog(f)
+	}
+
+	relayMetrics, stopMetrics, err := newRelayMetrics(&cfg.Metrics, reg, logger)
+	if err != nil {
+		return err
+	}
+	defer stopMetrics()
+
+	deps := &relayDeps{
+		logger:   logger,
+		verifier: verifier,
+		realIP:   realIP,
+		auditLog: auditLog,
+		metrics:  relayMetrics,
+	}
+
+	// The metrics of relays are labeled with their tenants if there are tenants.
+	relayReg := prometheus.Registerer(reg)
+	if len(cfg.Tenants) > 0 {
+		relayReg = prometheus.WrapRegistererWith(prometheus.Labels{"tenant": "default"}, reg)
+	}
+	def, err := newRelayServer(ctx, cfg, deps, relayReg)
+	if err != nil {
+		return err
+	}
+	defer def.close()
+
+	relays := []*mocrelay.Relay{def.relay}
+	handler := def.handler
+	if len(cfg.Tenants) > 0 {
+		vhost := &mocrelay.VirtualHostMux{
+			Hosts:   make(map[string]http.Handler),
+			Default: def.handler,
+		}
+		for i := range cfg.Tenants {
+			host := mocrelay.NormalizeHost(cfg.Tenants[i].Host)
+			tenantReg := prometheus.WrapRegistererWith(prometheus.Labels{"tenant": host}, reg)
+			t, err := newRelayServer(ctx, cfg.tenant(&cfg.Tenants[i]), deps, tenantReg)
+			if err != nil {
+				return fmt.Errorf("tenant %s: %w", host, err)
+			}
+			defer t.close()
+
+			vhost.Hosts[host] = t.handler
+			relays = append(relays, t.relay)
+		}
+		handler = vhost
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", handler)
+	health := &mocrelay.HealthHandler{Relay: def.relay, Verifier: verifier}
+	mux.Handle("/healthz", health)
+	mux.Handle("/readyz", health)
+	mux.Handle("/version", health)
+	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{Registry: reg}))
+
+	srv := &http.Server{
+		Addr:    cfg.Listen.Addr,
+		Handler: mux,
+	}
+
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+
+		<-ctx.Done()
+		notifySystemd(mocrelay.SystemdStopping)
+
+		c, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		srv.Shutdown(c)
+		for _, relay := range relays {
+			if err := relay.Shutdown(c); err != nil {
+				slog.WarnContext(ctx, "failed to shutdown relay gracefully", "err", err)
+			}
+		}
+	}()
+
+	err = listenAndServe(srv, &cfg.Listen)
+	if errors.Is(err, http.ErrServerClosed) {
+		<-shutdownDone
+		return nil
+	}
+	return err
+}
+
+// relayDeps are shared by the relays of all tenants.
+type relayDeps struct {
+	logger   *slog.Logger
+	verifier *mocrelay.Verifier
+	realIP   *mocrelay.RealIPResolver
+	auditLog *mocrelay.AuditLog
+	metrics  *mocrelay.RelayMetrics
+}
+
+// relayServer is a relay with its own identity, policies and storage.
+type relayServer struct {
+	relay   *mocrelay.Relay
+	handler http.Handler
+	closers []func()
+}
+
+func (s *relayServer) close() {
+	for i := len(s.closers) - 1; i >= 0; i-- {
+		s.closers[i]()
+	}
+}
+
+// newRelayServer builds the relay of cfg whose metrics are registered to reg.
+func newRelayServer(
+	ctx context.Context,
+	cfg *Config,
+	deps *relayDeps,
+	reg prometheus.Registerer,
+) (_ *relayServer, err error) {
+	s := &relayServer{}
+	defer func() {
+		if err != nil {
+			s.close()
+		}
+	}()
+
+	key, err := cfg.Info.keypair()
+	if err != nil {
+		return nil, err
+	}
+
+	nip11 := &mocrelay.NIP11{
+		Name:        cfg.Info.Name,
+		Description: cfg.Info.Description,
+		Pubkey:      cfg.Info.Pubkey,
+		Contact:     cfg.Info.Contact,
+		Software:    "https://github.com/high-moctane/mocrelay",
+		Limitation: &mocrelay.NIP11Limitation{
+			MaxMessageLength: int(cfg.Limits.MaxMessageLength),
+			MaxSubscriptions: cfg.Limits.MaxSubscriptions,
+			MaxFilters:       cfg.Limits.MaxFilters,
+			MaxLimit:         cfg.Limits.MaxLimit,
+			MaxEventTags:     cfg.Limits.MaxEventTags,
+			MaxContentLength: cfg.Limits.MaxContentLength,
+
+			MaxFilterIDs:       cfg.Limits.MaxFilterIDs,
+			MaxFilterAuthors:   cfg.Limits.MaxFilterAuthors,
+			MaxFilterKinds:     cfg.Limits.MaxFilterKinds,
+			MaxFilterTagValues: cfg.Limits.MaxFilterTagValues,
+		},
+	}
+
+	if key != nil && nip11.Pubkey == "" {
+		nip11.Pubkey = key.Pubkey()
+	}
+
+	mode, err := mocrelay.ParseRelayMode(cfg.Listen.Mode)
+	if err != nil {
+		return nil, err
+	}
+	modeOpt := &mocrelay.RelayModeOption{Mode: mode}
+
+	idMatchMode := cfg.Policy.idMatchMode()
+	tagNamePattern, err := cfg.Policy.tagNamePattern()
+	if err != nil {
+		return nil, err
+	}
+
+	interner := cfg.Storage.interner()
+
+	store, closeStore, err := newStore(ctx, &cfg.Storage, &cfg.Policy, interner, reg)
+	if err != nil {
+		return nil, err
+	}
+	s.closers = append(s.closers, closeStore)
+
+	router := mocrelay.NewRouterHandlerWithOption(100, &mocrelay.RouterHandlerOption{
+		FanoutWorkers:   cfg.Limits.FanoutWorkers,
+		FanoutQueueSize: cfg.Limits.FanoutQueueSize,
+		DeliveryLatency: mocprom.NewDeliveryLatencyHistogram(reg),
+		IDMatchMode:     idMatchMode,
+	})
+	s.closers = append(s.closers, router.Stop)
+	mocprom.RegisterSessions(reg, router)
+	h := mocrelay.NewMergeHandler(
+		mocrelay.NewRelayModeStoreMiddleware(modeOpt)(store),
+		mocrelay.NewSendEventUniqueFilterMiddleware(10)(router),
+	)
+	h = mocrelay.NewRelayModeMiddleware(modeOpt)(h)
+	h = mocrelay.NewEventCreatedAtMiddleware(
+		-cfg.Policy.CreatedAtPast,
+		cfg.Policy.CreatedAtFuture,
+	)(h)
+	h = mocrelay.BuildMiddlewareFromNIP11(nip11)(h)
+	h = mocrelay.NewRecvEventUniqueFilterMiddleware(10)(h)
+
+	var tombstones *mocrelay.Tombstones
+	if cfg.Storage.TombstonePath != "" {
+		tombstones, err = mocrelay.OpenTombstones(&mocrelay.TombstonesOption{
+			Path: cfg.Storage.TombstonePath,
+		})
+		if err != nil {
+			return nil, err
+		}
+		s.closers = append(s.closers, func() { tombstones.Close() })
+		h = mocrelay.NewTombstoneMiddleware(tombstones)(h)
+	}
+
+	if cfg.Policy.ExpensiveFilterAction != "" {
+		h = mocrelay.NewExpensiveFilterMiddleware(&mocrelay.ExpensiveFilterOption{
+			Action:      mocrelay.ExpensiveFilterAction(cfg.Policy.ExpensiveFilterAction),
+			MaxWindow:   cfg.Policy.ExpensiveFilterMaxWindow,
+			CappedLimit: cfg.Policy.ExpensiveFilterCappedLimit,
+			Logger:      deps.logger,
+			Counter:     mocprom.NewExpensiveFilterCounter(reg),
+		})(h)
+	}
+
+	if len(cfg.Policy.AuthReqKinds) > 0 || len(cfg.Policy.AuthEventKinds) > 0 {
+		h = mocrelay.NewAuthPolicyMiddleware(&mocrelay.AuthPolicyOption{
+			ReqKinds:   cfg.Policy.AuthReqKinds,
+			EventKinds: cfg.Policy.AuthEventKinds,
+		})(h)
+	}
+
+	if cfg.Policy.Auth {
+		h = mocrelay.NewAuthMiddleware(&mocrelay.AuthOption{
+			ChallengeTTL: cfg.Policy.AuthChallengeTTL,
+			RelayURLs:    cfg.Policy.AuthRelayURLs,
+			Reauth:       cfg.Policy.AuthReauth,
+		})(h)
+	}
+
+	if len(cfg.Policy.WoTSeeds) > 0 {
+		wot := mocrelay.NewWebOfTrust(&mocrelay.WebOfTrustOption{
+			Seeds: cfg.Policy.WoTSeeds,
+			Depth: cfg.Policy.WoTDepth,
+		})
+		h = mocrelay.NewWebOfTrustMiddleware(wot)(h)
+	}
+
+	if cfg.Sink.ClickHouseDSN != "" {
+		sink, stop, err := startClickHouseSink(ctx, &cfg.Sink, deps.logger)
+		if err != nil {
+			return nil, err
+		}
+		s.closers = append(s.closers, stop)
+
+		policy := mocrelay.AckAfterCache
+		if cfg.Sink.AckPolicy == "durable" {
+			policy = mocrelay.AckAfterDurable
+		}
+		h = mocrelay.NewEventSinkMiddleware(&mocrelay.EventSinkOption{
+			Sinks:    []mocrelay.EventSink{sink},
+			Policy:   policy,
+			Timeout:  cfg.Sink.AckTimeout,
+			Logger:   deps.logger,
+			Failures: mocprom.NewEventSinkFailureCounter(reg),
+		})(h)
+	}
+
+	var firehose *mocrelay.Firehose
+	if len(cfg.Firehose.Tokens) > 0 {
+		firehose = mocrelay.NewFirehose()
+		h = mocrelay.NewFirehoseMiddleware(firehose)(h)
+	}
+
+	var moderator *mocrelay.Moderator
+	if len(cfg.Admin.Pubkeys) > 0 {
+		modOpt := &mocrelay.ModeratorOption{
+			Purgers: []mocrelay.PubkeyPurger{store},
+			Logger:  deps.logger,
+		}
+		if tombstones != nil {
+			modOpt.OnPurgeEvent = func(id string) {
+				if err := tombstones.Add(id, ""); err != nil {
+					slog.WarnContext(ctx, "failed to add tombstone", "id", id, "err", err)
+				}
+			}
+			modOpt.OnPurgePubkey = func(pubkey string) {
+				if err := tombstones.AddPubkey(pubkey); err != nil {
+					slog.WarnContext(ctx, "failed to add tombstone", "pubkey", pubkey, "err", err)
+				}
+			}
+		}
+		moderator = mocrelay.NewModerator(modOpt)
+		h = mocrelay.NewModerationMiddleware(moderator)(h)
+
+		runCtx, stopRun := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			moderator.Run(runCtx)
+		}()
+		s.closers = append(s.closers, func() {
+			stopRun()
+			<-done
+		})
+	}
+
+	var debugTap *mocrelay.DebugTap
+	if len(cfg.Admin.Pubkeys) > 0 {
+		debugTap = mocrelay.NewDebugTap(nil)
+	}
+
+	h = mocprom.NewPrometheusMiddleware(reg)(h)
+
+	var banList *mocrelay.BanList
+	if cfg.Policy.BanMaxStrikes > 0 {
+		banList, err = mocrelay.NewBanList(&mocrelay.BanListOption{
+			MaxStrikes: cfg.Policy.BanMaxStrikes,
+			Path:       cfg.Policy.BanPath,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var sendQueue *mocrelay.SendQueueOption
+	if cfg.Limits.SendQueueSize > 0 {
+		sendQueue = &mocrelay.SendQueueOption{Size: cfg.Limits.SendQueueSize}
+	}
+
+	var msgRateLimit *mocrelay.MsgRateLimitOption
+	if rl := cfg.Limits.MsgRateLimit; rl.Rate > 0 {
+		msgRateLimit = &mocrelay.MsgRateLimitOption{
+			Rate:       rl.Rate,
+			Burst:      rl.Burst,
+			EventCost:  rl.EventCost,
+			ReqCost:    rl.ReqCost,
+			CountCost:  rl.CountCost,
+			CloseCost:  rl.CloseCost,
+			AuthCost:   rl.AuthCost,
+			FilterCost: rl.FilterCost,
+			Notice:     rl.Notice,
+		}
+	}
+
+	var egressLimit *mocrelay.EgressLimitOption
+	if cfg.Limits.EgressBytesPerSec > 0 || cfg.Limits.EgressGlobalBytesPerSec > 0 {
+		egressLimit = &mocrelay.EgressLimitOption{
+			BytesPerSec:       cfg.Limits.EgressBytesPerSec,
+			GlobalBytesPerSec: cfg.Limits.EgressGlobalBytesPerSec,
+		}
+	}
+
+	var writeCoalesce *mocrelay.WriteCoalesceOption
+	if cfg.Limits.WriteCoalesceInterval > 0 {
+		writeCoalesce = &mocrelay.WriteCoalesceOption{Interval: cfg.Limits.WriteCoalesceInterval}
+	}
+
+	filterLimits := &mocrelay.ReqFilterLimits{
+		MaxIDs:       cfg.Limits.MaxFilterIDs,
+		MaxAuthors:   cfg.Limits.MaxFilterAuthors,
+		MaxKinds:     cfg.Limits.MaxFilterKinds,
+		MaxTagValues: cfg.Limits.MaxFilterTagValues,
+	}
+
+	relay := mocrelay.NewRelay(h, &mocrelay.RelayOption{
+		Logger:              deps.logger,
+		RecvLogger:          deps.logger,
+		SendLogger:          deps.logger,
+		MaxMessageLength:    cfg.Limits.MaxMessageLength,
+		ReassembleMessages:  cfg.Listen.ReassembleMessages,
+		CBOR:                cfg.Listen.CBOR,
+		CanonicalURL:        cfg.Listen.CanonicalURL,
+		RealIP:              deps.realIP,
+		MaxConnections:      cfg.Limits.MaxConnections,
+		MaxConnectionsPerIP: cfg.Limits.MaxConnectionsPerIP,
+		SendQueue:           sendQueue,
+		WriteCoalesce:       writeCoalesce,
+		MsgRateLimit:        msgRateLimit,
+		EgressLimit:         egressLimit,
+		AuditLog:            deps.auditLog,
+		DebugTap:            debugTap,
+		IDMatchMode:         idMatchMode,
+		TagNamePattern:      tagNamePattern,
+		FilterLimits:        filterLimits,
+		BanList:             banList,
+		NoticeGovernor: &mocrelay.NoticeGovernorOption{
+			Rate:           cfg.Policy.NoticeRate,
+			Burst:          cfg.Policy.NoticeBurst,
+			DedupWindow:    cfg.Policy.NoticeDedupWindow,
+			MaxInvalidMsgs: cfg.Policy.MaxInvalidMsgs,
+		},
+		Verifier: deps.verifier,
+		Interner: interner,
+		Metrics:  deps.metrics,
+	})
+
+	relayMux := &mocrelay.ServeMux{
+		Relay:  relay,
+		NIP11:  nip11,
+		Logger: deps.logger,
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", relayMux)
+	if len(cfg.Firehose.Tokens) > 0 {
+		mux.Handle("/firehose", &mocrelay.FirehoseHandler{
+			Firehose:  firehose,
+			Authorize: mocrelay.NewBearerAuthorizer(cfg.Firehose.Tokens...),
+		})
+	}
+	if moderator != nil {
+		mux.Handle("/admin", &mocrelay.AdminHandler{
+			Moderator:  moderator,
+			Admins:     cfg.Admin.Pubkeys,
+			NIP98:      &mocrelay.NIP98Option{URL: cfg.Admin.URL},
+			Key:        key,
+			Broadcast:  router.Publish,
+			TopPubkeys: deps.metrics.TopPubkeys,
+			TopIPs:     deps.metrics.TopIPs,
+			DebugTap:   debugTap,
+		})
+	}
+
+	s.relay, s.handler = relay, mux
+	return s, nil
+}
+
+type storeHandler interface {
+	mocrelay.Handler
+	mocrelay.PubkeyPurger
+}
+
+func newStore(
+	ctx context.Context,
+	cfg *StorageConfig,
+	policy *PolicyConfig,
+	interner *mocrelay.Interner,
+	reg prometheus.Registerer,
+) (storeHandler, func(), error) {
+	idMatchMode := policy.idMatchMode()
+
+	if cfg.Backend != "mysql" {
+		cache := mocrelay.NewCacheHandlerWithOption(cfg.CacheSize, &mocrelay.CacheHandlerOption{
+			QueryTimeout:         cfg.QueryTimeout,
+			MaxConcurrentQueries: cfg.MaxConcurrentQueries,
+			CountCacheTTL:        cfg.CountCacheTTL,
+			MaxBytes:             cfg.CacheMaxBytes,
+			EvictionCounter:      mocprom.NewCacheEvictionCounter(reg),
+			Shards:               cfg.CacheShards,
+			IDMatchMode:          idMatchMode,
+			Interner:             interner,
+		})
+		mocprom.RegisterCache(reg, cache)
+		if cfg.SnapshotPath == "" {
+			return cache, func() {}, nil
+		}
+		stop, err := startCacheSnapshots(ctx, cfg, cache)
+		if err != nil {
+			return nil, nil, err
+		}
+		return cache, stop, nil
+	}
+
+	tagNamePattern, err := policy.tagNamePattern()
+	if err != nil {
+		return nil, nil, err
+	}
+	db, err := sql.Open("mysql", cfg.DSN)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open mysql: %w", err)
+	}
+	store := mysql.New(db, &mysql.Option{
+		DisableCompression: cfg.DisableCompression,
+		PartitionWindow:    cfg.PartitionWindow,
+		Retention:          cfg.Retention,
+		IDMatchMode:        idMatchMode,
+		TagNamePattern:     tagNamePattern,
+	})
+	if err := store.Migrate(ctx); err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	if err := store.MaintainPartitions(ctx, time.Now()); err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+
+	maintainCtx, stopMaintain := context.WithCancel(ctx)
+	maintainDone := make(chan struct{})
+	go func() {
+		defer close(maintainDone)
+
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-maintainCtx.Done():
+				return
+			case now := <-ticker.C:
+				if err := store.MaintainPartitions(maintainCtx, now); err != nil {
+					slog.WarnContext(maintainCtx, "failed to maintain partitions", "err", err)
+				}
+			}
+		}
+	}()
+
+	var eventStore mocrelay.EventStore = store.Namespace(cfg.Namespace)
+	if cfg.QueryCacheTTL > 0 {
+		eventStore = mocrelay.NewCachedStore(eventStore, &mocrelay.CachedStoreOption{
+			TTL:         cfg.QueryCacheTTL,
+			IDMatchMode: idMatchMode,
+		})
+	}
+
+	h := mocrelay.NewStoreHandler(eventStore, &mocrelay.StoreHandlerOption{
+		QueryTimeout:         cfg.QueryTimeout,
+		MaxConcurrentQueries: cfg.MaxConcurrentQueries,
+	})
+	return h, func() {
+		stopMaintain()
+		<-maintainDone
+		db.Close()
+	}, nil
+}
+
+// startCacheSnapshots loads the snapshot of cache and saves it periodically.
+// It returns a function which stops it and saves the last snapshot.
+func startCacheSnapshots(
+	ctx context.Context,
+	cfg *StorageConfig,
+	cache *mocrelay.CacheHandler,
+) (func(), error) {
+	n, err := cache.LoadSnapshot(cfg.SnapshotPath)
+	if err != nil {
+		return nil, err
+	}
+	slog.InfoContext(ctx, "loaded cache snapshot", "path", cfg.SnapshotPath, "events", n)
+
+	snapshotCtx, stopSnapshot := context.WithCancel(ctx)
+	snapshotDone := make(chan struct{})
+	go func() {
+		defer close(snapshotDone)
+
+		ticker := time.NewTicker(cfg.SnapshotInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-snapshotCtx.Done():
+				return
+			case <-ticker.C:
+				if err := cache.SaveSnapshot(cfg.SnapshotPath); err != nil {
+					slog.WarnContext(snapshotCtx, "failed to save cache snapshot", "err", err)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		stopSnapshot()
+		<-snapshotDone
+		if err := cache.SaveSnapshot(cfg.SnapshotPath); err != nil {
+			slog.Warn("failed to save cache snapshot", "err", err)
+		}
+	}, nil
+}
+
+// startClickHouseSink runs a sink which mirrors events into ClickHouse
+// and returns a function which flushes and stops the sink.
+func startClickHouseSink(
+	ctx context.Context,
+	cfg *SinkConfig,
+	logger *slog.Logger,
+) (*clickhouse.Sink, func(), error) {
+	db, err := sql.Open("clickhouse", cfg.ClickHouseDSN)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open clickhouse: %w", err)
+	}
+	sink := clickhouse.New(db, &clickhouse.Option{
+		BatchSize:     cfg.ClickHouseBatchSize,
+		FlushInterval: cfg.ClickHouseFlushInterval,
+		Logger:        logger,
+	})
+	if err := sink.Migrate(ctx); err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+
+	runCtx, stopRun := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sink.Run(runCtx, nil)
+	}()
+
+	return sink, func() {
+		stopRun()
+		<-done
+		db.Close()
+	}, nil
+}
+
+// newRelayMetrics returns the relay metrics reported to the configured sink
+// and the function to stop sending them.
+func newRelayMetrics(
+	cfg *MetricsConfig,
+	reg prometheus.Registerer,
+	logger *slog.Logger,
+) (*mocrelay.RelayMetrics, func(), error) {
+	var sink mocrelay.MetricsSink
+	var run func(ctx context.Context) error
+	switch cfg.Sink {
+	case "statsd":
+		s, err := statsd.New(cfg.StatsdAddr, &statsd.Option{FlushInterval: cfg.Interval})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open statsd: %w", err)
+		}
+		sink, run = s, s.Run
+	case "otlp":
+		s := otlp.New(cfg.OTLPEndpoint, &otlp.Option{Interval: cfg.Interval, Logger: logger})
+		sink, run = s, s.Run
+	default:
+		return mocprom.NewRelayMetrics(reg), func() {}, nil
+	}
+
+	runCtx, stopRun := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		run(runCtx)
+	}()
+
+	return mocrelay.NewSinkRelayMetrics(sink), func() {
+		stopRun()
+		<-done
+	}, nil
+}
+
+// listenAndServe serves srv on the socket activated listeners if any or on srv.Addr,
+// and notifies systemd of the readiness.
+func listenAndServe(srv *http.Server, cfg *ListenConfig) error {
+	if len(cfg.Autocert.Hosts) > 0 {
+		notifySystemd(mocrelay.SystemdReady)
+		return mocrelay.ListenAndServeAutocert(srv, &mocrelay.AutocertOption{
+			Hosts:    cfg.Autocert.Hosts,
+			CacheDir: cfg.Autocert.CacheDir,
+			Email:    cfg.Autocert.Email,
+		})
+	}
+
+	// Only the trusted proxies can tell the client addresses.
+	// Without them, every peer must be a proxy.
+	proxies, err := mocrelay.NewRealIPResolver(cfg.TrustedProxies)
+	if err != nil {
+		return err
+	}
+
+	lns, err := listen(srv.Addr)
+	if err != nil {
+		return err
+	}
+	if cfg.ProxyProtocol {
+		for i, ln := range lns {
+			lns[i] = mocrelay.NewProxyProtocolListener(ln, &mocrelay.ProxyProtocolOption{
+				TrustedProxies: proxies.TrustedProxies,
+				Required:       true,
+			})
+		}
+	}
+
+	notifySystemd(mocrelay.SystemdReady)
+
+	errs := make(chan error, len(lns))
+	for _, ln := range lns {
+		go func() { errs <- srv.Serve(ln) }()
+	}
+	return <-errs
+}
+
+func listen(addr string) ([]net.Listener, error) {
+	lns, err := mocrelay.SystemdListeners()
+	if err != nil {
+		return nil, err
+	}
+	if len(lns) > 0 {
+		slog.Info("use socket activated listeners", "n", len(lns))
+		return lns, nil
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return []net.Listener{ln}, nil
+}
+
+func notifySystemd(state string) {
+	if err := mocrelay.SystemdNotify(state); err != nil {
+		slog.Warn("failed to notify systemd", "state", state, "err", err)
+	}
+}
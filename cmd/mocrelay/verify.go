@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/high-moctane/mocrelay"
+	"github.com/spf13/cobra"
+)
+
+func newVerifyCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "verify [file]",
+		Short: "Verify ids and signatures of events in JSONL",
+		Long:  "Verify ids and signatures of events in JSONL. Reads stdin if file is omitted or \"-\".",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var name string
+			if len(args) > 0 {
+				name = args[0]
+			}
+
+			r, err := openInput(name)
+			if err != nil {
+				return err
+			}
+			defer r.Close()
+
+			var total, invalid int
+			err = readEvents(r, func(line int, event *mocrelay.Event) error {
+				total++
+				if ok, err := event.Verify(); !ok || err != nil {
+					invalid++
+					reason := "invalid id or signature"
+					if err != nil {
+						reason = err.Error()
+					}
+					fmt.Fprintf(cmd.OutOrStdout(), "line %d: %s: %s\n", line, event.ID, reason)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(cmd.ErrOrStderr(), "verified %d events, %d invalid\n", total, invalid)
+			if invalid > 0 {
+				return fmt.Errorf("%d invalid events", invalid)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,68 @@
+package mocrelay
+
+import (
+	"net/http"
+	"sync"
+)
+
+type connLimiter struct {
+	max      int
+	maxPerIP int
+
+	mu    sync.Mutex
+	total int
+	// map[ip]count
+	perIP map[string]int
+}
+
+func newConnLimiter(max, maxPerIP int) *connLimiter {
+	return &connLimiter{
+		max:      max,
+		maxPerIP: maxPerIP,
+		perIP:    make(map[string]int),
+	}
+}
+
+// acquire registers a connection from ip. If the limit is exceeded,
+// it returns false with the http status to reject the upgrade.
+func (l *connLimiter) acquire(ip string) (status int, ok bool) {
+	if l == nil {
+		return http.StatusOK, true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.max > 0 && l.total >= l.max {
+		return http.StatusServiceUnavailable, false
+	}
+	if l.maxPerIP > 0 && l.perIP[ip] >= l.maxPerIP {
+		return http.StatusTooManyRequests, false
+	}
+
+	l.total++
+	l.perIP[ip]++
+	return http.StatusOK, true
+}
+
+func (l *connLimiter) release(ip string) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.total--
+	if l.perIP[ip] <= 1 {
+		delete(l.perIP, ip)
+	} else {
+		l.perIP[ip]--
+	}
+}
+
+func (l *connLimiter) count() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.total
+}
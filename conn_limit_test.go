@@ -0,0 +1,47 @@
+package mocrelay
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnLimiter(t *testing.T) {
+	l := newConnLimiter(3, 2)
+
+	acquire := func(ip string) int {
+		status, _ := l.acquire(ip)
+		return status
+	}
+
+	assert.Equal(t, http.StatusOK, acquire("192.0.2.1"))
+	assert.Equal(t, http.StatusOK, acquire("192.0.2.1"))
+	assert.Equal(t, http.StatusTooManyRequests, acquire("192.0.2.1"))
+	assert.Equal(t, http.StatusOK, acquire("192.0.2.2"))
+	assert.Equal(t, http.StatusServiceUnavailable, acquire("192.0.2.3"))
+	assert.Equal(t, 3, l.count())
+
+	l.release("192.0.2.1")
+	assert.Equal(t, http.StatusOK, acquire("192.0.2.3"))
+	assert.Equal(t, http.StatusServiceUnavailable, acquire("192.0.2.1"))
+
+	l.release("192.0.2.2")
+	l.release("192.0.2.3")
+	l.release("192.0.2.1")
+	assert.Equal(t, 0, l.count())
+	assert.Empty(t, l.perIP)
+}
+
+func TestConnLimiter_noLimit(t *testing.T) {
+	l := newConnLimiter(0, 0)
+	for i := 0; i < 100; i++ {
+		_, ok := l.acquire("192.0.2.1")
+		assert.True(t, ok)
+	}
+
+	var nilLimiter *connLimiter
+	_, ok := nilLimiter.acquire("192.0.2.1")
+	assert.True(t, ok)
+	nilLimiter.release("192.0.2.1")
+}
@@ -0,0 +1,45 @@
+package mocrelay
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+var ErrContentPolicy = errors.New("content policy violation")
+
+type ContentPolicy struct {
+	// MaxContentLength is the max byte length of the content. 0 means no limit.
+	MaxContentLength int
+
+	AllowInvalidUTF8 bool
+	AllowNUL         bool
+}
+
+func (p *ContentPolicy) Check(event *Event) error {
+	if p == nil || event == nil {
+		return nil
+	}
+
+	if p.MaxContentLength > 0 && len(event.Content) > p.MaxContentLength {
+		return fmt.Errorf(
+			"%w: content length %d exceeds %d bytes",
+			ErrContentPolicy,
+			len(event.Content),
+			p.MaxContentLength,
+		)
+	}
+	if !p.AllowInvalidUTF8 && !utf8.ValidString(event.Content) {
+		return fmt.Errorf("%w: content is not valid utf-8", ErrContentPolicy)
+	}
+	if !p.AllowNUL && strings.IndexByte(event.Content, 0) >= 0 {
+		return fmt.Errorf("%w: content contains null characters", ErrContentPolicy)
+	}
+
+	return nil
+}
+
+func contentPolicyReason(err error) string {
+	return strings.TrimPrefix(err.Error(), ErrContentPolicy.Error()+": ")
+}
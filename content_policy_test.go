@@ -0,0 +1,65 @@
+package mocrelay
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContentPolicy_Check(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  *ContentPolicy
+		content string
+		ok      bool
+	}{
+		{"ok", &ContentPolicy{MaxContentLength: 4}, "powa", true},
+		{"ok: nil policy", nil, "\xff\x00", true},
+		{"ok: no limit", &ContentPolicy{}, "powapowa", true},
+		{"ng: too long", &ContentPolicy{MaxContentLength: 3}, "powa", false},
+		{"ng: multibyte too long", &ContentPolicy{MaxContentLength: 3}, "ぽわ", false},
+		{"ng: invalid utf-8", &ContentPolicy{}, "po\xffwa", false},
+		{"ok: allow invalid utf-8", &ContentPolicy{AllowInvalidUTF8: true}, "po\xffwa", true},
+		{"ng: nul", &ContentPolicy{}, "po\x00wa", false},
+		{"ok: allow nul", &ContentPolicy{AllowNUL: true}, "po\x00wa", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.Check(&Event{Content: tt.content})
+			if tt.ok {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, ErrContentPolicy)
+			}
+		})
+	}
+}
+
+func TestCheckClientMsgWithOption(t *testing.T) {
+	event := signTestEvent(
+		t,
+		&Event{CreatedAt: 1693157791, Kind: 1, Tags: []Tag{}, Content: "powa"},
+	)
+	msg := &ClientEventMsg{Event: event}
+
+	ok, err := CheckClientMsgWithOption(msg, nil)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = CheckClientMsgWithOption(
+		msg,
+		&CheckClientMsgOption{ContentPolicy: &ContentPolicy{MaxContentLength: 3}},
+	)
+	assert.ErrorIs(t, err, ErrContentPolicy)
+	assert.False(t, ok)
+
+	ok, err = CheckClientMsgWithOption(
+		&ClientCloseMsg{SubscriptionID: "sub"},
+		&CheckClientMsgOption{
+			ContentPolicy: &ContentPolicy{MaxContentLength: 3},
+		},
+	)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
@@ -3,55 +3,74 @@ package mocrelay
 import (
 	"context"
 	"net/http"
-
-	"github.com/google/uuid"
-	"github.com/tomasen/realip"
+	"time"
 )
 
-type requestIDKeyType struct{}
+type sessionKeyType struct{}
 
-var requestIDKey = requestIDKeyType{}
+var sessionKey = sessionKeyType{}
 
-func ctxWithRequestID(ctx context.Context) context.Context {
-	return context.WithValue(ctx, requestIDKey, uuid.NewString())
+func ctxWithSession(ctx context.Context, sess *Session) context.Context {
+	return context.WithValue(ctx, sessionKey, sess)
 }
 
-func GetRequestID(ctx context.Context) string {
-	id, ok := ctx.Value(requestIDKey).(string)
+// GetSession returns the session of the connection or nil.
+func GetSession(ctx context.Context) *Session {
+	sess, ok := ctx.Value(sessionKey).(*Session)
 	if !ok {
-		return ""
+		return nil
 	}
-	return id
+	return sess
 }
 
-type realIPKeyType struct{}
+func GetRequestID(ctx context.Context) string {
+	sess := GetSession(ctx)
+	if sess == nil {
+		return ""
+	}
+	return sess.ID
+}
 
-var realIPKey = realIPKeyType{}
+func GetRealIP(ctx context.Context) string {
+	sess := GetSession(ctx)
+	if sess == nil {
+		return ""
+	}
+	return sess.RealIP
+}
 
-func ctxWithRealIP(ctx context.Context, r *http.Request) context.Context {
-	return context.WithValue(ctx, realIPKey, realip.FromRequest(r))
+func GetHTTPHeader(ctx context.Context) http.Header {
+	sess := GetSession(ctx)
+	if sess == nil {
+		return nil
+	}
+	return sess.Header
 }
 
-func GetRealIP(ctx context.Context) string {
-	ip, ok := ctx.Value(realIPKey).(string)
-	if !ok {
+func GetRelayURL(ctx context.Context) string {
+	sess := GetSession(ctx)
+	if sess == nil {
 		return ""
 	}
-	return ip
+	return sess.RelayURL
 }
 
-type httpHeaderKeyType struct{}
+type queryObserverKeyType struct{}
 
-var httpHeaderKey = httpHeaderKeyType{}
+var queryObserverKey = queryObserverKeyType{}
 
-func ctxWithHTTPHeader(ctx context.Context, r *http.Request) context.Context {
-	return context.WithValue(ctx, httpHeaderKey, r.Header)
+// queryObserver receives the query time of REQs from the handlers.
+type queryObserver interface {
+	observeQuery(subID string, d time.Duration)
 }
 
-func GetHTTPHeader(ctx context.Context) http.Header {
-	header, ok := ctx.Value(httpHeaderKey).(http.Header)
-	if !ok {
-		return nil
+func ctxWithQueryObserver(ctx context.Context, o queryObserver) context.Context {
+	return context.WithValue(ctx, queryObserverKey, o)
+}
+
+// observeQuery reports d as the query time of the REQ subID if ctx has an observer.
+func observeQuery(ctx context.Context, subID string, d time.Duration) {
+	if o, ok := ctx.Value(queryObserverKey).(queryObserver); ok {
+		o.observeQuery(subID, d)
 	}
-	return header
 }
@@ -2,16 +2,31 @@ package mocrelay
 
 import (
 	"context"
+	"net/http"
 	"testing"
 
-	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 )
 
-func TestGetRequestID(t *testing.T) {
+func TestGetSession(t *testing.T) {
 	ctx := context.Background()
+	assert.Nil(t, GetSession(ctx))
 	assert.Equal(t, "", GetRequestID(ctx))
-	ctx = ctxWithRequestID(ctx)
-	_, err := uuid.Parse(GetRequestID(ctx))
-	assert.Nil(t, err)
+	assert.Equal(t, "", GetRealIP(ctx))
+	assert.Nil(t, GetHTTPHeader(ctx))
+	assert.Equal(t, "", GetRelayURL(ctx))
+
+	header := http.Header{"User-Agent": {"powa"}}
+	sess := &Session{
+		ID:       "id",
+		RealIP:   "192.0.2.1",
+		Header:   header,
+		RelayURL: "wss://relay.example.com",
+	}
+	ctx = ctxWithSession(ctx, sess)
+	assert.Equal(t, sess, GetSession(ctx))
+	assert.Equal(t, "id", GetRequestID(ctx))
+	assert.Equal(t, "192.0.2.1", GetRealIP(ctx))
+	assert.Equal(t, header, GetHTTPHeader(ctx))
+	assert.Equal(t, "wss://relay.example.com", GetRelayURL(ctx))
 }
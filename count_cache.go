@@ -0,0 +1,92 @@
+package mocrelay
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// countCache caches COUNT results keyed by normalized filters.
+type countCache struct {
+	mu sync.Mutex
+	c  *lruCache[string, *countCacheEntry]
+	// gen is incremented on every invalidation.
+	gen uint64
+	// idMatchMode is how the ids and authors of filters match events.
+	idMatchMode IDMatchMode
+}
+
+type countCacheEntry struct {
+	matcher EventMatcher
+	count   uint64
+}
+
+func newCountCache(capacity int, ttl time.Duration) *countCache {
+	c := newLRUCache[string, *countCacheEntry](capacity)
+	c.TTL = ttl
+	return &countCache{c: c}
+}
+
+// countCacheKey returns the same key for filters which differ only in order.
+func countCacheKey(filters []*ReqFilter) string {
+	keys := make([]string, len(filters))
+	for i, f := range filters {
+		keys[i] = countCacheFilterKey(f)
+	}
+	slices.Sort(keys)
+	keys = slices.Compact(keys)
+	return strings.Join(keys, "\n")
+}
+
+func countCacheFilterKey(f *ReqFilter) string {
+	return string(f.canonicalJSON())
+}
+
+// Get returns the cached count of key.
+// On miss, it returns the generation to be passed to Set.
+func (c *countCache) Get(key string) (count uint64, gen uint64, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.c.Get(key)
+	if !ok {
+		return 0, c.gen, false
+	}
+	return e.count, c.gen, true
+}
+
+// Set caches count unless the cache is invalidated after gen,
+// since count can be stale then.
+func (c *countCache) Set(key string, filters []*ReqFilter, count uint64, gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if gen != c.gen {
+		return
+	}
+	c.c.Set(
+		key,
+		&countCacheEntry{
+			matcher: NewReqFiltersEventMatchersWithMode(filters, c.idMatchMode),
+			count:   count,
+		},
+	)
+}
+
+// Invalidate deletes the results which event can change.
+func (c *countCache) Invalidate(event *Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	c.c.DeleteFunc(func(_ string, e *countCacheEntry) bool { return e.matcher.Match(event) })
+}
+
+func (c *countCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	c.c.Clear()
+}
@@ -0,0 +1,57 @@
+package mocrelay
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountCacheKey(t *testing.T) {
+	a := []*ReqFilter{
+		{Authors: []string{"b", "a"}, Kinds: []int64{7, 1}},
+		{Tags: map[string][]string{"#e": {"y", "x"}}},
+	}
+	b := []*ReqFilter{
+		{Tags: map[string][]string{"#e": {"x", "y"}}},
+		{Kinds: []int64{1, 7}, Authors: []string{"a", "b", "a"}},
+	}
+	c := []*ReqFilter{{Authors: []string{"a", "b"}, Kinds: []int64{1}}}
+
+	assert.Equal(t, countCacheKey(a), countCacheKey(b))
+	assert.NotEqual(t, countCacheKey(a), countCacheKey(c))
+}
+
+func TestCountCache(t *testing.T) {
+	c := newCountCache(10, time.Hour)
+	kind1 := []*ReqFilter{{Kinds: []int64{1}}}
+	kind7 := []*ReqFilter{{Kinds: []int64{7}}}
+
+	_, gen, ok := c.Get("kind1")
+	assert.False(t, ok)
+	c.Set("kind1", kind1, 3, gen)
+	c.Set("kind7", kind7, 5, gen)
+
+	n, _, ok := c.Get("kind1")
+	assert.True(t, ok)
+	assert.Equal(t, uint64(3), n)
+
+	c.Invalidate(&Event{Kind: 1})
+	_, gen, ok = c.Get("kind1")
+	assert.False(t, ok)
+	n, _, ok = c.Get("kind7")
+	assert.True(t, ok)
+	assert.Equal(t, uint64(5), n)
+
+	// A result computed before an invalidation is not cached.
+	c.Invalidate(&Event{Kind: 7})
+	c.Set("kind1", kind1, 4, gen)
+	_, _, ok = c.Get("kind1")
+	assert.False(t, ok)
+
+	expired := newCountCache(10, time.Nanosecond)
+	expired.Set("kind1", kind1, 3, 0)
+	time.Sleep(time.Millisecond)
+	_, _, ok = expired.Get("kind1")
+	assert.False(t, ok)
+}
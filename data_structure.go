@@ -1,14 +1,21 @@
 package mocrelay
 
 import (
+	"container/heap"
+	"container/list"
+	"context"
 	"math/bits"
 	"math/rand"
 	"slices"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type ringBuffer[T any] struct {
 	Cap int
+	// Grow doubles the capacity on Enqueue into the full buffer instead of panicking.
+	Grow bool
 
 	s          []T
 	head, tail int
@@ -47,13 +54,29 @@ func (rb *ringBuffer[T]) Len() int {
 
 func (rb *ringBuffer[T]) Enqueue(v T) {
 	if rb.Len() == rb.Cap {
-		panic("enqueue into full ring buffer")
+		if !rb.Grow {
+			panic("enqueue into full ring buffer")
+		}
+		rb.grow()
 	}
 
 	rb.s[rb.mod(rb.tail)] = v
 	rb.tail++
 }
 
+func (rb *ringBuffer[T]) grow() {
+	s := make([]T, 2*rb.Cap)
+	n := rb.Len()
+	for i := 0; i < n; i++ {
+		s[i] = rb.s[rb.mod(rb.head+i)]
+	}
+
+	rb.Cap = len(s)
+	rb.s = s
+	rb.head = 0
+	rb.tail = n
+}
+
 func (rb *ringBuffer[T]) Dequeue() T {
 	if rb.Len() == 0 {
 		panic("dequeue from empty ring buffer")
@@ -82,14 +105,109 @@ func (rb *ringBuffer[T]) IdxFunc(f func(v T) bool) int {
 	return -1
 }
 
+// DeleteFunc deletes the elements for which del returns true and returns the number of them.
+// del is called from the oldest element.
+func (rb *ringBuffer[T]) DeleteFunc(del func(T) bool) int {
+	w := rb.head
+	for r := rb.head; r < rb.tail; r++ {
+		v := rb.s[rb.mod(r)]
+		if del(v) {
+			continue
+		}
+		rb.s[rb.mod(w)] = v
+		w++
+	}
+
+	var empty T
+	for i := w; i < rb.tail; i++ {
+		rb.s[rb.mod(i)] = empty
+	}
+
+	n := rb.tail - w
+	rb.tail = w
+	return n
+}
+
+// syncRingBuffer is a goroutine-safe ringBuffer.
+type syncRingBuffer[T any] struct {
+	mu       sync.Mutex
+	rb       *ringBuffer[T]
+	notEmpty chan struct{}
+}
+
+func newSyncRingBuffer[T any](capacity int, grow bool) *syncRingBuffer[T] {
+	rb := newRingBuffer[T](capacity)
+	rb.Grow = grow
+	return &syncRingBuffer[T]{
+		rb:       rb,
+		notEmpty: make(chan struct{}, 1),
+	}
+}
+
+func (b *syncRingBuffer[T]) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.rb.Len()
+}
+
+// Enqueue adds v. It panics if the buffer is full and doesn't grow.
+func (b *syncRingBuffer[T]) Enqueue(v T) {
+	b.Do(func(rb *ringBuffer[T]) { rb.Enqueue(v) })
+}
+
+// Do calls f with the buffer locked.
+func (b *syncRingBuffer[T]) Do(f func(rb *ringBuffer[T])) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	f(b.rb)
+	if b.rb.Len() > 0 {
+		trySignal(b.notEmpty)
+	}
+}
+
+// Dequeue removes and returns the oldest element if any.
+func (b *syncRingBuffer[T]) Dequeue() (v T, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.rb.Len() == 0 {
+		return
+	}
+	v = b.rb.Dequeue()
+	if b.rb.Len() > 0 {
+		trySignal(b.notEmpty)
+	}
+	return v, true
+}
+
+// DequeueWait is like Dequeue but waits for an element until ctx is done.
+func (b *syncRingBuffer[T]) DequeueWait(ctx context.Context) (v T, err error) {
+	for {
+		if v, ok := b.Dequeue(); ok {
+			return v, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return v, ctx.Err()
+		case <-b.notEmpty:
+		}
+	}
+}
+
+// NotEmpty returns a channel which receives a value when the buffer may have elements.
+func (b *syncRingBuffer[T]) NotEmpty() <-chan struct{} {
+	return b.notEmpty
+}
+
 const skipListMaxHeight = 16
 
 type skipList[K any, V any] struct {
 	Cmp  func(K, K) int
 	Head *skipListNode[K, V]
 
-	lenMu sync.RWMutex
-	len   int
+	len atomic.Int64
 
 	rndMu sync.Mutex
 	rnd   *rand.Rand
@@ -104,9 +222,7 @@ func newSkipList[K any, V any](cmp func(K, K) int) *skipList[K, V] {
 }
 
 func (l *skipList[K, V]) Len() int {
-	l.lenMu.RLock()
-	defer l.lenMu.RUnlock()
-	return l.len
+	return int(l.len.Load())
 }
 
 func (l *skipList[K, V]) Find(k K) (v V, ok bool) {
@@ -134,32 +250,153 @@ func (l *skipList[K, V]) Find(k K) (v V, ok bool) {
 	return
 }
 
+// lessNode returns the last node whose key is less than k, or l.Head.
+func (l *skipList[K, V]) lessNode(k K) *skipListNode[K, V] {
+	node := l.Head
+	for h := skipListMaxHeight - 1; h >= 0; h-- {
+		for {
+			node.NextsMu.RLock()
+			next := node.Nexts[h]
+			node.NextsMu.RUnlock()
+
+			if next == nil || l.Cmp(next.K, k) >= 0 {
+				break
+			}
+			node = next
+		}
+	}
+	return node
+}
+
+// Range calls yield for each entry whose key is in [from, to] in ascending order
+// until yield returns false.
+func (l *skipList[K, V]) Range(from, to K, yield func(K, V) bool) {
+	node := l.lessNode(from)
+	for {
+		node.NextsMu.RLock()
+		next := node.Nexts[0]
+		node.NextsMu.RUnlock()
+
+		if next == nil || l.Cmp(next.K, to) > 0 {
+			return
+		}
+		if !yield(next.K, next.V) {
+			return
+		}
+		node = next
+	}
+}
+
+// RangeDesc calls yield for each entry whose key is in [to, from] in descending order
+// until yield returns false.
+// Nodes have only forward links, so the entries in the range are collected first.
+func (l *skipList[K, V]) RangeDesc(from, to K, yield func(K, V) bool) {
+	var nodes []*skipListNode[K, V]
+	node := l.lessNode(to)
+	for {
+		node.NextsMu.RLock()
+		next := node.Nexts[0]
+		node.NextsMu.RUnlock()
+
+		if next == nil || l.Cmp(next.K, from) > 0 {
+			break
+		}
+		nodes = append(nodes, next)
+		node = next
+	}
+
+	for i := len(nodes) - 1; i >= 0; i-- {
+		if !yield(nodes[i].K, nodes[i].V) {
+			return
+		}
+	}
+}
+
 type skipListStackEntry[K, V any] struct {
 	node  *skipListNode[K, V]
 	nexts []*skipListNode[K, V]
 }
 
 func (l *skipList[K, V]) Add(k K, v V) (added bool) {
+	return l.AddCursor(nil, k, v)
+}
+
+// skipListCursor remembers the predecessors of the last added key at each height
+// so that adding a following key doesn't search from the head.
+// It is not goroutine-safe.
+type skipListCursor[K, V any] struct {
+	preds [skipListMaxHeight]*skipListNode[K, V]
+}
+
+// AddCursor is like Add but starts the search from cur if it is not nil.
+// It is fast for keys added in almost ascending order.
+func (l *skipList[K, V]) AddCursor(cur *skipListCursor[K, V], k K, v V) (added bool) {
 	var ok bool
 	for {
-		if added, ok = l.tryAdd(k, v); ok {
+		if added, ok = l.tryAdd(cur, k, v); ok {
 			if added {
-				l.lenMu.Lock()
-				defer l.lenMu.Unlock()
-				l.len++
+				l.len.Add(1)
 			}
 			return
 		}
 	}
 }
 
-func (l *skipList[K, V]) tryAdd(k K, v V) (added, ok bool) {
+type skipListEntry[K, V any] struct {
+	K K
+	V V
+}
+
+// AddAll adds entries and returns the number of added ones.
+// It is fast for entries in almost ascending order.
+func (l *skipList[K, V]) AddAll(entries []skipListEntry[K, V]) int {
+	var cur skipListCursor[K, V]
+	var n int
+	for _, e := range entries {
+		if l.AddCursor(&cur, e.K, e.V) {
+			n++
+		}
+	}
+	return n
+}
+
+// cursorNode returns the predecessor of k at height h in cur if it is after node.
+func (l *skipList[K, V]) cursorNode(
+	cur *skipListCursor[K, V],
+	node *skipListNode[K, V],
+	h int,
+	k K,
+) *skipListNode[K, V] {
+	if cur == nil {
+		return node
+	}
+
+	pred := cur.preds[h]
+	if pred == nil || pred == node || pred == l.Head {
+		return node
+	}
+	if l.Cmp(pred.K, k) >= 0 || node != l.Head && l.Cmp(pred.K, node.K) <= 0 {
+		return node
+	}
+
+	pred.NextsMu.RLock()
+	deleted := pred.deleted
+	pred.NextsMu.RUnlock()
+	if deleted {
+		return node
+	}
+	return pred
+}
+
+func (l *skipList[K, V]) tryAdd(cur *skipListCursor[K, V], k K, v V) (added, ok bool) {
 	switched := make([]skipListStackEntry[K, V], skipListMaxHeight)
 
 	var next *skipListNode[K, V]
 	var nexts []*skipListNode[K, V]
 	node := l.Head
 	for h := skipListMaxHeight - 1; h >= 0; h-- {
+		node = l.cursorNode(cur, node, h, k)
+
 		for {
 			node.NextsMu.RLock()
 			nexts = append([]*skipListNode[K, V](nil), node.Nexts...)
@@ -189,7 +426,20 @@ func (l *skipList[K, V]) tryAdd(k K, v V) (added, ok bool) {
 		Nexts: make([]*skipListNode[K, V], l.newHeight()),
 	}
 
-	return true, l.tryAddInsert(&newNode, switched)
+	if !l.tryAddInsert(&newNode, switched) {
+		return false, false
+	}
+
+	if cur != nil {
+		for h := range cur.preds {
+			if h < len(newNode.Nexts) {
+				cur.preds[h] = &newNode
+			} else {
+				cur.preds[h] = switched[h].node
+			}
+		}
+	}
+	return true, true
 }
 
 func (l *skipList[K, V]) tryAddInsert(
@@ -204,7 +454,7 @@ func (l *skipList[K, V]) tryAddInsert(
 			defer node.NextsMu.Unlock()
 		}
 
-		if !slices.Equal(node.Nexts, switched[h].nexts) {
+		if node.deleted || !slices.Equal(node.Nexts, switched[h].nexts) {
 			return
 		}
 	}
@@ -229,9 +479,7 @@ func (l *skipList[K, V]) Delete(k K) (deleted bool) {
 	for {
 		if deleted, ok = l.tryDelete(k); ok {
 			if deleted {
-				l.lenMu.Lock()
-				defer l.lenMu.Unlock()
-				l.len--
+				l.len.Add(-1)
 			}
 			return
 		}
@@ -293,6 +541,12 @@ func (l *skipList[K, V]) tryDeleteRemove(switched []skipListStackEntry[K, V]) (o
 		}
 	}
 
+	// Cursors must not insert after the removed node.
+	target := switched[0].node.Nexts[0]
+	target.NextsMu.Lock()
+	target.deleted = true
+	target.NextsMu.Unlock()
+
 	for h := len(switched) - 1; h >= 0; h-- {
 		if switched[h].node == nil {
 			continue
@@ -304,45 +558,246 @@ func (l *skipList[K, V]) tryDeleteRemove(switched []skipListStackEntry[K, V]) (o
 	return true
 }
 
+// DeleteFunc deletes entries for which del returns true and returns the number of them.
+func (l *skipList[K, V]) DeleteFunc(del func(K, V) bool) int {
+	var keys []K
+	node := l.Head
+	for {
+		node.NextsMu.RLock()
+		next := node.Nexts[0]
+		node.NextsMu.RUnlock()
+
+		if next == nil {
+			break
+		}
+		if del(next.K, next.V) {
+			keys = append(keys, next.K)
+		}
+		node = next
+	}
+
+	var n int
+	for _, k := range keys {
+		if l.Delete(k) {
+			n++
+		}
+	}
+	return n
+}
+
 type skipListNode[K any, V any] struct {
 	K K
 	V V
 
 	NextsMu sync.RWMutex
 	Nexts   []*skipListNode[K, V]
+	// deleted is true if the node is removed from the list. It is guarded by NextsMu.
+	deleted bool
 }
 
-type randCache[K comparable, V any] struct {
+// lruCache is a LRU cache with optional per-entry TTL. It is not goroutine-safe.
+type lruCache[K comparable, V any] struct {
 	Cap int
-	c   map[K]V
+	// TTL is the lifetime of entries added by Set. Zero means no expiration.
+	TTL time.Duration
+	// Hits and Misses count Get results if not nil.
+	Hits, Misses Counter
+
+	// map[key]elem of *lruCacheEntry
+	m map[K]*list.Element
+	l *list.List
 }
 
-func newRandCache[K comparable, V any](capacity int) *randCache[K, V] {
-	return &randCache[K, V]{
+type lruCacheEntry[K comparable, V any] struct {
+	key   K
+	value V
+	// expiresAt is zero if the entry never expires.
+	expiresAt time.Time
+}
+
+func newLRUCache[K comparable, V any](capacity int) *lruCache[K, V] {
+	if capacity <= 0 {
+		panicf("capacity must be positive but got %d", capacity)
+	}
+	return &lruCache[K, V]{
 		Cap: capacity,
-		c:   make(map[K]V, capacity),
+		m:   make(map[K]*list.Element, capacity),
+		l:   list.New(),
 	}
 }
 
-func (c *randCache[K, V]) Get(key K) (v V, found bool) {
-	v, found = c.c[key]
-	return
+func (c *lruCache[K, V]) Len() int {
+	return len(c.m)
+}
+
+// Get returns the value of key and marks it as recently used.
+func (c *lruCache[K, V]) Get(key K) (v V, found bool) {
+	elem, ok := c.m[key]
+	if !ok {
+		incCounter(c.Misses)
+		return
+	}
+
+	e := elem.Value.(*lruCacheEntry[K, V])
+	if !e.expiresAt.IsZero() && time.Now().After(e.expiresAt) {
+		c.remove(elem)
+		incCounter(c.Misses)
+		return
+	}
+
+	c.l.MoveToFront(elem)
+	incCounter(c.Hits)
+	return e.value, true
+}
+
+// Set sets value with TTL and returns true if key is new.
+func (c *lruCache[K, V]) Set(key K, value V) (added bool) {
+	return c.SetWithTTL(key, value, c.TTL)
+}
+
+// SetWithTTL is like Set but with its own ttl. Zero means no expiration.
+// The least recently used entry is evicted if the cache is full.
+func (c *lruCache[K, V]) SetWithTTL(key K, value V, ttl time.Duration) (added bool) {
+	var expiresAt time.Time
+	if ttl > 0 {
+		expiresAt = time.Now().Add(ttl)
+	}
+
+	if elem, ok := c.m[key]; ok {
+		e := elem.Value.(*lruCacheEntry[K, V])
+		e.value, e.expiresAt = value, expiresAt
+		c.l.MoveToFront(elem)
+		return false
+	}
+
+	if len(c.m) >= c.Cap {
+		c.remove(c.l.Back())
+	}
+	c.m[key] = c.l.PushFront(&lruCacheEntry[K, V]{key: key, value: value, expiresAt: expiresAt})
+	return true
 }
 
-func (c *randCache[K, V]) Set(key K, value V) (added bool) {
-	if _, ok := c.c[key]; ok {
+func (c *lruCache[K, V]) Delete(key K) (deleted bool) {
+	elem, ok := c.m[key]
+	if !ok {
 		return false
 	}
+	c.remove(elem)
+	return true
+}
 
-	if len(c.c) >= c.Cap {
-		var k K
-		for k = range c.c {
-			break
+// DeleteFunc deletes the entries for which f returns true.
+func (c *lruCache[K, V]) DeleteFunc(f func(key K, value V) bool) {
+	for key, elem := range c.m {
+		if f(key, elem.Value.(*lruCacheEntry[K, V]).value) {
+			c.remove(elem)
 		}
-		delete(c.c, k)
+	}
+}
+
+func (c *lruCache[K, V]) Clear() {
+	clear(c.m)
+	c.l.Init()
+}
+
+func (c *lruCache[K, V]) remove(elem *list.Element) {
+	delete(c.m, elem.Value.(*lruCacheEntry[K, V]).key)
+	c.l.Remove(elem)
+}
+
+// expiryQueue is a min-heap of keys ordered by their expiration times,
+// so that many expirations can be handled without a timer for each.
+// It is not goroutine-safe.
+type expiryQueue[K comparable] struct {
+	h expiryHeap[K]
+	m map[K]*expiryItem[K]
+}
+
+type expiryItem[K comparable] struct {
+	key K
+	at  time.Time
+	idx int
+}
+
+func newExpiryQueue[K comparable]() *expiryQueue[K] {
+	return &expiryQueue[K]{m: make(map[K]*expiryItem[K])}
+}
+
+func (q *expiryQueue[K]) Len() int { return len(q.h) }
+
+// Get returns the expiration time of key.
+func (q *expiryQueue[K]) Get(key K) (at time.Time, ok bool) {
+	item, ok := q.m[key]
+	if !ok {
+		return
+	}
+	return item.at, true
+}
+
+// Set adds key or updates its expiration time.
+func (q *expiryQueue[K]) Set(key K, at time.Time) {
+	if item, ok := q.m[key]; ok {
+		item.at = at
+		heap.Fix(&q.h, item.idx)
+		return
 	}
 
-	c.c[key] = value
+	item := &expiryItem[K]{key: key, at: at}
+	q.m[key] = item
+	heap.Push(&q.h, item)
+}
 
+func (q *expiryQueue[K]) Delete(key K) bool {
+	item, ok := q.m[key]
+	if !ok {
+		return false
+	}
+	heap.Remove(&q.h, item.idx)
+	delete(q.m, key)
 	return true
 }
+
+// Peek returns the key which expires first.
+func (q *expiryQueue[K]) Peek() (key K, at time.Time, ok bool) {
+	if len(q.h) == 0 {
+		return
+	}
+	return q.h[0].key, q.h[0].at, true
+}
+
+// PopExpired removes and returns the keys which expired before now, earliest first.
+func (q *expiryQueue[K]) PopExpired(now time.Time) []K {
+	var ret []K
+	for len(q.h) > 0 && q.h[0].at.Before(now) {
+		item := heap.Pop(&q.h).(*expiryItem[K])
+		delete(q.m, item.key)
+		ret = append(ret, item.key)
+	}
+	return ret
+}
+
+type expiryHeap[K comparable] []*expiryItem[K]
+
+func (h expiryHeap[K]) Len() int           { return len(h) }
+func (h expiryHeap[K]) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+
+func (h expiryHeap[K]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].idx = i
+	h[j].idx = j
+}
+
+func (h *expiryHeap[K]) Push(x any) {
+	item := x.(*expiryItem[K])
+	item.idx = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *expiryHeap[K]) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return item
+}
@@ -2,8 +2,11 @@ package mocrelay
 
 import (
 	"cmp"
+	"context"
 	"math/rand"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -88,6 +91,74 @@ func TestRingBuffer_IdxFunc(t *testing.T) {
 	}
 }
 
+func TestRingBuffer_Grow(t *testing.T) {
+	b := newRingBuffer[int](2)
+	b.Grow = true
+
+	b.Enqueue(1)
+	b.Enqueue(2)
+	assert.Equal(t, 1, b.Dequeue())
+	b.Enqueue(3)
+	b.Enqueue(4)
+	b.Enqueue(5)
+	assert.Equal(t, 4, b.Cap)
+	assert.Equal(t, 4, b.Len())
+
+	for _, want := range []int{2, 3, 4, 5} {
+		assert.Equal(t, want, b.Dequeue())
+	}
+	assert.Panics(t, func() { b.Dequeue() })
+}
+
+func TestRingBuffer_DeleteFunc(t *testing.T) {
+	b := newRingBuffer[int](5)
+	b.Enqueue(0)
+	b.Dequeue()
+	for i := 1; i <= 5; i++ {
+		b.Enqueue(i)
+	}
+
+	var called []int
+	n := b.DeleteFunc(func(v int) bool {
+		called = append(called, v)
+		return v%2 == 0
+	})
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []int{1, 2, 3, 4, 5}, called, "called from the oldest")
+	assert.Equal(t, 3, b.Len())
+
+	b.Enqueue(6)
+	b.Enqueue(7)
+	for _, want := range []int{1, 3, 5, 6, 7} {
+		assert.Equal(t, want, b.Dequeue())
+	}
+}
+
+func TestSyncRingBuffer_DequeueWait(t *testing.T) {
+	b := newSyncRingBuffer[int](1, false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := b.DequeueWait(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		b.Enqueue(1)
+	}()
+	v, err := b.DequeueWait(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, v)
+
+	b.Enqueue(2)
+	assert.Panics(t, func() { b.Enqueue(3) })
+	v, ok := b.Dequeue()
+	assert.True(t, ok)
+	assert.Equal(t, 2, v)
+	_, ok = b.Dequeue()
+	assert.False(t, ok)
+}
+
 func TestSkipList_Find(t *testing.T) {
 	type entry struct{ k, v int }
 
@@ -380,6 +451,208 @@ func TestSkipList_Delete(t *testing.T) {
 	}
 }
 
+func TestSkipList_AddAll(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []int
+		added int
+		want  []int
+	}{
+		{
+			name:  "empty",
+			input: nil,
+			added: 0,
+			want:  nil,
+		},
+		{
+			name:  "ascending",
+			input: []int{1, 2, 3, 4, 5},
+			added: 5,
+			want:  []int{1, 2, 3, 4, 5},
+		},
+		{
+			name:  "almost ascending",
+			input: []int{1, 3, 2, 5, 4, 6},
+			added: 6,
+			want:  []int{1, 2, 3, 4, 5, 6},
+		},
+		{
+			name:  "descending",
+			input: []int{5, 4, 3, 2, 1},
+			added: 5,
+			want:  []int{1, 2, 3, 4, 5},
+		},
+		{
+			name:  "duplicated",
+			input: []int{1, 2, 2, 3, 1},
+			added: 3,
+			want:  []int{1, 2, 3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newSkipList[int, int](cmp.Compare[int])
+
+			entries := make([]skipListEntry[int, int], len(tt.input))
+			for i, k := range tt.input {
+				entries[i] = skipListEntry[int, int]{K: k, V: k}
+			}
+			assert.Equal(t, tt.added, l.AddAll(entries))
+
+			var got []int
+			for node := l.Head.Nexts[0]; node != nil; node = node.Nexts[0] {
+				got = append(got, node.V)
+			}
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, len(tt.want), l.Len())
+		})
+	}
+}
+
+func TestSkipList_AddCursor_concurrent(t *testing.T) {
+	l := newSkipList[int, int](cmp.Compare[int])
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			var cur skipListCursor[int, int]
+			for k := i; k < 4000; k += 4 {
+				l.AddCursor(&cur, k, k)
+				if k%3 == 0 {
+					l.Delete(k)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var got []int
+	l.Range(0, 4000, func(k, _ int) bool {
+		got = append(got, k)
+		return true
+	})
+
+	var want []int
+	for k := 0; k < 4000; k++ {
+		if k%3 != 0 {
+			want = append(want, k)
+		}
+	}
+	assert.Equal(t, want, got)
+	assert.Equal(t, len(want), l.Len())
+}
+
+func TestSkipList_DeleteFunc(t *testing.T) {
+	l := newSkipList[int, int](cmp.Compare[int])
+	for k := 0; k < 10; k++ {
+		l.Add(k, k*10)
+	}
+
+	assert.Equal(t, 5, l.DeleteFunc(func(_, v int) bool { return v%20 == 0 }))
+	assert.Equal(t, 0, l.DeleteFunc(func(_, v int) bool { return v > 100 }))
+
+	var got []int
+	for node := l.Head.Nexts[0]; node != nil; node = node.Nexts[0] {
+		got = append(got, node.K)
+	}
+	assert.Equal(t, []int{1, 3, 5, 7, 9}, got)
+	assert.Equal(t, 5, l.Len())
+}
+
+func TestSkipList_Range(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []int
+		from, to int
+		limit    int
+		want     []int
+		wantDesc []int
+	}{
+		{
+			name:     "empty",
+			input:    nil,
+			from:     0,
+			to:       10,
+			limit:    -1,
+			want:     nil,
+			wantDesc: nil,
+		},
+		{
+			name:     "all",
+			input:    []int{1, 2, 3, 4, 5},
+			from:     0,
+			to:       10,
+			limit:    -1,
+			want:     []int{1, 2, 3, 4, 5},
+			wantDesc: []int{5, 4, 3, 2, 1},
+		},
+		{
+			name:     "inclusive",
+			input:    []int{1, 2, 3, 4, 5},
+			from:     2,
+			to:       4,
+			limit:    -1,
+			want:     []int{2, 3, 4},
+			wantDesc: []int{4, 3, 2},
+		},
+		{
+			name:     "between keys",
+			input:    []int{1, 3, 5, 7, 9},
+			from:     2,
+			to:       6,
+			limit:    -1,
+			want:     []int{3, 5},
+			wantDesc: []int{5, 3},
+		},
+		{
+			name:     "out of range",
+			input:    []int{1, 2, 3},
+			from:     4,
+			to:       10,
+			limit:    -1,
+			want:     nil,
+			wantDesc: nil,
+		},
+		{
+			name:     "stop",
+			input:    []int{1, 2, 3, 4, 5},
+			from:     1,
+			to:       5,
+			limit:    2,
+			want:     []int{1, 2},
+			wantDesc: []int{5, 4},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newSkipList[int, int](cmp.Compare[int])
+			for _, k := range tt.input {
+				l.Add(k, k*10)
+			}
+
+			collect := func(got *[]int) func(int, int) bool {
+				return func(k, v int) bool {
+					assert.Equal(t, k*10, v)
+					*got = append(*got, k)
+					return tt.limit < 0 || len(*got) < tt.limit
+				}
+			}
+
+			var got, gotDesc []int
+			l.Range(tt.from, tt.to, collect(&got))
+			l.RangeDesc(tt.to, tt.from, collect(&gotDesc))
+
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wantDesc, gotDesc)
+		})
+	}
+}
+
 func TestSkipList_newHeight(t *testing.T) {
 	l := newSkipList[int, int](cmp.Compare[int])
 
@@ -393,6 +666,46 @@ func TestSkipList_newHeight(t *testing.T) {
 	assert.Equal(t, 16, large)
 }
 
+func TestLRUCache(t *testing.T) {
+	var hits, misses testCounter
+	c := newLRUCache[string, int](2)
+	c.Hits, c.Misses = &hits, &misses
+
+	assert.True(t, c.Set("a", 1))
+	assert.True(t, c.Set("b", 2))
+	_, found := c.Get("a")
+	assert.True(t, found)
+
+	// b is the least recently used.
+	assert.True(t, c.Set("c", 3))
+	_, found = c.Get("b")
+	assert.False(t, found)
+
+	assert.False(t, c.Set("a", 10))
+	v, found := c.Get("a")
+	assert.True(t, found)
+	assert.Equal(t, 10, v)
+	assert.Equal(t, 2, c.Len())
+
+	c.SetWithTTL("d", 4, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	_, found = c.Get("d")
+	assert.False(t, found)
+	assert.Equal(t, 1, c.Len())
+
+	assert.Equal(t, 2, hits.n)
+	assert.Equal(t, 2, misses.n)
+
+	c.Set("e", 5)
+	c.DeleteFunc(func(k string, v int) bool { return v == 5 })
+	assert.Equal(t, 1, c.Len())
+	assert.True(t, c.Delete("a"))
+	assert.False(t, c.Delete("a"))
+	c.Set("f", 6)
+	c.Clear()
+	assert.Equal(t, 0, c.Len())
+}
+
 func BenchmarkSkipList(b *testing.B) {
 	const length = 10000
 
@@ -410,3 +723,39 @@ func BenchmarkSkipList(b *testing.B) {
 		}
 	})
 }
+
+func TestExpiryQueue(t *testing.T) {
+	base := time.Unix(1000, 0)
+	at := func(sec int) time.Time { return base.Add(time.Duration(sec) * time.Second) }
+
+	q := newExpiryQueue[string]()
+	_, _, ok := q.Peek()
+	assert.False(t, ok)
+
+	q.Set("c", at(3))
+	q.Set("a", at(1))
+	q.Set("b", at(2))
+	q.Set("d", at(4))
+	assert.Equal(t, 4, q.Len())
+
+	key, got, ok := q.Peek()
+	assert.True(t, ok)
+	assert.Equal(t, "a", key)
+	assert.Equal(t, at(1), got)
+
+	q.Set("a", at(5))
+	got, ok = q.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, at(5), got)
+
+	assert.True(t, q.Delete("c"))
+	assert.False(t, q.Delete("c"))
+
+	assert.Nil(t, q.PopExpired(at(2)), "not before now")
+	assert.Equal(t, []string{"b", "d"}, q.PopExpired(at(5)))
+	assert.Equal(t, []string{"a"}, q.PopExpired(at(6)))
+	assert.Equal(t, 0, q.Len())
+
+	_, ok = q.Get("a")
+	assert.False(t, ok)
+}
@@ -0,0 +1,203 @@
+package mocrelay
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrDebugTapFull     = errors.New("too many debug taps")
+	ErrDebugTapNotFound = errors.New("debug tap not found")
+)
+
+type DebugTapOption struct {
+	// MaxTaps is the max number of connections tapped at the same time.
+	// The default is 16.
+	MaxTaps int
+
+	// MaxFrames is the number of the latest frames kept for each connection.
+	// The default is 1000.
+	MaxFrames int
+
+	// MaxFrameSize is the max size of a kept frame. Longer frames are truncated.
+	// The default is 4096.
+	MaxFrameSize int
+}
+
+func (opt *DebugTapOption) maxTaps() int {
+	if opt == nil || opt.MaxTaps <= 0 {
+		return 16
+	}
+	return opt.MaxTaps
+}
+
+func (opt *DebugTapOption) maxFrames() int {
+	if opt == nil || opt.MaxFrames <= 0 {
+		return 1000
+	}
+	return opt.MaxFrames
+}
+
+func (opt *DebugTapOption) maxFrameSize() int {
+	if opt == nil || opt.MaxFrameSize <= 0 {
+		return 4096
+	}
+	return opt.MaxFrameSize
+}
+
+type DebugFrame struct {
+	// Seq is the sequence number of the frame in the tap starting from 1.
+	Seq  uint64    `json:"seq"`
+	Time time.Time `json:"time"`
+	Dir  string    `json:"dir"`
+	// Binary reports whether the frame is binary. Data of binary frames is base64 encoded.
+	Binary bool   `json:"binary,omitempty"`
+	Data   string `json:"data"`
+	// Size is the size of the frame before truncation.
+	Size      int  `json:"size"`
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// DebugTap keeps the latest raw frames of the connections an admin tapped
+// for live troubleshooting of misbehaving clients. Connections are identified
+// by their request IDs. It costs almost nothing while no connections are tapped.
+type DebugTap struct {
+	opt *DebugTapOption
+
+	n    atomic.Int32
+	mu   sync.Mutex
+	taps map[string]*debugTapRing
+}
+
+type debugTapRing struct {
+	frames []DebugFrame
+	next   uint64
+}
+
+func NewDebugTap(option *DebugTapOption) *DebugTap {
+	return &DebugTap{
+		opt:  option,
+		taps: make(map[string]*debugTapRing),
+	}
+}
+
+// Start starts tapping connID. It does nothing if connID is already tapped.
+func (tap *DebugTap) Start(connID string) error {
+	tap.mu.Lock()
+	defer tap.mu.Unlock()
+
+	if _, ok := tap.taps[connID]; ok {
+		return nil
+	}
+	if len(tap.taps) >= tap.opt.maxTaps() {
+		return ErrDebugTapFull
+	}
+	tap.taps[connID] = &debugTapRing{next: 1}
+	tap.n.Store(int32(len(tap.taps)))
+	return nil
+}
+
+// Stop stops tapping connID and drops its frames.
+func (tap *DebugTap) Stop(connID string) error {
+	tap.mu.Lock()
+	defer tap.mu.Unlock()
+
+	if _, ok := tap.taps[connID]; !ok {
+		return ErrDebugTapNotFound
+	}
+	delete(tap.taps, connID)
+	tap.n.Store(int32(len(tap.taps)))
+	return nil
+}
+
+// Tapped returns the sorted IDs of the tapped connections.
+func (tap *DebugTap) Tapped() []string {
+	tap.mu.Lock()
+	defer tap.mu.Unlock()
+
+	ret := make([]string, 0, len(tap.taps))
+	for id := range tap.taps {
+		ret = append(ret, id)
+	}
+	slices.Sort(ret)
+	return ret
+}
+
+// Frames returns the kept frames of connID whose sequence numbers are greater than since.
+// Clients can poll it with the last sequence number they got.
+func (tap *DebugTap) Frames(connID string, since uint64) ([]DebugFrame, error) {
+	tap.mu.Lock()
+	defer tap.mu.Unlock()
+
+	ring, ok := tap.taps[connID]
+	if !ok {
+		return nil, ErrDebugTapNotFound
+	}
+
+	// The oldest frame is next to the latest one once the ring is full.
+	start := 0
+	if max := tap.opt.maxFrames(); len(ring.frames) == max {
+		start = int((ring.next - 1) % uint64(max))
+	}
+
+	ret := make([]DebugFrame, 0, len(ring.frames))
+	for i := range ring.frames {
+		f := ring.frames[(start+i)%len(ring.frames)]
+		if f.Seq > since {
+			ret = append(ret, f)
+		}
+	}
+	return ret, nil
+}
+
+func (tap *DebugTap) record(ctx context.Context, dir string, typ WSMessageType, b []byte) {
+	if tap == nil || tap.n.Load() == 0 {
+		return
+	}
+
+	connID := GetRequestID(ctx)
+
+	tap.mu.Lock()
+	defer tap.mu.Unlock()
+
+	ring, ok := tap.taps[connID]
+	if !ok {
+		return
+	}
+
+	f := DebugFrame{
+		Seq:    ring.next,
+		Time:   time.Now(),
+		Dir:    dir,
+		Binary: typ != WSMessageText,
+		Size:   len(b),
+	}
+	if max := tap.opt.maxFrameSize(); len(b) > max {
+		b, f.Truncated = b[:max], true
+	}
+	if f.Binary {
+		f.Data = base64.StdEncoding.EncodeToString(b)
+	} else {
+		f.Data = string(b)
+	}
+
+	if max := tap.opt.maxFrames(); len(ring.frames) < max {
+		ring.frames = append(ring.frames, f)
+	} else {
+		ring.frames[int((ring.next-1)%uint64(max))] = f
+	}
+	ring.next++
+}
+
+func (tap *DebugTap) recordRecv(ctx context.Context, typ WSMessageType, b []byte) {
+	tap.record(ctx, TrafficDirRecv, typ, b)
+}
+
+func (tap *DebugTap) recordSend(ctx context.Context, b []byte) {
+	tap.record(ctx, TrafficDirSend, WSMessageText, b)
+}
@@ -0,0 +1,72 @@
+package mocrelay
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDebugTap(t *testing.T) {
+	tap := NewDebugTap(&DebugTapOption{MaxFrames: 3, MaxFrameSize: 8})
+	ctx := ctxWithTestSession(context.Background(), "")
+	other := ctxWithTestSession(context.Background(), "")
+	id := GetRequestID(ctx)
+
+	tap.recordRecv(ctx, WSMessageText, []byte(`["REQ"]`))
+	_, err := tap.Frames(id, 0)
+	assert.ErrorIs(t, err, ErrDebugTapNotFound)
+
+	require.NoError(t, tap.Start(id))
+	assert.Equal(t, []string{id}, tap.Tapped())
+
+	tap.recordRecv(ctx, WSMessageText, []byte(`["REQ","sub"]`))
+	tap.recordRecv(ctx, WSMessageBinary, []byte{0xff})
+	tap.recordRecv(other, WSMessageText, []byte(`["REQ"]`))
+	tap.recordSend(ctx, []byte(`["EOSE"]`))
+
+	frames, err := tap.Frames(id, 0)
+	require.NoError(t, err)
+	require.Len(t, frames, 3)
+	assert.Equal(t, DebugFrame{
+		Seq:       1,
+		Time:      frames[0].Time,
+		Dir:       TrafficDirRecv,
+		Data:      `["REQ","`,
+		Size:      13,
+		Truncated: true,
+	}, frames[0])
+	assert.True(t, frames[1].Binary)
+	assert.Equal(t, "/w==", frames[1].Data)
+	assert.Equal(t, TrafficDirSend, frames[2].Dir)
+	assert.Equal(t, `["EOSE"]`, frames[2].Data)
+
+	// The oldest frames are dropped.
+	tap.recordSend(ctx, []byte(`4`))
+	tap.recordSend(ctx, []byte(`5`))
+	frames, err = tap.Frames(id, 0)
+	require.NoError(t, err)
+	var seqs []string
+	for _, f := range frames {
+		seqs = append(seqs, strconv.FormatUint(f.Seq, 10)+":"+f.Data)
+	}
+	assert.Equal(t, []string{`3:["EOSE"]`, "4:4", "5:5"}, seqs)
+
+	frames, err = tap.Frames(id, 4)
+	require.NoError(t, err)
+	require.Len(t, frames, 1)
+	assert.Equal(t, uint64(5), frames[0].Seq)
+
+	require.NoError(t, tap.Stop(id))
+	assert.ErrorIs(t, tap.Stop(id), ErrDebugTapNotFound)
+	assert.Empty(t, tap.Tapped())
+}
+
+func TestDebugTap_maxTaps(t *testing.T) {
+	tap := NewDebugTap(&DebugTapOption{MaxTaps: 1})
+	require.NoError(t, tap.Start("a"))
+	require.NoError(t, tap.Start("a"))
+	assert.ErrorIs(t, tap.Start("b"), ErrDebugTapFull)
+}
@@ -0,0 +1,91 @@
+package mocrelay
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+type EgressLimitOption struct {
+	// BytesPerSec is the max outbound bytes per second of a connection. Zero means no limit.
+	BytesPerSec int64
+
+	// GlobalBytesPerSec is the max outbound bytes per second of all the connections.
+	// Zero means no limit.
+	GlobalBytesPerSec int64
+
+	// Burst is how long the unused bandwidth is saved up for. The default is 1 second.
+	Burst time.Duration
+}
+
+func (opt *EgressLimitOption) burst() time.Duration {
+	if opt.Burst == 0 {
+		return time.Second
+	}
+	return opt.Burst
+}
+
+// byteLimiter is a token bucket of bytes shared by goroutines.
+// A write larger than the bucket is allowed by borrowing from the future tokens.
+type byteLimiter struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newByteLimiter(bytesPerSec int64, burst time.Duration) *byteLimiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	b := float64(bytesPerSec) * burst.Seconds()
+	return &byteLimiter{
+		rate:   float64(bytesPerSec),
+		burst:  b,
+		tokens: b,
+		last:   time.Now(),
+	}
+}
+
+// reserve takes n bytes and returns how long to wait before writing them.
+func (l *byteLimiter) reserve(n int, now time.Time) time.Duration {
+	if l == nil {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if elapsed := now.Sub(l.last); elapsed > 0 {
+		l.tokens = math.Min(l.burst, l.tokens+elapsed.Seconds()*l.rate)
+		l.last = now
+	}
+
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// waitEgress waits until both the connection and the global limiters allow n bytes.
+func waitEgress(ctx context.Context, conn, global *byteLimiter, n int) error {
+	now := time.Now()
+	wait := max(conn.reserve(n, now), global.reserve(n, now))
+	if wait <= 0 {
+		return nil
+	}
+
+	t := time.NewTimer(wait)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,78 @@
+package mocrelay
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"nhooyr.io/websocket"
+)
+
+func TestByteLimiter_reserve(t *testing.T) {
+	l := newByteLimiter(100, time.Second)
+	now := l.last
+
+	assert.Equal(t, time.Duration(0), l.reserve(60, now))
+	assert.Equal(t, time.Duration(0), l.reserve(40, now))
+	assert.Equal(t, 500*time.Millisecond, l.reserve(50, now), "borrows from the future")
+
+	assert.Equal(t, time.Duration(0), l.reserve(50, now.Add(time.Second)))
+	assert.Equal(t, time.Duration(0), l.reserve(100, now.Add(time.Hour)))
+	assert.Equal(
+		t,
+		time.Second,
+		l.reserve(100, now.Add(time.Hour)),
+		"tokens don't exceed the burst",
+	)
+
+	assert.Nil(t, newByteLimiter(0, time.Second))
+	var nilLimiter *byteLimiter
+	assert.Equal(t, time.Duration(0), nilLimiter.reserve(100, now))
+}
+
+func TestWaitEgress(t *testing.T) {
+	conn := newByteLimiter(1000, time.Second)
+	global := newByteLimiter(10, time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	assert.NoError(t, waitEgress(ctx, conn, global, 10))
+	assert.ErrorIs(t, waitEgress(ctx, conn, global, 10), context.DeadlineExceeded)
+	assert.NoError(t, waitEgress(ctx, nil, nil, 10))
+}
+
+func TestRelay_egressLimit(t *testing.T) {
+	relay := NewRelay(NewRouterHandler(10), &RelayOption{
+		EgressLimit: &EgressLimitOption{BytesPerSec: 100, Burst: 100 * time.Millisecond},
+	})
+	srv := httptest.NewServer(relay)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	conn, _, err := websocket.Dial(ctx, url, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close(websocket.StatusNormalClosure, "")
+
+	start := time.Now()
+	for _, subID := range []string{"sub1", "sub2"} {
+		err = conn.Write(ctx, websocket.MessageText, []byte(`["REQ","`+subID+`",{}]`))
+		assert.NoError(t, err)
+	}
+	for _, subID := range []string{"sub1", "sub2"} {
+		_, b, err := conn.Read(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, `["EOSE","`+subID+`"]`, string(b))
+	}
+
+	// Two EOSE of 15 bytes take 0.2 seconds with 10 bytes of burst.
+	assert.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+}
@@ -1,24 +1,66 @@
 package mocrelay
 
 import (
+	"cmp"
+	"context"
 	"fmt"
+	"hash/maphash"
 	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type eventCache struct {
 	rb   *ringBuffer[*Event]
 	ids  map[string]*Event
 	keys map[string]*Event
+
+	// maxBytes is the memory budget of events. Zero means no limit.
+	maxBytes int64
+	bytes    atomic.Int64
+	// evictions counts live events evicted for the capacity or maxBytes if not nil.
+	evictions Counter
+
+	// expiry has IDs of events with NIP-40 expiration.
+	expiry *expiryQueue[string]
 }
 
 func newEventCache(capacity int) *eventCache {
 	return &eventCache{
-		rb:   newRingBuffer[*Event](capacity),
-		ids:  make(map[string]*Event, capacity),
-		keys: make(map[string]*Event, capacity),
+		rb:     newRingBuffer[*Event](capacity),
+		ids:    make(map[string]*Event, capacity),
+		keys:   make(map[string]*Event, capacity),
+		expiry: newExpiryQueue[string](),
 	}
 }
 
+// eventExpired returns true if event has expired at now.
+func eventExpired(event *Event, now time.Time) bool {
+	at, ok := event.Tags.ExpirationTag()
+	return ok && at.Before(now)
+}
+
+// eventOverhead is the approximate bytes of an event in the cache except for its fields,
+// i.e. the struct, map entries and the ring buffer slot.
+const eventOverhead = 256
+
+// approxEventSize returns the approximate bytes of event in memory.
+func approxEventSize(event *Event) int64 {
+	n := eventOverhead + len(event.ID) + len(event.Pubkey) + len(event.Content) + len(event.Sig) +
+		cap(event.raw)
+	for _, tag := range event.Tags {
+		n += 24
+		for _, v := range tag {
+			n += 16 + len(v)
+		}
+	}
+	for k, v := range event.Annotations {
+		n += 32 + len(k) + len(v)
+	}
+	return int64(n)
+}
+
 func (*eventCache) eventKeyRegular(event *Event) string { return event.ID }
 
 func (*eventCache) eventKeyReplaceable(event *Event) string {
@@ -26,18 +68,10 @@ func (*eventCache) eventKeyReplaceable(event *Event) string {
 }
 
 func (*eventCache) eventKeyParameterized(event *Event) string {
-	idx := slices.IndexFunc(event.Tags, func(t Tag) bool {
-		return len(t) >= 1 && t[0] == "d"
-	})
-	if idx < 0 {
+	d, ok := event.Tags.DTag()
+	if !ok {
 		return ""
 	}
-
-	d := ""
-	if len(event.Tags[idx]) > 1 {
-		d = event.Tags[idx][1]
-	}
-
 	return fmt.Sprintf("%s:%d:%s", event.Pubkey, event.Kind, d)
 }
 
@@ -56,21 +90,30 @@ func (c *eventCache) eventKey(event *Event) (key string, ok bool) {
 }
 
 func (c *eventCache) Add(event *Event) (added bool) {
-	if c.ids[event.ID] != nil {
+	now := time.Now()
+	c.expire(now)
+
+	if c.ids[event.ID] != nil || eventExpired(event, now) {
 		return
 	}
 	key, ok := c.eventKey(event)
 	if !ok {
 		return
 	}
-	if old, ok := c.keys[key]; ok && old.CreatedAt > event.CreatedAt {
+	if ResolveReplaceable(c.keys[key], event) == KeepExisting {
+		return
+	}
+
+	size := approxEventSize(event)
+	if c.maxBytes > 0 && size > c.maxBytes {
 		return
 	}
 
 	idx := c.rb.IdxFunc(func(v *Event) bool {
 		return v.CreatedAt < event.CreatedAt
 	})
-	if c.rb.Len() == c.rb.Cap && idx < 0 {
+	full := c.rb.Len() == c.rb.Cap || c.maxBytes > 0 && c.bytes.Load()+size > c.maxBytes
+	if full && idx < 0 {
 		return
 	}
 
@@ -78,13 +121,10 @@ func (c *eventCache) Add(event *Event) (added bool) {
 	c.keys[key] = event
 
 	if c.rb.Len() == c.rb.Cap {
-		old := c.rb.Dequeue()
-		if k, _ := c.eventKey(old); c.keys[k] == old {
-			delete(c.keys, k)
-		}
-		delete(c.ids, old.ID)
+		c.evictOldest()
 	}
 	c.rb.Enqueue(event)
+	c.bytes.Add(size)
 
 	for i := 0; i+1 < c.rb.Len(); i++ {
 		if c.rb.At(i).CreatedAt < c.rb.At(i+1).CreatedAt {
@@ -92,10 +132,51 @@ func (c *eventCache) Add(event *Event) (added bool) {
 		}
 	}
 
-	added = true
+	for c.maxBytes > 0 && c.bytes.Load() > c.maxBytes {
+		c.evictOldest()
+	}
+
+	// event itself can be evicted if newer events fill the budget.
+	added = c.ids[event.ID] == event
+	if at, ok := event.Tags.ExpirationTag(); ok && added {
+		c.expiry.Set(event.ID, at)
+	}
 	return
 }
 
+// expire deletes the events which expired before now.
+func (c *eventCache) expire(now time.Time) {
+	for _, id := range c.expiry.PopExpired(now) {
+		event := c.ids[id]
+		if event == nil {
+			continue
+		}
+		if k, _ := c.eventKey(event); c.keys[k] == event {
+			delete(c.keys, k)
+		}
+		delete(c.ids, id)
+	}
+}
+
+func (c *eventCache) evictOldest() {
+	old := c.rb.Dequeue()
+	c.bytes.Add(-approxEventSize(old))
+
+	if c.ids[old.ID] == old {
+		incCounter(c.evictions)
+		delete(c.ids, old.ID)
+		c.expiry.Delete(old.ID)
+	}
+	if k, _ := c.eventKey(old); c.keys[k] == old {
+		delete(c.keys, k)
+	}
+}
+
+// Bytes returns the approximate bytes of events in the cache.
+func (c *eventCache) Bytes() int64 {
+	return c.bytes.Load()
+}
+
 func (c *eventCache) DeleteID(id, pubkey string) {
 	event := c.ids[id]
 	if event == nil || event.Pubkey != pubkey {
@@ -106,6 +187,7 @@ func (c *eventCache) DeleteID(id, pubkey string) {
 		delete(c.keys, k)
 	}
 	delete(c.ids, id)
+	c.expiry.Delete(id)
 }
 
 func (c *eventCache) DeleteNaddr(naddr, pubkey string) {
@@ -115,12 +197,60 @@ func (c *eventCache) DeleteNaddr(naddr, pubkey string) {
 	}
 	delete(c.ids, event.ID)
 	delete(c.keys, naddr)
+	c.expiry.Delete(event.ID)
+}
+
+// DeletePubkey deletes all events by pubkey and returns the number of them.
+func (c *eventCache) DeletePubkey(pubkey string) int {
+	var n int
+	for id, event := range c.ids {
+		if event.Pubkey != pubkey {
+			continue
+		}
+		if k, _ := c.eventKey(event); c.keys[k] == event {
+			delete(c.keys, k)
+			n++
+		}
+		delete(c.ids, id)
+		c.expiry.Delete(id)
+	}
+	return n
+}
+
+// Events returns the live events in the cache, newest first.
+func (c *eventCache) Events() []*Event {
+	ret := make([]*Event, 0, len(c.ids))
+	for i := 0; i < c.rb.Len(); i++ {
+		ev := c.rb.At(i)
+		if c.ids[ev.ID] != ev {
+			continue
+		}
+		if k, _ := c.eventKey(ev); c.keys[k] != ev {
+			continue
+		}
+		ret = append(ret, ev)
+	}
+	return ret
 }
 
 func (c *eventCache) Find(matcher EventCountMatcher) []*Event {
+	ret, _ := c.FindContext(context.Background(), matcher)
+	return ret
+}
+
+// FindContext is like Find but returns the events found so far with ctx.Err()
+// when ctx is done.
+func (c *eventCache) FindContext(ctx context.Context, matcher EventCountMatcher) ([]*Event, error) {
 	var ret []*Event
+	now := time.Now()
 
 	for i := 0; i < c.rb.Len(); i++ {
+		if i%1024 == 0 {
+			if err := ctx.Err(); err != nil {
+				return ret, err
+			}
+		}
+
 		ev := c.rb.At(i)
 
 		if c.ids[ev.ID] == nil {
@@ -129,6 +259,9 @@ func (c *eventCache) Find(matcher EventCountMatcher) []*Event {
 		if k, _ := c.eventKey(ev); c.keys[k] != ev {
 			continue
 		}
+		if at, ok := c.expiry.Get(ev.ID); ok && at.Before(now) {
+			continue
+		}
 
 		if matcher.Done() {
 			break
@@ -138,5 +271,187 @@ func (c *eventCache) Find(matcher EventCountMatcher) []*Event {
 		}
 	}
 
+	return ret, nil
+}
+
+// shardedEventCache partitions events into eventCaches by their keys, which are the IDs
+// of regular events, so that inserts and scans on different shards don't contend.
+type shardedEventCache struct {
+	seed   maphash.Seed
+	shards []*eventCacheShard
+	// idMatchMode is how the ids and authors of filters match events.
+	idMatchMode IDMatchMode
+}
+
+type eventCacheShard struct {
+	mu sync.RWMutex
+	c  *eventCache
+}
+
+// newShardedEventCache returns a cache of n shards, each of which has capacity/n events
+// and maxBytes/n bytes.
+func newShardedEventCache(
+	capacity, n int,
+	maxBytes int64,
+	evictions Counter,
+) *shardedEventCache {
+	if n <= 0 {
+		panicf("number of shards must be positive but got %d", n)
+	}
+	n = min(n, capacity)
+
+	c := &shardedEventCache{
+		seed:   maphash.MakeSeed(),
+		shards: make([]*eventCacheShard, n),
+	}
+	for i := range c.shards {
+		ec := newEventCache((capacity + n - 1) / n)
+		ec.maxBytes = maxBytes / int64(n)
+		ec.evictions = evictions
+		c.shards[i] = &eventCacheShard{c: ec}
+	}
+	return c
+}
+
+func (c *shardedEventCache) shard(key string) *eventCacheShard {
+	if len(c.shards) == 1 {
+		return c.shards[0]
+	}
+	return c.shards[maphash.String(c.seed, key)%uint64(len(c.shards))]
+}
+
+func (c *shardedEventCache) Add(event *Event) bool {
+	key, ok := c.shards[0].c.eventKey(event)
+	if !ok {
+		return false
+	}
+
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.c.Add(event)
+}
+
+// Has reports whether the event is live in the cache.
+func (c *shardedEventCache) Has(event *Event) bool {
+	key, ok := c.shards[0].c.eventKey(event)
+	if !ok {
+		return false
+	}
+
+	s := c.shard(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.c.ids[event.ID] == event
+}
+
+func (c *shardedEventCache) DeleteID(id, pubkey string) {
+	// Replaceable events are not in the shard of their IDs.
+	for _, s := range c.shards {
+		s.mu.Lock()
+		s.c.DeleteID(id, pubkey)
+		s.mu.Unlock()
+	}
+}
+
+func (c *shardedEventCache) DeleteNaddr(naddr, pubkey string) {
+	s := c.shard(naddr)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.c.DeleteNaddr(naddr, pubkey)
+}
+
+// DeletePubkey deletes all events by pubkey and returns the number of them.
+func (c *shardedEventCache) DeletePubkey(pubkey string) int {
+	var n int
+	for _, s := range c.shards {
+		s.mu.Lock()
+		n += s.c.DeletePubkey(pubkey)
+		s.mu.Unlock()
+	}
+	return n
+}
+
+// Bytes returns the approximate bytes of events in the cache.
+func (c *shardedEventCache) Bytes() int64 {
+	var n int64
+	for _, s := range c.shards {
+		n += s.c.Bytes()
+	}
+	return n
+}
+
+// Events returns the live events in the cache, newest first.
+func (c *shardedEventCache) Events() []*Event {
+	var ret []*Event
+	for _, s := range c.shards {
+		s.mu.RLock()
+		ret = append(ret, s.c.Events()...)
+		s.mu.RUnlock()
+	}
+	if len(c.shards) > 1 {
+		sortEventsDesc(ret)
+	}
+	return ret
+}
+
+func (c *shardedEventCache) Find(filters []*ReqFilter) []*Event {
+	ret, _ := c.FindContext(context.Background(), filters)
 	return ret
 }
+
+// FindContext returns the events matching filters, newest first.
+// It returns the events found so far with ctx.Err() when ctx is done.
+func (c *shardedEventCache) FindContext(
+	ctx context.Context,
+	filters []*ReqFilter,
+) ([]*Event, error) {
+	if len(c.shards) == 1 {
+		s := c.shards[0]
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+
+		return s.c.FindContext(ctx, NewReqFiltersEventMatchersWithMode(filters, c.idMatchMode))
+	}
+
+	// Each shard has the newest events within the limits,
+	// so the limits are applied again to the merged events.
+	var evs []*Event
+	var err error
+	for _, s := range c.shards {
+		s.mu.RLock()
+		var found []*Event
+		found, err = s.c.FindContext(
+			ctx,
+			NewReqFiltersEventMatchersWithMode(filters, c.idMatchMode),
+		)
+		s.mu.RUnlock()
+
+		evs = append(evs, found...)
+		if err != nil {
+			break
+		}
+	}
+	sortEventsDesc(evs)
+
+	matcher := NewReqFiltersEventMatchersWithMode(filters, c.idMatchMode)
+	ret := evs[:0]
+	for _, ev := range evs {
+		if matcher.Done() {
+			break
+		}
+		if matcher.CountMatch(ev) {
+			ret = append(ret, ev)
+		}
+	}
+	return ret, err
+}
+
+func sortEventsDesc(events []*Event) {
+	slices.SortStableFunc(events, func(a, b *Event) int {
+		return cmp.Compare(b.CreatedAt, a.CreatedAt)
+	})
+}
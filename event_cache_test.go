@@ -1,7 +1,12 @@
 package mocrelay
 
 import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -127,3 +132,195 @@ func TestEventCache(t *testing.T) {
 		})
 	}
 }
+
+func TestEventCache_FindContext(t *testing.T) {
+	c := newEventCache(10)
+	c.Add(&Event{ID: "id", Pubkey: "pubkey", CreatedAt: 1, Kind: 1})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	evs, err := c.FindContext(ctx, NewReqFiltersEventMatchers([]*ReqFilter{{}}))
+	assert.NoError(t, err)
+	assert.Len(t, evs, 1)
+
+	cancel()
+	evs, err = c.FindContext(ctx, NewReqFiltersEventMatchers([]*ReqFilter{{}}))
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Empty(t, evs)
+}
+
+func TestEventCache_replaceableTie(t *testing.T) {
+	c := newEventCache(10)
+	b := &Event{ID: "b", Pubkey: "pubkey", CreatedAt: 1, Kind: 0}
+	a := &Event{ID: "a", Pubkey: "pubkey", CreatedAt: 1, Kind: 0}
+
+	assert.True(t, c.Add(b))
+	assert.True(t, c.Add(a))
+	assert.False(t, c.Add(&Event{ID: "c", Pubkey: "pubkey", CreatedAt: 1, Kind: 0}))
+	assert.Equal(t, []*Event{a}, c.Find(NewReqFiltersEventMatchers([]*ReqFilter{{}})))
+}
+
+func TestEventCache_maxBytes(t *testing.T) {
+	newEvent := func(id string, createdAt int64) *Event {
+		return &Event{ID: id, Pubkey: "pub", Kind: 1, CreatedAt: createdAt}
+	}
+	size := approxEventSize(newEvent("id0", 0))
+
+	var evictions testCounter
+	c := newEventCache(10)
+	c.maxBytes = 3 * size
+	c.evictions = &evictions
+
+	for i, id := range []string{"id0", "id1", "id2"} {
+		assert.True(t, c.Add(newEvent(id, int64(i+1))))
+	}
+	assert.Equal(t, 3*size, c.Bytes())
+
+	assert.False(t, c.Add(newEvent("old", 0)), "older than all events in the full cache")
+
+	assert.True(t, c.Add(newEvent("id3", 4)))
+	assert.Equal(t, 3*size, c.Bytes())
+	assert.Equal(t, 1, evictions.n)
+	assert.Equal(
+		t,
+		[]*Event{newEvent("id3", 4), newEvent("id2", 3), newEvent("id1", 2)},
+		c.Find(NewReqFiltersEventMatchers([]*ReqFilter{{}})),
+	)
+
+	assert.False(
+		t,
+		c.Add(&Event{ID: "huge", Kind: 1, CreatedAt: 5, Content: string(make([]byte, 4*size))}),
+	)
+}
+
+func TestEventCache_expiration(t *testing.T) {
+	now := time.Now()
+	newEvent := func(id string, expiration time.Time) *Event {
+		return &Event{
+			ID:        id,
+			Pubkey:    "pub",
+			Kind:      1,
+			CreatedAt: now.Unix(),
+			Tags:      []Tag{{"expiration", strconv.FormatInt(expiration.Unix(), 10)}},
+		}
+	}
+
+	c := newEventCache(10)
+	assert.False(t, c.Add(newEvent("expired", now.Add(-time.Minute))))
+	assert.True(t, c.Add(newEvent("soon", now.Add(time.Second))))
+	assert.True(t, c.Add(newEvent("later", now.Add(time.Hour))))
+	assert.True(t, c.Add(&Event{ID: "never", Pubkey: "pub", Kind: 1, CreatedAt: now.Unix()}))
+	assert.Equal(t, 2, c.expiry.Len())
+
+	ids := func(evs []*Event) []string {
+		var ret []string
+		for _, ev := range evs {
+			ret = append(ret, ev.ID)
+		}
+		return ret
+	}
+	assert.ElementsMatch(
+		t,
+		[]string{"soon", "later", "never"},
+		ids(c.Find(NewReqFiltersEventMatchers([]*ReqFilter{{}}))),
+	)
+
+	c.expiry.Set("soon", now.Add(-time.Second))
+	assert.ElementsMatch(
+		t,
+		[]string{"later", "never"},
+		ids(c.Find(NewReqFiltersEventMatchers([]*ReqFilter{{}}))),
+	)
+
+	c.expire(now)
+	assert.Nil(t, c.ids["soon"])
+	assert.Equal(t, 1, c.expiry.Len())
+
+	c.DeleteID("later", "pub")
+	assert.Equal(t, 0, c.expiry.Len())
+}
+
+func TestShardedEventCache(t *testing.T) {
+	c := newShardedEventCache(100, 4, 0, nil)
+
+	reg := func(id string, createdAt int64) *Event {
+		return &Event{ID: id, Pubkey: "pub", Kind: 1, CreatedAt: createdAt}
+	}
+	rep := func(id string, createdAt int64) *Event {
+		return &Event{ID: id, Pubkey: "pub", Kind: 0, CreatedAt: createdAt}
+	}
+	param := func(id string, createdAt int64) *Event {
+		return &Event{
+			ID:        id,
+			Pubkey:    "pub",
+			Kind:      30000,
+			CreatedAt: createdAt,
+			Tags:      []Tag{{"d", "x"}},
+		}
+	}
+
+	var events []*Event
+	for i := 0; i < 20; i++ {
+		ev := reg(fmt.Sprintf("reg%d", i), int64(i))
+		events = append(events, ev)
+		assert.True(t, c.Add(ev))
+	}
+	assert.False(t, c.Add(reg("reg0", 0)))
+
+	assert.True(t, c.Add(rep("rep0", 100)))
+	assert.True(t, c.Add(rep("rep1", 101)))
+	assert.False(t, c.Add(rep("rep2", 99)), "older than the replaced one")
+	assert.True(t, c.Add(param("param0", 102)))
+
+	got := c.Events()
+	assert.Len(t, got, 22)
+	assert.Equal(t, "param0", got[0].ID)
+	assert.Equal(t, "rep1", got[1].ID)
+	assert.Equal(t, "reg19", got[2].ID)
+	assert.Equal(t, "reg0", got[21].ID)
+
+	assert.Equal(
+		t,
+		[]*Event{events[19], events[18], events[17]},
+		c.Find([]*ReqFilter{{Kinds: []int64{1}, Limit: toPtr[int64](3)}}),
+	)
+
+	single := newShardedEventCache(100, 1, 0, nil)
+	for _, ev := range c.Events() {
+		single.Add(ev)
+	}
+	for _, filters := range [][]*ReqFilter{
+		{{}},
+		{{Kinds: []int64{0, 30000}}},
+		{{Limit: toPtr[int64](5)}, {IDs: []string{"reg0", "reg1"}, Limit: toPtr[int64](1)}},
+	} {
+		assert.Equal(t, single.Find(filters), c.Find(filters), "same as an unsharded cache")
+	}
+
+	c.DeleteID("rep1", "pub")
+	c.DeleteID("reg5", "other")
+	c.DeleteNaddr("pub:30000:x", "pub")
+	assert.Empty(t, c.Find([]*ReqFilter{{Kinds: []int64{0, 30000}}}))
+	assert.True(t, c.Has(events[5]))
+
+	assert.Equal(t, 20, c.DeletePubkey("pub"))
+	assert.Empty(t, c.Events())
+}
+
+func TestShardedEventCache_concurrent(t *testing.T) {
+	c := newShardedEventCache(8000, 8, 0, nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				c.Add(&Event{ID: fmt.Sprintf("%d-%d", i, j), Kind: 1, CreatedAt: int64(j)})
+				c.Find([]*ReqFilter{{Limit: toPtr[int64](10)}})
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Len(t, c.Events(), 800)
+}
@@ -0,0 +1,141 @@
+package mocrelay
+
+import (
+	"bytes"
+	"errors"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+)
+
+// EventHook rewrites an event before it is stored and sent to subscribers.
+// It receives a copy of the event and returns the event to be used instead,
+// or an error to reject the event with the error message. An *OKError sets the prefix.
+//
+// Annotations can be changed freely since they live outside of the signed payload.
+// Any other field is signed, so an event whose signed fields are changed must be
+// signed again, e.g. by Keypair.Sign. Otherwise the event is rejected.
+// To drop disallowed tags of events by clients, reject the events instead.
+type EventHook func(r *http.Request, event *Event) (*Event, error)
+
+type EventHookMiddleware Middleware
+
+func NewEventHookMiddleware(hook EventHook) EventHookMiddleware {
+	if hook == nil {
+		panic("hook must be non-nil")
+	}
+	m := newSimpleEventHookMiddleware(hook)
+	return EventHookMiddleware(NewSimpleMiddleware(m))
+}
+
+var _ SimpleMiddlewareInterface = (*simpleEventHookMiddleware)(nil)
+
+type simpleEventHookMiddleware struct {
+	hook EventHook
+
+	mu sync.Mutex
+	// rewritten maps the ids of signed again events to the original ids
+	// to reply OK to the original ones.
+	// map[newID]originalID
+	rewritten map[string]string
+}
+
+func newSimpleEventHookMiddleware(hook EventHook) *simpleEventHookMiddleware {
+	return &simpleEventHookMiddleware{
+		hook:      hook,
+		rewritten: make(map[string]string),
+	}
+}
+
+func (m *simpleEventHookMiddleware) HandleStart(r *http.Request) (*http.Request, error) {
+	return r, nil
+}
+
+func (m *simpleEventHookMiddleware) HandleStop(r *http.Request) error {
+	return nil
+}
+
+func (m *simpleEventHookMiddleware) HandleClientMsg(
+	r *http.Request,
+	msg ClientMsg,
+) (<-chan ClientMsg, <-chan ServerMsg, error) {
+	if msg, ok := msg.(*ClientEventMsg); ok {
+		event, err := m.hook(r, cloneEvent(msg.Event))
+		if err == nil && event == nil {
+			err = errors.New("rejected")
+		}
+		if err != nil {
+			okMsg := okMsgFromError(msg.Event.ID, err, ServerOkMsgPrefixBlocked, err.Error())
+			return nil, newClosedBufCh[ServerMsg](okMsg), nil
+		}
+		if !eventSignedFieldsEqual(msg.Event, event) {
+			// The original JSON no longer matches the event.
+			event.raw = nil
+			if ok, _ := event.Verify(); !ok || !event.Valid() {
+				okMsg := NewServerOKMsg(
+					msg.Event.ID,
+					false,
+					ServerOkMsgPrefixError,
+					"event was modified without a valid signature",
+				)
+				return nil, newClosedBufCh[ServerMsg](okMsg), nil
+			}
+		}
+		if event.ID != msg.Event.ID {
+			m.mu.Lock()
+			m.rewritten[event.ID] = msg.Event.ID
+			m.mu.Unlock()
+		}
+		return newClosedBufCh[ClientMsg](&ClientEventMsg{Event: event}), nil, nil
+	}
+
+	return newClosedBufCh(msg), nil, nil
+}
+
+func (m *simpleEventHookMiddleware) HandleServerMsg(
+	r *http.Request,
+	msg ServerMsg,
+) (<-chan ServerMsg, error) {
+	if okMsg, ok := msg.(*ServerOKMsg); ok {
+		m.mu.Lock()
+		id, found := m.rewritten[okMsg.EventID]
+		delete(m.rewritten, okMsg.EventID)
+		m.mu.Unlock()
+
+		if found {
+			ret := *okMsg
+			ret.EventID = id
+			return newClosedBufCh[ServerMsg](&ret), nil
+		}
+	}
+
+	return newClosedBufCh(msg), nil
+}
+
+// cloneEvent returns a deep copy of event.
+func cloneEvent(event *Event) *Event {
+	ret := *event
+	ret.Tags = make([]Tag, len(event.Tags))
+	for i, tag := range event.Tags {
+		ret.Tags[i] = slices.Clone(tag)
+	}
+	ret.Annotations = maps.Clone(event.Annotations)
+	return &ret
+}
+
+// eventSignedFieldsEqual reports whether a and b have the same signed payload and signature.
+func eventSignedFieldsEqual(a, b *Event) bool {
+	if b == nil || a.ID != b.ID || a.Sig != b.Sig {
+		return false
+	}
+	sa, err := a.Serialize()
+	if err != nil {
+		return false
+	}
+	sb, err := b.Serialize()
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(sa, sb)
+}
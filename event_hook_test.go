@@ -0,0 +1,126 @@
+package mocrelay
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventHookMiddleware(t *testing.T) {
+	key, err := ParseKeypair(strings.Repeat("01", 32))
+	require.NoError(t, err)
+
+	newEvent := func(t *testing.T) *Event {
+		ev, err := key.newEvent(1, []Tag{{"t", "nostr"}, {"x", "disallowed"}}, "hello")
+		require.NoError(t, err)
+		return ev
+	}
+
+	run := func(t *testing.T, hook EventHook, event *Event) (*Event, *ServerOKMsg) {
+		got := make(chan *Event, 1)
+		var h Handler = HandlerFunc(
+			func(r *http.Request, recv <-chan ClientMsg, send chan<- ServerMsg) error {
+				for msg := range recv {
+					msg := msg.(*ClientEventMsg)
+					got <- msg.Event
+					send <- NewServerOKMsg(msg.Event.ID, true, ServerOKMsgPrefixNoPrefix, "")
+				}
+				return ErrRecvClosed
+			},
+		)
+		h = NewEventHookMiddleware(hook)(h)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		r := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+		recv := make(chan ClientMsg)
+		send := make(chan ServerMsg)
+
+		go h.Handle(r, recv, send)
+
+		recv <- &ClientEventMsg{Event: event}
+		select {
+		case msg := <-send:
+			var ev *Event
+			if len(got) > 0 {
+				ev = <-got
+			}
+			return ev, msg.(*ServerOKMsg)
+		case <-ctx.Done():
+			t.Fatal("timeout")
+			return nil, nil
+		}
+	}
+
+	t.Run("annotate", func(t *testing.T) {
+		orig := newEvent(t)
+		ev, ok := run(t, func(r *http.Request, event *Event) (*Event, error) {
+			event.Annotations = map[string]string{"received_at": "1"}
+			return event, nil
+		}, orig)
+
+		assert.True(t, ok.Accepted)
+		assert.Equal(t, map[string]string{"received_at": "1"}, ev.Annotations)
+		assert.Equal(t, orig.Raw(), ev.Raw())
+		assert.Nil(t, orig.Annotations)
+
+		// Annotations are never sent to clients.
+		b, err := ev.MarshalJSON()
+		require.NoError(t, err)
+		assert.NotContains(t, string(b), "received_at")
+	})
+
+	t.Run("strip tags without signing", func(t *testing.T) {
+		orig := newEvent(t)
+		ev, ok := run(t, func(r *http.Request, event *Event) (*Event, error) {
+			event.Tags = event.Tags[:1]
+			return event, nil
+		}, orig)
+
+		assert.Nil(t, ev)
+		assert.False(t, ok.Accepted)
+		assert.Equal(t, orig.ID, ok.EventID)
+		assert.Len(t, orig.Tags, 2)
+	})
+
+	t.Run("strip tags and sign", func(t *testing.T) {
+		orig := newEvent(t)
+		ev, ok := run(t, func(r *http.Request, event *Event) (*Event, error) {
+			event.Tags = event.Tags[:1]
+			return event, key.Sign(event)
+		}, orig)
+
+		assert.Equal(t, Tags{{"t", "nostr"}}, ev.Tags)
+		assert.NotEqual(t, orig.ID, ev.ID)
+		assert.True(t, ok.Accepted)
+		// OK is replied to the original event.
+		assert.Equal(t, orig.ID, ok.EventID)
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		ev, ok := run(t, func(r *http.Request, event *Event) (*Event, error) {
+			return nil, errors.New("disallowed tag")
+		}, newEvent(t))
+
+		assert.Nil(t, ev)
+		assert.False(t, ok.Accepted)
+		assert.Equal(t, "blocked: disallowed tag", ok.Message())
+	})
+
+	t.Run("reject with ok error", func(t *testing.T) {
+		_, ok := run(t, func(r *http.Request, event *Event) (*Event, error) {
+			return nil, NewOKError(ServerOkMsgPrefixRestricted, "members only")
+		}, newEvent(t))
+
+		assert.False(t, ok.Accepted)
+		assert.Equal(t, "restricted: members only", ok.Message())
+	})
+}
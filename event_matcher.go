@@ -1,5 +1,7 @@
 package mocrelay
 
+import "strings"
+
 type EventMatcher interface {
 	Match(*Event) bool
 }
@@ -36,11 +38,41 @@ func (m EventCountMatchers[T]) Done() bool {
 	return done
 }
 
+// IDMatchMode is how the ids and authors of filters match events.
+type IDMatchMode int
+
+const (
+	// IDMatchExact matches ids and pubkeys of 64 hex chars exactly as NIP-01 specifies.
+	IDMatchExact IDMatchMode = iota
+
+	// IDMatchPrefix also matches ids and pubkeys by hex prefixes as the legacy NIP-01.
+	IDMatchPrefix
+)
+
+// validID reports whether v is a valid value of ids in the mode.
+func (mode IDMatchMode) validID(v string) bool {
+	if mode == IDMatchPrefix {
+		return 0 < len(v) && len(v) <= 64 && validHexString(v)
+	}
+	return validID(v)
+}
+
+// validPubkey reports whether v is a valid value of authors in the mode.
+func (mode IDMatchMode) validPubkey(v string) bool {
+	if mode == IDMatchPrefix {
+		return 0 < len(v) && len(v) <= 64 && validHexString(v)
+	}
+	return validPubkey(v)
+}
+
 var _ EventCountMatcher = (*ReqFilterEventMatcher)(nil)
 
 type ReqFilterEventMatcher struct {
 	cnt int64
-	f   struct {
+	// idPrefixes and authorPrefixes are the values shorter than 64 chars in IDMatchPrefix.
+	idPrefixes     []string
+	authorPrefixes []string
+	f              struct {
 		IDs     map[string]bool
 		Authors map[string]bool
 		Kinds   map[int64]bool
@@ -52,6 +84,12 @@ type ReqFilterEventMatcher struct {
 }
 
 func NewReqFilterMatcher(filter *ReqFilter) *ReqFilterEventMatcher {
+	return NewReqFilterMatcherWithMode(filter, IDMatchExact)
+}
+
+// NewReqFilterMatcherWithMode is the same as NewReqFilterMatcher but matches ids and authors
+// in mode.
+func NewReqFilterMatcherWithMode(filter *ReqFilter, mode IDMatchMode) *ReqFilterEventMatcher {
 	if filter == nil {
 		panic("filter must be non-nil pointer")
 	}
@@ -61,6 +99,10 @@ func NewReqFilterMatcher(filter *ReqFilter) *ReqFilterEventMatcher {
 	if filter.IDs != nil {
 		ret.f.IDs = make(map[string]bool)
 		for _, id := range filter.IDs {
+			if mode == IDMatchPrefix && len(id) < 64 {
+				ret.idPrefixes = append(ret.idPrefixes, id)
+				continue
+			}
 			ret.f.IDs[id] = true
 		}
 	}
@@ -68,6 +110,10 @@ func NewReqFilterMatcher(filter *ReqFilter) *ReqFilterEventMatcher {
 	if filter.Authors != nil {
 		ret.f.Authors = make(map[string]bool)
 		for _, author := range filter.Authors {
+			if mode == IDMatchPrefix && len(author) < 64 {
+				ret.authorPrefixes = append(ret.authorPrefixes, author)
+				continue
+			}
 			ret.f.Authors[author] = true
 		}
 	}
@@ -84,9 +130,12 @@ func NewReqFilterMatcher(filter *ReqFilter) *ReqFilterEventMatcher {
 		for tag, vals := range filter.Tags {
 			m := make(map[string]bool)
 			for _, val := range vals {
+				if tag == "#a" {
+					val = normalizeNaddr(val)
+				}
 				m[val] = true
 			}
-			ret.f.Tags[string(tag[1])] = m
+			ret.f.Tags[tag[1:]] = m
 		}
 	}
 
@@ -98,7 +147,7 @@ func NewReqFilterMatcher(filter *ReqFilter) *ReqFilterEventMatcher {
 }
 
 func (m *ReqFilterEventMatcher) Match(event *Event) bool {
-	if m.f.IDs != nil && !m.f.IDs[event.ID] {
+	if m.f.IDs != nil && !m.f.IDs[event.ID] && !hasAnyPrefix(event.ID, m.idPrefixes) {
 		return false
 	}
 
@@ -106,7 +155,8 @@ func (m *ReqFilterEventMatcher) Match(event *Event) bool {
 		return false
 	}
 
-	if m.f.Authors != nil && !m.f.Authors[event.Pubkey] {
+	if m.f.Authors != nil && !m.f.Authors[event.Pubkey] &&
+		!hasAnyPrefix(event.Pubkey, m.authorPrefixes) {
 		return false
 	}
 
@@ -121,6 +171,9 @@ func (m *ReqFilterEventMatcher) Match(event *Event) bool {
 			if len(tag) >= 2 {
 				v = tag[1]
 			}
+			if tag[0] == "a" {
+				v = normalizeNaddr(v)
+			}
 			if m.f.Tags[tag[0]][v] {
 				found[tag[0]] = true
 			}
@@ -130,18 +183,18 @@ func (m *ReqFilterEventMatcher) Match(event *Event) bool {
 		}
 	}
 
-	if m.f.Since != nil {
-		if event.CreatedAt < *m.f.Since {
-			return false
-		}
-	}
+	return matchCreatedAt(m.f.Since, m.f.Until, event.CreatedAt)
+}
 
-	if m.f.Until != nil {
-		if *m.f.Until < event.CreatedAt {
-			return false
-		}
+// matchCreatedAt reports whether since <= createdAt <= until as NIP-01.
+// Nil bounds are unbounded.
+func matchCreatedAt(since, until *int64, createdAt int64) bool {
+	if since != nil && createdAt < *since {
+		return false
+	}
+	if until != nil && *until < createdAt {
+		return false
 	}
-
 	return true
 }
 
@@ -161,13 +214,29 @@ type ReqFiltersMatcher []*ReqFilterEventMatcher
 
 func NewReqFiltersEventMatchers(
 	filters []*ReqFilter,
+) EventCountMatchers[*ReqFilterEventMatcher] {
+	return NewReqFiltersEventMatchersWithMode(filters, IDMatchExact)
+}
+
+func NewReqFiltersEventMatchersWithMode(
+	filters []*ReqFilter,
+	mode IDMatchMode,
 ) EventCountMatchers[*ReqFilterEventMatcher] {
 	if filters == nil {
 		panic("filters must be non-nil slice")
 	}
 	ret := make([]*ReqFilterEventMatcher, len(filters))
 	for i, f := range filters {
-		ret[i] = NewReqFilterMatcher(f)
+		ret[i] = NewReqFilterMatcherWithMode(f, mode)
 	}
 	return ret
 }
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -658,3 +658,118 @@ func TestReqFilterMatcher_Match(t *testing.T) {
 		})
 	}
 }
+
+func TestReqFilterMatcher_Match_prefix(t *testing.T) {
+	event := &Event{
+		ID:        "d2ea747b6e3a35d2a8b759857b73fcaba5e9f3cfb6f38d317e034bddc0bf0d1c",
+		Pubkey:    "dbf0becf24bf8dd7d779d7fb547e6112964ff042b77a42cc2d8488636eed9f5e",
+		CreatedAt: 1693156107,
+		Kind:      1,
+		Tags:      []Tag{},
+	}
+
+	tests := []struct {
+		name   string
+		filter ReqFilter
+		exact  bool
+		prefix bool
+	}{
+		{
+			name:   "full id",
+			filter: ReqFilter{IDs: []string{event.ID}},
+			exact:  true,
+			prefix: true,
+		},
+		{
+			name:   "id prefix",
+			filter: ReqFilter{IDs: []string{"d2ea74"}},
+			exact:  false,
+			prefix: true,
+		},
+		{
+			name:   "author prefix",
+			filter: ReqFilter{Authors: []string{"0000", "dbf0"}},
+			exact:  false,
+			prefix: true,
+		},
+		{
+			name:   "other prefix",
+			filter: ReqFilter{IDs: []string{"d2eb"}},
+			exact:  false,
+			prefix: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.exact, NewReqFilterMatcher(&tt.filter).Match(event))
+			assert.Equal(
+				t,
+				tt.prefix,
+				NewReqFilterMatcherWithMode(&tt.filter, IDMatchPrefix).Match(event),
+			)
+		})
+	}
+}
+
+func TestReqFilterMatcher_Match_createdAt(t *testing.T) {
+	tests := []struct {
+		name      string
+		since     *int64
+		until     *int64
+		createdAt int64
+		want      bool
+	}{
+		{"unbounded", nil, nil, 100, true},
+		{"equal to since", toPtr(int64(100)), nil, 100, true},
+		{"before since", toPtr(int64(100)), nil, 99, false},
+		{"equal to until", nil, toPtr(int64(100)), 100, true},
+		{"after until", nil, toPtr(int64(100)), 101, false},
+		{"since equal to until", toPtr(int64(100)), toPtr(int64(100)), 100, true},
+		{"since after until", toPtr(int64(101)), toPtr(int64(100)), 100, false},
+		{"between", toPtr(int64(99)), toPtr(int64(101)), 100, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewReqFilterMatcher(&ReqFilter{Since: tt.since, Until: tt.until})
+			assert.Equal(t, tt.want, m.Match(&Event{CreatedAt: tt.createdAt}))
+		})
+	}
+}
+
+func TestReqFilterMatcher_Match_longTagName(t *testing.T) {
+	event := &Event{Tags: []Tag{{"client", "mocrelay"}, {"c", "other"}}}
+
+	m := NewReqFilterMatcher(&ReqFilter{Tags: map[string][]string{"#client": {"mocrelay"}}})
+	assert.True(t, m.Match(event))
+
+	m = NewReqFilterMatcher(&ReqFilter{Tags: map[string][]string{"#c": {"mocrelay"}}})
+	assert.False(t, m.Match(event))
+}
+
+func TestReqFilterMatcher_Match_naddr(t *testing.T) {
+	pubkey := "dbf0becf24bf8dd7d779d7fb547e6112964ff042b77a42cc2d8488636eed9f5e"
+	naddr := "30023:" + pubkey + ":powa:meu"
+
+	tests := []struct {
+		name  string
+		value string
+		want  bool
+	}{
+		{"same", naddr, true},
+		{"leading zeros in kind", "030023:" + pubkey + ":powa:meu", true},
+		{"other kind", "30024:" + pubkey + ":powa:meu", false},
+		{"d-tag prefix", "30023:" + pubkey + ":powa", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewReqFilterMatcher(&ReqFilter{Tags: map[string][]string{"#a": {tt.value}}})
+			assert.Equal(t, tt.want, m.Match(&Event{Tags: []Tag{{"a", naddr}}}))
+
+			m = NewReqFilterMatcher(&ReqFilter{Tags: map[string][]string{"#a": {naddr}}})
+			assert.Equal(t, tt.want, m.Match(&Event{Tags: []Tag{{"a", tt.value}}}))
+		})
+	}
+}
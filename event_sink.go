@@ -0,0 +1,235 @@
+package mocrelay
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// EventSink receives the events accepted by the relay such as a mirror into another database.
+type EventSink interface {
+	// Accept returns after event is written.
+	// The same event may be accepted again when a client resends it.
+	Accept(ctx context.Context, event *Event) error
+}
+
+// AckPolicy decides when the OK of an event is sent.
+type AckPolicy int
+
+const (
+	// AckAfterCache sends OK as soon as the handler accepts the event
+	// and writes it into the sinks in the background.
+	AckAfterCache AckPolicy = iota
+
+	// AckAfterDurable sends OK after all the sinks have written the event.
+	// Other messages are not held back meanwhile, so the OK may be sent after them.
+	// The OK is false if any sink fails, although the handler has already stored
+	// the event. Clients may resend it and sinks accept it again.
+	AckAfterDurable
+)
+
+type EventSinkOption struct {
+	Sinks  []EventSink
+	Policy AckPolicy
+
+	// Timeout is the timeout of writing an event into the sinks. The default is 10 seconds.
+	Timeout time.Duration
+
+	// MaxBackgroundWrites is the max number of events written in the background
+	// with AckAfterCache. Events beyond it are dropped. The default is 1024.
+	MaxBackgroundWrites int
+
+	// Logger logs failed writes if not nil.
+	Logger *slog.Logger
+
+	// Failures counts the events which some sink failed to write or which are dropped.
+	Failures Counter
+}
+
+func (opt *EventSinkOption) timeout() time.Duration {
+	if opt.Timeout == 0 {
+		return 10 * time.Second
+	}
+	return opt.Timeout
+}
+
+func (opt *EventSinkOption) maxBackgroundWrites() int {
+	if opt.MaxBackgroundWrites == 0 {
+		return 1024
+	}
+	return opt.MaxBackgroundWrites
+}
+
+type EventSinkMiddleware Middleware
+
+// NewEventSinkMiddleware writes the events accepted by the handler into the sinks.
+// The OK of an event is sent once according to the policy.
+func NewEventSinkMiddleware(option *EventSinkOption) EventSinkMiddleware {
+	if option == nil {
+		panic("option must be non-nil pointer")
+	}
+
+	sinks := &eventSinks{
+		opt:  option,
+		sema: make(chan struct{}, option.maxBackgroundWrites()),
+	}
+
+	return func(h Handler) Handler {
+		return HandlerFunc(
+			func(r *http.Request, recv <-chan ClientMsg, send chan<- ServerMsg) error {
+				sm := newSimpleEventSinkMiddleware(sinks, send)
+				m := NewSimpleMiddleware(sm)
+				return m(h).Handle(r, recv, send)
+			},
+		)
+	}
+}
+
+// eventSinks is shared by all the connections.
+type eventSinks struct {
+	opt *EventSinkOption
+	// sema limits the background writes.
+	sema chan struct{}
+}
+
+// write writes event into all the sinks concurrently.
+func (s *eventSinks) write(ctx context.Context, event *Event) error {
+	ctx, cancel := context.WithTimeout(ctx, s.opt.timeout())
+	defer cancel()
+
+	errs := make([]error, len(s.opt.Sinks))
+	var wg sync.WaitGroup
+	for i, sink := range s.opt.Sinks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = sink.Accept(ctx, event)
+		}()
+	}
+	wg.Wait()
+
+	err := errors.Join(errs...)
+	if err != nil {
+		incCounter(s.opt.Failures)
+		if s.opt.Logger != nil {
+			s.opt.Logger.WarnContext(ctx, "failed to write event into sinks",
+				"id", event.ID, "err", err)
+		}
+	}
+	return err
+}
+
+// writeBackground writes event without waiting. It drops event if too many writes are running.
+func (s *eventSinks) writeBackground(ctx context.Context, event *Event) {
+	select {
+	case s.sema <- struct{}{}:
+	default:
+		incCounter(s.opt.Failures)
+		if s.opt.Logger != nil {
+			s.opt.Logger.WarnContext(ctx, "too many background writes: event dropped",
+				"id", event.ID)
+		}
+		return
+	}
+
+	go func() {
+		defer func() { <-s.sema }()
+		s.write(context.WithoutCancel(ctx), event)
+	}()
+}
+
+var _ SimpleMiddlewareInterface = (*simpleEventSinkMiddleware)(nil)
+
+type simpleEventSinkMiddleware struct {
+	sinks *eventSinks
+
+	// send receives the OKs of durable writes, which bypass HandleServerMsg
+	// not to block the other messages.
+	send chan<- ServerMsg
+	// wg waits for the durable writes.
+	wg sync.WaitGroup
+
+	mu sync.Mutex
+	// map[eventID]event
+	pending map[string]*Event
+}
+
+func newSimpleEventSinkMiddleware(
+	sinks *eventSinks,
+	send chan<- ServerMsg,
+) *simpleEventSinkMiddleware {
+	return &simpleEventSinkMiddleware{
+		sinks:   sinks,
+		send:    send,
+		pending: make(map[string]*Event),
+	}
+}
+
+func (m *simpleEventSinkMiddleware) HandleStart(r *http.Request) (*http.Request, error) {
+	return r, nil
+}
+
+func (m *simpleEventSinkMiddleware) HandleStop(r *http.Request) error {
+	m.wg.Wait()
+	return nil
+}
+
+func (m *simpleEventSinkMiddleware) HandleClientMsg(
+	r *http.Request,
+	msg ClientMsg,
+) (<-chan ClientMsg, <-chan ServerMsg, error) {
+	if msg, ok := msg.(*ClientEventMsg); ok {
+		m.mu.Lock()
+		m.pending[msg.Event.ID] = msg.Event
+		m.mu.Unlock()
+	}
+
+	return newClosedBufCh(msg), nil, nil
+}
+
+func (m *simpleEventSinkMiddleware) HandleServerMsg(
+	r *http.Request,
+	msg ServerMsg,
+) (<-chan ServerMsg, error) {
+	okMsg, ok := msg.(*ServerOKMsg)
+	if !ok {
+		return newClosedBufCh(msg), nil
+	}
+
+	m.mu.Lock()
+	event := m.pending[okMsg.EventID]
+	delete(m.pending, okMsg.EventID)
+	m.mu.Unlock()
+
+	if event == nil || !okMsg.Accepted || len(m.sinks.opt.Sinks) == 0 {
+		return newClosedBufCh(msg), nil
+	}
+
+	if m.sinks.opt.Policy != AckAfterDurable {
+		m.sinks.writeBackground(r.Context(), event)
+		return newClosedBufCh(msg), nil
+	}
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		m.writeDurable(r.Context(), event, okMsg)
+	}()
+	return nil, nil
+}
+
+// writeDurable sends okMsg after event is written into the sinks.
+func (m *simpleEventSinkMiddleware) writeDurable(
+	ctx context.Context,
+	event *Event,
+	okMsg *ServerOKMsg,
+) {
+	var msg ServerMsg = okMsg
+	if err := m.sinks.write(ctx, event); err != nil {
+		msg = okMsgFromError(event.ID, err, ServerOkMsgPrefixError, "failed to store event")
+	}
+	sendServerMsgCtx(ctx, m.send, msg)
+}
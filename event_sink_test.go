@@ -0,0 +1,207 @@
+package mocrelay
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testEventSink struct {
+	mu     sync.Mutex
+	ids    []string
+	err    error
+	blockC chan struct{}
+}
+
+func (s *testEventSink) Accept(ctx context.Context, event *Event) error {
+	if s.blockC != nil {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.blockC:
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ids = append(s.ids, event.ID)
+	return s.err
+}
+
+func (s *testEventSink) IDs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.ids...)
+}
+
+func TestEventSinkMiddleware(t *testing.T) {
+	accepted := &Event{ID: "accepted"}
+	rejected := &Event{ID: "rejected"}
+
+	run := func(t *testing.T, option *EventSinkOption, events ...*Event) []*ServerOKMsg {
+		var h Handler = HandlerFunc(
+			func(r *http.Request, recv <-chan ClientMsg, send chan<- ServerMsg) error {
+				for msg := range recv {
+					msg := msg.(*ClientEventMsg)
+					send <- NewServerOKMsg(
+						msg.Event.ID,
+						msg.Event != rejected,
+						ServerOKMsgPrefixNoPrefix,
+						"",
+					)
+				}
+				return ErrRecvClosed
+			},
+		)
+		h = NewEventSinkMiddleware(option)(h)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		r := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+		recv := make(chan ClientMsg)
+		send := make(chan ServerMsg)
+
+		go h.Handle(r, recv, send)
+
+		var ret []*ServerOKMsg
+		for _, ev := range events {
+			recv <- &ClientEventMsg{Event: ev}
+			select {
+			case msg := <-send:
+				ret = append(ret, msg.(*ServerOKMsg))
+			case <-ctx.Done():
+				t.Fatal("timeout")
+			}
+		}
+		return ret
+	}
+
+	t.Run("durable", func(t *testing.T) {
+		s1, s2 := new(testEventSink), new(testEventSink)
+		oks := run(t, &EventSinkOption{
+			Sinks:  []EventSink{s1, s2},
+			Policy: AckAfterDurable,
+		}, rejected, accepted)
+
+		assert.False(t, oks[0].Accepted)
+		assert.True(t, oks[1].Accepted)
+		assert.Equal(t, []string{"accepted"}, s1.IDs())
+		assert.Equal(t, []string{"accepted"}, s2.IDs())
+	})
+
+	t.Run("durable failure", func(t *testing.T) {
+		var failures testCounter
+		oks := run(t, &EventSinkOption{
+			Sinks:    []EventSink{new(testEventSink), &testEventSink{err: errors.New("down")}},
+			Policy:   AckAfterDurable,
+			Failures: &failures,
+		}, accepted)
+
+		assert.False(t, oks[0].Accepted)
+		assert.Equal(t, "error: failed to store event", oks[0].Message())
+		assert.Equal(t, 1, failures.n)
+	})
+
+	t.Run("durable timeout", func(t *testing.T) {
+		oks := run(t, &EventSinkOption{
+			Sinks:   []EventSink{&testEventSink{blockC: make(chan struct{})}},
+			Policy:  AckAfterDurable,
+			Timeout: 10 * time.Millisecond,
+		}, accepted)
+
+		assert.False(t, oks[0].Accepted)
+	})
+
+	t.Run("durable does not block other messages", func(t *testing.T) {
+		sink := &testEventSink{blockC: make(chan struct{})}
+
+		var h Handler = HandlerFunc(
+			func(r *http.Request, recv <-chan ClientMsg, send chan<- ServerMsg) error {
+				for msg := range recv {
+					switch msg := msg.(type) {
+					case *ClientEventMsg:
+						send <- NewServerOKMsg(msg.Event.ID, true, ServerOKMsgPrefixNoPrefix, "")
+					case *ClientReqMsg:
+						send <- NewServerEOSEMsg(msg.SubscriptionID)
+					}
+				}
+				return ErrRecvClosed
+			},
+		)
+		h = NewEventSinkMiddleware(&EventSinkOption{
+			Sinks:  []EventSink{sink},
+			Policy: AckAfterDurable,
+		})(h)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		r := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+		recv := make(chan ClientMsg)
+		send := make(chan ServerMsg)
+
+		go h.Handle(r, recv, send)
+
+		recv <- &ClientEventMsg{Event: accepted}
+		recv <- &ClientReqMsg{SubscriptionID: "sub"}
+
+		select {
+		case msg := <-send:
+			assert.Equal(t, NewServerEOSEMsg("sub"), msg)
+		case <-ctx.Done():
+			t.Fatal("timeout")
+		}
+
+		close(sink.blockC)
+
+		select {
+		case msg := <-send:
+			if assert.IsType(t, new(ServerOKMsg), msg) {
+				assert.True(t, msg.(*ServerOKMsg).Accepted)
+			}
+		case <-ctx.Done():
+			t.Fatal("timeout")
+		}
+		assert.Equal(t, []string{"accepted"}, sink.IDs())
+	})
+
+	t.Run("cache", func(t *testing.T) {
+		sink := &testEventSink{blockC: make(chan struct{})}
+		oks := run(t, &EventSinkOption{Sinks: []EventSink{sink}}, accepted)
+
+		// OK is sent before the sink writes the event.
+		assert.True(t, oks[0].Accepted)
+		assert.Empty(t, sink.IDs())
+
+		close(sink.blockC)
+		assert.Eventually(
+			t,
+			func() bool { return len(sink.IDs()) == 1 },
+			time.Second,
+			time.Millisecond,
+		)
+	})
+
+	t.Run("cache drops too many writes", func(t *testing.T) {
+		var failures testCounter
+		sink := &testEventSink{blockC: make(chan struct{})}
+		defer close(sink.blockC)
+
+		oks := run(t, &EventSinkOption{
+			Sinks:               []EventSink{sink},
+			MaxBackgroundWrites: 1,
+			Failures:            &failures,
+		}, accepted, &Event{ID: "dropped"})
+
+		assert.True(t, oks[0].Accepted)
+		assert.True(t, oks[1].Accepted)
+		assert.Equal(t, 1, failures.n)
+	})
+}
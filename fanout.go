@@ -0,0 +1,154 @@
+package mocrelay
+
+import (
+	"sync"
+	"time"
+)
+
+// fanout delivers published events to subscribers on worker goroutines.
+// Each subscriber has its own queue and the workers take an event from each subscriber
+// in turn, so that a subscriber matching many events doesn't delay the others.
+// A subscriber is handled by one worker at a time to keep the order of its events.
+type fanout struct {
+	queueSize int
+	latency   Observer
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// map[subscriber]pending events
+	queues map[*subscriber][]fanoutJob
+	// subscribers which have pending events in round-robin order
+	subs []*subscriber
+	// subscribers being delivered an event by a worker
+	busy    map[*subscriber]bool
+	stopped bool
+
+	wg sync.WaitGroup
+}
+
+type fanoutJob struct {
+	event       *Event
+	publishedAt time.Time
+}
+
+func newFanout(workers, queueSize int, latency Observer) *fanout {
+	f := &fanout{
+		queueSize: queueSize,
+		latency:   latency,
+		queues:    make(map[*subscriber][]fanoutJob),
+		busy:      make(map[*subscriber]bool),
+	}
+	f.cond = sync.NewCond(&f.mu)
+
+	for i := 0; i < workers; i++ {
+		f.wg.Add(1)
+		go f.work()
+	}
+
+	return f
+}
+
+// enqueue queues event for sub. The event is dropped if the queue of sub is full.
+func (f *fanout) enqueue(sub *subscriber, event *Event, publishedAt time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.stopped {
+		return
+	}
+
+	jobs := f.queues[sub]
+	if len(jobs) >= f.queueSize {
+		sub.Dropped.Add(1)
+		return
+	}
+	if len(jobs) == 0 && !f.busy[sub] {
+		f.subs = append(f.subs, sub)
+	}
+	f.queues[sub] = append(jobs, fanoutJob{event: event, publishedAt: publishedAt})
+
+	f.cond.Signal()
+}
+
+// dequeue takes the next event. The subscriber of it is not dequeued again until done.
+func (f *fanout) dequeue() (sub *subscriber, job fanoutJob, ok bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for len(f.subs) == 0 && !f.stopped {
+		f.cond.Wait()
+	}
+	if f.stopped {
+		return
+	}
+
+	sub = f.subs[0]
+	f.subs[0] = nil
+	f.subs = f.subs[1:]
+
+	jobs := f.queues[sub]
+	job = jobs[0]
+	jobs[0] = fanoutJob{}
+	if jobs = jobs[1:]; len(jobs) > 0 {
+		f.queues[sub] = jobs
+	} else {
+		delete(f.queues, sub)
+	}
+	f.busy[sub] = true
+
+	return sub, job, true
+}
+
+// done requeues sub after its event is delivered.
+func (f *fanout) done(sub *subscriber) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.busy, sub)
+	if len(f.queues[sub]) > 0 {
+		f.subs = append(f.subs, sub)
+		f.cond.Signal()
+	}
+}
+
+func (f *fanout) work() {
+	defer f.wg.Done()
+
+	for {
+		sub, job, ok := f.dequeue()
+		if !ok {
+			return
+		}
+		sub.deliver(job.event, job.publishedAt, f.latency)
+		f.done(sub)
+	}
+}
+
+// pending returns the number of queued events.
+func (f *fanout) pending() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var n int
+	for _, jobs := range f.queues {
+		n += len(jobs)
+	}
+	return n
+}
+
+// stop stops the workers and drops pending events.
+func (f *fanout) stop() {
+	f.mu.Lock()
+	if f.stopped {
+		f.mu.Unlock()
+		return
+	}
+	f.stopped = true
+	f.queues = make(map[*subscriber][]fanoutJob)
+	f.subs = nil
+	f.busy = make(map[*subscriber]bool)
+	f.cond.Broadcast()
+	f.mu.Unlock()
+
+	f.wg.Wait()
+}
@@ -0,0 +1,141 @@
+package mocrelay
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFanout_dequeue(t *testing.T) {
+	f := newFanout(0, 2, nil)
+	newSub := func(subID string) *subscriber {
+		return newSubscriber(
+			"req",
+			&ClientReqMsg{SubscriptionID: subID, ReqFilters: []*ReqFilter{{}}},
+			make(chan ServerMsg, 10),
+			IDMatchExact,
+		)
+	}
+	a, b := newSub("a"), newSub("b")
+	events := []*Event{{ID: "0"}, {ID: "1"}, {ID: "2"}, {ID: "3"}}
+
+	now := time.Now()
+	f.enqueue(a, events[0], now)
+	f.enqueue(a, events[1], now)
+	f.enqueue(a, events[2], now)
+	f.enqueue(b, events[3], now)
+	assert.Equal(t, int64(1), a.Dropped.Load(), "queue of a is full")
+	assert.Equal(t, 3, f.pending())
+
+	type delivery struct {
+		sub   *subscriber
+		event *Event
+	}
+	var got []delivery
+	for f.pending() > 0 {
+		sub, job, ok := f.dequeue()
+		assert.True(t, ok)
+		got = append(got, delivery{sub, job.event})
+		f.done(sub)
+	}
+	assert.Equal(t, []delivery{{a, events[0]}, {b, events[3]}, {a, events[1]}}, got)
+
+	// a is not dequeued while its event is being delivered.
+	f.enqueue(a, events[0], now)
+	f.enqueue(a, events[1], now)
+	sub, _, _ := f.dequeue()
+	assert.Equal(t, a, sub)
+	assert.Empty(t, f.subs)
+	f.done(a)
+	sub, job, _ := f.dequeue()
+	assert.Equal(t, a, sub)
+	assert.Equal(t, events[1], job.event)
+	f.done(a)
+
+	f.stop()
+	_, _, ok := f.dequeue()
+	assert.False(t, ok)
+}
+
+func TestFanout_order(t *testing.T) {
+	const n = 1000
+
+	f := newFanout(16, n, nil)
+	defer f.stop()
+
+	ch := make(chan ServerMsg, n)
+	sub := newSubscriber(
+		"req",
+		&ClientReqMsg{SubscriptionID: "sub", ReqFilters: []*ReqFilter{{}}},
+		ch,
+		IDMatchExact,
+	)
+
+	now := time.Now()
+	for i := 0; i < n; i++ {
+		f.enqueue(sub, &Event{ID: strconv.Itoa(i)}, now)
+	}
+
+	for i := 0; i < n; i++ {
+		select {
+		case msg := <-ch:
+			require.Equal(t, strconv.Itoa(i), msg.(*ServerEventMsg).Event.ID)
+		case <-time.After(time.Second):
+			t.Fatal("event is not delivered")
+		}
+	}
+}
+
+func TestRouterHandler_fanout(t *testing.T) {
+	var latency testObserver
+	router := NewRouterHandlerWithOption(10, &RouterHandlerOption{
+		FanoutWorkers:   2,
+		DeliveryLatency: &latency,
+	})
+	defer router.Stop()
+
+	ch := make(chan ServerMsg, 10)
+	sub := newSubscriber("req", &ClientReqMsg{
+		SubscriptionID: "sub",
+		ReqFilters:     []*ReqFilter{{Kinds: []int64{1}}},
+	}, ch, IDMatchExact)
+	router.subs.Subscribe(sub)
+
+	event := &Event{ID: "id", Kind: 1}
+	router.Publish(&Event{ID: "other", Kind: 7})
+	router.Publish(event)
+
+	select {
+	case msg := <-ch:
+		assert.Equal(t, NewServerEventMsg("sub", event), msg)
+	case <-time.After(time.Second):
+		t.Fatal("event is not delivered")
+	}
+	assert.Eventually(
+		t,
+		func() bool { return latency.n.Load() == 1 },
+		time.Second,
+		time.Millisecond,
+	)
+	assert.Equal(t, int64(1), sub.Info().Sent)
+
+	router.subs.Unsubscribe("req", "sub")
+	router.Publish(event)
+	assert.Eventually(
+		t,
+		func() bool { return router.FanoutPending() == 0 },
+		time.Second,
+		time.Millisecond,
+	)
+	assert.Empty(t, ch, "closed subscriptions receive no events")
+}
+
+type testObserver struct {
+	n atomic.Int64
+}
+
+func (o *testObserver) Observe(float64) { o.n.Add(1) }
@@ -0,0 +1,113 @@
+package mocrelay
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+var ErrInvalidReqFilter = errors.New("invalid req filter")
+
+// FilterBuilder builds a ReqFilter. Each method adds conditions and returns the builder,
+// so that a filter is written in a chain:
+//
+//	fil, err := NewFilterBuilder().Kinds(1).Tag("e", id).Since(t).Limit(10).Build()
+type FilterBuilder struct {
+	fil ReqFilter
+}
+
+func NewFilterBuilder() *FilterBuilder { return new(FilterBuilder) }
+
+// IDs adds ids to the ids condition.
+func (b *FilterBuilder) IDs(ids ...string) *FilterBuilder {
+	b.fil.IDs = append(b.fil.IDs, ids...)
+	return b
+}
+
+// Authors adds pubkeys to the authors condition.
+func (b *FilterBuilder) Authors(pubkeys ...string) *FilterBuilder {
+	b.fil.Authors = append(b.fil.Authors, pubkeys...)
+	return b
+}
+
+// Kinds adds kinds to the kinds condition.
+func (b *FilterBuilder) Kinds(kinds ...int64) *FilterBuilder {
+	b.fil.Kinds = append(b.fil.Kinds, kinds...)
+	return b
+}
+
+// Tag adds values to the tag condition of name such as "e" or "#e".
+func (b *FilterBuilder) Tag(name string, values ...string) *FilterBuilder {
+	if !strings.HasPrefix(name, "#") {
+		name = "#" + name
+	}
+	if b.fil.Tags == nil {
+		b.fil.Tags = make(map[string][]string)
+	}
+	b.fil.Tags[name] = append(b.fil.Tags[name], values...)
+	return b
+}
+
+// Since sets since to the unix time of t.
+func (b *FilterBuilder) Since(t time.Time) *FilterBuilder {
+	b.fil.Since = toPtr(t.Unix())
+	return b
+}
+
+// Until sets until to the unix time of t.
+func (b *FilterBuilder) Until(t time.Time) *FilterBuilder {
+	b.fil.Until = toPtr(t.Unix())
+	return b
+}
+
+// Limit sets limit to n.
+func (b *FilterBuilder) Limit(n int64) *FilterBuilder {
+	b.fil.Limit = toPtr(n)
+	return b
+}
+
+// Build returns the filter. It returns ErrInvalidReqFilter if the filter is invalid
+// such as an id which is not 64 hex chars. The builder can be used after Build.
+func (b *FilterBuilder) Build() (*ReqFilter, error) {
+	fil := b.filter()
+	if !fil.Valid() {
+		return nil, ErrInvalidReqFilter
+	}
+	return fil, nil
+}
+
+// MustBuild is like Build but panics if the filter is invalid.
+func (b *FilterBuilder) MustBuild() *ReqFilter {
+	fil, err := b.Build()
+	if err != nil {
+		panic(err)
+	}
+	return fil
+}
+
+// filter returns a copy of the filter which does not share memory with the builder.
+func (b *FilterBuilder) filter() *ReqFilter {
+	ret := &ReqFilter{
+		IDs:     slices.Clone(b.fil.IDs),
+		Authors: slices.Clone(b.fil.Authors),
+		Kinds:   slices.Clone(b.fil.Kinds),
+	}
+	if b.fil.Tags != nil {
+		ret.Tags = maps.Clone(b.fil.Tags)
+		for k, v := range ret.Tags {
+			ret.Tags[k] = slices.Clone(v)
+		}
+	}
+	if b.fil.Since != nil {
+		ret.Since = toPtr(*b.fil.Since)
+	}
+	if b.fil.Until != nil {
+		ret.Until = toPtr(*b.fil.Until)
+	}
+	if b.fil.Limit != nil {
+		ret.Limit = toPtr(*b.fil.Limit)
+	}
+	return ret
+}
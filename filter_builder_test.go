@@ -0,0 +1,113 @@
+package mocrelay
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilterBuilder_Build(t *testing.T) {
+	id := strings.Repeat("a", 64)
+	pubkey := strings.Repeat("b", 64)
+	since := time.Unix(100, 0)
+	until := time.Unix(200, 0)
+
+	tests := []struct {
+		name    string
+		in      *FilterBuilder
+		want    *ReqFilter
+		wantErr bool
+	}{
+		{
+			name: "empty",
+			in:   NewFilterBuilder(),
+			want: &ReqFilter{},
+		},
+		{
+			name: "all",
+			in: NewFilterBuilder().
+				IDs(id).
+				Authors(pubkey).
+				Kinds(1, 7).
+				Kinds(30023).
+				Tag("e", id).
+				Tag("#t", "nostr").
+				Tag("t", "go").
+				Since(since).
+				Until(until).
+				Limit(10),
+			want: &ReqFilter{
+				IDs:     []string{id},
+				Authors: []string{pubkey},
+				Kinds:   []int64{1, 7, 30023},
+				Tags: map[string][]string{
+					"#e": {id},
+					"#t": {"nostr", "go"},
+				},
+				Since: toPtr[int64](100),
+				Until: toPtr[int64](200),
+				Limit: toPtr[int64](10),
+			},
+		},
+		{
+			name:    "invalid id",
+			in:      NewFilterBuilder().IDs("invalid"),
+			wantErr: true,
+		},
+		{
+			name:    "invalid e tag",
+			in:      NewFilterBuilder().Tag("e", "invalid"),
+			wantErr: true,
+		},
+		{
+			name:    "invalid tag name",
+			in:      NewFilterBuilder().Tag("tag", "value"),
+			wantErr: true,
+		},
+		{
+			name:    "since after until",
+			in:      NewFilterBuilder().Since(until).Until(since),
+			wantErr: true,
+		},
+		{
+			name:    "negative limit",
+			in:      NewFilterBuilder().Limit(-1),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.in.Build()
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidReqFilter)
+				assert.Panics(t, func() { tt.in.MustBuild() })
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestFilterBuilder_Build_copy(t *testing.T) {
+	b := NewFilterBuilder().Kinds(1).Tag("t", "a").Limit(1)
+	fil := b.MustBuild()
+
+	b.Kinds(2).Tag("t", "b").Limit(2)
+	fil.Tags["#t"][0] = "x"
+
+	assert.Equal(t, &ReqFilter{
+		Kinds: []int64{1},
+		Tags:  map[string][]string{"#t": {"x"}},
+		Limit: toPtr[int64](1),
+	}, fil)
+	assert.Equal(t, &ReqFilter{
+		Kinds: []int64{1, 2},
+		Tags:  map[string][]string{"#t": {"a", "b"}},
+		Limit: toPtr[int64](2),
+	}, b.MustBuild())
+}
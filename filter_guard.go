@@ -0,0 +1,239 @@
+package mocrelay
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+type ExpensiveFilterAction string
+
+const (
+	// ExpensiveFilterReject closes the subscription.
+	ExpensiveFilterReject ExpensiveFilterAction = "reject"
+	// ExpensiveFilterCap caps the limit of the filter.
+	ExpensiveFilterCap ExpensiveFilterAction = "cap"
+	// ExpensiveFilterAuth closes the subscription unless the session is authenticated.
+	ExpensiveFilterAuth ExpensiveFilterAction = "auth"
+)
+
+type ExpensiveFilterOption struct {
+	// Action is the default ExpensiveFilterReject.
+	Action ExpensiveFilterAction
+	// MaxWindow is the max time window between since and until of filters without ids.
+	// A missing since is the beginning of time and a missing until is now.
+	// Zero disables the check and only full scans are expensive.
+	MaxWindow time.Duration
+	// CappedLimit is the limit ExpensiveFilterCap caps to. The default is 100.
+	CappedLimit int64
+
+	// Logger logs expensive queries if not nil.
+	Logger *slog.Logger
+	// Counter counts expensive queries if not nil.
+	Counter Counter
+}
+
+func (opt *ExpensiveFilterOption) action() ExpensiveFilterAction {
+	if opt == nil || opt.Action == "" {
+		return ExpensiveFilterReject
+	}
+	return opt.Action
+}
+
+func (opt *ExpensiveFilterOption) maxWindow() time.Duration {
+	if opt == nil {
+		return 0
+	}
+	return opt.MaxWindow
+}
+
+func (opt *ExpensiveFilterOption) cappedLimit() int64 {
+	if opt == nil || opt.CappedLimit == 0 {
+		return 100
+	}
+	return opt.CappedLimit
+}
+
+func (opt *ExpensiveFilterOption) logger() *slog.Logger {
+	if opt == nil || opt.Logger == nil {
+		return nil
+	}
+	return slog.New(WithSlogMocrelayHandler(opt.Logger.Handler()))
+}
+
+func (opt *ExpensiveFilterOption) counter() Counter {
+	if opt == nil {
+		return nil
+	}
+	return opt.Counter
+}
+
+// isFullScan reports whether f has no ids, authors, kinds and tags.
+func isFullScan(f *ReqFilter) bool {
+	return len(f.IDs) == 0 && len(f.Authors) == 0 && len(f.Kinds) == 0 && len(f.Tags) == 0
+}
+
+func filterWindow(f *ReqFilter, now time.Time) time.Duration {
+	until := now.Unix()
+	if f.Until != nil {
+		until = *f.Until
+	}
+	var since int64
+	if f.Since != nil {
+		since = *f.Since
+	}
+	return time.Duration(max(until-since, 0)) * time.Second
+}
+
+type ExpensiveFilterMiddleware Middleware
+
+// NewExpensiveFilterMiddleware detects REQ and COUNT with full scan filters
+// or enormous time windows and handles them with option.Action.
+func NewExpensiveFilterMiddleware(option *ExpensiveFilterOption) ExpensiveFilterMiddleware {
+	m := newSimpleExpensiveFilterMiddleware(option)
+	return ExpensiveFilterMiddleware(NewSimpleMiddleware(m))
+}
+
+var _ SimpleMiddlewareInterface = (*simpleExpensiveFilterMiddleware)(nil)
+
+type simpleExpensiveFilterMiddleware struct {
+	action      ExpensiveFilterAction
+	maxWindow   time.Duration
+	cappedLimit int64
+	logger      *slog.Logger
+	counter     Counter
+}
+
+func newSimpleExpensiveFilterMiddleware(
+	option *ExpensiveFilterOption,
+) *simpleExpensiveFilterMiddleware {
+	switch option.action() {
+	case ExpensiveFilterReject, ExpensiveFilterCap, ExpensiveFilterAuth:
+	default:
+		panicf("unknown expensive filter action %q", option.action())
+	}
+
+	return &simpleExpensiveFilterMiddleware{
+		action:      option.action(),
+		maxWindow:   option.maxWindow(),
+		cappedLimit: option.cappedLimit(),
+		logger:      option.logger(),
+		counter:     option.counter(),
+	}
+}
+
+func (m *simpleExpensiveFilterMiddleware) HandleStart(r *http.Request) (*http.Request, error) {
+	return r, nil
+}
+
+func (m *simpleExpensiveFilterMiddleware) HandleStop(r *http.Request) error {
+	return nil
+}
+
+// expensive returns the reason if f is expensive or an empty string.
+func (m *simpleExpensiveFilterMiddleware) expensive(f *ReqFilter, now time.Time) string {
+	if isFullScan(f) {
+		return "full scan"
+	}
+	if m.maxWindow > 0 && len(f.IDs) == 0 && filterWindow(f, now) > m.maxWindow {
+		return "too wide time window"
+	}
+	return ""
+}
+
+func (m *simpleExpensiveFilterMiddleware) HandleClientMsg(
+	r *http.Request,
+	msg ClientMsg,
+) (<-chan ClientMsg, <-chan ServerMsg, error) {
+	var subID string
+	var filters []*ReqFilter
+
+	switch msg := msg.(type) {
+	case *ClientReqMsg:
+		subID, filters = msg.SubscriptionID, msg.ReqFilters
+	case *ClientCountMsg:
+		subID, filters = msg.SubscriptionID, msg.ReqFilters
+	default:
+		return newClosedBufCh(msg), nil, nil
+	}
+
+	now := time.Now()
+	var reason string
+	for _, f := range filters {
+		if reason = m.expensive(f, now); reason != "" {
+			break
+		}
+	}
+	if reason == "" {
+		return newClosedBufCh(msg), nil, nil
+	}
+
+	incCounter(m.counter)
+	if m.logger != nil {
+		m.logger.InfoContext(
+			r.Context(),
+			"expensive filter",
+			"subscriptionID", subID,
+			"reason", reason,
+			"action", m.action,
+		)
+	}
+
+	switch m.action {
+	case ExpensiveFilterCap:
+		return newClosedBufCh(m.capLimit(msg, now)), nil, nil
+
+	case ExpensiveFilterAuth:
+		if GetSession(r.Context()).Pubkey() != "" {
+			return newClosedBufCh(msg), nil, nil
+		}
+		closedMsg := NewServerClosedMsg(
+			subID,
+			ServerClosedMsgPrefixAuthRequired,
+			reason+" needs authentication",
+		)
+		return nil, newClosedBufCh[ServerMsg](closedMsg), nil
+
+	default:
+		closedMsg := NewServerClosedMsg(subID, ServerClosedMsgPrefixRestricted, reason)
+		return nil, newClosedBufCh[ServerMsg](closedMsg), nil
+	}
+}
+
+// capLimit returns a copy of msg with the limits of expensive filters capped.
+func (m *simpleExpensiveFilterMiddleware) capLimit(msg ClientMsg, now time.Time) ClientMsg {
+	capFilters := func(filters []*ReqFilter) []*ReqFilter {
+		ret := make([]*ReqFilter, len(filters))
+		for i, f := range filters {
+			ret[i] = f
+			if m.expensive(f, now) == "" || (f.Limit != nil && *f.Limit <= m.cappedLimit) {
+				continue
+			}
+			ff := *f
+			limit := m.cappedLimit
+			ff.Limit = &limit
+			ret[i] = &ff
+		}
+		return ret
+	}
+
+	switch msg := msg.(type) {
+	case *ClientReqMsg:
+		ret := *msg
+		ret.ReqFilters = capFilters(msg.ReqFilters)
+		return &ret
+	case *ClientCountMsg:
+		ret := *msg
+		ret.ReqFilters = capFilters(msg.ReqFilters)
+		return &ret
+	default:
+		return msg
+	}
+}
+
+func (m *simpleExpensiveFilterMiddleware) HandleServerMsg(
+	r *http.Request,
+	msg ServerMsg,
+) (<-chan ServerMsg, error) {
+	return newClosedBufCh(msg), nil
+}
@@ -0,0 +1,134 @@
+package mocrelay
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpensiveFilterMiddleware(t *testing.T) {
+	toPtr := func(v int64) *int64 { return &v }
+	now := time.Now().Unix()
+
+	tests := []struct {
+		name      string
+		opt       *ExpensiveFilterOption
+		pubkey    string
+		filters   []*ReqFilter
+		wantMsg   ClientMsg
+		want      ServerMsg
+		expensive bool
+	}{
+		{
+			name:    "cheap",
+			filters: []*ReqFilter{{Kinds: []int64{1}}},
+			wantMsg: &ClientReqMsg{
+				SubscriptionID: "sub",
+				ReqFilters:     []*ReqFilter{{Kinds: []int64{1}}},
+			},
+		},
+		{
+			name:      "reject full scan",
+			expensive: true,
+			filters:   []*ReqFilter{{Kinds: []int64{1}}, {Limit: toPtr(10)}},
+			want:      NewServerClosedMsg("sub", ServerClosedMsgPrefixRestricted, "full scan"),
+		},
+		{
+			name:      "reject wide window",
+			expensive: true,
+			opt:       &ExpensiveFilterOption{MaxWindow: time.Hour},
+			filters:   []*ReqFilter{{Kinds: []int64{1}, Since: toPtr(now - 7200)}},
+			want: NewServerClosedMsg(
+				"sub",
+				ServerClosedMsgPrefixRestricted,
+				"too wide time window",
+			),
+		},
+		{
+			name: "narrow window",
+			opt:  &ExpensiveFilterOption{MaxWindow: time.Hour},
+			filters: []*ReqFilter{
+				{Kinds: []int64{1}, Since: toPtr(now - 1800)},
+				{IDs: []string{"id"}},
+			},
+			wantMsg: &ClientReqMsg{
+				SubscriptionID: "sub",
+				ReqFilters: []*ReqFilter{
+					{Kinds: []int64{1}, Since: toPtr(now - 1800)},
+					{IDs: []string{"id"}},
+				},
+			},
+		},
+		{
+			name:      "cap",
+			expensive: true,
+			opt:       &ExpensiveFilterOption{Action: ExpensiveFilterCap, CappedLimit: 10},
+			filters:   []*ReqFilter{{Kinds: []int64{1}}, {}, {Limit: toPtr(5)}},
+			wantMsg: &ClientReqMsg{
+				SubscriptionID: "sub",
+				ReqFilters: []*ReqFilter{
+					{Kinds: []int64{1}},
+					{Limit: toPtr(10)},
+					{Limit: toPtr(5)},
+				},
+			},
+		},
+		{
+			name:      "auth required",
+			expensive: true,
+			opt:       &ExpensiveFilterOption{Action: ExpensiveFilterAuth},
+			filters:   []*ReqFilter{{}},
+			want: NewServerClosedMsg(
+				"sub",
+				ServerClosedMsgPrefixAuthRequired,
+				"full scan needs authentication",
+			),
+		},
+		{
+			name:      "authenticated",
+			expensive: true,
+			opt:       &ExpensiveFilterOption{Action: ExpensiveFilterAuth},
+			pubkey:    "pubkey",
+			filters:   []*ReqFilter{{}},
+			wantMsg:   &ClientReqMsg{SubscriptionID: "sub", ReqFilters: []*ReqFilter{{}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var counter testCounter
+			opt := tt.opt
+			if opt == nil {
+				opt = &ExpensiveFilterOption{}
+			}
+			opt.Counter = &counter
+
+			sess := &Session{}
+			sess.SetPubkey(tt.pubkey)
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r = r.WithContext(ctxWithSession(r.Context(), sess))
+
+			m := newSimpleExpensiveFilterMiddleware(opt)
+			in := &ClientReqMsg{SubscriptionID: "sub", ReqFilters: tt.filters}
+			cmsgCh, smsgCh, err := m.HandleClientMsg(r, in)
+			assert.NoError(t, err)
+
+			if tt.want != nil {
+				assert.Nil(t, cmsgCh)
+				assert.Equal(t, tt.want, <-smsgCh)
+			} else {
+				assert.Nil(t, smsgCh)
+				assert.Equal(t, tt.wantMsg, <-cmsgCh)
+			}
+
+			if tt.expensive {
+				assert.Equal(t, 1, counter.n)
+			} else {
+				assert.Equal(t, 0, counter.n)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package mocrelay
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// Firehose broadcasts accepted events to its subscribers.
+// Slow subscribers miss events instead of blocking the relay.
+type Firehose struct {
+	mu     sync.RWMutex
+	nextID int
+	// map[id]ch
+	subs map[int]chan *Event
+
+	dropped atomic.Int64
+}
+
+func NewFirehose() *Firehose {
+	return &Firehose{subs: make(map[int]chan *Event)}
+}
+
+// Subscribe returns a channel of accepted events and a function to unsubscribe.
+// The channel is closed by unsubscribe.
+func (f *Firehose) Subscribe(buflen int) (<-chan *Event, func()) {
+	ch := make(chan *Event, buflen)
+
+	f.mu.Lock()
+	id := f.nextID
+	f.nextID++
+	f.subs[id] = ch
+	f.mu.Unlock()
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			f.mu.Lock()
+			delete(f.subs, id)
+			f.mu.Unlock()
+			close(ch)
+		})
+	}
+
+	return ch, unsubscribe
+}
+
+func (f *Firehose) Publish(event *Event) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	for _, ch := range f.subs {
+		select {
+		case ch <- event:
+		default:
+			f.dropped.Add(1)
+		}
+	}
+}
+
+// Dropped returns the number of events dropped for slow subscribers.
+func (f *Firehose) Dropped() int64 { return f.dropped.Load() }
+
+type FirehoseMiddleware Middleware
+
+// NewFirehoseMiddleware publishes events to f once the handler accepts them with OK true.
+func NewFirehoseMiddleware(f *Firehose) FirehoseMiddleware {
+	if f == nil {
+		panic("firehose must be non-nil pointer")
+	}
+
+	return func(h Handler) Handler {
+		return HandlerFunc(
+			func(r *http.Request, recv <-chan ClientMsg, send chan<- ServerMsg) error {
+				sm := newSimpleFirehoseMiddleware(f)
+				m := NewSimpleMiddleware(sm)
+				return m(h).Handle(r, recv, send)
+			},
+		)
+	}
+}
+
+var _ SimpleMiddlewareInterface = (*simpleFirehoseMiddleware)(nil)
+
+type simpleFirehoseMiddleware struct {
+	firehose *Firehose
+
+	mu sync.Mutex
+	// map[eventID]event
+	pending map[string]*Event
+}
+
+func newSimpleFirehoseMiddleware(f *Firehose) *simpleFirehoseMiddleware {
+	return &simpleFirehoseMiddleware{
+		firehose: f,
+		pending:  make(map[string]*Event),
+	}
+}
+
+func (m *simpleFirehoseMiddleware) HandleStart(r *http.Request) (*http.Request, error) {
+	return r, nil
+}
+
+func (m *simpleFirehoseMiddleware) HandleStop(r *http.Request) error {
+	return nil
+}
+
+func (m *simpleFirehoseMiddleware) HandleClientMsg(
+	r *http.Request,
+	msg ClientMsg,
+) (<-chan ClientMsg, <-chan ServerMsg, error) {
+	if msg, ok := msg.(*ClientEventMsg); ok {
+		m.mu.Lock()
+		m.pending[msg.Event.ID] = msg.Event
+		m.mu.Unlock()
+	}
+
+	return newClosedBufCh(msg), nil, nil
+}
+
+func (m *simpleFirehoseMiddleware) HandleServerMsg(
+	r *http.Request,
+	msg ServerMsg,
+) (<-chan ServerMsg, error) {
+	if msg, ok := msg.(*ServerOKMsg); ok {
+		m.mu.Lock()
+		event := m.pending[msg.EventID]
+		delete(m.pending, msg.EventID)
+		m.mu.Unlock()
+
+		if event != nil && msg.Accepted {
+			m.firehose.Publish(event)
+		}
+	}
+
+	return newClosedBufCh[ServerMsg](msg), nil
+}
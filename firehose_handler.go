@@ -0,0 +1,125 @@
+package mocrelay
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"nhooyr.io/websocket"
+)
+
+// FirehoseHandler streams every accepted event to trusted consumers such as
+// search indexers over WebSocket, or SSE if the request accepts text/event-stream.
+// Each message is an event in JSON. There is no filter and no history.
+type FirehoseHandler struct {
+	Firehose *Firehose
+
+	// Authorize authorizes the request. If nil, all requests are rejected.
+	Authorize func(r *http.Request) bool
+
+	// Buffer is the number of events buffered for each consumer.
+	// Events are dropped if the buffer is full. Default is 1024.
+	Buffer int
+}
+
+func (h *FirehoseHandler) buffer() int {
+	if h.Buffer <= 0 {
+		return 1024
+	}
+	return h.Buffer
+}
+
+// NewBearerAuthorizer returns an Authorize function which accepts
+// "Authorization: Bearer <token>" with one of tokens.
+func NewBearerAuthorizer(tokens ...string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			return false
+		}
+		for _, t := range tokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func (h *FirehoseHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.Authorize == nil || !h.Authorize(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		h.serveSSE(w, r)
+		return
+	}
+	h.serveWebSocket(w, r)
+}
+
+func (h *FirehoseHandler) serveSSE(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	ctx := r.Context()
+
+	events, unsubscribe := h.Firehose.Subscribe(h.buffer())
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	var buf []byte
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case event := <-events:
+			buf = append(buf[:0], "data: "...)
+			buf = event.appendJSONOrNull(buf)
+			buf = append(buf, "\n\n"...)
+			if _, err := w.Write(buf); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func (h *FirehoseHandler) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close(websocket.StatusInternalError, "")
+
+	// Consumers only read. CloseRead handles control frames and cancels ctx on close.
+	ctx := conn.CloseRead(r.Context())
+
+	events, unsubscribe := h.Firehose.Subscribe(h.buffer())
+	defer unsubscribe()
+
+	var buf []byte
+	for {
+		select {
+		case <-ctx.Done():
+			conn.Close(websocket.StatusNormalClosure, "")
+			return
+
+		case event := <-events:
+			buf = event.appendJSONOrNull(buf[:0])
+			if err := conn.Write(ctx, websocket.MessageText, buf); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package mocrelay
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+func TestNewBearerAuthorizer(t *testing.T) {
+	authorize := NewBearerAuthorizer("secret1", "secret2")
+
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"Bearer secret1", true},
+		{"Bearer secret2", true},
+		{"Bearer secret3", false},
+		{"secret1", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Authorization", tt.header)
+			assert.Equal(t, tt.want, authorize(r))
+		})
+	}
+}
+
+func TestFirehoseHandler_unauthorized(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler *FirehoseHandler
+	}{
+		{"nil authorize", &FirehoseHandler{Firehose: NewFirehose()}},
+		{
+			"invalid token",
+			&FirehoseHandler{Firehose: NewFirehose(), Authorize: NewBearerAuthorizer("secret")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			w := httptest.NewRecorder()
+			tt.handler.ServeHTTP(w, r)
+			assert.Equal(t, http.StatusUnauthorized, w.Code)
+		})
+	}
+}
+
+// publishUntil publishes event repeatedly until ctx is done because
+// consumers subscribe asynchronously.
+func publishUntil(ctx context.Context, f *Firehose, event *Event) {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			f.Publish(event)
+		}
+	}
+}
+
+func TestFirehoseHandler(t *testing.T) {
+	event := &Event{
+		ID:        "49d58222bd85ddabfc19b8052d35bcce2bad8f1f3030c0bc7dc9f10dba82a8a2",
+		Pubkey:    "dbf0becf24bf8dd7d779d7fb547e6112964ff042b77a42cc2d8488636eed9f5e",
+		CreatedAt: 1693157791,
+		Kind:      1,
+		Tags:      []Tag{},
+		Content:   "powa",
+		Sig:       "795e51656e8b863805c41b3a6e1195ed63bf8c5df1fc3a4078cd45aaf0d8838f2dc57b802819443364e8e38c0f35c97e409181680bfff83e58949500f5a8f0c8",
+	}
+	want, err := event.MarshalJSON()
+	require.NoError(t, err)
+
+	f := NewFirehose()
+	srv := httptest.NewServer(&FirehoseHandler{
+		Firehose:  f,
+		Authorize: NewBearerAuthorizer("secret"),
+	})
+	defer srv.Close()
+
+	header := http.Header{"Authorization": {"Bearer secret"}}
+
+	t.Run("websocket", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		conn, _, err := websocket.Dial(
+			ctx,
+			"ws"+strings.TrimPrefix(srv.URL, "http"),
+			&websocket.DialOptions{HTTPHeader: header},
+		)
+		require.NoError(t, err)
+		defer conn.Close(websocket.StatusNormalClosure, "")
+
+		go publishUntil(ctx, f, event)
+
+		_, b, err := conn.Read(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, string(want), string(b))
+	})
+
+	t.Run("sse", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+		require.NoError(t, err)
+		req.Header = header.Clone()
+		req.Header.Set("Accept", "text/event-stream")
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+		go publishUntil(ctx, f, event)
+
+		line, err := bufio.NewReader(resp.Body).ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, "data: "+string(want)+"\n", line)
+	})
+}
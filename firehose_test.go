@@ -0,0 +1,79 @@
+package mocrelay
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFirehose(t *testing.T) {
+	f := NewFirehose()
+
+	ch1, unsub1 := f.Subscribe(1)
+	ch2, unsub2 := f.Subscribe(0)
+	defer unsub2()
+
+	ev := &Event{ID: "a"}
+	f.Publish(ev)
+
+	assert.Equal(t, ev, <-ch1)
+	assert.Equal(t, int64(1), f.Dropped())
+
+	unsub1()
+	unsub1()
+	_, ok := <-ch1
+	assert.False(t, ok)
+
+	f.Publish(ev)
+	assert.Equal(t, int64(2), f.Dropped())
+	assert.Len(t, ch2, 0)
+}
+
+func TestFirehoseMiddleware(t *testing.T) {
+	accepted := &Event{ID: "accepted"}
+	rejected := &Event{ID: "rejected"}
+
+	f := NewFirehose()
+	events, unsub := f.Subscribe(10)
+	defer unsub()
+
+	var h Handler = HandlerFunc(func(r *http.Request, recv <-chan ClientMsg, send chan<- ServerMsg) error {
+		for msg := range recv {
+			msg := msg.(*ClientEventMsg)
+			send <- NewServerOKMsg(
+				msg.Event.ID,
+				msg.Event == accepted,
+				ServerOKMsgPrefixNoPrefix,
+				"",
+			)
+		}
+		return ErrRecvClosed
+	})
+	h = NewFirehoseMiddleware(f)(h)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	r := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+	recv := make(chan ClientMsg)
+	send := make(chan ServerMsg)
+
+	go h.Handle(r, recv, send)
+
+	recv <- &ClientEventMsg{Event: rejected}
+	<-send
+	recv <- &ClientEventMsg{Event: accepted}
+	<-send
+
+	select {
+	case ev := <-events:
+		assert.Equal(t, accepted, ev)
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	}
+	assert.Len(t, events, 0)
+}
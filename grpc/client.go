@@ -0,0 +1,85 @@
+package grpc
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+type AdminClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAdminClient(cc grpc.ClientConnInterface) *AdminClient {
+	return &AdminClient{cc: cc}
+}
+
+// Call calls method with params. Each param must be convertible by structpb.NewValue.
+func (c *AdminClient) Call(
+	ctx context.Context,
+	method string,
+	params []any,
+	opts ...grpc.CallOption,
+) (*structpb.Value, error) {
+	in, err := structpb.NewStruct(map[string]any{"method": method, "params": params})
+	if err != nil {
+		return nil, err
+	}
+
+	out := new(structpb.Value)
+	if err := c.cc.Invoke(ctx, "/"+AdminServiceName+"/Call", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+type FirehoseClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewFirehoseClient(cc grpc.ClientConnInterface) *FirehoseClient {
+	return &FirehoseClient{cc: cc}
+}
+
+type FirehoseSubscribeClient interface {
+	Recv() (*structpb.Struct, error)
+	grpc.ClientStream
+}
+
+func (c *FirehoseClient) Subscribe(
+	ctx context.Context,
+	opts ...grpc.CallOption,
+) (FirehoseSubscribeClient, error) {
+	stream, err := c.cc.NewStream(
+		ctx,
+		&FirehoseServiceDesc.Streams[0],
+		"/"+FirehoseServiceName+"/Subscribe",
+		opts...,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &firehoseSubscribeClient{stream}
+	if err := ret.ClientStream.SendMsg(new(emptypb.Empty)); err != nil {
+		return nil, err
+	}
+	if err := ret.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+type firehoseSubscribeClient struct {
+	grpc.ClientStream
+}
+
+func (c *firehoseSubscribeClient) Recv() (*structpb.Struct, error) {
+	m := new(structpb.Struct)
+	if err := c.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
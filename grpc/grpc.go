@@ -0,0 +1,269 @@
+// Package grpc exposes the admin operations and the firehose of accepted events over gRPC.
+//
+// The services use protobuf well-known types so that no generated code is needed:
+//
+//	service mocrelay.v1.Admin {
+//	  // {"method": "banpubkey", "params": ["<pubkey>", "<reason>"]}
+//	  rpc Call(google.protobuf.Struct) returns (google.protobuf.Value);
+//	}
+//
+//	service mocrelay.v1.Firehose {
+//	  // Events in NIP-01 JSON form.
+//	  rpc Subscribe(google.protobuf.Empty) returns (stream google.protobuf.Struct);
+//	}
+package grpc
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/high-moctane/mocrelay"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+	AdminServiceName    = "mocrelay.v1.Admin"
+	FirehoseServiceName = "mocrelay.v1.Firehose"
+)
+
+// ErrNoAuthorizer is returned by Register when srv has no Authorize.
+var ErrNoAuthorizer = errors.New("grpc services require authorization")
+
+type AdminServer interface {
+	Call(context.Context, *structpb.Struct) (*structpb.Value, error)
+}
+
+type FirehoseServer interface {
+	Subscribe(*emptypb.Empty, FirehoseSubscribeServer) error
+}
+
+type FirehoseSubscribeServer interface {
+	Send(*structpb.Struct) error
+	grpc.ServerStream
+}
+
+// Server implements AdminServer and FirehoseServer.
+type Server struct {
+	Admin    *mocrelay.AdminHandler
+	Firehose *mocrelay.Firehose
+
+	// Authorize reports whether the peer of a call or a stream is allowed.
+	// It is required to register the admin and firehose services.
+	Authorize func(ctx context.Context) bool
+
+	// FirehoseBuffer is the number of events buffered for each subscriber.
+	// Default is 256.
+	FirehoseBuffer int
+}
+
+var (
+	_ AdminServer    = (*Server)(nil)
+	_ FirehoseServer = (*Server)(nil)
+)
+
+func (s *Server) firehoseBuffer() int {
+	if s.FirehoseBuffer <= 0 {
+		return 256
+	}
+	return s.FirehoseBuffer
+}
+
+// Register registers the services of srv which have a non-nil backend.
+// No service is registered without srv.Authorize.
+func Register(reg grpc.ServiceRegistrar, srv *Server) error {
+	if (srv.Admin != nil || srv.Firehose != nil) && srv.Authorize == nil {
+		return ErrNoAuthorizer
+	}
+
+	if srv.Admin != nil {
+		reg.RegisterService(&AdminServiceDesc, srv)
+	}
+	if srv.Firehose != nil {
+		reg.RegisterService(&FirehoseServiceDesc, srv)
+	}
+	return nil
+}
+
+// NewBearerAuthorizer returns an Authorize function which accepts calls with
+// "authorization: Bearer <token>" metadata of one of tokens.
+func NewBearerAuthorizer(tokens ...string) func(ctx context.Context) bool {
+	return func(ctx context.Context) bool {
+		md, _ := metadata.FromIncomingContext(ctx)
+		for _, v := range md.Get("authorization") {
+			token, ok := strings.CutPrefix(v, "Bearer ")
+			if !ok {
+				continue
+			}
+			for _, t := range tokens {
+				if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+					return true
+				}
+			}
+		}
+		return false
+	}
+}
+
+func (s *Server) authorize(ctx context.Context) error {
+	if s.Authorize == nil || !s.Authorize(ctx) {
+		return status.Error(codes.Unauthenticated, "unauthorized")
+	}
+	return nil
+}
+
+func (s *Server) Call(ctx context.Context, in *structpb.Struct) (*structpb.Value, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	var req mocrelay.AdminRequest
+	if err := convertJSON(in.AsMap(), &req); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid request: %v", err)
+	}
+
+	result, err := s.Admin.Call(ctx, &req)
+	if err != nil {
+		return nil, status.Error(adminErrorCode(err), err.Error())
+	}
+
+	var v any
+	if err := convertJSON(result, &v); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to convert result: %v", err)
+	}
+	ret, err := structpb.NewValue(v)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to convert result: %v", err)
+	}
+	return ret, nil
+}
+
+func adminErrorCode(err error) codes.Code {
+	switch {
+	case errors.Is(err, mocrelay.ErrAdminMethodNotFound):
+		return codes.Unimplemented
+	case errors.Is(err, mocrelay.ErrModerationNotFound):
+		return codes.NotFound
+	case errors.Is(err, mocrelay.ErrModerationWindowExpired):
+		return codes.FailedPrecondition
+	default:
+		return codes.InvalidArgument
+	}
+}
+
+func (s *Server) Subscribe(_ *emptypb.Empty, stream FirehoseSubscribeServer) error {
+	ctx := stream.Context()
+	if err := s.authorize(ctx); err != nil {
+		return err
+	}
+
+	events, unsubscribe := s.Firehose.Subscribe(s.firehoseBuffer())
+	defer unsubscribe()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+
+		case event := <-events:
+			msg, err := eventToStruct(event)
+			if err != nil {
+				return status.Errorf(codes.Internal, "failed to convert event: %v", err)
+			}
+			if err := stream.Send(msg); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func eventToStruct(event *mocrelay.Event) (*structpb.Struct, error) {
+	var m map[string]any
+	if err := convertJSON(event, &m); err != nil {
+		return nil, err
+	}
+	return structpb.NewStruct(m)
+}
+
+func convertJSON(src, dst any) error {
+	b, err := json.Marshal(src)
+	if err != nil {
+		return fmt.Errorf("failed to marshal: %w", err)
+	}
+	if err := json.Unmarshal(b, dst); err != nil {
+		return fmt.Errorf("failed to unmarshal: %w", err)
+	}
+	return nil
+}
+
+var AdminServiceDesc = grpc.ServiceDesc{
+	ServiceName: AdminServiceName,
+	HandlerType: (*AdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Call",
+			Handler:    adminCallHandler,
+		},
+	},
+	Metadata: "mocrelay/v1/admin.proto",
+}
+
+func adminCallHandler(
+	srv any,
+	ctx context.Context,
+	dec func(any) error,
+	interceptor grpc.UnaryServerInterceptor,
+) (any, error) {
+	in := new(structpb.Struct)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).Call(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/" + AdminServiceName + "/Call",
+	}
+	handler := func(ctx context.Context, req any) (any, error) {
+		return srv.(AdminServer).Call(ctx, req.(*structpb.Struct))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var FirehoseServiceDesc = grpc.ServiceDesc{
+	ServiceName: FirehoseServiceName,
+	HandlerType: (*FirehoseServer)(nil),
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Subscribe",
+			Handler:       firehoseSubscribeHandler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "mocrelay/v1/firehose.proto",
+}
+
+func firehoseSubscribeHandler(srv any, stream grpc.ServerStream) error {
+	in := new(emptypb.Empty)
+	if err := stream.RecvMsg(in); err != nil {
+		return err
+	}
+	return srv.(FirehoseServer).Subscribe(in, &firehoseSubscribeServer{stream})
+}
+
+type firehoseSubscribeServer struct {
+	grpc.ServerStream
+}
+
+func (s *firehoseSubscribeServer) Send(m *structpb.Struct) error {
+	return s.ServerStream.SendMsg(m)
+}
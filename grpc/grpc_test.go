@@ -0,0 +1,167 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/high-moctane/mocrelay"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func newTestConn(t *testing.T, srv *Server) *grpc.ClientConn {
+	t.Helper()
+
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	require.NoError(t, Register(s, srv))
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	cc, err := grpc.NewClient(
+		"passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { cc.Close() })
+
+	return cc
+}
+
+func TestServer_Call(t *testing.T) {
+	pubkey := strings.Repeat("a", 64)
+
+	moderator := mocrelay.NewModerator(nil)
+	cc := newTestConn(t, &Server{
+		Admin:     &mocrelay.AdminHandler{Moderator: moderator},
+		Authorize: NewBearerAuthorizer("secret"),
+	})
+	client := NewAdminClient(cc)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := client.Call(ctx, "banpubkey", []any{pubkey, "spam"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = client.Call(
+		metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer wrong"),
+		"banpubkey",
+		[]any{pubkey, "spam"},
+	)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	assert.False(t, moderator.IsPubkeyBanned(pubkey))
+
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer secret")
+
+	ret, err := client.Call(ctx, "banpubkey", []any{pubkey, "spam"})
+	require.NoError(t, err)
+	assert.True(t, ret.GetBoolValue())
+	assert.True(t, moderator.IsPubkeyBanned(pubkey))
+
+	ret, err = client.Call(ctx, "listbannedpubkeys", nil)
+	require.NoError(t, err)
+	assert.Equal(
+		t,
+		[]any{map[string]any{"pubkey": pubkey, "reason": "spam"}},
+		ret.AsInterface(),
+	)
+
+	_, err = client.Call(ctx, "unknown", nil)
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+
+	_, err = client.Call(ctx, "banpubkey", []any{"invalid"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.Call(ctx, "unbanpubkey", []any{strings.Repeat("b", 64)})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestServer_Subscribe(t *testing.T) {
+	firehose := mocrelay.NewFirehose()
+	cc := newTestConn(t, &Server{
+		Firehose:  firehose,
+		Authorize: NewBearerAuthorizer("secret"),
+	})
+	client := NewFirehoseClient(cc)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := client.Subscribe(ctx)
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer secret")
+	stream, err = client.Subscribe(ctx)
+	require.NoError(t, err)
+
+	event := &mocrelay.Event{
+		ID:        strings.Repeat("1", 64),
+		Pubkey:    strings.Repeat("2", 64),
+		CreatedAt: 1693156107,
+		Kind:      1,
+		Tags:      []mocrelay.Tag{{"p", strings.Repeat("3", 64)}},
+		Content:   "hello",
+		Sig:       strings.Repeat("4", 128),
+	}
+
+	// The subscription is registered asynchronously, so publish until it arrives.
+	go func() {
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				firehose.Publish(event)
+			}
+		}
+	}()
+
+	msg, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{
+		"id":         event.ID,
+		"pubkey":     event.Pubkey,
+		"created_at": float64(event.CreatedAt),
+		"kind":       float64(event.Kind),
+		"tags":       []any{[]any{"p", strings.Repeat("3", 64)}},
+		"content":    event.Content,
+		"sig":        event.Sig,
+	}, msg.AsMap())
+}
+
+func TestRegister_noAuthorizer(t *testing.T) {
+	s := grpc.NewServer()
+	defer s.Stop()
+
+	err := Register(s, &Server{Admin: &mocrelay.AdminHandler{}})
+	assert.ErrorIs(t, err, ErrNoAuthorizer)
+	err = Register(s, &Server{Firehose: mocrelay.NewFirehose()})
+	assert.ErrorIs(t, err, ErrNoAuthorizer)
+	assert.Empty(t, s.GetServiceInfo())
+}
+
+func TestRegister_unimplemented(t *testing.T) {
+	cc := newTestConn(t, &Server{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := NewAdminClient(cc).Call(ctx, "supportedmethods", nil)
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+}
@@ -10,6 +10,7 @@ import (
 	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -76,6 +77,9 @@ func NewSimpleHandler(h SimpleHandlerInterface) SimpleHandler {
 							}
 
 							smsgCh, err := h.HandleClientMsg(r, cmsg)
+							if okMsg, ok := clientMsgOKError(cmsg, err); ok {
+								smsgCh, err = newClosedBufCh[ServerMsg](okMsg), nil
+							}
 							if err != nil {
 								return err
 							}
@@ -115,20 +119,158 @@ func NewSimpleHandler(h SimpleHandlerInterface) SimpleHandler {
 var ErrRouterHandlerStop = errors.New("router handler stopped")
 
 type RouterHandler struct {
-	buflen int
-	subs   *subscribers
+	buflen      int
+	idMatchMode IDMatchMode
+	subs        *subscribers
+	fanout      *fanout
+
+	sessMu sync.Mutex
+	// map[reqID]session
+	sessions map[string]*SessionInfo
+}
+
+type RouterHandlerOption struct {
+	// FanoutWorkers is the number of goroutines delivering published events to
+	// subscriptions in turn. Zero delivers events synchronously in Publish.
+	FanoutWorkers int
+	// FanoutQueueSize is the max number of pending events of each subscription
+	// with FanoutWorkers. The default is 1000.
+	FanoutQueueSize int
+	// DeliveryLatency observes the seconds from publishing events to queueing them
+	// for connections if not nil.
+	DeliveryLatency Observer
+	// IDMatchMode is how the ids and authors of subscriptions match events.
+	IDMatchMode IDMatchMode
+}
+
+func (opt *RouterHandlerOption) fanoutWorkers() int {
+	if opt == nil {
+		return 0
+	}
+	return opt.FanoutWorkers
+}
+
+func (opt *RouterHandlerOption) fanoutQueueSize() int {
+	if opt == nil || opt.FanoutQueueSize == 0 {
+		return 1000
+	}
+	return opt.FanoutQueueSize
+}
+
+func (opt *RouterHandlerOption) deliveryLatency() Observer {
+	if opt == nil {
+		return nil
+	}
+	return opt.DeliveryLatency
+}
+
+func (opt *RouterHandlerOption) idMatchMode() IDMatchMode {
+	if opt == nil {
+		return IDMatchExact
+	}
+	return opt.IDMatchMode
 }
 
 func NewRouterHandler(buflen int) *RouterHandler {
+	return NewRouterHandlerWithOption(buflen, nil)
+}
+
+// NewRouterHandlerWithOption is the same as NewRouterHandler but also applies the options.
+func NewRouterHandlerWithOption(buflen int, option *RouterHandlerOption) *RouterHandler {
 	if buflen <= 0 {
 		panicf("router handler buflen must be a positive integer but got %d", buflen)
 	}
-	return &RouterHandler{
-		buflen: buflen,
-		subs:   newSubscribers(),
+	router := &RouterHandler{
+		buflen:      buflen,
+		idMatchMode: option.idMatchMode(),
+		subs:        newSubscribers(option.deliveryLatency()),
+		sessions:    make(map[string]*SessionInfo),
+	}
+	if n := option.fanoutWorkers(); n > 0 {
+		router.fanout = newFanout(n, option.fanoutQueueSize(), option.deliveryLatency())
+		router.subs.fanout = router.fanout
+	}
+	return router
+}
+
+// Stop stops the fanout workers. Events published after Stop are not delivered
+// with FanoutWorkers.
+func (router *RouterHandler) Stop() {
+	if router.fanout != nil {
+		router.fanout.stop()
+	}
+}
+
+// FanoutPending returns the number of published events waiting for the fanout workers.
+func (router *RouterHandler) FanoutPending() int {
+	if router.fanout == nil {
+		return 0
+	}
+	return router.fanout.pending()
+}
+
+// SessionInfo is a snapshot of a connection handled by RouterHandler.
+type SessionInfo struct {
+	ID            string
+	RealIP        string
+	ConnectedAt   time.Time
+	Subscriptions []SubscriptionInfo
+}
+
+type SubscriptionInfo struct {
+	ID        string
+	Filters   []*ReqFilter
+	CreatedAt time.Time
+	// Sent is the number of events sent to the subscription.
+	Sent int64
+	// Dropped is the number of events dropped because the connection was too slow.
+	Dropped int64
+	// AvgDeliveryLatency is the average time from publishing events to sending them.
+	AvgDeliveryLatency time.Duration
+}
+
+// Sessions returns the active connections and their subscriptions
+// in the order they connected.
+func (router *RouterHandler) Sessions() []SessionInfo {
+	router.sessMu.Lock()
+	ret := make([]SessionInfo, 0, len(router.sessions))
+	for _, sess := range router.sessions {
+		ret = append(ret, *sess)
+	}
+	router.sessMu.Unlock()
+
+	subs := router.subs.Snapshot()
+	for i := range ret {
+		ret[i].Subscriptions = subs[ret[i].ID]
+	}
+
+	slices.SortFunc(ret, func(a, b SessionInfo) int {
+		if c := a.ConnectedAt.Compare(b.ConnectedAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return ret
+}
+
+func (router *RouterHandler) addSession(ctx context.Context, reqID string) {
+	router.sessMu.Lock()
+	defer router.sessMu.Unlock()
+
+	router.sessions[reqID] = &SessionInfo{
+		ID:          reqID,
+		RealIP:      GetRealIP(ctx),
+		ConnectedAt: time.Now(),
 	}
 }
 
+func (router *RouterHandler) removeSession(reqID string) {
+	router.sessMu.Lock()
+	defer router.sessMu.Unlock()
+
+	delete(router.sessions, reqID)
+}
+
 func (router *RouterHandler) Handle(
 	r *http.Request,
 	recv <-chan ClientMsg,
@@ -138,6 +280,8 @@ func (router *RouterHandler) Handle(
 	defer cancel()
 
 	reqID := GetRequestID(ctx)
+	router.addSession(ctx, reqID)
+	defer router.removeSession(reqID)
 	defer router.subs.UnsubscribeAll(reqID)
 
 	subCh := make(chan ServerMsg, router.buflen)
@@ -160,6 +304,11 @@ func (router *RouterHandler) Handle(
 	}
 }
 
+// Publish sends event to the matching subscriptions of all connections.
+func (router *RouterHandler) Publish(event *Event) {
+	router.subs.Publish(event)
+}
+
 func (router *RouterHandler) recv(
 	ctx context.Context,
 	reqID string,
@@ -168,7 +317,7 @@ func (router *RouterHandler) recv(
 ) ServerMsg {
 	switch msg := msg.(type) {
 	case *ClientReqMsg:
-		sub := newSubscriber(reqID, msg, subCh)
+		sub := newSubscriber(reqID, msg, subCh, router.idMatchMode)
 		router.subs.Subscribe(sub)
 		return NewServerEOSEMsg(msg.SubscriptionID)
 
@@ -191,35 +340,81 @@ func (router *RouterHandler) recv(
 type subscriber struct {
 	ReqID          string
 	SubscriptionID string
+	Filters        []*ReqFilter
 	Matcher        EventMatcher
 	Ch             chan ServerMsg
+	CreatedAt      time.Time
+	Sent           atomic.Int64
+	Dropped        atomic.Int64
+	// latency is the sum of delivery latencies of sent events in nanoseconds.
+	latency atomic.Int64
+	// closed is true after the subscription is closed or replaced.
+	closed atomic.Bool
 }
 
-func newSubscriber(reqID string, msg *ClientReqMsg, ch chan ServerMsg) *subscriber {
+func newSubscriber(
+	reqID string,
+	msg *ClientReqMsg,
+	ch chan ServerMsg,
+	mode IDMatchMode,
+) *subscriber {
 	return &subscriber{
 		ReqID:          reqID,
 		SubscriptionID: msg.SubscriptionID,
-		Matcher:        NewReqFiltersEventMatchers(msg.ReqFilters),
+		Filters:        msg.ReqFilters,
+		Matcher:        NewReqFiltersEventMatchersWithMode(msg.ReqFilters, mode),
 		Ch:             ch,
+		CreatedAt:      time.Now(),
+	}
+}
+
+// deliver sends event published at publishedAt to the connection of sub.
+func (sub *subscriber) deliver(event *Event, publishedAt time.Time, latency Observer) {
+	if sub.closed.Load() {
+		return
+	}
+
+	msg := ServerMsg(NewServerEventMsg(sub.SubscriptionID, event))
+	if !trySendCtx(context.TODO(), sub.Ch, msg) {
+		sub.Dropped.Add(1)
+		return
 	}
+
+	d := time.Since(publishedAt)
+	sub.Sent.Add(1)
+	sub.latency.Add(int64(d))
+	observe(latency, d.Seconds())
 }
 
-func (sub *subscriber) SendIfMatch(event *Event) {
-	if sub.Matcher.Match(event) {
-		trySendCtx(context.TODO(), sub.Ch, ServerMsg(NewServerEventMsg(sub.SubscriptionID, event)))
+func (sub *subscriber) Info() SubscriptionInfo {
+	info := SubscriptionInfo{
+		ID:        sub.SubscriptionID,
+		Filters:   sub.Filters,
+		CreatedAt: sub.CreatedAt,
+		Sent:      sub.Sent.Load(),
+		Dropped:   sub.Dropped.Load(),
+	}
+	if info.Sent > 0 {
+		info.AvgDeliveryLatency = time.Duration(sub.latency.Load() / info.Sent)
 	}
+	return info
 }
 
 type subscribers struct {
 	// map[reqID]map[subID]*subscriber
 	subs chan map[string]chan map[string]chan *subscriber
+
+	// fanout delivers events if not nil.
+	fanout  *fanout
+	latency Observer
 }
 
-func newSubscribers() *subscribers {
+func newSubscribers(latency Observer) *subscribers {
 	subs := make(chan map[string]chan map[string]chan *subscriber, 1)
 	subs <- make(map[string]chan map[string]chan *subscriber)
 	return &subscribers{
-		subs: subs,
+		subs:    subs,
+		latency: latency,
 	}
 }
 
@@ -239,7 +434,8 @@ func (subs *subscribers) Subscribe(sub *subscriber) {
 	mmch, ok := mm[sub.SubscriptionID]
 	if ok {
 		mch <- mm
-		<-mmch
+		old := <-mmch
+		old.closed.Store(true)
 	} else {
 		mmch = make(chan *subscriber, 1)
 		mm[sub.SubscriptionID] = mmch
@@ -257,14 +453,65 @@ func (subs *subscribers) Unsubscribe(reqID, subID string) {
 		return
 	}
 	mm := <-mch
+	mmch, ok := mm[subID]
 	delete(mm, subID)
 	mch <- mm
+	if !ok {
+		return
+	}
+
+	s := <-mmch
+	s.closed.Store(true)
+	mmch <- s
 }
 
 func (subs *subscribers) UnsubscribeAll(reqID string) {
 	m := <-subs.subs
+	mch, ok := m[reqID]
 	delete(m, reqID)
 	subs.subs <- m
+	if !ok {
+		return
+	}
+
+	mm := <-mch
+	for _, mmch := range mm {
+		s := <-mmch
+		s.closed.Store(true)
+		mmch <- s
+	}
+	mch <- mm
+}
+
+// Snapshot returns the subscriptions of each reqID sorted by their IDs.
+func (subs *subscribers) Snapshot() map[string][]SubscriptionInfo {
+	m := <-subs.subs
+	mchs := make(map[string]chan map[string]chan *subscriber, len(m))
+	for reqID, mch := range m {
+		mchs[reqID] = mch
+	}
+	subs.subs <- m
+
+	ret := make(map[string][]SubscriptionInfo, len(mchs))
+	for reqID, mch := range mchs {
+		mm := <-mch
+		mmchs := make([]chan *subscriber, 0, len(mm))
+		for _, mmch := range mm {
+			mmchs = append(mmchs, mmch)
+		}
+		mch <- mm
+
+		infos := make([]SubscriptionInfo, 0, len(mmchs))
+		for _, mmch := range mmchs {
+			s := <-mmch
+			infos = append(infos, s.Info())
+			mmch <- s
+		}
+		slices.SortFunc(infos, func(a, b SubscriptionInfo) int { return cmp.Compare(a.ID, b.ID) })
+		ret[reqID] = infos
+	}
+
+	return ret
 }
 
 func (subs *subscribers) Publish(event *Event) {
@@ -284,29 +531,243 @@ func (subs *subscribers) Publish(event *Event) {
 		mch <- mm
 	}
 
+	now := time.Now()
 	for _, mmch := range mmchs {
 		s := <-mmch
-		s.SendIfMatch(event)
+		match := s.Matcher.Match(event)
 		mmch <- s
+
+		if !match {
+			continue
+		}
+		if subs.fanout != nil {
+			subs.fanout.enqueue(s, event, now)
+		} else {
+			s.deliver(event, now, subs.latency)
+		}
+	}
+}
+
+type CacheHandler struct {
+	h Handler
+	c *simpleCacheHandler
+}
+
+type CacheHandlerOption struct {
+	// QueryTimeout is the timeout of a REQ query. On timeout, the events found so far
+	// are sent with EOSE and a NOTICE. Zero means no timeout.
+	QueryTimeout time.Duration
+	// MaxConcurrentQueries is the max number of REQ queries run at the same time.
+	// The default is GOMAXPROCS.
+	MaxConcurrentQueries int
+	// CountCacheTTL is how long COUNT results are cached. Results are invalidated
+	// by matching inserts but not by evictions from the cache. Zero disables it.
+	CountCacheTTL time.Duration
+
+	// MaxBytes is the approximate memory budget of cached events. The oldest events are
+	// evicted when it is exceeded. Zero means only the size limits the cache.
+	MaxBytes int64
+	// EvictionCounter counts events evicted by the size or MaxBytes if not nil.
+	EvictionCounter Counter
+
+	// Shards is the number of partitions of the cache with their own locks.
+	// The size and MaxBytes are divided among them, so the oldest events are evicted
+	// per shard. The default is 1.
+	Shards int
+
+	// IDMatchMode is how the ids and authors of REQ and COUNT match events.
+	IDMatchMode IDMatchMode
+
+	// Interner interns the repeated strings of events loaded from snapshots.
+	// It is usually the same as the one of the relay.
+	Interner *Interner
+}
+
+func (opt *CacheHandlerOption) queryTimeout() time.Duration {
+	if opt == nil {
+		return 0
+	}
+	return opt.QueryTimeout
+}
+
+func (opt *CacheHandlerOption) maxConcurrentQueries() int {
+	if opt == nil || opt.MaxConcurrentQueries == 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return opt.MaxConcurrentQueries
+}
+
+func (opt *CacheHandlerOption) countCacheTTL() time.Duration {
+	if opt == nil {
+		return 0
+	}
+	return opt.CountCacheTTL
+}
+
+func (opt *CacheHandlerOption) maxBytes() int64 {
+	if opt == nil {
+		return 0
+	}
+	return opt.MaxBytes
+}
+
+func (opt *CacheHandlerOption) evictionCounter() Counter {
+	if opt == nil {
+		return nil
 	}
+	return opt.EvictionCounter
 }
 
-type CacheHandler SimpleHandler
+func (opt *CacheHandlerOption) shards() int {
+	if opt == nil || opt.Shards == 0 {
+		return 1
+	}
+	return opt.Shards
+}
 
-func NewCacheHandler(size int) CacheHandler {
-	return CacheHandler(NewSimpleHandler(newSimpleCacheHandler(size)))
+func (opt *CacheHandlerOption) idMatchMode() IDMatchMode {
+	if opt == nil {
+		return IDMatchExact
+	}
+	return opt.IDMatchMode
+}
+
+func (opt *CacheHandlerOption) interner() *Interner {
+	if opt == nil {
+		return nil
+	}
+	return opt.Interner
+}
+
+// defaultCountCacheEntries is the max number of cached COUNT results of CacheHandler.
+const defaultCountCacheEntries = 4096
+
+func NewCacheHandler(size int) *CacheHandler {
+	return NewCacheHandlerWithOption(size, nil)
+}
+
+// NewCacheHandlerWithOption is the same as NewCacheHandler but also applies the options.
+func NewCacheHandlerWithOption(size int, option *CacheHandlerOption) *CacheHandler {
+	c := newSimpleCacheHandler(size, option)
+	return &CacheHandler{
+		h: NewSimpleHandler(c),
+		c: c,
+	}
+}
+
+func (h *CacheHandler) Handle(r *http.Request, recv <-chan ClientMsg, send chan<- ServerMsg) error {
+	return h.h.Handle(r, recv, send)
+}
+
+// PurgePubkey deletes all events by pubkey and returns the number of them.
+func (h *CacheHandler) PurgePubkey(ctx context.Context, pubkey string) (int, error) {
+	return h.c.purgePubkey(pubkey), nil
+}
+
+// Bytes returns the approximate memory usage of cached events.
+func (h *CacheHandler) Bytes() int64 {
+	return h.c.c.Bytes()
 }
 
 type simpleCacheHandler struct {
-	sema chan struct{}
-	c    *eventCache
+	sema         chan struct{}
+	c            *shardedEventCache
+	queryTimeout time.Duration
+	counts       *countCache
+	interner     *Interner
 }
 
-func newSimpleCacheHandler(size int) *simpleCacheHandler {
-	return &simpleCacheHandler{
-		sema: make(chan struct{}, runtime.GOMAXPROCS(0)),
-		c:    newEventCache(size),
+func newSimpleCacheHandler(size int, option *CacheHandlerOption) *simpleCacheHandler {
+	h := &simpleCacheHandler{
+		sema: make(chan struct{}, option.maxConcurrentQueries()),
+		c: newShardedEventCache(
+			size,
+			option.shards(),
+			option.maxBytes(),
+			option.evictionCounter(),
+		),
+		queryTimeout: option.queryTimeout(),
+		interner:     option.interner(),
 	}
+	h.c.idMatchMode = option.idMatchMode()
+	if ttl := option.countCacheTTL(); ttl > 0 {
+		h.counts = newCountCache(defaultCountCacheEntries, ttl)
+		h.counts.idMatchMode = option.idMatchMode()
+	}
+	return h
+}
+
+func (h *simpleCacheHandler) purgePubkey(pubkey string) int {
+	n := h.c.DeletePubkey(pubkey)
+	if h.counts != nil {
+		h.counts.Clear()
+	}
+	return n
+}
+
+// invalidateCounts invalidates COUNT results changed by ev.
+// It must be called after ev is applied to the cache.
+func (h *simpleCacheHandler) invalidateCounts(ev *Event) {
+	if h.counts == nil {
+		return
+	}
+	if ev.Kind == 5 || ev.EventType() != EventTypeRegular {
+		// Deleted or replaced events can match any filter.
+		h.counts.Clear()
+		return
+	}
+	h.counts.Invalidate(ev)
+}
+
+// query finds events matching filters under the query timeout and concurrency limit.
+// It returns the events found so far with an error on timeout.
+func (h *simpleCacheHandler) query(
+	ctx context.Context,
+	filters []*ReqFilter,
+) ([]*Event, error) {
+	if h.queryTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.queryTimeout)
+		defer cancel()
+	}
+
+	select {
+	case h.sema <- struct{}{}:
+		defer func() { <-h.sema }()
+		return h.c.FindContext(ctx, filters)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// count returns the number of events matching filters.
+// The result is approximate on timeout.
+func (h *simpleCacheHandler) count(
+	ctx context.Context,
+	subID string,
+	filters []*ReqFilter,
+) *ServerCountMsg {
+	var key string
+	var gen uint64
+	if h.counts != nil {
+		key = countCacheKey(filters)
+		n, g, ok := h.counts.Get(key)
+		if ok {
+			return NewServerCountMsg(subID, n, nil)
+		}
+		gen = g
+	}
+
+	evs, err := h.query(ctx, filters)
+	n := uint64(len(evs))
+	if errors.Is(err, context.DeadlineExceeded) {
+		approx := true
+		return NewServerCountMsg(subID, n, &approx)
+	}
+	if h.counts != nil && err == nil {
+		h.counts.Set(key, filters, n, gen)
+	}
+	return NewServerCountMsg(subID, n, nil)
 }
 
 func (h *simpleCacheHandler) HandleStart(r *http.Request) (*http.Request, error) {
@@ -323,16 +784,11 @@ func (h *simpleCacheHandler) HandleClientMsg(
 ) (<-chan ServerMsg, error) {
 	switch msg := msg.(type) {
 	case *ClientEventMsg:
-		for i := 0; i < cap(h.sema); i++ {
-			h.sema <- struct{}{}
-		}
-		defer func() {
-			for i := 0; i < cap(h.sema); i++ {
-				<-h.sema
-			}
-		}()
-
 		ev := msg.Event
+		if eventExpired(ev, time.Now()) {
+			okMsg := NewServerOKMsg(ev.ID, false, ServerOkMsgPrefixRateInvalid, "event is expired")
+			return newClosedBufCh[ServerMsg](okMsg), nil
+		}
 		if ev.Kind == 5 {
 			for _, tag := range ev.Tags {
 				if len(tag) < 2 {
@@ -348,7 +804,11 @@ func (h *simpleCacheHandler) HandleClientMsg(
 		}
 
 		var okMsg ServerMsg
+		if ev.Kind == 5 {
+			h.invalidateCounts(ev)
+		}
 		if h.c.Add(ev) {
+			h.invalidateCounts(ev)
 			okMsg = NewServerOKMsg(msg.Event.ID, true, "", "")
 		} else {
 			okMsg = NewServerOKMsg(msg.Event.ID, false, ServerOKMsgPrefixDuplicate, "already have this event")
@@ -356,22 +816,24 @@ func (h *simpleCacheHandler) HandleClientMsg(
 		return newClosedBufCh(okMsg), nil
 
 	case *ClientReqMsg:
-		h.sema <- struct{}{}
-		defer func() { <-h.sema }()
-
-		evs := h.c.Find(NewReqFiltersEventMatchers(msg.ReqFilters))
+		start := time.Now()
+		evs, err := h.query(r.Context(), msg.ReqFilters)
+		observeQuery(r.Context(), msg.SubscriptionID, time.Since(start))
 
-		smsgCh := make(chan ServerMsg, len(evs)+1)
+		smsgCh := make(chan ServerMsg, len(evs)+2)
 		defer close(smsgCh)
 
 		for _, ev := range evs {
 			smsgCh <- NewServerEventMsg(msg.SubscriptionID, ev)
 		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			smsgCh <- NewServerNoticeMsgf("query timeout: %s: results may be partial", msg.SubscriptionID)
+		}
 		smsgCh <- NewServerEOSEMsg(msg.SubscriptionID)
 		return smsgCh, nil
 
 	case *ClientCountMsg:
-		ret := NewServerCountMsg(msg.SubscriptionID, 0, nil)
+		ret := h.count(r.Context(), msg.SubscriptionID, msg.ReqFilters)
 		return newClosedBufCh[ServerMsg](ret), nil
 
 	default:
@@ -597,28 +1059,31 @@ func (ss *mergeHandlerSession) handleSend(ctx context.Context, send chan<- Serve
 			return
 
 		case msg := <-ss.preSendCh:
-			m := ss.handleSendMsg(msg)
-			sendServerMsgCtx(ctx, send, m)
+			for _, m := range ss.handleSendMsg(msg) {
+				sendServerMsgCtx(ctx, send, m)
+			}
 		}
 	}
 }
 
-func (ss *mergeHandlerSession) handleSendMsg(msg *mergeHandlerSessionSendMsg) ServerMsg {
+func (ss *mergeHandlerSession) handleSendMsg(msg *mergeHandlerSessionSendMsg) []ServerMsg {
 	switch msg.Msg.(type) {
 	case *ServerEOSEMsg:
 		return ss.handleSendEOSEMsg(msg)
 	case *ServerEventMsg:
-		return ss.handleSendEventMsg(msg)
+		return []ServerMsg{ss.handleSendEventMsg(msg)}
 	case *ServerOKMsg:
-		return ss.handleSendOKMsg(msg)
+		return []ServerMsg{ss.handleSendOKMsg(msg)}
 	case *ServerCountMsg:
-		return ss.handleSendCountMsg(msg)
+		return []ServerMsg{ss.handleSendCountMsg(msg)}
 	default:
-		return msg.Msg
+		return []ServerMsg{msg.Msg}
 	}
 }
 
-func (ss *mergeHandlerSession) handleSendEOSEMsg(msg *mergeHandlerSessionSendMsg) *ServerEOSEMsg {
+// handleSendEOSEMsg returns EOSE followed by the held live events
+// when all the handlers have sent EOSE. A NOTICE follows if some of them are dropped.
+func (ss *mergeHandlerSession) handleSendEOSEMsg(msg *mergeHandlerSessionSendMsg) []ServerMsg {
 	m := msg.Msg.(*ServerEOSEMsg)
 
 	s := <-ss.reqStat
@@ -632,7 +1097,18 @@ func (ss *mergeHandlerSession) handleSendEOSEMsg(msg *mergeHandlerSessionSendMsg
 		return nil
 	}
 
-	return m
+	ret := []ServerMsg{m}
+	for _, ev := range s.TakePending(m.SubscriptionID, time.Now()) {
+		ret = append(ret, ev)
+	}
+	if n := s.TakeOverflow(m.SubscriptionID); n > 0 {
+		ret = append(ret, NewServerNoticeMsgf(
+			"too many live events before eose: %s: %d events dropped",
+			m.SubscriptionID,
+			n,
+		))
+	}
+	return ret
 }
 
 func (ss *mergeHandlerSession) handleSendEventMsg(msg *mergeHandlerSessionSendMsg) *ServerEventMsg {
@@ -641,7 +1117,7 @@ func (ss *mergeHandlerSession) handleSendEventMsg(msg *mergeHandlerSessionSendMs
 	s := <-ss.reqStat
 	defer func() { ss.reqStat <- s }()
 
-	if !s.IsSendableEventMsg(msg.Idx, m) {
+	if !s.IsSendableEventMsg(msg.Idx, m, time.Now()) {
 		return nil
 	}
 
@@ -752,6 +1228,14 @@ func (stat *mergeHandlerSessionOKState) ClearEventID(eventID string) {
 	delete(stat.s, eventID)
 }
 
+// mergeReplayDedupeWindow is how long the ids of replayed events are kept after EOSE
+// to drop their live duplicates delayed by other handlers.
+const mergeReplayDedupeWindow = 30 * time.Second
+
+// maxMergePendingEvents is the max number of live events held for a subscription until EOSE.
+// Events beyond it are dropped and reported with a NOTICE after EOSE.
+const maxMergePendingEvents = 1000
+
 type mergeHandlerSessionReqState struct {
 	size int
 	// map[subID][chIdx]eose?
@@ -760,21 +1244,35 @@ type mergeHandlerSessionReqState struct {
 	lastEvent map[string]*ServerEventMsg
 	// map[subID]map[eventID]seen
 	seen map[string]map[string]bool
+	// map[subID]events sent before EOSE by handlers which have sent EOSE
+	pending map[string][]*ServerEventMsg
+	// map[subID]number of events dropped beyond maxMergePendingEvents
+	overflow map[string]int
+	// map[subID]map[eventID]replayed
+	replayed map[string]map[string]bool
+	// map[subID]time to forget replayed
+	replayedUntil map[string]time.Time
 }
 
 func newMergeHandlerSessionReqState(size int) *mergeHandlerSessionReqState {
 	return &mergeHandlerSessionReqState{
-		size:      size,
-		eose:      make(map[string][]bool),
-		lastEvent: make(map[string]*ServerEventMsg),
-		seen:      make(map[string]map[string]bool),
+		size:          size,
+		eose:          make(map[string][]bool),
+		lastEvent:     make(map[string]*ServerEventMsg),
+		seen:          make(map[string]map[string]bool),
+		pending:       make(map[string][]*ServerEventMsg),
+		overflow:      make(map[string]int),
+		replayed:      make(map[string]map[string]bool),
+		replayedUntil: make(map[string]time.Time),
 	}
 }
 
 func (stat *mergeHandlerSessionReqState) SetSubID(subID string) {
+	stat.ClearSubID(subID)
 	stat.eose[subID] = make([]bool, stat.size)
 	stat.lastEvent[subID] = nil
 	stat.seen[subID] = make(map[string]bool)
+	stat.replayed[subID] = make(map[string]bool)
 }
 
 func (stat *mergeHandlerSessionReqState) SetEOSE(subID string, chIdx int) {
@@ -804,39 +1302,116 @@ func (stat *mergeHandlerSessionReqState) IsEOSE(subID string, chIdx int) bool {
 	return len(eoses) == 0 || eoses[chIdx]
 }
 
+// IsSendableEventMsg reports whether msg is sent now.
+// Before EOSE, events are merged in the descending order of created_at and
+// live events of handlers which have sent EOSE are held until all the handlers send EOSE.
+// After EOSE, live duplicates of replayed events are dropped.
 func (stat *mergeHandlerSessionReqState) IsSendableEventMsg(
 	chIdx int,
 	msg *ServerEventMsg,
+	now time.Time,
 ) bool {
-	if stat.AllEOSE(msg.SubscriptionID) {
-		return true
+	subID := msg.SubscriptionID
+
+	if stat.AllEOSE(subID) {
+		return !stat.isReplayed(subID, msg.Event.ID, now)
 	}
 
-	if stat.IsEOSE(msg.SubscriptionID, chIdx) {
+	if stat.IsEOSE(subID, chIdx) {
+		if len(stat.pending[subID]) < maxMergePendingEvents {
+			stat.pending[subID] = append(stat.pending[subID], msg)
+		} else {
+			stat.overflow[subID]++
+		}
 		return false
 	}
 
-	if last := stat.lastEvent[msg.SubscriptionID]; last != nil {
+	if last := stat.lastEvent[subID]; last != nil {
 		if res := cmp.Compare(last.Event.CreatedAt, msg.Event.CreatedAt); res < 0 {
 			return false
 		} else if res > 0 {
-			stat.seen[msg.SubscriptionID] = make(map[string]bool)
+			stat.seen[subID] = make(map[string]bool)
 		}
 	}
-	stat.lastEvent[msg.SubscriptionID] = msg
+	stat.lastEvent[subID] = msg
 
-	if stat.seen[msg.SubscriptionID] == nil || stat.seen[msg.SubscriptionID][msg.Event.ID] {
+	if stat.seen[subID] == nil || stat.seen[subID][msg.Event.ID] {
 		return false
 	}
-	stat.seen[msg.SubscriptionID][msg.Event.ID] = true
+	stat.seen[subID][msg.Event.ID] = true
+	stat.replayed[subID][msg.Event.ID] = true
 
 	return true
 }
 
+// TakePending returns the held live events which have not been replayed
+// and starts the dedupe window of replayed events.
+func (stat *mergeHandlerSessionReqState) TakePending(
+	subID string,
+	now time.Time,
+) []*ServerEventMsg {
+	stat.forgetReplayed(now)
+
+	replayed := stat.replayed[subID]
+	var ret []*ServerEventMsg
+	for _, msg := range stat.pending[subID] {
+		if replayed[msg.Event.ID] {
+			continue
+		}
+		if replayed != nil {
+			replayed[msg.Event.ID] = true
+		}
+		ret = append(ret, msg)
+	}
+	delete(stat.pending, subID)
+
+	if len(replayed) > 0 {
+		stat.replayedUntil[subID] = now.Add(mergeReplayDedupeWindow)
+	} else {
+		delete(stat.replayed, subID)
+	}
+
+	return ret
+}
+
+// TakeOverflow returns the number of live events dropped before EOSE.
+func (stat *mergeHandlerSessionReqState) TakeOverflow(subID string) int {
+	n := stat.overflow[subID]
+	delete(stat.overflow, subID)
+	return n
+}
+
+func (stat *mergeHandlerSessionReqState) isReplayed(subID, eventID string, now time.Time) bool {
+	replayed, ok := stat.replayed[subID]
+	if !ok {
+		return false
+	}
+	if now.After(stat.replayedUntil[subID]) {
+		delete(stat.replayed, subID)
+		delete(stat.replayedUntil, subID)
+		return false
+	}
+	return replayed[eventID]
+}
+
+// forgetReplayed deletes replayed events whose dedupe windows have passed.
+func (stat *mergeHandlerSessionReqState) forgetReplayed(now time.Time) {
+	for subID, until := range stat.replayedUntil {
+		if now.After(until) {
+			delete(stat.replayed, subID)
+			delete(stat.replayedUntil, subID)
+		}
+	}
+}
+
 func (stat *mergeHandlerSessionReqState) ClearSubID(subID string) {
 	delete(stat.eose, subID)
 	delete(stat.lastEvent, subID)
 	delete(stat.seen, subID)
+	delete(stat.pending, subID)
+	delete(stat.overflow, subID)
+	delete(stat.replayed, subID)
+	delete(stat.replayedUntil, subID)
 }
 
 type mergeHandlerSessionCountState struct {
@@ -935,6 +1510,10 @@ func NewSimpleMiddleware(m SimpleMiddlewareInterface) SimpleMiddleware {
 								}
 
 								cmsgCh, smsgCh, err := m.HandleClientMsg(r, cmsg)
+								if okMsg, ok := clientMsgOKError(cmsg, err); ok {
+									sendServerMsgCtx(ctx, send, okMsg)
+									continue
+								}
 								if err != nil {
 									return err
 								}
@@ -1595,12 +2174,12 @@ func NewRecvEventUniqueFilterMiddleware(size int) RecvEventUniqueFilterMiddlewar
 var _ SimpleMiddlewareInterface = (*simpleRecvEventUniqueFilterMiddleware)(nil)
 
 type simpleRecvEventUniqueFilterMiddleware struct {
-	c *randCache[string, struct{}]
+	c *lruCache[string, struct{}]
 }
 
 func newSimpleRecvEventUniqueFilterMiddleware(size int) *simpleRecvEventUniqueFilterMiddleware {
 	return &simpleRecvEventUniqueFilterMiddleware{
-		c: newRandCache[string, struct{}](size),
+		c: newLRUCache[string, struct{}](size),
 	}
 }
 
@@ -1658,12 +2237,12 @@ func NewSendEventUniqueFilterMiddleware(size int) SendEventUniqueFilterMiddlewar
 var _ SimpleMiddlewareInterface = (*simpleSendEventUniqueFilterMiddleware)(nil)
 
 type simpleSendEventUniqueFilterMiddleware struct {
-	c *randCache[string, struct{}]
+	c *lruCache[string, struct{}]
 }
 
 func newSimpleSendEventUniqueFilterMiddleware(size int) *simpleSendEventUniqueFilterMiddleware {
 	return &simpleSendEventUniqueFilterMiddleware{
-		c: newRandCache[string, struct{}](size),
+		c: newLRUCache[string, struct{}](size),
 	}
 }
 
@@ -1697,3 +2276,209 @@ func (m *simpleSendEventUniqueFilterMiddleware) HandleServerMsg(
 
 	return newClosedBufCh[ServerMsg](msg), nil
 }
+
+// ReqTiming is the timing of a REQ observed from the messages around the handler.
+// TimeToFirstMsg is the time from the REQ to its first EVENT or EOSE,
+// which includes the store query but also queueing and the other middlewares.
+// Query is the time the cache or store handler spent querying events,
+// which is zero if the handler does not report it.
+type ReqTiming struct {
+	SubscriptionID string
+	TimeToFirstMsg time.Duration
+	Query          time.Duration
+	Send           time.Duration
+}
+
+func (t *ReqTiming) Total() time.Duration { return t.TimeToFirstMsg + t.Send }
+
+const ReqTimingDebugQueryKey = "debug_timing"
+
+type ReqTimingMiddleware Middleware
+
+func NewReqTimingMiddleware(observe func(*http.Request, *ReqTiming)) ReqTimingMiddleware {
+	return func(h Handler) Handler {
+		return HandlerFunc(
+			func(r *http.Request, recv <-chan ClientMsg, send chan<- ServerMsg) error {
+				sm := newSimpleReqTimingMiddleware(observe)
+				m := NewSimpleMiddleware(sm)
+				return m(h).Handle(r, recv, send)
+			},
+		)
+	}
+}
+
+var _ SimpleMiddlewareInterface = (*simpleReqTimingMiddleware)(nil)
+
+type simpleReqTimingMiddleware struct {
+	observe func(*http.Request, *ReqTiming)
+	debug   bool
+
+	mu sync.Mutex
+	// map[subID]state
+	reqs map[string]*reqTimingState
+}
+
+type reqTimingState struct {
+	start, first time.Time
+	query        time.Duration
+}
+
+func newSimpleReqTimingMiddleware(
+	observe func(*http.Request, *ReqTiming),
+) *simpleReqTimingMiddleware {
+	return &simpleReqTimingMiddleware{
+		observe: observe,
+		reqs:    make(map[string]*reqTimingState),
+	}
+}
+
+func (m *simpleReqTimingMiddleware) HandleStart(r *http.Request) (*http.Request, error) {
+	m.debug = r.URL != nil && r.URL.Query().Has(ReqTimingDebugQueryKey)
+	return r.WithContext(ctxWithQueryObserver(r.Context(), m)), nil
+}
+
+func (m *simpleReqTimingMiddleware) observeQuery(subID string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if s := m.reqs[subID]; s != nil {
+		s.query += d
+	}
+}
+
+func (m *simpleReqTimingMiddleware) HandleStop(r *http.Request) error {
+	return nil
+}
+
+func (m *simpleReqTimingMiddleware) HandleClientMsg(
+	r *http.Request,
+	msg ClientMsg,
+) (<-chan ClientMsg, <-chan ServerMsg, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch msg := msg.(type) {
+	case *ClientReqMsg:
+		m.reqs[msg.SubscriptionID] = &reqTimingState{start: time.Now()}
+
+	case *ClientCloseMsg:
+		delete(m.reqs, msg.SubscriptionID)
+	}
+
+	return newClosedBufCh(msg), nil, nil
+}
+
+func (m *simpleReqTimingMiddleware) HandleServerMsg(
+	r *http.Request,
+	msg ServerMsg,
+) (<-chan ServerMsg, error) {
+	now := time.Now()
+
+	var subID string
+	switch msg := msg.(type) {
+	case *ServerEventMsg:
+		subID = msg.SubscriptionID
+	case *ServerEOSEMsg:
+		subID = msg.SubscriptionID
+	default:
+		return newClosedBufCh(msg), nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := m.reqs[subID]
+	if s == nil {
+		return newClosedBufCh(msg), nil
+	}
+	if s.first.IsZero() {
+		s.first = now
+	}
+	if _, ok := msg.(*ServerEOSEMsg); !ok {
+		return newClosedBufCh(msg), nil
+	}
+
+	delete(m.reqs, subID)
+	timing := &ReqTiming{
+		SubscriptionID: subID,
+		TimeToFirstMsg: s.first.Sub(s.start),
+		Query:          s.query,
+		Send:           now.Sub(s.first),
+	}
+	if m.observe != nil {
+		m.observe(r, timing)
+	}
+
+	if !m.debug {
+		return newClosedBufCh(msg), nil
+	}
+
+	notice := NewServerNoticeMsgf(
+		"debug: timing: %s: first_msg=%s query=%s send=%s total=%s",
+		subID,
+		timing.TimeToFirstMsg,
+		timing.Query,
+		timing.Send,
+		timing.Total(),
+	)
+	return newClosedBufCh[ServerMsg](msg, notice), nil
+}
+
+type ModerationMiddleware Middleware
+
+func NewModerationMiddleware(moderator *Moderator) ModerationMiddleware {
+	if moderator == nil {
+		panic("moderator must be non-nil pointer")
+	}
+	m := newSimpleModerationMiddleware(moderator)
+	return ModerationMiddleware(NewSimpleMiddleware(m))
+}
+
+var _ SimpleMiddlewareInterface = (*simpleModerationMiddleware)(nil)
+
+type simpleModerationMiddleware struct {
+	moderator *Moderator
+}
+
+func newSimpleModerationMiddleware(moderator *Moderator) *simpleModerationMiddleware {
+	return &simpleModerationMiddleware{moderator: moderator}
+}
+
+func (m *simpleModerationMiddleware) HandleStart(r *http.Request) (*http.Request, error) {
+	return r, nil
+}
+
+func (m *simpleModerationMiddleware) HandleStop(r *http.Request) error {
+	return nil
+}
+
+func (m *simpleModerationMiddleware) HandleClientMsg(
+	r *http.Request,
+	msg ClientMsg,
+) (<-chan ClientMsg, <-chan ServerMsg, error) {
+	if msg, ok := msg.(*ClientEventMsg); ok {
+		if m.moderator.IsPubkeyBanned(msg.Event.Pubkey) {
+			okMsg := NewServerOKMsg(msg.Event.ID, false, ServerOkMsgPrefixBlocked, "banned pubkey")
+			return nil, newClosedBufCh[ServerMsg](okMsg), nil
+		}
+		if m.moderator.IsEventDeleted(msg.Event.ID) {
+			okMsg := NewServerOKMsg(msg.Event.ID, false, ServerOkMsgPrefixBlocked, "deleted event")
+			return nil, newClosedBufCh[ServerMsg](okMsg), nil
+		}
+	}
+
+	return newClosedBufCh(msg), nil, nil
+}
+
+func (m *simpleModerationMiddleware) HandleServerMsg(
+	r *http.Request,
+	msg ServerMsg,
+) (<-chan ServerMsg, error) {
+	if msg, ok := msg.(*ServerEventMsg); ok {
+		if m.moderator.Hidden(msg.Event) {
+			return nil, nil
+		}
+	}
+
+	return newClosedBufCh(msg), nil
+}
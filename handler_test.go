@@ -3,8 +3,10 @@ package mocrelay
 import (
 	"bufio"
 	"context"
+	"fmt"
 	"net/http"
 	"slices"
+	"strings"
 	"testing"
 	"time"
 
@@ -81,6 +83,66 @@ Loop:
 	t.Logf("error: %v", <-errCh)
 }
 
+// okErrorSimpleHandler rejects kind 1 EVENT with an *OKError and accepts the others.
+type okErrorSimpleHandler struct{}
+
+func (okErrorSimpleHandler) HandleStart(r *http.Request) (*http.Request, error) { return r, nil }
+
+func (okErrorSimpleHandler) HandleStop(r *http.Request) error { return nil }
+
+func (okErrorSimpleHandler) HandleClientMsg(
+	r *http.Request,
+	msg ClientMsg,
+) (<-chan ServerMsg, error) {
+	m := msg.(*ClientEventMsg)
+	if m.Event.Kind == 1 {
+		return nil, fmt.Errorf("wrapped: %w", NewOKError(ServerOkMsgPrefixRestricted, "no notes"))
+	}
+	return newClosedBufCh[ServerMsg](NewServerOKMsg(m.Event.ID, true, "", "")), nil
+}
+
+// okErrorSimpleMiddleware rejects kind 1 EVENT with an *OKError and passes the others.
+type okErrorSimpleMiddleware struct{ okErrorSimpleHandler }
+
+func (okErrorSimpleMiddleware) HandleClientMsg(
+	r *http.Request,
+	msg ClientMsg,
+) (<-chan ClientMsg, <-chan ServerMsg, error) {
+	if msg.(*ClientEventMsg).Event.Kind == 1 {
+		return nil, nil, NewOKError(ServerOkMsgPrefixRestricted, "no notes")
+	}
+	return newClosedBufCh(msg), nil, nil
+}
+
+func (okErrorSimpleMiddleware) HandleServerMsg(
+	r *http.Request,
+	msg ServerMsg,
+) (<-chan ServerMsg, error) {
+	return newClosedBufCh(msg), nil
+}
+
+func TestSimpleHandler_okError(t *testing.T) {
+	in := []ClientMsg{
+		&ClientEventMsg{Event: &Event{ID: "note", Kind: 1}},
+		&ClientEventMsg{Event: &Event{ID: "reaction", Kind: 7}},
+	}
+	out := []ServerMsg{
+		NewServerOKMsg("note", false, ServerOkMsgPrefixRestricted, "no notes"),
+		NewServerOKMsg("reaction", true, "", ""),
+	}
+
+	t.Run("handler", func(t *testing.T) {
+		helperTestHandler(t, NewSimpleHandler(okErrorSimpleHandler{}), in, out)
+	})
+
+	t.Run("middleware", func(t *testing.T) {
+		h := NewSimpleMiddleware(okErrorSimpleMiddleware{})(
+			NewSimpleHandler(okErrorSimpleHandler{}),
+		)
+		helperTestHandler(t, h, in, out)
+	})
+}
+
 func TestRouterHandler_Handle(t *testing.T) {
 	tests := []struct {
 		name  string
@@ -298,6 +360,60 @@ func TestRouterHandler_Handle(t *testing.T) {
 	}
 }
 
+func TestRouterHandler_Sessions(t *testing.T) {
+	router := NewRouterHandler(10)
+
+	newConn := func(ip string) (chan ClientMsg, chan ServerMsg, chan error) {
+		ctx := ctxWithTestSession(context.Background(), ip)
+		r, _ := http.NewRequestWithContext(ctx, "", "/", nil)
+
+		recv := make(chan ClientMsg)
+		send := make(chan ServerMsg, 10)
+		errCh := make(chan error, 1)
+		go func() { errCh <- router.Handle(r, recv, send) }()
+		return recv, send, errCh
+	}
+
+	recv0, send0, errCh0 := newConn("192.0.2.1")
+
+	filters := []*ReqFilter{{Kinds: []int64{1}}, {Kinds: []int64{7}}}
+	recv0 <- &ClientReqMsg{SubscriptionID: "b", ReqFilters: filters}
+	assert.Equal(t, NewServerEOSEMsg("b"), <-send0)
+	recv0 <- &ClientReqMsg{SubscriptionID: "a", ReqFilters: []*ReqFilter{{Kinds: []int64{0}}}}
+	assert.Equal(t, NewServerEOSEMsg("a"), <-send0)
+
+	recv1, send1, errCh1 := newConn("192.0.2.2")
+
+	event := &Event{ID: "id", Kind: 1}
+	recv1 <- &ClientEventMsg{Event: event}
+	assert.Equal(t, NewServerOKMsg("id", true, "", ""), <-send1)
+	assert.Equal(t, NewServerEventMsg("b", event), <-send0)
+
+	sessions := router.Sessions()
+	if assert.Len(t, sessions, 2) {
+		assert.Equal(t, "192.0.2.1", sessions[0].RealIP)
+		if assert.Len(t, sessions[0].Subscriptions, 2) {
+			assert.Equal(t, "a", sessions[0].Subscriptions[0].ID)
+			assert.Equal(t, "b", sessions[0].Subscriptions[1].ID)
+			assert.Equal(t, filters, sessions[0].Subscriptions[1].Filters)
+			assert.Equal(t, int64(1), sessions[0].Subscriptions[1].Sent)
+		}
+		assert.Equal(t, "192.0.2.2", sessions[1].RealIP)
+		assert.Empty(t, sessions[1].Subscriptions)
+	}
+
+	router.Publish(event)
+	assert.Equal(t, NewServerEventMsg("b", event), <-send0)
+
+	close(recv0)
+	assert.ErrorIs(t, <-errCh0, ErrRecvClosed)
+	assert.Len(t, router.Sessions(), 1)
+
+	close(recv1)
+	assert.ErrorIs(t, <-errCh1, ErrRecvClosed)
+	assert.Empty(t, router.Sessions())
+}
+
 func TestCacheHandler(t *testing.T) {
 	tests := []struct {
 		name  string
@@ -726,6 +842,91 @@ func TestCacheHandler(t *testing.T) {
 	}
 }
 
+func TestCacheHandler_queryTimeout(t *testing.T) {
+	h := newSimpleCacheHandler(10, &CacheHandlerOption{
+		QueryTimeout:         10 * time.Millisecond,
+		MaxConcurrentQueries: 1,
+	})
+	r, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, "/", nil)
+	collect := func(ch <-chan ServerMsg) []ServerMsg {
+		var ret []ServerMsg
+		for msg := range ch {
+			ret = append(ret, msg)
+		}
+		return ret
+	}
+
+	event := &Event{ID: "id", Pubkey: "pubkey", CreatedAt: 1, Kind: 1, Tags: []Tag{}}
+	smsgCh, err := h.HandleClientMsg(r, &ClientEventMsg{Event: event})
+	assert.NoError(t, err)
+	assert.Equal(t, NewServerOKMsg("id", true, "", ""), <-smsgCh)
+
+	req := &ClientReqMsg{SubscriptionID: "sub", ReqFilters: []*ReqFilter{{}}}
+	smsgCh, err = h.HandleClientMsg(r, req)
+	assert.NoError(t, err)
+	assert.Equal(t, []ServerMsg{
+		NewServerEventMsg("sub", event),
+		NewServerEOSEMsg("sub"),
+	}, collect(smsgCh))
+
+	// The only query slot is taken by another query.
+	h.sema <- struct{}{}
+	defer func() { <-h.sema }()
+
+	smsgCh, err = h.HandleClientMsg(r, req)
+	assert.NoError(t, err)
+	assert.Equal(t, []ServerMsg{
+		NewServerNoticeMsg("query timeout: sub: results may be partial"),
+		NewServerEOSEMsg("sub"),
+	}, collect(smsgCh))
+}
+
+func TestCacheHandler_count(t *testing.T) {
+	h := newSimpleCacheHandler(10, &CacheHandlerOption{CountCacheTTL: time.Hour})
+	r, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, "/", nil)
+
+	count := func() ServerMsg {
+		smsgCh, err := h.HandleClientMsg(r, &ClientCountMsg{
+			SubscriptionID: "cnt",
+			ReqFilters:     []*ReqFilter{{Kinds: []int64{1}}},
+		})
+		assert.NoError(t, err)
+		return <-smsgCh
+	}
+	add := func(event *Event) {
+		smsgCh, err := h.HandleClientMsg(r, &ClientEventMsg{Event: event})
+		assert.NoError(t, err)
+		<-smsgCh
+	}
+
+	add(&Event{ID: "1", Pubkey: "pubkey", CreatedAt: 1, Kind: 1, Tags: []Tag{}})
+	assert.Equal(t, NewServerCountMsg("cnt", 1, nil), count())
+
+	add(&Event{ID: "2", Pubkey: "pubkey", CreatedAt: 2, Kind: 7, Tags: []Tag{}})
+	assert.Equal(t, NewServerCountMsg("cnt", 1, nil), count())
+
+	add(&Event{ID: "3", Pubkey: "pubkey", CreatedAt: 3, Kind: 1, Tags: []Tag{}})
+	assert.Equal(t, NewServerCountMsg("cnt", 2, nil), count())
+
+	add(&Event{ID: "4", Pubkey: "pubkey", CreatedAt: 4, Kind: 5, Tags: []Tag{{"e", "1"}}})
+	assert.Equal(t, NewServerCountMsg("cnt", 1, nil), count())
+}
+
+func TestCacheHandler_expiration(t *testing.T) {
+	h := newSimpleCacheHandler(10, nil)
+	r, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, "/", nil)
+
+	event := &Event{ID: "id", Pubkey: "pubkey", Kind: 1, Tags: []Tag{{"expiration", "1"}}}
+	smsgCh, err := h.HandleClientMsg(r, &ClientEventMsg{Event: event})
+	assert.NoError(t, err)
+	assert.Equal(
+		t,
+		NewServerOKMsg("id", false, ServerOkMsgPrefixRateInvalid, "event is expired"),
+		<-smsgCh,
+	)
+	assert.Empty(t, h.c.Events())
+}
+
 func TestMergeHandler(t *testing.T) {
 	tests := []struct {
 		name  string
@@ -881,6 +1082,73 @@ func TestMergeHandler(t *testing.T) {
 	}
 }
 
+func TestMergeHandlerSessionReqState_replay(t *testing.T) {
+	const store, router = 0, 1
+	newEvent := func(id string, createdAt int64) *ServerEventMsg {
+		return NewServerEventMsg("sub", &Event{ID: id, CreatedAt: createdAt})
+	}
+	a, b, c, d := newEvent("a", 3), newEvent("b", 2), newEvent("c", 4), newEvent("d", 5)
+	now := time.Unix(0, 0)
+
+	s := newMergeHandlerSessionReqState(2)
+	s.SetSubID("sub")
+	s.SetEOSE("sub", router)
+	assert.False(t, s.AllEOSE("sub"))
+
+	assert.False(t, s.IsSendableEventMsg(router, a, now), "live events are held until EOSE")
+	assert.True(t, s.IsSendableEventMsg(store, a, now))
+	assert.True(t, s.IsSendableEventMsg(store, b, now))
+	assert.False(t, s.IsSendableEventMsg(router, c, now))
+
+	s.SetEOSE("sub", store)
+	assert.True(t, s.AllEOSE("sub"))
+	assert.Equal(t, []*ServerEventMsg{c}, s.TakePending("sub", now), "replayed a is dropped")
+
+	assert.False(t, s.IsSendableEventMsg(router, b, now), "delayed duplicate")
+	assert.True(t, s.IsSendableEventMsg(router, d, now))
+
+	later := now.Add(mergeReplayDedupeWindow + time.Second)
+	assert.True(t, s.IsSendableEventMsg(router, b, later))
+	assert.Empty(t, s.replayed)
+
+	s.SetSubID("sub")
+	s.ClearSubID("sub")
+	assert.Empty(t, s.replayed)
+	assert.Empty(t, s.pending)
+}
+
+func TestMergeHandlerSession_pendingOverflow(t *testing.T) {
+	const store, router = 0, 1
+	ss := newMergeHandlerSession(&MergeHandler{hs: make([]Handler, 2)})
+	now := time.Unix(0, 0)
+
+	s := <-ss.reqStat
+	s.SetSubID("sub")
+	s.SetEOSE("sub", router)
+	for i := 0; i < maxMergePendingEvents+2; i++ {
+		ev := NewServerEventMsg("sub", &Event{ID: fmt.Sprint(i)})
+		assert.False(t, s.IsSendableEventMsg(router, ev, now))
+	}
+	ss.reqStat <- s
+
+	msgs := ss.handleSendEOSEMsg(&mergeHandlerSessionSendMsg{
+		Idx: store,
+		Msg: NewServerEOSEMsg("sub"),
+	})
+	if assert.Len(t, msgs, maxMergePendingEvents+2) {
+		assert.Equal(t, NewServerEOSEMsg("sub"), msgs[0])
+		assert.Equal(
+			t,
+			NewServerNoticeMsg("too many live events before eose: sub: 2 events dropped"),
+			msgs[len(msgs)-1],
+		)
+	}
+
+	s = <-ss.reqStat
+	assert.Empty(t, s.overflow)
+	ss.reqStat <- s
+}
+
 func TestMaxSubscriptionsMiddleware(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -1483,3 +1751,176 @@ func TestEventCreatedAtMiddleware(t *testing.T) {
 		})
 	}
 }
+
+func TestReqTimingMiddleware(t *testing.T) {
+	event := &Event{ID: "id", Pubkey: "pubkey", Kind: 1, CreatedAt: 1}
+
+	reqEventHandler := HandlerFunc(
+		func(r *http.Request, recv <-chan ClientMsg, send chan<- ServerMsg) error {
+			ctx := r.Context()
+			for {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case msg, ok := <-recv:
+					if !ok {
+						return ErrRecvClosed
+					}
+					if msg, ok := msg.(*ClientReqMsg); ok {
+						time.Sleep(2 * time.Millisecond)
+						sendCtx(ctx, send, ServerMsg(NewServerEventMsg(msg.SubscriptionID, event)))
+						time.Sleep(2 * time.Millisecond)
+						sendCtx(ctx, send, ServerMsg(NewServerEOSEMsg(msg.SubscriptionID)))
+					}
+				}
+			}
+		},
+	)
+
+	t.Run("observe", func(t *testing.T) {
+		var timings []*ReqTiming
+		observe := func(r *http.Request, timing *ReqTiming) { timings = append(timings, timing) }
+
+		h := NewReqTimingMiddleware(observe)(reqEventHandler)
+		helperTestHandler(
+			t,
+			h,
+			[]ClientMsg{&ClientReqMsg{SubscriptionID: "sub_id", ReqFilters: []*ReqFilter{{}}}},
+			[]ServerMsg{NewServerEventMsg("sub_id", event), NewServerEOSEMsg("sub_id")},
+		)
+
+		if assert.Len(t, timings, 1) {
+			assert.Equal(t, "sub_id", timings[0].SubscriptionID)
+			assert.GreaterOrEqual(t, timings[0].TimeToFirstMsg, 2*time.Millisecond)
+			assert.GreaterOrEqual(t, timings[0].Send, 2*time.Millisecond)
+			assert.Equal(t, timings[0].TimeToFirstMsg+timings[0].Send, timings[0].Total())
+		}
+	})
+
+	t.Run("query", func(t *testing.T) {
+		store := newTestEventStore()
+		store.delay = 2 * time.Millisecond
+
+		var timings []*ReqTiming
+		observe := func(r *http.Request, timing *ReqTiming) { timings = append(timings, timing) }
+
+		h := NewReqTimingMiddleware(observe)(NewStoreHandler(store, nil))
+		helperTestHandler(
+			t,
+			h,
+			[]ClientMsg{&ClientReqMsg{SubscriptionID: "sub_id", ReqFilters: []*ReqFilter{{}}}},
+			[]ServerMsg{NewServerEOSEMsg("sub_id")},
+		)
+
+		if assert.Len(t, timings, 1) {
+			assert.GreaterOrEqual(t, timings[0].Query, 2*time.Millisecond)
+			assert.LessOrEqual(t, timings[0].Query, timings[0].TimeToFirstMsg)
+		}
+	})
+
+	t.Run("debug notice", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		r, _ := http.NewRequestWithContext(ctx, "", "/?"+ReqTimingDebugQueryKey, new(bufio.Reader))
+		recv := make(chan ClientMsg, 1)
+		send := make(chan ServerMsg, 3)
+
+		h := NewReqTimingMiddleware(nil)(reqEventHandler)
+		go h.Handle(r, recv, send)
+
+		recv <- &ClientReqMsg{SubscriptionID: "sub_id", ReqFilters: []*ReqFilter{{}}}
+
+		var gots []ServerMsg
+		for i := 0; i < 3; i++ {
+			select {
+			case <-ctx.Done():
+				t.Fatal("timeout")
+			case msg := <-send:
+				gots = append(gots, msg)
+			}
+		}
+
+		assert.IsType(t, &ServerEventMsg{}, gots[0])
+		assert.IsType(t, &ServerEOSEMsg{}, gots[1])
+		if assert.IsType(t, &ServerNoticeMsg{}, gots[2]) {
+			assert.True(
+				t,
+				strings.HasPrefix(gots[2].(*ServerNoticeMsg).Message, "debug: timing: sub_id: "),
+			)
+		}
+	})
+}
+
+func TestModerationMiddleware(t *testing.T) {
+	m := NewModerator(nil)
+	m.BanPubkey("banned", "")
+	m.DeleteEvent("deleted", "")
+
+	tests := []struct {
+		name  string
+		input []ClientMsg
+		want  []ServerMsg
+	}{
+		{
+			name: "ok",
+			input: []ClientMsg{
+				&ClientReqMsg{SubscriptionID: "sub_id", ReqFilters: []*ReqFilter{{}}},
+				&ClientEventMsg{Event: &Event{ID: "id", Pubkey: "pubkey", Kind: 1}},
+			},
+			want: []ServerMsg{
+				NewServerEOSEMsg("sub_id"),
+				NewServerEventMsg("sub_id", &Event{ID: "id", Pubkey: "pubkey", Kind: 1}),
+				NewServerOKMsg("id", true, ServerOKMsgPrefixNoPrefix, ""),
+			},
+		},
+		{
+			name: "ng: banned pubkey",
+			input: []ClientMsg{
+				&ClientEventMsg{Event: &Event{ID: "id", Pubkey: "banned", Kind: 1}},
+			},
+			want: []ServerMsg{
+				NewServerOKMsg("id", false, ServerOkMsgPrefixBlocked, "banned pubkey"),
+			},
+		},
+		{
+			name: "ng: deleted event",
+			input: []ClientMsg{
+				&ClientEventMsg{Event: &Event{ID: "deleted", Pubkey: "pubkey", Kind: 1}},
+			},
+			want: []ServerMsg{
+				NewServerOKMsg("deleted", false, ServerOkMsgPrefixBlocked, "deleted event"),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var h Handler
+			h = NewRouterHandler(100)
+			h = NewModerationMiddleware(m)(h)
+			helperTestHandler(t, h, tt.input, tt.want)
+		})
+	}
+}
+
+func TestModerationMiddleware_purged(t *testing.T) {
+	deleted := &Event{ID: "deleted", Pubkey: "pubkey", Kind: 1}
+	cache := NewCacheHandler(10)
+	cache.c.c.Add(deleted)
+
+	m := NewModerator(&ModeratorOption{UndoWindow: time.Millisecond})
+	m.BanPubkey("banned", "")
+	m.DeleteEvent(deleted.ID, "")
+	time.Sleep(2 * time.Millisecond)
+	m.Purge()
+
+	h := NewModerationMiddleware(m)(cache)
+	helperTestHandler(t, h, []ClientMsg{
+		&ClientReqMsg{SubscriptionID: "sub_id", ReqFilters: []*ReqFilter{{}}},
+		&ClientEventMsg{Event: &Event{ID: "id", Pubkey: "banned", Kind: 1}},
+	}, []ServerMsg{
+		NewServerEOSEMsg("sub_id"),
+		NewServerOKMsg("id", false, ServerOkMsgPrefixBlocked, "banned pubkey"),
+	})
+}
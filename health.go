@@ -0,0 +1,185 @@
+package mocrelay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"runtime/debug"
+	"time"
+)
+
+var (
+	ErrHealthShuttingDown    = errors.New("relay is shutting down")
+	ErrHealthQueueSaturated  = errors.New("verifier queue is saturated")
+	ErrHealthVerifierStopped = errors.New("verifier is stopped")
+)
+
+// Version is reported by /version. It can be set with -ldflags "-X".
+// If empty, the main module version from the build info is used.
+var Version = ""
+
+// HealthCheck reports an error if a dependency (e.g. storage) is not ready.
+type HealthCheck func(ctx context.Context) error
+
+// HealthHandler serves /healthz, /readyz and /version.
+// Mount it on each path next to the relay handler.
+type HealthHandler struct {
+	Relay    *Relay
+	Verifier *Verifier
+
+	// VerifierSaturation is the ratio of the verifier queue depth to its size
+	// at which the relay is reported as not ready. Default is 0.9.
+	VerifierSaturation float64
+
+	// Checks are extra readiness checks keyed by name.
+	Checks map[string]HealthCheck
+
+	// Timeout is the timeout for all checks. Default is 5s.
+	Timeout time.Duration
+}
+
+type HealthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+type VersionResponse struct {
+	Version   string `json:"version"`
+	GoVersion string `json:"go_version"`
+	Revision  string `json:"revision,omitempty"`
+	Time      string `json:"time,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+}
+
+func (h *HealthHandler) verifierSaturation() float64 {
+	if h.VerifierSaturation <= 0 {
+		return 0.9
+	}
+	return h.VerifierSaturation
+}
+
+func (h *HealthHandler) timeout() time.Duration {
+	if h.Timeout <= 0 {
+		return 5 * time.Second
+	}
+	return h.Timeout
+}
+
+func (h *HealthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch path.Base(r.URL.Path) {
+	case "healthz":
+		h.serveHealthz(w, r)
+	case "readyz":
+		h.serveReadyz(w, r)
+	case "version":
+		writeHealthJSON(w, http.StatusOK, buildVersion())
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveHealthz reports liveness. Dependencies are not checked here so that
+// a broken storage does not make the process restart.
+func (h *HealthHandler) serveHealthz(w http.ResponseWriter, r *http.Request) {
+	writeHealthJSON(w, http.StatusOK, &HealthResponse{Status: "ok"})
+}
+
+func (h *HealthHandler) serveReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), h.timeout())
+	defer cancel()
+
+	errs := h.check(ctx)
+
+	resp := &HealthResponse{Status: "ok", Checks: make(map[string]string, len(errs))}
+	status := http.StatusOK
+	for name, err := range errs {
+		if err != nil {
+			resp.Checks[name] = err.Error()
+			resp.Status = "unavailable"
+			status = http.StatusServiceUnavailable
+		} else {
+			resp.Checks[name] = "ok"
+		}
+	}
+
+	writeHealthJSON(w, status, resp)
+}
+
+func (h *HealthHandler) check(ctx context.Context) map[string]error {
+	ret := make(map[string]error)
+
+	if h.Relay != nil {
+		ret["shutdown"] = nil
+		if h.Relay.shuttingDown() {
+			ret["shutdown"] = ErrHealthShuttingDown
+		}
+	}
+
+	if h.Verifier != nil {
+		ret["verifier"] = h.checkVerifier()
+	}
+
+	for name, check := range h.Checks {
+		ret[name] = check(ctx)
+	}
+
+	return ret
+}
+
+func (h *HealthHandler) checkVerifier() error {
+	v := h.Verifier
+
+	v.mu.Lock()
+	stopped := v.stopped
+	v.mu.Unlock()
+	if stopped {
+		return ErrHealthVerifierStopped
+	}
+
+	depth, size := v.QueueDepth(), v.QueueSize()
+	if float64(depth) >= float64(size)*h.verifierSaturation() {
+		return fmt.Errorf("%w: %d/%d", ErrHealthQueueSaturated, depth, size)
+	}
+	return nil
+}
+
+func buildVersion() *VersionResponse {
+	ret := &VersionResponse{Version: Version}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ret
+	}
+
+	ret.GoVersion = info.GoVersion
+	if ret.Version == "" {
+		ret.Version = info.Main.Version
+	}
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			ret.Revision = s.Value
+		case "vcs.time":
+			ret.Time = s.Value
+		case "vcs.modified":
+			ret.Modified = s.Value == "true"
+		}
+	}
+
+	return ret
+}
+
+func writeHealthJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
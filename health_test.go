@@ -0,0 +1,146 @@
+package mocrelay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthHandler(t *testing.T) {
+	storeErr := errors.New("connection refused")
+
+	tests := []struct {
+		name       string
+		handler    func(t *testing.T) *HealthHandler
+		path       string
+		wantStatus int
+		wantResp   *HealthResponse
+	}{
+		{
+			name: "healthz",
+			handler: func(t *testing.T) *HealthHandler {
+				return &HealthHandler{
+					Checks: map[string]HealthCheck{
+						"store": func(ctx context.Context) error { return storeErr },
+					},
+				}
+			},
+			path:       "/healthz",
+			wantStatus: http.StatusOK,
+			wantResp:   &HealthResponse{Status: "ok"},
+		},
+		{
+			name: "readyz ok",
+			handler: func(t *testing.T) *HealthHandler {
+				return &HealthHandler{
+					Relay:    NewRelay(NewRouterHandler(10), nil),
+					Verifier: newTestVerifierWithoutWorkers(10),
+					Checks: map[string]HealthCheck{
+						"store": func(ctx context.Context) error { return nil },
+					},
+				}
+			},
+			path:       "/readyz",
+			wantStatus: http.StatusOK,
+			wantResp: &HealthResponse{
+				Status: "ok",
+				Checks: map[string]string{"shutdown": "ok", "verifier": "ok", "store": "ok"},
+			},
+		},
+		{
+			name: "readyz store error",
+			handler: func(t *testing.T) *HealthHandler {
+				return &HealthHandler{
+					Checks: map[string]HealthCheck{
+						"store": func(ctx context.Context) error { return storeErr },
+					},
+				}
+			},
+			path:       "/readyz",
+			wantStatus: http.StatusServiceUnavailable,
+			wantResp: &HealthResponse{
+				Status: "unavailable",
+				Checks: map[string]string{"store": "connection refused"},
+			},
+		},
+		{
+			name: "readyz shutting down",
+			handler: func(t *testing.T) *HealthHandler {
+				relay := NewRelay(NewRouterHandler(10), nil)
+				require.NoError(t, relay.Shutdown(context.Background()))
+				return &HealthHandler{Relay: relay}
+			},
+			path:       "/readyz",
+			wantStatus: http.StatusServiceUnavailable,
+			wantResp: &HealthResponse{
+				Status: "unavailable",
+				Checks: map[string]string{"shutdown": ErrHealthShuttingDown.Error()},
+			},
+		},
+		{
+			name: "readyz verifier saturated",
+			handler: func(t *testing.T) *HealthHandler {
+				v := newTestVerifierWithoutWorkers(2)
+				for i := 0; i < 2; i++ {
+					require.NoError(
+						t,
+						v.enqueue("key", &verifyJob{ret: make(chan verifyResult, 1)}),
+					)
+				}
+				return &HealthHandler{Verifier: v, VerifierSaturation: 1}
+			},
+			path:       "/readyz",
+			wantStatus: http.StatusServiceUnavailable,
+			wantResp: &HealthResponse{
+				Status: "unavailable",
+				Checks: map[string]string{"verifier": "verifier queue is saturated: 2/2"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			w := httptest.NewRecorder()
+			tt.handler(t).ServeHTTP(w, r)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+			var resp HealthResponse
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+			assert.Equal(t, tt.wantResp, &resp)
+		})
+	}
+}
+
+func TestHealthHandler_version(t *testing.T) {
+	old := Version
+	Version = "v1.2.3"
+	t.Cleanup(func() { Version = old })
+
+	r := httptest.NewRequest(http.MethodGet, "/version", nil)
+	w := httptest.NewRecorder()
+	new(HealthHandler).ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var resp VersionResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	assert.Equal(t, "v1.2.3", resp.Version)
+	assert.NotEmpty(t, resp.GoVersion)
+}
+
+func TestHealthHandler_methodNotAllowed(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/healthz", nil)
+	w := httptest.NewRecorder()
+	new(HealthHandler).ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
@@ -0,0 +1,89 @@
+package mocrelay
+
+import "sync"
+
+// internMaxLen is the max length of strings to intern.
+// Longer strings are rarely repeated.
+const internMaxLen = 256
+
+// Interner deduplicates strings repeated across events such as pubkeys, tag names
+// and relay hints, so that the events kept in memory share one copy of each of them.
+// It is safe for concurrent use. A nil Interner interns nothing.
+type Interner struct {
+	mu   sync.Mutex
+	strs map[string]string
+	max  int
+}
+
+// NewInterner returns an Interner holding up to maxStrings strings.
+// It forgets all the strings when it is full. The default is 65536.
+func NewInterner(maxStrings int) *Interner {
+	if maxStrings <= 0 {
+		maxStrings = 65536
+	}
+	return &Interner{
+		strs: make(map[string]string),
+		max:  maxStrings,
+	}
+}
+
+// String returns the string equal to s which the Interner already holds, or holds s.
+func (in *Interner) String(s string) string {
+	if in == nil || s == "" || len(s) > internMaxLen {
+		return s
+	}
+
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	if ret, ok := in.strs[s]; ok {
+		return ret
+	}
+	if len(in.strs) >= in.max {
+		clear(in.strs)
+	}
+	in.strs[s] = s
+	return s
+}
+
+// Event interns the pubkey, tag names, pubkeys of "p" tags and relay hints of event
+// in place. event must not be shared with other goroutines yet.
+func (in *Interner) Event(event *Event) {
+	if in == nil || event == nil {
+		return
+	}
+
+	event.Pubkey = in.String(event.Pubkey)
+	for _, tag := range event.Tags {
+		if len(tag) == 0 {
+			continue
+		}
+		tag[0] = in.String(tag[0])
+		switch tag[0] {
+		case "p":
+			if len(tag) > 1 {
+				tag[1] = in.String(tag[1])
+			}
+			fallthrough
+		case "e", "a", "q":
+			if len(tag) > 2 {
+				tag[2] = in.String(tag[2])
+			}
+		case "r", "relay":
+			if len(tag) > 1 {
+				tag[1] = in.String(tag[1])
+			}
+		}
+	}
+}
+
+// Len returns the number of strings held.
+func (in *Interner) Len() int {
+	if in == nil {
+		return 0
+	}
+
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return len(in.strs)
+}
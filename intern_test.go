@@ -0,0 +1,68 @@
+package mocrelay
+
+import (
+	"strings"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func sameString(a, b string) bool {
+	return a == b && unsafe.StringData(a) == unsafe.StringData(b)
+}
+
+func TestInterner_String(t *testing.T) {
+	in := NewInterner(2)
+
+	a := in.String(strings.Repeat("a", 64))
+	assert.True(t, sameString(a, in.String(strings.Repeat("a", 64))))
+	assert.Equal(t, 1, in.Len())
+
+	in.String("b")
+	assert.Equal(t, 2, in.Len())
+
+	// Full
+	in.String("c")
+	assert.Equal(t, 1, in.Len())
+	assert.False(t, sameString(a, in.String(strings.Repeat("a", 64))))
+
+	long := strings.Repeat("x", internMaxLen+1)
+	assert.Equal(t, long, in.String(long))
+	assert.Equal(t, 2, in.Len())
+}
+
+func TestInterner_Event(t *testing.T) {
+	pubkey := strings.Repeat("a", 64)
+	relay := "wss://relay.example.com"
+	newEvent := func() *Event {
+		return &Event{
+			Pubkey: strings.Clone(pubkey),
+			Tags: Tags{
+				{"p", strings.Clone(pubkey), strings.Clone(relay)},
+				{"e", "id", strings.Clone(relay)},
+				{"t", "nostr"},
+				{},
+			},
+		}
+	}
+
+	in := NewInterner(0)
+	a, b := newEvent(), newEvent()
+	in.Event(a)
+	in.Event(b)
+
+	assert.Equal(t, newEvent(), b)
+	assert.True(t, sameString(a.Pubkey, b.Pubkey))
+	assert.True(t, sameString(a.Pubkey, b.Tags[0][1]))
+	assert.True(t, sameString(a.Tags[0][2], b.Tags[1][2]))
+	assert.True(t, sameString(a.Tags[2][0], b.Tags[2][0]))
+}
+
+func TestInterner_nil(t *testing.T) {
+	var in *Interner
+	ev := &Event{Pubkey: "a"}
+	in.Event(ev)
+	assert.Equal(t, "a", in.String("a"))
+	assert.Equal(t, 0, in.Len())
+}
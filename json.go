@@ -0,0 +1,141 @@
+package mocrelay
+
+import (
+	"strconv"
+	"unicode/utf8"
+)
+
+const jsonHex = "0123456789abcdef"
+
+func jsonSafeByte(b byte) bool {
+	return b >= 0x20 && b != '"' && b != '\\' && b != '<' && b != '>' && b != '&'
+}
+
+// appendJSONString appends s as a JSON string in the same form as encoding/json.
+func appendJSONString(dst []byte, s string) []byte {
+	dst = append(dst, '"')
+
+	start := 0
+	for i := 0; i < len(s); {
+		if b := s[i]; b < utf8.RuneSelf {
+			if jsonSafeByte(b) {
+				i++
+				continue
+			}
+
+			dst = append(dst, s[start:i]...)
+			switch b {
+			case '\\', '"':
+				dst = append(dst, '\\', b)
+			case '\b':
+				dst = append(dst, '\\', 'b')
+			case '\f':
+				dst = append(dst, '\\', 'f')
+			case '\n':
+				dst = append(dst, '\\', 'n')
+			case '\r':
+				dst = append(dst, '\\', 'r')
+			case '\t':
+				dst = append(dst, '\\', 't')
+			default:
+				dst = append(dst, '\\', 'u', '0', '0', jsonHex[b>>4], jsonHex[b&0xf])
+			}
+			i++
+			start = i
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			dst = append(dst, s[start:i]...)
+			dst = append(dst, `\ufffd`...)
+			i += size
+			start = i
+			continue
+		}
+		if r == '\u2028' || r == '\u2029' {
+			dst = append(dst, s[start:i]...)
+			dst = append(dst, '\\', 'u', '2', '0', '2', jsonHex[r&0xf])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+
+	dst = append(dst, s[start:]...)
+	return append(dst, '"')
+}
+
+// appendCanonicalJSONString appends s as a JSON string in the NIP-01 canonical form,
+// which escapes only \n, \", \\, \r, \t, \b and \f and keeps other bytes verbatim.
+func appendCanonicalJSONString(dst []byte, s string) []byte {
+	dst = append(dst, '"')
+
+	start := 0
+	for i := 0; i < len(s); i++ {
+		var esc byte
+		switch s[i] {
+		case '\n':
+			esc = 'n'
+		case '"':
+			esc = '"'
+		case '\\':
+			esc = '\\'
+		case '\r':
+			esc = 'r'
+		case '\t':
+			esc = 't'
+		case '\b':
+			esc = 'b'
+		case '\f':
+			esc = 'f'
+		default:
+			continue
+		}
+		dst = append(dst, s[start:i]...)
+		dst = append(dst, '\\', esc)
+		start = i + 1
+	}
+
+	dst = append(dst, s[start:]...)
+	return append(dst, '"')
+}
+
+func appendJSONStrings(dst []byte, ss []string) []byte {
+	if ss == nil {
+		return append(dst, "null"...)
+	}
+
+	dst = append(dst, '[')
+	for i, s := range ss {
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		dst = appendJSONString(dst, s)
+	}
+	return append(dst, ']')
+}
+
+func appendJSONTags(dst []byte, tags []Tag) []byte {
+	if tags == nil {
+		return append(dst, "null"...)
+	}
+
+	dst = append(dst, '[')
+	for i, tag := range tags {
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		dst = appendJSONStrings(dst, tag)
+	}
+	return append(dst, ']')
+}
+
+func appendJSONInt(dst []byte, v int64) []byte {
+	return strconv.AppendInt(dst, v, 10)
+}
+
+func appendJSONBool(dst []byte, v bool) []byte {
+	return strconv.AppendBool(dst, v)
+}
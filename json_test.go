@@ -0,0 +1,122 @@
+package mocrelay
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAppendJSONString(t *testing.T) {
+	tests := []string{
+		"",
+		"powa",
+		"ぽわ〜",
+		"\"quoted\" \\backslash\\",
+		"\b\f\n\r\t",
+		"\x00\x01\x1f\x7f",
+		"<script>&</script>",
+		"  ",
+		"emoji 🍣",
+	}
+
+	for _, tt := range tests {
+		t.Run(tt, func(t *testing.T) {
+			want, err := json.Marshal(tt)
+			assert.NoError(t, err)
+			assert.Equal(t, string(want), string(appendJSONString(nil, tt)))
+		})
+	}
+
+	t.Run("invalid utf-8", func(t *testing.T) {
+		var got string
+		assert.NoError(t, json.Unmarshal(appendJSONString(nil, "invalid \xff utf-8"), &got))
+		assert.Equal(t, "invalid \ufffd utf-8", got)
+	})
+}
+
+func TestServerMsg_AppendJSON(t *testing.T) {
+	event := &Event{
+		ID:        "49d58222bd85ddabfc19b8052d35bcce2bad8f1f3030c0bc7dc9f10dba82a8a2",
+		Pubkey:    "dbf0becf24bf8dd7d779d7fb547e6112964ff042b77a42cc2d8488636eed9f5e",
+		CreatedAt: 1693157791,
+		Kind:      1,
+		Tags: []Tag{
+			{"e", "d2ea747b6e3a35d2a8b759857b73fcaba5e9f3cfb6f38d317e034bddc0bf0d1c"},
+			nil,
+		},
+		Content: "<powa>\n",
+		Sig:     "795e51656e8b863805c41b3a6e1195ed63bf8c5df1fc3a4078cd45aaf0d8838f2dc57b802819443364e8e38c0f35c97e409181680bfff83e58949500f5a8f0c8",
+	}
+
+	tests := []struct {
+		name string
+		in   ServerMsg
+		want any
+	}{
+		{
+			name: "eose",
+			in:   NewServerEOSEMsg("sub_id"),
+			want: []any{"EOSE", "sub_id"},
+		},
+		{
+			name: "event",
+			in:   NewServerEventMsg("sub_id", event),
+			want: []any{"EVENT", "sub_id", event},
+		},
+		{
+			name: "event: nil event",
+			in:   NewServerEventMsg("sub_id", nil),
+			want: []any{"EVENT", "sub_id", nil},
+		},
+		{
+			name: "notice",
+			in:   NewServerNoticeMsg("\"notice\""),
+			want: []any{"NOTICE", "\"notice\""},
+		},
+		{
+			name: "ok",
+			in:   NewServerOKMsg("id", false, ServerOkMsgPrefixBlocked, "you <are> blocked"),
+			want: []any{"OK", "id", false, "blocked: you <are> blocked"},
+		},
+		{
+			name: "count",
+			in:   NewServerCountMsg("sub_id", 3, toPtr(true)),
+			want: []any{"COUNT", "sub_id", struct {
+				Count       uint64 `json:"count"`
+				Approximate bool   `json:"approximate"`
+			}{3, true}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want, err := json.Marshal(tt.want)
+			assert.NoError(t, err)
+
+			prefix := []byte("prefix")
+			got, err := appendServerMsgJSON(prefix, tt.in)
+			assert.NoError(t, err)
+			assert.Equal(t, "prefix"+string(want), string(got))
+		})
+	}
+}
+
+func BenchmarkServerMsg_AppendJSON_Event(b *testing.B) {
+	msg := NewServerEventMsg("sub_id", &Event{
+		ID:        "49d58222bd85ddabfc19b8052d35bcce2bad8f1f3030c0bc7dc9f10dba82a8a2",
+		Pubkey:    "dbf0becf24bf8dd7d779d7fb547e6112964ff042b77a42cc2d8488636eed9f5e",
+		CreatedAt: 1693157791,
+		Kind:      1,
+		Tags:      []Tag{{"e", "d2ea747b6e3a35d2a8b759857b73fcaba5e9f3cfb6f38d317e034bddc0bf0d1c"}},
+		Content:   "powa",
+		Sig:       "795e51656e8b863805c41b3a6e1195ed63bf8c5df1fc3a4078cd45aaf0d8838f2dc57b802819443364e8e38c0f35c97e409181680bfff83e58949500f5a8f0c8",
+	})
+
+	var buf []byte
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf, _ = msg.AppendJSON(buf[:0])
+	}
+}
@@ -0,0 +1,151 @@
+package mocrelay
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+)
+
+const RelayStatusEventKind = 30166
+
+var ErrInvalidSecretKey = errors.New("invalid secret key")
+
+// Keypair is a nostr keypair such as the identity of the relay.
+type Keypair struct {
+	priv   *btcec.PrivateKey
+	pubkey string
+}
+
+// ParseKeypair parses a secret key in hex or nsec.
+func ParseKeypair(s string) (*Keypair, error) {
+	s = strings.TrimSpace(s)
+
+	if strings.HasPrefix(s, NIP19PrefixNsec+"1") {
+		prefix, v, err := DecodeNIP19(s)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidSecretKey, err)
+		}
+		if prefix != NIP19PrefixNsec {
+			return nil, fmt.Errorf("%w: not nsec", ErrInvalidSecretKey)
+		}
+		s = v.(string)
+	}
+
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != 32 {
+		return nil, fmt.Errorf("%w: must be 32 bytes hex or nsec", ErrInvalidSecretKey)
+	}
+
+	priv, pub := btcec.PrivKeyFromBytes(b)
+	if priv.Key.IsZero() {
+		return nil, fmt.Errorf("%w: zero key", ErrInvalidSecretKey)
+	}
+
+	return &Keypair{
+		priv:   priv,
+		pubkey: hex.EncodeToString(schnorr.SerializePubKey(pub)),
+	}, nil
+}
+
+// LoadKeypairFile reads a secret key in hex or nsec from the file at path.
+func LoadKeypairFile(path string) (*Keypair, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret key: %w", err)
+	}
+	return ParseKeypair(string(b))
+}
+
+func (k *Keypair) Pubkey() string { return k.pubkey }
+
+// Sign sets the pubkey, id and sig of event.
+func (k *Keypair) Sign(event *Event) error {
+	event.Pubkey = k.pubkey
+	event.raw = nil
+
+	serialized, err := event.Serialize()
+	if err != nil {
+		return fmt.Errorf("failed to serialize event: %w", err)
+	}
+	id := sha256.Sum256(serialized)
+	event.ID = hex.EncodeToString(id[:])
+
+	sig, err := schnorr.Sign(k.priv, id[:])
+	if err != nil {
+		return fmt.Errorf("failed to sign event: %w", err)
+	}
+	event.Sig = hex.EncodeToString(sig.Serialize())
+
+	return nil
+}
+
+func (k *Keypair) newEvent(kind int64, tags []Tag, content string) (*Event, error) {
+	event := &Event{
+		CreatedAt: time.Now().Unix(),
+		Kind:      kind,
+		Tags:      tags,
+		Content:   content,
+	}
+	if err := k.Sign(event); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// NewAuthMsg returns an AUTH message with a kind 22242 event for the challenge.
+func (k *Keypair) NewAuthMsg(relayURL, challenge string) (*ServerAuthMsg, error) {
+	event, err := k.newEvent(
+		AuthEventKind,
+		[]Tag{{"relay", relayURL}, {"challenge", challenge}},
+		"",
+	)
+	if err != nil {
+		return nil, err
+	}
+	return NewServerAuthMsg(event)
+}
+
+// NewStatusEvent returns a NIP-66 relay discovery event of the relay at relayURL.
+func (k *Keypair) NewStatusEvent(relayURL string, nip11 *NIP11) (*Event, error) {
+	tags := []Tag{{"d", relayURL}}
+	var content string
+
+	if nip11 != nil {
+		for _, nip := range nip11.SupportedNIPs {
+			tags = append(tags, Tag{"N", fmt.Sprint(nip)})
+		}
+		if l := nip11.Limitation; l != nil {
+			if l.AuthRequired {
+				tags = append(tags, Tag{"R", "auth"})
+			} else {
+				tags = append(tags, Tag{"R", "!auth"})
+			}
+			if l.PaymentRequired {
+				tags = append(tags, Tag{"R", "payment"})
+			} else {
+				tags = append(tags, Tag{"R", "!payment"})
+			}
+		}
+
+		b, err := json.Marshal(nip11)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal nip11: %w", err)
+		}
+		content = string(b)
+	}
+
+	return k.newEvent(RelayStatusEventKind, tags, content)
+}
+
+// NewNoticeEvent returns a kind 1 note from the relay used to broadcast notices.
+func (k *Keypair) NewNoticeEvent(content string) (*Event, error) {
+	return k.newEvent(1, []Tag{}, content)
+}
@@ -0,0 +1,107 @@
+package mocrelay
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseKeypair(t *testing.T) {
+	const (
+		seckey = "67dea2ed018072d675f5415ecfaed7d2597555e202d85b3d65ea4e58d2d92ffa"
+		pubkey = "7e7e9c42a91bfef19fa929e5fda1b72e0ebc1a4c1141673e2794234d86addf4e"
+		nsec   = "nsec1vl029mgpspedva04g90vltkh6fvh240zqtv9k0t9af8935ke9laqsnlfe5"
+	)
+
+	tests := []struct {
+		name    string
+		input   string
+		wantErr bool
+	}{
+		{"hex", seckey, false},
+		{"nsec", nsec, false},
+		{"trailing newline", seckey + "\n", false},
+		{"npub", "npub10elfcs4fr0l0r8af98jlmgdh9c8tcxjvz9qkw038js35mp4dma8qzvjptg", true},
+		{"short hex", seckey[:62], true},
+		{"zero", strings.Repeat("0", 64), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, err := ParseKeypair(tt.input)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidSecretKey)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, pubkey, k.Pubkey())
+		})
+	}
+}
+
+func TestLoadKeypairFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key")
+	require.NoError(t, os.WriteFile(path, []byte(strings.Repeat("01", 32)+"\n"), 0o600))
+
+	k, err := LoadKeypairFile(path)
+	require.NoError(t, err)
+	assert.Len(t, k.Pubkey(), 64)
+
+	_, err = LoadKeypairFile(filepath.Join(t.TempDir(), "none"))
+	assert.Error(t, err)
+}
+
+func TestKeypair(t *testing.T) {
+	k, err := ParseKeypair(strings.Repeat("01", 32))
+	require.NoError(t, err)
+
+	t.Run("auth", func(t *testing.T) {
+		msg, err := k.NewAuthMsg("wss://relay.example.com", "challenge")
+		require.NoError(t, err)
+		assert.NoError(t, ValidateAuthEvent(msg.Event, "wss://relay.example.com", "challenge"))
+		assert.Equal(t, k.Pubkey(), msg.Event.Pubkey)
+	})
+
+	t.Run("status", func(t *testing.T) {
+		nip11 := &NIP11{
+			Name:          "mocrelay",
+			SupportedNIPs: []int{1, 11},
+			Limitation:    &NIP11Limitation{PaymentRequired: true},
+		}
+		event, err := k.NewStatusEvent("wss://relay.example.com", nip11)
+		require.NoError(t, err)
+
+		ok, err := event.Verify()
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, int64(RelayStatusEventKind), event.Kind)
+		assert.Equal(t, Tags{
+			{"d", "wss://relay.example.com"},
+			{"N", "1"},
+			{"N", "11"},
+			{"R", "!auth"},
+			{"R", "payment"},
+		}, event.Tags)
+
+		var got NIP11
+		require.NoError(t, json.Unmarshal([]byte(event.Content), &got))
+		assert.Equal(t, nip11, &got)
+	})
+
+	t.Run("notice", func(t *testing.T) {
+		event, err := k.NewNoticeEvent("maintenance")
+		require.NoError(t, err)
+
+		ok, err := event.Verify()
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, "maintenance", event.Content)
+		assert.WithinDuration(t, time.Now(), event.CreatedAtTime(), time.Minute)
+	})
+}
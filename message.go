@@ -9,11 +9,9 @@ import (
 	"fmt"
 	"reflect"
 	"regexp"
+	"slices"
 	"strconv"
-	"strings"
 	"time"
-
-	"github.com/btcsuite/btcd/btcec/v2/schnorr"
 )
 
 var ErrInvalidClientMsg = errors.New("invalid client message")
@@ -79,7 +77,54 @@ func ParseClientMsg(b []byte) (msg ClientMsg, err error) {
 	}
 }
 
+type CheckClientMsgOption struct {
+	ContentPolicy *ContentPolicy
+	// IDMatchMode allows the prefixes of ids and authors in filters with IDMatchPrefix.
+	IDMatchMode IDMatchMode
+	// TagNamePattern matches the tag names (without "#") allowed in filters.
+	// The default is StrictTagNamePattern.
+	TagNamePattern *regexp.Regexp
+	// FilterLimits caps the number of values of REQ and COUNT filters.
+	// Violations are returned as *ReqFilterLimitError.
+	FilterLimits *ReqFilterLimits
+}
+
+func (opt *CheckClientMsgOption) idMatchMode() IDMatchMode {
+	if opt == nil {
+		return IDMatchExact
+	}
+	return opt.IDMatchMode
+}
+
+func (opt *CheckClientMsgOption) filterLimits() *ReqFilterLimits {
+	if opt == nil {
+		return nil
+	}
+	return opt.FilterLimits
+}
+
+func (opt *CheckClientMsgOption) tagNamePattern() *regexp.Regexp {
+	if opt == nil || opt.TagNamePattern == nil {
+		return StrictTagNamePattern
+	}
+	return opt.TagNamePattern
+}
+
+func (opt *CheckClientMsgOption) contentPolicy() *ContentPolicy {
+	if opt == nil {
+		return nil
+	}
+	return opt.ContentPolicy
+}
+
 func CheckClientMsg(msg ClientMsg) (bool, error) {
+	return CheckClientMsgWithOption(msg, nil)
+}
+
+// CheckClientMsgWithOption is the same as CheckClientMsg but also applies the options.
+// Policy violations are returned as errors wrapping ErrContentPolicy,
+// and filters with too many values as *ReqFilterLimitError.
+func CheckClientMsgWithOption(msg ClientMsg, option *CheckClientMsgOption) (bool, error) {
 	if msg == nil {
 		return false, nil
 	}
@@ -89,6 +134,9 @@ func CheckClientMsg(msg ClientMsg) (bool, error) {
 		if !msg.Valid() {
 			return false, nil
 		}
+		if err := option.contentPolicy().Check(msg.Event); err != nil {
+			return false, err
+		}
 		ok, err := msg.Event.Verify()
 		if err != nil {
 			return false, fmt.Errorf("failed to verify event: %w", err)
@@ -96,7 +144,10 @@ func CheckClientMsg(msg ClientMsg) (bool, error) {
 		return ok, nil
 
 	case *ClientReqMsg:
-		return msg.Valid(), nil
+		if !msg.validWithOption(option) {
+			return false, nil
+		}
+		return checkFilterLimits(msg.ReqFilters, option.filterLimits())
 
 	case *ClientCloseMsg:
 		return msg.Valid(), nil
@@ -105,13 +156,25 @@ func CheckClientMsg(msg ClientMsg) (bool, error) {
 		return msg.Valid(), nil
 
 	case *ClientCountMsg:
-		return msg.Valid(), nil
+		if !msg.validWithOption(option) {
+			return false, nil
+		}
+		return checkFilterLimits(msg.ReqFilters, option.filterLimits())
 
 	default:
 		return false, nil
 	}
 }
 
+func checkFilterLimits(filters []*ReqFilter, limits *ReqFilterLimits) (bool, error) {
+	for _, f := range filters {
+		if err := limits.Check(f); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
 var _ ClientMsg = (*ClientUnknownMsg)(nil)
 
 type ClientUnknownMsg struct {
@@ -162,10 +225,14 @@ func (msg *ClientEventMsg) UnmarshalJSON(b []byte) error {
 		return nil
 	}
 
-	var elems []json.RawMessage
+	pooled := getRawMsgs()
+	defer putRawMsgs(pooled)
+
+	elems := *pooled
 	if err := json.Unmarshal(b, &elems); err != nil {
 		return fmt.Errorf("not a json array: %w", err)
 	}
+	*pooled = elems
 	if len(elems) != 2 {
 		return fmt.Errorf("client event msg length must be 3 but got %d", len(elems))
 	}
@@ -201,46 +268,41 @@ type ClientReqMsg struct {
 
 func (*ClientReqMsg) ClientMsg() {}
 
+var ErrInvalidClientReqMsg = errors.New("invalid client req msg")
+
 func (msg *ClientReqMsg) UnmarshalJSON(b []byte) error {
 	if bytes.Equal(b, []byte("null")) {
 		return nil
 	}
 
-	var elems []json.RawMessage
-	if err := json.Unmarshal(b, &elems); err != nil {
-		return fmt.Errorf("not a json array: %w", err)
-	}
-	if len(elems) < 3 {
-		return fmt.Errorf("client req msg length must be 3 or more but got %d", len(elems))
+	subID, filters, err := unmarshalClientFiltersMsg(b, "REQ")
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidClientReqMsg, err)
 	}
+	*msg = ClientReqMsg{SubscriptionID: subID, ReqFilters: filters}
 
-	var label string
-	if err := json.Unmarshal(elems[0], &label); err != nil {
-		return fmt.Errorf("label is not a json string: %w", err)
-	}
-	if label != "REQ" {
-		return fmt.Errorf(`client req msg labes must be "REQ" but got %q`, label)
-	}
+	return nil
+}
 
-	var ret ClientReqMsg
+var ErrMarshalClientReqMsg = errors.New("failed to marshal client req msg")
 
-	if err := json.Unmarshal(elems[1], &ret.SubscriptionID); err != nil {
-		return fmt.Errorf("subscription id is not a json string: %w", err)
-	}
+func (msg *ClientReqMsg) MarshalJSON() ([]byte, error) {
+	return msg.AppendJSON(nil)
+}
 
-	ret.ReqFilters = make([]*ReqFilter, len(elems)-2)
-	for i := 0; i < len(elems)-2; i++ {
-		if err := json.Unmarshal(elems[i+2], &ret.ReqFilters[i]); err != nil {
-			return fmt.Errorf("failed to unmarshal filter: %w", err)
-		}
+// AppendJSON appends the message so that it can be forwarded to another relay.
+func (msg *ClientReqMsg) AppendJSON(dst []byte) ([]byte, error) {
+	if msg == nil {
+		return nil, ErrMarshalClientReqMsg
 	}
-
-	*msg = ret
-
-	return nil
+	return appendClientFiltersMsgJSON(dst, "REQ", msg.SubscriptionID, msg.ReqFilters)
 }
 
 func (msg *ClientReqMsg) Valid() (ok bool) {
+	return msg.validWithOption(nil)
+}
+
+func (msg *ClientReqMsg) validWithOption(option *CheckClientMsgOption) (ok bool) {
 	if msg == nil {
 		return
 	}
@@ -249,7 +311,10 @@ func (msg *ClientReqMsg) Valid() (ok bool) {
 		return
 	}
 
-	if !sliceAllFunc(msg.ReqFilters, func(f *ReqFilter) bool { return f.Valid() }) {
+	if !sliceAllFunc(
+		msg.ReqFilters,
+		func(f *ReqFilter) bool { return f.validWithOption(option) },
+	) {
 		return
 	}
 
@@ -291,8 +356,11 @@ func (msg *ClientCloseMsg) Valid() bool { return msg != nil }
 
 var _ ClientMsg = (*ClientAuthMsg)(nil)
 
+// ClientAuthMsg is an AUTH message from a client. Event is the signed kind 22242 event
+// of NIP-42, and Challenge is set instead if the second element is a string.
 type ClientAuthMsg struct {
 	Challenge string
+	Event     *Event
 }
 
 func (*ClientAuthMsg) ClientMsg() {}
@@ -302,7 +370,7 @@ func (msg *ClientAuthMsg) UnmarshalJSON(b []byte) error {
 		return nil
 	}
 
-	var elems []string
+	var elems []json.RawMessage
 	if err := json.Unmarshal(b, &elems); err != nil {
 		return fmt.Errorf("not a json array: %w", err)
 	}
@@ -310,16 +378,33 @@ func (msg *ClientAuthMsg) UnmarshalJSON(b []byte) error {
 		return fmt.Errorf("client auth msg length must be 2 but got %d", len(elems))
 	}
 
-	if elems[0] != "AUTH" {
-		return fmt.Errorf(`client auth msg label must be "AUTH" but got %q`, elems[0])
+	var label string
+	if err := json.Unmarshal(elems[0], &label); err != nil {
+		return fmt.Errorf("label must be string: %w", err)
+	}
+	if label != "AUTH" {
+		return fmt.Errorf(`client auth msg label must be "AUTH" but got %q`, label)
 	}
 
-	msg.Challenge = elems[1]
+	if bytes.HasPrefix(bytes.TrimSpace(elems[1]), []byte("{")) {
+		var event Event
+		if err := json.Unmarshal(elems[1], &event); err != nil {
+			return fmt.Errorf("failed to unmarshal event json: %w", err)
+		}
+		msg.Event = &event
+		return nil
+	}
+
+	if err := json.Unmarshal(elems[1], &msg.Challenge); err != nil {
+		return fmt.Errorf("challenge must be string: %w", err)
+	}
 
 	return nil
 }
 
-func (msg *ClientAuthMsg) Valid() bool { return msg != nil }
+func (msg *ClientAuthMsg) Valid() bool {
+	return msg != nil && (msg.Event == nil || msg.Event.Valid())
+}
 
 var _ ClientMsg = (*ClientCountMsg)(nil)
 
@@ -330,46 +415,102 @@ type ClientCountMsg struct {
 
 func (*ClientCountMsg) ClientMsg() {}
 
+var ErrInvalidClientCountMsg = errors.New("invalid client count msg")
+
 func (msg *ClientCountMsg) UnmarshalJSON(b []byte) error {
 	if bytes.Equal(b, []byte("null")) {
 		return nil
 	}
 
-	var elems []json.RawMessage
+	subID, filters, err := unmarshalClientFiltersMsg(b, "COUNT")
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidClientCountMsg, err)
+	}
+	*msg = ClientCountMsg{SubscriptionID: subID, ReqFilters: filters}
+
+	return nil
+}
+
+var ErrMarshalClientCountMsg = errors.New("failed to marshal client count msg")
+
+func (msg *ClientCountMsg) MarshalJSON() ([]byte, error) {
+	return msg.AppendJSON(nil)
+}
+
+func (msg *ClientCountMsg) AppendJSON(dst []byte) ([]byte, error) {
+	if msg == nil {
+		return nil, ErrMarshalClientCountMsg
+	}
+	return appendClientFiltersMsgJSON(dst, "COUNT", msg.SubscriptionID, msg.ReqFilters)
+}
+
+// unmarshalClientFiltersMsg parses a REQ or COUNT message of label,
+// which has a subscription id and one or more filters.
+func unmarshalClientFiltersMsg(b []byte, label string) (string, []*ReqFilter, error) {
+	pooled := getRawMsgs()
+	defer putRawMsgs(pooled)
+
+	elems := *pooled
 	if err := json.Unmarshal(b, &elems); err != nil {
-		return fmt.Errorf("not a json array: %w", err)
+		return "", nil, fmt.Errorf("not a json array: %w", err)
 	}
+	*pooled = elems
 	if len(elems) < 3 {
-		return fmt.Errorf("client count msg length must be 3 or more but got %d", len(elems))
+		return "", nil, fmt.Errorf("msg length must be 3 or more but got %d", len(elems))
 	}
 
-	var label string
-	if err := json.Unmarshal(elems[0], &label); err != nil {
-		return fmt.Errorf("label is not a json string: %w", err)
+	var gotLabel string
+	if err := json.Unmarshal(elems[0], &gotLabel); err != nil {
+		return "", nil, fmt.Errorf("label is not a json string: %w", err)
 	}
-	if label != "COUNT" {
-		return fmt.Errorf(`client count msg labes must be "COUNT" but got %q`, label)
+	if gotLabel != label {
+		return "", nil, fmt.Errorf("label must be %q but got %q", label, gotLabel)
 	}
 
-	var ret ClientCountMsg
-
-	if err := json.Unmarshal(elems[1], &ret.SubscriptionID); err != nil {
-		return fmt.Errorf("subscription id is not a json string: %w", err)
+	var subID string
+	if err := json.Unmarshal(elems[1], &subID); err != nil {
+		return "", nil, fmt.Errorf("subscription id is not a json string: %w", err)
 	}
 
-	ret.ReqFilters = make([]*ReqFilter, len(elems)-2)
-	for i := 0; i < len(elems)-2; i++ {
-		if err := json.Unmarshal(elems[i+2], &ret.ReqFilters[i]); err != nil {
-			return fmt.Errorf("failed to unmarshal filter: %w", err)
+	filters := make([]*ReqFilter, len(elems)-2)
+	for i, elem := range elems[2:] {
+		if bytes.Equal(elem, []byte("null")) {
+			return "", nil, fmt.Errorf("filter %d is null", i)
+		}
+		if err := json.Unmarshal(elem, &filters[i]); err != nil {
+			return "", nil, fmt.Errorf("failed to unmarshal filter: %w", err)
 		}
 	}
 
-	*msg = ret
+	return subID, filters, nil
+}
 
-	return nil
+// appendClientFiltersMsgJSON appends a REQ or COUNT message.
+func appendClientFiltersMsgJSON(
+	dst []byte,
+	label, subID string,
+	filters []*ReqFilter,
+) ([]byte, error) {
+	dst = append(dst, '[')
+	dst = appendJSONString(dst, label)
+	dst = append(dst, ',')
+	dst = appendJSONString(dst, subID)
+	for _, fil := range filters {
+		dst = append(dst, ',')
+		var err error
+		dst, err = fil.AppendJSON(dst)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return append(dst, ']'), nil
 }
 
 func (msg *ClientCountMsg) Valid() (ok bool) {
+	return msg.validWithOption(nil)
+}
+
+func (msg *ClientCountMsg) validWithOption(option *CheckClientMsgOption) (ok bool) {
 	if msg == nil {
 		return
 	}
@@ -378,7 +519,10 @@ func (msg *ClientCountMsg) Valid() (ok bool) {
 		return
 	}
 
-	if !sliceAllFunc(msg.ReqFilters, func(f *ReqFilter) bool { return f.Valid() }) {
+	if !sliceAllFunc(
+		msg.ReqFilters,
+		func(f *ReqFilter) bool { return f.validWithOption(option) },
+	) {
 		return
 	}
 
@@ -386,6 +530,14 @@ func (msg *ClientCountMsg) Valid() (ok bool) {
 	return
 }
 
+var (
+	// StrictTagNamePattern allows single-letter tag names in filters as NIP-01.
+	StrictTagNamePattern = regexp.MustCompile(`^[A-Za-z]$`)
+	// AnyTagNamePattern allows tag names of up to 64 printable ASCII characters
+	// such as "client".
+	AnyTagNamePattern = regexp.MustCompile(`^[!-~]{1,64}$`)
+)
+
 type ReqFilter struct {
 	IDs     []string
 	Authors []string
@@ -401,11 +553,11 @@ func (fil *ReqFilter) UnmarshalJSON(b []byte) error {
 		return nil
 	}
 
-	dec := json.NewDecoder(bytes.NewBuffer(b))
-	dec.UseNumber()
+	pooled := getJSONObj()
+	defer putJSONObj(pooled)
 
-	var obj map[string]any
-	if err := dec.Decode(&obj); err != nil {
+	obj := pooled
+	if err := decodeUseNumber(b, &obj); err != nil {
 		return fmt.Errorf("not a json object: %w", err)
 	}
 
@@ -453,8 +605,8 @@ func (fil *ReqFilter) UnmarshalJSON(b []byte) error {
 			}
 			ret.Kinds = kinds
 
-		case len(k) == 2 && k[0] == '#' && ('A' <= k[1] && k[1] <= 'Z' || 'a' <= k[1] && k[1] <= 'z'):
-			// tags
+		case len(k) >= 2 && k[0] == '#':
+			// tags. Names are checked by Valid since the allowed ones are configurable.
 			if ret.Tags == nil {
 				ret.Tags = make(map[string][]string)
 			}
@@ -512,19 +664,99 @@ func (fil *ReqFilter) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
+var ErrMarshalReqFilter = errors.New("failed to marshal req filter")
+
+func (fil *ReqFilter) MarshalJSON() ([]byte, error) {
+	return fil.AppendJSON(nil)
+}
+
+// AppendJSON appends the filter as a JSON object. Nil members are omitted
+// and tags are in sorted order.
+func (fil *ReqFilter) AppendJSON(dst []byte) ([]byte, error) {
+	if fil == nil {
+		return nil, ErrMarshalReqFilter
+	}
+
+	dst = append(dst, '{')
+	first := true
+	key := func(k string) {
+		if !first {
+			dst = append(dst, ',')
+		}
+		first = false
+		dst = appendJSONString(dst, k)
+		dst = append(dst, ':')
+	}
+
+	if fil.IDs != nil {
+		key("ids")
+		dst = appendJSONStrings(dst, fil.IDs)
+	}
+	if fil.Authors != nil {
+		key("authors")
+		dst = appendJSONStrings(dst, fil.Authors)
+	}
+	if fil.Kinds != nil {
+		key("kinds")
+		dst = append(dst, '[')
+		for i, kind := range fil.Kinds {
+			if i > 0 {
+				dst = append(dst, ',')
+			}
+			dst = appendJSONInt(dst, kind)
+		}
+		dst = append(dst, ']')
+	}
+	tags := make([]string, 0, len(fil.Tags))
+	for tag := range fil.Tags {
+		tags = append(tags, tag)
+	}
+	slices.Sort(tags)
+	for _, tag := range tags {
+		key(tag)
+		dst = appendJSONStrings(dst, fil.Tags[tag])
+	}
+	if fil.Since != nil {
+		key("since")
+		dst = appendJSONInt(dst, *fil.Since)
+	}
+	if fil.Until != nil {
+		key("until")
+		dst = appendJSONInt(dst, *fil.Until)
+	}
+	if fil.Limit != nil {
+		key("limit")
+		dst = appendJSONInt(dst, *fil.Limit)
+	}
+
+	return append(dst, '}'), nil
+}
+
 func (fil *ReqFilter) Valid() (ok bool) {
+	return fil.validWithOption(nil)
+}
+
+// ValidWithMode is the same as Valid but allows the prefixes of ids and authors
+// in IDMatchPrefix.
+func (fil *ReqFilter) ValidWithMode(mode IDMatchMode) (ok bool) {
+	return fil.validWithOption(&CheckClientMsgOption{IDMatchMode: mode})
+}
+
+func (fil *ReqFilter) validWithOption(option *CheckClientMsgOption) (ok bool) {
 	if fil == nil {
 		return
 	}
 
+	mode := option.idMatchMode()
+
 	if fil.IDs != nil {
-		if !sliceAllFunc(fil.IDs, validID) {
+		if !sliceAllFunc(fil.IDs, mode.validID) {
 			return
 		}
 	}
 
 	if fil.Authors != nil {
-		if !sliceAllFunc(fil.Authors, validPubkey) {
+		if !sliceAllFunc(fil.Authors, mode.validPubkey) {
 			return
 		}
 	}
@@ -537,8 +769,7 @@ func (fil *ReqFilter) Valid() (ok bool) {
 
 	if fil.Tags != nil {
 		for tag, vals := range fil.Tags {
-			if len(tag) != 2 || tag[0] != '#' ||
-				!('A' <= tag[1] && tag[1] <= 'Z' || 'a' <= tag[1] && tag[1] <= 'z') {
+			if len(tag) < 2 || tag[0] != '#' || !option.tagNamePattern().MatchString(tag[1:]) {
 				return
 			}
 			if vals == nil {
@@ -597,6 +828,30 @@ type ServerMsg interface {
 	MarshalJSON() ([]byte, error)
 }
 
+type jsonAppender interface {
+	AppendJSON(dst []byte) ([]byte, error)
+}
+
+type rawAppender interface {
+	AppendRaw(dst []byte) ([]byte, error)
+}
+
+// appendServerMsgJSON appends msg, preferring the original JSON of events.
+func appendServerMsgJSON(dst []byte, msg ServerMsg) ([]byte, error) {
+	if a, ok := msg.(rawAppender); ok {
+		return a.AppendRaw(dst)
+	}
+	if a, ok := msg.(jsonAppender); ok {
+		return a.AppendJSON(dst)
+	}
+
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	return append(dst, b...), nil
+}
+
 func IsNilServerMsg(msg ServerMsg) bool {
 	return msg == nil || reflect.ValueOf(msg).IsNil()
 }
@@ -616,17 +871,17 @@ func (*ServerEOSEMsg) ServerMsg() {}
 var ErrMarshalServerEOSEMsg = errors.New("failed to marshal server eose msg")
 
 func (msg *ServerEOSEMsg) MarshalJSON() ([]byte, error) {
+	return msg.AppendJSON(nil)
+}
+
+func (msg *ServerEOSEMsg) AppendJSON(dst []byte) ([]byte, error) {
 	if msg == nil {
 		return nil, ErrMarshalServerEOSEMsg
 	}
 
-	v := [2]string{"EOSE", msg.SubscriptionID}
-	ret, err := json.Marshal(&v)
-	if err != nil {
-		return nil, errors.Join(err, ErrMarshalServerEOSEMsg)
-	}
-
-	return ret, nil
+	dst = append(dst, `["EOSE",`...)
+	dst = appendJSONString(dst, msg.SubscriptionID)
+	return append(dst, ']'), nil
 }
 
 type ServerEventMsg struct {
@@ -647,17 +902,39 @@ func (*ServerEventMsg) ServerMsg() {}
 var ErrMarshalServerEventMsg = errors.New("failed to marshal server event msg")
 
 func (msg *ServerEventMsg) MarshalJSON() ([]byte, error) {
+	return msg.AppendJSON(nil)
+}
+
+func (msg *ServerEventMsg) AppendJSON(dst []byte) ([]byte, error) {
 	if msg == nil {
 		return nil, ErrMarshalServerEventMsg
 	}
 
-	v := [3]interface{}{"EVENT", msg.SubscriptionID, msg.Event}
-	ret, err := json.Marshal(&v)
-	if err != nil {
-		return nil, errors.Join(err, ErrMarshalServerEventMsg)
+	dst = append(dst, `["EVENT",`...)
+	dst = appendJSONString(dst, msg.SubscriptionID)
+	dst = append(dst, ',')
+	dst = msg.Event.appendJSONOrNull(dst)
+	return append(dst, ']'), nil
+}
+
+// MarshalRaw is like MarshalJSON but uses the original JSON of the event if any.
+func (msg *ServerEventMsg) MarshalRaw() ([]byte, error) {
+	return msg.AppendRaw(nil)
+}
+
+func (msg *ServerEventMsg) AppendRaw(dst []byte) ([]byte, error) {
+	if msg == nil || msg.Event == nil {
+		return msg.AppendJSON(dst)
 	}
 
-	return ret, nil
+	dst = append(dst, `["EVENT",`...)
+	dst = appendJSONString(dst, msg.SubscriptionID)
+	dst = append(dst, ',')
+	dst, err := msg.Event.AppendRaw(dst)
+	if err != nil {
+		return nil, ErrMarshalServerEventMsg
+	}
+	return append(dst, ']'), nil
 }
 
 type ServerNoticeMsg struct {
@@ -681,17 +958,17 @@ func (*ServerNoticeMsg) ServerMsg() {}
 var ErrMarshalServerNoticeMsg = errors.New("failed to marshal server notice msg")
 
 func (msg *ServerNoticeMsg) MarshalJSON() ([]byte, error) {
+	return msg.AppendJSON(nil)
+}
+
+func (msg *ServerNoticeMsg) AppendJSON(dst []byte) ([]byte, error) {
 	if msg == nil {
 		return nil, ErrMarshalServerNoticeMsg
 	}
 
-	v := [2]string{"NOTICE", msg.Message}
-	ret, err := json.Marshal(&v)
-	if err != nil {
-		err = errors.Join(err, ErrMarshalServerNoticeMsg)
-	}
-
-	return ret, err
+	dst = append(dst, `["NOTICE",`...)
+	dst = appendJSONString(dst, msg.Message)
+	return append(dst, ']'), nil
 }
 
 type ServerOKMsg struct {
@@ -702,13 +979,15 @@ type ServerOKMsg struct {
 }
 
 const (
-	ServerOKMsgPrefixNoPrefix    = ""
-	ServerOKMsgPrefixPoW         = "pow: "
-	ServerOKMsgPrefixDuplicate   = "duplicate: "
-	ServerOkMsgPrefixBlocked     = "blocked: "
-	ServerOkMsgPrefixRateLimited = "rate-limited: "
-	ServerOkMsgPrefixRateInvalid = "invalid: "
-	ServerOkMsgPrefixError       = "error: "
+	ServerOKMsgPrefixNoPrefix     = ""
+	ServerOKMsgPrefixPoW          = "pow: "
+	ServerOKMsgPrefixDuplicate    = "duplicate: "
+	ServerOkMsgPrefixBlocked      = "blocked: "
+	ServerOkMsgPrefixRateLimited  = "rate-limited: "
+	ServerOkMsgPrefixRateInvalid  = "invalid: "
+	ServerOkMsgPrefixRestricted   = "restricted: "
+	ServerOkMsgPrefixError        = "error: "
+	ServerOkMsgPrefixAuthRequired = "auth-required: "
 )
 
 func NewServerOKMsg(eventID string, accepted bool, prefix, msg string) *ServerOKMsg {
@@ -722,6 +1001,49 @@ func NewServerOKMsg(eventID string, accepted bool, prefix, msg string) *ServerOK
 
 func (*ServerOKMsg) ServerMsg() {}
 
+// OKError is an error rejecting an EVENT with OK false, Prefix and Msg.
+// Stores and the handlers and middlewares made by NewSimpleHandler or NewSimpleMiddleware
+// can return it, possibly wrapped, to reply the OK message instead of failing.
+type OKError struct {
+	// Prefix is a machine-readable prefix such as ServerOkMsgPrefixBlocked.
+	Prefix string
+	Msg    string
+}
+
+func NewOKError(prefix, msg string) *OKError {
+	return &OKError{Prefix: prefix, Msg: msg}
+}
+
+func (e *OKError) Error() string {
+	return e.Prefix + e.Msg
+}
+
+// ServerOKMsg returns the OK message rejecting eventID.
+func (e *OKError) ServerOKMsg(eventID string) *ServerOKMsg {
+	return NewServerOKMsg(eventID, false, e.Prefix, e.Msg)
+}
+
+// okMsgFromError returns the OK message of err if it is an *OKError,
+// or the one with prefix and msg otherwise.
+func okMsgFromError(eventID string, err error, prefix, msg string) *ServerOKMsg {
+	var okErr *OKError
+	if errors.As(err, &okErr) {
+		return okErr.ServerOKMsg(eventID)
+	}
+	return NewServerOKMsg(eventID, false, prefix, msg)
+}
+
+// clientMsgOKError returns the OK message replying msg if it is an EVENT
+// and err is an *OKError.
+func clientMsgOKError(msg ClientMsg, err error) (*ServerOKMsg, bool) {
+	var okErr *OKError
+	m, ok := msg.(*ClientEventMsg)
+	if !ok || !errors.As(err, &okErr) {
+		return nil, false
+	}
+	return okErr.ServerOKMsg(m.Event.ID), true
+}
+
 func (msg *ServerOKMsg) Message() string {
 	return msg.MsgPrefix + msg.Msg
 }
@@ -729,17 +1051,21 @@ func (msg *ServerOKMsg) Message() string {
 var ErrMarshalServerOKMsg = errors.New("failed to marshal server ok msg")
 
 func (msg *ServerOKMsg) MarshalJSON() ([]byte, error) {
+	return msg.AppendJSON(nil)
+}
+
+func (msg *ServerOKMsg) AppendJSON(dst []byte) ([]byte, error) {
 	if msg == nil {
 		return nil, ErrMarshalServerOKMsg
 	}
 
-	v := [4]interface{}{"OK", msg.EventID, msg.Accepted, msg.Message()}
-	ret, err := json.Marshal(&v)
-	if err != nil {
-		err = errors.Join(err, ErrMarshalServerOKMsg)
-	}
-
-	return ret, err
+	dst = append(dst, `["OK",`...)
+	dst = appendJSONString(dst, msg.EventID)
+	dst = append(dst, ',')
+	dst = appendJSONBool(dst, msg.Accepted)
+	dst = append(dst, ',')
+	dst = appendJSONString(dst, msg.Message())
+	return append(dst, ']'), nil
 }
 
 type ServerAuthMsg struct {
@@ -761,17 +1087,44 @@ func (*ServerAuthMsg) ServerMsg() {}
 var ErrMarshalServerAuthMsg = errors.New("failed to marshal server auth msg")
 
 func (msg *ServerAuthMsg) MarshalJSON() ([]byte, error) {
+	return msg.AppendJSON(nil)
+}
+
+func (msg *ServerAuthMsg) AppendJSON(dst []byte) ([]byte, error) {
 	if msg == nil {
 		return nil, ErrMarshalServerAuthMsg
 	}
 
-	v := [2]interface{}{"AUTH", msg.Event}
-	ret, err := json.Marshal(&v)
-	if err != nil {
-		err = errors.Join(err, ErrMarshalServerAuthMsg)
+	dst = append(dst, `["AUTH",`...)
+	dst = msg.Event.appendJSONOrNull(dst)
+	return append(dst, ']'), nil
+}
+
+// ServerAuthChallengeMsg is an AUTH message with the challenge of NIP-42.
+type ServerAuthChallengeMsg struct {
+	Challenge string
+}
+
+func NewServerAuthChallengeMsg(challenge string) *ServerAuthChallengeMsg {
+	return &ServerAuthChallengeMsg{Challenge: challenge}
+}
+
+func (*ServerAuthChallengeMsg) ServerMsg() {}
+
+var ErrMarshalServerAuthChallengeMsg = errors.New("failed to marshal server auth challenge msg")
+
+func (msg *ServerAuthChallengeMsg) MarshalJSON() ([]byte, error) {
+	return msg.AppendJSON(nil)
+}
+
+func (msg *ServerAuthChallengeMsg) AppendJSON(dst []byte) ([]byte, error) {
+	if msg == nil {
+		return nil, ErrMarshalServerAuthChallengeMsg
 	}
 
-	return ret, err
+	dst = append(dst, `["AUTH",`...)
+	dst = appendJSONString(dst, msg.Challenge)
+	return append(dst, ']'), nil
 }
 
 type ServerCountMsg struct {
@@ -793,26 +1146,23 @@ func (*ServerCountMsg) ServerMsg() {}
 var ErrMarshalServerCountMsg = errors.New("failed to marshal server count msg")
 
 func (msg *ServerCountMsg) MarshalJSON() ([]byte, error) {
+	return msg.AppendJSON(nil)
+}
+
+func (msg *ServerCountMsg) AppendJSON(dst []byte) ([]byte, error) {
 	if msg == nil {
 		return nil, ErrMarshalServerCountMsg
 	}
 
-	type payload struct {
-		Count       uint64 `json:"count"`
-		Approximate *bool  `json:"approximate,omitempty"`
+	dst = append(dst, `["COUNT",`...)
+	dst = appendJSONString(dst, msg.SubscriptionID)
+	dst = append(dst, `,{"count":`...)
+	dst = strconv.AppendUint(dst, msg.Count, 10)
+	if msg.Approximate != nil {
+		dst = append(dst, `,"approximate":`...)
+		dst = appendJSONBool(dst, *msg.Approximate)
 	}
-
-	v := [3]interface{}{
-		"COUNT",
-		msg.SubscriptionID,
-		payload{Count: msg.Count, Approximate: msg.Approximate},
-	}
-	ret, err := json.Marshal(&v)
-	if err != nil {
-		err = errors.Join(err, ErrMarshalServerCountMsg)
-	}
-
-	return ret, err
+	return append(dst, "}]"...), nil
 }
 
 type EventType int
@@ -825,37 +1175,133 @@ const (
 	EventTypeParamReplaceable
 )
 
+type ServerClosedMsg struct {
+	SubscriptionID string
+	Msg            string
+	MsgPrefix      string
+}
+
+const (
+	ServerClosedMsgPrefixNoPrefix     = ""
+	ServerClosedMsgPrefixAuthRequired = "auth-required: "
+	ServerClosedMsgPrefixRestricted   = "restricted: "
+	ServerClosedMsgPrefixRateLimited  = "rate-limited: "
+	ServerClosedMsgPrefixInvalid      = "invalid: "
+	ServerClosedMsgPrefixError        = "error: "
+)
+
+func NewServerClosedMsg(subID string, prefix, msg string) *ServerClosedMsg {
+	return &ServerClosedMsg{
+		SubscriptionID: subID,
+		MsgPrefix:      prefix,
+		Msg:            msg,
+	}
+}
+
+func (*ServerClosedMsg) ServerMsg() {}
+
+func (msg *ServerClosedMsg) Message() string {
+	return msg.MsgPrefix + msg.Msg
+}
+
+var ErrMarshalServerClosedMsg = errors.New("failed to marshal server closed msg")
+
+func (msg *ServerClosedMsg) MarshalJSON() ([]byte, error) {
+	return msg.AppendJSON(nil)
+}
+
+func (msg *ServerClosedMsg) AppendJSON(dst []byte) ([]byte, error) {
+	if msg == nil {
+		return nil, ErrMarshalServerClosedMsg
+	}
+
+	dst = append(dst, `["CLOSED",`...)
+	dst = appendJSONString(dst, msg.SubscriptionID)
+	dst = append(dst, ',')
+	dst = appendJSONString(dst, msg.Message())
+	return append(dst, ']'), nil
+}
+
 type Event struct {
 	ID        string `json:"id"`
 	Pubkey    string `json:"pubkey"`
 	CreatedAt int64  `json:"created_at"`
 	Kind      int64  `json:"kind"`
-	Tags      []Tag  `json:"tags"`
+	Tags      Tags   `json:"tags"`
 	Content   string `json:"content"`
 	Sig       string `json:"sig"`
+
+	// Annotations are relay side metadata such as the ones added by EventHook.
+	// They are not a part of the signed payload and are never sent to clients.
+	Annotations map[string]string `json:"-"`
+
+	// raw is the original JSON of the event if it is unmarshaled.
+	raw []byte
 }
 
 var ErrMarshalEvent = errors.New("failed to marshal event")
 
 func (ev *Event) MarshalJSON() ([]byte, error) {
+	return ev.AppendJSON(nil)
+}
+
+func (ev *Event) AppendJSON(dst []byte) ([]byte, error) {
 	if ev == nil {
 		return nil, ErrMarshalEvent
 	}
 
-	type alias Event
-	ret, err := json.Marshal(alias(*ev))
-	if err != nil {
-		err = errors.Join(err, ErrMarshalEvent)
+	dst = append(dst, `{"id":`...)
+	dst = appendJSONString(dst, ev.ID)
+	dst = append(dst, `,"pubkey":`...)
+	dst = appendJSONString(dst, ev.Pubkey)
+	dst = append(dst, `,"created_at":`...)
+	dst = appendJSONInt(dst, ev.CreatedAt)
+	dst = append(dst, `,"kind":`...)
+	dst = appendJSONInt(dst, ev.Kind)
+	dst = append(dst, `,"tags":`...)
+	dst = appendJSONTags(dst, ev.Tags)
+	dst = append(dst, `,"content":`...)
+	dst = appendJSONString(dst, ev.Content)
+	dst = append(dst, `,"sig":`...)
+	dst = appendJSONString(dst, ev.Sig)
+	return append(dst, '}'), nil
+}
+
+// Raw returns the original JSON of the event, or nil if it is not unmarshaled.
+// Events must not be modified after unmarshaling since the raw JSON is not updated.
+func (ev *Event) Raw() []byte {
+	if ev == nil {
+		return nil
 	}
-	return ret, err
+	return ev.raw
+}
+
+// MarshalRaw returns the original JSON of the event if any, or marshals it.
+func (ev *Event) MarshalRaw() ([]byte, error) {
+	return ev.AppendRaw(nil)
+}
+
+func (ev *Event) AppendRaw(dst []byte) ([]byte, error) {
+	if raw := ev.Raw(); raw != nil {
+		return append(dst, raw...), nil
+	}
+	return ev.AppendJSON(dst)
+}
+
+func (ev *Event) appendJSONOrNull(dst []byte) []byte {
+	if ev == nil {
+		return append(dst, "null"...)
+	}
+	dst, _ = ev.AppendJSON(dst)
+	return dst
 }
 
 func (ev *Event) UnmarshalJSON(b []byte) error {
-	dec := json.NewDecoder(bytes.NewBuffer(b))
-	dec.UseNumber()
+	pooled := getJSONObj()
+	defer putJSONObj(pooled)
 
-	var obj map[string]interface{}
-	if err := dec.Decode(&obj); err != nil {
+	obj := pooled
+	if err := decodeUseNumber(b, &obj); err != nil {
 		return fmt.Errorf("not a json object: %w", err)
 	}
 	if len(obj) != 7 {
@@ -925,12 +1371,12 @@ func (ev *Event) UnmarshalJSON(b []byte) error {
 	if !ok {
 		return errors.New("tags is not a json array")
 	}
-	slisli, ok := anySliceAs[[]any](tmpSli)
-	if !ok {
-		return errors.New("tags is not a array of json array")
-	}
-	ret.Tags = make([]Tag, len(slisli))
-	for i, sli := range slisli {
+	ret.Tags = make([]Tag, len(tmpSli))
+	for i, v := range tmpSli {
+		sli, ok := v.([]any)
+		if !ok {
+			return errors.New("tags is not a array of json array")
+		}
 		ret.Tags[i], ok = anySliceAs[string](sli)
 		if !ok {
 			return errors.New("tags is not string arrays of json array")
@@ -957,6 +1403,8 @@ func (ev *Event) UnmarshalJSON(b []byte) error {
 		return errors.New("sig is not a json string")
 	}
 
+	ret.raw = bytes.Clone(bytes.TrimSpace(b))
+
 	*ev = ret
 
 	return nil
@@ -990,66 +1438,81 @@ func (ev *Event) Serialize() ([]byte, error) {
 		return nil, fmt.Errorf("empty event: %w", ErrEventSerialize)
 	}
 
-	v := [6]interface{}{
-		0,
-		ev.Pubkey,
-		ev.CreatedAt,
-		ev.Kind,
-		ev.Tags,
-		ev.Content,
+	ret := make([]byte, 0, 128+len(ev.Content))
+	ret = append(ret, "[0,"...)
+	ret = appendCanonicalJSONString(ret, ev.Pubkey)
+	ret = append(ret, ',')
+	ret = appendJSONInt(ret, ev.CreatedAt)
+	ret = append(ret, ',')
+	ret = appendJSONInt(ret, ev.Kind)
+	ret = append(ret, ",["...)
+	for i, tag := range ev.Tags {
+		if i > 0 {
+			ret = append(ret, ',')
+		}
+		ret = append(ret, '[')
+		for j, elem := range tag {
+			if j > 0 {
+				ret = append(ret, ',')
+			}
+			ret = appendCanonicalJSONString(ret, elem)
+		}
+		ret = append(ret, ']')
 	}
+	ret = append(ret, "],"...)
+	ret = appendCanonicalJSONString(ret, ev.Content)
+	ret = append(ret, ']')
 
-	ret, err := json.Marshal(&v)
-	if err != nil {
-		err = errors.Join(err, ErrEventSerialize)
-	}
-	return ret, err
+	return ret, nil
 }
 
 func (ev *Event) Verify() (bool, error) {
+	return ev.VerifyWith(BtcecSigVerifier{})
+}
+
+func (ev *Event) VerifyWith(sv SigVerifier) (bool, error) {
+	id, pubkey, sig, ok, err := ev.sigInputs()
+	if err != nil || !ok {
+		return false, err
+	}
+
+	return sv.VerifySig(id, pubkey, sig)
+}
+
+// sigInputs verifies the event id and returns the decoded id, pubkey and sig.
+func (ev *Event) sigInputs() (id, pubkey, sig []byte, ok bool, err error) {
 	if ev == nil {
-		return false, errors.New("nil event")
+		return nil, nil, nil, false, errors.New("nil event")
 	}
 
 	// Verify ID
 	serialized, err := ev.Serialize()
 	if err != nil {
-		return false, err
+		return nil, nil, nil, false, err
 	}
 
-	idBin, err := hex.DecodeString(ev.ID)
+	id, err = hex.DecodeString(ev.ID)
 	if err != nil {
-		return false, fmt.Errorf("failed to decode id: %w", err)
+		return nil, nil, nil, false, fmt.Errorf("failed to decode id: %w", err)
 	}
 
 	hash := sha256.Sum256(serialized)
 
-	if !bytes.Equal(idBin, hash[:]) {
-		return false, nil
-	}
-
-	// Verify Sig
-	pubkeyBin, err := hex.DecodeString(ev.Pubkey)
-	if err != nil {
-		return false, fmt.Errorf("failed to decode pubkey: %w", err)
+	if !bytes.Equal(id, hash[:]) {
+		return nil, nil, nil, false, nil
 	}
 
-	pubkey, err := schnorr.ParsePubKey(pubkeyBin)
+	pubkey, err = hex.DecodeString(ev.Pubkey)
 	if err != nil {
-		return false, fmt.Errorf("failed to parse pubkey: %w", err)
+		return nil, nil, nil, false, fmt.Errorf("failed to decode pubkey: %w", err)
 	}
 
-	sigBin, err := hex.DecodeString(ev.Sig)
+	sig, err = hex.DecodeString(ev.Sig)
 	if err != nil {
-		return false, fmt.Errorf("failed to decode sig: %w", err)
+		return nil, nil, nil, false, fmt.Errorf("failed to decode sig: %w", err)
 	}
 
-	sig, err := schnorr.ParseSignature(sigBin)
-	if err != nil {
-		return false, fmt.Errorf("failed to parse sig: %w", err)
-	}
-
-	return sig.Verify(idBin, pubkey), nil
+	return id, pubkey, sig, true, nil
 }
 
 func (ev *Event) CreatedAtTime() time.Time {
@@ -1085,26 +1548,9 @@ func validKind(kind int64) bool { return 0 <= kind || kind <= 65535 }
 
 func validTag(tag Tag) bool { return len(tag) >= 1 && tag[0] != "" }
 
-func validNaddr(naddr string) (ok bool) {
-	elems := strings.Split(naddr, ":")
-	if len(elems) != 3 {
-		return
-	}
-
-	kind, err := strconv.ParseInt(elems[0], 10, 64)
-	if err != nil {
-		return
-	}
-	if !validKind(kind) {
-		return
-	}
-
-	if !validPubkey(elems[1]) {
-		return
-	}
-
-	ok = true
-	return
+func validNaddr(naddr string) bool {
+	_, err := ParseNaddr(naddr)
+	return err == nil
 }
 
 func validSig(sig string) bool { return len(sig) == 128 && validHexString(sig) }
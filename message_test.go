@@ -2,6 +2,7 @@ package mocrelay
 
 import (
 	"encoding/json"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -158,6 +159,7 @@ func BenchmarkParseClientMsg_All(b *testing.B) {
 		`["COUNT","8d405a05-a8d7-4cc5-8bc1-53eac4f7949d",{"ids":["powa11","powa12"],"authors":["meu11","meu12"],"kinds":[1,3],"#e":["moyasu11","moyasu12"],"since":16,"until":184838,"limit":143},{"ids":["powa21","powa22"],"authors":["meu21","meu22"],"kinds":[11,33],"#e":["moyasu21","moyasu22"],"since":17,"until":184839,"limit":144}]`,
 	)
 
+	b.ReportAllocs()
 	b.ResetTimer()
 	for i := 0; i < b.N; i += 5 {
 		ParseClientMsg(eventJSON)
@@ -191,6 +193,7 @@ func BenchmarkParseClientMsg_Event(b *testing.B) {
 		`  "sig": "795e51656e8b863805c41b3a6e1195ed63bf8c5df1fc3a4078cd45aaf0d8838f2dc57b802819443364e8e38c0f35c97e409181680bfff83e58949500f5a8f0c8"` +
 		`}]`)
 
+	b.ReportAllocs()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		ParseClientMsg(eventJSON)
@@ -202,6 +205,7 @@ func BenchmarkParseClientMsg_Req(b *testing.B) {
 		`["REQ","8d405a05-a8d7-4cc5-8bc1-53eac4f7949d",{"ids":["powa11","powa12"],"authors":["meu11","meu12"],"kinds":[1,3],"#e":["moyasu11","moyasu12"],"since":16,"until":184838,"limit":143},{"ids":["powa21","powa22"],"authors":["meu21","meu22"],"kinds":[11,33],"#e":["moyasu21","moyasu22"],"since":17,"until":184839,"limit":144}]`,
 	)
 
+	b.ReportAllocs()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		ParseClientMsg(reqJSON)
@@ -211,6 +215,7 @@ func BenchmarkParseClientMsg_Req(b *testing.B) {
 func BenchmarkParseClientMsg_Close(b *testing.B) {
 	closeJSON := []byte(`["CLOSE","sub_id"]`)
 
+	b.ReportAllocs()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		ParseClientMsg(closeJSON)
@@ -220,6 +225,7 @@ func BenchmarkParseClientMsg_Close(b *testing.B) {
 func BenchmarkParseClientMsg_Auth(b *testing.B) {
 	authJSON := []byte(`["AUTH","challenge"]`)
 
+	b.ReportAllocs()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		ParseClientMsg(authJSON)
@@ -231,6 +237,7 @@ func BenchmarkParseClientMsg_Count(b *testing.B) {
 		`["COUNT","8d405a05-a8d7-4cc5-8bc1-53eac4f7949d",{"ids":["powa11","powa12"],"authors":["meu11","meu12"],"kinds":[1,3],"#e":["moyasu11","moyasu12"],"since":16,"until":184838,"limit":143},{"ids":["powa21","powa22"],"authors":["meu21","meu22"],"kinds":[11,33],"#e":["moyasu21","moyasu22"],"since":17,"until":184839,"limit":144}]`,
 	)
 
+	b.ReportAllocs()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		ParseClientMsg(countJSON)
@@ -450,6 +457,27 @@ func TestClientReqMsg_UnmarshalJSON(t *testing.T) {
 	}
 }
 
+func TestClientReqMsg_MarshalJSON(t *testing.T) {
+	msg := &ClientReqMsg{
+		SubscriptionID: "sub",
+		ReqFilters: []*ReqFilter{
+			{Kinds: []int64{1}, Tags: map[string][]string{"#t": {"nostr"}}},
+			{Limit: toPtr[int64](10)},
+		},
+	}
+
+	b, err := msg.MarshalJSON()
+	assert.NoError(t, err)
+	assert.Equal(t, `["REQ","sub",{"kinds":[1],"#t":["nostr"]},{"limit":10}]`, string(b))
+
+	got, err := ParseClientMsg(b)
+	assert.NoError(t, err)
+	assert.Equal(t, msg, got)
+
+	_, err = (&ClientReqMsg{SubscriptionID: "sub", ReqFilters: []*ReqFilter{nil}}).MarshalJSON()
+	assert.Error(t, err)
+}
+
 func TestClientCloseMsg_UnmarshalJSON(t *testing.T) {
 	type Expect struct {
 		SubscriptionID string
@@ -554,6 +582,24 @@ func TestClientAuthMsg_UnmarshalJSON(t *testing.T) {
 	}
 }
 
+func TestClientAuthMsg_UnmarshalJSON_event(t *testing.T) {
+	var msg ClientAuthMsg
+	err := msg.UnmarshalJSON([]byte(
+		`["AUTH",{"id":"id","pubkey":"pubkey","created_at":1,"kind":22242,"tags":[["challenge","c"]],"content":"","sig":"sig"}]`,
+	))
+	assert.NoError(t, err)
+	assert.Empty(t, msg.Challenge)
+	if assert.NotNil(t, msg.Event) {
+		assert.Equal(t, Tags{{"challenge", "c"}}, msg.Event.Tags)
+	}
+}
+
+func TestServerAuthChallengeMsg_MarshalJSON(t *testing.T) {
+	b, err := NewServerAuthChallengeMsg("challenge").MarshalJSON()
+	assert.NoError(t, err)
+	assert.Equal(t, `["AUTH","challenge"]`, string(b))
+}
+
 func TestClientCountMsg_UnmarshalJSON(t *testing.T) {
 	type Expect struct {
 		SubscriptionID string
@@ -644,6 +690,48 @@ func TestClientCountMsg_UnmarshalJSON(t *testing.T) {
 	}
 }
 
+func TestClientFiltersMsg_UnmarshalJSON_invalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"no filter", `["%s","sub"]`},
+		{"no subscription id", `["%s"]`},
+		{"empty", `[]`},
+		{"not an array", `{"%s":"sub"}`},
+		{"null filter", `["%s","sub",{},null]`},
+		{"non-string subscription id", `["%s",1,{}]`},
+		{"other label", `["EVENT","sub",{}]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req ClientReqMsg
+			err := req.UnmarshalJSON([]byte(strings.ReplaceAll(tt.input, "%s", "REQ")))
+			assert.ErrorIs(t, err, ErrInvalidClientReqMsg)
+
+			var count ClientCountMsg
+			err = count.UnmarshalJSON([]byte(strings.ReplaceAll(tt.input, "%s", "COUNT")))
+			assert.ErrorIs(t, err, ErrInvalidClientCountMsg)
+		})
+	}
+}
+
+func TestClientCountMsg_MarshalJSON(t *testing.T) {
+	msg := &ClientCountMsg{
+		SubscriptionID: "sub",
+		ReqFilters:     []*ReqFilter{{Authors: []string{"pubkey"}}},
+	}
+
+	b, err := msg.MarshalJSON()
+	assert.NoError(t, err)
+	assert.Equal(t, `["COUNT","sub",{"authors":["pubkey"]}]`, string(b))
+
+	got, err := ParseClientMsg(b)
+	assert.NoError(t, err)
+	assert.Equal(t, msg, got)
+}
+
 func TestReqFilter_UnmarshalJSON(t *testing.T) {
 	type Expect struct {
 		ReqFilter ReqFilter
@@ -699,6 +787,20 @@ func TestReqFilter_UnmarshalJSON(t *testing.T) {
 				IsErr: false,
 			},
 		},
+		{
+			Name:  "ok: long tag name",
+			Input: []byte(`{"#client":["mocrelay"]}`),
+			Expect: Expect{
+				ReqFilter: ReqFilter{Tags: map[string][]string{"#client": {"mocrelay"}}},
+			},
+		},
+		{
+			Name:  "ng: empty tag name",
+			Input: []byte(`{"#":["mocrelay"]}`),
+			Expect: Expect{
+				IsErr: true,
+			},
+		},
 		{
 			Name: "ng: contains some extra fields",
 			Input: []byte(
@@ -726,6 +828,133 @@ func TestReqFilter_UnmarshalJSON(t *testing.T) {
 	}
 }
 
+func TestReqFilter_MarshalJSON(t *testing.T) {
+	tests := []struct {
+		name  string
+		input *ReqFilter
+		want  string
+	}{
+		{"empty", &ReqFilter{}, `{}`},
+		{"empty slice", &ReqFilter{IDs: []string{}}, `{"ids":[]}`},
+		{
+			"full",
+			&ReqFilter{
+				IDs:     []string{"id"},
+				Authors: []string{"pubkey"},
+				Kinds:   []int64{1, 7},
+				Tags:    map[string][]string{"#p": {"p1"}, "#e": {"e1", "e2"}},
+				Since:   toPtr[int64](10),
+				Until:   toPtr[int64](20),
+				Limit:   toPtr[int64](0),
+			},
+			`{"ids":["id"],"authors":["pubkey"],"kinds":[1,7],"#e":["e1","e2"],"#p":["p1"],` +
+				`"since":10,"until":20,"limit":0}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := tt.input.MarshalJSON()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, string(b))
+
+			var got ReqFilter
+			assert.NoError(t, got.UnmarshalJSON(b))
+			assert.Equal(t, *tt.input, got)
+		})
+	}
+}
+
+func TestReqFilter_ValidWithMode(t *testing.T) {
+	full := "d2ea747b6e3a35d2a8b759857b73fcaba5e9f3cfb6f38d317e034bddc0bf0d1c"
+
+	tests := []struct {
+		name   string
+		input  ReqFilter
+		exact  bool
+		prefix bool
+	}{
+		{
+			name:   "full",
+			input:  ReqFilter{IDs: []string{full}, Authors: []string{full}},
+			exact:  true,
+			prefix: true,
+		},
+		{
+			name:   "id prefix",
+			input:  ReqFilter{IDs: []string{"d2ea"}},
+			exact:  false,
+			prefix: true,
+		},
+		{
+			name:   "author prefix",
+			input:  ReqFilter{Authors: []string{"d"}},
+			exact:  false,
+			prefix: true,
+		},
+		{
+			name:   "empty prefix",
+			input:  ReqFilter{IDs: []string{""}},
+			exact:  false,
+			prefix: false,
+		},
+		{
+			name:   "uppercase prefix",
+			input:  ReqFilter{IDs: []string{"D2EA"}},
+			exact:  false,
+			prefix: false,
+		},
+		{
+			name:   "too long",
+			input:  ReqFilter{Authors: []string{full + "0"}},
+			exact:  false,
+			prefix: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.exact, tt.input.Valid())
+			assert.Equal(t, tt.prefix, tt.input.ValidWithMode(IDMatchPrefix))
+
+			msg := &ClientReqMsg{SubscriptionID: "sub", ReqFilters: []*ReqFilter{&tt.input}}
+			ok, err := CheckClientMsgWithOption(
+				msg,
+				&CheckClientMsgOption{IDMatchMode: IDMatchPrefix},
+			)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.prefix, ok)
+		})
+	}
+}
+
+func TestReqFilter_Valid_tagName(t *testing.T) {
+	tests := []struct {
+		name   string
+		tag    string
+		strict bool
+		any    bool
+	}{
+		{"single letter", "#t", true, true},
+		{"long name", "#client", false, true},
+		{"digit", "#1", false, true},
+		{"space", "#a b", false, false},
+		{"too long", "#" + strings.Repeat("a", 65), false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fil := &ReqFilter{Tags: map[string][]string{tt.tag: {"v"}}}
+			assert.Equal(t, tt.strict, fil.Valid())
+			assert.Equal(
+				t,
+				tt.any,
+				fil.validWithOption(&CheckClientMsgOption{TagNamePattern: AnyTagNamePattern}),
+			)
+		})
+	}
+}
+
 func TestServerEOSEMsg_MarshalJSON(t *testing.T) {
 	type Expect struct {
 		Json []byte
@@ -848,6 +1077,52 @@ func TestServerEventMsg_MarshalJSON(t *testing.T) {
 	}
 }
 
+func TestServerEventMsg_MarshalRaw(t *testing.T) {
+	raw := []byte(`{"kind": 1, "pubkey": "pub", "created_at": 1693157791, "tags": [], ` +
+		`"content": "\u3042", "id": "id", "sig": "sig"}`)
+
+	var event Event
+	assert.NoError(t, event.UnmarshalJSON(append([]byte(" "), raw...)))
+
+	tests := []struct {
+		name  string
+		input *ServerEventMsg
+		want  []byte
+	}{
+		{
+			name:  "raw",
+			input: NewServerEventMsg("sub_id", &event),
+			want:  append(append([]byte(`["EVENT","sub_id",`), raw...), ']'),
+		},
+		{
+			name: "no raw",
+			input: NewServerEventMsg("sub_id", &Event{
+				ID:        "id",
+				Pubkey:    "pub",
+				CreatedAt: 1693157791,
+				Kind:      1,
+				Tags:      []Tag{},
+				Content:   "あ",
+				Sig:       "sig",
+			}),
+			want: []byte(`["EVENT","sub_id",{"id":"id","pubkey":"pub","created_at":1693157791,` +
+				`"kind":1,"tags":[],"content":"あ","sig":"sig"}]`),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.input.MarshalRaw()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+
+			got, err = appendServerMsgJSON(nil, tt.input)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
 func TestServerNoticeMsg_MarshalJSON(t *testing.T) {
 	type Expect struct {
 		Json []byte
@@ -948,6 +1223,54 @@ func TestServerOKMsg_MarshalJSON(t *testing.T) {
 	}
 }
 
+func TestServerClosedMsg_MarshalJSON(t *testing.T) {
+	type Expect struct {
+		Json []byte
+		Err  error
+	}
+
+	tests := []struct {
+		Name   string
+		Input  *ServerClosedMsg
+		Expect Expect
+	}{
+		{
+			Name:  "ok: server closed message",
+			Input: NewServerClosedMsg("sub_id", ServerClosedMsgPrefixNoPrefix, "msg"),
+			Expect: Expect{
+				Json: []byte(`["CLOSED","sub_id","msg"]`),
+				Err:  nil,
+			},
+		},
+		{
+			Name:  "ok: server closed message with prefix",
+			Input: NewServerClosedMsg("sub_id", ServerClosedMsgPrefixRateLimited, "slow down"),
+			Expect: Expect{
+				Json: []byte(`["CLOSED","sub_id","rate-limited: slow down"]`),
+				Err:  nil,
+			},
+		},
+		{
+			Name:  "ng: nil",
+			Input: nil,
+			Expect: Expect{
+				Err: ErrMarshalServerClosedMsg,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			got, err := tt.Input.MarshalJSON()
+			if tt.Expect.Err != nil || err != nil {
+				assert.ErrorIs(t, err, tt.Expect.Err)
+				return
+			}
+			assert.Equal(t, tt.Expect.Json, got)
+		})
+	}
+}
+
 func TestServerAuthMsg_MarshalJSON(t *testing.T) {
 	// TODO(high-moctane) use auth event
 
@@ -1410,6 +1733,7 @@ func TestEvent_UnmarshalJSON(t *testing.T) {
 				return
 			}
 			assert.EqualExportedValues(t, *tt.Expect.Event, event)
+			assert.Equal(t, tt.Input, event.Raw())
 		})
 	}
 }
@@ -1437,6 +1761,7 @@ func BenchmarkParseEvent(b *testing.B) {
 		`}`)
 
 	var event Event
+	b.ReportAllocs()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		event.UnmarshalJSON(input)
@@ -1582,6 +1907,47 @@ func TestEvent_Serialize(t *testing.T) {
 	}
 }
 
+func TestEvent_Serialize_canonical(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"plain", "powa", `"powa"`},
+		{"html", "<a href=\"x\">&amp;</a>", `"<a href=\"x\">&amp;</a>"`},
+		{"line break", "a\nb", `"a\nb"`},
+		{"double quote", `"`, `"\""`},
+		{"backslash", `\`, `"\\"`},
+		{"carriage return", "\r", `"\r"`},
+		{"tab", "\t", `"\t"`},
+		{"backspace", "\b", `"\b"`},
+		{"form feed", "\f", `"\f"`},
+		{"other control chars", "\x00\x01\x1f\x7f", "\"\x00\x01\x1f\x7f\""},
+		{"line separator", "\u2028\u2029", "\"\u2028\u2029\""},
+		{"multibyte", "ぽわ〜🍣", `"ぽわ〜🍣"`},
+		{"slash", "a/b", `"a/b"`},
+		{"escaped sequence", `\u003c`, `"\\u003c"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev := &Event{
+				Pubkey:    "dbf0becf24bf8dd7d779d7fb547e6112964ff042b77a42cc2d8488636eed9f5e",
+				CreatedAt: 1693157791,
+				Kind:      1,
+				Tags:      []Tag{{"t", tt.content}},
+				Content:   tt.content,
+			}
+			want := `[0,"dbf0becf24bf8dd7d779d7fb547e6112964ff042b77a42cc2d8488636eed9f5e",1693157791,1,[["t",` +
+				tt.want + `]],` + tt.want + `]`
+
+			got, err := ev.Serialize()
+			assert.NoError(t, err)
+			assert.Equal(t, want, string(got))
+		})
+	}
+}
+
 func TestEvent_VerifyID(t *testing.T) {
 	tests := []struct {
 		name  string
@@ -0,0 +1,182 @@
+package mocrelay
+
+import (
+	"context"
+	"strconv"
+	"sync"
+)
+
+type Counter interface {
+	Inc()
+}
+
+// LabeledCounter is a family of counters distinguished by label values.
+type LabeledCounter interface {
+	Inc(labels ...string)
+}
+
+// Adder is a counter of amounts such as bytes.
+type Adder interface {
+	Add(float64)
+}
+
+// Observer observes values such as durations in seconds.
+type Observer interface {
+	Observe(float64)
+}
+
+type RelayMetrics struct {
+	SendTimeoutTotal       Counter
+	UpgradeRejectedTotal   Counter
+	SendQueueDropTotal     Counter
+	ConnLimitRejectedTotal Counter
+
+	// EventsTotal counts EVENT decisions labeled with the kind
+	// and "accepted" or "rejected".
+	EventsTotal LabeledCounter
+
+	// RecvBytesTotal and SendBytesTotal count the bytes of client and server messages.
+	RecvBytesTotal Adder
+	SendBytesTotal Adder
+
+	// TopPubkeys counts received events by their pubkeys.
+	TopPubkeys *TopK
+	// TopIPs counts received client messages by the IPs of the clients.
+	TopIPs *TopK
+
+	// pending remembers the kinds of events passed to handlers until their OK messages
+	// as map[connID]map[eventID]kind.
+	mu      sync.Mutex
+	pending map[string]map[string]int64
+}
+
+func (m *RelayMetrics) incSendTimeout() {
+	if m == nil {
+		return
+	}
+	incCounter(m.SendTimeoutTotal)
+}
+
+func (m *RelayMetrics) incUpgradeRejected() {
+	if m == nil {
+		return
+	}
+	incCounter(m.UpgradeRejectedTotal)
+}
+
+func (m *RelayMetrics) incSendQueueDrop() {
+	if m == nil {
+		return
+	}
+	incCounter(m.SendQueueDropTotal)
+}
+
+func (m *RelayMetrics) incConnLimitRejected() {
+	if m == nil {
+		return
+	}
+	incCounter(m.ConnLimitRejectedTotal)
+}
+
+func (m *RelayMetrics) addRecvBytes(n int) {
+	if m == nil || m.RecvBytesTotal == nil {
+		return
+	}
+	m.RecvBytesTotal.Add(float64(n))
+}
+
+func (m *RelayMetrics) addSendBytes(n int) {
+	if m == nil || m.SendBytesTotal == nil {
+		return
+	}
+	m.SendBytesTotal.Add(float64(n))
+}
+
+// recvMsg counts msg received from the client of ctx.
+func (m *RelayMetrics) recvMsg(ctx context.Context, msg ClientMsg) {
+	if m == nil {
+		return
+	}
+	m.TopIPs.Add(GetRealIP(ctx))
+	if ev, ok := msg.(*ClientEventMsg); ok {
+		m.TopPubkeys.Add(ev.Event.Pubkey)
+	}
+}
+
+func (m *RelayMetrics) incEvent(kind int64, accepted bool) {
+	if m == nil || m.EventsTotal == nil {
+		return
+	}
+	result := "rejected"
+	if accepted {
+		result = "accepted"
+	}
+	m.EventsTotal.Inc(strconv.FormatInt(kind, 10), result)
+}
+
+// track remembers the kind of the event passed to the handler until its OK message is sent.
+func (m *RelayMetrics) track(ctx context.Context, msg ClientMsg) {
+	ev, ok := msg.(*ClientEventMsg)
+	if m == nil || m.EventsTotal == nil || !ok {
+		return
+	}
+
+	connID := GetRequestID(ctx)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.pending == nil {
+		m.pending = make(map[string]map[string]int64)
+	}
+	kinds := m.pending[connID]
+	if kinds == nil {
+		kinds = make(map[string]int64)
+		m.pending[connID] = kinds
+	}
+	kinds[ev.Event.ID] = ev.Event.Kind
+}
+
+// recordSend counts the decision of the OK message sent to the client of ctx.
+func (m *RelayMetrics) recordSend(ctx context.Context, msg ServerMsg) {
+	ok, isOK := msg.(*ServerOKMsg)
+	if m == nil || m.EventsTotal == nil || !isOK {
+		return
+	}
+
+	connID := GetRequestID(ctx)
+
+	m.mu.Lock()
+	kind, found := m.pending[connID][ok.EventID]
+	delete(m.pending[connID], ok.EventID)
+	m.mu.Unlock()
+
+	if found {
+		m.incEvent(kind, ok.Accepted)
+	}
+}
+
+// forget drops events of the connection which were not answered.
+func (m *RelayMetrics) forget(ctx context.Context) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.pending, GetRequestID(ctx))
+}
+
+func incCounter(c Counter) {
+	if c == nil {
+		return
+	}
+	c.Inc()
+}
+
+func observe(o Observer, v float64) {
+	if o == nil {
+		return
+	}
+	o.Observe(v)
+}
@@ -0,0 +1,354 @@
+// Package otlp pushes mocrelay metrics to an OpenTelemetry collector
+// with OTLP/HTTP in the JSON encoding.
+//
+// Counters are exported as cumulative monotonic sums and observations as
+// cumulative histograms.
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/high-moctane/mocrelay"
+)
+
+type Option struct {
+	// ServiceName is the service.name resource attribute. The default is "mocrelay".
+	ServiceName string
+	// Interval is the interval between pushes. The default is 10 seconds.
+	Interval time.Duration
+	// Buckets are the upper bounds of histogram buckets.
+	// The default is the same as the one of Prometheus.
+	Buckets []float64
+	// Header is added to push requests, such as for authorization.
+	Header http.Header
+	// Client is the HTTP client. The default is http.DefaultClient.
+	Client *http.Client
+	// Logger logs failed pushes if not nil.
+	Logger *slog.Logger
+}
+
+func (opt *Option) serviceName() string {
+	if opt == nil || opt.ServiceName == "" {
+		return "mocrelay"
+	}
+	return opt.ServiceName
+}
+
+func (opt *Option) interval() time.Duration {
+	if opt == nil || opt.Interval == 0 {
+		return 10 * time.Second
+	}
+	return opt.Interval
+}
+
+func (opt *Option) buckets() []float64 {
+	if opt == nil || len(opt.Buckets) == 0 {
+		return []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	}
+	return opt.Buckets
+}
+
+func (opt *Option) header() http.Header {
+	if opt == nil {
+		return nil
+	}
+	return opt.Header
+}
+
+func (opt *Option) client() *http.Client {
+	if opt == nil || opt.Client == nil {
+		return http.DefaultClient
+	}
+	return opt.Client
+}
+
+func (opt *Option) logger() *slog.Logger {
+	if opt == nil {
+		return nil
+	}
+	return opt.Logger
+}
+
+// Sink aggregates metrics in memory and Run pushes them periodically.
+type Sink struct {
+	url     string
+	opt     *Option
+	buckets []float64
+	start   time.Time
+
+	mu    sync.Mutex
+	sums  map[seriesKey]*sum
+	hists map[seriesKey]*histogram
+}
+
+// seriesKey identifies a series by its name and labels joined with "\xff".
+type seriesKey struct {
+	name   string
+	labels string
+}
+
+type sum struct {
+	labels []string
+	value  float64
+}
+
+type histogram struct {
+	labels []string
+	count  uint64
+	sum    float64
+	// counts are the counts of buckets and the last is the one of +Inf.
+	counts []uint64
+}
+
+var _ mocrelay.MetricsSink = (*Sink)(nil)
+
+// New returns a Sink pushing metrics to the collector at endpoint
+// such as "http://localhost:4318". The path "/v1/metrics" is appended.
+func New(endpoint string, option *Option) *Sink {
+	return &Sink{
+		url:     strings.TrimSuffix(endpoint, "/") + "/v1/metrics",
+		opt:     option,
+		buckets: option.buckets(),
+		start:   time.Now(),
+		sums:    make(map[seriesKey]*sum),
+		hists:   make(map[seriesKey]*histogram),
+	}
+}
+
+func (s *Sink) Add(name string, value float64, labels ...string) {
+	key := seriesKey{name: name, labels: strings.Join(labels, "\xff")}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.sums[key]
+	if !ok {
+		m = &sum{labels: slices.Clone(labels)}
+		s.sums[key] = m
+	}
+	m.value += value
+}
+
+func (s *Sink) Observe(name string, value float64, labels ...string) {
+	key := seriesKey{name: name, labels: strings.Join(labels, "\xff")}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h, ok := s.hists[key]
+	if !ok {
+		h = &histogram{labels: slices.Clone(labels), counts: make([]uint64, len(s.buckets)+1)}
+		s.hists[key] = h
+	}
+	h.count++
+	h.sum += value
+	i, _ := slices.BinarySearch(s.buckets, value)
+	h.counts[i]++
+}
+
+// The types below are the JSON encoding of ExportMetricsServiceRequest.
+// 64 bit integers are encoded as strings.
+
+type exportRequest struct {
+	ResourceMetrics []resourceMetrics `json:"resourceMetrics"`
+}
+
+type resourceMetrics struct {
+	Resource     resource       `json:"resource"`
+	ScopeMetrics []scopeMetrics `json:"scopeMetrics"`
+}
+
+type resource struct {
+	Attributes []attribute `json:"attributes"`
+}
+
+type scopeMetrics struct {
+	Scope   scope    `json:"scope"`
+	Metrics []metric `json:"metrics"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type metric struct {
+	Name      string         `json:"name"`
+	Sum       *sumData       `json:"sum,omitempty"`
+	Histogram *histogramData `json:"histogram,omitempty"`
+}
+
+const aggregationTemporalityCumulative = 2
+
+type sumData struct {
+	AggregationTemporality int               `json:"aggregationTemporality"`
+	IsMonotonic            bool              `json:"isMonotonic"`
+	DataPoints             []numberDataPoint `json:"dataPoints"`
+}
+
+type numberDataPoint struct {
+	Attributes        []attribute `json:"attributes"`
+	StartTimeUnixNano string      `json:"startTimeUnixNano"`
+	TimeUnixNano      string      `json:"timeUnixNano"`
+	AsDouble          float64     `json:"asDouble"`
+}
+
+type histogramData struct {
+	AggregationTemporality int                  `json:"aggregationTemporality"`
+	DataPoints             []histogramDataPoint `json:"dataPoints"`
+}
+
+type histogramDataPoint struct {
+	Attributes        []attribute `json:"attributes"`
+	StartTimeUnixNano string      `json:"startTimeUnixNano"`
+	TimeUnixNano      string      `json:"timeUnixNano"`
+	Count             string      `json:"count"`
+	Sum               float64     `json:"sum"`
+	BucketCounts      []string    `json:"bucketCounts"`
+	ExplicitBounds    []float64   `json:"explicitBounds"`
+}
+
+type attribute struct {
+	Key   string         `json:"key"`
+	Value attributeValue `json:"value"`
+}
+
+type attributeValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+func attributes(labels []string) []attribute {
+	ret := make([]attribute, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		ret = append(ret, attribute{Key: labels[i], Value: attributeValue{labels[i+1]}})
+	}
+	return ret
+}
+
+func unixNano(t time.Time) string { return strconv.FormatInt(t.UnixNano(), 10) }
+
+// request returns the request of the current values of the metrics
+// sorted by their names.
+func (s *Sink) request(now time.Time) *exportRequest {
+	start, ts := unixNano(s.start), unixNano(now)
+	metrics := make(map[string]*metric)
+	get := func(name string) *metric {
+		m, ok := metrics[name]
+		if !ok {
+			m = &metric{Name: name}
+			metrics[name] = m
+		}
+		return m
+	}
+
+	s.mu.Lock()
+	for key, v := range s.sums {
+		m := get(key.name)
+		if m.Sum == nil {
+			m.Sum = &sumData{
+				AggregationTemporality: aggregationTemporalityCumulative,
+				IsMonotonic:            true,
+			}
+		}
+		m.Sum.DataPoints = append(m.Sum.DataPoints, numberDataPoint{
+			Attributes:        attributes(v.labels),
+			StartTimeUnixNano: start,
+			TimeUnixNano:      ts,
+			AsDouble:          v.value,
+		})
+	}
+	for key, h := range s.hists {
+		m := get(key.name)
+		if m.Histogram == nil {
+			m.Histogram = &histogramData{AggregationTemporality: aggregationTemporalityCumulative}
+		}
+		counts := make([]string, len(h.counts))
+		for i, c := range h.counts {
+			counts[i] = strconv.FormatUint(c, 10)
+		}
+		m.Histogram.DataPoints = append(m.Histogram.DataPoints, histogramDataPoint{
+			Attributes:        attributes(h.labels),
+			StartTimeUnixNano: start,
+			TimeUnixNano:      ts,
+			Count:             strconv.FormatUint(h.count, 10),
+			Sum:               h.sum,
+			BucketCounts:      counts,
+			ExplicitBounds:    s.buckets,
+		})
+	}
+	s.mu.Unlock()
+
+	sorted := make([]metric, 0, len(metrics))
+	for _, m := range metrics {
+		sorted = append(sorted, *m)
+	}
+	slices.SortFunc(sorted, func(a, b metric) int { return strings.Compare(a.Name, b.Name) })
+
+	return &exportRequest{ResourceMetrics: []resourceMetrics{{
+		Resource: resource{Attributes: attributes([]string{"service.name", s.opt.serviceName()})},
+		ScopeMetrics: []scopeMetrics{{
+			Scope:   scope{Name: "github.com/high-moctane/mocrelay"},
+			Metrics: sorted,
+		}},
+	}}}
+}
+
+// Push sends the current values of the metrics to the collector.
+func (s *Sink) Push(ctx context.Context) error {
+	b, err := json.Marshal(s.request(time.Now()))
+	if err != nil {
+		return fmt.Errorf("failed to marshal metrics: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	for k, vs := range s.opt.header() {
+		req.Header[k] = vs
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.opt.client().Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to push metrics: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("failed to push metrics: %s", resp.Status)
+	}
+	return nil
+}
+
+// Run pushes the metrics every Interval until ctx is done.
+// Failed pushes are retried with the latest values at the next interval.
+func (s *Sink) Run(ctx context.Context) error {
+	ticker := time.NewTicker(s.opt.interval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-ticker.C:
+			if err := s.Push(ctx); err != nil {
+				if logger := s.opt.logger(); logger != nil {
+					logger.WarnContext(ctx, "failed to push metrics", "err", err)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package otlp
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSink_Push(t *testing.T) {
+	reqs := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		reqs <- r
+		bodies <- b
+	}))
+	defer srv.Close()
+
+	s := New(srv.URL+"/", &Option{
+		Buckets: []float64{0.1, 1},
+		Header:  http.Header{"Authorization": {"Bearer token"}},
+	})
+	s.Add("mocrelay_events_total", 1, "kind", "1", "result", "accepted")
+	s.Add("mocrelay_events_total", 2, "kind", "1", "result", "accepted")
+	s.Observe("mocrelay_query_seconds", 0.1)
+	s.Observe("mocrelay_query_seconds", 5)
+
+	require.NoError(t, s.Push(context.Background()))
+
+	r := <-reqs
+	assert.Equal(t, "/v1/metrics", r.URL.Path)
+	assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+	assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+
+	var got exportRequest
+	require.NoError(t, json.Unmarshal(<-bodies, &got))
+	require.Len(t, got.ResourceMetrics, 1)
+	assert.Equal(
+		t,
+		[]attribute{{Key: "service.name", Value: attributeValue{"mocrelay"}}},
+		got.ResourceMetrics[0].Resource.Attributes,
+	)
+
+	metrics := got.ResourceMetrics[0].ScopeMetrics[0].Metrics
+	require.Len(t, metrics, 2)
+
+	assert.Equal(t, "mocrelay_events_total", metrics[0].Name)
+	require.Len(t, metrics[0].Sum.DataPoints, 1)
+	assert.True(t, metrics[0].Sum.IsMonotonic)
+	assert.Equal(t, 3.0, metrics[0].Sum.DataPoints[0].AsDouble)
+	assert.Equal(t, []attribute{
+		{Key: "kind", Value: attributeValue{"1"}},
+		{Key: "result", Value: attributeValue{"accepted"}},
+	}, metrics[0].Sum.DataPoints[0].Attributes)
+
+	assert.Equal(t, "mocrelay_query_seconds", metrics[1].Name)
+	require.Len(t, metrics[1].Histogram.DataPoints, 1)
+	p := metrics[1].Histogram.DataPoints[0]
+	assert.Equal(t, "2", p.Count)
+	assert.Equal(t, 5.1, p.Sum)
+	assert.Equal(t, []string{"1", "0", "1"}, p.BucketCounts)
+	assert.Equal(t, []float64{0.1, 1}, p.ExplicitBounds)
+}
+
+func TestSink_Push_error(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	s := New(srv.URL, nil)
+	assert.Error(t, s.Push(context.Background()))
+}
+
+func TestSink_Run(t *testing.T) {
+	pushed := make(chan struct{}, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pushed <- struct{}{}
+	}))
+	defer srv.Close()
+
+	s := New(srv.URL, &Option{Interval: 10 * time.Millisecond})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.Run(ctx) }()
+
+	select {
+	case <-pushed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("not pushed")
+	}
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+}
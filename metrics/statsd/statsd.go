@@ -0,0 +1,145 @@
+// Package statsd sends mocrelay metrics to a statsd server over UDP.
+//
+// Counters are sent as "c" and observations as "h" metrics.
+// Labels are sent as DogStatsD tags, which servers without tag support ignore
+// or reject depending on the implementation.
+package statsd
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/high-moctane/mocrelay"
+)
+
+type Option struct {
+	// Prefix is prepended to metric names such as "relay.".
+	Prefix string
+	// MaxPacketSize is the max size of a UDP packet. The default is 1432.
+	MaxPacketSize int
+	// FlushInterval is the max interval between packets. The default is 1 second.
+	FlushInterval time.Duration
+}
+
+func (opt *Option) prefix() string {
+	if opt == nil {
+		return ""
+	}
+	return opt.Prefix
+}
+
+func (opt *Option) maxPacketSize() int {
+	if opt == nil || opt.MaxPacketSize == 0 {
+		return 1432
+	}
+	return opt.MaxPacketSize
+}
+
+func (opt *Option) flushInterval() time.Duration {
+	if opt == nil || opt.FlushInterval == 0 {
+		return time.Second
+	}
+	return opt.FlushInterval
+}
+
+// Sink buffers metrics and sends them in packets of up to MaxPacketSize.
+// Run sends the buffered ones periodically.
+type Sink struct {
+	conn net.Conn
+	opt  *Option
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+var _ mocrelay.MetricsSink = (*Sink)(nil)
+
+// New returns a Sink sending metrics to the UDP address addr such as "localhost:8125".
+func New(addr string, option *Option) (*Sink, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &Sink{conn: conn, opt: option}, nil
+}
+
+func (s *Sink) Add(name string, value float64, labels ...string) {
+	s.write(name, value, "c", labels)
+}
+
+func (s *Sink) Observe(name string, value float64, labels ...string) {
+	s.write(name, value, "h", labels)
+}
+
+func (s *Sink) write(name string, value float64, typ string, labels []string) {
+	line := appendLine(nil, s.opt.prefix()+name, value, typ, labels)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.buf) > 0 && len(s.buf)+1+len(line) > s.opt.maxPacketSize() {
+		s.flushLocked()
+	}
+	if len(s.buf) > 0 {
+		s.buf = append(s.buf, '\n')
+	}
+	s.buf = append(s.buf, line...)
+}
+
+// appendLine appends a metric line such as "name:1|c|#kind:1".
+func appendLine(dst []byte, name string, value float64, typ string, labels []string) []byte {
+	dst = append(dst, name...)
+	dst = append(dst, ':')
+	dst = strconv.AppendFloat(dst, value, 'f', -1, 64)
+	dst = append(dst, '|')
+	dst = append(dst, typ...)
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i == 0 {
+			dst = append(dst, "|#"...)
+		} else {
+			dst = append(dst, ',')
+		}
+		dst = append(dst, labels[i]...)
+		dst = append(dst, ':')
+		dst = append(dst, labels[i+1]...)
+	}
+	return dst
+}
+
+// Flush sends the buffered metrics.
+func (s *Sink) Flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flushLocked()
+}
+
+func (s *Sink) flushLocked() {
+	if len(s.buf) == 0 {
+		return
+	}
+	// Metrics are lost on errors such as no listeners, like other statsd clients.
+	s.conn.Write(s.buf)
+	s.buf = s.buf[:0]
+}
+
+// Run flushes the metrics every FlushInterval until ctx is done,
+// and then flushes the rest and closes the connection.
+func (s *Sink) Run(ctx context.Context) error {
+	ticker := time.NewTicker(s.opt.flushInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.Flush()
+			s.conn.Close()
+			return ctx.Err()
+
+		case <-ticker.C:
+			s.Flush()
+		}
+	}
+}
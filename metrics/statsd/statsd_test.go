@@ -0,0 +1,74 @@
+package statsd
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func listen(t *testing.T) net.PacketConn {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func readPacket(t *testing.T, conn net.PacketConn) string {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 2048)
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+	return string(buf[:n])
+}
+
+func TestSink(t *testing.T) {
+	conn := listen(t)
+	s, err := New(conn.LocalAddr().String(), &Option{Prefix: "relay."})
+	require.NoError(t, err)
+
+	s.Add("events_total", 1, "kind", "1", "result", "accepted")
+	s.Observe("query_seconds", 0.25)
+	s.Flush()
+
+	assert.Equal(
+		t,
+		"relay.events_total:1|c|#kind:1,result:accepted\nrelay.query_seconds:0.25|h",
+		readPacket(t, conn),
+	)
+}
+
+func TestSink_maxPacketSize(t *testing.T) {
+	conn := listen(t)
+	s, err := New(conn.LocalAddr().String(), &Option{MaxPacketSize: 30})
+	require.NoError(t, err)
+
+	s.Add("a_total", 1)
+	s.Add("b_total", 1)
+	s.Add("c_total", 1)
+	assert.Equal(t, "a_total:1|c\nb_total:1|c", readPacket(t, conn))
+
+	s.Flush()
+	assert.Equal(t, "c_total:1|c", readPacket(t, conn))
+}
+
+func TestSink_Run(t *testing.T) {
+	conn := listen(t)
+	s, err := New(conn.LocalAddr().String(), &Option{FlushInterval: 10 * time.Millisecond})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.Run(ctx) }()
+
+	s.Add("a_total", 2)
+	assert.Equal(t, "a_total:2|c", readPacket(t, conn))
+
+	s.Add("b_total", 1)
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+	assert.Equal(t, "b_total:1|c", readPacket(t, conn))
+}
@@ -0,0 +1,90 @@
+package mocrelay
+
+// MetricsSink receives metrics for monitoring systems other than Prometheus.
+// Labels are pairs of names and values. It must be safe for concurrent use.
+//
+// The metrics/statsd and metrics/otlp packages implement it.
+type MetricsSink interface {
+	// Add adds value to the counter name.
+	Add(name string, value float64, labels ...string)
+
+	// Observe records value of the distribution name such as durations in seconds.
+	Observe(name string, value float64, labels ...string)
+}
+
+// NewSinkCounter returns a counter of name reported to sink.
+func NewSinkCounter(sink MetricsSink, name string, labels ...string) *SinkCounter {
+	return &SinkCounter{sink: sink, name: name, labels: labels}
+}
+
+// SinkCounter is a Counter and an Adder reported to a MetricsSink.
+type SinkCounter struct {
+	sink   MetricsSink
+	name   string
+	labels []string
+}
+
+var (
+	_ Counter = (*SinkCounter)(nil)
+	_ Adder   = (*SinkCounter)(nil)
+)
+
+func (c *SinkCounter) Inc() { c.sink.Add(c.name, 1, c.labels...) }
+
+func (c *SinkCounter) Add(v float64) { c.sink.Add(c.name, v, c.labels...) }
+
+// NewSinkLabeledCounter returns a counter of name reported to sink
+// whose label values are named labelNames.
+func NewSinkLabeledCounter(sink MetricsSink, name string, labelNames ...string) LabeledCounter {
+	return &sinkLabeledCounter{sink: sink, name: name, labelNames: labelNames}
+}
+
+type sinkLabeledCounter struct {
+	sink       MetricsSink
+	name       string
+	labelNames []string
+}
+
+func (c *sinkLabeledCounter) Inc(labels ...string) {
+	pairs := make([]string, 0, 2*len(c.labelNames))
+	for i, name := range c.labelNames {
+		if i < len(labels) {
+			pairs = append(pairs, name, labels[i])
+		}
+	}
+	c.sink.Add(c.name, 1, pairs...)
+}
+
+// NewSinkObserver returns an observer of name reported to sink.
+func NewSinkObserver(sink MetricsSink, name string, labels ...string) Observer {
+	return &sinkObserver{sink: sink, name: name, labels: labels}
+}
+
+type sinkObserver struct {
+	sink   MetricsSink
+	name   string
+	labels []string
+}
+
+func (o *sinkObserver) Observe(v float64) { o.sink.Observe(o.name, v, o.labels...) }
+
+// NewSinkRelayMetrics returns RelayMetrics reported to sink
+// with the same names as the ones of the middleware/prometheus package.
+func NewSinkRelayMetrics(sink MetricsSink) *RelayMetrics {
+	return &RelayMetrics{
+		SendTimeoutTotal:       NewSinkCounter(sink, "mocrelay_send_timeout_total"),
+		UpgradeRejectedTotal:   NewSinkCounter(sink, "mocrelay_upgrade_rejected_total"),
+		SendQueueDropTotal:     NewSinkCounter(sink, "mocrelay_send_queue_drop_total"),
+		ConnLimitRejectedTotal: NewSinkCounter(sink, "mocrelay_conn_limit_rejected_total"),
+		EventsTotal: NewSinkLabeledCounter(
+			sink,
+			"mocrelay_events_total",
+			"kind",
+			"result",
+		),
+		RecvBytesTotal: NewSinkCounter(sink, "mocrelay_recv_bytes_total"),
+		SendBytesTotal: NewSinkCounter(sink, "mocrelay_send_bytes_total"),
+		TopPubkeys:     NewTopK(0),
+		TopIPs:         NewTopK(0),
+	}
+}
@@ -0,0 +1,77 @@
+package mocrelay
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type sinkRecord struct {
+	name   string
+	value  float64
+	labels []string
+}
+
+type testMetricsSink struct {
+	mu       sync.Mutex
+	added    []sinkRecord
+	observed []sinkRecord
+}
+
+func (s *testMetricsSink) Add(name string, value float64, labels ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.added = append(s.added, sinkRecord{name, value, labels})
+}
+
+func (s *testMetricsSink) Observe(name string, value float64, labels ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.observed = append(s.observed, sinkRecord{name, value, labels})
+}
+
+func TestSinkCounter(t *testing.T) {
+	sink := new(testMetricsSink)
+	c := NewSinkCounter(sink, "a_total", "k", "v")
+	c.Inc()
+	c.Add(3)
+
+	assert.Equal(t, []sinkRecord{
+		{"a_total", 1, []string{"k", "v"}},
+		{"a_total", 3, []string{"k", "v"}},
+	}, sink.added)
+}
+
+func TestSinkLabeledCounter(t *testing.T) {
+	sink := new(testMetricsSink)
+	c := NewSinkLabeledCounter(sink, "events_total", "kind", "result")
+	c.Inc("1", "accepted")
+	c.Inc("7")
+
+	assert.Equal(t, []sinkRecord{
+		{"events_total", 1, []string{"kind", "1", "result", "accepted"}},
+		{"events_total", 1, []string{"kind", "7"}},
+	}, sink.added)
+}
+
+func TestSinkObserver(t *testing.T) {
+	sink := new(testMetricsSink)
+	NewSinkObserver(sink, "query_seconds", "op", "req").Observe(0.5)
+
+	assert.Equal(t, []sinkRecord{
+		{"query_seconds", 0.5, []string{"op", "req"}},
+	}, sink.observed)
+}
+
+func TestNewSinkRelayMetrics(t *testing.T) {
+	sink := new(testMetricsSink)
+	m := NewSinkRelayMetrics(sink)
+	m.EventsTotal.Inc("1", "accepted")
+	m.RecvBytesTotal.Add(10)
+
+	assert.Equal(t, []sinkRecord{
+		{"mocrelay_events_total", 1, []string{"kind", "1", "result", "accepted"}},
+		{"mocrelay_recv_bytes_total", 10, nil},
+	}, sink.added)
+}
@@ -0,0 +1,113 @@
+package mocrelay
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+type testLabeledCounter struct {
+	mu sync.Mutex
+	m  map[string]int
+}
+
+func (c *testLabeledCounter) Inc(labels ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.m == nil {
+		c.m = make(map[string]int)
+	}
+	c.m[strings.Join(labels, ",")]++
+}
+
+func (c *testLabeledCounter) get(labels ...string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.m[strings.Join(labels, ",")]
+}
+
+type testAdder struct {
+	mu sync.Mutex
+	v  float64
+}
+
+func (a *testAdder) Add(v float64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.v += v
+}
+
+func (a *testAdder) get() float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.v
+}
+
+func TestRelay_metrics(t *testing.T) {
+	h := HandlerFunc(func(r *http.Request, recv <-chan ClientMsg, send chan<- ServerMsg) error {
+		for msg := range recv {
+			if m, ok := msg.(*ClientEventMsg); ok {
+				send <- NewServerOKMsg(m.Event.ID, true, ServerOKMsgPrefixNoPrefix, "")
+			}
+		}
+		return nil
+	})
+
+	var events testLabeledCounter
+	var recvBytes, sendBytes testAdder
+	metrics := &RelayMetrics{
+		EventsTotal:    &events,
+		RecvBytesTotal: &recvBytes,
+		SendBytesTotal: &sendBytes,
+		TopPubkeys:     NewTopK(10),
+		TopIPs:         NewTopK(10),
+	}
+	relay := NewRelay(h, &RelayOption{
+		Metrics:       metrics,
+		ContentPolicy: &ContentPolicy{MaxContentLength: 10},
+	})
+	srv := httptest.NewServer(relay)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	require.NoError(t, err)
+	defer conn.Close(websocket.StatusNormalClosure, "")
+
+	accepted := signTestEvent(t, &Event{CreatedAt: time.Now().Unix(), Kind: 1, Tags: []Tag{}})
+	tooLong := signTestEvent(t, &Event{
+		CreatedAt: time.Now().Unix(),
+		Kind:      7,
+		Tags:      []Tag{},
+		Content:   strings.Repeat("a", 11),
+	})
+
+	var sent int
+	for _, ev := range []*Event{accepted, tooLong} {
+		b, err := json.Marshal([]any{"EVENT", ev})
+		require.NoError(t, err)
+		require.NoError(t, conn.Write(ctx, websocket.MessageText, b))
+		sent += len(b)
+		_, _, err = conn.Read(ctx)
+		require.NoError(t, err)
+	}
+
+	assert.Eventually(t, func() bool {
+		return events.get("1", "accepted") == 1 && events.get("7", "rejected") == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, float64(sent), recvBytes.get())
+	assert.Positive(t, sendBytes.get())
+	assert.Equal(t, []TopKEntry{{Key: accepted.Pubkey, Count: 2}}, metrics.TopPubkeys.Top(1))
+	assert.Equal(t, []TopKEntry{{Key: "127.0.0.1", Count: 2}}, metrics.TopIPs.Top(1))
+}
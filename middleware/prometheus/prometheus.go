@@ -3,6 +3,7 @@ package prometheus
 import (
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/high-moctane/mocrelay"
 	"github.com/prometheus/client_golang/prometheus"
@@ -12,15 +13,21 @@ type PrometheusMiddleware mocrelay.SimpleMiddleware
 
 func NewPrometheusMiddleware(reg prometheus.Registerer) PrometheusMiddleware {
 	m := newSimplePrometheusMiddleware(reg)
-	return PrometheusMiddleware(mocrelay.NewSimpleMiddleware(m))
+	simple := mocrelay.NewSimpleMiddleware(m)
+	timing := mocrelay.NewReqTimingMiddleware(m.observeReqTiming)
+	return func(h mocrelay.Handler) mocrelay.Handler { return simple(timing(h)) }
 }
 
 type simplePrometheusMiddleware struct {
-	connectionCount prometheus.Gauge
-	recvMsgTotal    *prometheus.CounterVec
-	recvEventTotal  *prometheus.CounterVec
-	sendMsgTotal    *prometheus.CounterVec
-	reqTotal        prometheus.GaugeFunc
+	connectionCount    prometheus.Gauge
+	recvMsgTotal       *prometheus.CounterVec
+	recvEventTotal     *prometheus.CounterVec
+	eventLagSeconds    *prometheus.HistogramVec
+	sendMsgTotal       *prometheus.CounterVec
+	reqTotal           prometheus.GaugeFunc
+	reqFirstMsgSeconds prometheus.Histogram
+	reqQuerySeconds    prometheus.Histogram
+	reqSendSeconds     prometheus.Histogram
 
 	reqCounter *reqCounter
 }
@@ -47,6 +54,16 @@ func newSimplePrometheusMiddleware(reg prometheus.Registerer) *simplePrometheusM
 			},
 			[]string{"kind"},
 		),
+		eventLagSeconds: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name: "mocrelay_event_lag_seconds",
+				Help: "Received time minus created_at of received events. Negative is future-dated.",
+				Buckets: []float64{
+					-86400, -3600, -600, -60, -10, 0, 10, 60, 600, 3600, 86400, 604800, 2592000,
+				},
+			},
+			[]string{"kind"},
+		),
 		sendMsgTotal: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "mocrelay_send_msg_total",
@@ -61,6 +78,21 @@ func newSimplePrometheusMiddleware(reg prometheus.Registerer) *simplePrometheusM
 			},
 			func() float64 { return float64(reqCounter.Count()) },
 		),
+		reqFirstMsgSeconds: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "mocrelay_req_first_msg_seconds",
+			Help:    "Time from receiving a req to the first server message for it.",
+			Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14),
+		}),
+		reqQuerySeconds: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "mocrelay_req_query_seconds",
+			Help:    "Time spent querying the cache or the store for a req.",
+			Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14),
+		}),
+		reqSendSeconds: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "mocrelay_req_send_seconds",
+			Help:    "Time from the first server message of a req to its eose.",
+			Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14),
+		}),
 
 		reqCounter: reqCounter,
 	}
@@ -68,12 +100,146 @@ func newSimplePrometheusMiddleware(reg prometheus.Registerer) *simplePrometheusM
 	reg.MustRegister(m.connectionCount)
 	reg.MustRegister(m.recvMsgTotal)
 	reg.MustRegister(m.recvEventTotal)
+	reg.MustRegister(m.eventLagSeconds)
 	reg.MustRegister(m.sendMsgTotal)
 	reg.MustRegister(m.reqTotal)
+	reg.MustRegister(m.reqFirstMsgSeconds)
+	reg.MustRegister(m.reqQuerySeconds)
+	reg.MustRegister(m.reqSendSeconds)
 
 	return m
 }
 
+func RegisterVerifier(reg prometheus.Registerer, v *mocrelay.Verifier) {
+	reg.MustRegister(prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "mocrelay_verify_queue_depth",
+			Help: "Current number of events waiting for signature verification.",
+		},
+		func() float64 { return float64(v.QueueDepth()) },
+	))
+	reg.MustRegister(prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "mocrelay_verify_in_flight",
+			Help: "Current number of events being verified.",
+		},
+		func() float64 { return float64(v.InFlight()) },
+	))
+}
+
+func RegisterSessions(reg prometheus.Registerer, router *mocrelay.RouterHandler) {
+	count := func(f func(sub *mocrelay.SubscriptionInfo) int) float64 {
+		var n int
+		for _, sess := range router.Sessions() {
+			for i := range sess.Subscriptions {
+				n += f(&sess.Subscriptions[i])
+			}
+		}
+		return float64(n)
+	}
+
+	reg.MustRegister(prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "mocrelay_live_subscriptions",
+			Help: "Current number of subscriptions waiting for new events.",
+		},
+		func() float64 { return count(func(*mocrelay.SubscriptionInfo) int { return 1 }) },
+	))
+	reg.MustRegister(prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "mocrelay_live_filters",
+			Help: "Current number of filters of subscriptions waiting for new events.",
+		},
+		func() float64 {
+			return count(func(sub *mocrelay.SubscriptionInfo) int { return len(sub.Filters) })
+		},
+	))
+	reg.MustRegister(prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "mocrelay_fanout_pending",
+			Help: "Current number of published events waiting for the fanout workers.",
+		},
+		func() float64 { return float64(router.FanoutPending()) },
+	))
+}
+
+// NewDeliveryLatencyHistogram returns the histogram of seconds from publishing events
+// to queueing them for connections.
+func NewDeliveryLatencyHistogram(reg prometheus.Registerer) prometheus.Histogram {
+	h := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "mocrelay_delivery_latency_seconds",
+		Help:    "Seconds from publishing events to queueing them for subscriptions.",
+		Buckets: prometheus.ExponentialBuckets(0.0001, 4, 10),
+	})
+	reg.MustRegister(h)
+	return h
+}
+
+func NewExpensiveFilterCounter(reg prometheus.Registerer) prometheus.Counter {
+	c := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "mocrelay_expensive_filter_total",
+		Help: "Number of REQ and COUNT with expensive filters.",
+	})
+	reg.MustRegister(c)
+	return c
+}
+
+// NewVerifierCacheCounters returns the counters of hits and misses of the signature cache.
+func NewVerifierCacheCounters(reg prometheus.Registerer) (hits, misses prometheus.Counter) {
+	c := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mocrelay_verify_cache_total",
+		Help: "Number of signature cache lookups by result.",
+	}, []string{"result"})
+	reg.MustRegister(c)
+	return c.WithLabelValues("hit"), c.WithLabelValues("miss")
+}
+
+// SetUpgradeRuleCounters sets the Matched counters of rules labeled with their names.
+func SetUpgradeRuleCounters(reg prometheus.Registerer, rules []*mocrelay.UpgradeRule) {
+	c := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mocrelay_upgrade_rule_matched_total",
+		Help: "Number of websocket upgrade requests decided by upgrade rules.",
+	}, []string{"rule", "action"})
+	reg.MustRegister(c)
+
+	for _, rule := range rules {
+		action := "reject"
+		if rule.Allow {
+			action = "allow"
+		}
+		rule.Matched = c.WithLabelValues(rule.Name, action)
+	}
+}
+
+func RegisterCache(reg prometheus.Registerer, h *mocrelay.CacheHandler) {
+	reg.MustRegister(prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "mocrelay_cache_bytes",
+			Help: "Approximate memory usage of cached events.",
+		},
+		func() float64 { return float64(h.Bytes()) },
+	))
+}
+
+func NewCacheEvictionCounter(reg prometheus.Registerer) prometheus.Counter {
+	c := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "mocrelay_cache_evictions_total",
+		Help: "Number of events evicted from the cache.",
+	})
+	reg.MustRegister(c)
+	return c
+}
+
+// NewEventSinkFailureCounter returns the counter of events which are not written into sinks.
+func NewEventSinkFailureCounter(reg prometheus.Registerer) prometheus.Counter {
+	c := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "mocrelay_event_sink_failures_total",
+		Help: "Number of events failed to be written into sinks or dropped.",
+	})
+	reg.MustRegister(c)
+	return c
+}
+
 func (m *simplePrometheusMiddleware) HandleStart(r *http.Request) (*http.Request, error) {
 	m.connectionCount.Inc()
 
@@ -104,6 +270,8 @@ func (m *simplePrometheusMiddleware) HandleClientMsg(
 		m.recvMsgTotal.WithLabelValues("EVENT").Inc()
 		k := strconv.FormatInt(msg.Event.Kind, 10)
 		m.recvEventTotal.WithLabelValues(k).Inc()
+		lag := time.Since(msg.Event.CreatedAtTime())
+		m.eventLagSeconds.WithLabelValues(k).Observe(lag.Seconds())
 
 	case *mocrelay.ClientReqMsg:
 		m.recvMsgTotal.WithLabelValues("REQ").Inc()
@@ -155,6 +323,9 @@ func (m *simplePrometheusMiddleware) HandleServerMsg(
 	case *mocrelay.ServerCountMsg:
 		m.sendMsgTotal.WithLabelValues("COUNT").Inc()
 
+	case *mocrelay.ServerClosedMsg:
+		m.sendMsgTotal.WithLabelValues("CLOSED").Inc()
+
 	default:
 		m.sendMsgTotal.WithLabelValues("UNDEFINED").Inc()
 	}
@@ -166,6 +337,15 @@ func (m *simplePrometheusMiddleware) HandleServerMsg(
 	return res, nil
 }
 
+func (m *simplePrometheusMiddleware) observeReqTiming(
+	r *http.Request,
+	timing *mocrelay.ReqTiming,
+) {
+	m.reqFirstMsgSeconds.Observe(timing.TimeToFirstMsg.Seconds())
+	m.reqQuerySeconds.Observe(timing.Query.Seconds())
+	m.reqSendSeconds.Observe(timing.Send.Seconds())
+}
+
 type reqCounter struct {
 	// chan map[reqID]chan map[subID]exist
 	c chan map[string]chan map[string]bool
@@ -231,3 +411,70 @@ func (c *reqCounter) Count() int {
 
 	return ret
 }
+
+func NewRelayMetrics(reg prometheus.Registerer) *mocrelay.RelayMetrics {
+	sendTimeoutTotal := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "mocrelay_send_timeout_total",
+		Help: "Number of connections closed by send timeout.",
+	})
+
+	upgradeRejectedTotal := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "mocrelay_upgrade_rejected_total",
+		Help: "Number of rejected websocket upgrade requests.",
+	})
+
+	sendQueueDropTotal := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "mocrelay_send_queue_drop_total",
+		Help: "Number of server messages dropped by send queue overflow.",
+	})
+
+	connLimitRejectedTotal := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "mocrelay_conn_limit_rejected_total",
+		Help: "Number of websocket upgrades rejected by connection limits.",
+	})
+
+	eventsTotal := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mocrelay_events_total",
+			Help: "Number of EVENT decisions by kind and result.",
+		},
+		[]string{"kind", "result"},
+	)
+
+	recvBytesTotal := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "mocrelay_recv_bytes_total",
+		Help: "Bytes of received client messages.",
+	})
+
+	sendBytesTotal := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "mocrelay_send_bytes_total",
+		Help: "Bytes of sent server messages.",
+	})
+
+	reg.MustRegister(sendTimeoutTotal)
+	reg.MustRegister(upgradeRejectedTotal)
+	reg.MustRegister(sendQueueDropTotal)
+	reg.MustRegister(connLimitRejectedTotal)
+	reg.MustRegister(eventsTotal)
+	reg.MustRegister(recvBytesTotal)
+	reg.MustRegister(sendBytesTotal)
+
+	return &mocrelay.RelayMetrics{
+		SendTimeoutTotal:       sendTimeoutTotal,
+		UpgradeRejectedTotal:   upgradeRejectedTotal,
+		SendQueueDropTotal:     sendQueueDropTotal,
+		ConnLimitRejectedTotal: connLimitRejectedTotal,
+		EventsTotal:            labeledCounter{eventsTotal},
+		RecvBytesTotal:         recvBytesTotal,
+		SendBytesTotal:         sendBytesTotal,
+		TopPubkeys:             mocrelay.NewTopK(0),
+		TopIPs:                 mocrelay.NewTopK(0),
+	}
+}
+
+// labeledCounter adapts a prometheus.CounterVec to mocrelay.LabeledCounter.
+type labeledCounter struct {
+	vec *prometheus.CounterVec
+}
+
+func (c labeledCounter) Inc(labels ...string) { c.vec.WithLabelValues(labels...).Inc() }
@@ -0,0 +1,88 @@
+package mocrelay
+
+import "net/http"
+
+// RejectEventFunc returns a middleware which rejects EVENT with OK false
+// and the reason unless f returns true.
+func RejectEventFunc(f func(*Event) (ok bool, reason string)) Middleware {
+	return Middleware(NewSimpleMiddleware(&simpleFuncMiddleware{
+		clientMsg: func(msg ClientMsg) ServerMsg {
+			m, isEvent := msg.(*ClientEventMsg)
+			if !isEvent {
+				return nil
+			}
+			if ok, reason := f(m.Event); !ok {
+				return NewServerOKMsg(m.Event.ID, false, ServerOkMsgPrefixBlocked, reason)
+			}
+			return nil
+		},
+	}))
+}
+
+// RejectReqFunc returns a middleware which closes REQ with CLOSED
+// and the reason unless f returns true.
+func RejectReqFunc(f func(*ClientReqMsg) (ok bool, reason string)) Middleware {
+	return Middleware(NewSimpleMiddleware(&simpleFuncMiddleware{
+		clientMsg: func(msg ClientMsg) ServerMsg {
+			m, isReq := msg.(*ClientReqMsg)
+			if !isReq {
+				return nil
+			}
+			if ok, reason := f(m); !ok {
+				return NewServerClosedMsg(m.SubscriptionID, ServerClosedMsgPrefixRestricted, reason)
+			}
+			return nil
+		},
+	}))
+}
+
+// EventFilterFunc returns a middleware which sends events to clients only if f returns true.
+// It doesn't affect the events clients publish.
+func EventFilterFunc(f func(*Event) bool) Middleware {
+	return Middleware(NewSimpleMiddleware(&simpleFuncMiddleware{
+		serverMsg: func(msg ServerMsg) bool {
+			m, isEvent := msg.(*ServerEventMsg)
+			return !isEvent || f(m.Event)
+		},
+	}))
+}
+
+var _ SimpleMiddlewareInterface = (*simpleFuncMiddleware)(nil)
+
+// simpleFuncMiddleware is a stateless middleware of functions.
+type simpleFuncMiddleware struct {
+	// clientMsg returns the reply to a rejected message or nil to pass it through.
+	clientMsg func(ClientMsg) ServerMsg
+	// serverMsg reports whether to send a message.
+	serverMsg func(ServerMsg) bool
+}
+
+func (m *simpleFuncMiddleware) HandleStart(r *http.Request) (*http.Request, error) {
+	return r, nil
+}
+
+func (m *simpleFuncMiddleware) HandleStop(r *http.Request) error {
+	return nil
+}
+
+func (m *simpleFuncMiddleware) HandleClientMsg(
+	r *http.Request,
+	msg ClientMsg,
+) (<-chan ClientMsg, <-chan ServerMsg, error) {
+	if m.clientMsg != nil {
+		if reply := m.clientMsg(msg); reply != nil {
+			return nil, newClosedBufCh(reply), nil
+		}
+	}
+	return newClosedBufCh(msg), nil, nil
+}
+
+func (m *simpleFuncMiddleware) HandleServerMsg(
+	r *http.Request,
+	msg ServerMsg,
+) (<-chan ServerMsg, error) {
+	if m.serverMsg != nil && !m.serverMsg(msg) {
+		return nil, nil
+	}
+	return newClosedBufCh(msg), nil
+}
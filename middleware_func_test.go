@@ -0,0 +1,94 @@
+package mocrelay
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// runFuncMiddleware sends msg through m to a handler which replies OK to EVENT
+// and an event and EOSE to REQ, and returns the first reply.
+func runFuncMiddleware(t *testing.T, m Middleware, msg ClientMsg) ServerMsg {
+	var h Handler = HandlerFunc(
+		func(r *http.Request, recv <-chan ClientMsg, send chan<- ServerMsg) error {
+			for msg := range recv {
+				switch msg := msg.(type) {
+				case *ClientEventMsg:
+					send <- NewServerOKMsg(msg.Event.ID, true, ServerOKMsgPrefixNoPrefix, "")
+				case *ClientReqMsg:
+					send <- NewServerEventMsg(msg.SubscriptionID, &Event{ID: "hidden", Kind: 4})
+					send <- NewServerEventMsg(msg.SubscriptionID, &Event{ID: "public", Kind: 1})
+					send <- NewServerEOSEMsg(msg.SubscriptionID)
+				}
+			}
+			return ErrRecvClosed
+		},
+	)
+	h = m(h)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	r := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+	recv := make(chan ClientMsg)
+	send := make(chan ServerMsg)
+
+	go h.Handle(r, recv, send)
+
+	recv <- msg
+	select {
+	case msg := <-send:
+		return msg
+	case <-ctx.Done():
+		t.Fatal("timeout")
+		return nil
+	}
+}
+
+func TestRejectEventFunc(t *testing.T) {
+	m := RejectEventFunc(func(event *Event) (bool, string) {
+		return event.Kind != 4, "no dm"
+	})
+
+	got := runFuncMiddleware(t, m, &ClientEventMsg{Event: &Event{ID: "a", Kind: 1}})
+	assert.Equal(t, NewServerOKMsg("a", true, ServerOKMsgPrefixNoPrefix, ""), got)
+
+	got = runFuncMiddleware(t, m, &ClientEventMsg{Event: &Event{ID: "b", Kind: 4}})
+	assert.Equal(t, NewServerOKMsg("b", false, ServerOkMsgPrefixBlocked, "no dm"), got)
+}
+
+func TestRejectReqFunc(t *testing.T) {
+	m := RejectReqFunc(func(msg *ClientReqMsg) (bool, string) {
+		return len(msg.ReqFilters) == 1, "one filter only"
+	})
+
+	got := runFuncMiddleware(t, m, &ClientReqMsg{
+		SubscriptionID: "sub",
+		ReqFilters:     []*ReqFilter{{}, {}},
+	})
+	assert.Equal(
+		t,
+		NewServerClosedMsg("sub", ServerClosedMsgPrefixRestricted, "one filter only"),
+		got,
+	)
+
+	got = runFuncMiddleware(t, m, &ClientReqMsg{
+		SubscriptionID: "sub",
+		ReqFilters:     []*ReqFilter{{}},
+	})
+	assert.IsType(t, &ServerEventMsg{}, got)
+}
+
+func TestEventFilterFunc(t *testing.T) {
+	m := EventFilterFunc(func(event *Event) bool { return event.Kind != 4 })
+
+	got := runFuncMiddleware(t, m, &ClientReqMsg{
+		SubscriptionID: "sub",
+		ReqFilters:     []*ReqFilter{{}},
+	})
+	assert.Equal(t, NewServerEventMsg("sub", &Event{ID: "public", Kind: 1}), got)
+}
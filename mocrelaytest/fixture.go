@@ -0,0 +1,134 @@
+package mocrelaytest
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/high-moctane/mocrelay"
+)
+
+// Generator generates signed events and filters matching some of them.
+// The same seed generates the same fixtures except for signatures.
+// It is not goroutine safe.
+type Generator struct {
+	rng  *rand.Rand
+	keys []*mocrelay.Keypair
+	// Kinds are the kinds of generated events.
+	Kinds []int64
+	// Since is the min created_at of generated events.
+	Since int64
+	// Span is the range of created_at of generated events in seconds.
+	Span int64
+}
+
+// NewGenerator returns a Generator with authors keypairs.
+func NewGenerator(seed uint64, authors int) *Generator {
+	if authors < 1 {
+		panic(fmt.Sprintf("authors must be positive but got %d", authors))
+	}
+
+	g := &Generator{
+		rng:   rand.New(rand.NewPCG(seed, seed)),
+		Kinds: []int64{0, 1, 3, 7, 30023},
+		Since: 1700000000,
+		Span:  3600,
+	}
+	for len(g.keys) < authors {
+		b := make([]byte, 32)
+		for i := range b {
+			b[i] = byte(g.rng.UintN(256))
+		}
+		// Almost all the random keys are valid.
+		if key, err := mocrelay.ParseKeypair(hex.EncodeToString(b)); err == nil {
+			g.keys = append(g.keys, key)
+		}
+	}
+	return g
+}
+
+// Pubkeys returns the pubkeys of the authors.
+func (g *Generator) Pubkeys() []string {
+	ret := make([]string, len(g.keys))
+	for i, key := range g.keys {
+		ret[i] = key.Pubkey()
+	}
+	return ret
+}
+
+// Event returns an event by a random author with a random kind and created_at.
+func (g *Generator) Event() *mocrelay.Event {
+	kind := g.Kinds[g.rng.IntN(len(g.Kinds))]
+	tags := []mocrelay.Tag{{"t", fmt.Sprintf("tag%d", g.rng.IntN(4))}}
+	if (&mocrelay.Event{Kind: kind}).EventType() == mocrelay.EventTypeParamReplaceable {
+		tags = append(tags, mocrelay.Tag{"d", fmt.Sprintf("d%d", g.rng.IntN(4))})
+	}
+	return SignedEvent(
+		g.keys[g.rng.IntN(len(g.keys))],
+		&mocrelay.Event{
+			CreatedAt: g.Since + g.rng.Int64N(g.Span),
+			Kind:      kind,
+			Tags:      tags,
+			Content:   fmt.Sprintf("content %d", g.rng.Uint64()),
+		},
+	)
+}
+
+// Events returns n events by Event.
+func (g *Generator) Events(n int) []*mocrelay.Event {
+	ret := make([]*mocrelay.Event, n)
+	for i := range ret {
+		ret[i] = g.Event()
+	}
+	return ret
+}
+
+// SignedEvent signs event with key. It panics if signing fails.
+func SignedEvent(key *mocrelay.Keypair, event *mocrelay.Event) *mocrelay.Event {
+	if event.Tags == nil {
+		event.Tags = []mocrelay.Tag{}
+	}
+	if err := key.Sign(event); err != nil {
+		panic(err)
+	}
+	return event
+}
+
+// Filter returns a filter with some random conditions on the generated authors,
+// kinds, tags and created_at.
+func (g *Generator) Filter() *mocrelay.ReqFilter {
+	b := mocrelay.NewFilterBuilder()
+	if g.rng.IntN(2) == 0 {
+		b.Authors(g.keys[g.rng.IntN(len(g.keys))].Pubkey())
+	}
+	if g.rng.IntN(2) == 0 {
+		b.Kinds(g.Kinds[g.rng.IntN(len(g.Kinds))])
+	}
+	if g.rng.IntN(4) == 0 {
+		b.Tag("t", fmt.Sprintf("tag%d", g.rng.IntN(4)))
+	}
+	since, until := int64(-1), int64(-1)
+	if g.rng.IntN(4) == 0 {
+		since = g.Since + g.rng.Int64N(g.Span)
+	}
+	if g.rng.IntN(4) == 0 {
+		until = g.Since + g.rng.Int64N(g.Span)
+	}
+	if since >= 0 && until >= 0 && since > until {
+		since, until = until, since
+	}
+	if since >= 0 {
+		b.Since(time.Unix(since, 0))
+	}
+	if until >= 0 {
+		b.Until(time.Unix(until, 0))
+	}
+	if g.rng.IntN(4) == 0 {
+		b.Limit(1 + g.rng.Int64N(10))
+	}
+	return b.MustBuild()
+}
+
+// Int64 returns a pointer to v for Since, Until and Limit of filters.
+func Int64(v int64) *int64 { return &v }
@@ -0,0 +1,116 @@
+package mocrelaytest
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/high-moctane/mocrelay"
+)
+
+var _ mocrelay.Handler = (*Handler)(nil)
+
+// Handler is a scripted mocrelay.Handler. It replies to each message with Reply
+// and records the received messages.
+type Handler struct {
+	// Reply returns the messages sent in reply to msg. Nil Reply replies nothing.
+	Reply func(msg mocrelay.ClientMsg) []mocrelay.ServerMsg
+
+	mu       sync.Mutex
+	received []mocrelay.ClientMsg
+}
+
+func NewHandler(reply func(msg mocrelay.ClientMsg) []mocrelay.ServerMsg) *Handler {
+	return &Handler{Reply: reply}
+}
+
+func (h *Handler) Handle(
+	r *http.Request,
+	recv <-chan mocrelay.ClientMsg,
+	send chan<- mocrelay.ServerMsg,
+) error {
+	ctx := r.Context()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case msg, ok := <-recv:
+			if !ok {
+				return mocrelay.ErrRecvClosed
+			}
+
+			h.mu.Lock()
+			h.received = append(h.received, msg)
+			h.mu.Unlock()
+
+			if h.Reply == nil {
+				continue
+			}
+			for _, m := range h.Reply(msg) {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case send <- m:
+				}
+			}
+		}
+	}
+}
+
+// Received returns the messages received so far.
+func (h *Handler) Received() []mocrelay.ClientMsg {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]mocrelay.ClientMsg(nil), h.received...)
+}
+
+// AcceptAll replies OK true to EVENT, EOSE to REQ and zero to COUNT.
+func AcceptAll(msg mocrelay.ClientMsg) []mocrelay.ServerMsg {
+	switch msg := msg.(type) {
+	case *mocrelay.ClientEventMsg:
+		return []mocrelay.ServerMsg{
+			mocrelay.NewServerOKMsg(msg.Event.ID, true, mocrelay.ServerOKMsgPrefixNoPrefix, ""),
+		}
+	case *mocrelay.ClientReqMsg:
+		return []mocrelay.ServerMsg{mocrelay.NewServerEOSEMsg(msg.SubscriptionID)}
+	case *mocrelay.ClientCountMsg:
+		return []mocrelay.ServerMsg{mocrelay.NewServerCountMsg(msg.SubscriptionID, 0, nil)}
+	default:
+		return nil
+	}
+}
+
+// RejectAll returns a Reply which replies OK false to EVENT and CLOSED to REQ
+// with prefix and reason such as "blocked: ".
+func RejectAll(prefix, reason string) func(mocrelay.ClientMsg) []mocrelay.ServerMsg {
+	return func(msg mocrelay.ClientMsg) []mocrelay.ServerMsg {
+		switch msg := msg.(type) {
+		case *mocrelay.ClientEventMsg:
+			return []mocrelay.ServerMsg{mocrelay.NewServerOKMsg(msg.Event.ID, false, prefix, reason)}
+		case *mocrelay.ClientReqMsg:
+			return []mocrelay.ServerMsg{
+				mocrelay.NewServerClosedMsg(msg.SubscriptionID, prefix, reason),
+			}
+		default:
+			return nil
+		}
+	}
+}
+
+// Script returns a Reply which replies replies[i] to the i-th message
+// and nothing to the messages after them.
+func Script(replies ...[]mocrelay.ServerMsg) func(mocrelay.ClientMsg) []mocrelay.ServerMsg {
+	var mu sync.Mutex
+	i := 0
+	return func(mocrelay.ClientMsg) []mocrelay.ServerMsg {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if i >= len(replies) {
+			return nil
+		}
+		i++
+		return replies[i-1]
+	}
+}
@@ -0,0 +1,44 @@
+package mocrelaytest
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/high-moctane/mocrelay"
+)
+
+func TestHandler(t *testing.T) {
+	ev := &mocrelay.Event{ID: "id"}
+	h := NewHandler(Script(
+		AcceptAll(&mocrelay.ClientEventMsg{Event: ev}),
+		RejectAll(mocrelay.ServerOkMsgPrefixBlocked, "no")(&mocrelay.ClientEventMsg{Event: ev}),
+	))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	r := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+	recv := make(chan mocrelay.ClientMsg)
+	send := make(chan mocrelay.ServerMsg, 3)
+	done := make(chan error)
+	go func() { done <- h.Handle(r, recv, send) }()
+
+	for i := 0; i < 3; i++ {
+		recv <- &mocrelay.ClientEventMsg{Event: ev}
+	}
+	close(recv)
+	assert.ErrorIs(t, <-done, mocrelay.ErrRecvClosed)
+
+	assert.Len(t, h.Received(), 3)
+	assert.Equal(t, mocrelay.NewServerOKMsg("id", true, "", ""), <-send)
+	assert.Equal(
+		t,
+		mocrelay.NewServerOKMsg("id", false, mocrelay.ServerOkMsgPrefixBlocked, "no"),
+		<-send,
+	)
+	assert.Len(t, send, 0)
+}
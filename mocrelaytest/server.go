@@ -0,0 +1,178 @@
+package mocrelaytest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"nhooyr.io/websocket"
+
+	"github.com/high-moctane/mocrelay"
+)
+
+// DefaultTimeout is how long a Client waits for a message by default.
+const DefaultTimeout = 5 * time.Second
+
+// Server is a relay on an httptest.Server. It is closed at the end of the test.
+type Server struct {
+	*httptest.Server
+	Relay *mocrelay.Relay
+}
+
+// NewRelayHandler returns the handler stack of a relay with an in-memory cache:
+// stored events are replayed to REQ and published events are sent to subscribers.
+func NewRelayHandler() mocrelay.Handler {
+	router := mocrelay.NewRouterHandler(100)
+	return mocrelay.NewMergeHandler(
+		mocrelay.NewCacheHandler(100),
+		mocrelay.NewSendEventUniqueFilterMiddleware(10)(router),
+	)
+}
+
+// NewServer starts a relay of h. Nil h means NewRelayHandler.
+func NewServer(t testing.TB, h mocrelay.Handler, option *mocrelay.RelayOption) *Server {
+	t.Helper()
+
+	if h == nil {
+		h = NewRelayHandler()
+	}
+	relay := mocrelay.NewRelay(h, option)
+	srv := httptest.NewServer(relay)
+	t.Cleanup(srv.Close)
+
+	return &Server{Server: srv, Relay: relay}
+}
+
+// WSURL returns the websocket URL of the relay.
+func (s *Server) WSURL() string {
+	return "ws" + strings.TrimPrefix(s.URL, "http")
+}
+
+// Dial connects a Client to the relay. It is closed at the end of the test.
+func (s *Server) Dial(t testing.TB) *Client {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	conn, _, err := websocket.Dial(ctx, s.WSURL(), nil)
+	if err != nil {
+		cancel()
+		t.Fatalf("failed to dial: %v", err)
+	}
+
+	c := &Client{
+		t:       t,
+		Timeout: DefaultTimeout,
+		conn:    conn,
+		ctx:     ctx,
+		cancel:  cancel,
+		msgs:    make(chan string, 100),
+		done:    make(chan struct{}),
+	}
+	go c.read()
+	t.Cleanup(c.Close)
+
+	return c
+}
+
+// Client is a websocket client of a Server which sends and receives raw JSON messages.
+// Failures are reported to the test.
+type Client struct {
+	t testing.TB
+
+	// Timeout is how long Recv waits for a message.
+	Timeout time.Duration
+
+	conn   *websocket.Conn
+	ctx    context.Context
+	cancel context.CancelFunc
+	msgs   chan string
+	done   chan struct{}
+	err    error
+}
+
+// read receives messages in the background since a read timeout closes the connection.
+func (c *Client) read() {
+	defer close(c.done)
+	for {
+		_, b, err := c.conn.Read(c.ctx)
+		if err != nil {
+			c.err = err
+			return
+		}
+		c.msgs <- string(b)
+	}
+}
+
+// Send sends msg such as `["REQ","sub",{"kinds":[1]}]`.
+func (c *Client) Send(msg string) {
+	c.t.Helper()
+
+	ctx, cancel := context.WithTimeout(c.ctx, c.Timeout)
+	defer cancel()
+
+	if err := c.conn.Write(ctx, websocket.MessageText, []byte(msg)); err != nil {
+		c.t.Fatalf("failed to send %s: %v", msg, err)
+	}
+}
+
+// SendEvent sends EVENT of event.
+func (c *Client) SendEvent(event *mocrelay.Event) {
+	c.t.Helper()
+
+	b, err := json.Marshal([]any{"EVENT", event})
+	if err != nil {
+		c.t.Fatalf("failed to marshal event: %v", err)
+	}
+	c.Send(string(b))
+}
+
+// Recv returns the next message. It fails the test after Timeout.
+func (c *Client) Recv() string {
+	c.t.Helper()
+
+	select {
+	case msg := <-c.msgs:
+		return msg
+	case <-c.done:
+		c.t.Fatalf("connection closed: %v", c.err)
+	case <-time.After(c.Timeout):
+		c.t.Fatalf("no message in %s", c.Timeout)
+	}
+	return ""
+}
+
+// Expect receives the next message and checks that it is the same JSON as want.
+func (c *Client) Expect(want string) {
+	c.t.Helper()
+
+	got := c.Recv()
+	var w, g any
+	if err := json.Unmarshal([]byte(want), &w); err != nil {
+		c.t.Fatalf("invalid json %s: %v", want, err)
+	}
+	if err := json.Unmarshal([]byte(got), &g); err != nil || !reflect.DeepEqual(w, g) {
+		c.t.Errorf("want %s but got %s", want, got)
+	}
+}
+
+// ExpectNone checks that no message arrives within d.
+func (c *Client) ExpectNone(d time.Duration) {
+	c.t.Helper()
+
+	select {
+	case msg := <-c.msgs:
+		c.t.Errorf("want no message but got %s", msg)
+	case <-time.After(d):
+	}
+}
+
+// Close closes the connection.
+func (c *Client) Close() {
+	c.conn.Close(websocket.StatusNormalClosure, "")
+	c.cancel()
+	<-c.done
+}
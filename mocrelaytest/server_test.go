@@ -0,0 +1,85 @@
+package mocrelaytest
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/high-moctane/mocrelay"
+)
+
+func eventJSON(t *testing.T, subID string, event *mocrelay.Event) string {
+	b, err := json.Marshal([]any{"EVENT", subID, event})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestServer_event(t *testing.T) {
+	g := NewGenerator(1, 1)
+	ev := SignedEvent(g.keys[0], &mocrelay.Event{CreatedAt: time.Now().Unix(), Kind: 1})
+
+	srv := NewServer(t, nil, nil)
+	c := srv.Dial(t)
+
+	c.SendEvent(ev)
+	c.Expect(`["OK","` + ev.ID + `",true,""]`)
+
+	c.SendEvent(ev)
+	c.Expect(`["OK","` + ev.ID + `",false,"duplicate: already have this event"]`)
+}
+
+func TestServer_reqStoredEvents(t *testing.T) {
+	g := NewGenerator(1, 1)
+	now := time.Now().Unix()
+	ev1 := SignedEvent(g.keys[0], &mocrelay.Event{CreatedAt: now - 1, Kind: 1})
+	ev2 := SignedEvent(g.keys[0], &mocrelay.Event{CreatedAt: now, Kind: 1})
+
+	srv := NewServer(t, nil, nil)
+	c := srv.Dial(t)
+	c.SendEvent(ev1)
+	c.Recv()
+	c.SendEvent(ev2)
+	c.Recv()
+
+	c.Send(`["REQ","sub",{"kinds":[1]}]`)
+	c.Expect(eventJSON(t, "sub", ev2))
+	c.Expect(eventJSON(t, "sub", ev1))
+	c.Expect(`["EOSE","sub"]`)
+}
+
+func TestServer_reqLiveEventsAndClose(t *testing.T) {
+	g := NewGenerator(1, 1)
+	now := time.Now().Unix()
+	ev1 := SignedEvent(g.keys[0], &mocrelay.Event{CreatedAt: now, Kind: 1})
+	ev2 := SignedEvent(g.keys[0], &mocrelay.Event{CreatedAt: now, Kind: 1, Content: "2"})
+	ev3 := SignedEvent(g.keys[0], &mocrelay.Event{CreatedAt: now, Kind: 1, Content: "3"})
+
+	srv := NewServer(t, nil, nil)
+	sub := srv.Dial(t)
+	pub := srv.Dial(t)
+
+	sub.Send(`["REQ","sub",{"kinds":[1]}]`)
+	sub.Expect(`["EOSE","sub"]`)
+
+	pub.SendEvent(ev1)
+	pub.Recv()
+	sub.Expect(eventJSON(t, "sub", ev1))
+
+	// Events not matching the filter are not sent.
+	pub.SendEvent(SignedEvent(g.keys[0], &mocrelay.Event{CreatedAt: now, Kind: 7}))
+	pub.Recv()
+	pub.SendEvent(ev2)
+	pub.Recv()
+	sub.Expect(eventJSON(t, "sub", ev2))
+
+	sub.Send(`["CLOSE","sub"]`)
+	// CLOSE is processed before the next message.
+	sub.Send(`["COUNT","cnt",{"kinds":[1]}]`)
+	sub.Recv()
+
+	pub.SendEvent(ev3)
+	pub.Recv()
+	sub.ExpectNone(100 * time.Millisecond)
+}
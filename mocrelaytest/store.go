@@ -0,0 +1,203 @@
+// Package mocrelaytest provides utilities for testing handlers and middleware
+// of mocrelay without a real database, and a relay on an httptest.Server
+// for end-to-end tests.
+package mocrelaytest
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/high-moctane/mocrelay"
+)
+
+var _ mocrelay.EventStore = (*Store)(nil)
+
+// Store is an in-memory mocrelay.EventStore.
+// It keeps all the events, so it is only for tests.
+type Store struct {
+	mu sync.Mutex
+	// events are in descending order of created_at.
+	events []*mocrelay.Event
+	err    error
+
+	// namespaces are shared by the stores of all namespaces.
+	namespaces *storeNamespaces
+}
+
+type storeNamespaces struct {
+	mu sync.Mutex
+	m  map[string]*Store
+}
+
+// NewStore returns a Store of the namespace "" with events.
+func NewStore(events ...*mocrelay.Event) *Store {
+	s := new(Store)
+	s.namespaces = &storeNamespaces{m: map[string]*Store{"": s}}
+	for _, ev := range events {
+		s.Save(context.Background(), ev)
+	}
+	return s
+}
+
+// Namespace returns the Store of ns, which is empty at first.
+// Errors set by SetErr are not shared between namespaces.
+func (s *Store) Namespace(ns string) mocrelay.EventStore {
+	s.namespaces.mu.Lock()
+	defer s.namespaces.mu.Unlock()
+
+	ret, ok := s.namespaces.m[ns]
+	if !ok {
+		ret = &Store{namespaces: s.namespaces}
+		s.namespaces.m[ns] = ret
+	}
+	return ret
+}
+
+// SetErr makes all the following calls fail with err. Nil err restores them.
+func (s *Store) SetErr(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+// Events returns the stored events in descending order of created_at.
+func (s *Store) Events() []*mocrelay.Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.events)
+}
+
+func (s *Store) Save(ctx context.Context, event *mocrelay.Event) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return false, s.err
+	}
+
+	key, ok := eventKey(event)
+	if !ok {
+		return false, nil
+	}
+	for i, ev := range s.events {
+		if ev.ID == event.ID {
+			return false, nil
+		}
+		if k, _ := eventKey(ev); k == key {
+			if mocrelay.ResolveReplaceable(ev, event) == mocrelay.KeepExisting {
+				return false, nil
+			}
+			s.events = slices.Delete(s.events, i, i+1)
+			break
+		}
+	}
+
+	idx := slices.IndexFunc(s.events, func(ev *mocrelay.Event) bool {
+		return ev.CreatedAt < event.CreatedAt
+	})
+	if idx < 0 {
+		idx = len(s.events)
+	}
+	s.events = slices.Insert(s.events, idx, event)
+	return true, nil
+}
+
+// Delete deletes the events referenced by e tags of deletion with the same pubkey.
+func (s *Store) Delete(ctx context.Context, deletion *mocrelay.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+
+	ids := make(map[string]bool)
+	for _, id := range deletion.Tags.Values("e") {
+		ids[id] = true
+	}
+	s.events = slices.DeleteFunc(s.events, func(ev *mocrelay.Event) bool {
+		return ids[ev.ID] && ev.Pubkey == deletion.Pubkey
+	})
+	return nil
+}
+
+func (s *Store) Query(
+	ctx context.Context,
+	filters []*mocrelay.ReqFilter,
+) ([]*mocrelay.Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	m := mocrelay.NewReqFiltersEventMatchers(filters)
+	var ret []*mocrelay.Event
+	for _, ev := range s.events {
+		if m.Done() {
+			break
+		}
+		if m.CountMatch(ev) {
+			ret = append(ret, ev)
+		}
+	}
+	return ret, nil
+}
+
+func (s *Store) Count(ctx context.Context, filters []*mocrelay.ReqFilter) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return 0, s.err
+	}
+
+	m := mocrelay.NewReqFiltersEventMatchers(filters)
+	var n uint64
+	for _, ev := range s.events {
+		if m.Match(ev) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *Store) PurgePubkey(ctx context.Context, pubkey string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return 0, s.err
+	}
+
+	n := len(s.events)
+	s.events = slices.DeleteFunc(s.events, func(ev *mocrelay.Event) bool {
+		return ev.Pubkey == pubkey
+	})
+	return n - len(s.events), nil
+}
+
+// eventKey returns the key of events which replace each other.
+// Ephemeral events have no key since they are never stored.
+func eventKey(event *mocrelay.Event) (string, bool) {
+	switch event.EventType() {
+	case mocrelay.EventTypeRegular:
+		return event.ID, true
+
+	case mocrelay.EventTypeReplaceable:
+		return fmt.Sprintf("%s:%d", event.Pubkey, event.Kind), true
+
+	case mocrelay.EventTypeParamReplaceable:
+		d, ok := event.Tags.DTag()
+		if !ok {
+			return "", false
+		}
+		return fmt.Sprintf("%s:%d:%s", event.Pubkey, event.Kind, d), true
+
+	default:
+		return "", false
+	}
+}
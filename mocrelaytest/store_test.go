@@ -0,0 +1,116 @@
+package mocrelaytest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/high-moctane/mocrelay"
+)
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	g := NewGenerator(1, 2)
+	key := g.keys[0]
+
+	old := SignedEvent(key, &mocrelay.Event{CreatedAt: 1, Kind: 0})
+	profile := SignedEvent(key, &mocrelay.Event{CreatedAt: 2, Kind: 0})
+	note := SignedEvent(key, &mocrelay.Event{CreatedAt: 3, Kind: 1})
+	ephemeral := SignedEvent(key, &mocrelay.Event{CreatedAt: 4, Kind: 20000})
+
+	s := NewStore(old, note)
+
+	saved, err := s.Save(ctx, note)
+	require.NoError(t, err)
+	assert.False(t, saved)
+
+	saved, err = s.Save(ctx, ephemeral)
+	require.NoError(t, err)
+	assert.False(t, saved)
+
+	saved, err = s.Save(ctx, profile)
+	require.NoError(t, err)
+	assert.True(t, saved)
+	assert.Equal(t, []*mocrelay.Event{note, profile}, s.Events())
+
+	saved, err = s.Save(ctx, old)
+	require.NoError(t, err)
+	assert.False(t, saved)
+
+	evs, err := s.Query(ctx, []*mocrelay.ReqFilter{{Limit: Int64(1)}})
+	require.NoError(t, err)
+	assert.Equal(t, []*mocrelay.Event{note}, evs)
+
+	n, err := s.Count(ctx, []*mocrelay.ReqFilter{{Kinds: []int64{0, 1}}})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), n)
+
+	deletion := SignedEvent(key, &mocrelay.Event{
+		CreatedAt: 5,
+		Kind:      5,
+		Tags:      []mocrelay.Tag{{"e", note.ID}},
+	})
+	require.NoError(t, s.Delete(ctx, deletion))
+	assert.Equal(t, []*mocrelay.Event{profile}, s.Events())
+
+	purged, err := s.PurgePubkey(ctx, key.Pubkey())
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+	assert.Empty(t, s.Events())
+
+	s.SetErr(errors.New("down"))
+	_, err = s.Save(ctx, note)
+	assert.EqualError(t, err, "down")
+}
+
+func TestStore_Namespace(t *testing.T) {
+	ctx := context.Background()
+	key := NewGenerator(1, 1).keys[0]
+	note := SignedEvent(key, &mocrelay.Event{CreatedAt: 1, Kind: 1})
+
+	s := NewStore(note)
+	a := s.Namespace("a")
+	assert.Same(t, a, s.Namespace("a"))
+	assert.Same(t, s, a.Namespace(""))
+
+	saved, err := a.Save(ctx, note)
+	require.NoError(t, err)
+	assert.True(t, saved, "namespaces are isolated")
+
+	purged, err := a.PurgePubkey(ctx, key.Pubkey())
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+	assert.Equal(t, []*mocrelay.Event{note}, s.Events())
+}
+
+// TestStore_generated checks queries of Store with the generated fixtures.
+func TestStore_generated(t *testing.T) {
+	ctx := context.Background()
+	g := NewGenerator(2, 3)
+
+	s := NewStore()
+	var want []*mocrelay.Event
+	for _, ev := range g.Events(50) {
+		if saved, _ := s.Save(ctx, ev); saved {
+			want = append(want, ev)
+		}
+	}
+
+	for i := 0; i < 20; i++ {
+		filter := g.Filter()
+		got, err := s.Query(ctx, []*mocrelay.ReqFilter{filter})
+		require.NoError(t, err)
+
+		m := mocrelay.NewReqFilterMatcher(filter)
+		for _, ev := range got {
+			assert.True(t, m.Match(ev))
+		}
+		if filter.Limit != nil {
+			assert.LessOrEqual(t, int64(len(got)), *filter.Limit)
+		}
+	}
+	assert.NotEmpty(t, want)
+}
@@ -0,0 +1,294 @@
+package mocrelay
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+var (
+	ErrModerationNotFound      = errors.New("moderation action not found")
+	ErrModerationWindowExpired = errors.New("moderation undo window expired")
+)
+
+// PubkeyPurger deletes all events by a pubkey, such as CacheHandler or an event store.
+type PubkeyPurger interface {
+	PurgePubkey(ctx context.Context, pubkey string) (int, error)
+}
+
+type ModeratorOption struct {
+	UndoWindow time.Duration
+
+	// PurgeInterval is the interval Moderator.Run purges expired actions at.
+	// Default is 1 minute.
+	PurgeInterval time.Duration
+
+	// Purgers are called by Moderator.PurgePubkey and for expired pubkey bans.
+	Purgers []PubkeyPurger
+
+	// OnPurgeEvent and OnPurgePubkey are called for purged actions,
+	// such as to persist them across restarts.
+	OnPurgeEvent  func(id string)
+	OnPurgePubkey func(pubkey string)
+
+	// Logger logs failed purges of expired pubkey bans if not nil.
+	Logger *slog.Logger
+}
+
+func (opt *ModeratorOption) undoWindow() time.Duration {
+	const defaultUndoWindow = 24 * time.Hour
+
+	if opt == nil || opt.UndoWindow == 0 {
+		return defaultUndoWindow
+	}
+
+	return opt.UndoWindow
+}
+
+func (opt *ModeratorOption) purgeInterval() time.Duration {
+	const defaultPurgeInterval = time.Minute
+
+	if opt == nil || opt.PurgeInterval <= 0 {
+		return defaultPurgeInterval
+	}
+
+	return opt.PurgeInterval
+}
+
+type Moderator struct {
+	opt *ModeratorOption
+
+	mu sync.Mutex
+	// map[eventID]action
+	events map[string]*moderationAction
+	// map[pubkey]action
+	pubkeys map[string]*moderationAction
+}
+
+type ModerationAction struct {
+	Target    string
+	Reason    string
+	CreatedAt time.Time
+	Purged    bool
+}
+
+type moderationAction struct {
+	ModerationAction
+	purgeAt time.Time
+}
+
+func NewModerator(option *ModeratorOption) *Moderator {
+	return &Moderator{
+		opt:     option,
+		events:  make(map[string]*moderationAction),
+		pubkeys: make(map[string]*moderationAction),
+	}
+}
+
+func (m *Moderator) newAction(target, reason string) *moderationAction {
+	now := time.Now()
+	return &moderationAction{
+		ModerationAction: ModerationAction{
+			Target:    target,
+			Reason:    reason,
+			CreatedAt: now,
+		},
+		purgeAt: now.Add(m.opt.undoWindow()),
+	}
+}
+
+func (m *Moderator) DeleteEvent(id, reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.events[id]; ok {
+		return
+	}
+	m.events[id] = m.newAction(id, reason)
+}
+
+func (m *Moderator) UndeleteEvent(id string) error {
+	m.Purge()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.undo(m.events, id)
+}
+
+func (m *Moderator) BanPubkey(pubkey, reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.pubkeys[pubkey]; ok {
+		return
+	}
+	m.pubkeys[pubkey] = m.newAction(pubkey, reason)
+}
+
+func (m *Moderator) UnbanPubkey(pubkey string) error {
+	m.Purge()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.undo(m.pubkeys, pubkey)
+}
+
+// PurgePubkey deletes all events by pubkey from the purgers right away
+// and leaves a tombstone which rejects its events and cannot be undone.
+// It returns the number of deleted events.
+func (m *Moderator) PurgePubkey(ctx context.Context, pubkey, reason string) (int, error) {
+	m.mu.Lock()
+	action, ok := m.pubkeys[pubkey]
+	if !ok {
+		action = m.newAction(pubkey, reason)
+		m.pubkeys[pubkey] = action
+	}
+	action.Purged = true
+	m.mu.Unlock()
+
+	return m.purgePubkey(ctx, pubkey)
+}
+
+func (m *Moderator) purgePubkey(ctx context.Context, pubkey string) (int, error) {
+	if m.opt == nil {
+		return 0, nil
+	}
+
+	var n int
+	var errs []error
+	for _, p := range m.opt.Purgers {
+		deleted, err := p.PurgePubkey(ctx, pubkey)
+		n += deleted
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if m.opt.OnPurgePubkey != nil {
+		m.opt.OnPurgePubkey(pubkey)
+	}
+
+	return n, errors.Join(errs...)
+}
+
+func (m *Moderator) undo(actions map[string]*moderationAction, target string) error {
+	action, ok := actions[target]
+	if !ok {
+		return ErrModerationNotFound
+	}
+	if action.Purged {
+		return ErrModerationWindowExpired
+	}
+	delete(actions, target)
+	return nil
+}
+
+func (m *Moderator) IsEventDeleted(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, ok := m.events[id]
+	return ok
+}
+
+func (m *Moderator) IsPubkeyBanned(pubkey string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, ok := m.pubkeys[pubkey]
+	return ok
+}
+
+func (m *Moderator) Hidden(event *Event) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, deleted := m.events[event.ID]
+	_, banned := m.pubkeys[event.Pubkey]
+	return deleted || banned
+}
+
+func (m *Moderator) DeletedEvents() []ModerationAction {
+	m.Purge()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return collectModerationActions(m.events)
+}
+
+func (m *Moderator) BannedPubkeys() []ModerationAction {
+	m.Purge()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return collectModerationActions(m.pubkeys)
+}
+
+func collectModerationActions(actions map[string]*moderationAction) []ModerationAction {
+	ret := make([]ModerationAction, 0, len(actions))
+	for _, action := range actions {
+		ret = append(ret, action.ModerationAction)
+	}
+	return ret
+}
+
+// Run purges expired actions periodically until ctx is done.
+func (m *Moderator) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.opt.purgeInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.purge(ctx)
+		}
+	}
+}
+
+// Purge makes the actions whose undo window has expired permanent
+// and calls the purge hooks for them.
+func (m *Moderator) Purge() {
+	m.purge(context.Background())
+}
+
+func (m *Moderator) purge(ctx context.Context) {
+	now := time.Now()
+
+	var ids, pubkeys []string
+
+	m.mu.Lock()
+	for id, action := range m.events {
+		if !action.Purged && !now.Before(action.purgeAt) {
+			action.Purged = true
+			ids = append(ids, id)
+		}
+	}
+	for pubkey, action := range m.pubkeys {
+		if !action.Purged && !now.Before(action.purgeAt) {
+			action.Purged = true
+			pubkeys = append(pubkeys, pubkey)
+		}
+	}
+	m.mu.Unlock()
+
+	if m.opt == nil {
+		return
+	}
+	if m.opt.OnPurgeEvent != nil {
+		for _, id := range ids {
+			m.opt.OnPurgeEvent(id)
+		}
+	}
+	for _, pubkey := range pubkeys {
+		if _, err := m.purgePubkey(ctx, pubkey); err != nil && m.opt.Logger != nil {
+			m.opt.Logger.WarnContext(ctx, "failed to purge pubkey", "pubkey", pubkey, "err", err)
+		}
+	}
+}
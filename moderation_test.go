@@ -0,0 +1,146 @@
+package mocrelay
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModerator(t *testing.T) {
+	var purgedEvents, purgedPubkeys []string
+
+	m := NewModerator(&ModeratorOption{
+		UndoWindow:    20 * time.Millisecond,
+		OnPurgeEvent:  func(id string) { purgedEvents = append(purgedEvents, id) },
+		OnPurgePubkey: func(pubkey string) { purgedPubkeys = append(purgedPubkeys, pubkey) },
+	})
+
+	m.DeleteEvent("id0", "spam")
+	m.DeleteEvent("id1", "")
+	m.BanPubkey("pubkey0", "")
+	m.BanPubkey("pubkey1", "")
+
+	assert.True(t, m.IsEventDeleted("id0"))
+	assert.True(t, m.IsPubkeyBanned("pubkey0"))
+	assert.True(t, m.Hidden(&Event{ID: "id1", Pubkey: "other"}))
+	assert.True(t, m.Hidden(&Event{ID: "other", Pubkey: "pubkey1"}))
+	assert.False(t, m.Hidden(&Event{ID: "other", Pubkey: "other"}))
+
+	assert.NoError(t, m.UndeleteEvent("id1"))
+	assert.NoError(t, m.UnbanPubkey("pubkey1"))
+	assert.ErrorIs(t, m.UndeleteEvent("id1"), ErrModerationNotFound)
+	assert.False(t, m.Hidden(&Event{ID: "id1", Pubkey: "pubkey1"}))
+
+	time.Sleep(30 * time.Millisecond)
+	m.Purge()
+
+	// Expired actions are permanent.
+	assert.Equal(t, []string{"id0"}, purgedEvents)
+	assert.Equal(t, []string{"pubkey0"}, purgedPubkeys)
+	assert.ErrorIs(t, m.UndeleteEvent("id0"), ErrModerationWindowExpired)
+	assert.ErrorIs(t, m.UnbanPubkey("pubkey0"), ErrModerationWindowExpired)
+	assert.True(t, m.Hidden(&Event{ID: "id0", Pubkey: "other"}))
+	assert.True(t, m.Hidden(&Event{ID: "other", Pubkey: "pubkey0"}))
+
+	if events := m.DeletedEvents(); assert.Len(t, events, 1) {
+		assert.Equal(t, "id0", events[0].Target)
+		assert.Equal(t, "spam", events[0].Reason)
+		assert.True(t, events[0].Purged)
+	}
+
+	m.Purge()
+	assert.Len(t, purgedEvents, 1)
+}
+
+func TestModerator_Run(t *testing.T) {
+	cache := NewCacheHandler(10)
+	cache.c.c.Add(&Event{ID: "reg0", Pubkey: "pubkey0", Kind: 1})
+
+	purged := make(chan string, 1)
+	m := NewModerator(&ModeratorOption{
+		UndoWindow:    10 * time.Millisecond,
+		PurgeInterval: 10 * time.Millisecond,
+		Purgers:       []PubkeyPurger{cache},
+		OnPurgePubkey: func(pubkey string) { purged <- pubkey },
+	})
+	m.BanPubkey("pubkey0", "spam")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.Run(ctx)
+	}()
+
+	select {
+	case pubkey := <-purged:
+		assert.Equal(t, "pubkey0", pubkey)
+	case <-time.After(time.Second):
+		t.Fatal("expired ban is not purged")
+	}
+	cancel()
+	<-done
+
+	assert.Empty(t, cache.c.c.Find([]*ReqFilter{{}}))
+	assert.True(t, m.IsPubkeyBanned("pubkey0"))
+}
+
+func TestModerator_PurgePubkey(t *testing.T) {
+	ctx := context.Background()
+
+	cache := NewCacheHandler(10)
+	for _, event := range []*Event{
+		{ID: "reg0", Pubkey: "pubkey0", Kind: 1, CreatedAt: 0},
+		{ID: "reg1", Pubkey: "pubkey1", Kind: 1, CreatedAt: 1},
+		{ID: "rep0", Pubkey: "pubkey0", Kind: 0, CreatedAt: 2},
+		{ID: "rep1", Pubkey: "pubkey0", Kind: 0, CreatedAt: 3},
+	} {
+		cache.c.c.Add(event)
+	}
+
+	var purgedPubkeys []string
+	m := NewModerator(&ModeratorOption{
+		Purgers:       []PubkeyPurger{cache},
+		OnPurgePubkey: func(pubkey string) { purgedPubkeys = append(purgedPubkeys, pubkey) },
+	})
+
+	n, err := m.PurgePubkey(ctx, "pubkey0", "illegal")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []string{"pubkey0"}, purgedPubkeys)
+
+	assert.Equal(
+		t,
+		[]*Event{{ID: "reg1", Pubkey: "pubkey1", Kind: 1, CreatedAt: 1}},
+		cache.c.c.Find([]*ReqFilter{{}}),
+	)
+	assert.True(t, m.IsPubkeyBanned("pubkey0"))
+	assert.ErrorIs(t, m.UnbanPubkey("pubkey0"), ErrModerationWindowExpired)
+
+	n, err = m.PurgePubkey(ctx, "pubkey0", "")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+	if pubkeys := m.BannedPubkeys(); assert.Len(t, pubkeys, 1) {
+		assert.Equal(t, "illegal", pubkeys[0].Reason)
+	}
+}
+
+func TestModerator_PurgePubkey_permanent(t *testing.T) {
+	var purgedPubkeys []string
+	m := NewModerator(&ModeratorOption{
+		UndoWindow:    time.Millisecond,
+		OnPurgePubkey: func(pubkey string) { purgedPubkeys = append(purgedPubkeys, pubkey) },
+	})
+
+	_, err := m.PurgePubkey(context.Background(), "pubkey0", "illegal")
+	assert.NoError(t, err)
+
+	time.Sleep(2 * time.Millisecond)
+	m.Purge()
+
+	assert.True(t, m.Hidden(&Event{ID: "id", Pubkey: "pubkey0"}))
+	assert.ErrorIs(t, m.UnbanPubkey("pubkey0"), ErrModerationWindowExpired)
+	assert.Equal(t, []string{"pubkey0"}, purgedPubkeys, "purged once")
+}
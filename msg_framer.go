@@ -0,0 +1,86 @@
+package mocrelay
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrInvalidMsgFrame = errors.New("invalid msg frame")
+
+// msgFramer reassembles client messages, i.e. JSON arrays, from websocket messages
+// which may contain several client messages or a part of one.
+type msgFramer struct {
+	maxLen int64
+
+	buf []byte
+	// pos is the position to resume scanning buf from.
+	pos int
+	// start is the start of the current message or -1 outside messages.
+	start    int
+	depth    int
+	inString bool
+	escaped  bool
+}
+
+func newMsgFramer(maxLen int64) *msgFramer {
+	return &msgFramer{maxLen: maxLen, start: -1}
+}
+
+// Push appends payload and returns the complete messages in it.
+// On errors, the buffered data is discarded.
+func (f *msgFramer) Push(payload []byte) ([][]byte, error) {
+	f.buf = append(f.buf, payload...)
+
+	var msgs [][]byte
+	for ; f.pos < len(f.buf); f.pos++ {
+		c := f.buf[f.pos]
+
+		if f.start < 0 {
+			switch c {
+			case ' ', '\t', '\r', '\n':
+				continue
+			case '[':
+				f.start, f.depth = f.pos, 1
+				continue
+			default:
+				f.reset()
+				return msgs, fmt.Errorf("%w: unexpected %q outside messages", ErrInvalidMsgFrame, c)
+			}
+		}
+
+		switch {
+		case f.escaped:
+			f.escaped = false
+		case f.inString && c == '\\':
+			f.escaped = true
+		case c == '"':
+			f.inString = !f.inString
+		case f.inString:
+		case c == '[' || c == '{':
+			f.depth++
+		case c == ']' || c == '}':
+			f.depth--
+			if f.depth == 0 {
+				msgs = append(msgs, append([]byte(nil), f.buf[f.start:f.pos+1]...))
+				f.start = -1
+			}
+		}
+	}
+
+	if f.start < 0 {
+		f.buf, f.pos = f.buf[:0], 0
+		return msgs, nil
+	}
+	if int64(len(f.buf)-f.start) > f.maxLen {
+		f.reset()
+		return msgs, fmt.Errorf("%w: message is longer than %d bytes", ErrInvalidMsgFrame, f.maxLen)
+	}
+	// Drop the scanned messages before the incomplete one.
+	n := copy(f.buf, f.buf[f.start:])
+	f.buf, f.pos, f.start = f.buf[:n], f.pos-f.start, 0
+	return msgs, nil
+}
+
+func (f *msgFramer) reset() {
+	*f = msgFramer{maxLen: f.maxLen, buf: f.buf[:0], start: -1}
+}
@@ -0,0 +1,85 @@
+package mocrelay
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMsgFramer_Push(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   []string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:  "one message",
+			input: []string{`["REQ","sub",{}]`},
+			want:  []string{`["REQ","sub",{}]`},
+		},
+		{
+			name:  "several messages",
+			input: []string{`["CLOSE","a"]` + "\n" + `["CLOSE","b"] ["CLOSE","c"]`},
+			want:  []string{`["CLOSE","a"]`, `["CLOSE","b"]`, `["CLOSE","c"]`},
+		},
+		{
+			name:  "fragmented",
+			input: []string{`["REQ","sub",`, `{"#t":["]"]}`, `]["CLOSE",`, `"sub"]`},
+			want:  []string{`["REQ","sub",{"#t":["]"]}]`, `["CLOSE","sub"]`},
+		},
+		{
+			name:  "escaped quote",
+			input: []string{`["NOTICE","\"]"]`},
+			want:  []string{`["NOTICE","\"]"]`},
+		},
+		{
+			name:    "not an array",
+			input:   []string{`["CLOSE","a"]{}`},
+			want:    []string{`["CLOSE","a"]`},
+			wantErr: true,
+		},
+		{
+			name:    "too long",
+			input:   []string{`["REQ",`, `"` + strings.Repeat("a", 32)},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newMsgFramer(32)
+
+			var got []string
+			var err error
+			for _, in := range tt.input {
+				var msgs [][]byte
+				msgs, err = f.Push([]byte(in))
+				for _, msg := range msgs {
+					got = append(got, string(msg))
+				}
+				if err != nil {
+					break
+				}
+			}
+
+			assert.Equal(t, tt.want, got)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidMsgFrame)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	t.Run("reset after error", func(t *testing.T) {
+		f := newMsgFramer(32)
+		_, err := f.Push([]byte(`x`))
+		assert.ErrorIs(t, err, ErrInvalidMsgFrame)
+
+		msgs, err := f.Push([]byte(`["CLOSE","a"]`))
+		assert.NoError(t, err)
+		assert.Equal(t, [][]byte{[]byte(`["CLOSE","a"]`)}, msgs)
+	})
+}
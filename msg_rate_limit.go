@@ -0,0 +1,179 @@
+package mocrelay
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+)
+
+// RateLimitNoticePrefix is the prefix of vendor NOTICEs sent with MsgRateLimitOption.Notice.
+// The rest of the message is a RateLimitNotice in JSON.
+const RateLimitNoticePrefix = "mocrelay-rate-limit: "
+
+// MsgRateLimitOption limits client messages of a connection with a token bucket.
+// Each message takes tokens by its type, and messages which exceed the budget are rejected.
+type MsgRateLimitOption struct {
+	// Rate is the number of tokens refilled per second.
+	Rate float64
+
+	// Burst is the max number of tokens. The default is Rate but at least 1.
+	// Messages costing more than Burst are always rejected.
+	Burst float64
+
+	// Costs of messages by type. Zero means 1.
+	EventCost float64
+	ReqCost   float64
+	CountCost float64
+	CloseCost float64
+	AuthCost  float64
+
+	// FilterCost is the additional cost of each filter of REQ and COUNT after the first one.
+	FilterCost float64
+
+	// Notice sends a vendor NOTICE prefixed with RateLimitNoticePrefix
+	// for each rejected message so that clients can back off precisely.
+	Notice bool
+}
+
+func (opt *MsgRateLimitOption) burst() float64 {
+	if opt.Burst == 0 {
+		return math.Max(opt.Rate, 1)
+	}
+	return opt.Burst
+}
+
+func (opt *MsgRateLimitOption) cost(msg ClientMsg) float64 {
+	orOne := func(c float64) float64 {
+		if c == 0 {
+			return 1
+		}
+		return c
+	}
+	filters := func(n int) float64 {
+		if n <= 1 {
+			return 0
+		}
+		return opt.FilterCost * float64(n-1)
+	}
+
+	switch m := msg.(type) {
+	case *ClientEventMsg:
+		return orOne(opt.EventCost)
+	case *ClientReqMsg:
+		return orOne(opt.ReqCost) + filters(len(m.ReqFilters))
+	case *ClientCountMsg:
+		return orOne(opt.CountCost) + filters(len(m.ReqFilters))
+	case *ClientCloseMsg:
+		return orOne(opt.CloseCost)
+	case *ClientAuthMsg:
+		return orOne(opt.AuthCost)
+	default:
+		return 1
+	}
+}
+
+// msgRateLimiter is a token bucket of a connection. It is not goroutine safe.
+type msgRateLimiter struct {
+	opt    *MsgRateLimitOption
+	tokens float64
+	last   time.Time
+}
+
+func newMsgRateLimiter(option *MsgRateLimitOption, now time.Time) *msgRateLimiter {
+	if option == nil {
+		return nil
+	}
+	return &msgRateLimiter{
+		opt:    option,
+		tokens: option.burst(),
+		last:   now,
+	}
+}
+
+// RateLimitInfo is the state of the limiter when a message is rejected.
+type RateLimitInfo struct {
+	Cost   float64
+	Budget float64
+	Burst  float64
+
+	// RetryAfter is how long to wait until the message is allowed
+	// rounded up to milliseconds. Zero means it is never allowed.
+	RetryAfter time.Duration
+}
+
+// Reason returns the reason of OK and CLOSED messages such as
+// "retry in 1s: cost 3.0, budget 1.0/4.0".
+func (info *RateLimitInfo) Reason() string {
+	budget := fmt.Sprintf("cost %.1f, budget %.1f/%.1f", info.Cost, info.Budget, info.Burst)
+	if info.RetryAfter == 0 {
+		return "never allowed: " + budget
+	}
+	return fmt.Sprintf("retry in %s: %s", info.RetryAfter, budget)
+}
+
+// RateLimitNotice is the body of vendor NOTICEs about rejected messages.
+type RateLimitNotice struct {
+	// Type is the type of the rejected message such as "EVENT".
+	Type string `json:"type"`
+	// ID is the event ID of EVENT or the subscription ID of REQ and COUNT.
+	ID string `json:"id,omitempty"`
+	// RetryAfterMS is RateLimitInfo.RetryAfter in milliseconds.
+	// It is omitted if the message is never allowed.
+	RetryAfterMS int64   `json:"retry_after_ms,omitempty"`
+	Cost         float64 `json:"cost"`
+	Budget       float64 `json:"budget"`
+	Burst        float64 `json:"burst"`
+}
+
+// newRateLimitNotice returns the vendor NOTICE about msg rejected with info.
+func newRateLimitNotice(msg ClientMsg, info *RateLimitInfo) *ServerNoticeMsg {
+	n := RateLimitNotice{
+		RetryAfterMS: info.RetryAfter.Milliseconds(),
+		Cost:         info.Cost,
+		Budget:       info.Budget,
+		Burst:        info.Burst,
+	}
+	switch m := msg.(type) {
+	case *ClientEventMsg:
+		n.Type, n.ID = "EVENT", m.Event.ID
+	case *ClientReqMsg:
+		n.Type, n.ID = "REQ", m.SubscriptionID
+	case *ClientCountMsg:
+		n.Type, n.ID = "COUNT", m.SubscriptionID
+	case *ClientCloseMsg:
+		n.Type = "CLOSE"
+	case *ClientAuthMsg:
+		n.Type = "AUTH"
+	}
+
+	b, _ := json.Marshal(&n)
+	return NewServerNoticeMsg(RateLimitNoticePrefix + string(b))
+}
+
+// allow takes the cost of msg from the bucket if it has enough tokens.
+// Otherwise, it returns the state of the bucket.
+func (l *msgRateLimiter) allow(msg ClientMsg, now time.Time) (info *RateLimitInfo, ok bool) {
+	if l == nil {
+		return nil, true
+	}
+
+	burst := l.opt.burst()
+	if elapsed := now.Sub(l.last); elapsed > 0 {
+		l.tokens = math.Min(burst, l.tokens+elapsed.Seconds()*l.opt.Rate)
+		l.last = now
+	}
+
+	cost := l.opt.cost(msg)
+	if cost <= l.tokens {
+		l.tokens -= cost
+		return nil, true
+	}
+
+	info = &RateLimitInfo{Cost: cost, Budget: l.tokens, Burst: burst}
+	if cost <= burst && l.opt.Rate > 0 {
+		ms := math.Ceil((cost - l.tokens) / l.opt.Rate * 1000)
+		info.RetryAfter = time.Duration(ms) * time.Millisecond
+	}
+	return info, false
+}
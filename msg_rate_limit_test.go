@@ -0,0 +1,162 @@
+package mocrelay
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"nhooyr.io/websocket"
+)
+
+func TestMsgRateLimitOption_cost(t *testing.T) {
+	opt := &MsgRateLimitOption{EventCost: 2, FilterCost: 0.5}
+
+	tests := []struct {
+		name string
+		msg  ClientMsg
+		want float64
+	}{
+		{name: "event", msg: &ClientEventMsg{Event: &Event{}}, want: 2},
+		{name: "req", msg: &ClientReqMsg{ReqFilters: []*ReqFilter{{}}}, want: 1},
+		{name: "req with filters", msg: &ClientReqMsg{ReqFilters: make([]*ReqFilter, 5)}, want: 3},
+		{
+			name: "count with filters",
+			msg:  &ClientCountMsg{ReqFilters: make([]*ReqFilter, 3)},
+			want: 2,
+		},
+		{name: "close", msg: &ClientCloseMsg{}, want: 1},
+		{name: "auth", msg: &ClientAuthMsg{}, want: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, opt.cost(tt.msg))
+		})
+	}
+}
+
+func TestMsgRateLimiter_allow(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := newMsgRateLimiter(&MsgRateLimitOption{Rate: 2, Burst: 4, ReqCost: 3}, now)
+	req := &ClientReqMsg{ReqFilters: []*ReqFilter{{}}}
+
+	_, ok := l.allow(req, now)
+	assert.True(t, ok)
+
+	info, ok := l.allow(req, now)
+	assert.False(t, ok)
+	assert.Equal(t, &RateLimitInfo{Cost: 3, Budget: 1, Burst: 4, RetryAfter: time.Second}, info)
+	assert.Equal(t, "retry in 1s: cost 3.0, budget 1.0/4.0", info.Reason())
+
+	info, ok = newMsgRateLimiter(&MsgRateLimitOption{Rate: 2, ReqCost: 3}, now).allow(req, now)
+	assert.False(t, ok)
+	assert.Equal(t, "never allowed: cost 3.0, budget 2.0/2.0", info.Reason())
+
+	_, ok = l.allow(req, now.Add(time.Second))
+	assert.True(t, ok)
+
+	_, ok = l.allow(req, now.Add(time.Hour))
+	assert.True(t, ok)
+	_, ok = l.allow(req, now.Add(time.Hour))
+	assert.False(t, ok, "tokens don't exceed the burst")
+
+	var nilLimiter *msgRateLimiter
+	_, ok = nilLimiter.allow(req, now)
+	assert.True(t, ok)
+}
+
+func TestRelay_msgRateLimit(t *testing.T) {
+	relay := NewRelay(NewRouterHandler(10), &RelayOption{
+		MsgRateLimit: &MsgRateLimitOption{Rate: 0.001, Burst: 2, FilterCost: 1},
+	})
+	srv := httptest.NewServer(relay)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	conn, _, err := websocket.Dial(ctx, url, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close(websocket.StatusNormalClosure, "")
+
+	for _, req := range []string{`["REQ","sub1",{}]`, `["REQ","sub2",{},{}]`} {
+		err = conn.Write(ctx, websocket.MessageText, []byte(req))
+		assert.NoError(t, err)
+	}
+
+	_, b, err := conn.Read(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, `["EOSE","sub1"]`, string(b))
+
+	_, b, err = conn.Read(ctx)
+	assert.NoError(t, err)
+	assert.True(
+		t,
+		strings.HasPrefix(
+			string(b),
+			`["CLOSED","sub2","rate-limited: retry in 16m40s: cost 2.0, budget 1.0/2.0`,
+		),
+		string(b),
+	)
+}
+
+func TestRelay_msgRateLimitNotice(t *testing.T) {
+	relay := NewRelay(NewRouterHandler(10), &RelayOption{
+		MsgRateLimit: &MsgRateLimitOption{Rate: 1, Burst: 1, Notice: true},
+	})
+	srv := httptest.NewServer(relay)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	conn, _, err := websocket.Dial(ctx, url, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close(websocket.StatusNormalClosure, "")
+
+	for _, req := range []string{`["REQ","sub1",{}]`, `["REQ","sub2",{}]`} {
+		err = conn.Write(ctx, websocket.MessageText, []byte(req))
+		assert.NoError(t, err)
+	}
+
+	_, b, err := conn.Read(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, `["EOSE","sub1"]`, string(b))
+
+	_, b, err = conn.Read(ctx)
+	assert.NoError(t, err)
+	assert.True(
+		t,
+		strings.HasPrefix(string(b), `["CLOSED","sub2","rate-limited: retry in `),
+		string(b),
+	)
+
+	_, b, err = conn.Read(ctx)
+	assert.NoError(t, err)
+	var notice []string
+	if !assert.NoError(t, json.Unmarshal(b, &notice)) || !assert.Len(t, notice, 2) {
+		return
+	}
+	assert.Equal(t, "NOTICE", notice[0])
+	body, ok := strings.CutPrefix(notice[1], RateLimitNoticePrefix)
+	assert.True(t, ok, notice[1])
+
+	var got RateLimitNotice
+	assert.NoError(t, json.Unmarshal([]byte(body), &got))
+	assert.Equal(t, "REQ", got.Type)
+	assert.Equal(t, "sub2", got.ID)
+	assert.Equal(t, 1.0, got.Cost)
+	assert.Equal(t, 1.0, got.Burst)
+	assert.Positive(t, got.RetryAfterMS)
+	assert.LessOrEqual(t, got.RetryAfterMS, int64(1000))
+}
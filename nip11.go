@@ -23,6 +23,7 @@ type NIP11 struct {
 	PaymentsURL   string           `json:"payments_url,omitempty"`
 	Fees          *NIP11Fees       `json:"fees,omitempty"`
 	Icon          string           `json:"icon,omitempty"`
+	RelayURL      string           `json:"relay_url,omitempty"`
 }
 
 type NIP11Limitation struct {
@@ -38,6 +39,13 @@ type NIP11Limitation struct {
 	PaymentRequired     bool  `json:"payment_required,omitempty"`
 	CreatedAtLowerLimit int64 `json:"created_at_lower_limit,omitempty"`
 	CreatedAtUpperLimit int64 `json:"created_at_upper_limit,omitempty"`
+
+	// MaxFilterIDs and the others are the limits of ReqFilterLimits.
+	// They are not in NIP-11 yet.
+	MaxFilterIDs       int `json:"max_filter_ids,omitempty"`
+	MaxFilterAuthors   int `json:"max_filter_authors,omitempty"`
+	MaxFilterKinds     int `json:"max_filter_kinds,omitempty"`
+	MaxFilterTagValues int `json:"max_filter_tag_values,omitempty"`
 }
 
 type NIP11Retention struct {
@@ -45,7 +53,16 @@ type NIP11Retention struct {
 }
 
 type NIP11Fees struct {
-	// TODO(high-moctane) Impl
+	Admission    []NIP11Fee `json:"admission,omitempty"`
+	Subscription []NIP11Fee `json:"subscription,omitempty"`
+	Publication  []NIP11Fee `json:"publication,omitempty"`
+}
+
+type NIP11Fee struct {
+	Amount int64   `json:"amount"`
+	Unit   string  `json:"unit"`
+	Period int64   `json:"period,omitempty"`
+	Kinds  []int64 `json:"kinds,omitempty"`
 }
 
 func (nip11 *NIP11) ServeHTTP(w http.ResponseWriter, r *http.Request) {
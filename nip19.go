@@ -0,0 +1,482 @@
+package mocrelay
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidBech32 = errors.New("invalid bech32 string")
+	ErrInvalidNIP19  = errors.New("invalid nip19 entity")
+	ErrInvalidNaddr  = errors.New("invalid naddr")
+)
+
+const (
+	NIP19PrefixNpub     = "npub"
+	NIP19PrefixNsec     = "nsec"
+	NIP19PrefixNote     = "note"
+	NIP19PrefixNprofile = "nprofile"
+	NIP19PrefixNevent   = "nevent"
+	NIP19PrefixNaddr    = "naddr"
+)
+
+type NIP19Profile struct {
+	Pubkey string
+	Relays []string
+}
+
+type NIP19Event struct {
+	ID     string
+	Relays []string
+	Author string
+	Kind   *int64
+}
+
+type NIP19Addr struct {
+	Identifier string
+	Pubkey     string
+	Kind       int64
+	Relays     []string
+}
+
+// Naddr returns the "kind:pubkey:d-tag" form used in "a" tags and filters.
+func (addr *NIP19Addr) Naddr() string {
+	return fmt.Sprintf("%d:%s:%s", addr.Kind, addr.Pubkey, addr.Identifier)
+}
+
+// ParseNaddr parses the "kind:pubkey:d-tag" form used in "a" tags and filters.
+// The d-tag may contain colons.
+func ParseNaddr(naddr string) (*NIP19Addr, error) {
+	elems := strings.SplitN(naddr, ":", 3)
+	if len(elems) != 3 {
+		return nil, fmt.Errorf("%q is not kind:pubkey:d-tag: %w", naddr, ErrInvalidNaddr)
+	}
+
+	kind, err := strconv.ParseInt(elems[0], 10, 64)
+	if err != nil || !validKind(kind) {
+		return nil, fmt.Errorf("invalid kind %q: %w", elems[0], ErrInvalidNaddr)
+	}
+	if !validPubkey(elems[1]) {
+		return nil, fmt.Errorf("invalid pubkey %q: %w", elems[1], ErrInvalidNaddr)
+	}
+
+	return &NIP19Addr{Identifier: elems[2], Pubkey: elems[1], Kind: kind}, nil
+}
+
+// normalizeNaddr returns the canonical form of naddr to compare it component-wise.
+// Invalid ones are returned as is.
+func normalizeNaddr(naddr string) string {
+	addr, err := ParseNaddr(naddr)
+	if err != nil {
+		return naddr
+	}
+	return addr.Naddr()
+}
+
+func EncodeNpub(pubkey string) (string, error) {
+	if !validPubkey(pubkey) {
+		return "", fmt.Errorf("invalid pubkey %q: %w", pubkey, ErrInvalidNIP19)
+	}
+	return encodeNIP19Hex(NIP19PrefixNpub, pubkey)
+}
+
+func EncodeNsec(seckey string) (string, error) {
+	if len(seckey) != 64 || !validHexString(seckey) {
+		return "", fmt.Errorf("invalid seckey: %w", ErrInvalidNIP19)
+	}
+	return encodeNIP19Hex(NIP19PrefixNsec, seckey)
+}
+
+func EncodeNote(id string) (string, error) {
+	if !validID(id) {
+		return "", fmt.Errorf("invalid event id %q: %w", id, ErrInvalidNIP19)
+	}
+	return encodeNIP19Hex(NIP19PrefixNote, id)
+}
+
+func encodeNIP19Hex(prefix, s string) (string, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return "", errors.Join(err, ErrInvalidNIP19)
+	}
+	return bech32Encode(prefix, b)
+}
+
+const (
+	nip19TLVSpecial uint8 = 0
+	nip19TLVRelay   uint8 = 1
+	nip19TLVAuthor  uint8 = 2
+	nip19TLVKind    uint8 = 3
+)
+
+func EncodeNprofile(profile *NIP19Profile) (string, error) {
+	if profile == nil || !validPubkey(profile.Pubkey) {
+		return "", fmt.Errorf("invalid nprofile: %w", ErrInvalidNIP19)
+	}
+
+	var tlv nip19TLVWriter
+	tlv.WriteHex(nip19TLVSpecial, profile.Pubkey)
+	tlv.WriteRelays(profile.Relays)
+	if tlv.err != nil {
+		return "", tlv.err
+	}
+
+	return bech32Encode(NIP19PrefixNprofile, tlv.b)
+}
+
+func EncodeNevent(event *NIP19Event) (string, error) {
+	if event == nil || !validID(event.ID) {
+		return "", fmt.Errorf("invalid nevent: %w", ErrInvalidNIP19)
+	}
+	if event.Author != "" && !validPubkey(event.Author) {
+		return "", fmt.Errorf("invalid nevent author: %w", ErrInvalidNIP19)
+	}
+
+	var tlv nip19TLVWriter
+	tlv.WriteHex(nip19TLVSpecial, event.ID)
+	tlv.WriteRelays(event.Relays)
+	if event.Author != "" {
+		tlv.WriteHex(nip19TLVAuthor, event.Author)
+	}
+	if event.Kind != nil {
+		tlv.WriteKind(*event.Kind)
+	}
+	if tlv.err != nil {
+		return "", tlv.err
+	}
+
+	return bech32Encode(NIP19PrefixNevent, tlv.b)
+}
+
+func EncodeNaddr(addr *NIP19Addr) (string, error) {
+	if addr == nil || !validPubkey(addr.Pubkey) {
+		return "", fmt.Errorf("invalid naddr: %w", ErrInvalidNIP19)
+	}
+
+	var tlv nip19TLVWriter
+	tlv.Write(nip19TLVSpecial, []byte(addr.Identifier))
+	tlv.WriteRelays(addr.Relays)
+	tlv.WriteHex(nip19TLVAuthor, addr.Pubkey)
+	tlv.WriteKind(addr.Kind)
+	if tlv.err != nil {
+		return "", tlv.err
+	}
+
+	return bech32Encode(NIP19PrefixNaddr, tlv.b)
+}
+
+// DecodeNIP19 decodes a bech32 nip19 entity.
+// The value is a hex string for npub, nsec and note,
+// and *NIP19Profile, *NIP19Event or *NIP19Addr for the TLV entities.
+func DecodeNIP19(s string) (prefix string, value any, err error) {
+	prefix, data, err := bech32Decode(strings.TrimPrefix(s, "nostr:"))
+	if err != nil {
+		return "", nil, err
+	}
+
+	switch prefix {
+	case NIP19PrefixNpub, NIP19PrefixNsec, NIP19PrefixNote:
+		if len(data) != 32 {
+			return "", nil, fmt.Errorf(
+				"invalid %s length %d: %w",
+				prefix,
+				len(data),
+				ErrInvalidNIP19,
+			)
+		}
+		return prefix, hex.EncodeToString(data), nil
+
+	case NIP19PrefixNprofile:
+		ret, err := decodeNprofile(data)
+		return prefix, ret, err
+
+	case NIP19PrefixNevent:
+		ret, err := decodeNevent(data)
+		return prefix, ret, err
+
+	case NIP19PrefixNaddr:
+		ret, err := decodeNaddr(data)
+		return prefix, ret, err
+
+	default:
+		return "", nil, fmt.Errorf("unknown nip19 prefix %q: %w", prefix, ErrInvalidNIP19)
+	}
+}
+
+func decodeNprofile(data []byte) (*NIP19Profile, error) {
+	var ret NIP19Profile
+
+	err := walkNIP19TLV(data, func(typ uint8, v []byte) error {
+		switch typ {
+		case nip19TLVSpecial:
+			if len(v) != 32 {
+				return fmt.Errorf("invalid nprofile pubkey length %d", len(v))
+			}
+			ret.Pubkey = hex.EncodeToString(v)
+		case nip19TLVRelay:
+			ret.Relays = append(ret.Relays, string(v))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if ret.Pubkey == "" {
+		return nil, fmt.Errorf("nprofile pubkey not found: %w", ErrInvalidNIP19)
+	}
+
+	return &ret, nil
+}
+
+func decodeNevent(data []byte) (*NIP19Event, error) {
+	var ret NIP19Event
+
+	err := walkNIP19TLV(data, func(typ uint8, v []byte) error {
+		switch typ {
+		case nip19TLVSpecial:
+			if len(v) != 32 {
+				return fmt.Errorf("invalid nevent id length %d", len(v))
+			}
+			ret.ID = hex.EncodeToString(v)
+		case nip19TLVRelay:
+			ret.Relays = append(ret.Relays, string(v))
+		case nip19TLVAuthor:
+			if len(v) != 32 {
+				return fmt.Errorf("invalid nevent author length %d", len(v))
+			}
+			ret.Author = hex.EncodeToString(v)
+		case nip19TLVKind:
+			if len(v) != 4 {
+				return fmt.Errorf("invalid nevent kind length %d", len(v))
+			}
+			ret.Kind = toPtr(int64(binary.BigEndian.Uint32(v)))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if ret.ID == "" {
+		return nil, fmt.Errorf("nevent id not found: %w", ErrInvalidNIP19)
+	}
+
+	return &ret, nil
+}
+
+func decodeNaddr(data []byte) (*NIP19Addr, error) {
+	var ret NIP19Addr
+	var foundIdentifier, foundKind bool
+
+	err := walkNIP19TLV(data, func(typ uint8, v []byte) error {
+		switch typ {
+		case nip19TLVSpecial:
+			ret.Identifier = string(v)
+			foundIdentifier = true
+		case nip19TLVRelay:
+			ret.Relays = append(ret.Relays, string(v))
+		case nip19TLVAuthor:
+			if len(v) != 32 {
+				return fmt.Errorf("invalid naddr author length %d", len(v))
+			}
+			ret.Pubkey = hex.EncodeToString(v)
+		case nip19TLVKind:
+			if len(v) != 4 {
+				return fmt.Errorf("invalid naddr kind length %d", len(v))
+			}
+			ret.Kind = int64(binary.BigEndian.Uint32(v))
+			foundKind = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !foundIdentifier || ret.Pubkey == "" || !foundKind {
+		return nil, fmt.Errorf("naddr lacks required fields: %w", ErrInvalidNIP19)
+	}
+
+	return &ret, nil
+}
+
+type nip19TLVWriter struct {
+	b   []byte
+	err error
+}
+
+func (w *nip19TLVWriter) Write(typ uint8, v []byte) {
+	if w.err != nil {
+		return
+	}
+	if len(v) > 255 {
+		w.err = fmt.Errorf("tlv value too long (%d bytes): %w", len(v), ErrInvalidNIP19)
+		return
+	}
+	w.b = append(w.b, typ, uint8(len(v)))
+	w.b = append(w.b, v...)
+}
+
+func (w *nip19TLVWriter) WriteHex(typ uint8, s string) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		w.err = errors.Join(err, ErrInvalidNIP19)
+		return
+	}
+	w.Write(typ, b)
+}
+
+func (w *nip19TLVWriter) WriteRelays(relays []string) {
+	for _, relay := range relays {
+		w.Write(nip19TLVRelay, []byte(relay))
+	}
+}
+
+func (w *nip19TLVWriter) WriteKind(kind int64) {
+	if kind < 0 || kind > 0xffffffff {
+		w.err = fmt.Errorf("invalid kind %d: %w", kind, ErrInvalidNIP19)
+		return
+	}
+	w.Write(nip19TLVKind, binary.BigEndian.AppendUint32(nil, uint32(kind)))
+}
+
+func walkNIP19TLV(data []byte, f func(typ uint8, v []byte) error) error {
+	for len(data) > 0 {
+		if len(data) < 2 {
+			return fmt.Errorf("truncated tlv: %w", ErrInvalidNIP19)
+		}
+		typ, l := data[0], int(data[1])
+		if len(data) < 2+l {
+			return fmt.Errorf("truncated tlv value: %w", ErrInvalidNIP19)
+		}
+		if err := f(typ, data[2:2+l]); err != nil {
+			return errors.Join(err, ErrInvalidNIP19)
+		}
+		data = data[2+l:]
+	}
+	return nil
+}
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+var bech32Generator = [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+func bech32Polymod(values []byte) uint32 {
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>i)&1 == 1 {
+				chk ^= bech32Generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+func bech32HRPExpand(hrp string) []byte {
+	ret := make([]byte, 0, len(hrp)*2+1)
+	for i := 0; i < len(hrp); i++ {
+		ret = append(ret, hrp[i]>>5)
+	}
+	ret = append(ret, 0)
+	for i := 0; i < len(hrp); i++ {
+		ret = append(ret, hrp[i]&31)
+	}
+	return ret
+}
+
+func bech32Encode(hrp string, data []byte) (string, error) {
+	values, err := bech32ConvertBits(data, 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+
+	polymod := bech32Polymod(append(append(bech32HRPExpand(hrp), values...), 0, 0, 0, 0, 0, 0)) ^ 1
+	for i := 0; i < 6; i++ {
+		values = append(values, byte(polymod>>(5*(5-i)))&31)
+	}
+
+	var b strings.Builder
+	b.Grow(len(hrp) + 1 + len(values))
+	b.WriteString(hrp)
+	b.WriteByte('1')
+	for _, v := range values {
+		b.WriteByte(bech32Charset[v])
+	}
+
+	return b.String(), nil
+}
+
+func bech32Decode(s string) (hrp string, data []byte, err error) {
+	if lower := strings.ToLower(s); lower != s {
+		if strings.ToUpper(s) != s {
+			return "", nil, fmt.Errorf("mixed case: %w", ErrInvalidBech32)
+		}
+		s = lower
+	}
+
+	sep := strings.LastIndexByte(s, '1')
+	if sep < 1 || sep+7 > len(s) {
+		return "", nil, fmt.Errorf("invalid separator position: %w", ErrInvalidBech32)
+	}
+	hrp = s[:sep]
+
+	for i := 0; i < len(hrp); i++ {
+		if hrp[i] < 33 || hrp[i] > 126 {
+			return "", nil, fmt.Errorf("invalid hrp character: %w", ErrInvalidBech32)
+		}
+	}
+
+	values := make([]byte, 0, len(s)-sep-1)
+	for i := sep + 1; i < len(s); i++ {
+		idx := strings.IndexByte(bech32Charset, s[i])
+		if idx < 0 {
+			return "", nil, fmt.Errorf("invalid data character %q: %w", s[i], ErrInvalidBech32)
+		}
+		values = append(values, byte(idx))
+	}
+
+	if bech32Polymod(append(bech32HRPExpand(hrp), values...)) != 1 {
+		return "", nil, fmt.Errorf("invalid checksum: %w", ErrInvalidBech32)
+	}
+
+	data, err = bech32ConvertBits(values[:len(values)-6], 5, 8, false)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return hrp, data, nil
+}
+
+func bech32ConvertBits(data []byte, from, to uint, pad bool) ([]byte, error) {
+	var acc uint32
+	var bits uint
+	maxv := uint32(1)<<to - 1
+
+	ret := make([]byte, 0, len(data)*int(from)/int(to)+1)
+	for _, v := range data {
+		if uint32(v)>>from != 0 {
+			return nil, fmt.Errorf("invalid data range: %w", ErrInvalidBech32)
+		}
+		acc = acc<<from | uint32(v)
+		bits += from
+		for bits >= to {
+			bits -= to
+			ret = append(ret, byte(acc>>bits&maxv))
+		}
+	}
+
+	if pad {
+		if bits > 0 {
+			ret = append(ret, byte(acc<<(to-bits)&maxv))
+		}
+	} else if bits >= from || acc<<(to-bits)&maxv != 0 {
+		return nil, fmt.Errorf("invalid padding: %w", ErrInvalidBech32)
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,203 @@
+package mocrelay
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeNpub(t *testing.T) {
+	got, err := EncodeNpub("7e7e9c42a91bfef19fa929e5fda1b72e0ebc1a4c1141673e2794234d86addf4e")
+	assert.NoError(t, err)
+	assert.Equal(t, "npub10elfcs4fr0l0r8af98jlmgdh9c8tcxjvz9qkw038js35mp4dma8qzvjptg", got)
+
+	_, err = EncodeNpub("invalid")
+	assert.ErrorIs(t, err, ErrInvalidNIP19)
+}
+
+func TestEncodeNsec(t *testing.T) {
+	got, err := EncodeNsec("67dea2ed018072d675f5415ecfaed7d2597555e202d85b3d65ea4e58d2d92ffa")
+	assert.NoError(t, err)
+	assert.Equal(t, "nsec1vl029mgpspedva04g90vltkh6fvh240zqtv9k0t9af8935ke9laqsnlfe5", got)
+}
+
+func TestDecodeNIP19(t *testing.T) {
+	tests := []struct {
+		name   string
+		in     string
+		prefix string
+		value  any
+		err    error
+	}{
+		{
+			name:   "npub",
+			in:     "npub10elfcs4fr0l0r8af98jlmgdh9c8tcxjvz9qkw038js35mp4dma8qzvjptg",
+			prefix: NIP19PrefixNpub,
+			value:  "7e7e9c42a91bfef19fa929e5fda1b72e0ebc1a4c1141673e2794234d86addf4e",
+		},
+		{
+			name:   "npub: nostr uri",
+			in:     "nostr:npub10elfcs4fr0l0r8af98jlmgdh9c8tcxjvz9qkw038js35mp4dma8qzvjptg",
+			prefix: NIP19PrefixNpub,
+			value:  "7e7e9c42a91bfef19fa929e5fda1b72e0ebc1a4c1141673e2794234d86addf4e",
+		},
+		{
+			name:   "npub: upper case",
+			in:     "NPUB10ELFCS4FR0L0R8AF98JLMGDH9C8TCXJVZ9QKW038JS35MP4DMA8QZVJPTG",
+			prefix: NIP19PrefixNpub,
+			value:  "7e7e9c42a91bfef19fa929e5fda1b72e0ebc1a4c1141673e2794234d86addf4e",
+		},
+		{
+			name:   "nsec",
+			in:     "nsec1vl029mgpspedva04g90vltkh6fvh240zqtv9k0t9af8935ke9laqsnlfe5",
+			prefix: NIP19PrefixNsec,
+			value:  "67dea2ed018072d675f5415ecfaed7d2597555e202d85b3d65ea4e58d2d92ffa",
+		},
+		{
+			name:   "nprofile",
+			in:     "nprofile1qqsrhuxx8l9ex335q7he0f09aej04zpazpl0ne2cgukyawd24mayt8gpp4mhxue69uhhytnc9e3k7mgpz4mhxue69uhkg6nzv9ejuumpv34kytnrdaksjlyr9p",
+			prefix: NIP19PrefixNprofile,
+			value: &NIP19Profile{
+				Pubkey: "3bf0c63fcb93463407af97a5e5ee64fa883d107ef9e558472c4eb9aaaefa459d",
+				Relays: []string{"wss://r.x.com", "wss://djbas.sadkb.com"},
+			},
+		},
+		{
+			name:   "ng: mixed case",
+			in:     "npub10ELFcs4fr0l0r8af98jlmgdh9c8tcxjvz9qkw038js35mp4dma8qzvjptg",
+			prefix: "",
+			value:  nil,
+			err:    ErrInvalidBech32,
+		},
+		{
+			name:   "ng: checksum",
+			in:     "npub10elfcs4fr0l0r8af98jlmgdh9c8tcxjvz9qkw038js35mp4dma8qzvjpth",
+			prefix: "",
+			value:  nil,
+			err:    ErrInvalidBech32,
+		},
+		{
+			name:   "ng: no separator",
+			in:     "npub",
+			prefix: "",
+			value:  nil,
+			err:    ErrInvalidBech32,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefix, value, err := DecodeNIP19(tt.in)
+			if err != nil || tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			assert.Equal(t, tt.prefix, prefix)
+			assert.Equal(t, tt.value, value)
+		})
+	}
+}
+
+func TestNIP19_RoundTrip(t *testing.T) {
+	pubkey := "dbf0becf24bf8dd7d779d7fb547e6112964ff042b77a42cc2d8488636eed9f5e"
+	id := "49d58222bd85ddabfc19b8052d35bcce2bad8f1f3030c0bc7dc9f10dba82a8a2"
+
+	t.Run("note", func(t *testing.T) {
+		s, err := EncodeNote(id)
+		assert.NoError(t, err)
+		prefix, value, err := DecodeNIP19(s)
+		assert.NoError(t, err)
+		assert.Equal(t, NIP19PrefixNote, prefix)
+		assert.Equal(t, id, value)
+	})
+
+	t.Run("nevent", func(t *testing.T) {
+		in := &NIP19Event{
+			ID:     id,
+			Relays: []string{"wss://relay.example.com"},
+			Author: pubkey,
+			Kind:   toPtr(int64(1)),
+		}
+		s, err := EncodeNevent(in)
+		assert.NoError(t, err)
+		prefix, value, err := DecodeNIP19(s)
+		assert.NoError(t, err)
+		assert.Equal(t, NIP19PrefixNevent, prefix)
+		assert.Equal(t, in, value)
+	})
+
+	t.Run("nevent: id only", func(t *testing.T) {
+		in := &NIP19Event{ID: id}
+		s, err := EncodeNevent(in)
+		assert.NoError(t, err)
+		_, value, err := DecodeNIP19(s)
+		assert.NoError(t, err)
+		assert.Equal(t, in, value)
+	})
+
+	t.Run("naddr", func(t *testing.T) {
+		in := &NIP19Addr{
+			Identifier: "ぽわ〜",
+			Pubkey:     pubkey,
+			Kind:       30023,
+			Relays:     []string{"wss://relay.example.com"},
+		}
+		s, err := EncodeNaddr(in)
+		assert.NoError(t, err)
+		prefix, value, err := DecodeNIP19(s)
+		assert.NoError(t, err)
+		assert.Equal(t, NIP19PrefixNaddr, prefix)
+		assert.Equal(t, in, value)
+		assert.True(t, validNaddr(value.(*NIP19Addr).Naddr()))
+	})
+
+	t.Run("naddr: empty identifier", func(t *testing.T) {
+		in := &NIP19Addr{Pubkey: pubkey, Kind: 10002}
+		s, err := EncodeNaddr(in)
+		assert.NoError(t, err)
+		_, value, err := DecodeNIP19(s)
+		assert.NoError(t, err)
+		assert.Equal(t, in, value)
+	})
+}
+
+func TestParseNaddr(t *testing.T) {
+	pubkey := "7e7e9c42a91bfef19fa929e5fda1b72e0ebc1a4c1141673e2794234d86addf4e"
+
+	tests := []struct {
+		name  string
+		input string
+		want  *NIP19Addr
+	}{
+		{
+			name:  "ok",
+			input: "30023:" + pubkey + ":powa",
+			want:  &NIP19Addr{Identifier: "powa", Pubkey: pubkey, Kind: 30023},
+		},
+		{
+			name:  "ok: colons in d-tag",
+			input: "30023:" + pubkey + ":https://example.com",
+			want:  &NIP19Addr{Identifier: "https://example.com", Pubkey: pubkey, Kind: 30023},
+		},
+		{
+			name:  "ok: empty d-tag",
+			input: "10002:" + pubkey + ":",
+			want:  &NIP19Addr{Pubkey: pubkey, Kind: 10002},
+		},
+		{name: "ng: no d-tag", input: "30023:" + pubkey},
+		{name: "ng: kind", input: "powa:" + pubkey + ":powa"},
+		{name: "ng: pubkey", input: "30023:powa:powa"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseNaddr(tt.input)
+			if tt.want == nil {
+				assert.ErrorIs(t, err, ErrInvalidNaddr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package mocrelay
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+const AuthEventKind = 22242
+
+var ErrInvalidAuthEvent = errors.New("invalid auth event")
+
+func ValidateAuthEvent(event *Event, relayURL, challenge string) error {
+	return ValidateAuthEventURLs(event, []string{relayURL}, challenge)
+}
+
+// ValidateAuthEventURLs is ValidateAuthEvent accepting the relay tag matching
+// any of relayURLs, such as the onion and clearnet addresses of the same relay.
+func ValidateAuthEventURLs(event *Event, relayURLs []string, challenge string) error {
+	const maxAuthEventAge = 10 * time.Minute
+
+	if event == nil {
+		return fmt.Errorf("%w: nil event", ErrInvalidAuthEvent)
+	}
+	if event.Kind != AuthEventKind {
+		return fmt.Errorf(
+			"%w: kind must be %d but got %d",
+			ErrInvalidAuthEvent,
+			AuthEventKind,
+			event.Kind,
+		)
+	}
+
+	if d := time.Since(event.CreatedAtTime()); d > maxAuthEventAge || d < -maxAuthEventAge {
+		return fmt.Errorf("%w: created_at is too far from now", ErrInvalidAuthEvent)
+	}
+
+	var relayOK, challengeOK bool
+	for _, tag := range event.Tags {
+		if len(tag) < 2 {
+			continue
+		}
+		switch tag[0] {
+		case "relay":
+			relayOK = relayOK || slices.ContainsFunc(relayURLs, func(u string) bool {
+				return MatchRelayURL(u, tag[1])
+			})
+		case "challenge":
+			challengeOK = challengeOK || tag[1] == challenge
+		}
+	}
+	if !relayOK {
+		return fmt.Errorf("%w: relay tag does not match %q", ErrInvalidAuthEvent, relayURLs)
+	}
+	if !challengeOK {
+		return fmt.Errorf("%w: challenge tag mismatch", ErrInvalidAuthEvent)
+	}
+
+	ok, err := event.Verify()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidAuthEvent, err)
+	}
+	if !ok {
+		return fmt.Errorf("%w: invalid signature", ErrInvalidAuthEvent)
+	}
+
+	return nil
+}
+
+// MatchRelayURL reports whether a and b point to the same relay.
+// Schemes and hosts are compared case-insensitively, default ports are ignored
+// and trailing dots of the host and trailing slashes of the path are trimmed.
+func MatchRelayURL(a, b string) bool {
+	ca, ok := NormalizeRelayURL(a)
+	if !ok {
+		return false
+	}
+	cb, ok := NormalizeRelayURL(b)
+	if !ok {
+		return false
+	}
+	return ca == cb
+}
+
+// NormalizeRelayURL returns the canonical form of a relay URL such as "wss://relay.example.com".
+// It reports false if s is not a relay URL.
+func NormalizeRelayURL(s string) (string, bool) {
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil || u.Host == "" {
+		return "", false
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+	switch scheme {
+	case "http":
+		scheme = "ws"
+	case "https":
+		scheme = "wss"
+	case "ws", "wss":
+	default:
+		return "", false
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	port := u.Port()
+	if (scheme == "ws" && port == "80") || (scheme == "wss" && port == "443") {
+		port = ""
+	}
+	if port != "" {
+		host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+
+	path := strings.TrimRight(u.Path, "/")
+
+	return scheme + "://" + host + path, true
+}
+
+func requestRelayURL(r *http.Request) string {
+	scheme := "ws"
+	if r.TLS != nil {
+		scheme = "wss"
+	}
+	return scheme + "://" + r.Host + r.URL.Path
+}
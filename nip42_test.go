@@ -0,0 +1,186 @@
+package mocrelay
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchRelayURL(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want bool
+	}{
+		{"same", "wss://relay.example.com", "wss://relay.example.com", true},
+		{"trailing slash", "wss://relay.example.com", "wss://relay.example.com/", true},
+		{"case", "wss://relay.example.com", "WSS://Relay.Example.com", true},
+		{"default port", "wss://relay.example.com", "wss://relay.example.com:443", true},
+		{"default port ws", "ws://relay.example.com:80/", "ws://relay.example.com", true},
+		{"http scheme", "wss://relay.example.com", "https://relay.example.com", true},
+		{"path", "wss://example.com/relay", "wss://example.com/relay/", true},
+		{"trailing dot", "wss://relay.example.com", "wss://relay.example.com./", true},
+		{"other port", "wss://relay.example.com", "wss://relay.example.com:8443", false},
+		{"other scheme", "wss://relay.example.com", "ws://relay.example.com", false},
+		{"other host", "wss://relay.example.com", "wss://relay.example.org", false},
+		{"other path", "wss://example.com/relay", "wss://example.com", false},
+		{"no scheme", "wss://relay.example.com", "relay.example.com", false},
+		{"empty", "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, MatchRelayURL(tt.a, tt.b))
+		})
+	}
+}
+
+func signTestEvent(t *testing.T, event *Event) *Event {
+	t.Helper()
+
+	seed := sha256.Sum256([]byte("mocrelay"))
+	priv, pub := btcec.PrivKeyFromBytes(seed[:])
+	event.Pubkey = hex.EncodeToString(schnorr.SerializePubKey(pub))
+
+	serialized, err := event.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := sha256.Sum256(serialized)
+	event.ID = hex.EncodeToString(id[:])
+
+	sig, err := schnorr.Sign(priv, id[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	event.Sig = hex.EncodeToString(sig.Serialize())
+
+	return event
+}
+
+func TestValidateAuthEvent(t *testing.T) {
+	const relayURL = "wss://relay.example.com"
+	const challenge = "challengestringhere"
+
+	newAuthEvent := func(kind int64, createdAt time.Time, tags []Tag) *Event {
+		return signTestEvent(t, &Event{
+			CreatedAt: createdAt.Unix(),
+			Kind:      kind,
+			Tags:      tags,
+		})
+	}
+
+	tests := []struct {
+		name  string
+		event *Event
+		ok    bool
+	}{
+		{
+			name: "ok",
+			event: newAuthEvent(AuthEventKind, time.Now(), []Tag{
+				{"relay", "wss://relay.example.com/"},
+				{"challenge", challenge},
+			}),
+			ok: true,
+		},
+		{
+			name: "ng: kind",
+			event: newAuthEvent(1, time.Now(), []Tag{
+				{"relay", relayURL},
+				{"challenge", challenge},
+			}),
+			ok: false,
+		},
+		{
+			name: "ng: created_at",
+			event: newAuthEvent(AuthEventKind, time.Now().Add(-time.Hour), []Tag{
+				{"relay", relayURL},
+				{"challenge", challenge},
+			}),
+			ok: false,
+		},
+		{
+			name: "ng: relay",
+			event: newAuthEvent(AuthEventKind, time.Now(), []Tag{
+				{"relay", "wss://other.example.com"},
+				{"challenge", challenge},
+			}),
+			ok: false,
+		},
+		{
+			name: "ng: no relay",
+			event: newAuthEvent(AuthEventKind, time.Now(), []Tag{
+				{"challenge", challenge},
+			}),
+			ok: false,
+		},
+		{
+			name: "ng: challenge",
+			event: newAuthEvent(AuthEventKind, time.Now(), []Tag{
+				{"relay", relayURL},
+				{"challenge", "other"},
+			}),
+			ok: false,
+		},
+		{
+			name: "ng: sig",
+			event: func() *Event {
+				ev := newAuthEvent(AuthEventKind, time.Now(), []Tag{
+					{"relay", relayURL},
+					{"challenge", challenge},
+				})
+				ev.Content = "tampered"
+				return ev
+			}(),
+			ok: false,
+		},
+		{
+			name:  "ng: nil",
+			event: nil,
+			ok:    false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateAuthEvent(tt.event, relayURL, challenge)
+			if tt.ok {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, ErrInvalidAuthEvent)
+			}
+		})
+	}
+}
+
+func TestValidateAuthEventURLs(t *testing.T) {
+	const challenge = "challengestringhere"
+	relayURLs := []string{"wss://relay.example.com", "ws://relayexample.onion"}
+
+	newAuthEvent := func(relayURL string) *Event {
+		return signTestEvent(t, &Event{
+			CreatedAt: time.Now().Unix(),
+			Kind:      AuthEventKind,
+			Tags:      []Tag{{"relay", relayURL}, {"challenge", challenge}},
+		})
+	}
+
+	assert.NoError(
+		t,
+		ValidateAuthEventURLs(newAuthEvent("wss://relay.example.com/"), relayURLs, challenge),
+	)
+	assert.NoError(
+		t,
+		ValidateAuthEventURLs(newAuthEvent("ws://RelayExample.onion:80"), relayURLs, challenge),
+	)
+	assert.ErrorIs(
+		t,
+		ValidateAuthEventURLs(newAuthEvent("wss://relayexample.onion"), relayURLs, challenge),
+		ErrInvalidAuthEvent,
+	)
+}
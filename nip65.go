@@ -0,0 +1,64 @@
+package mocrelay
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+const RelayListEventKind = 10002
+
+var ErrInvalidRelayList = errors.New("invalid relay list")
+
+// RelayList is a NIP-65 relay list of a pubkey.
+// Read relays receive the events mentioning the pubkey
+// and write relays receive the events written by the pubkey.
+type RelayList struct {
+	Pubkey    string
+	CreatedAt int64
+	Read      []string
+	Write     []string
+}
+
+// ParseRelayList parses a kind 10002 event. The relay URLs are normalized
+// and invalid ones are ignored.
+func ParseRelayList(event *Event) (*RelayList, error) {
+	if event == nil {
+		return nil, fmt.Errorf("%w: nil event", ErrInvalidRelayList)
+	}
+	if event.Kind != RelayListEventKind {
+		return nil, fmt.Errorf(
+			"%w: kind must be %d but got %d",
+			ErrInvalidRelayList,
+			RelayListEventKind,
+			event.Kind,
+		)
+	}
+
+	ret := &RelayList{
+		Pubkey:    event.Pubkey,
+		CreatedAt: event.CreatedAt,
+	}
+	for _, tag := range event.Tags {
+		if len(tag) < 2 || tag[0] != "r" {
+			continue
+		}
+		u, ok := NormalizeRelayURL(tag[1])
+		if !ok {
+			continue
+		}
+
+		marker := ""
+		if len(tag) >= 3 {
+			marker = tag[2]
+		}
+		if marker != "write" && !slices.Contains(ret.Read, u) {
+			ret.Read = append(ret.Read, u)
+		}
+		if marker != "read" && !slices.Contains(ret.Write, u) {
+			ret.Write = append(ret.Write, u)
+		}
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,68 @@
+package mocrelay
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRelayList(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      *Event
+		want    *RelayList
+		wantErr bool
+	}{
+		{
+			name: "markers",
+			in: &Event{
+				Pubkey:    "pubkey",
+				CreatedAt: 100,
+				Kind:      RelayListEventKind,
+				Tags: []Tag{
+					{"r", "wss://both.example.com"},
+					{"r", "wss://read.example.com", "read"},
+					{"r", "wss://write.example.com/", "write"},
+					{"r", "WSS://Both.example.com"},
+					{"r", "relay.example.com"},
+					{"p", "wss://p.example.com"},
+					{"r"},
+				},
+			},
+			want: &RelayList{
+				Pubkey:    "pubkey",
+				CreatedAt: 100,
+				Read:      []string{"wss://both.example.com", "wss://read.example.com"},
+				Write:     []string{"wss://both.example.com", "wss://write.example.com"},
+			},
+		},
+		{
+			name: "empty",
+			in:   &Event{Pubkey: "pubkey", Kind: RelayListEventKind, Tags: []Tag{}},
+			want: &RelayList{Pubkey: "pubkey"},
+		},
+		{
+			name:    "other kind",
+			in:      &Event{Kind: 1},
+			wantErr: true,
+		},
+		{
+			name:    "nil",
+			in:      nil,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRelayList(tt.in)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidRelayList)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package mocrelay
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const HTTPAuthEventKind = 27235
+
+var ErrInvalidNIP98Auth = errors.New("invalid nip-98 authorization")
+
+type NIP98Option struct {
+	// URL is the expected absolute request URL.
+	// If empty, it is built from the request, which is wrong behind a proxy
+	// rewriting the scheme or the host.
+	URL string
+
+	// MaxAge is the allowed difference between created_at and now. Default is 60s.
+	MaxAge time.Duration
+
+	// RequirePayload requires a payload tag for requests with a body.
+	RequirePayload bool
+
+	// MaxBodySize limits the body read to check the payload hash. Default is 1MiB.
+	MaxBodySize int64
+}
+
+func (opt *NIP98Option) maxAge() time.Duration {
+	if opt == nil || opt.MaxAge <= 0 {
+		return 60 * time.Second
+	}
+	return opt.MaxAge
+}
+
+func (opt *NIP98Option) requirePayload() bool {
+	return opt != nil && opt.RequirePayload
+}
+
+func (opt *NIP98Option) maxBodySize() int64 {
+	if opt == nil || opt.MaxBodySize <= 0 {
+		return 1 << 20
+	}
+	return opt.MaxBodySize
+}
+
+func (opt *NIP98Option) url(r *http.Request) string {
+	if opt != nil && opt.URL != "" {
+		return opt.URL
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + r.URL.RequestURI()
+}
+
+// VerifyNIP98 validates the "Authorization: Nostr <base64 event>" header of r
+// and returns the event. See VerifyNIP98WithOption.
+func VerifyNIP98(r *http.Request) (*Event, error) {
+	return VerifyNIP98WithOption(r, nil)
+}
+
+// VerifyNIP98WithOption validates the kind, created_at, the u and method tags,
+// the payload tag against the body and the signature of the authorization event.
+// If the body is read, r.Body is replaced so that handlers can read it again.
+func VerifyNIP98WithOption(r *http.Request, option *NIP98Option) (*Event, error) {
+	event, err := parseNIP98Header(r.Header.Get("Authorization"))
+	if err != nil {
+		return nil, err
+	}
+
+	if event.Kind != HTTPAuthEventKind {
+		return nil, fmt.Errorf(
+			"%w: kind must be %d but got %d",
+			ErrInvalidNIP98Auth,
+			HTTPAuthEventKind,
+			event.Kind,
+		)
+	}
+
+	maxAge := option.maxAge()
+	if d := time.Since(event.CreatedAtTime()); d > maxAge || d < -maxAge {
+		return nil, fmt.Errorf("%w: created_at is too far from now", ErrInvalidNIP98Auth)
+	}
+
+	var u, method, payload string
+	var hasU, hasMethod, hasPayload bool
+	for _, tag := range event.Tags {
+		if len(tag) < 2 {
+			continue
+		}
+		switch tag[0] {
+		case "u":
+			u, hasU = tag[1], true
+		case "method":
+			method, hasMethod = tag[1], true
+		case "payload":
+			payload, hasPayload = tag[1], true
+		}
+	}
+
+	if want := option.url(r); !hasU || u != want {
+		return nil, fmt.Errorf("%w: u tag does not match %q", ErrInvalidNIP98Auth, want)
+	}
+	if !hasMethod || !strings.EqualFold(method, r.Method) {
+		return nil, fmt.Errorf("%w: method tag does not match %q", ErrInvalidNIP98Auth, r.Method)
+	}
+
+	if hasPayload || option.requirePayload() {
+		if err := checkNIP98Payload(r, payload, hasPayload, option.maxBodySize()); err != nil {
+			return nil, err
+		}
+	}
+
+	ok, err := event.Verify()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidNIP98Auth, err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: invalid signature", ErrInvalidNIP98Auth)
+	}
+
+	return event, nil
+}
+
+func parseNIP98Header(header string) (*Event, error) {
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Nostr") {
+		return nil, fmt.Errorf("%w: authorization scheme must be Nostr", ErrInvalidNIP98Auth)
+	}
+
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(token))
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid base64: %w", ErrInvalidNIP98Auth, err)
+	}
+
+	var event Event
+	if err := json.Unmarshal(b, &event); err != nil {
+		return nil, fmt.Errorf("%w: invalid event: %w", ErrInvalidNIP98Auth, err)
+	}
+	return &event, nil
+}
+
+func checkNIP98Payload(r *http.Request, payload string, hasPayload bool, maxBodySize int64) error {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		body, err = io.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+		r.Body.Close()
+		if err != nil {
+			return fmt.Errorf("%w: failed to read body: %w", ErrInvalidNIP98Auth, err)
+		}
+		if int64(len(body)) > maxBodySize {
+			return fmt.Errorf("%w: body is too large", ErrInvalidNIP98Auth)
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	if !hasPayload {
+		if len(body) == 0 {
+			return nil
+		}
+		return fmt.Errorf("%w: payload tag is required", ErrInvalidNIP98Auth)
+	}
+
+	sum := sha256.Sum256(body)
+	if !strings.EqualFold(payload, hex.EncodeToString(sum[:])) {
+		return fmt.Errorf("%w: payload tag does not match the body", ErrInvalidNIP98Auth)
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package mocrelay
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestNIP98Request(t *testing.T, method, url, body string, event *Event) *http.Request {
+	t.Helper()
+
+	r := httptest.NewRequest(method, url, strings.NewReader(body))
+	if event != nil {
+		b, err := event.MarshalJSON()
+		require.NoError(t, err)
+		r.Header.Set("Authorization", "Nostr "+base64.StdEncoding.EncodeToString(b))
+	}
+	return r
+}
+
+func newTestNIP98Event(t *testing.T, kind int64, createdAt time.Time, tags []Tag) *Event {
+	t.Helper()
+	return signTestEvent(t, &Event{
+		CreatedAt: createdAt.Unix(),
+		Kind:      kind,
+		Tags:      tags,
+	})
+}
+
+func TestVerifyNIP98(t *testing.T) {
+	const url = "http://relay.example.com/admin?x=1"
+	const body = `{"method":"supportedmethods","params":[]}`
+
+	sum := sha256.Sum256([]byte(body))
+	payload := hex.EncodeToString(sum[:])
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		method  string
+		body    string
+		event   *Event
+		opt     *NIP98Option
+		wantErr bool
+	}{
+		{
+			name:   "ok",
+			method: http.MethodGet,
+			event: newTestNIP98Event(t, HTTPAuthEventKind, now, []Tag{
+				{"u", url},
+				{"method", "GET"},
+			}),
+		},
+		{
+			name:   "ok: payload",
+			method: http.MethodPost,
+			body:   body,
+			event: newTestNIP98Event(t, HTTPAuthEventKind, now, []Tag{
+				{"u", url},
+				{"method", "POST"},
+				{"payload", payload},
+			}),
+			opt: &NIP98Option{RequirePayload: true},
+		},
+		{
+			name:   "ok: url option",
+			method: http.MethodGet,
+			event: newTestNIP98Event(t, HTTPAuthEventKind, now, []Tag{
+				{"u", "https://relay.example.com/admin?x=1"},
+				{"method", "GET"},
+			}),
+			opt: &NIP98Option{URL: "https://relay.example.com/admin?x=1"},
+		},
+		{
+			name:    "ng: no header",
+			method:  http.MethodGet,
+			wantErr: true,
+		},
+		{
+			name:   "ng: kind",
+			method: http.MethodGet,
+			event: newTestNIP98Event(t, 1, now, []Tag{
+				{"u", url},
+				{"method", "GET"},
+			}),
+			wantErr: true,
+		},
+		{
+			name:   "ng: too old",
+			method: http.MethodGet,
+			event: newTestNIP98Event(t, HTTPAuthEventKind, now.Add(-2*time.Minute), []Tag{
+				{"u", url},
+				{"method", "GET"},
+			}),
+			wantErr: true,
+		},
+		{
+			name:   "ng: url",
+			method: http.MethodGet,
+			event: newTestNIP98Event(t, HTTPAuthEventKind, now, []Tag{
+				{"u", "http://relay.example.com/admin"},
+				{"method", "GET"},
+			}),
+			wantErr: true,
+		},
+		{
+			name:   "ng: method",
+			method: http.MethodPost,
+			event: newTestNIP98Event(t, HTTPAuthEventKind, now, []Tag{
+				{"u", url},
+				{"method", "GET"},
+			}),
+			wantErr: true,
+		},
+		{
+			name:   "ng: payload mismatch",
+			method: http.MethodPost,
+			body:   body + " ",
+			event: newTestNIP98Event(t, HTTPAuthEventKind, now, []Tag{
+				{"u", url},
+				{"method", "POST"},
+				{"payload", payload},
+			}),
+			wantErr: true,
+		},
+		{
+			name:   "ng: payload required",
+			method: http.MethodPost,
+			body:   body,
+			event: newTestNIP98Event(t, HTTPAuthEventKind, now, []Tag{
+				{"u", url},
+				{"method", "POST"},
+			}),
+			opt:     &NIP98Option{RequirePayload: true},
+			wantErr: true,
+		},
+		{
+			name:   "ng: body too large",
+			method: http.MethodPost,
+			body:   body,
+			event: newTestNIP98Event(t, HTTPAuthEventKind, now, []Tag{
+				{"u", url},
+				{"method", "POST"},
+				{"payload", payload},
+			}),
+			opt:     &NIP98Option{MaxBodySize: 10},
+			wantErr: true,
+		},
+		{
+			name:   "ng: signature",
+			method: http.MethodGet,
+			event: func() *Event {
+				ev := newTestNIP98Event(t, HTTPAuthEventKind, now, []Tag{
+					{"u", url},
+					{"method", "GET"},
+				})
+				ev.Sig = strings.Repeat("0", 128)
+				return ev
+			}(),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestNIP98Request(t, tt.method, url, tt.body, tt.event)
+
+			event, err := VerifyNIP98WithOption(r, tt.opt)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidNIP98Auth)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.event.ID, event.ID)
+
+			b, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			assert.Equal(t, tt.body, string(b))
+		})
+	}
+}
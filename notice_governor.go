@@ -0,0 +1,152 @@
+package mocrelay
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrTooManyInvalidMsgs = errors.New("too many invalid messages")
+
+type NoticeGovernorOption struct {
+	// Rate is the interval to allow a new notice per connection. 0 means no rate limit.
+	Rate  time.Duration
+	Burst int
+
+	// DedupWindow drops a notice identical to one sent within the window.
+	DedupWindow time.Duration
+
+	// SummarizeAfter is the number of invalid messages which get their own notices.
+	// Notices for further invalid messages are suppressed and summarized
+	// at most once per SummaryInterval.
+	SummarizeAfter int
+
+	// SummaryInterval is the min interval of the summaries. Default is 1 minute.
+	SummaryInterval time.Duration
+
+	// MaxInvalidMsgs is the number of invalid messages to disconnect the connection.
+	// 0 means never disconnect.
+	MaxInvalidMsgs int
+}
+
+func (opt *NoticeGovernorOption) summarizeAfter() int {
+	const defaultSummarizeAfter = 3
+
+	if opt.SummarizeAfter <= 0 {
+		return defaultSummarizeAfter
+	}
+	return opt.SummarizeAfter
+}
+
+func (opt *NoticeGovernorOption) summaryInterval() time.Duration {
+	if opt.SummaryInterval <= 0 {
+		return time.Minute
+	}
+	return opt.SummaryInterval
+}
+
+type noticeGovernor struct {
+	opt *NoticeGovernorOption
+	l   *rateLimiter
+
+	mu sync.Mutex
+	// map[message]sentAt
+	recent     map[string]time.Time
+	invalid    int
+	suppressed int
+	// summarizedAt is when the suppressed notices are summarized last.
+	summarizedAt time.Time
+}
+
+func newNoticeGovernor(option *NoticeGovernorOption) *noticeGovernor {
+	if option == nil {
+		return nil
+	}
+	return &noticeGovernor{
+		opt:    option,
+		l:      newRateLimiter(option.Rate, option.Burst),
+		recent: make(map[string]time.Time),
+	}
+}
+
+func (g *noticeGovernor) Stop() {
+	if g == nil {
+		return
+	}
+	g.l.Stop()
+}
+
+// allow reports whether the notice can be sent now.
+func (g *noticeGovernor) allow(msg *ServerNoticeMsg) bool {
+	if g == nil {
+		return true
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+
+	if g.opt.DedupWindow > 0 {
+		for m, sentAt := range g.recent {
+			if now.Sub(sentAt) >= g.opt.DedupWindow {
+				delete(g.recent, m)
+			}
+		}
+		if _, ok := g.recent[msg.Message]; ok {
+			return false
+		}
+	}
+
+	select {
+	case <-g.l.C:
+	default:
+		return false
+	}
+
+	if g.opt.DedupWindow > 0 {
+		g.recent[msg.Message] = now
+	}
+	return true
+}
+
+// invalidMsg counts an invalid client message and returns the notice to send for it.
+// The notice is nil if suppressed, or a summary of the suppressed ones if it is time to.
+// If disconnect is true, the returned notice is the summary
+// and the connection should be closed.
+func (g *noticeGovernor) invalidMsg(
+	notice *ServerNoticeMsg,
+) (ret *ServerNoticeMsg, disconnect bool) {
+	if g == nil {
+		return notice, false
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.invalid++
+
+	if g.opt.MaxInvalidMsgs > 0 && g.invalid >= g.opt.MaxInvalidMsgs {
+		return NewServerNoticeMsgf(
+			"%d invalid messages received (%d notices suppressed): disconnecting",
+			g.invalid,
+			g.suppressed,
+		), true
+	}
+
+	if g.invalid <= g.opt.summarizeAfter() {
+		return notice, false
+	}
+
+	g.suppressed++
+	now := time.Now()
+	if now.Sub(g.summarizedAt) < g.opt.summaryInterval() {
+		return nil, false
+	}
+	g.summarizedAt = now
+	return NewServerNoticeMsgf(
+		"%d invalid messages received (%d notices suppressed)",
+		g.invalid,
+		g.suppressed,
+	), false
+}
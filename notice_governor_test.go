@@ -0,0 +1,124 @@
+package mocrelay
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNoticeGovernor_allow(t *testing.T) {
+	t.Run("nil", func(t *testing.T) {
+		var g *noticeGovernor
+		for i := 0; i < 10; i++ {
+			assert.True(t, g.allow(NewServerNoticeMsg("powa")))
+		}
+	})
+
+	t.Run("dedup", func(t *testing.T) {
+		g := newNoticeGovernor(&NoticeGovernorOption{DedupWindow: 50 * time.Millisecond})
+		defer g.Stop()
+
+		assert.True(t, g.allow(NewServerNoticeMsg("powa")))
+		assert.False(t, g.allow(NewServerNoticeMsg("powa")))
+		assert.True(t, g.allow(NewServerNoticeMsg("meu")))
+
+		time.Sleep(60 * time.Millisecond)
+		assert.True(t, g.allow(NewServerNoticeMsg("powa")))
+	})
+
+	t.Run("rate limit", func(t *testing.T) {
+		g := newNoticeGovernor(&NoticeGovernorOption{Rate: time.Hour, Burst: 2})
+		defer g.Stop()
+
+		assert.True(t, g.allow(NewServerNoticeMsg("1")))
+		assert.True(t, g.allow(NewServerNoticeMsg("2")))
+		assert.False(t, g.allow(NewServerNoticeMsg("3")))
+	})
+
+	t.Run("dropped notice is not remembered", func(t *testing.T) {
+		g := newNoticeGovernor(&NoticeGovernorOption{
+			Rate:        time.Hour,
+			Burst:       1,
+			DedupWindow: time.Hour,
+		})
+		defer g.Stop()
+
+		assert.True(t, g.allow(NewServerNoticeMsg("1")))
+		assert.False(t, g.allow(NewServerNoticeMsg("2")))
+		assert.Len(t, g.recent, 1)
+	})
+}
+
+func TestNoticeGovernor_invalidMsg(t *testing.T) {
+	t.Run("nil", func(t *testing.T) {
+		var g *noticeGovernor
+		notice := NewServerNoticeMsg("invalid")
+		for i := 0; i < 100; i++ {
+			got, disconnect := g.invalidMsg(notice)
+			assert.Equal(t, notice, got)
+			assert.False(t, disconnect)
+		}
+	})
+
+	t.Run("summarize and disconnect", func(t *testing.T) {
+		g := newNoticeGovernor(&NoticeGovernorOption{SummarizeAfter: 2, MaxInvalidMsgs: 5})
+		defer g.Stop()
+
+		notice := NewServerNoticeMsg("invalid")
+
+		for i := 0; i < 2; i++ {
+			got, disconnect := g.invalidMsg(notice)
+			assert.Equal(t, notice, got)
+			assert.False(t, disconnect)
+		}
+		got, disconnect := g.invalidMsg(notice)
+		assert.Equal(
+			t,
+			NewServerNoticeMsg("3 invalid messages received (1 notices suppressed)"),
+			got,
+		)
+		assert.False(t, disconnect)
+		got, disconnect = g.invalidMsg(notice)
+		assert.Nil(t, got)
+		assert.False(t, disconnect)
+
+		got, disconnect = g.invalidMsg(notice)
+		assert.True(t, disconnect)
+		assert.Equal(
+			t,
+			NewServerNoticeMsg("5 invalid messages received (2 notices suppressed): disconnecting"),
+			got,
+		)
+	})
+	t.Run("summarize without disconnect", func(t *testing.T) {
+		g := newNoticeGovernor(&NoticeGovernorOption{SummarizeAfter: 1})
+		defer g.Stop()
+
+		notice := NewServerNoticeMsg("invalid")
+
+		got, _ := g.invalidMsg(notice)
+		assert.Equal(t, notice, got)
+		got, _ = g.invalidMsg(notice)
+		assert.Equal(
+			t,
+			NewServerNoticeMsg("2 invalid messages received (1 notices suppressed)"),
+			got,
+		)
+		for i := 0; i < 100; i++ {
+			got, disconnect := g.invalidMsg(notice)
+			assert.Nil(t, got)
+			assert.False(t, disconnect)
+		}
+
+		// The next summary is sent after the interval.
+		g.summarizedAt = g.summarizedAt.Add(-time.Minute)
+		got, disconnect := g.invalidMsg(notice)
+		assert.False(t, disconnect)
+		assert.Equal(
+			t,
+			NewServerNoticeMsg("103 invalid messages received (102 notices suppressed)"),
+			got,
+		)
+	})
+}
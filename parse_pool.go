@@ -0,0 +1,88 @@
+package mocrelay
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+)
+
+// maxPooledParseBytes is the max input length whose temporaries are pooled.
+// Temporaries grown by larger inputs are left to the GC.
+const maxPooledParseBytes = 64 * 1024
+
+// maxPooledJSONObjLen is the max number of keys of pooled JSON objects.
+const maxPooledJSONObjLen = 64
+
+// numberDecoder is a json.Decoder with UseNumber reused across inputs.
+// Its buffer is empty between uses.
+type numberDecoder struct {
+	r   bytes.Reader
+	dec *json.Decoder
+}
+
+var numberDecoderPool = sync.Pool{
+	New: func() any {
+		d := new(numberDecoder)
+		d.dec = json.NewDecoder(&d.r)
+		d.dec.UseNumber()
+		return d
+	},
+}
+
+// decodeUseNumber decodes the first JSON value of b into v with json.Number for numbers.
+// Like json.Decoder, the data after the value are ignored.
+func decodeUseNumber(b []byte, v any) error {
+	b = bytes.TrimSpace(b)
+
+	d := numberDecoderPool.Get().(*numberDecoder)
+	d.r.Reset(b)
+
+	start := d.dec.InputOffset()
+	err := d.dec.Decode(v)
+	// The decoder can be reused only if no error is kept and nothing is left in its buffer.
+	if err == nil && d.dec.InputOffset()-start == int64(len(b)) && len(b) <= maxPooledParseBytes {
+		numberDecoderPool.Put(d)
+	}
+	return err
+}
+
+var rawMsgsPool = sync.Pool{
+	New: func() any { return new([]json.RawMessage) },
+}
+
+// getRawMsgs returns a slice to unmarshal a JSON array into.
+// Unmarshaling reuses the slice and its elements, so they must not be retained
+// after putRawMsgs.
+func getRawMsgs() *[]json.RawMessage {
+	return rawMsgsPool.Get().(*[]json.RawMessage)
+}
+
+func putRawMsgs(p *[]json.RawMessage) {
+	var n int
+	for _, m := range (*p)[:cap(*p)] {
+		n += cap(m)
+	}
+	if n > maxPooledParseBytes {
+		return
+	}
+	*p = (*p)[:0]
+	rawMsgsPool.Put(p)
+}
+
+var jsonObjPool = sync.Pool{
+	New: func() any { return make(map[string]any) },
+}
+
+// getJSONObj returns an empty map to decode a JSON object into.
+// The values must not be retained after putJSONObj except for strings and numbers.
+func getJSONObj() map[string]any {
+	return jsonObjPool.Get().(map[string]any)
+}
+
+func putJSONObj(obj map[string]any) {
+	if len(obj) > maxPooledJSONObjLen {
+		return
+	}
+	clear(obj)
+	jsonObjPool.Put(obj)
+}
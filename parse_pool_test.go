@@ -0,0 +1,72 @@
+package mocrelay
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeUseNumber(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    any
+		wantErr bool
+	}{
+		{
+			name:  "object",
+			input: ` {"a":1} `,
+			want:  map[string]any{"a": json.Number("1")},
+		},
+		{
+			name:  "trailing data",
+			input: `{"b":2}]`,
+			want:  map[string]any{"b": json.Number("2")},
+		},
+		{
+			name:    "truncated",
+			input:   `{"c":`,
+			wantErr: true,
+		},
+		{
+			name:  "after errors",
+			input: `[3.5]`,
+			want:  []any{json.Number("3.5")},
+		},
+	}
+
+	// The decoders are reused across the cases.
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got any
+			err := decodeUseNumber([]byte(tt.input), &got)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRawMsgsPool(t *testing.T) {
+	p := getRawMsgs()
+	assert.NoError(t, json.Unmarshal([]byte(`["EVENT",{"a":"b"},3]`), p))
+	putRawMsgs(p)
+
+	p = getRawMsgs()
+	defer putRawMsgs(p)
+	assert.Empty(t, *p)
+	assert.NoError(t, json.Unmarshal([]byte(`["CLOSE"]`), p))
+	assert.Equal(t, []json.RawMessage{json.RawMessage(`"CLOSE"`)}, *p)
+}
+
+func TestJSONObjPool(t *testing.T) {
+	obj := getJSONObj()
+	obj["a"] = 1
+	putJSONObj(obj)
+
+	assert.Empty(t, getJSONObj())
+}
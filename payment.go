@@ -0,0 +1,476 @@
+package mocrelay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvoiceNotFound = errors.New("invoice not found")
+	ErrInvoiceProvider = errors.New("invoice provider error")
+)
+
+type Invoice struct {
+	// ID is the payment hash.
+	ID         string `json:"id"`
+	Bolt11     string `json:"bolt11"`
+	AmountMsat int64  `json:"amount_msat"`
+}
+
+// InvoiceProvider issues lightning invoices. LNbitsProvider is built in and
+// LND or CLN nodes can be plugged in by implementing this interface.
+type InvoiceProvider interface {
+	CreateInvoice(ctx context.Context, amountMsat int64, memo string) (*Invoice, error)
+	InvoicePaid(ctx context.Context, id string) (bool, error)
+}
+
+// AdmissionStore stores paying pubkeys with their expiry.
+type AdmissionStore interface {
+	Admit(ctx context.Context, pubkey string, until time.Time) error
+	AdmittedUntil(ctx context.Context, pubkey string) (until time.Time, ok bool, err error)
+}
+
+type MemoryAdmissionStore struct {
+	mu sync.RWMutex
+	// map[pubkey]until
+	m map[string]time.Time
+}
+
+var _ AdmissionStore = (*MemoryAdmissionStore)(nil)
+
+func NewMemoryAdmissionStore() *MemoryAdmissionStore {
+	return &MemoryAdmissionStore{m: make(map[string]time.Time)}
+}
+
+func (s *MemoryAdmissionStore) Admit(ctx context.Context, pubkey string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.m[pubkey] = until
+	return nil
+}
+
+func (s *MemoryAdmissionStore) AdmittedUntil(
+	ctx context.Context,
+	pubkey string,
+) (time.Time, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	until, ok := s.m[pubkey]
+	return until, ok, nil
+}
+
+type PaidAdmissionOption struct {
+	Provider InvoiceProvider
+
+	// Store is the admission store. Default is a new MemoryAdmissionStore.
+	Store AdmissionStore
+
+	AmountMsat int64
+
+	// Period is how long a payment admits the pubkey. Default is 30 days.
+	Period time.Duration
+
+	// PaymentURL is where non-payers are directed to.
+	PaymentURL string
+}
+
+func (opt *PaidAdmissionOption) period() time.Duration {
+	if opt.Period <= 0 {
+		return 30 * 24 * time.Hour
+	}
+	return opt.Period
+}
+
+// PaidAdmission admits pubkeys which paid an invoice.
+// Its ServeHTTP issues invoices with POST {"pubkey": "..."} and
+// checks them with GET ?id=<invoice id>.
+type PaidAdmission struct {
+	opt   *PaidAdmissionOption
+	store AdmissionStore
+
+	mu sync.Mutex
+	// map[invoiceID]pendingInvoice
+	pending map[string]pendingInvoice
+}
+
+type pendingInvoice struct {
+	pubkey    string
+	createdAt time.Time
+}
+
+func NewPaidAdmission(option *PaidAdmissionOption) *PaidAdmission {
+	if option == nil || option.Provider == nil {
+		panic("paid admission option must have a provider")
+	}
+
+	store := option.Store
+	if store == nil {
+		store = NewMemoryAdmissionStore()
+	}
+
+	return &PaidAdmission{
+		opt:     option,
+		store:   store,
+		pending: make(map[string]pendingInvoice),
+	}
+}
+
+func (p *PaidAdmission) Admitted(ctx context.Context, pubkey string) (bool, error) {
+	until, ok, err := p.store.AdmittedUntil(ctx, pubkey)
+	if err != nil || !ok {
+		return false, err
+	}
+	return time.Now().Before(until), nil
+}
+
+func (p *PaidAdmission) RequestInvoice(ctx context.Context, pubkey string) (*Invoice, error) {
+	if !validPubkey(pubkey) {
+		return nil, errors.New("invalid pubkey")
+	}
+
+	inv, err := p.opt.Provider.CreateInvoice(ctx, p.opt.AmountMsat, "mocrelay admission: "+pubkey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create invoice: %w", err)
+	}
+
+	const pendingTTL = 24 * time.Hour
+	now := time.Now()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for id, pi := range p.pending {
+		if now.Sub(pi.createdAt) > pendingTTL {
+			delete(p.pending, id)
+		}
+	}
+	p.pending[inv.ID] = pendingInvoice{pubkey: pubkey, createdAt: now}
+
+	return inv, nil
+}
+
+// CheckInvoice admits the pubkey of the invoice if it is paid.
+func (p *PaidAdmission) CheckInvoice(ctx context.Context, id string) (time.Time, bool, error) {
+	p.mu.Lock()
+	pi, ok := p.pending[id]
+	p.mu.Unlock()
+	if !ok {
+		return time.Time{}, false, ErrInvoiceNotFound
+	}
+
+	paid, err := p.opt.Provider.InvoicePaid(ctx, id)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("failed to check invoice: %w", err)
+	}
+	if !paid {
+		return time.Time{}, false, nil
+	}
+
+	p.mu.Lock()
+	_, ok = p.pending[id]
+	delete(p.pending, id)
+	p.mu.Unlock()
+	if !ok {
+		// Another request has already admitted it.
+		return p.store.AdmittedUntil(ctx, pi.pubkey)
+	}
+
+	// Extend the current admission if any.
+	start := time.Now()
+	if until, ok, err := p.store.AdmittedUntil(ctx, pi.pubkey); err == nil && ok &&
+		until.After(start) {
+		start = until
+	}
+	until := start.Add(p.opt.period())
+
+	if err := p.store.Admit(ctx, pi.pubkey, until); err != nil {
+		return time.Time{}, false, fmt.Errorf("failed to admit pubkey: %w", err)
+	}
+	return until, true, nil
+}
+
+// Fees returns NIP-11 fees.
+func (p *PaidAdmission) Fees() *NIP11Fees {
+	return &NIP11Fees{
+		Admission: []NIP11Fee{{
+			Amount: p.opt.AmountMsat,
+			Unit:   "msats",
+			Period: int64(p.opt.period() / time.Second),
+		}},
+	}
+}
+
+// ApplyNIP11 advertises the payment requirement and fees in nip11.
+func (p *PaidAdmission) ApplyNIP11(nip11 *NIP11) {
+	if nip11.Limitation == nil {
+		nip11.Limitation = new(NIP11Limitation)
+	}
+	nip11.Limitation.PaymentRequired = true
+	nip11.PaymentsURL = p.opt.PaymentURL
+	nip11.Fees = p.Fees()
+}
+
+func (p *PaidAdmission) paymentRequiredMsg() string {
+	if p.opt.PaymentURL == "" {
+		return "payment required"
+	}
+	return "payment required: " + p.opt.PaymentURL
+}
+
+func (p *PaidAdmission) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodPost:
+		var req struct {
+			Pubkey string `json:"pubkey"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writePaymentError(w, http.StatusBadRequest, "invalid request")
+			return
+		}
+
+		inv, err := p.RequestInvoice(r.Context(), req.Pubkey)
+		if err != nil {
+			writePaymentError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		json.NewEncoder(w).Encode(inv)
+
+	case http.MethodGet:
+		until, paid, err := p.CheckInvoice(r.Context(), r.URL.Query().Get("id"))
+		if errors.Is(err, ErrInvoiceNotFound) {
+			writePaymentError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		if err != nil {
+			writePaymentError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+
+		resp := struct {
+			Paid      bool  `json:"paid"`
+			ExpiresAt int64 `json:"expires_at,omitempty"`
+		}{Paid: paid}
+		if paid {
+			resp.ExpiresAt = until.Unix()
+		}
+		json.NewEncoder(w).Encode(&resp)
+
+	default:
+		writePaymentError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func writePaymentError(w http.ResponseWriter, status int, msg string) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+type PaidAdmissionMiddleware Middleware
+
+// NewPaidAdmissionMiddleware rejects events from pubkeys which are not admitted.
+// REQ and COUNT are closed unless the session is authenticated with NIP-42
+// as an admitted pubkey, so it must be placed after the AuthMiddleware.
+func NewPaidAdmissionMiddleware(p *PaidAdmission) PaidAdmissionMiddleware {
+	if p == nil {
+		panic("paid admission must be non-nil pointer")
+	}
+	m := newSimplePaidAdmissionMiddleware(p)
+	return PaidAdmissionMiddleware(NewSimpleMiddleware(m))
+}
+
+var _ SimpleMiddlewareInterface = (*simplePaidAdmissionMiddleware)(nil)
+
+type simplePaidAdmissionMiddleware struct {
+	admission *PaidAdmission
+}
+
+func newSimplePaidAdmissionMiddleware(p *PaidAdmission) *simplePaidAdmissionMiddleware {
+	return &simplePaidAdmissionMiddleware{admission: p}
+}
+
+func (m *simplePaidAdmissionMiddleware) HandleStart(r *http.Request) (*http.Request, error) {
+	return r, nil
+}
+
+func (m *simplePaidAdmissionMiddleware) HandleStop(r *http.Request) error {
+	return nil
+}
+
+func (m *simplePaidAdmissionMiddleware) HandleClientMsg(
+	r *http.Request,
+	msg ClientMsg,
+) (<-chan ClientMsg, <-chan ServerMsg, error) {
+	switch msg := msg.(type) {
+	case *ClientReqMsg:
+		if closedMsg := m.checkSession(r, msg.SubscriptionID); closedMsg != nil {
+			return nil, newClosedBufCh[ServerMsg](closedMsg), nil
+		}
+
+	case *ClientCountMsg:
+		if closedMsg := m.checkSession(r, msg.SubscriptionID); closedMsg != nil {
+			return nil, newClosedBufCh[ServerMsg](closedMsg), nil
+		}
+
+	case *ClientEventMsg:
+		admitted, err := m.admission.Admitted(r.Context(), msg.Event.Pubkey)
+		if err != nil {
+			okMsg := NewServerOKMsg(
+				msg.Event.ID,
+				false,
+				ServerOkMsgPrefixError,
+				"failed to check admission",
+			)
+			return nil, newClosedBufCh[ServerMsg](okMsg), nil
+		}
+		if !admitted {
+			okMsg := NewServerOKMsg(
+				msg.Event.ID,
+				false,
+				ServerOkMsgPrefixRestricted,
+				m.admission.paymentRequiredMsg(),
+			)
+			return nil, newClosedBufCh[ServerMsg](okMsg), nil
+		}
+	}
+
+	return newClosedBufCh(msg), nil, nil
+}
+
+// checkSession returns CLOSED for subID unless the session pubkey is admitted.
+func (m *simplePaidAdmissionMiddleware) checkSession(
+	r *http.Request,
+	subID string,
+) *ServerClosedMsg {
+	pubkey := GetSession(r.Context()).Pubkey()
+	if pubkey == "" {
+		return NewServerClosedMsg(
+			subID,
+			ServerClosedMsgPrefixAuthRequired,
+			m.admission.paymentRequiredMsg(),
+		)
+	}
+
+	admitted, err := m.admission.Admitted(r.Context(), pubkey)
+	if err != nil {
+		return NewServerClosedMsg(subID, ServerClosedMsgPrefixError, "failed to check admission")
+	}
+	if !admitted {
+		return NewServerClosedMsg(
+			subID,
+			ServerClosedMsgPrefixRestricted,
+			m.admission.paymentRequiredMsg(),
+		)
+	}
+	return nil
+}
+
+func (m *simplePaidAdmissionMiddleware) HandleServerMsg(
+	r *http.Request,
+	msg ServerMsg,
+) (<-chan ServerMsg, error) {
+	return newClosedBufCh(msg), nil
+}
+
+// LNbitsProvider issues invoices on an LNbits wallet.
+type LNbitsProvider struct {
+	// URL is the base URL of the LNbits instance.
+	URL string
+	// APIKey is the invoice/read key of the wallet.
+	APIKey string
+
+	Client *http.Client
+}
+
+var _ InvoiceProvider = (*LNbitsProvider)(nil)
+
+func (p *LNbitsProvider) client() *http.Client {
+	if p.Client == nil {
+		return http.DefaultClient
+	}
+	return p.Client
+}
+
+func (p *LNbitsProvider) do(ctx context.Context, method, path string, body, dst any) error {
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		method,
+		strings.TrimRight(p.URL, "/")+path,
+		&buf,
+	)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Api-Key", p.APIKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrInvoiceNotFound
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%w: lnbits returned %s", ErrInvoiceProvider, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(dst)
+}
+
+func (p *LNbitsProvider) CreateInvoice(
+	ctx context.Context,
+	amountMsat int64,
+	memo string,
+) (*Invoice, error) {
+	req := struct {
+		Out    bool   `json:"out"`
+		Amount int64  `json:"amount"`
+		Memo   string `json:"memo"`
+	}{
+		// LNbits takes sats.
+		Amount: (amountMsat + 999) / 1000,
+		Memo:   memo,
+	}
+
+	var resp struct {
+		PaymentHash    string `json:"payment_hash"`
+		PaymentRequest string `json:"payment_request"`
+	}
+	if err := p.do(ctx, http.MethodPost, "/api/v1/payments", &req, &resp); err != nil {
+		return nil, err
+	}
+
+	return &Invoice{
+		ID:         resp.PaymentHash,
+		Bolt11:     resp.PaymentRequest,
+		AmountMsat: req.Amount * 1000,
+	}, nil
+}
+
+func (p *LNbitsProvider) InvoicePaid(ctx context.Context, id string) (bool, error) {
+	var resp struct {
+		Paid bool `json:"paid"`
+	}
+	if err := p.do(ctx, http.MethodGet, "/api/v1/payments/"+url.PathEscape(id), nil, &resp); err != nil {
+		return false, err
+	}
+	return resp.Paid, nil
+}
@@ -0,0 +1,266 @@
+package mocrelay
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testInvoiceProvider struct {
+	mu   sync.Mutex
+	n    int
+	paid map[string]bool
+}
+
+func newTestInvoiceProvider() *testInvoiceProvider {
+	return &testInvoiceProvider{paid: make(map[string]bool)}
+}
+
+func (p *testInvoiceProvider) CreateInvoice(
+	ctx context.Context,
+	amountMsat int64,
+	memo string,
+) (*Invoice, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.n++
+	id := strings.Repeat(string(rune('0'+p.n)), 64)
+	p.paid[id] = false
+	return &Invoice{ID: id, Bolt11: "lnbc" + id, AmountMsat: amountMsat}, nil
+}
+
+func (p *testInvoiceProvider) InvoicePaid(ctx context.Context, id string) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	paid, ok := p.paid[id]
+	if !ok {
+		return false, ErrInvoiceNotFound
+	}
+	return paid, nil
+}
+
+func (p *testInvoiceProvider) pay(id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.paid[id] = true
+}
+
+func TestPaidAdmission(t *testing.T) {
+	ctx := context.Background()
+	pubkey := strings.Repeat("a", 64)
+
+	provider := newTestInvoiceProvider()
+	p := NewPaidAdmission(&PaidAdmissionOption{
+		Provider:   provider,
+		AmountMsat: 21000,
+		Period:     time.Hour,
+		PaymentURL: "https://relay.example.com/pay",
+	})
+
+	admitted, err := p.Admitted(ctx, pubkey)
+	require.NoError(t, err)
+	assert.False(t, admitted)
+
+	_, err = p.RequestInvoice(ctx, "invalid")
+	assert.Error(t, err)
+
+	inv, err := p.RequestInvoice(ctx, pubkey)
+	require.NoError(t, err)
+	assert.Equal(t, int64(21000), inv.AmountMsat)
+
+	_, paid, err := p.CheckInvoice(ctx, inv.ID)
+	require.NoError(t, err)
+	assert.False(t, paid)
+
+	provider.pay(inv.ID)
+	until, paid, err := p.CheckInvoice(ctx, inv.ID)
+	require.NoError(t, err)
+	assert.True(t, paid)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), until, time.Minute)
+
+	admitted, err = p.Admitted(ctx, pubkey)
+	require.NoError(t, err)
+	assert.True(t, admitted)
+
+	// A second payment extends the admission.
+	inv, err = p.RequestInvoice(ctx, pubkey)
+	require.NoError(t, err)
+	provider.pay(inv.ID)
+	until, paid, err = p.CheckInvoice(ctx, inv.ID)
+	require.NoError(t, err)
+	assert.True(t, paid)
+	assert.WithinDuration(t, time.Now().Add(2*time.Hour), until, time.Minute)
+
+	_, _, err = p.CheckInvoice(ctx, "unknown")
+	assert.ErrorIs(t, err, ErrInvoiceNotFound)
+}
+
+func TestPaidAdmission_ApplyNIP11(t *testing.T) {
+	p := NewPaidAdmission(&PaidAdmissionOption{
+		Provider:   newTestInvoiceProvider(),
+		AmountMsat: 21000,
+		PaymentURL: "https://relay.example.com/pay",
+	})
+
+	nip11 := new(NIP11)
+	p.ApplyNIP11(nip11)
+
+	b, err := json.Marshal(nip11)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"limitation": {"payment_required": true},
+		"payments_url": "https://relay.example.com/pay",
+		"fees": {"admission": [{"amount": 21000, "unit": "msats", "period": 2592000}]}
+	}`, string(b))
+}
+
+func TestPaidAdmission_ServeHTTP(t *testing.T) {
+	pubkey := strings.Repeat("a", 64)
+
+	provider := newTestInvoiceProvider()
+	p := NewPaidAdmission(&PaidAdmissionOption{Provider: provider, AmountMsat: 1000})
+
+	r := httptest.NewRequest(http.MethodPost, "/pay", strings.NewReader(`{"pubkey":"`+pubkey+`"}`))
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var inv Invoice
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&inv))
+
+	r = httptest.NewRequest(http.MethodGet, "/pay?id="+inv.ID, nil)
+	w = httptest.NewRecorder()
+	p.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"paid":false}`, w.Body.String())
+
+	provider.pay(inv.ID)
+	r = httptest.NewRequest(http.MethodGet, "/pay?id="+inv.ID, nil)
+	w = httptest.NewRecorder()
+	p.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"paid":true`)
+
+	r = httptest.NewRequest(http.MethodGet, "/pay?id=unknown", nil)
+	w = httptest.NewRecorder()
+	p.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestPaidAdmissionMiddleware(t *testing.T) {
+	ctx := context.Background()
+	paying := strings.Repeat("a", 64)
+	other := strings.Repeat("b", 64)
+
+	store := NewMemoryAdmissionStore()
+	require.NoError(t, store.Admit(ctx, paying, time.Now().Add(time.Hour)))
+	require.NoError(t, store.Admit(ctx, other, time.Now().Add(-time.Hour)))
+
+	p := NewPaidAdmission(&PaidAdmissionOption{
+		Provider:   newTestInvoiceProvider(),
+		Store:      store,
+		PaymentURL: "https://relay.example.com/pay",
+	})
+	m := newSimplePaidAdmissionMiddleware(p)
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	cmsgCh, smsgCh, err := m.HandleClientMsg(
+		r,
+		&ClientEventMsg{Event: &Event{ID: "1", Pubkey: paying}},
+	)
+	require.NoError(t, err)
+	assert.Nil(t, smsgCh)
+	assert.Len(t, cmsgCh, 1)
+
+	cmsgCh, smsgCh, err = m.HandleClientMsg(
+		r,
+		&ClientEventMsg{Event: &Event{ID: "2", Pubkey: other}},
+	)
+	require.NoError(t, err)
+	assert.Nil(t, cmsgCh)
+	assert.Equal(t, NewServerOKMsg(
+		"2",
+		false,
+		ServerOkMsgPrefixRestricted,
+		"payment required: https://relay.example.com/pay",
+	), <-smsgCh)
+
+	// Subscriptions need an admitted session pubkey.
+	cmsgCh, smsgCh, err = m.HandleClientMsg(r, &ClientReqMsg{SubscriptionID: "sub"})
+	require.NoError(t, err)
+	assert.Nil(t, cmsgCh)
+	assert.Equal(t, NewServerClosedMsg(
+		"sub",
+		ServerClosedMsgPrefixAuthRequired,
+		"payment required: https://relay.example.com/pay",
+	), <-smsgCh)
+
+	sess := &Session{}
+	r = r.WithContext(ctxWithSession(ctx, sess))
+	sess.SetPubkey(other)
+	cmsgCh, smsgCh, err = m.HandleClientMsg(r, &ClientCountMsg{SubscriptionID: "cnt"})
+	require.NoError(t, err)
+	assert.Nil(t, cmsgCh)
+	assert.Equal(t, NewServerClosedMsg(
+		"cnt",
+		ServerClosedMsgPrefixRestricted,
+		"payment required: https://relay.example.com/pay",
+	), <-smsgCh)
+
+	sess.SetPubkey(paying)
+	cmsgCh, smsgCh, err = m.HandleClientMsg(r, &ClientReqMsg{SubscriptionID: "sub"})
+	require.NoError(t, err)
+	assert.Nil(t, smsgCh)
+	assert.Len(t, cmsgCh, 1)
+}
+
+func TestLNbitsProvider(t *testing.T) {
+	hash := strings.Repeat("f", 64)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Api-Key") != "key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v1/payments":
+			var req map[string]any
+			json.NewDecoder(r.Body).Decode(&req)
+			assert.Equal(t, map[string]any{"out": false, "amount": 22.0, "memo": "memo"}, req)
+			w.Write([]byte(`{"payment_hash":"` + hash + `","payment_request":"lnbc1"}`))
+
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v1/payments/"+hash:
+			w.Write([]byte(`{"paid":true}`))
+
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	p := &LNbitsProvider{URL: srv.URL + "/", APIKey: "key"}
+
+	inv, err := p.CreateInvoice(ctx, 21001, "memo")
+	require.NoError(t, err)
+	assert.Equal(t, &Invoice{ID: hash, Bolt11: "lnbc1", AmountMsat: 22000}, inv)
+
+	paid, err := p.InvoicePaid(ctx, hash)
+	require.NoError(t, err)
+	assert.True(t, paid)
+
+	_, err = p.InvoicePaid(ctx, "unknown")
+	assert.ErrorIs(t, err, ErrInvoiceNotFound)
+
+	_, err = (&LNbitsProvider{URL: srv.URL}).InvoicePaid(ctx, hash)
+	assert.ErrorIs(t, err, ErrInvoiceProvider)
+}
@@ -0,0 +1,270 @@
+package mocrelay
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrInvalidProxyHeader = errors.New("invalid proxy protocol header")
+
+type ProxyProtocolOption struct {
+	// TrustedProxies are the peers whose PROXY protocol headers are parsed.
+	// Headers from the other peers are read as normal data.
+	// If empty, all peers are trusted with Required and none without it.
+	TrustedProxies []netip.Prefix
+
+	// Required rejects connections from trusted proxies without a PROXY protocol header.
+	Required bool
+
+	// HeaderTimeout is the timeout to read the header. Default is 5 seconds.
+	HeaderTimeout time.Duration
+}
+
+func (opt *ProxyProtocolOption) required() bool {
+	return opt != nil && opt.Required
+}
+
+func (opt *ProxyProtocolOption) trusted(addr net.Addr) bool {
+	if opt == nil {
+		return false
+	}
+	if len(opt.TrustedProxies) == 0 {
+		return opt.Required
+	}
+
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	ip := tcpAddr.AddrPort().Addr().Unmap()
+	for _, prefix := range opt.TrustedProxies {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (opt *ProxyProtocolOption) headerTimeout() time.Duration {
+	const defaultHeaderTimeout = 5 * time.Second
+
+	if opt == nil || opt.HeaderTimeout == 0 {
+		return defaultHeaderTimeout
+	}
+	return opt.HeaderTimeout
+}
+
+// NewProxyProtocolListener wraps l to accept HAProxy PROXY protocol v1 and v2 headers.
+// RemoteAddr of accepted connections returns the client address in the header.
+func NewProxyProtocolListener(l net.Listener, option *ProxyProtocolOption) net.Listener {
+	return &proxyProtocolListener{Listener: l, opt: option}
+}
+
+type proxyProtocolListener struct {
+	net.Listener
+	opt *ProxyProtocolOption
+}
+
+func (l *proxyProtocolListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return newProxyProtocolConn(conn, l.opt), nil
+}
+
+// proxyProtocolConn reads the header lazily so that Accept never blocks on a slow client.
+type proxyProtocolConn struct {
+	net.Conn
+	opt *ProxyProtocolOption
+
+	once   sync.Once
+	r      *bufio.Reader
+	remote net.Addr
+	local  net.Addr
+	err    error
+}
+
+func newProxyProtocolConn(conn net.Conn, option *ProxyProtocolOption) *proxyProtocolConn {
+	return &proxyProtocolConn{Conn: conn, opt: option}
+}
+
+func (c *proxyProtocolConn) init() {
+	c.once.Do(func() {
+		c.r = bufio.NewReader(c.Conn)
+		if !c.opt.trusted(c.Conn.RemoteAddr()) {
+			return
+		}
+
+		c.Conn.SetReadDeadline(time.Now().Add(c.opt.headerTimeout()))
+		c.err = c.readHeader()
+		c.Conn.SetReadDeadline(time.Time{})
+	})
+}
+
+func (c *proxyProtocolConn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+func (c *proxyProtocolConn) RemoteAddr() net.Addr {
+	c.init()
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyProtocolConn) LocalAddr() net.Addr {
+	c.init()
+	if c.local != nil {
+		return c.local
+	}
+	return c.Conn.LocalAddr()
+}
+
+var (
+	proxyProtocolV1Prefix = []byte("PROXY ")
+	proxyProtocolV2Sig    = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+func (c *proxyProtocolConn) readHeader() error {
+	if b, err := c.r.Peek(len(proxyProtocolV1Prefix)); err == nil &&
+		bytes.Equal(b, proxyProtocolV1Prefix) {
+		return c.readHeaderV1()
+	}
+	if b, err := c.r.Peek(len(proxyProtocolV2Sig)); err == nil &&
+		bytes.Equal(b, proxyProtocolV2Sig) {
+		return c.readHeaderV2()
+	}
+
+	if c.opt.required() {
+		return fmt.Errorf("%w: no header", ErrInvalidProxyHeader)
+	}
+	return nil
+}
+
+func (c *proxyProtocolConn) readHeaderV1() error {
+	// The max length of a v1 header is 107 bytes including CRLF.
+	const maxLen = 107
+
+	var line []byte
+	for {
+		b, err := c.r.ReadSlice('\n')
+		line = append(line, b...)
+		if len(line) > maxLen {
+			return fmt.Errorf("%w: too long v1 header", ErrInvalidProxyHeader)
+		}
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return fmt.Errorf("%w: %w", ErrInvalidProxyHeader, err)
+		}
+	}
+
+	s, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return fmt.Errorf("%w: v1 header must end with CRLF", ErrInvalidProxyHeader)
+	}
+
+	fields := strings.Split(s, " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return fmt.Errorf("%w: malformed v1 header", ErrInvalidProxyHeader)
+	}
+
+	src, err := parseProxyProtocolV1Addr(fields[2], fields[4])
+	if err != nil {
+		return err
+	}
+	dst, err := parseProxyProtocolV1Addr(fields[3], fields[5])
+	if err != nil {
+		return err
+	}
+
+	c.remote, c.local = src, dst
+	return nil
+}
+
+func parseProxyProtocolV1Addr(ip, port string) (*net.TCPAddr, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return nil, fmt.Errorf("%w: invalid ip %q", ErrInvalidProxyHeader, ip)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid port %q", ErrInvalidProxyHeader, port)
+	}
+	return &net.TCPAddr{IP: addr, Port: int(p)}, nil
+}
+
+func (c *proxyProtocolConn) readHeaderV2() error {
+	const (
+		cmdLocal = 0x0
+		cmdProxy = 0x1
+
+		famTCP4 = 0x11
+		famTCP6 = 0x21
+	)
+
+	var hdr [16]byte
+	if _, err := io.ReadFull(c.r, hdr[:]); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidProxyHeader, err)
+	}
+	if hdr[12]>>4 != 2 {
+		return fmt.Errorf("%w: unsupported version %d", ErrInvalidProxyHeader, hdr[12]>>4)
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidProxyHeader, err)
+	}
+
+	switch hdr[12] & 0xf {
+	case cmdLocal:
+		return nil
+	case cmdProxy:
+	default:
+		return fmt.Errorf("%w: unknown command %d", ErrInvalidProxyHeader, hdr[12]&0xf)
+	}
+
+	var ipLen int
+	switch hdr[13] {
+	case famTCP4:
+		ipLen = net.IPv4len
+	case famTCP6:
+		ipLen = net.IPv6len
+	default:
+		// unspec or unix sockets
+		return nil
+	}
+
+	if len(payload) < 2*ipLen+4 {
+		return fmt.Errorf("%w: too short v2 addresses", ErrInvalidProxyHeader)
+	}
+	c.remote = &net.TCPAddr{
+		IP:   net.IP(payload[:ipLen]),
+		Port: int(binary.BigEndian.Uint16(payload[2*ipLen:])),
+	}
+	c.local = &net.TCPAddr{
+		IP:   net.IP(payload[ipLen : 2*ipLen]),
+		Port: int(binary.BigEndian.Uint16(payload[2*ipLen+2:])),
+	}
+	return nil
+}
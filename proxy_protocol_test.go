@@ -0,0 +1,176 @@
+package mocrelay
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func proxyProtocolV2Header(cmd, fam byte, addrs []byte) []byte {
+	ret := append([]byte(nil), proxyProtocolV2Sig...)
+	ret = append(ret, 0x20|cmd, fam)
+	ret = binary.BigEndian.AppendUint16(ret, uint16(len(addrs)))
+	return append(ret, addrs...)
+}
+
+func TestProxyProtocolConn(t *testing.T) {
+	tcp4Addrs := []byte{192, 0, 2, 1, 198, 51, 100, 1, 0x30, 0x39, 0x01, 0xbb}
+
+	tests := []struct {
+		name    string
+		opt     *ProxyProtocolOption
+		header  []byte
+		remote  string
+		local   string
+		invalid bool
+	}{
+		{
+			name:   "v1 tcp4",
+			header: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 12345 443\r\n"),
+			remote: "192.0.2.1:12345",
+			local:  "198.51.100.1:443",
+		},
+		{
+			name:   "v1 tcp6",
+			header: []byte("PROXY TCP6 2001:db8::1 2001:db8::2 12345 443\r\n"),
+			remote: "[2001:db8::1]:12345",
+			local:  "[2001:db8::2]:443",
+		},
+		{
+			name:   "v1 unknown",
+			header: []byte("PROXY UNKNOWN\r\n"),
+			remote: "pipe",
+			local:  "pipe",
+		},
+		{
+			name:    "v1 malformed",
+			header:  []byte("PROXY TCP4 192.0.2.1 12345\r\n"),
+			invalid: true,
+		},
+		{
+			name:   "v2 tcp4",
+			header: proxyProtocolV2Header(0x1, 0x11, tcp4Addrs),
+			remote: "192.0.2.1:12345",
+			local:  "198.51.100.1:443",
+		},
+		{
+			name: "v2 tcp6",
+			header: proxyProtocolV2Header(0x1, 0x21, append(append(
+				net.ParseIP("2001:db8::1").To16(),
+				net.ParseIP("2001:db8::2").To16()...),
+				0x30, 0x39, 0x01, 0xbb,
+			)),
+			remote: "[2001:db8::1]:12345",
+			local:  "[2001:db8::2]:443",
+		},
+		{
+			name:   "v2 local",
+			header: proxyProtocolV2Header(0x0, 0x00, nil),
+			remote: "pipe",
+			local:  "pipe",
+		},
+		{
+			name:    "v2 short",
+			header:  proxyProtocolV2Header(0x1, 0x11, tcp4Addrs[:8]),
+			invalid: true,
+		},
+		{
+			name:   "no header",
+			opt:    &ProxyProtocolOption{},
+			header: nil,
+			remote: "pipe",
+			local:  "pipe",
+		},
+		{
+			name:    "no header: required",
+			opt:     &ProxyProtocolOption{Required: true},
+			header:  nil,
+			invalid: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			defer server.Close()
+			defer client.Close()
+
+			const body = "GET / HTTP/1.1\r\n\r\n"
+			go func() {
+				client.Write(append(append([]byte(nil), tt.header...), body...))
+			}()
+
+			opt := tt.opt
+			if opt == nil {
+				opt = &ProxyProtocolOption{Required: true, HeaderTimeout: time.Second}
+			}
+			conn := newProxyProtocolConn(server, opt)
+
+			b := make([]byte, len(body))
+			_, err := io.ReadFull(conn, b)
+			if tt.invalid {
+				assert.ErrorIs(t, err, ErrInvalidProxyHeader)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, body, string(b))
+			assert.Equal(t, tt.remote, conn.RemoteAddr().String())
+			assert.Equal(t, tt.local, conn.LocalAddr().String())
+		})
+	}
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	const header = "PROXY TCP4 192.0.2.1 198.51.100.1 12345 443\r\n"
+
+	tests := []struct {
+		name    string
+		trusted string
+		remote  string
+		body    string
+	}{
+		{"trusted", "127.0.0.0/8", "192.0.2.1", "powa"},
+		{"untrusted", "192.0.2.0/24", "127.0.0.1", header + "powa"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ln := NewProxyProtocolListener(ln, &ProxyProtocolOption{
+				TrustedProxies: []netip.Prefix{netip.MustParsePrefix(tt.trusted)},
+			})
+
+			go func() {
+				c, err := net.Dial("tcp", ln.Addr().String())
+				if err != nil {
+					return
+				}
+				defer c.Close()
+				io.WriteString(c, header+"powa")
+			}()
+
+			conn, err := ln.Accept()
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer conn.Close()
+
+			host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+			assert.NoError(t, err)
+			assert.Equal(t, tt.remote, host)
+			b, err := io.ReadAll(conn)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.body, string(b))
+		})
+	}
+}
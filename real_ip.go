@@ -0,0 +1,118 @@
+package mocrelay
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+var DefaultRealIPHeaders = []string{"X-Forwarded-For", "X-Real-IP", "CF-Connecting-IP"}
+
+// RealIPResolver extracts the client IP of a request.
+// Forwarding headers are only trusted when the peer is one of the trusted proxies.
+type RealIPResolver struct {
+	TrustedProxies []netip.Prefix
+
+	// Headers are checked in order. Default is DefaultRealIPHeaders.
+	Headers []string
+}
+
+// NewRealIPResolver creates a resolver trusting the proxies given as CIDRs or IPs.
+func NewRealIPResolver(trustedProxies []string, headers ...string) (*RealIPResolver, error) {
+	ret := &RealIPResolver{Headers: headers}
+
+	for _, s := range trustedProxies {
+		if prefix, err := netip.ParsePrefix(s); err == nil {
+			ret.TrustedProxies = append(ret.TrustedProxies, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", s, err)
+		}
+		ret.TrustedProxies = append(ret.TrustedProxies, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+
+	return ret, nil
+}
+
+func (res *RealIPResolver) headers() []string {
+	if len(res.Headers) == 0 {
+		return DefaultRealIPHeaders
+	}
+	return res.Headers
+}
+
+func (res *RealIPResolver) trusted(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range res.TrustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// FromRequest returns the client IP. If res is nil, no headers are trusted.
+func (res *RealIPResolver) FromRequest(r *http.Request) string {
+	remote := remoteIP(r)
+	if res == nil {
+		return remote
+	}
+
+	addr, err := netip.ParseAddr(remote)
+	if err != nil || !res.trusted(addr) {
+		return remote
+	}
+
+	for _, header := range res.headers() {
+		if http.CanonicalHeaderKey(header) == "X-Forwarded-For" {
+			if ip, ok := res.fromXFF(r.Header.Values(header)); ok {
+				return ip
+			}
+			continue
+		}
+
+		if addr, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get(header))); err == nil {
+			return addr.Unmap().String()
+		}
+	}
+
+	return remote
+}
+
+// fromXFF returns the rightmost address which is not a trusted proxy.
+func (res *RealIPResolver) fromXFF(values []string) (string, bool) {
+	var addrs []netip.Addr
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			addr, err := netip.ParseAddr(strings.TrimSpace(s))
+			if err != nil {
+				// A broken hop makes the rest of the chain unreliable.
+				addrs = nil
+				continue
+			}
+			addrs = append(addrs, addr.Unmap())
+		}
+	}
+	if len(addrs) == 0 {
+		return "", false
+	}
+
+	for i := len(addrs) - 1; i >= 0; i-- {
+		if !res.trusted(addrs[i]) {
+			return addrs[i].String(), true
+		}
+	}
+	return addrs[0].String(), true
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,137 @@
+package mocrelay
+
+import (
+	"net/http"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRealIPResolver(t *testing.T) {
+	res, err := NewRealIPResolver([]string{"10.0.0.0/8", "192.168.1.1", "::1"})
+	require.NoError(t, err)
+	assert.Equal(t, []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("192.168.1.1/32"),
+		netip.MustParsePrefix("::1/128"),
+	}, res.TrustedProxies)
+
+	_, err = NewRealIPResolver([]string{"invalid"})
+	assert.Error(t, err)
+}
+
+func TestRealIPResolver_FromRequest(t *testing.T) {
+	trusted, err := NewRealIPResolver([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+
+	cfOnly, err := NewRealIPResolver([]string{"10.0.0.0/8"}, "CF-Connecting-IP")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		res    *RealIPResolver
+		remote string
+		header http.Header
+		want   string
+	}{
+		{
+			name:   "nil resolver ignores headers",
+			res:    nil,
+			remote: "10.0.0.1:1234",
+			header: http.Header{"X-Forwarded-For": {"1.2.3.4"}},
+			want:   "10.0.0.1",
+		},
+		{
+			name:   "untrusted peer",
+			res:    trusted,
+			remote: "5.6.7.8:1234",
+			header: http.Header{"X-Forwarded-For": {"1.2.3.4"}},
+			want:   "5.6.7.8",
+		},
+		{
+			name:   "x-forwarded-for",
+			res:    trusted,
+			remote: "10.0.0.1:1234",
+			header: http.Header{"X-Forwarded-For": {"1.2.3.4"}},
+			want:   "1.2.3.4",
+		},
+		{
+			name:   "x-forwarded-for skips trusted hops",
+			res:    trusted,
+			remote: "10.0.0.1:1234",
+			header: http.Header{"X-Forwarded-For": {"9.9.9.9, 1.2.3.4, 10.0.0.2"}},
+			want:   "1.2.3.4",
+		},
+		{
+			name:   "x-forwarded-for multiple headers",
+			res:    trusted,
+			remote: "10.0.0.1:1234",
+			header: http.Header{"X-Forwarded-For": {"9.9.9.9", "1.2.3.4"}},
+			want:   "1.2.3.4",
+		},
+		{
+			name:   "x-forwarded-for all trusted",
+			res:    trusted,
+			remote: "10.0.0.1:1234",
+			header: http.Header{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}},
+			want:   "10.0.0.3",
+		},
+		{
+			name:   "x-forwarded-for invalid falls back to x-real-ip",
+			res:    trusted,
+			remote: "10.0.0.1:1234",
+			header: http.Header{
+				"X-Forwarded-For": {"invalid"},
+				"X-Real-Ip":       {"1.2.3.4"},
+			},
+			want: "1.2.3.4",
+		},
+		{
+			name:   "x-real-ip",
+			res:    trusted,
+			remote: "10.0.0.1:1234",
+			header: http.Header{"X-Real-Ip": {"1.2.3.4"}},
+			want:   "1.2.3.4",
+		},
+		{
+			name:   "cf-connecting-ip",
+			res:    trusted,
+			remote: "10.0.0.1:1234",
+			header: http.Header{"Cf-Connecting-Ip": {"2001:db8::1"}},
+			want:   "2001:db8::1",
+		},
+		{
+			name:   "header precedence",
+			res:    cfOnly,
+			remote: "10.0.0.1:1234",
+			header: http.Header{
+				"X-Forwarded-For":  {"1.2.3.4"},
+				"Cf-Connecting-Ip": {"5.6.7.8"},
+			},
+			want: "5.6.7.8",
+		},
+		{
+			name:   "no headers",
+			res:    trusted,
+			remote: "10.0.0.1:1234",
+			header: http.Header{},
+			want:   "10.0.0.1",
+		},
+		{
+			name:   "ipv4-mapped peer",
+			res:    trusted,
+			remote: "[::ffff:10.0.0.1]:1234",
+			header: http.Header{"X-Real-Ip": {"1.2.3.4"}},
+			want:   "1.2.3.4",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &http.Request{RemoteAddr: tt.remote, Header: tt.header}
+			assert.Equal(t, tt.want, tt.res.FromRequest(r))
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package mocrelay
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -8,15 +9,18 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
+	"os"
+	"regexp"
+	"strings"
 	"sync"
 	"time"
-	"unicode/utf8"
 
 	"nhooyr.io/websocket"
 )
 
 var (
-	ErrRelayStop = errors.New("relay stopped")
+	ErrRelayStop   = errors.New("relay stopped")
+	ErrSendTimeout = errors.New("send timeout")
 )
 
 type Relay struct {
@@ -33,6 +37,13 @@ type Relay struct {
 	recvRateLimitRate  time.Duration
 	recvRateLimitBurst int
 	sendRateLimitRate  time.Duration
+
+	metrics *RelayMetrics
+
+	connLimiter *connLimiter
+	egress      *byteLimiter
+
+	shutdown relayShutdown
 }
 
 type RelayOption struct {
@@ -40,11 +51,102 @@ type RelayOption struct {
 	RecvLogger *slog.Logger
 	SendLogger *slog.Logger
 
+	// RecvRateLimitRate and RecvRateLimitBurst delay client messages.
+	// They are ignored if MsgRateLimit is set.
 	RecvRateLimitRate  time.Duration
 	RecvRateLimitBurst int
 	SendRateLimitRate  time.Duration
 
+	// MsgRateLimit rejects client messages exceeding the budget by their costs.
+	MsgRateLimit *MsgRateLimitOption
+
 	MaxMessageLength int64
+
+	// ReassembleMessages accepts websocket messages containing several client messages
+	// or a part of one, for clients which frame messages by themselves.
+	// A client message still cannot exceed MaxMessageLength.
+	ReassembleMessages bool
+
+	// CBOR accepts the CBORSubprotocol, on which messages are CBOR encoded binary messages.
+	// Clients not requesting it keep using JSON text messages.
+	CBOR bool
+
+	// EgressLimit limits the outbound bandwidth.
+	EgressLimit *EgressLimitOption
+
+	// RealIP resolves client IPs. If nil, forwarding headers are ignored
+	// and the peer address is used.
+	RealIP *RealIPResolver
+
+	// MaxConnections is the max number of simultaneous websocket connections.
+	// Upgrades beyond it get 503. 0 means no limit.
+	MaxConnections int
+
+	// MaxConnectionsPerIP is the max number of simultaneous websocket connections
+	// from a single IP. Upgrades beyond it get 429. 0 means no limit.
+	MaxConnectionsPerIP int
+
+	// CanonicalURL is the public URL of the relay (e.g. "wss://relay.example.com").
+	// It is used to validate the relay tag of NIP-42 auth events.
+	// If empty, the URL is derived from each request.
+	CanonicalURL string
+
+	SendTimeout time.Duration
+
+	// Compression enables permessage-deflate. If nil, compression is disabled.
+	Compression *CompressionOption
+
+	UpgradePolicy *UpgradePolicy
+
+	ContentPolicy *ContentPolicy
+
+	// IDMatchMode is how REQ and COUNT filters are validated. With IDMatchPrefix,
+	// the prefixes of ids and authors are accepted, and the handlers must be
+	// configured with the same mode to match them.
+	IDMatchMode IDMatchMode
+
+	// TagNamePattern matches the tag names (without "#") allowed in REQ and COUNT filters.
+	// The default is StrictTagNamePattern. Stores may have to index the longer names.
+	TagNamePattern *regexp.Regexp
+
+	// FilterLimits caps the number of values of REQ and COUNT filters.
+	// Filters over them are closed with CLOSED "invalid: ".
+	FilterLimits *ReqFilterLimits
+
+	// NoticeGovernor deduplicates and rate limits NOTICEs and disconnects
+	// connections which keep sending invalid messages. If nil, NOTICEs are not governed.
+	NoticeGovernor *NoticeGovernorOption
+
+	// SendQueue enables a per-connection send queue with an overflow policy.
+	// If nil, handlers are blocked until messages are written.
+	SendQueue *SendQueueOption
+
+	// WriteCoalesce holds written frames for a short interval to write them together.
+	// If nil, each frame is written immediately.
+	WriteCoalesce *WriteCoalesceOption
+
+	// Recorder records sampled traffic for TrafficReplayer.
+	Recorder *TrafficRecorder
+
+	// DebugTap keeps the raw frames of the connections tapped by admins.
+	DebugTap *DebugTap
+
+	// AuditLog records every EVENT decision.
+	AuditLog *AuditLog
+
+	// BanList bans IPs and pubkeys which keep sending invalid messages or hitting
+	// the rate limit. If nil, no one is banned.
+	BanList *BanList
+
+	// Verifier verifies event signatures on a shared worker pool.
+	// If nil, events are verified on each connection goroutine.
+	Verifier *Verifier
+
+	// Interner interns the repeated strings of received events to save the memory
+	// of the events kept by handlers. If nil, events are kept as they are parsed.
+	Interner *Interner
+
+	Metrics *RelayMetrics
 }
 
 func (opt *RelayOption) maxMessageLength() int64 {
@@ -57,6 +159,170 @@ func (opt *RelayOption) maxMessageLength() int64 {
 	return opt.MaxMessageLength
 }
 
+func (opt *RelayOption) reassembleMessages() bool {
+	return opt != nil && opt.ReassembleMessages
+}
+
+func (opt *RelayOption) cbor() bool {
+	return opt != nil && opt.CBOR
+}
+
+func (opt *RelayOption) sendTimeout() time.Duration {
+	const defaultSendTimeout = 10 * time.Second
+
+	if opt == nil || opt.SendTimeout == 0 {
+		return defaultSendTimeout
+	}
+
+	return opt.SendTimeout
+}
+
+type CompressionOption struct {
+	// ContextTakeover keeps the deflate context across messages.
+	// It compresses better but costs memory per connection.
+	ContextTakeover bool
+
+	// Threshold is the min message size in bytes to compress.
+	// Small messages like OK and EOSE are not worth compressing. Default is 512.
+	Threshold int
+}
+
+func (opt *RelayOption) acceptOptions() *websocket.AcceptOptions {
+	const defaultCompressionThreshold = 512
+
+	ret := &websocket.AcceptOptions{
+		InsecureSkipVerify: true,
+		CompressionMode:    websocket.CompressionDisabled,
+	}
+	if opt.cbor() {
+		ret.Subprotocols = []string{CBORSubprotocol}
+	}
+	if opt == nil || opt.Compression == nil {
+		return ret
+	}
+
+	ret.CompressionMode = websocket.CompressionNoContextTakeover
+	if opt.Compression.ContextTakeover {
+		ret.CompressionMode = websocket.CompressionContextTakeover
+	}
+	ret.CompressionThreshold = opt.Compression.Threshold
+	if ret.CompressionThreshold <= 0 {
+		ret.CompressionThreshold = defaultCompressionThreshold
+	}
+
+	return ret
+}
+
+func (opt *RelayOption) realIPResolver() *RealIPResolver {
+	if opt == nil {
+		return nil
+	}
+	return opt.RealIP
+}
+
+func (opt *RelayOption) canonicalURL() string {
+	if opt == nil {
+		return ""
+	}
+	return opt.CanonicalURL
+}
+
+func (opt *RelayOption) upgradePolicy() *UpgradePolicy {
+	if opt == nil {
+		return nil
+	}
+	return opt.UpgradePolicy
+}
+
+func (opt *RelayOption) interner() *Interner {
+	if opt == nil {
+		return nil
+	}
+	return opt.Interner
+}
+
+func (opt *RelayOption) checkClientMsgOption() *CheckClientMsgOption {
+	if opt == nil {
+		return nil
+	}
+	return &CheckClientMsgOption{
+		ContentPolicy:  opt.ContentPolicy,
+		IDMatchMode:    opt.IDMatchMode,
+		TagNamePattern: opt.TagNamePattern,
+		FilterLimits:   opt.FilterLimits,
+	}
+}
+
+func (opt *RelayOption) noticeGovernor() *NoticeGovernorOption {
+	if opt == nil {
+		return nil
+	}
+	return opt.NoticeGovernor
+}
+
+func (opt *RelayOption) sendQueue() *SendQueueOption {
+	if opt == nil {
+		return nil
+	}
+	return opt.SendQueue
+}
+
+func (opt *RelayOption) writeCoalesce() *WriteCoalesceOption {
+	if opt == nil {
+		return nil
+	}
+	return opt.WriteCoalesce
+}
+
+func (opt *RelayOption) recorder() *TrafficRecorder {
+	if opt == nil {
+		return nil
+	}
+	return opt.Recorder
+}
+
+func (opt *RelayOption) debugTap() *DebugTap {
+	if opt == nil {
+		return nil
+	}
+	return opt.DebugTap
+}
+
+func (opt *RelayOption) auditLog() *AuditLog {
+	if opt == nil {
+		return nil
+	}
+	return opt.AuditLog
+}
+
+func (opt *RelayOption) banList() *BanList {
+	if opt == nil {
+		return nil
+	}
+	return opt.BanList
+}
+
+func (opt *RelayOption) msgRateLimit() *MsgRateLimitOption {
+	if opt == nil {
+		return nil
+	}
+	return opt.MsgRateLimit
+}
+
+func (opt *RelayOption) egressLimit() *EgressLimitOption {
+	if opt == nil {
+		return nil
+	}
+	return opt.EgressLimit
+}
+
+func (opt *RelayOption) verifier() *Verifier {
+	if opt == nil {
+		return nil
+	}
+	return opt.Verifier
+}
+
 func NewRelay(handler Handler, option *RelayOption) *Relay {
 	relay := &Relay{
 		Handler: handler,
@@ -65,54 +331,163 @@ func NewRelay(handler Handler, option *RelayOption) *Relay {
 
 	relay.prepareLoggers()
 	relay.prepareRateLimitOpts()
+	relay.prepareMetrics()
+	relay.prepareConnLimiter()
+	relay.prepareEgress()
 
 	return relay
 }
 
 func (relay *Relay) Wait() { relay.wg.Wait() }
 
+func (relay *Relay) CanonicalURL() string { return relay.opt.canonicalURL() }
+
+func (relay *Relay) relayURL(r *http.Request) string {
+	if u := relay.opt.canonicalURL(); u != "" {
+		return u
+	}
+	return requestRelayURL(r)
+}
+
 func (relay *Relay) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	relay.wg.Add(1)
+	if !relay.enter() {
+		http.Error(w, ErrRelayShutdown.Error(), http.StatusServiceUnavailable)
+		return
+	}
 	defer relay.wg.Done()
 
+	r = relay.startSession(r)
 	ctx := r.Context()
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
-	ctx = ctxWithRealIP(ctx, r)
-	ctx = ctxWithRequestID(ctx)
-	ctx = ctxWithHTTPHeader(ctx, r)
-	r = r.WithContext(ctx)
+	sess := GetSession(ctx)
 
-	relay.logInfo(ctx, relay.logger, "mocrelay session start")
-
-	errs := make(chan error, 3)
+	if status, ok := relay.admit(r); !ok {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	defer relay.connLimiter.release(sess.RealIP)
 
-	conn, err := websocket.Accept(
-		w,
-		r,
-		&websocket.AcceptOptions{
-			InsecureSkipVerify: true,
-			CompressionMode:    websocket.CompressionDisabled,
-		},
-	)
+	var aw http.ResponseWriter = w
+	if opt := relay.opt.writeCoalesce(); opt.interval() > 0 {
+		aw = &coalescingResponseWriter{
+			ResponseWriter: w,
+			opt:            opt,
+			writeTimeout:   relay.opt.sendTimeout(),
+		}
+	}
+	conn, err := websocket.Accept(aw, r, relay.opt.acceptOptions())
 	if err != nil {
 		relay.logWarn(ctx, relay.logger, "failed to upgrade http", "err", err)
 		return
 	}
-	defer conn.Close(websocket.StatusInternalError, "")
+	if strings.Contains(w.Header().Get("Sec-WebSocket-Extensions"), "permessage-deflate") {
+		sess.AddFeature(SessionFeatureCompression)
+	}
+
+	wsConn := NewNhooyrWSConn(conn)
+	if conn.Subprotocol() == CBORSubprotocol {
+		sess.AddFeature(SessionFeatureCBOR)
+		wsConn = NewCBORWSConn(wsConn)
+	}
+
+	relay.serve(r, wsConn)
+}
+
+// ServeConn serves conn which an existing HTTP server has already upgraded from r
+// with its own websocket library. It blocks until the session ends and closes conn.
+// The cancellation of the context of r is ignored
+// because the connection usually outlives the HTTP handler.
+func (relay *Relay) ServeConn(r *http.Request, conn WSConn) {
+	if !relay.enter() {
+		conn.Close(WSStatusGoingAway, ErrRelayShutdown.Error())
+		return
+	}
+	defer relay.wg.Done()
+
+	r = relay.startSession(r.WithContext(context.WithoutCancel(r.Context())))
+
+	status, ok := relay.admit(r)
+	if !ok {
+		code := WSStatusPolicyViolation
+		if status == http.StatusServiceUnavailable || status == http.StatusTooManyRequests {
+			code = WSStatusTryAgainLater
+		}
+		conn.Close(code, http.StatusText(status))
+		return
+	}
+	defer relay.connLimiter.release(GetRealIP(r.Context()))
+
+	relay.serve(r, conn)
+}
+
+func (relay *Relay) startSession(r *http.Request) *http.Request {
+	sess := newSession(r, relay.opt.realIPResolver(), relay.relayURL(r))
+	ctx := ctxWithSession(r.Context(), sess)
+
+	relay.logInfo(ctx, relay.logger, "mocrelay session start")
+
+	return r.WithContext(ctx)
+}
+
+// admit checks whether the relay accepts the session of r.
+// It returns the HTTP status of the rejection if not.
+// The caller must release the connection limit if admitted.
+func (relay *Relay) admit(r *http.Request) (int, bool) {
+	if status, ok := relay.checkUpgrade(r); !ok {
+		return status, false
+	}
+
+	if relay.opt.banList().Banned(BanTargetIP, GetRealIP(r.Context())) {
+		relay.logWarn(r.Context(), relay.logger, "rejected banned ip")
+		return http.StatusForbidden, false
+	}
+
+	return relay.acquireConn(r)
+}
+
+func (relay *Relay) serve(r *http.Request, conn WSConn) {
+	if opt := relay.opt.egressLimit(); opt != nil {
+		GetSession(r.Context()).egress = newByteLimiter(opt.BytesPerSec, opt.burst())
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	defer conn.Close(WSStatusInternalError, "")
 	conn.SetReadLimit(relay.opt.maxMessageLength())
 
+	errs := make(chan error, 4)
+
 	recv := make(chan ClientMsg)
 	send := make(chan ServerMsg)
 
+	gov := newNoticeGovernor(relay.opt.noticeGovernor())
+	defer gov.Stop()
+
+	var q *sendQueue
+	if opt := relay.opt.sendQueue(); opt != nil {
+		q = newSendQueue(opt, relay.metrics)
+	}
+
+	subs := newActiveSubs()
+
+	defer relay.opt.auditLog().forget(ctx)
+	defer relay.metrics.forget(ctx)
+
 	var wg sync.WaitGroup
 
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		relay.watchShutdown(ctx, cancel, conn, subs, q)
+	}()
+
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 		defer cancel()
 		defer close(recv)
-		err := relay.serveRead(ctx, conn, recv, send)
+		err := relay.serveRead(ctx, conn, gov, q, subs, recv, send)
 		errs <- fmt.Errorf("serveRead terminated: %w", err)
 	}()
 
@@ -120,10 +495,20 @@ func (relay *Relay) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	go func() {
 		defer wg.Done()
 		defer cancel()
-		err := relay.serveWrite(ctx, conn, send)
+		err := relay.serveWrite(ctx, conn, gov, q, send)
 		errs <- fmt.Errorf("serveWrite terminated: %w", err)
 	}()
 
+	if q != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer cancel()
+			err := relay.serveSendQueue(ctx, conn, q, send)
+			errs <- fmt.Errorf("serveSendQueue terminated: %w", err)
+		}()
+	}
+
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
@@ -137,6 +522,7 @@ func (relay *Relay) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	wg.Wait()
 
 	close(errs)
+	var err error
 	for e := range errs {
 		err = errors.Join(err, e)
 	}
@@ -149,36 +535,137 @@ func (relay *Relay) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (relay *Relay) checkUpgrade(r *http.Request) (int, bool) {
+	policy := relay.opt.upgradePolicy()
+
+	status, err := policy.Check(r)
+	if err == nil {
+		return status, true
+	}
+
+	relay.metrics.incUpgradeRejected()
+
+	if policy.ReportOnly {
+		relay.logWarn(
+			r.Context(),
+			relay.logger,
+			"invalid upgrade request (report only)",
+			"err",
+			err,
+		)
+		return http.StatusOK, true
+	}
+
+	relay.logWarn(r.Context(), relay.logger, "rejected upgrade request", "err", err)
+	return status, false
+}
+
+func (relay *Relay) acquireConn(r *http.Request) (int, bool) {
+	ip := GetRealIP(r.Context())
+
+	status, ok := relay.connLimiter.acquire(ip)
+	if ok {
+		return status, true
+	}
+
+	relay.metrics.incConnLimitRejected()
+	relay.logWarn(r.Context(), relay.logger, "too many connections", "ip", ip, "status", status)
+	return status, false
+}
+
 func (relay *Relay) serveRead(
 	ctx context.Context,
-	conn *websocket.Conn,
+	conn WSConn,
+	gov *noticeGovernor,
+	q *sendQueue,
+	subs *activeSubs,
 	recv chan<- ClientMsg,
 	send chan ServerMsg,
 ) error {
-	l := newRateLimiter(relay.recvRateLimitRate, relay.recvRateLimitBurst)
+	ml := newMsgRateLimiter(relay.opt.msgRateLimit(), time.Now())
+	rate, burst := relay.recvRateLimitRate, relay.recvRateLimitBurst
+	if ml != nil {
+		rate, burst = 0, 0
+	}
+	l := newRateLimiter(rate, burst)
 	defer l.Stop()
 
+	var framer *msgFramer
+	if relay.opt.reassembleMessages() {
+		framer = newMsgFramer(relay.opt.maxMessageLength())
+	}
+	var pending [][]byte
+
 	for {
-		typ, payload, err := conn.Read(ctx)
-		if err != nil {
-			return fmt.Errorf("failed to read websocket: %w", err)
-		}
-		if typ != websocket.MessageText {
-			notice := NewServerNoticeMsgf("binary websocket message type is not allowed")
-			sendServerMsgCtx(ctx, send, notice)
-			continue
+		var payload []byte
+		if len(pending) > 0 {
+			payload, pending = pending[0], pending[1:]
+		} else {
+			typ, b, err := relay.read(ctx, conn)
+			if err != nil {
+				return fmt.Errorf("failed to read websocket: %w", err)
+			}
+			relay.metrics.addRecvBytes(len(b))
+			relay.opt.debugTap().recordRecv(ctx, typ, b)
+			if typ != WSMessageText {
+				if err := relay.strike(ctx, conn, "", StrikeProtocol); err != nil {
+					return err
+				}
+				notice := NewServerNoticeMsgf("binary websocket message type is not allowed")
+				if err := relay.sendInvalidMsgNotice(ctx, conn, gov, send, notice); err != nil {
+					return err
+				}
+				continue
+			}
+			if framer != nil {
+				// The messages before an error are still handled.
+				msgs, err := framer.Push(b)
+				pending = msgs
+				if err != nil {
+					relay.logWarn(ctx, relay.recvLogger, "failed to reassemble msg", "error", err)
+					if err := relay.strike(ctx, conn, "", StrikeProtocol); err != nil {
+						return err
+					}
+					notice := NewServerNoticeMsgf("invalid json msg")
+					if err := relay.sendInvalidMsgNotice(ctx, conn, gov, send, notice); err != nil {
+						return err
+					}
+					continue
+				}
+				if len(pending) == 0 {
+					continue
+				}
+				b, pending = pending[0], pending[1:]
+			}
+			payload = b
 		}
-		if !utf8.Valid(payload) || !json.Valid(payload) {
+		if !json.Valid(payload) {
+			if err := relay.strike(ctx, conn, "", StrikeProtocol); err != nil {
+				return err
+			}
 			notice := NewServerNoticeMsgf("invalid json msg")
-			sendServerMsgCtx(ctx, send, notice)
+			if err := relay.sendInvalidMsgNotice(ctx, conn, gov, send, notice); err != nil {
+				return err
+			}
 			continue
 		}
+		relay.opt.recorder().recordRecv(ctx, payload)
 
 		msg, err := ParseClientMsg(payload)
 		if err != nil {
 			relay.logWarn(ctx, relay.recvLogger, "failed to parse client msg", "error", err)
+			if err := relay.strike(ctx, conn, "", StrikeProtocol); err != nil {
+				return err
+			}
+			if err := relay.sendInvalidMsgNotice(ctx, conn, gov, send, nil); err != nil {
+				return err
+			}
 			continue
 		}
+		if m, ok := msg.(*ClientEventMsg); ok {
+			relay.opt.interner().Event(m.Event)
+		}
+		relay.metrics.recvMsg(ctx, msg)
 
 		relay.logInfo(
 			ctx,
@@ -188,7 +675,66 @@ func (relay *Relay) serveRead(
 			json.RawMessage(payload),
 		)
 
-		ok, err := CheckClientMsg(msg)
+		if m, ok := msg.(*ClientEventMsg); ok &&
+			relay.opt.banList().Banned(BanTargetPubkey, m.Event.Pubkey) {
+			okMsg := NewServerOKMsg(m.Event.ID, false, ServerOkMsgPrefixBlocked, "banned pubkey")
+			relay.metrics.incEvent(m.Event.Kind, false)
+			sendServerMsgCtx(ctx, send, okMsg)
+			continue
+		}
+
+		ok, err := relay.checkClientMsg(ctx, msg)
+		if errors.Is(err, ErrVerifierQueueFull) {
+			if m, ok := msg.(*ClientEventMsg); ok {
+				okMsg := NewServerOKMsg(
+					m.Event.ID,
+					false,
+					ServerOkMsgPrefixRateLimited,
+					"server is busy",
+				)
+				relay.rejectEvent(
+					ctx,
+					m.Event,
+					okMsg.MsgPrefix,
+					okMsg.Msg,
+					AuditPolicyVerifierQueue,
+				)
+				sendServerMsgCtx(ctx, send, okMsg)
+			}
+			continue
+		}
+		if errors.Is(err, ErrContentPolicy) {
+			if m, ok := msg.(*ClientEventMsg); ok {
+				okMsg := NewServerOKMsg(
+					m.Event.ID,
+					false,
+					ServerOkMsgPrefixRateInvalid,
+					contentPolicyReason(err),
+				)
+				relay.rejectEvent(
+					ctx,
+					m.Event,
+					okMsg.MsgPrefix,
+					okMsg.Msg,
+					AuditPolicyContentPolicy,
+				)
+				sendServerMsgCtx(ctx, send, okMsg)
+			}
+			continue
+		}
+		var limitErr *ReqFilterLimitError
+		if errors.As(err, &limitErr) {
+			var subID string
+			switch m := msg.(type) {
+			case *ClientReqMsg:
+				subID = m.SubscriptionID
+			case *ClientCountMsg:
+				subID = m.SubscriptionID
+			}
+			closed := NewServerClosedMsg(subID, ServerClosedMsgPrefixInvalid, limitErr.Error())
+			sendServerMsgCtx(ctx, send, closed)
+			continue
+		}
 		if err != nil {
 			relay.logWarn(ctx, relay.recvLogger, "failed to verify client msg", "error", err)
 			notice := NewServerNoticeMsgf("internal error")
@@ -197,17 +743,62 @@ func (relay *Relay) serveRead(
 		}
 		if !ok {
 			relay.logWarn(ctx, relay.recvLogger, "invalid client msg", "error", err)
+			var pubkey string
+			if m, ok := msg.(*ClientEventMsg); ok {
+				pubkey = m.Event.Pubkey
+			}
+			if err := relay.strike(ctx, conn, pubkey, StrikeInvalid); err != nil {
+				return err
+			}
+			if m, ok := msg.(*ClientEventMsg); ok {
+				relay.rejectEvent(
+					ctx,
+					m.Event,
+					ServerOkMsgPrefixRateInvalid,
+					"invalid event",
+					AuditPolicyValidation,
+				)
+			}
 			notice := NewServerNoticeMsgf("invalid client msg: %s", payload)
-			sendServerMsgCtx(ctx, send, notice)
+			if err := relay.sendInvalidMsgNotice(ctx, conn, gov, send, notice); err != nil {
+				return err
+			}
 			continue
 		}
 
+		if info, ok := ml.allow(msg, time.Now()); !ok {
+			if err := relay.rejectRateLimited(ctx, conn, msg, info, send); err != nil {
+				return err
+			}
+			continue
+		}
+
+		subs.handleClientMsg(msg)
+
+		switch m := msg.(type) {
+		case *ClientReqMsg:
+			q.reopen(m.SubscriptionID)
+		case *ClientCloseMsg:
+			q.reopen(m.SubscriptionID)
+		}
+
 		select {
 		case <-l.C:
+			relay.track(ctx, msg)
 			sendCtx(ctx, recv, msg)
 
 		default:
 			if m, ok := msg.(*ClientEventMsg); ok {
+				if err := relay.strike(ctx, conn, m.Event.Pubkey, StrikeRateLimit); err != nil {
+					return err
+				}
+				relay.rejectEvent(
+					ctx,
+					m.Event,
+					ServerOkMsgPrefixRateLimited,
+					"slow down",
+					AuditPolicyRateLimit,
+				)
 				sendCtx(
 					ctx,
 					send,
@@ -223,15 +814,183 @@ func (relay *Relay) serveRead(
 				<-l.C
 			} else {
 				<-l.C
+				relay.track(ctx, msg)
 				sendCtx(ctx, recv, msg)
 			}
 		}
 	}
 }
 
+// track remembers msg passed to the handler until its OK message is sent.
+func (relay *Relay) track(ctx context.Context, msg ClientMsg) {
+	relay.opt.auditLog().track(ctx, msg)
+	relay.metrics.track(ctx, msg)
+}
+
+// rejectEvent records the rejection of event decided by the relay.
+func (relay *Relay) rejectEvent(
+	ctx context.Context,
+	event *Event,
+	prefix, reason, policy string,
+) {
+	relay.opt.auditLog().reject(ctx, event, prefix, reason, policy)
+	relay.metrics.incEvent(event.Kind, false)
+}
+
+// rejectRateLimited responds to msg rejected by MsgRateLimit with the retry information.
+func (relay *Relay) rejectRateLimited(
+	ctx context.Context,
+	conn WSConn,
+	msg ClientMsg,
+	info *RateLimitInfo,
+	send chan<- ServerMsg,
+) error {
+	reason := info.Reason()
+
+	var resp ServerMsg
+	switch m := msg.(type) {
+	case *ClientEventMsg:
+		if err := relay.strike(ctx, conn, m.Event.Pubkey, StrikeRateLimit); err != nil {
+			return err
+		}
+		relay.rejectEvent(
+			ctx,
+			m.Event,
+			ServerOkMsgPrefixRateLimited,
+			reason,
+			AuditPolicyRateLimit,
+		)
+		resp = NewServerOKMsg(m.Event.ID, false, ServerOkMsgPrefixRateLimited, reason)
+
+	case *ClientReqMsg:
+		resp = NewServerClosedMsg(m.SubscriptionID, ServerClosedMsgPrefixRateLimited, reason)
+
+	case *ClientCountMsg:
+		resp = NewServerClosedMsg(m.SubscriptionID, ServerClosedMsgPrefixRateLimited, reason)
+
+	default:
+		resp = NewServerNoticeMsgf("rate-limited: %s", reason)
+	}
+
+	sendServerMsgCtx(ctx, send, resp)
+	if relay.opt.msgRateLimit().Notice {
+		sendServerMsgCtx(ctx, send, newRateLimitNotice(msg, info))
+	}
+	return nil
+}
+
+// strike records an offense of the connection and its pubkey if any,
+// and closes the connection if either of them gets banned.
+func (relay *Relay) strike(
+	ctx context.Context,
+	conn WSConn,
+	pubkey, offense string,
+) error {
+	b := relay.opt.banList()
+	if b == nil {
+		return nil
+	}
+
+	bannedIP := b.Strike(BanTargetIP, GetRealIP(ctx), offense)
+	bannedPubkey := b.Strike(BanTargetPubkey, pubkey, offense)
+	if !bannedIP && !bannedPubkey {
+		return nil
+	}
+
+	relay.logWarn(ctx, relay.logger, "disconnect banned peer", "offense", offense, "pubkey", pubkey)
+	conn.Close(WSStatusPolicyViolation, "banned")
+	return ErrBanned
+}
+
+func (relay *Relay) sendInvalidMsgNotice(
+	ctx context.Context,
+	conn WSConn,
+	gov *noticeGovernor,
+	send chan ServerMsg,
+	notice *ServerNoticeMsg,
+) error {
+	notice, disconnect := gov.invalidMsg(notice)
+	if !disconnect {
+		sendServerMsgCtx(ctx, send, notice)
+		return nil
+	}
+
+	relay.logWarn(ctx, relay.logger, "disconnect peer sending invalid messages")
+	if err := relay.writeServerMsg(ctx, conn, notice); err != nil {
+		return errors.Join(ErrTooManyInvalidMsgs, err)
+	}
+	conn.Close(WSStatusPolicyViolation, "too many invalid messages")
+	return ErrTooManyInvalidMsgs
+}
+
+func (relay *Relay) checkClientMsg(ctx context.Context, msg ClientMsg) (bool, error) {
+	v := relay.opt.verifier()
+	m, ok := msg.(*ClientEventMsg)
+	if v == nil || !ok {
+		return CheckClientMsgWithOption(msg, relay.opt.checkClientMsgOption())
+	}
+
+	if !m.Valid() {
+		return false, nil
+	}
+	if err := relay.opt.checkClientMsgOption().contentPolicy().Check(m.Event); err != nil {
+		return false, err
+	}
+	ok, err := v.Verify(ctx, GetRequestID(ctx), m.Event)
+	if err != nil {
+		return false, fmt.Errorf("failed to verify event: %w", err)
+	}
+	return ok, nil
+}
+
+func (relay *Relay) read(
+	ctx context.Context,
+	conn WSConn,
+) (WSMessageType, []byte, error) {
+	typ, r, err := conn.Reader(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if typ != WSMessageText {
+		if _, err := io.Copy(io.Discard, r); err != nil {
+			return 0, nil, err
+		}
+		return typ, nil, nil
+	}
+
+	// The payload is validated before it is buffered, and at most
+	// MaxMessageLength bytes are buffered even if the conn does not limit reads.
+	maxLen := relay.opt.maxMessageLength()
+	lr := &io.LimitedReader{R: r, N: maxLen + 1}
+
+	var buf bytes.Buffer
+	var v utf8Validator
+
+	_, err = io.Copy(io.MultiWriter(&v, &buf), lr)
+	if err == nil && lr.N == 0 {
+		err = fmt.Errorf("message is longer than %d bytes", maxLen)
+		conn.Close(WSStatusMessageTooBig, "message too big")
+		return 0, nil, err
+	}
+	if err == nil {
+		err = v.Close()
+	}
+	if errors.Is(err, errInvalidUTF8) {
+		conn.Close(WSStatusInvalidFramePayloadData, "invalid utf-8")
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return typ, buf.Bytes(), nil
+}
+
 func (relay *Relay) serveWrite(
 	ctx context.Context,
-	conn *websocket.Conn,
+	conn WSConn,
+	gov *noticeGovernor,
+	q *sendQueue,
 	send <-chan ServerMsg,
 ) error {
 	l := newRateLimiter(relay.sendRateLimitRate, 0)
@@ -240,38 +999,149 @@ func (relay *Relay) serveWrite(
 	pingTicker := time.NewTicker(10 * time.Second)
 	defer pingTicker.Stop()
 
+	write := func(msg ServerMsg) error {
+		if notice, ok := msg.(*ServerNoticeMsg); ok && !gov.allow(notice) {
+			return nil
+		}
+
+		<-l.C
+
+		return relay.writeServerMsg(ctx, conn, msg)
+	}
+
+	// If the send queue is enabled, messages are taken from the queue instead of send.
+	var notEmpty <-chan struct{}
+	if q != nil {
+		notEmpty = q.notEmpty()
+		send = nil
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
-			conn.Close(websocket.StatusNormalClosure, "")
+			conn.Close(WSStatusNormalClosure, "")
 			return fmt.Errorf("serverWrite terminated by ctx: %w", ctx.Err())
 
 		case <-pingTicker.C:
-			if err := conn.Ping(ctx); err != nil {
+			if err := relay.ping(ctx, conn); err != nil {
 				return fmt.Errorf("failed to send ping: %w", err)
 			}
 
 		case msg := <-send:
-			<-l.C
+			if err := write(msg); err != nil {
+				return err
+			}
 
-			jsonMsg, err := json.Marshal(msg)
-			if err != nil {
-				return fmt.Errorf("failed to marshal server msg: %w", err)
+		case <-notEmpty:
+			msg, ok := q.pop()
+			if !ok {
+				continue
+			}
+			if err := write(msg); err != nil {
+				return err
 			}
+		}
+	}
+}
 
-			if err := conn.Write(ctx, websocket.MessageText, jsonMsg); err != nil {
-				return fmt.Errorf("failed to write websocket: %w", err)
+func (relay *Relay) serveSendQueue(
+	ctx context.Context,
+	conn WSConn,
+	q *sendQueue,
+	send <-chan ServerMsg,
+) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case msg := <-send:
+			err := q.push(ctx, msg)
+			if errors.Is(err, ErrSlowConsumer) {
+				relay.logWarn(ctx, relay.logger, "disconnect slow consumer")
+				conn.Close(WSStatusPolicyViolation, "slow consumer")
+			}
+			if err != nil {
+				return err
 			}
+		}
+	}
+}
+
+var serverMsgBufPool = sync.Pool{
+	New: func() any {
+		b := make([]byte, 0, 1024)
+		return &b
+	},
+}
 
-			relay.logInfo(
-				ctx,
-				relay.sendLogger,
-				"sent server msg",
-				"serverMsg",
-				json.RawMessage(jsonMsg),
-			)
+func (relay *Relay) writeServerMsg(ctx context.Context, conn WSConn, msg ServerMsg) error {
+	const maxPooledBufCap = 64 * 1024
+
+	bufp := serverMsgBufPool.Get().(*[]byte)
+	defer func() {
+		if cap(*bufp) <= maxPooledBufCap {
+			serverMsgBufPool.Put(bufp)
 		}
+	}()
+
+	jsonMsg, err := appendServerMsgJSON((*bufp)[:0], msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal server msg: %w", err)
+	}
+	*bufp = jsonMsg[:0]
+
+	if err := relay.write(ctx, conn, jsonMsg); err != nil {
+		return fmt.Errorf("failed to write websocket: %w", err)
 	}
+
+	relay.opt.debugTap().recordSend(ctx, jsonMsg)
+	relay.opt.recorder().recordSend(ctx, msg, jsonMsg)
+	relay.opt.auditLog().recordSend(ctx, msg)
+	relay.metrics.recordSend(ctx, msg)
+	relay.metrics.addSendBytes(len(jsonMsg))
+
+	relay.logInfo(
+		ctx,
+		relay.sendLogger,
+		"sent server msg",
+		"serverMsg",
+		json.RawMessage(jsonMsg),
+	)
+
+	return nil
+}
+
+func (relay *Relay) write(ctx context.Context, conn WSConn, b []byte) error {
+	if err := waitEgress(ctx, GetSession(ctx).egressLimiter(), relay.egress, len(b)); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, relay.opt.sendTimeout())
+	defer cancel()
+
+	// Coalesced writes time out on the write deadline of the flush.
+	err := conn.Write(ctx, b)
+	if err != nil &&
+		(errors.Is(ctx.Err(), context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded)) {
+		relay.metrics.incSendTimeout()
+		relay.logWarn(ctx, relay.logger, "disconnect slow peer", "err", err)
+		return errors.Join(ErrSendTimeout, err)
+	}
+	return err
+}
+
+func (relay *Relay) ping(ctx context.Context, conn WSConn) error {
+	ctx, cancel := context.WithTimeout(ctx, relay.opt.sendTimeout())
+	defer cancel()
+
+	err := conn.Ping(ctx)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		relay.metrics.incSendTimeout()
+		relay.logWarn(ctx, relay.logger, "disconnect unresponsive peer", "err", err)
+		return errors.Join(ErrSendTimeout, err)
+	}
+	return err
 }
 
 func (relay *Relay) prepareLoggers() {
@@ -313,3 +1183,25 @@ func (relay *Relay) prepareRateLimitOpts() {
 	relay.recvRateLimitBurst = relay.opt.RecvRateLimitBurst
 	relay.sendRateLimitRate = relay.opt.SendRateLimitRate
 }
+
+func (relay *Relay) prepareMetrics() {
+	if relay.opt == nil {
+		return
+	}
+
+	relay.metrics = relay.opt.Metrics
+}
+
+func (relay *Relay) prepareEgress() {
+	if opt := relay.opt.egressLimit(); opt != nil {
+		relay.egress = newByteLimiter(opt.GlobalBytesPerSec, opt.burst())
+	}
+}
+
+func (relay *Relay) prepareConnLimiter() {
+	var max, maxPerIP int
+	if relay.opt != nil {
+		max, maxPerIP = relay.opt.MaxConnections, relay.opt.MaxConnectionsPerIP
+	}
+	relay.connLimiter = newConnLimiter(max, maxPerIP)
+}
@@ -0,0 +1,177 @@
+package mocrelay
+
+import (
+	"fmt"
+	"net/http"
+)
+
+type RelayMode string
+
+const (
+	// RelayModeReadWrite serves both EVENT and REQ.
+	RelayModeReadWrite RelayMode = "read-write"
+	// RelayModeReadOnly rejects EVENT.
+	RelayModeReadOnly RelayMode = "read-only"
+	// RelayModeWriteOnly rejects REQ and COUNT such as inbox relays.
+	RelayModeWriteOnly RelayMode = "write-only"
+	// RelayModeBroadcastOnly fans out events to live subscribers but never stores them.
+	RelayModeBroadcastOnly RelayMode = "broadcast-only"
+)
+
+// ParseRelayMode parses s. An empty string is RelayModeReadWrite.
+func ParseRelayMode(s string) (RelayMode, error) {
+	switch mode := RelayMode(s); mode {
+	case "":
+		return RelayModeReadWrite, nil
+	case RelayModeReadWrite, RelayModeReadOnly, RelayModeWriteOnly, RelayModeBroadcastOnly:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown relay mode %q", s)
+	}
+}
+
+type RelayModeOption struct {
+	// Mode is the mode of the listener. The default is RelayModeReadWrite.
+	Mode RelayMode
+	// PubkeyModes overrides Mode for sessions authenticated as the pubkeys.
+	PubkeyModes map[string]RelayMode
+}
+
+// mode returns the mode of the session of r.
+// It is resolved per message because the session can authenticate later.
+func (opt *RelayModeOption) mode(r *http.Request) RelayMode {
+	if opt == nil {
+		return RelayModeReadWrite
+	}
+	if pubkey := GetSession(r.Context()).Pubkey(); pubkey != "" {
+		if mode, ok := opt.PubkeyModes[pubkey]; ok && mode != "" {
+			return mode
+		}
+	}
+	if opt.Mode == "" {
+		return RelayModeReadWrite
+	}
+	return opt.Mode
+}
+
+type RelayModeMiddleware Middleware
+
+// NewRelayModeMiddleware rejects EVENT in read-only mode and REQ and COUNT in write-only mode.
+// Broadcast-only mode is enforced by NewRelayModeStoreMiddleware around the storage handler.
+func NewRelayModeMiddleware(option *RelayModeOption) RelayModeMiddleware {
+	m := newSimpleRelayModeMiddleware(option)
+	return RelayModeMiddleware(NewSimpleMiddleware(m))
+}
+
+var _ SimpleMiddlewareInterface = (*simpleRelayModeMiddleware)(nil)
+
+type simpleRelayModeMiddleware struct {
+	opt *RelayModeOption
+}
+
+func newSimpleRelayModeMiddleware(option *RelayModeOption) *simpleRelayModeMiddleware {
+	return &simpleRelayModeMiddleware{opt: option}
+}
+
+func (m *simpleRelayModeMiddleware) HandleStart(r *http.Request) (*http.Request, error) {
+	return r, nil
+}
+
+func (m *simpleRelayModeMiddleware) HandleStop(r *http.Request) error {
+	return nil
+}
+
+func (m *simpleRelayModeMiddleware) HandleClientMsg(
+	r *http.Request,
+	msg ClientMsg,
+) (<-chan ClientMsg, <-chan ServerMsg, error) {
+	mode := m.opt.mode(r)
+
+	switch msg := msg.(type) {
+	case *ClientEventMsg:
+		if mode == RelayModeReadOnly {
+			okMsg := NewServerOKMsg(
+				msg.Event.ID,
+				false,
+				ServerOkMsgPrefixRestricted,
+				"read-only relay",
+			)
+			return nil, newClosedBufCh[ServerMsg](okMsg), nil
+		}
+
+	case *ClientReqMsg:
+		if mode == RelayModeWriteOnly {
+			closedMsg := NewServerClosedMsg(
+				msg.SubscriptionID,
+				ServerClosedMsgPrefixRestricted,
+				"write-only relay",
+			)
+			return nil, newClosedBufCh[ServerMsg](closedMsg), nil
+		}
+
+	case *ClientCountMsg:
+		if mode == RelayModeWriteOnly {
+			closedMsg := NewServerClosedMsg(
+				msg.SubscriptionID,
+				ServerClosedMsgPrefixRestricted,
+				"write-only relay",
+			)
+			return nil, newClosedBufCh[ServerMsg](closedMsg), nil
+		}
+	}
+
+	return newClosedBufCh(msg), nil, nil
+}
+
+func (m *simpleRelayModeMiddleware) HandleServerMsg(
+	r *http.Request,
+	msg ServerMsg,
+) (<-chan ServerMsg, error) {
+	return newClosedBufCh(msg), nil
+}
+
+type RelayModeStoreMiddleware Middleware
+
+// NewRelayModeStoreMiddleware wraps a storage handler such as CacheHandler.
+// It accepts events of broadcast-only sessions without passing them to the storage.
+func NewRelayModeStoreMiddleware(option *RelayModeOption) RelayModeStoreMiddleware {
+	m := newSimpleRelayModeStoreMiddleware(option)
+	return RelayModeStoreMiddleware(NewSimpleMiddleware(m))
+}
+
+var _ SimpleMiddlewareInterface = (*simpleRelayModeStoreMiddleware)(nil)
+
+type simpleRelayModeStoreMiddleware struct {
+	opt *RelayModeOption
+}
+
+func newSimpleRelayModeStoreMiddleware(option *RelayModeOption) *simpleRelayModeStoreMiddleware {
+	return &simpleRelayModeStoreMiddleware{opt: option}
+}
+
+func (m *simpleRelayModeStoreMiddleware) HandleStart(r *http.Request) (*http.Request, error) {
+	return r, nil
+}
+
+func (m *simpleRelayModeStoreMiddleware) HandleStop(r *http.Request) error {
+	return nil
+}
+
+func (m *simpleRelayModeStoreMiddleware) HandleClientMsg(
+	r *http.Request,
+	msg ClientMsg,
+) (<-chan ClientMsg, <-chan ServerMsg, error) {
+	if msg, ok := msg.(*ClientEventMsg); ok && m.opt.mode(r) == RelayModeBroadcastOnly {
+		okMsg := NewServerOKMsg(msg.Event.ID, true, ServerOKMsgPrefixNoPrefix, "")
+		return nil, newClosedBufCh[ServerMsg](okMsg), nil
+	}
+
+	return newClosedBufCh(msg), nil, nil
+}
+
+func (m *simpleRelayModeStoreMiddleware) HandleServerMsg(
+	r *http.Request,
+	msg ServerMsg,
+) (<-chan ServerMsg, error) {
+	return newClosedBufCh(msg), nil
+}
@@ -0,0 +1,133 @@
+package mocrelay
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRelayMode(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    RelayMode
+		wantErr bool
+	}{
+		{"", RelayModeReadWrite, false},
+		{"read-only", RelayModeReadOnly, false},
+		{"write-only", RelayModeWriteOnly, false},
+		{"broadcast-only", RelayModeBroadcastOnly, false},
+		{"readonly", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseRelayMode(tt.in)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRelayModeMiddleware(t *testing.T) {
+	event := &Event{ID: "1"}
+	req := &ClientReqMsg{SubscriptionID: "sub", ReqFilters: []*ReqFilter{{}}}
+	count := &ClientCountMsg{SubscriptionID: "cnt", ReqFilters: []*ReqFilter{{}}}
+
+	tests := []struct {
+		name   string
+		mode   RelayMode
+		pubkey string
+		in     ClientMsg
+		want   ServerMsg
+	}{
+		{
+			name: "read-write event",
+			in:   &ClientEventMsg{Event: event},
+		},
+		{
+			name: "read-only event",
+			mode: RelayModeReadOnly,
+			in:   &ClientEventMsg{Event: event},
+			want: NewServerOKMsg("1", false, ServerOkMsgPrefixRestricted, "read-only relay"),
+		},
+		{
+			name: "read-only req",
+			mode: RelayModeReadOnly,
+			in:   req,
+		},
+		{
+			name: "write-only req",
+			mode: RelayModeWriteOnly,
+			in:   req,
+			want: NewServerClosedMsg("sub", ServerClosedMsgPrefixRestricted, "write-only relay"),
+		},
+		{
+			name: "write-only count",
+			mode: RelayModeWriteOnly,
+			in:   count,
+			want: NewServerClosedMsg("cnt", ServerClosedMsgPrefixRestricted, "write-only relay"),
+		},
+		{
+			name:   "write-only req by pubkey group",
+			mode:   RelayModeWriteOnly,
+			pubkey: "owner",
+			in:     req,
+		},
+		{
+			name:   "read-only event by pubkey group",
+			pubkey: "reader",
+			in:     &ClientEventMsg{Event: event},
+			want:   NewServerOKMsg("1", false, ServerOkMsgPrefixRestricted, "read-only relay"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sess := &Session{}
+			sess.SetPubkey(tt.pubkey)
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r = r.WithContext(ctxWithSession(r.Context(), sess))
+
+			m := newSimpleRelayModeMiddleware(&RelayModeOption{
+				Mode: tt.mode,
+				PubkeyModes: map[string]RelayMode{
+					"owner":  RelayModeReadWrite,
+					"reader": RelayModeReadOnly,
+				},
+			})
+
+			cmsgCh, smsgCh, err := m.HandleClientMsg(r, tt.in)
+			assert.NoError(t, err)
+			if tt.want == nil {
+				assert.Equal(t, tt.in, <-cmsgCh)
+				assert.Nil(t, smsgCh)
+				return
+			}
+			assert.Nil(t, cmsgCh)
+			assert.Equal(t, tt.want, <-smsgCh)
+		})
+	}
+}
+
+func TestRelayModeStoreMiddleware(t *testing.T) {
+	event := &ClientEventMsg{Event: &Event{ID: "1"}}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	m := newSimpleRelayModeStoreMiddleware(&RelayModeOption{Mode: RelayModeBroadcastOnly})
+	cmsgCh, smsgCh, err := m.HandleClientMsg(r, event)
+	assert.NoError(t, err)
+	assert.Nil(t, cmsgCh)
+	assert.Equal(t, NewServerOKMsg("1", true, ServerOKMsgPrefixNoPrefix, ""), <-smsgCh)
+
+	m = newSimpleRelayModeStoreMiddleware(nil)
+	cmsgCh, smsgCh, err = m.HandleClientMsg(r, event)
+	assert.NoError(t, err)
+	assert.Equal(t, ClientMsg(event), <-cmsgCh)
+	assert.Nil(t, smsgCh)
+}
@@ -0,0 +1,419 @@
+package mocrelay
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"nhooyr.io/websocket"
+)
+
+func TestRelay_compression(t *testing.T) {
+	event := &Event{
+		ID:        "49d58222bd85ddabfc19b8052d35bcce2bad8f1f3030c0bc7dc9f10dba82a8a2",
+		Pubkey:    "dbf0becf24bf8dd7d779d7fb547e6112964ff042b77a42cc2d8488636eed9f5e",
+		CreatedAt: 1693157791,
+		Kind:      1,
+		Tags:      []Tag{},
+		Content:   strings.Repeat("powa", 256),
+		Sig:       "795e51656e8b863805c41b3a6e1195ed63bf8c5df1fc3a4078cd45aaf0d8838f2dc57b802819443364e8e38c0f35c97e409181680bfff83e58949500f5a8f0c8",
+	}
+	deflate := make(chan bool, 1)
+	h := HandlerFunc(func(r *http.Request, recv <-chan ClientMsg, send chan<- ServerMsg) error {
+		deflate <- GetSession(r.Context()).HasFeature(SessionFeatureCompression)
+		for msg := range recv {
+			if m, ok := msg.(*ClientReqMsg); ok {
+				send <- NewServerEventMsg(m.SubscriptionID, event)
+				send <- NewServerEOSEMsg(m.SubscriptionID)
+			}
+		}
+		return nil
+	})
+
+	tests := []struct {
+		name        string
+		option      *CompressionOption
+		wantDeflate bool
+	}{
+		{"disabled", nil, false},
+		{"no context takeover", &CompressionOption{}, true},
+		{"context takeover", &CompressionOption{ContextTakeover: true, Threshold: 128}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			relay := NewRelay(h, &RelayOption{Compression: tt.option})
+			srv := httptest.NewServer(relay)
+			defer srv.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			conn, res, err := websocket.Dial(
+				ctx,
+				"ws"+strings.TrimPrefix(srv.URL, "http"),
+				&websocket.DialOptions{CompressionMode: websocket.CompressionContextTakeover},
+			)
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer conn.Close(websocket.StatusNormalClosure, "")
+
+			ext := res.Header.Get("Sec-WebSocket-Extensions")
+			assert.Equal(t, tt.wantDeflate, strings.Contains(ext, "permessage-deflate"), ext)
+			assert.Equal(t, tt.wantDeflate, <-deflate)
+
+			assert.NoError(t, conn.Write(ctx, websocket.MessageText, []byte(`["REQ","sub",{}]`)))
+
+			_, b, err := conn.Read(ctx)
+			assert.NoError(t, err)
+			want, _ := NewServerEventMsg("sub", event).MarshalJSON()
+			assert.Equal(t, string(want), string(b))
+
+			_, b, err = conn.Read(ctx)
+			assert.NoError(t, err)
+			assert.Equal(t, `["EOSE","sub"]`, string(b))
+		})
+	}
+}
+
+func TestRelay_connLimit(t *testing.T) {
+	var counter testCounter
+	relay := NewRelay(NewRouterHandler(10), &RelayOption{
+		MaxConnectionsPerIP: 1,
+		Metrics:             &RelayMetrics{ConnLimitRejectedTotal: &counter},
+	})
+	srv := httptest.NewServer(relay)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	conn, _, err := websocket.Dial(ctx, url, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	_, res, err := websocket.Dial(ctx, url, nil)
+	assert.Error(t, err)
+	if assert.NotNil(t, res) {
+		assert.Equal(t, http.StatusTooManyRequests, res.StatusCode)
+	}
+	assert.Equal(t, 1, counter.n)
+
+	conn.Close(websocket.StatusNormalClosure, "")
+	assert.Eventually(
+		t,
+		func() bool { return relay.connLimiter.count() == 0 },
+		time.Second,
+		time.Millisecond,
+	)
+
+	conn, _, err = websocket.Dial(ctx, url, nil)
+	if assert.NoError(t, err) {
+		conn.Close(websocket.StatusNormalClosure, "")
+	}
+}
+
+func TestRelay_banList(t *testing.T) {
+	bans, err := NewBanList(&BanListOption{MaxStrikes: 2})
+	if !assert.NoError(t, err) {
+		return
+	}
+	relay := NewRelay(NewRouterHandler(10), &RelayOption{BanList: bans})
+	srv := httptest.NewServer(relay)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	conn, _, err := websocket.Dial(ctx, url, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close(websocket.StatusNormalClosure, "")
+
+	assert.NoError(t, conn.Write(ctx, websocket.MessageText, []byte("powa")))
+	_, _, err = conn.Read(ctx)
+	assert.NoError(t, err)
+
+	assert.NoError(t, conn.Write(ctx, websocket.MessageText, []byte("powa")))
+	_, _, err = conn.Read(ctx)
+	assert.Equal(t, websocket.StatusPolicyViolation, websocket.CloseStatus(err))
+
+	_, res, err := websocket.Dial(ctx, url, nil)
+	assert.Error(t, err)
+	if assert.NotNil(t, res) {
+		assert.Equal(t, http.StatusForbidden, res.StatusCode)
+	}
+}
+
+func TestRelay_ServeConn(t *testing.T) {
+	relay := NewRelay(NewRouterHandler(10), &RelayOption{MaxConnections: 1})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		relay.ServeConn(r, NewNhooyrWSConn(conn))
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	conn, _, err := websocket.Dial(ctx, url, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close(websocket.StatusNormalClosure, "")
+
+	err = conn.Write(ctx, websocket.MessageText, []byte(`["REQ","sub",{}]`))
+	assert.NoError(t, err)
+	_, b, err := conn.Read(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, `["EOSE","sub"]`, string(b))
+
+	rejected, _, err := websocket.Dial(ctx, url, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, _, err = rejected.Read(ctx)
+	assert.Equal(t, websocket.StatusCode(WSStatusTryAgainLater), websocket.CloseStatus(err))
+}
+
+func TestRelay_filterLimits(t *testing.T) {
+	relay := NewRelay(NewRouterHandler(10), &RelayOption{
+		FilterLimits: &ReqFilterLimits{MaxKinds: 2},
+	})
+	srv := httptest.NewServer(relay)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	conn, _, err := websocket.Dial(ctx, url, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close(websocket.StatusNormalClosure, "")
+
+	err = conn.Write(ctx, websocket.MessageText, []byte(`["REQ","sub",{"kinds":[1,6,7]}]`))
+	assert.NoError(t, err)
+	_, b, err := conn.Read(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, `["CLOSED","sub","invalid: too many kinds: 3 exceeds 2"]`, string(b))
+
+	err = conn.Write(ctx, websocket.MessageText, []byte(`["REQ","sub",{"kinds":[1,6]}]`))
+	assert.NoError(t, err)
+	_, b, err = conn.Read(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, `["EOSE","sub"]`, string(b))
+}
+
+func TestRelay_reassembleMessages(t *testing.T) {
+	relay := NewRelay(NewRouterHandler(10), &RelayOption{ReassembleMessages: true})
+	srv := httptest.NewServer(relay)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	conn, _, err := websocket.Dial(ctx, url, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close(websocket.StatusNormalClosure, "")
+
+	for _, frame := range []string{`["REQ","a",{}]["REQ",`, `"b",{}]`} {
+		assert.NoError(t, conn.Write(ctx, websocket.MessageText, []byte(frame)))
+	}
+
+	for _, want := range []string{`["EOSE","a"]`, `["EOSE","b"]`} {
+		_, b, err := conn.Read(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, want, string(b))
+	}
+}
+
+func TestRelay_cbor(t *testing.T) {
+	relay := NewRelay(NewRouterHandler(10), &RelayOption{CBOR: true})
+	srv := httptest.NewServer(relay)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	t.Run("negotiated", func(t *testing.T) {
+		conn, _, err := websocket.Dial(
+			ctx,
+			url,
+			&websocket.DialOptions{Subprotocols: []string{CBORSubprotocol}},
+		)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close(websocket.StatusNormalClosure, "")
+		assert.Equal(t, CBORSubprotocol, conn.Subprotocol())
+
+		// ["REQ","sub",{}]
+		req, _ := hex.DecodeString("836352455163737562a0")
+		assert.NoError(t, conn.Write(ctx, websocket.MessageBinary, req))
+
+		typ, b, err := conn.Read(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, websocket.MessageBinary, typ)
+		// ["EOSE","sub"]
+		assert.Equal(t, "8264454f534563737562", hex.EncodeToString(b))
+	})
+
+	t.Run("fallback", func(t *testing.T) {
+		conn, _, err := websocket.Dial(ctx, url, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close(websocket.StatusNormalClosure, "")
+		assert.Equal(t, "", conn.Subprotocol())
+
+		assert.NoError(t, conn.Write(ctx, websocket.MessageText, []byte(`["REQ","sub",{}]`)))
+
+		typ, b, err := conn.Read(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, websocket.MessageText, typ)
+		assert.Equal(t, `["EOSE","sub"]`, string(b))
+	})
+}
+
+// readTestWSConn returns payload from Reader and records the close code.
+// It does not limit reads.
+type readTestWSConn struct {
+	payload string
+	code    WSStatusCode
+}
+
+func (c *readTestWSConn) Reader(ctx context.Context) (WSMessageType, io.Reader, error) {
+	return WSMessageText, strings.NewReader(c.payload), nil
+}
+
+func (c *readTestWSConn) Write(ctx context.Context, b []byte) error { return nil }
+func (c *readTestWSConn) Ping(ctx context.Context) error            { return nil }
+func (c *readTestWSConn) SetReadLimit(n int64)                      {}
+
+func (c *readTestWSConn) Close(code WSStatusCode, reason string) error {
+	c.code = code
+	return nil
+}
+
+func TestRelay_read(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		code    WSStatusCode
+	}{
+		{"ok", `["ぽわ"]`, 0},
+		{"ok: max length", strings.Repeat("a", 16), 0},
+		{"ng: too long", strings.Repeat("a", 17), WSStatusMessageTooBig},
+		{"ng: invalid utf-8", "po\xffwa", WSStatusInvalidFramePayloadData},
+	}
+
+	relay := NewRelay(NewRouterHandler(1), &RelayOption{MaxMessageLength: 16})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &readTestWSConn{payload: tt.payload}
+			typ, b, err := relay.read(context.Background(), conn)
+			assert.Equal(t, tt.code, conn.code)
+			if tt.code != 0 {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, WSMessageText, typ)
+			assert.Equal(t, tt.payload, string(b))
+		})
+	}
+}
+
+// blockingTestWSConn returns payloads from Reader and blocks Write until ctx is done.
+type blockingTestWSConn struct {
+	payloads chan string
+	closed   chan WSStatusCode
+}
+
+func (c *blockingTestWSConn) Reader(ctx context.Context) (WSMessageType, io.Reader, error) {
+	select {
+	case <-ctx.Done():
+		return 0, nil, ctx.Err()
+	case payload := <-c.payloads:
+		return WSMessageText, strings.NewReader(payload), nil
+	}
+}
+
+func (c *blockingTestWSConn) Write(ctx context.Context, b []byte) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (c *blockingTestWSConn) Ping(ctx context.Context) error { return nil }
+func (c *blockingTestWSConn) SetReadLimit(n int64)           {}
+
+func (c *blockingTestWSConn) Close(code WSStatusCode, reason string) error {
+	select {
+	case c.closed <- code:
+	default:
+	}
+	return nil
+}
+
+func TestRelay_sendTimeout(t *testing.T) {
+	var sendTimeouts testAtomicCounter
+	relay := NewRelay(NewRouterHandler(10), &RelayOption{
+		SendTimeout: 10 * time.Millisecond,
+		Metrics:     &RelayMetrics{SendTimeoutTotal: &sendTimeouts},
+	})
+
+	conn := &blockingTestWSConn{
+		payloads: make(chan string, 1),
+		closed:   make(chan WSStatusCode, 1),
+	}
+	ctx := ctxWithSession(context.Background(), &Session{})
+	err := relay.write(ctx, conn, []byte(`["EOSE","sub"]`))
+	assert.ErrorIs(t, err, ErrSendTimeout)
+	assert.Equal(t, int64(1), sendTimeouts.n.Load())
+
+	// The peer is disconnected when it cannot receive the EOSE.
+	conn.payloads <- `["REQ","sub",{}]`
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		relay.ServeConn(httptest.NewRequest(http.MethodGet, "/", nil), conn)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("slow peer is not disconnected")
+	}
+	assert.Equal(t, WSStatusInternalError, <-conn.closed)
+	assert.Equal(t, int64(2), sendTimeouts.n.Load())
+}
+
+type testAtomicCounter struct{ n atomic.Int64 }
+
+func (c *testAtomicCounter) Inc() { c.n.Add(1) }
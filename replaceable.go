@@ -0,0 +1,40 @@
+package mocrelay
+
+// Keep is the event ResolveReplaceable keeps.
+type Keep int
+
+const (
+	KeepExisting Keep = iota
+	KeepIncoming
+)
+
+func (k Keep) String() string {
+	switch k {
+	case KeepExisting:
+		return "existing"
+	case KeepIncoming:
+		return "incoming"
+	default:
+		return "unknown"
+	}
+}
+
+// ResolveReplaceable returns which of the replaceable events with the same key
+// is kept as NIP-01 says: the one with the newest created_at and then the lowest id.
+// A nil existing event keeps incoming and the same event keeps existing,
+// so that stores do not replace an event with itself.
+func ResolveReplaceable(existing, incoming *Event) Keep {
+	switch {
+	case existing == nil:
+		return KeepIncoming
+	case incoming.CreatedAt != existing.CreatedAt:
+		if incoming.CreatedAt > existing.CreatedAt {
+			return KeepIncoming
+		}
+		return KeepExisting
+	case incoming.ID < existing.ID:
+		return KeepIncoming
+	default:
+		return KeepExisting
+	}
+}
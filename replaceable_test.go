@@ -0,0 +1,97 @@
+package mocrelay
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveReplaceable(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing *Event
+		incoming *Event
+		want     Keep
+	}{
+		{
+			name:     "no existing",
+			existing: nil,
+			incoming: &Event{ID: "b", CreatedAt: 1},
+			want:     KeepIncoming,
+		},
+		{
+			name:     "newer incoming",
+			existing: &Event{ID: "a", CreatedAt: 1},
+			incoming: &Event{ID: "b", CreatedAt: 2},
+			want:     KeepIncoming,
+		},
+		{
+			name:     "older incoming",
+			existing: &Event{ID: "b", CreatedAt: 2},
+			incoming: &Event{ID: "a", CreatedAt: 1},
+			want:     KeepExisting,
+		},
+		{
+			name:     "same created_at: lower incoming id",
+			existing: &Event{ID: "b", CreatedAt: 1},
+			incoming: &Event{ID: "a", CreatedAt: 1},
+			want:     KeepIncoming,
+		},
+		{
+			name:     "same created_at: higher incoming id",
+			existing: &Event{ID: "a", CreatedAt: 1},
+			incoming: &Event{ID: "b", CreatedAt: 1},
+			want:     KeepExisting,
+		},
+		{
+			name:     "same event",
+			existing: &Event{ID: "a", CreatedAt: 1},
+			incoming: &Event{ID: "a", CreatedAt: 1},
+			want:     KeepExisting,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, ResolveReplaceable(tt.existing, tt.incoming))
+		})
+	}
+}
+
+// TestResolveReplaceable_order checks that the result does not depend on
+// the order events arrive in.
+func TestResolveReplaceable_order(t *testing.T) {
+	events := []*Event{
+		{ID: "c", CreatedAt: 2},
+		{ID: "a", CreatedAt: 1},
+		{ID: "d", CreatedAt: 2},
+		{ID: "b", CreatedAt: 2},
+		{ID: "e", CreatedAt: 0},
+	}
+
+	var perm func(k int)
+	perm = func(k int) {
+		if k == len(events) {
+			var kept *Event
+			for _, ev := range events {
+				if ResolveReplaceable(kept, ev) == KeepIncoming {
+					kept = ev
+				}
+			}
+			assert.Equal(t, "b", kept.ID)
+			return
+		}
+		for i := k; i < len(events); i++ {
+			events[k], events[i] = events[i], events[k]
+			perm(k + 1)
+			events[k], events[i] = events[i], events[k]
+		}
+	}
+	perm(0)
+}
+
+func TestKeep_String(t *testing.T) {
+	assert.Equal(t, "existing", KeepExisting.String())
+	assert.Equal(t, "incoming", KeepIncoming.String())
+	assert.Equal(t, "unknown", Keep(-1).String())
+}
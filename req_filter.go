@@ -0,0 +1,237 @@
+package mocrelay
+
+import (
+	"cmp"
+	"fmt"
+	"hash/fnv"
+	"slices"
+)
+
+// canonical returns a copy of the filter with the values of each condition
+// sorted and deduplicated. Nil conditions stay nil.
+func (fil *ReqFilter) canonical() *ReqFilter {
+	sorted := func(s []string) []string {
+		if s == nil {
+			return nil
+		}
+		s = slices.Clone(s)
+		slices.Sort(s)
+		return slices.Compact(s)
+	}
+
+	ret := &ReqFilter{
+		IDs:     sorted(fil.IDs),
+		Authors: sorted(fil.Authors),
+		Since:   fil.Since,
+		Until:   fil.Until,
+		Limit:   fil.Limit,
+	}
+	if fil.Kinds != nil {
+		ret.Kinds = slices.Clone(fil.Kinds)
+		slices.Sort(ret.Kinds)
+		ret.Kinds = slices.Compact(ret.Kinds)
+	}
+	if fil.Tags != nil {
+		ret.Tags = make(map[string][]string, len(fil.Tags))
+		for k, v := range fil.Tags {
+			ret.Tags[k] = sorted(v)
+			if ret.Tags[k] == nil {
+				ret.Tags[k] = []string{}
+			}
+		}
+	}
+	return ret
+}
+
+// canonicalJSON returns the JSON of the canonical filter.
+// Filters which differ only in the order or duplicates of values have the same JSON.
+func (fil *ReqFilter) canonicalJSON() []byte {
+	b, _ := fil.canonical().AppendJSON(nil)
+	return b
+}
+
+// Equal reports whether fil and other have the same conditions
+// regardless of the order and duplicates of values.
+func (fil *ReqFilter) Equal(other *ReqFilter) bool {
+	if fil == nil || other == nil {
+		return fil == other
+	}
+	return string(fil.canonicalJSON()) == string(other.canonicalJSON())
+}
+
+// Hash returns a hash of the conditions. Equal filters have the same hash.
+func (fil *ReqFilter) Hash() uint64 {
+	h := fnv.New64a()
+	if fil != nil {
+		h.Write(fil.canonicalJSON())
+	}
+	return h.Sum64()
+}
+
+// empty reports whether the filter can match no event.
+func (fil *ReqFilter) empty() bool {
+	if fil.IDs != nil && len(fil.IDs) == 0 ||
+		fil.Authors != nil && len(fil.Authors) == 0 ||
+		fil.Kinds != nil && len(fil.Kinds) == 0 {
+		return true
+	}
+	for _, v := range fil.Tags {
+		if len(v) == 0 {
+			return true
+		}
+	}
+	return fil.Since != nil && fil.Until != nil && *fil.Since > *fil.Until
+}
+
+// Contains reports whether every event matching other also matches fil.
+// Limit is not considered.
+func (fil *ReqFilter) Contains(other *ReqFilter) bool {
+	if fil == nil || other == nil {
+		return false
+	}
+	if other.empty() {
+		return true
+	}
+
+	if !containsCond(fil.IDs, other.IDs) ||
+		!containsCond(fil.Authors, other.Authors) ||
+		!containsCond(fil.Kinds, other.Kinds) {
+		return false
+	}
+	for k, v := range fil.Tags {
+		ov, ok := other.Tags[k]
+		if !ok || !containsCond(v, ov) {
+			return false
+		}
+	}
+
+	if fil.Since != nil && (other.Since == nil || *other.Since < *fil.Since) {
+		return false
+	}
+	if fil.Until != nil && (other.Until == nil || *other.Until > *fil.Until) {
+		return false
+	}
+	return true
+}
+
+// Intersects reports whether some event can match both fil and other.
+// It may report true for filters whose conditions are unrelated such as ids and authors.
+// Limit is not considered.
+func (fil *ReqFilter) Intersects(other *ReqFilter) bool {
+	if fil == nil || other == nil {
+		return false
+	}
+	if fil.empty() || other.empty() {
+		return false
+	}
+
+	if !intersectsCond(fil.IDs, other.IDs) ||
+		!intersectsCond(fil.Authors, other.Authors) ||
+		!intersectsCond(fil.Kinds, other.Kinds) {
+		return false
+	}
+	for k, v := range fil.Tags {
+		if ov, ok := other.Tags[k]; ok && !intersectsCond(v, ov) {
+			return false
+		}
+	}
+
+	since := fil.Since
+	if since == nil || other.Since != nil && *other.Since > *since {
+		since = other.Since
+	}
+	until := fil.Until
+	if until == nil || other.Until != nil && *other.Until < *until {
+		until = other.Until
+	}
+	return since == nil || until == nil || *since <= *until
+}
+
+// containsCond reports whether the values allowed by cond include all the ones by other.
+// A nil condition allows any value.
+func containsCond[T cmp.Ordered](cond, other []T) bool {
+	if cond == nil {
+		return true
+	}
+	if other == nil {
+		return false
+	}
+	for _, v := range other {
+		if !slices.Contains(cond, v) {
+			return false
+		}
+	}
+	return true
+}
+
+// intersectsCond reports whether some value is allowed by both cond and other.
+func intersectsCond[T cmp.Ordered](cond, other []T) bool {
+	if cond == nil || other == nil {
+		return true
+	}
+	for _, v := range other {
+		if slices.Contains(cond, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReqFilterLimits caps the number of values of each condition of filters.
+// Zero means no limit.
+type ReqFilterLimits struct {
+	MaxIDs     int
+	MaxAuthors int
+	MaxKinds   int
+	// MaxTagValues is the max number of values of each tag condition such as "#e".
+	MaxTagValues int
+}
+
+// ReqFilterLimitError reports a filter condition with too many values.
+type ReqFilterLimitError struct {
+	// Field is the name of the condition such as "ids" or "#e".
+	Field string
+	Len   int
+	Max   int
+}
+
+func (e *ReqFilterLimitError) Error() string {
+	return fmt.Sprintf("too many %s: %d exceeds %d", e.Field, e.Len, e.Max)
+}
+
+// Check returns a *ReqFilterLimitError if fil has too many values of a condition.
+func (l *ReqFilterLimits) Check(fil *ReqFilter) error {
+	if l == nil || fil == nil {
+		return nil
+	}
+
+	check := func(field string, n, max int) error {
+		if max > 0 && n > max {
+			return &ReqFilterLimitError{Field: field, Len: n, Max: max}
+		}
+		return nil
+	}
+
+	if err := check("ids", len(fil.IDs), l.MaxIDs); err != nil {
+		return err
+	}
+	if err := check("authors", len(fil.Authors), l.MaxAuthors); err != nil {
+		return err
+	}
+	if err := check("kinds", len(fil.Kinds), l.MaxKinds); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(fil.Tags))
+	for name := range fil.Tags {
+		names = append(names, name)
+	}
+	// The error names the same field for the same filter.
+	slices.Sort(names)
+	for _, name := range names {
+		if err := check(name, len(fil.Tags[name]), l.MaxTagValues); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package mocrelay
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReqFilter_Equal(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b *ReqFilter
+		want bool
+	}{
+		{"empty", &ReqFilter{}, &ReqFilter{}, true},
+		{"nil", nil, nil, true},
+		{"nil and empty", nil, &ReqFilter{}, false},
+		{
+			"order and duplicates",
+			&ReqFilter{
+				Authors: []string{"a", "b"},
+				Kinds:   []int64{1, 7},
+				Tags:    map[string][]string{"#t": {"x", "y"}},
+			},
+			&ReqFilter{
+				Authors: []string{"b", "a", "a"},
+				Kinds:   []int64{7, 1},
+				Tags:    map[string][]string{"#t": {"y", "x", "y"}},
+			},
+			true,
+		},
+		{
+			"nil and empty condition",
+			&ReqFilter{IDs: nil},
+			&ReqFilter{IDs: []string{}},
+			false,
+		},
+		{
+			"different since",
+			&ReqFilter{Since: toPtr[int64](1)},
+			&ReqFilter{Since: toPtr[int64](2)},
+			false,
+		},
+		{
+			"different limit",
+			&ReqFilter{Limit: toPtr[int64](1)},
+			&ReqFilter{},
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.a.Equal(tt.b))
+			assert.Equal(t, tt.want, tt.b.Equal(tt.a))
+			if tt.want {
+				assert.Equal(t, tt.a.Hash(), tt.b.Hash())
+			} else {
+				assert.NotEqual(t, tt.a.Hash(), tt.b.Hash())
+			}
+		})
+	}
+}
+
+func TestReqFilter_Contains(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b *ReqFilter
+		want bool
+	}{
+		{"empty", &ReqFilter{}, &ReqFilter{Kinds: []int64{1}}, true},
+		{"reverse", &ReqFilter{Kinds: []int64{1}}, &ReqFilter{}, false},
+		{
+			"subset",
+			&ReqFilter{Kinds: []int64{1, 7}, Authors: []string{"a", "b"}},
+			&ReqFilter{Kinds: []int64{7}, Authors: []string{"a"}},
+			true,
+		},
+		{
+			"not subset",
+			&ReqFilter{Kinds: []int64{1}},
+			&ReqFilter{Kinds: []int64{1, 7}},
+			false,
+		},
+		{
+			"tags",
+			&ReqFilter{Tags: map[string][]string{"#t": {"x", "y"}}},
+			&ReqFilter{Tags: map[string][]string{"#t": {"x"}, "#p": {"p"}}},
+			true,
+		},
+		{
+			"missing tag",
+			&ReqFilter{Tags: map[string][]string{"#t": {"x"}}},
+			&ReqFilter{Tags: map[string][]string{"#p": {"p"}}},
+			false,
+		},
+		{
+			"time range",
+			&ReqFilter{Since: toPtr[int64](10), Until: toPtr[int64](20)},
+			&ReqFilter{Since: toPtr[int64](12), Until: toPtr[int64](20)},
+			true,
+		},
+		{
+			"open time range",
+			&ReqFilter{Since: toPtr[int64](10)},
+			&ReqFilter{Until: toPtr[int64](20)},
+			false,
+		},
+		{
+			"other matches nothing",
+			&ReqFilter{Kinds: []int64{1}},
+			&ReqFilter{Since: toPtr[int64](20), Until: toPtr[int64](10)},
+			true,
+		},
+		{
+			"limit is ignored",
+			&ReqFilter{Limit: toPtr[int64](1)},
+			&ReqFilter{Limit: toPtr[int64](10)},
+			true,
+		},
+		{"nil", nil, &ReqFilter{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.a.Contains(tt.b))
+		})
+	}
+}
+
+func TestReqFilter_Intersects(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b *ReqFilter
+		want bool
+	}{
+		{"empty", &ReqFilter{}, &ReqFilter{}, true},
+		{
+			"common kind",
+			&ReqFilter{Kinds: []int64{1, 7}},
+			&ReqFilter{Kinds: []int64{7, 30023}},
+			true,
+		},
+		{
+			"disjoint kinds",
+			&ReqFilter{Kinds: []int64{1}},
+			&ReqFilter{Kinds: []int64{7}},
+			false,
+		},
+		{
+			"different conditions",
+			&ReqFilter{Kinds: []int64{1}},
+			&ReqFilter{Authors: []string{"a"}},
+			true,
+		},
+		{
+			"disjoint tags",
+			&ReqFilter{Tags: map[string][]string{"#t": {"x"}}},
+			&ReqFilter{Tags: map[string][]string{"#t": {"y"}}},
+			false,
+		},
+		{
+			"overlapping time",
+			&ReqFilter{Since: toPtr[int64](10), Until: toPtr[int64](20)},
+			&ReqFilter{Since: toPtr[int64](20)},
+			true,
+		},
+		{
+			"disjoint time",
+			&ReqFilter{Until: toPtr[int64](10)},
+			&ReqFilter{Since: toPtr[int64](11)},
+			false,
+		},
+		{
+			"matches nothing",
+			&ReqFilter{IDs: []string{}},
+			&ReqFilter{},
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.a.Intersects(tt.b))
+			assert.Equal(t, tt.want, tt.b.Intersects(tt.a))
+		})
+	}
+}
+
+func TestReqFilterLimits_Check(t *testing.T) {
+	limits := &ReqFilterLimits{MaxIDs: 1, MaxAuthors: 2, MaxKinds: 3, MaxTagValues: 1}
+
+	tests := []struct {
+		name   string
+		filter *ReqFilter
+		want   error
+	}{
+		{"empty", &ReqFilter{}, nil},
+		{
+			"within limits",
+			&ReqFilter{
+				IDs:     []string{"a"},
+				Authors: []string{"a", "b"},
+				Kinds:   []int64{1, 2, 3},
+				Tags:    map[string][]string{"#e": {"a"}},
+			},
+			nil,
+		},
+		{
+			"ids",
+			&ReqFilter{IDs: []string{"a", "b"}},
+			&ReqFilterLimitError{Field: "ids", Len: 2, Max: 1},
+		},
+		{
+			"authors",
+			&ReqFilter{Authors: []string{"a", "b", "c"}},
+			&ReqFilterLimitError{Field: "authors", Len: 3, Max: 2},
+		},
+		{
+			"kinds",
+			&ReqFilter{Kinds: []int64{1, 2, 3, 4}},
+			&ReqFilterLimitError{Field: "kinds", Len: 4, Max: 3},
+		},
+		{
+			"tag values",
+			&ReqFilter{Tags: map[string][]string{"#p": {"a", "b"}, "#e": {"a", "b"}}},
+			&ReqFilterLimitError{Field: "#e", Len: 2, Max: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, limits.Check(tt.filter))
+		})
+	}
+
+	var nilLimits *ReqFilterLimits
+	assert.NoError(t, nilLimits.Check(&ReqFilter{IDs: []string{"a", "b"}}))
+}
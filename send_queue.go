@@ -0,0 +1,229 @@
+package mocrelay
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var ErrSlowConsumer = errors.New("slow consumer")
+
+type SendQueueOverflowPolicy int
+
+const (
+	// SendQueueOverflowBlock blocks the handler until the queue has room.
+	SendQueueOverflowBlock SendQueueOverflowPolicy = iota
+
+	// SendQueueOverflowDropOldestEvent drops the oldest queued EVENT.
+	SendQueueOverflowDropOldestEvent
+
+	// SendQueueOverflowCloseSubscription drops the queued messages of the
+	// overflowing subscription and sends CLOSED for it. Further messages for
+	// the subscription are dropped until the client sends REQ or CLOSE for it.
+	SendQueueOverflowCloseSubscription
+
+	// SendQueueOverflowDisconnect closes the connection.
+	SendQueueOverflowDisconnect
+)
+
+type SendQueueOption struct {
+	Size     int
+	Overflow SendQueueOverflowPolicy
+}
+
+func (opt *SendQueueOption) size() int {
+	const defaultSize = 256
+
+	if opt == nil || opt.Size <= 0 {
+		return defaultSize
+	}
+	return opt.Size
+}
+
+type sendQueue struct {
+	size     int
+	overflow SendQueueOverflowPolicy
+	metrics  *RelayMetrics
+
+	// mu serializes pushes and guards closedSubs.
+	mu   sync.Mutex
+	msgs *syncRingBuffer[ServerMsg]
+	// map[subID]closed
+	closedSubs map[string]bool
+
+	notFull chan struct{}
+}
+
+func newSendQueue(option *SendQueueOption, metrics *RelayMetrics) *sendQueue {
+	size := option.size()
+	q := &sendQueue{
+		size:    size,
+		metrics: metrics,
+		// Control messages can exceed the size.
+		msgs:       newSyncRingBuffer[ServerMsg](size, true),
+		closedSubs: make(map[string]bool),
+		notFull:    make(chan struct{}, 1),
+	}
+	if option != nil {
+		q.overflow = option.Overflow
+	}
+	return q
+}
+
+func serverMsgSubscriptionID(msg ServerMsg) (string, bool) {
+	switch msg := msg.(type) {
+	case *ServerEventMsg:
+		return msg.SubscriptionID, true
+	case *ServerEOSEMsg:
+		return msg.SubscriptionID, true
+	default:
+		return "", false
+	}
+}
+
+func trySignal(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+func (q *sendQueue) push(ctx context.Context, msg ServerMsg) error {
+	for {
+		q.mu.Lock()
+
+		if subID, ok := serverMsgSubscriptionID(msg); ok && q.closedSubs[subID] {
+			q.mu.Unlock()
+			q.metrics.incSendQueueDrop()
+			return nil
+		}
+
+		if q.msgs.Len() < q.size {
+			q.msgs.Enqueue(msg)
+			q.mu.Unlock()
+			return nil
+		}
+
+		switch q.overflow {
+		case SendQueueOverflowDropOldestEvent:
+			q.dropOldestEventLocked(msg)
+			q.mu.Unlock()
+			return nil
+
+		case SendQueueOverflowCloseSubscription:
+			q.closeSubscriptionLocked(msg)
+			q.mu.Unlock()
+			return nil
+
+		case SendQueueOverflowDisconnect:
+			q.mu.Unlock()
+			q.metrics.incSendQueueDrop()
+			return ErrSlowConsumer
+
+		default:
+			q.mu.Unlock()
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-q.notFull:
+			}
+		}
+	}
+}
+
+func (q *sendQueue) dropOldestEventLocked(msg ServerMsg) {
+	var dropped bool
+	q.msgs.Do(func(rb *ringBuffer[ServerMsg]) {
+		rb.DeleteFunc(func(m ServerMsg) bool {
+			if dropped {
+				return false
+			}
+			_, dropped = m.(*ServerEventMsg)
+			return dropped
+		})
+		if dropped {
+			rb.Enqueue(msg)
+			return
+		}
+
+		// control messages are never dropped
+		if _, ok := msg.(*ServerEventMsg); !ok {
+			rb.Enqueue(msg)
+		}
+	})
+
+	if _, ok := msg.(*ServerEventMsg); dropped || ok {
+		q.metrics.incSendQueueDrop()
+	}
+}
+
+func (q *sendQueue) closeSubscriptionLocked(msg ServerMsg) {
+	_, isEvent := msg.(*ServerEventMsg)
+
+	q.msgs.Do(func(rb *ringBuffer[ServerMsg]) {
+		subID, ok := serverMsgSubscriptionID(msg)
+		if !isEvent {
+			ok = false
+			for i := rb.Len() - 1; i >= 0; i-- {
+				if ev, isEv := rb.At(i).(*ServerEventMsg); isEv {
+					subID, ok = ev.SubscriptionID, true
+					break
+				}
+			}
+		}
+		if !ok {
+			rb.Enqueue(msg)
+			return
+		}
+
+		q.closedSubs[subID] = true
+
+		rb.DeleteFunc(func(m ServerMsg) bool {
+			if id, ok := serverMsgSubscriptionID(m); ok && id == subID {
+				q.metrics.incSendQueueDrop()
+				return true
+			}
+			return false
+		})
+
+		rb.Enqueue(NewServerClosedMsg(subID, ServerClosedMsgPrefixRateLimited, "slow consumer"))
+
+		if id, _ := serverMsgSubscriptionID(msg); isEvent || id == subID {
+			q.metrics.incSendQueueDrop()
+		} else {
+			rb.Enqueue(msg)
+		}
+	})
+}
+
+// reopen allows the messages of the subscription again.
+func (q *sendQueue) reopen(subID string) {
+	if q == nil {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.closedSubs, subID)
+}
+
+func (q *sendQueue) pop() (ServerMsg, bool) {
+	if q == nil {
+		return nil, false
+	}
+
+	msg, ok := q.msgs.Dequeue()
+	if ok {
+		trySignal(q.notFull)
+	}
+	return msg, ok
+}
+
+// notEmpty returns a channel which receives a value when the queue may have messages.
+func (q *sendQueue) notEmpty() <-chan struct{} {
+	return q.msgs.NotEmpty()
+}
+
+func (q *sendQueue) len() int {
+	return q.msgs.Len()
+}
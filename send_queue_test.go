@@ -0,0 +1,188 @@
+package mocrelay
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func popAllServerMsgs(q *sendQueue) []ServerMsg {
+	var ret []ServerMsg
+	for {
+		msg, ok := q.pop()
+		if !ok {
+			return ret
+		}
+		ret = append(ret, msg)
+	}
+}
+
+func TestSendQueue_push(t *testing.T) {
+	ev := func(subID, content string) ServerMsg {
+		return NewServerEventMsg(subID, &Event{Content: content})
+	}
+
+	tests := []struct {
+		name    string
+		policy  SendQueueOverflowPolicy
+		in      []ServerMsg
+		want    []ServerMsg
+		err     error
+		dropped int
+	}{
+		{
+			name:   "drop oldest event",
+			policy: SendQueueOverflowDropOldestEvent,
+			in: []ServerMsg{
+				NewServerEOSEMsg("a"),
+				ev("a", "1"),
+				ev("b", "2"),
+				ev("a", "3"),
+			},
+			want: []ServerMsg{
+				NewServerEOSEMsg("a"),
+				ev("b", "2"),
+				ev("a", "3"),
+			},
+			dropped: 1,
+		},
+		{
+			name:   "drop oldest event: no events",
+			policy: SendQueueOverflowDropOldestEvent,
+			in: []ServerMsg{
+				NewServerEOSEMsg("a"),
+				NewServerEOSEMsg("b"),
+				NewServerEOSEMsg("c"),
+				ev("a", "1"),
+				NewServerNoticeMsg("notice"),
+			},
+			want: []ServerMsg{
+				NewServerEOSEMsg("a"),
+				NewServerEOSEMsg("b"),
+				NewServerEOSEMsg("c"),
+				NewServerNoticeMsg("notice"),
+			},
+			dropped: 1,
+		},
+		{
+			name:   "close subscription",
+			policy: SendQueueOverflowCloseSubscription,
+			in: []ServerMsg{
+				ev("a", "1"),
+				ev("b", "2"),
+				ev("a", "3"),
+				ev("a", "4"),
+				ev("a", "5"),
+				NewServerEOSEMsg("a"),
+			},
+			want: []ServerMsg{
+				ev("b", "2"),
+				NewServerClosedMsg("a", ServerClosedMsgPrefixRateLimited, "slow consumer"),
+			},
+			dropped: 5,
+		},
+		{
+			name:   "close subscription: non event overflow",
+			policy: SendQueueOverflowCloseSubscription,
+			in: []ServerMsg{
+				ev("a", "1"),
+				ev("b", "2"),
+				ev("b", "3"),
+				NewServerNoticeMsg("notice"),
+			},
+			want: []ServerMsg{
+				ev("b", "2"),
+				ev("b", "3"),
+				NewServerClosedMsg("a", ServerClosedMsgPrefixRateLimited, "slow consumer"),
+				NewServerNoticeMsg("notice"),
+			},
+			dropped: 1,
+		},
+		{
+			name:   "disconnect",
+			policy: SendQueueOverflowDisconnect,
+			in: []ServerMsg{
+				ev("a", "1"),
+				ev("a", "2"),
+				ev("a", "3"),
+				ev("a", "4"),
+			},
+			want: []ServerMsg{
+				ev("a", "1"),
+				ev("a", "2"),
+				ev("a", "3"),
+			},
+			err:     ErrSlowConsumer,
+			dropped: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var counter testCounter
+			q := newSendQueue(
+				&SendQueueOption{Size: 3, Overflow: tt.policy},
+				&RelayMetrics{SendQueueDropTotal: &counter},
+			)
+
+			var err error
+			for _, msg := range tt.in {
+				if err = q.push(context.Background(), msg); err != nil {
+					break
+				}
+			}
+			assert.ErrorIs(t, err, tt.err)
+			assert.Equal(t, tt.want, popAllServerMsgs(q))
+			assert.Equal(t, tt.dropped, counter.n)
+		})
+	}
+}
+
+func TestSendQueue_reopen(t *testing.T) {
+	q := newSendQueue(&SendQueueOption{Size: 1, Overflow: SendQueueOverflowCloseSubscription}, nil)
+	ctx := context.Background()
+
+	assert.NoError(t, q.push(ctx, NewServerEventMsg("a", &Event{})))
+	assert.NoError(t, q.push(ctx, NewServerEventMsg("a", &Event{})))
+	assert.Equal(
+		t,
+		[]ServerMsg{NewServerClosedMsg("a", ServerClosedMsgPrefixRateLimited, "slow consumer")},
+		popAllServerMsgs(q),
+	)
+
+	assert.NoError(t, q.push(ctx, NewServerEventMsg("a", &Event{})))
+	assert.Equal(t, 0, q.len())
+
+	q.reopen("a")
+	assert.NoError(t, q.push(ctx, NewServerEventMsg("a", &Event{})))
+	assert.Equal(t, 1, q.len())
+}
+
+func TestSendQueue_block(t *testing.T) {
+	q := newSendQueue(&SendQueueOption{Size: 1}, nil)
+	ctx := context.Background()
+
+	assert.NoError(t, q.push(ctx, NewServerEOSEMsg("a")))
+
+	done := make(chan error)
+	go func() { done <- q.push(ctx, NewServerEOSEMsg("b")) }()
+
+	select {
+	case <-done:
+		t.Fatal("push must block")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	msg, ok := q.pop()
+	assert.True(t, ok)
+	assert.Equal(t, NewServerEOSEMsg("a"), msg)
+	assert.NoError(t, <-done)
+	assert.Equal(t, []ServerMsg{NewServerEOSEMsg("b")}, popAllServerMsgs(q))
+
+	ctx, cancel := context.WithCancel(ctx)
+	assert.NoError(t, q.push(ctx, NewServerEOSEMsg("c")))
+	cancel()
+	assert.ErrorIs(t, q.push(ctx, NewServerEOSEMsg("d")), context.Canceled)
+}
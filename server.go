@@ -18,9 +18,7 @@ type ServeMux struct {
 
 func (mux *ServeMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	ctx = ctxWithRealIP(ctx, r)
-	ctx = ctxWithRequestID(ctx)
-	ctx = ctxWithHTTPHeader(ctx, r)
+	ctx = ctxWithSession(ctx, newSession(r, mux.realIPResolver(), ""))
 	r = r.WithContext(ctx)
 
 	if mux.Logger != nil {
@@ -33,10 +31,10 @@ func (mux *ServeMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	} else if r.Header.Get("Accept") == "application/nostr+json" {
 		mux.logInfo(r.Context(), "got nip11 access")
-		if mux.NIP11 == nil {
+		if nip11 := mux.nip11(); nip11 == nil {
 			io.WriteString(w, "{}")
 		} else {
-			mux.NIP11.ServeHTTP(w, r)
+			nip11.ServeHTTP(w, r)
 		}
 
 	} else {
@@ -49,6 +47,28 @@ func (mux *ServeMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (mux *ServeMux) realIPResolver() *RealIPResolver {
+	if mux.Relay == nil {
+		return nil
+	}
+	return mux.Relay.opt.realIPResolver()
+}
+
+func (mux *ServeMux) nip11() *NIP11 {
+	if mux.NIP11 == nil || mux.NIP11.RelayURL != "" || mux.Relay == nil {
+		return mux.NIP11
+	}
+
+	relayURL := mux.Relay.CanonicalURL()
+	if relayURL == "" {
+		return mux.NIP11
+	}
+
+	nip11 := *mux.NIP11
+	nip11.RelayURL = relayURL
+	return &nip11
+}
+
 func (mux *ServeMux) logInfo(ctx context.Context, msg string, args ...any) {
 	if mux.logger == nil {
 		return
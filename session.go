@@ -0,0 +1,126 @@
+package mocrelay
+
+import (
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Features negotiated on a connection.
+const (
+	SessionFeatureCompression = "permessage-deflate"
+	SessionFeatureCBOR        = CBORSubprotocol
+)
+
+// Session is the state of a connection carried in the context of handlers.
+// The exported fields are set when the connection is accepted and must not be modified.
+type Session struct {
+	ID          string
+	RealIP      string
+	Header      http.Header
+	RelayURL    string
+	ConnectedAt time.Time
+
+	// egress limits the outbound bandwidth. It is set before the connection is served.
+	egress *byteLimiter
+
+	mu       sync.RWMutex
+	pubkey   string
+	features []string
+	values   map[any]any
+}
+
+func newSession(r *http.Request, realIP *RealIPResolver, relayURL string) *Session {
+	return &Session{
+		ID:          uuid.NewString(),
+		RealIP:      realIP.FromRequest(r),
+		Header:      r.Header,
+		RelayURL:    relayURL,
+		ConnectedAt: time.Now(),
+	}
+}
+
+// Pubkey returns the authenticated pubkey or an empty string.
+func (s *Session) Pubkey() string {
+	if s == nil {
+		return ""
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.pubkey
+}
+
+// SetPubkey is called by middlewares which authenticate the client.
+func (s *Session) SetPubkey(pubkey string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pubkey = pubkey
+}
+
+// Features returns the negotiated features.
+func (s *Session) Features() []string {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return slices.Clone(s.features)
+}
+
+func (s *Session) HasFeature(feature string) bool {
+	if s == nil {
+		return false
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return slices.Contains(s.features, feature)
+}
+
+func (s *Session) AddFeature(feature string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !slices.Contains(s.features, feature) {
+		s.features = append(s.features, feature)
+	}
+}
+
+// Value returns the value for key set by SetValue.
+// Middlewares should use unexported key types as with context.Context.
+func (s *Session) Value(key any) any {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.values[key]
+}
+
+func (s *Session) SetValue(key, value any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.values == nil {
+		s.values = make(map[any]any)
+	}
+	s.values[key] = value
+}
+
+func (s *Session) egressLimiter() *byteLimiter {
+	if s == nil {
+		return nil
+	}
+	return s.egress
+}
@@ -0,0 +1,54 @@
+package mocrelay
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func ctxWithTestSession(ctx context.Context, realIP string) context.Context {
+	return ctxWithSession(ctx, &Session{ID: uuid.NewString(), RealIP: realIP})
+}
+
+func TestNewSession(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	r.Header.Set("User-Agent", "powa")
+
+	sess := newSession(r, nil, "wss://relay.example.com")
+	_, err := uuid.Parse(sess.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "192.0.2.1", sess.RealIP)
+	assert.Equal(t, "powa", sess.Header.Get("User-Agent"))
+	assert.Equal(t, "wss://relay.example.com", sess.RelayURL)
+	assert.False(t, sess.ConnectedAt.IsZero())
+}
+
+func TestSession(t *testing.T) {
+	type keyType struct{}
+
+	var sess Session
+	assert.Equal(t, "", sess.Pubkey())
+	sess.SetPubkey("pubkey")
+	assert.Equal(t, "pubkey", sess.Pubkey())
+
+	assert.False(t, sess.HasFeature(SessionFeatureCompression))
+	sess.AddFeature(SessionFeatureCompression)
+	sess.AddFeature(SessionFeatureCompression)
+	assert.True(t, sess.HasFeature(SessionFeatureCompression))
+	assert.Equal(t, []string{SessionFeatureCompression}, sess.Features())
+
+	assert.Nil(t, sess.Value(keyType{}))
+	sess.SetValue(keyType{}, 1)
+	assert.Equal(t, 1, sess.Value(keyType{}))
+
+	var nilSess *Session
+	assert.Equal(t, "", nilSess.Pubkey())
+	assert.Nil(t, nilSess.Features())
+	assert.False(t, nilSess.HasFeature(SessionFeatureCompression))
+	assert.Nil(t, nilSess.Value(keyType{}))
+}
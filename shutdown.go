@@ -0,0 +1,172 @@
+package mocrelay
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+)
+
+var ErrRelayShutdown = errors.New("relay is shutting down")
+
+type relayShutdown struct {
+	mu       sync.Mutex
+	done     bool
+	draining chan struct{}
+	forced   chan struct{}
+}
+
+func (s *relayShutdown) init() {
+	if s.draining == nil {
+		s.draining = make(chan struct{})
+		s.forced = make(chan struct{})
+	}
+}
+
+// Shutdown stops accepting new websocket connections, sends CLOSED for active
+// subscriptions and a NOTICE to each connection, flushes queued messages and closes
+// the connections. If ctx is done before all connections are closed, the remaining
+// connections are closed immediately and ctx.Err() is returned.
+func (relay *Relay) Shutdown(ctx context.Context) error {
+	relay.shutdown.mu.Lock()
+	relay.shutdown.init()
+	if !relay.shutdown.done {
+		relay.shutdown.done = true
+		close(relay.shutdown.draining)
+	}
+	relay.shutdown.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		relay.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+
+	case <-ctx.Done():
+		relay.shutdown.mu.Lock()
+		select {
+		case <-relay.shutdown.forced:
+		default:
+			close(relay.shutdown.forced)
+		}
+		relay.shutdown.mu.Unlock()
+
+		<-done
+		return ctx.Err()
+	}
+}
+
+func (relay *Relay) shuttingDown() bool {
+	relay.shutdown.mu.Lock()
+	defer relay.shutdown.mu.Unlock()
+	return relay.shutdown.done
+}
+
+// enter registers a new connection. It returns false if the relay is shutting down.
+func (relay *Relay) enter() bool {
+	relay.shutdown.mu.Lock()
+	defer relay.shutdown.mu.Unlock()
+
+	relay.shutdown.init()
+	if relay.shutdown.done {
+		return false
+	}
+	relay.wg.Add(1)
+	return true
+}
+
+func (relay *Relay) watchShutdown(
+	ctx context.Context,
+	cancel context.CancelFunc,
+	conn WSConn,
+	subs *activeSubs,
+	q *sendQueue,
+) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-relay.shutdown.draining:
+	}
+
+	relay.logInfo(ctx, relay.logger, "drain connection")
+	defer cancel()
+
+	flush := func(msg ServerMsg) bool {
+		select {
+		case <-relay.shutdown.forced:
+			return false
+		default:
+		}
+		return relay.writeServerMsg(ctx, conn, msg) == nil
+	}
+
+	for {
+		msg, ok := q.pop()
+		if !ok {
+			break
+		}
+		if !flush(msg) {
+			return
+		}
+	}
+
+	for _, subID := range subs.list() {
+		msg := NewServerClosedMsg(subID, ServerClosedMsgPrefixError, "relay is shutting down")
+		if !flush(msg) {
+			return
+		}
+	}
+	if !flush(NewServerNoticeMsg("relay is shutting down")) {
+		return
+	}
+
+	// Close waits for the close handshake, which a stuck peer never completes.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		conn.Close(WSStatusGoingAway, "relay is shutting down")
+	}()
+
+	select {
+	case <-closed:
+	case <-relay.shutdown.forced:
+	}
+}
+
+type activeSubs struct {
+	mu sync.Mutex
+	// map[subID]exists
+	m map[string]bool
+}
+
+func newActiveSubs() *activeSubs {
+	return &activeSubs{m: make(map[string]bool)}
+}
+
+func (s *activeSubs) handleClientMsg(msg ClientMsg) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch msg := msg.(type) {
+	case *ClientReqMsg:
+		s.m[msg.SubscriptionID] = true
+	case *ClientCloseMsg:
+		delete(s.m, msg.SubscriptionID)
+	}
+}
+
+func (s *activeSubs) list() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ret := make([]string, 0, len(s.m))
+	for subID := range s.m {
+		ret = append(ret, subID)
+	}
+	sort.Strings(ret)
+	return ret
+}
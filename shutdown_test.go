@@ -0,0 +1,100 @@
+package mocrelay
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"nhooyr.io/websocket"
+)
+
+func TestRelay_Shutdown(t *testing.T) {
+	relay := NewRelay(NewCacheHandler(10), nil)
+	srv := httptest.NewServer(relay)
+	defer srv.Close()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, _, err := websocket.Dial(ctx, url, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close(websocket.StatusNormalClosure, "")
+
+	assert.NoError(t, conn.Write(ctx, websocket.MessageText, []byte(`["REQ","sub1",{}]`)))
+	assert.NoError(t, conn.Write(ctx, websocket.MessageText, []byte(`["REQ","sub2",{}]`)))
+	assert.NoError(t, conn.Write(ctx, websocket.MessageText, []byte(`["CLOSE","sub2"]`)))
+
+	for i := 0; i < 2; i++ {
+		_, b, err := conn.Read(ctx)
+		assert.NoError(t, err)
+		assert.True(t, strings.HasPrefix(string(b), `["EOSE",`), string(b))
+	}
+	// wait for the CLOSE to be handled
+	time.Sleep(10 * time.Millisecond)
+
+	shutdownErr := make(chan error, 1)
+	go func() { shutdownErr <- relay.Shutdown(ctx) }()
+
+	var got []string
+	for {
+		_, b, err := conn.Read(ctx)
+		if err != nil {
+			assert.Equal(t, websocket.StatusGoingAway, websocket.CloseStatus(err))
+			break
+		}
+		got = append(got, string(b))
+	}
+	assert.Equal(t, []string{
+		`["CLOSED","sub1","error: relay is shutting down"]`,
+		`["NOTICE","relay is shutting down"]`,
+	}, got)
+
+	assert.NoError(t, <-shutdownErr)
+
+	_, res, err := websocket.Dial(ctx, url, nil)
+	assert.Error(t, err)
+	if assert.NotNil(t, res) {
+		assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+	}
+}
+
+func TestRelay_Shutdown_forced(t *testing.T) {
+	blocking := HandlerFunc(
+		func(r *http.Request, recv <-chan ClientMsg, send chan<- ServerMsg) error {
+			<-r.Context().Done()
+			return r.Context().Err()
+		},
+	)
+
+	relay := NewRelay(blocking, nil)
+	srv := httptest.NewServer(relay)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close(websocket.StatusNormalClosure, "")
+
+	// the client never reads, but closing still finishes by the deadline
+	shutdownCtx, shutdownCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer shutdownCancel()
+
+	start := time.Now()
+	err = relay.Shutdown(shutdownCtx)
+	if err != nil {
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	}
+	assert.Less(t, time.Since(start), time.Second)
+}
@@ -0,0 +1,38 @@
+package mocrelay
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+)
+
+// SigVerifier verifies a BIP-340 schnorr signature of a 32-byte event id
+// by a 32-byte x-only pubkey.
+type SigVerifier interface {
+	VerifySig(id, pubkey, sig []byte) (bool, error)
+}
+
+// BatchSigVerifier verifies many signatures in one call.
+// Implementations may verify them one by one. Malformed inputs are reported as false.
+type BatchSigVerifier interface {
+	SigVerifier
+	VerifySigBatch(ids, pubkeys, sigs [][]byte) []bool
+}
+
+var _ SigVerifier = BtcecSigVerifier{}
+
+type BtcecSigVerifier struct{}
+
+func (BtcecSigVerifier) VerifySig(id, pubkey, sig []byte) (bool, error) {
+	pk, err := schnorr.ParsePubKey(pubkey)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse pubkey: %w", err)
+	}
+
+	s, err := schnorr.ParseSignature(sig)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse sig: %w", err)
+	}
+
+	return s.Verify(id, pk), nil
+}
@@ -0,0 +1,132 @@
+//go:build cgo && secp256k1
+
+package mocrelay
+
+/*
+#cgo LDFLAGS: -lsecp256k1
+#include <stddef.h>
+#include <secp256k1.h>
+#include <secp256k1_extrakeys.h>
+#include <secp256k1_schnorrsig.h>
+
+static int mocrelay_secp256k1_verify(
+	const secp256k1_context *ctx,
+	const unsigned char *id32,
+	const unsigned char *pubkey32,
+	const unsigned char *sig64
+) {
+	secp256k1_xonly_pubkey pk;
+	if (!secp256k1_xonly_pubkey_parse(ctx, &pk, pubkey32)) {
+		return -1;
+	}
+	return secp256k1_schnorrsig_verify(ctx, sig64, id32, 32, &pk);
+}
+
+// mocrelay_secp256k1_verify_each verifies the signatures one by one.
+// It is not batch verification, which libsecp256k1 does not provide,
+// but saves the cgo call per signature.
+static void mocrelay_secp256k1_verify_each(
+	const secp256k1_context *ctx,
+	const unsigned char *ids,
+	const unsigned char *pubkeys,
+	const unsigned char *sigs,
+	unsigned char *results,
+	size_t n
+) {
+	size_t i;
+	for (i = 0; i < n; i++) {
+		results[i] = mocrelay_secp256k1_verify(
+			ctx, ids + 32 * i, pubkeys + 32 * i, sigs + 64 * i
+		) == 1;
+	}
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"sync"
+	"unsafe"
+)
+
+var (
+	secp256k1CtxOnce sync.Once
+	secp256k1Ctx     *C.secp256k1_context
+)
+
+func secp256k1Context() *C.secp256k1_context {
+	secp256k1CtxOnce.Do(func() {
+		secp256k1Ctx = C.secp256k1_context_create(C.SECP256K1_CONTEXT_VERIFY)
+	})
+	return secp256k1Ctx
+}
+
+var _ BatchSigVerifier = Secp256k1SigVerifier{}
+
+// Secp256k1SigVerifier verifies signatures with libsecp256k1.
+// It is available with the secp256k1 build tag.
+type Secp256k1SigVerifier struct{}
+
+func (Secp256k1SigVerifier) VerifySig(id, pubkey, sig []byte) (bool, error) {
+	if len(id) != 32 {
+		return false, errors.New("invalid id length")
+	}
+	if len(pubkey) != 32 {
+		return false, errors.New("failed to parse pubkey: invalid length")
+	}
+	if len(sig) != 64 {
+		return false, errors.New("failed to parse sig: invalid length")
+	}
+
+	ret := C.mocrelay_secp256k1_verify(
+		secp256k1Context(),
+		(*C.uchar)(unsafe.Pointer(&id[0])),
+		(*C.uchar)(unsafe.Pointer(&pubkey[0])),
+		(*C.uchar)(unsafe.Pointer(&sig[0])),
+	)
+	if ret < 0 {
+		return false, errors.New("failed to parse pubkey")
+	}
+	return ret == 1, nil
+}
+
+// VerifySigBatch verifies the signatures one by one in a single cgo call,
+// which only saves the overhead of a cgo call per signature.
+func (Secp256k1SigVerifier) VerifySigBatch(ids, pubkeys, sigs [][]byte) []bool {
+	n := len(ids)
+	ret := make([]bool, n)
+	if n == 0 || len(pubkeys) != n || len(sigs) != n {
+		return ret
+	}
+
+	flatIDs := make([]byte, 0, 32*n)
+	flatPubkeys := make([]byte, 0, 32*n)
+	flatSigs := make([]byte, 0, 64*n)
+	for i := 0; i < n; i++ {
+		if len(ids[i]) != 32 || len(pubkeys[i]) != 32 || len(sigs[i]) != 64 {
+			// keep offsets; the zero pubkey fails to parse
+			flatIDs = append(flatIDs, make([]byte, 32)...)
+			flatPubkeys = append(flatPubkeys, make([]byte, 32)...)
+			flatSigs = append(flatSigs, make([]byte, 64)...)
+			continue
+		}
+		flatIDs = append(flatIDs, ids[i]...)
+		flatPubkeys = append(flatPubkeys, pubkeys[i]...)
+		flatSigs = append(flatSigs, sigs[i]...)
+	}
+
+	results := make([]byte, n)
+	C.mocrelay_secp256k1_verify_each(
+		secp256k1Context(),
+		(*C.uchar)(unsafe.Pointer(&flatIDs[0])),
+		(*C.uchar)(unsafe.Pointer(&flatPubkeys[0])),
+		(*C.uchar)(unsafe.Pointer(&flatSigs[0])),
+		(*C.uchar)(unsafe.Pointer(&results[0])),
+		C.size_t(n),
+	)
+
+	for i, r := range results {
+		ret[i] = r != 0
+	}
+	return ret
+}
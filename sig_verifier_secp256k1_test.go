@@ -0,0 +1,48 @@
+//go:build cgo && secp256k1
+
+package mocrelay
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecp256k1SigVerifier(t *testing.T) {
+	valid := signTestEvent(
+		t,
+		&Event{CreatedAt: 1693157791, Kind: 1, Tags: []Tag{}, Content: "powa"},
+	)
+
+	id, _ := hex.DecodeString(valid.ID)
+	pubkey, _ := hex.DecodeString(valid.Pubkey)
+	sig, _ := hex.DecodeString(valid.Sig)
+
+	badSig := append([]byte(nil), sig...)
+	badSig[63] ^= 1
+
+	var sv Secp256k1SigVerifier
+
+	ok, err := sv.VerifySig(id, pubkey, sig)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = sv.VerifySig(id, pubkey, badSig)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	_, err = sv.VerifySig(id, pubkey[:31], sig)
+	assert.Error(t, err)
+
+	got := sv.VerifySigBatch(
+		[][]byte{id, id, id},
+		[][]byte{pubkey, pubkey, pubkey[:31]},
+		[][]byte{sig, badSig, sig},
+	)
+	assert.Equal(t, []bool{true, false, false}, got)
+
+	ok, err = valid.VerifyWith(sv)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
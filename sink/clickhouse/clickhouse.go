@@ -0,0 +1,227 @@
+// Package clickhouse mirrors accepted events into ClickHouse for analytics.
+//
+// The sink is separate from the serving store. It consumes events from
+// mocrelay.Firehose or works as a mocrelay.EventSink, and inserts them in batches
+// on a *sql.DB opened with a ClickHouse driver such as github.com/ClickHouse/clickhouse-go.
+package clickhouse
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/high-moctane/mocrelay"
+)
+
+type Option struct {
+	// Table is the table name. The default is "events".
+	Table string
+	// BatchSize is the max number of events in an insert. The default is 1000.
+	BatchSize int
+	// FlushInterval is the max interval between inserts. The default is 1 second.
+	FlushInterval time.Duration
+	// Logger logs failed inserts if not nil.
+	Logger *slog.Logger
+}
+
+func (opt *Option) table() string {
+	if opt == nil || opt.Table == "" {
+		return "events"
+	}
+	return opt.Table
+}
+
+func (opt *Option) batchSize() int {
+	if opt == nil || opt.BatchSize == 0 {
+		return 1000
+	}
+	return opt.BatchSize
+}
+
+func (opt *Option) flushInterval() time.Duration {
+	if opt == nil || opt.FlushInterval == 0 {
+		return time.Second
+	}
+	return opt.FlushInterval
+}
+
+func (opt *Option) logger() *slog.Logger {
+	if opt == nil {
+		return nil
+	}
+	return opt.Logger
+}
+
+type Sink struct {
+	db       *sql.DB
+	opt      *Option
+	accepted chan *acceptedRow
+
+	// insert is replaced in tests.
+	insert func(ctx context.Context, rows []row) error
+}
+
+type row struct {
+	event      *mocrelay.Event
+	receivedAt time.Time
+}
+
+// acceptedRow is a row which Accept waits for being inserted.
+type acceptedRow struct {
+	row
+	done chan error
+}
+
+var _ mocrelay.EventSink = (*Sink)(nil)
+
+func New(db *sql.DB, option *Option) *Sink {
+	s := &Sink{db: db, opt: option, accepted: make(chan *acceptedRow)}
+	s.insert = s.insertDB
+	return s
+}
+
+// Migrate creates the table if it does not exist.
+func (s *Sink) Migrate(ctx context.Context) error {
+	stmt := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		id FixedString(64),
+		pubkey FixedString(64),
+		created_at DateTime,
+		kind UInt32,
+		tags String,
+		content String,
+		sig FixedString(128),
+		received_at DateTime
+	) ENGINE = MergeTree
+	PARTITION BY toYYYYMM(received_at)
+	ORDER BY (kind, pubkey, created_at)`, s.opt.table())
+
+	if _, err := s.db.ExecContext(ctx, stmt); err != nil {
+		return fmt.Errorf("failed to migrate: %w", err)
+	}
+	return nil
+}
+
+// Accept waits until event is inserted by Run.
+func (s *Sink) Accept(ctx context.Context, event *mocrelay.Event) error {
+	r := &acceptedRow{
+		row:  row{event: event, receivedAt: time.Now()},
+		done: make(chan error, 1),
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case s.accepted <- r:
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-r.done:
+		return err
+	}
+}
+
+// Run inserts events and the events of Accept in batches until events is closed or ctx is done.
+// Events may be nil if the sink is used only as a mocrelay.EventSink.
+// Failed batches are logged and dropped so that analytics never blocks the relay,
+// and their errors are returned to Accept.
+func (s *Sink) Run(ctx context.Context, events <-chan *mocrelay.Event) error {
+	ticker := time.NewTicker(s.opt.flushInterval())
+	defer ticker.Stop()
+
+	batch := make([]row, 0, s.opt.batchSize())
+	var waiters []chan error
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		err := s.insert(context.WithoutCancel(ctx), batch)
+		if err != nil {
+			if logger := s.opt.logger(); logger != nil {
+				logger.WarnContext(ctx, "failed to insert events", "n", len(batch), "err", err)
+			}
+		}
+		for _, done := range waiters {
+			done <- err
+		}
+		batch = batch[:0]
+		waiters = waiters[:0]
+	}
+	defer flush()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-ticker.C:
+			flush()
+
+		case ev, ok := <-events:
+			if !ok {
+				return nil
+			}
+			batch = append(batch, row{event: ev, receivedAt: time.Now()})
+			if len(batch) >= s.opt.batchSize() {
+				flush()
+			}
+
+		case r := <-s.accepted:
+			batch = append(batch, r.row)
+			waiters = append(waiters, r.done)
+			if len(batch) >= s.opt.batchSize() {
+				flush()
+			}
+		}
+	}
+}
+
+// insertDB inserts rows in a transaction, which ClickHouse drivers send as a single block.
+func (s *Sink) insertDB(ctx context.Context, rows []row) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, fmt.Sprintf(
+		"INSERT INTO %s (id, pubkey, created_at, kind, tags, content, sig, received_at) "+
+			"VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		s.opt.table(),
+	))
+	if err != nil {
+		return fmt.Errorf("failed to prepare insert: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, r := range rows {
+		ev := r.event
+		tags, err := json.Marshal(ev.Tags)
+		if err != nil {
+			return fmt.Errorf("failed to marshal tags: %w", err)
+		}
+		_, err = stmt.ExecContext(
+			ctx,
+			ev.ID,
+			ev.Pubkey,
+			ev.CreatedAtTime(),
+			uint32(ev.Kind),
+			string(tags),
+			ev.Content,
+			ev.Sig,
+			r.receivedAt,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to insert event: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package clickhouse
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/high-moctane/mocrelay"
+	"github.com/stretchr/testify/assert"
+)
+
+type testInserter struct {
+	mu      sync.Mutex
+	batches [][]string
+	err     error
+}
+
+func (ins *testInserter) insert(ctx context.Context, rows []row) error {
+	ins.mu.Lock()
+	defer ins.mu.Unlock()
+
+	var ids []string
+	for _, r := range rows {
+		ids = append(ids, r.event.ID)
+	}
+	ins.batches = append(ins.batches, ids)
+	return ins.err
+}
+
+func (ins *testInserter) Batches() [][]string {
+	ins.mu.Lock()
+	defer ins.mu.Unlock()
+
+	return ins.batches
+}
+
+func TestSink_Run(t *testing.T) {
+	t.Run("batch size and close", func(t *testing.T) {
+		var ins testInserter
+		s := New(nil, &Option{BatchSize: 2, FlushInterval: time.Hour})
+		s.insert = ins.insert
+
+		events := make(chan *mocrelay.Event, 3)
+		for _, id := range []string{"a", "b", "c"} {
+			events <- &mocrelay.Event{ID: id}
+		}
+		close(events)
+
+		assert.NoError(t, s.Run(context.Background(), events))
+		assert.Equal(t, [][]string{{"a", "b"}, {"c"}}, ins.Batches())
+	})
+
+	t.Run("flush interval", func(t *testing.T) {
+		ins := testInserter{err: errors.New("down")}
+		s := New(nil, &Option{FlushInterval: 10 * time.Millisecond})
+		s.insert = ins.insert
+
+		ctx, cancel := context.WithCancel(context.Background())
+		events := make(chan *mocrelay.Event)
+		done := make(chan error)
+		go func() { done <- s.Run(ctx, events) }()
+
+		events <- &mocrelay.Event{ID: "a"}
+		assert.Eventually(
+			t,
+			func() bool { return len(ins.Batches()) == 1 },
+			time.Second,
+			time.Millisecond,
+		)
+
+		// Failed batches are dropped.
+		events <- &mocrelay.Event{ID: "b"}
+		cancel()
+		assert.ErrorIs(t, <-done, context.Canceled)
+		assert.Equal(t, [][]string{{"a"}, {"b"}}, ins.Batches())
+	})
+}
+
+func TestSink_Accept(t *testing.T) {
+	ins := testInserter{err: errors.New("down")}
+	s := New(nil, &Option{BatchSize: 2, FlushInterval: time.Hour})
+	s.insert = ins.insert
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx, nil)
+
+	errs := make(chan error, 2)
+	for _, id := range []string{"a", "b"} {
+		go func() { errs <- s.Accept(ctx, &mocrelay.Event{ID: id}) }()
+	}
+
+	// Accept returns the error of the batch insert.
+	assert.EqualError(t, <-errs, "down")
+	assert.EqualError(t, <-errs, "down")
+	assert.Len(t, ins.Batches(), 1)
+
+	timeout, cancelTimeout := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancelTimeout()
+	assert.ErrorIs(t, s.Accept(timeout, &mocrelay.Event{ID: "c"}), context.DeadlineExceeded)
+}
@@ -0,0 +1,388 @@
+package mocrelay
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// SpamFeatures are the contextual features of an event passed to SpamScorer.
+type SpamFeatures struct {
+	// IP is the real IP of the sender.
+	IP string
+	// IPReputation is in [0, 1] and higher is worse.
+	// It is always 0 if SpamFilterOption.IPReputation is nil.
+	IPReputation float64
+	// EventRate is the number of events from the pubkey in the current rate window
+	// including the event.
+	EventRate int
+	// Fingerprint is the hash of the normalized content.
+	// It is empty if the content is too short to be fingerprinted.
+	Fingerprint string
+	// DuplicatePubkeys is the number of distinct pubkeys which sent content with the same
+	// fingerprint in the duplicate window including the sender.
+	DuplicatePubkeys int
+	// Lag is the received time minus created_at. It is negative for future-dated events.
+	Lag time.Duration
+	// SkewedEvents is the number of events from the pubkey in the current rate window
+	// whose Lag exceeds SpamFilterOption.MaxSkew in either direction including the event.
+	SkewedEvents int
+}
+
+type SpamScorer interface {
+	// ScoreSpam returns the spam score of the event. Higher is more likely spam.
+	ScoreSpam(ctx context.Context, event *Event, features *SpamFeatures) float64
+}
+
+type SpamScorerFunc func(ctx context.Context, event *Event, features *SpamFeatures) float64
+
+func (f SpamScorerFunc) ScoreSpam(
+	ctx context.Context,
+	event *Event,
+	features *SpamFeatures,
+) float64 {
+	return f(ctx, event, features)
+}
+
+var _ SpamScorer = (*HeuristicSpamScorer)(nil)
+
+// HeuristicSpamScorer is the built-in SpamScorer.
+// Each tripped heuristic adds its weight to the IP reputation.
+type HeuristicSpamScorer struct {
+	// MaxEventRate is the allowed EventRate. The default is 30.
+	MaxEventRate int
+	// MaxDuplicatePubkeys is the allowed DuplicatePubkeys. The default is 3.
+	MaxDuplicatePubkeys int
+	// MaxLinks is the allowed number of links in the content. The default is 5.
+	MaxLinks int
+	// MaxSkewedEvents is the allowed SkewedEvents. The default is 10.
+	MaxSkewedEvents int
+}
+
+func (s *HeuristicSpamScorer) maxEventRate() int {
+	if s == nil || s.MaxEventRate == 0 {
+		return 30
+	}
+	return s.MaxEventRate
+}
+
+func (s *HeuristicSpamScorer) maxDuplicatePubkeys() int {
+	if s == nil || s.MaxDuplicatePubkeys == 0 {
+		return 3
+	}
+	return s.MaxDuplicatePubkeys
+}
+
+func (s *HeuristicSpamScorer) maxLinks() int {
+	if s == nil || s.MaxLinks == 0 {
+		return 5
+	}
+	return s.MaxLinks
+}
+
+func (s *HeuristicSpamScorer) maxSkewedEvents() int {
+	if s == nil || s.MaxSkewedEvents == 0 {
+		return 10
+	}
+	return s.MaxSkewedEvents
+}
+
+func (s *HeuristicSpamScorer) ScoreSpam(
+	ctx context.Context,
+	event *Event,
+	features *SpamFeatures,
+) float64 {
+	score := features.IPReputation
+
+	if rate := features.EventRate; rate > 2*s.maxEventRate() {
+		score += 1
+	} else if rate > s.maxEventRate() {
+		score += 0.5
+	}
+
+	if features.DuplicatePubkeys > s.maxDuplicatePubkeys() {
+		score += 1
+	}
+
+	links := strings.Count(event.Content, "http://") + strings.Count(event.Content, "https://")
+	if links > s.maxLinks() {
+		score += 0.5
+	}
+
+	// Backfilling clients legitimately send old events, so skew alone is not enough.
+	if features.SkewedEvents > s.maxSkewedEvents() {
+		score += 0.25
+	}
+
+	return score
+}
+
+type SpamVerdict int
+
+const (
+	SpamVerdictAccept SpamVerdict = iota
+	// SpamVerdictShadowBan means the event is acknowledged but silently dropped.
+	SpamVerdictShadowBan
+	SpamVerdictReject
+)
+
+type SpamFilterOption struct {
+	// Scorers are summed up to the score of an event.
+	// The default is a single HeuristicSpamScorer.
+	Scorers []SpamScorer
+
+	// IPReputation returns the reputation of ip in [0, 1].
+	IPReputation func(ip string) float64
+
+	// RejectThreshold is the score from which events are rejected. The default is 1.
+	RejectThreshold float64
+	// ShadowBanThreshold is the score from which events are shadow-banned.
+	// The default is 0.5. Set it to RejectThreshold or more to disable shadow-banning.
+	ShadowBanThreshold float64
+
+	// RateWindow is the window of EventRate. The default is 1 minute.
+	RateWindow time.Duration
+	// DuplicateWindow is the window of DuplicatePubkeys. The default is 1 hour.
+	DuplicateWindow time.Duration
+	// MinFingerprintLength is the content byte length from which it is fingerprinted.
+	// The default is 32.
+	MinFingerprintLength int
+	// CacheSize is the number of pubkeys and fingerprints to track. The default is 10000.
+	CacheSize int
+	// MaxSkew is the Lag from which events are counted in SkewedEvents.
+	// The default is 1 hour.
+	MaxSkew time.Duration
+}
+
+func (opt *SpamFilterOption) scorers() []SpamScorer {
+	if opt == nil || len(opt.Scorers) == 0 {
+		return []SpamScorer{new(HeuristicSpamScorer)}
+	}
+	return opt.Scorers
+}
+
+func (opt *SpamFilterOption) ipReputation(ip string) float64 {
+	if opt == nil || opt.IPReputation == nil || ip == "" {
+		return 0
+	}
+	return opt.IPReputation(ip)
+}
+
+func (opt *SpamFilterOption) rejectThreshold() float64 {
+	if opt == nil || opt.RejectThreshold == 0 {
+		return 1
+	}
+	return opt.RejectThreshold
+}
+
+func (opt *SpamFilterOption) shadowBanThreshold() float64 {
+	if opt == nil || opt.ShadowBanThreshold == 0 {
+		return 0.5
+	}
+	return opt.ShadowBanThreshold
+}
+
+func (opt *SpamFilterOption) rateWindow() time.Duration {
+	if opt == nil || opt.RateWindow == 0 {
+		return time.Minute
+	}
+	return opt.RateWindow
+}
+
+func (opt *SpamFilterOption) duplicateWindow() time.Duration {
+	if opt == nil || opt.DuplicateWindow == 0 {
+		return time.Hour
+	}
+	return opt.DuplicateWindow
+}
+
+func (opt *SpamFilterOption) minFingerprintLength() int {
+	if opt == nil || opt.MinFingerprintLength == 0 {
+		return 32
+	}
+	return opt.MinFingerprintLength
+}
+
+func (opt *SpamFilterOption) maxSkew() time.Duration {
+	if opt == nil || opt.MaxSkew == 0 {
+		return time.Hour
+	}
+	return opt.MaxSkew
+}
+
+func (opt *SpamFilterOption) cacheSize() int {
+	if opt == nil || opt.CacheSize == 0 {
+		return 10000
+	}
+	return opt.CacheSize
+}
+
+type SpamFilter struct {
+	opt *SpamFilterOption
+
+	mu sync.Mutex
+	// map[pubkey]rate
+	rates *lruCache[string, *spamRate]
+	// map[fingerprint]map[pubkey]lastSeen
+	fingerprints *lruCache[string, map[string]time.Time]
+}
+
+type spamRate struct {
+	start  time.Time
+	count  int
+	skewed int
+}
+
+func NewSpamFilter(option *SpamFilterOption) *SpamFilter {
+	return &SpamFilter{
+		opt:          option,
+		rates:        newLRUCache[string, *spamRate](option.cacheSize()),
+		fingerprints: newLRUCache[string, map[string]time.Time](option.cacheSize()),
+	}
+}
+
+// Check records the event and returns its verdict and score.
+// The real IP is taken from ctx.
+func (f *SpamFilter) Check(ctx context.Context, event *Event) (SpamVerdict, float64) {
+	features := f.features(ctx, event, time.Now())
+
+	var score float64
+	for _, s := range f.opt.scorers() {
+		score += s.ScoreSpam(ctx, event, features)
+	}
+
+	switch {
+	case score >= f.opt.rejectThreshold():
+		return SpamVerdictReject, score
+	case score >= f.opt.shadowBanThreshold():
+		return SpamVerdictShadowBan, score
+	default:
+		return SpamVerdictAccept, score
+	}
+}
+
+func (f *SpamFilter) features(ctx context.Context, event *Event, now time.Time) *SpamFeatures {
+	ip := GetRealIP(ctx)
+	features := &SpamFeatures{
+		IP:           ip,
+		IPReputation: f.opt.ipReputation(ip),
+		Lag:          now.Sub(event.CreatedAtTime()),
+	}
+	if len(event.Content) >= f.opt.minFingerprintLength() {
+		features.Fingerprint = spamFingerprint(event.Content)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	rate, ok := f.rates.Get(event.Pubkey)
+	if !ok {
+		rate = new(spamRate)
+		f.rates.Set(event.Pubkey, rate)
+	}
+	if now.Sub(rate.start) >= f.opt.rateWindow() {
+		rate.start = now
+		rate.count = 0
+		rate.skewed = 0
+	}
+	rate.count++
+	features.EventRate = rate.count
+
+	if features.Lag > f.opt.maxSkew() || -features.Lag > f.opt.maxSkew() {
+		rate.skewed++
+	}
+	features.SkewedEvents = rate.skewed
+
+	if features.Fingerprint != "" {
+		pubkeys, ok := f.fingerprints.Get(features.Fingerprint)
+		if !ok {
+			pubkeys = make(map[string]time.Time)
+			f.fingerprints.Set(features.Fingerprint, pubkeys)
+		}
+		for pubkey, seen := range pubkeys {
+			if now.Sub(seen) >= f.opt.duplicateWindow() {
+				delete(pubkeys, pubkey)
+			}
+		}
+		pubkeys[event.Pubkey] = now
+		features.DuplicatePubkeys = len(pubkeys)
+	}
+
+	return features
+}
+
+// spamFingerprint hashes content ignoring case, whitespace and punctuation
+// so that trivially varied copies share the fingerprint.
+func spamFingerprint(content string) string {
+	h := sha256.New()
+	var buf [utf8.UTFMax]byte
+	for _, r := range content {
+		if !unicode.IsLetter(r) && !unicode.IsNumber(r) {
+			continue
+		}
+		n := utf8.EncodeRune(buf[:], unicode.ToLower(r))
+		h.Write(buf[:n])
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+type SpamMiddleware Middleware
+
+func NewSpamMiddleware(filter *SpamFilter) SpamMiddleware {
+	if filter == nil {
+		panic("spam filter must be non-nil pointer")
+	}
+	m := newSimpleSpamMiddleware(filter)
+	return SpamMiddleware(NewSimpleMiddleware(m))
+}
+
+var _ SimpleMiddlewareInterface = (*simpleSpamMiddleware)(nil)
+
+type simpleSpamMiddleware struct {
+	filter *SpamFilter
+}
+
+func newSimpleSpamMiddleware(filter *SpamFilter) *simpleSpamMiddleware {
+	return &simpleSpamMiddleware{filter: filter}
+}
+
+func (m *simpleSpamMiddleware) HandleStart(r *http.Request) (*http.Request, error) {
+	return r, nil
+}
+
+func (m *simpleSpamMiddleware) HandleStop(r *http.Request) error {
+	return nil
+}
+
+func (m *simpleSpamMiddleware) HandleClientMsg(
+	r *http.Request,
+	msg ClientMsg,
+) (<-chan ClientMsg, <-chan ServerMsg, error) {
+	if msg, ok := msg.(*ClientEventMsg); ok {
+		verdict, _ := m.filter.Check(r.Context(), msg.Event)
+		switch verdict {
+		case SpamVerdictReject:
+			okMsg := NewServerOKMsg(msg.Event.ID, false, ServerOkMsgPrefixBlocked, "spam")
+			return nil, newClosedBufCh[ServerMsg](okMsg), nil
+
+		case SpamVerdictShadowBan:
+			okMsg := NewServerOKMsg(msg.Event.ID, true, ServerOKMsgPrefixNoPrefix, "")
+			return nil, newClosedBufCh[ServerMsg](okMsg), nil
+		}
+	}
+
+	return newClosedBufCh(msg), nil, nil
+}
+
+func (m *simpleSpamMiddleware) HandleServerMsg(
+	r *http.Request,
+	msg ServerMsg,
+) (<-chan ServerMsg, error) {
+	return newClosedBufCh(msg), nil
+}
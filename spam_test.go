@@ -0,0 +1,221 @@
+package mocrelay
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeuristicSpamScorer(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		features SpamFeatures
+		want     float64
+	}{
+		{
+			name:    "clean",
+			content: "hello",
+			features: SpamFeatures{
+				EventRate:        1,
+				DuplicatePubkeys: 1,
+			},
+			want: 0,
+		},
+		{
+			name:     "ip reputation",
+			content:  "hello",
+			features: SpamFeatures{IPReputation: 0.3, EventRate: 1},
+			want:     0.3,
+		},
+		{
+			name:     "high rate",
+			content:  "hello",
+			features: SpamFeatures{EventRate: 31},
+			want:     0.5,
+		},
+		{
+			name:     "very high rate",
+			content:  "hello",
+			features: SpamFeatures{EventRate: 61},
+			want:     1,
+		},
+		{
+			name:     "duplicate content",
+			content:  "hello",
+			features: SpamFeatures{EventRate: 1, DuplicatePubkeys: 4},
+			want:     1,
+		},
+		{
+			name:     "many links",
+			content:  strings.Repeat("https://example.com ", 6),
+			features: SpamFeatures{EventRate: 1},
+			want:     0.5,
+		},
+		{
+			name:     "skewed events",
+			content:  "hello",
+			features: SpamFeatures{EventRate: 11, SkewedEvents: 11},
+			want:     0.25,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := new(HeuristicSpamScorer)
+			got := s.ScoreSpam(context.Background(), &Event{Content: tt.content}, &tt.features)
+			assert.InDelta(t, tt.want, got, 1e-9)
+		})
+	}
+}
+
+func TestSpamFingerprint(t *testing.T) {
+	assert.Equal(t, spamFingerprint("Buy NOW!!! cheap"), spamFingerprint("buy now, cheap."))
+	assert.NotEqual(t, spamFingerprint("buy now"), spamFingerprint("sell now"))
+}
+
+func TestSpamFilter_Check(t *testing.T) {
+	content := "this is a very long spam message sprayed across many accounts"
+
+	t.Run("duplicate pubkeys", func(t *testing.T) {
+		f := NewSpamFilter(nil)
+		ctx := context.Background()
+
+		for _, pubkey := range []string{"a", "b", "c"} {
+			verdict, _ := f.Check(ctx, &Event{Pubkey: pubkey, Content: content})
+			assert.Equal(t, SpamVerdictAccept, verdict)
+		}
+		verdict, score := f.Check(ctx, &Event{Pubkey: "d", Content: content})
+		assert.Equal(t, SpamVerdictReject, verdict)
+		assert.InDelta(t, 1, score, 1e-9)
+
+		// The same pubkey is counted once.
+		verdict, _ = f.Check(ctx, &Event{Pubkey: "d", Content: "short"})
+		assert.Equal(t, SpamVerdictAccept, verdict)
+	})
+
+	t.Run("rate", func(t *testing.T) {
+		f := NewSpamFilter(&SpamFilterOption{
+			Scorers:    []SpamScorer{&HeuristicSpamScorer{MaxEventRate: 2}},
+			RateWindow: time.Hour,
+		})
+		ctx := context.Background()
+
+		var verdicts []SpamVerdict
+		for i := 0; i < 5; i++ {
+			verdict, _ := f.Check(ctx, &Event{Pubkey: "a"})
+			verdicts = append(verdicts, verdict)
+		}
+		assert.Equal(t, []SpamVerdict{
+			SpamVerdictAccept,
+			SpamVerdictAccept,
+			SpamVerdictShadowBan,
+			SpamVerdictShadowBan,
+			SpamVerdictReject,
+		}, verdicts)
+	})
+
+	t.Run("skew", func(t *testing.T) {
+		var got []SpamFeatures
+		f := NewSpamFilter(&SpamFilterOption{
+			Scorers: []SpamScorer{
+				SpamScorerFunc(func(_ context.Context, _ *Event, features *SpamFeatures) float64 {
+					got = append(got, *features)
+					return 0
+				}),
+			},
+			MaxSkew: time.Minute,
+		})
+		ctx := context.Background()
+		now := time.Now().Unix()
+
+		for _, createdAt := range []int64{now, now - 3600, now + 3600} {
+			f.Check(ctx, &Event{Pubkey: "a", CreatedAt: createdAt})
+		}
+		if assert.Len(t, got, 3) {
+			assert.InDelta(t, 0, got[0].Lag.Seconds(), 2)
+			assert.InDelta(t, 3600, got[1].Lag.Seconds(), 2)
+			assert.InDelta(t, -3600, got[2].Lag.Seconds(), 2)
+			assert.Equal(t, 0, got[0].SkewedEvents)
+			assert.Equal(t, 1, got[1].SkewedEvents)
+			assert.Equal(t, 2, got[2].SkewedEvents)
+		}
+	})
+
+	t.Run("ip reputation and custom scorer", func(t *testing.T) {
+		f := NewSpamFilter(&SpamFilterOption{
+			Scorers: []SpamScorer{
+				new(HeuristicSpamScorer),
+				SpamScorerFunc(func(ctx context.Context, event *Event, _ *SpamFeatures) float64 {
+					if strings.Contains(event.Content, "nsec") {
+						return 0.2
+					}
+					return 0
+				}),
+			},
+			IPReputation: func(ip string) float64 {
+				if ip == "192.0.2.1" {
+					return 0.4
+				}
+				return 0
+			},
+			ShadowBanThreshold: 0.6,
+		})
+		ctx := ctxWithTestSession(context.Background(), "192.0.2.1")
+
+		verdict, score := f.Check(ctx, &Event{Pubkey: "a", Content: "hi"})
+		assert.Equal(t, SpamVerdictAccept, verdict)
+		assert.InDelta(t, 0.4, score, 1e-9)
+
+		verdict, score = f.Check(ctx, &Event{Pubkey: "a", Content: "send nsec"})
+		assert.Equal(t, SpamVerdictShadowBan, verdict)
+		assert.InDelta(t, 0.6, score, 1e-9)
+	})
+}
+
+func TestSpamMiddleware(t *testing.T) {
+	f := NewSpamFilter(&SpamFilterOption{
+		Scorers: []SpamScorer{
+			SpamScorerFunc(func(ctx context.Context, event *Event, _ *SpamFeatures) float64 {
+				switch event.Content {
+				case "reject":
+					return 1
+				case "shadow":
+					return 0.5
+				default:
+					return 0
+				}
+			}),
+		},
+	})
+	m := newSimpleSpamMiddleware(f)
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	msg := &ClientEventMsg{Event: &Event{ID: "1", Content: "ok"}}
+	cmsgCh, smsgCh, err := m.HandleClientMsg(r, msg)
+	require.NoError(t, err)
+	assert.Nil(t, smsgCh)
+	assert.Equal(t, ClientMsg(msg), <-cmsgCh)
+
+	cmsgCh, smsgCh, err = m.HandleClientMsg(
+		r,
+		&ClientEventMsg{Event: &Event{ID: "2", Content: "shadow"}},
+	)
+	require.NoError(t, err)
+	assert.Nil(t, cmsgCh)
+	assert.Equal(t, NewServerOKMsg("2", true, ServerOKMsgPrefixNoPrefix, ""), <-smsgCh)
+
+	cmsgCh, smsgCh, err = m.HandleClientMsg(
+		r,
+		&ClientEventMsg{Event: &Event{ID: "3", Content: "reject"}},
+	)
+	require.NoError(t, err)
+	assert.Nil(t, cmsgCh)
+	assert.Equal(t, NewServerOKMsg("3", false, ServerOkMsgPrefixBlocked, "spam"), <-smsgCh)
+}
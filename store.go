@@ -0,0 +1,189 @@
+package mocrelay
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"runtime"
+	"time"
+)
+
+// EventStore is a persistent storage of events such as store/mysql.
+type EventStore interface {
+	// Save stores event. It returns false if the store already has the event
+	// or a newer replaceable event. Ephemeral events are never stored.
+	Save(ctx context.Context, event *Event) (bool, error)
+	// Delete deletes the events referenced by the kind-5 deletion event.
+	Delete(ctx context.Context, deletion *Event) error
+	// Query returns events matching filters in descending order of created_at.
+	Query(ctx context.Context, filters []*ReqFilter) ([]*Event, error)
+	// Count returns the number of events matching filters.
+	Count(ctx context.Context, filters []*ReqFilter) (uint64, error)
+	// Namespace returns the store of the events of namespace ns in the same storage,
+	// isolated from the other namespaces such as other tenants and parallel tests.
+	// Stores returned by constructors are of the namespace "".
+	Namespace(ns string) EventStore
+
+	PubkeyPurger
+}
+
+type StoreHandlerOption struct {
+	// QueryTimeout is the timeout of REQ and COUNT queries. Zero means no timeout.
+	QueryTimeout time.Duration
+	// MaxConcurrentQueries is the max number of queries run at the same time.
+	// The default is GOMAXPROCS.
+	MaxConcurrentQueries int
+}
+
+func (opt *StoreHandlerOption) queryTimeout() time.Duration {
+	if opt == nil {
+		return 0
+	}
+	return opt.QueryTimeout
+}
+
+func (opt *StoreHandlerOption) maxConcurrentQueries() int {
+	if opt == nil || opt.MaxConcurrentQueries == 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return opt.MaxConcurrentQueries
+}
+
+// StoreHandler is a Handler which serves events from an EventStore.
+type StoreHandler struct {
+	h     Handler
+	store EventStore
+}
+
+func NewStoreHandler(store EventStore, option *StoreHandlerOption) *StoreHandler {
+	if store == nil {
+		panic("store must be non-nil")
+	}
+	return &StoreHandler{
+		h:     NewSimpleHandler(newSimpleStoreHandler(store, option)),
+		store: store,
+	}
+}
+
+func (h *StoreHandler) Handle(r *http.Request, recv <-chan ClientMsg, send chan<- ServerMsg) error {
+	return h.h.Handle(r, recv, send)
+}
+
+// PurgePubkey deletes all events by pubkey and returns the number of them.
+func (h *StoreHandler) PurgePubkey(ctx context.Context, pubkey string) (int, error) {
+	return h.store.PurgePubkey(ctx, pubkey)
+}
+
+type simpleStoreHandler struct {
+	store        EventStore
+	sema         chan struct{}
+	queryTimeout time.Duration
+}
+
+func newSimpleStoreHandler(store EventStore, option *StoreHandlerOption) *simpleStoreHandler {
+	return &simpleStoreHandler{
+		store:        store,
+		sema:         make(chan struct{}, option.maxConcurrentQueries()),
+		queryTimeout: option.queryTimeout(),
+	}
+}
+
+func (h *simpleStoreHandler) HandleStart(r *http.Request) (*http.Request, error) {
+	return r, nil
+}
+
+func (h *simpleStoreHandler) HandleStop(r *http.Request) error {
+	return nil
+}
+
+// acquire takes a query slot and returns the context of the query.
+func (h *simpleStoreHandler) acquire(
+	ctx context.Context,
+) (context.Context, context.CancelFunc, error) {
+	cancel := context.CancelFunc(func() {})
+	if h.queryTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, h.queryTimeout)
+	}
+
+	select {
+	case h.sema <- struct{}{}:
+		return ctx, func() { cancel(); <-h.sema }, nil
+	case <-ctx.Done():
+		cancel()
+		return nil, nil, ctx.Err()
+	}
+}
+
+func (h *simpleStoreHandler) HandleClientMsg(
+	r *http.Request,
+	msg ClientMsg,
+) (<-chan ServerMsg, error) {
+	switch msg := msg.(type) {
+	case *ClientEventMsg:
+		return newClosedBufCh[ServerMsg](h.save(r.Context(), msg.Event)), nil
+
+	case *ClientReqMsg:
+		var evs []*Event
+		ctx, release, err := h.acquire(r.Context())
+		if err == nil {
+			start := time.Now()
+			evs, err = h.store.Query(ctx, msg.ReqFilters)
+			observeQuery(ctx, msg.SubscriptionID, time.Since(start))
+			release()
+		}
+
+		smsgCh := make(chan ServerMsg, len(evs)+2)
+		defer close(smsgCh)
+
+		for _, ev := range evs {
+			smsgCh <- NewServerEventMsg(msg.SubscriptionID, ev)
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			smsgCh <- NewServerNoticeMsgf("query timeout: %s: results may be partial", msg.SubscriptionID)
+		} else if err != nil {
+			smsgCh <- NewServerNoticeMsgf("failed to query: %s", msg.SubscriptionID)
+		}
+		smsgCh <- NewServerEOSEMsg(msg.SubscriptionID)
+		return smsgCh, nil
+
+	case *ClientCountMsg:
+		var n uint64
+		ctx, release, err := h.acquire(r.Context())
+		if err == nil {
+			n, err = h.store.Count(ctx, msg.ReqFilters)
+			release()
+		}
+
+		var approx *bool
+		if err != nil {
+			v := true
+			approx = &v
+		}
+		return newClosedBufCh[ServerMsg](NewServerCountMsg(msg.SubscriptionID, n, approx)), nil
+
+	default:
+		return nil, nil
+	}
+}
+
+func (h *simpleStoreHandler) save(ctx context.Context, event *Event) *ServerOKMsg {
+	if event.Kind == 5 {
+		if err := h.store.Delete(ctx, event); err != nil {
+			return okMsgFromError(event.ID, err, ServerOkMsgPrefixError, "failed to delete events")
+		}
+	}
+
+	saved, err := h.store.Save(ctx, event)
+	if err != nil {
+		return okMsgFromError(event.ID, err, ServerOkMsgPrefixError, "failed to save event")
+	}
+	if !saved {
+		return NewServerOKMsg(
+			event.ID,
+			false,
+			ServerOKMsgPrefixDuplicate,
+			"already have this event",
+		)
+	}
+	return NewServerOKMsg(event.ID, true, ServerOKMsgPrefixNoPrefix, "")
+}
@@ -0,0 +1,214 @@
+// Package migrate applies versioned SQL migrations to SQL event stores.
+//
+// Migrations are files named like "0001_init.sql" in an fs.FS, usually an embed.FS
+// in the store package. Statements in a file are separated by ";" at the end of a line.
+// Applied versions are recorded in a table so each migration runs only once.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrUnknownVersion = errors.New("database schema is newer than this binary")
+
+var fileNameRegexp = regexp.MustCompile(`^(\d+)_(\w+)\.sql$`)
+
+var statementSepRegexp = regexp.MustCompile(`;[ \t]*(\r?\n|$)`)
+
+type Migration struct {
+	Version    int
+	Name       string
+	Statements []string
+}
+
+// Load reads migrations in the root of fsys in ascending order of versions.
+func Load(fsys fs.FS) ([]*Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	var ret []*Migration
+	for _, entry := range entries {
+		if entry.IsDir() || path.Ext(entry.Name()) != ".sql" {
+			continue
+		}
+
+		match := fileNameRegexp.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name: %s", entry.Name())
+		}
+		version, err := strconv.Atoi(match[1])
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid migration version: %s", entry.Name())
+		}
+
+		b, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration: %w", err)
+		}
+
+		ret = append(ret, &Migration{
+			Version:    version,
+			Name:       match[2],
+			Statements: splitStatements(string(b)),
+		})
+	}
+
+	slices.SortFunc(ret, func(a, b *Migration) int { return a.Version - b.Version })
+	for i := 1; i < len(ret); i++ {
+		if ret[i-1].Version == ret[i].Version {
+			return nil, fmt.Errorf("duplicate migration version: %d", ret[i].Version)
+		}
+	}
+
+	return ret, nil
+}
+
+func splitStatements(s string) []string {
+	var ret []string
+	for _, stmt := range statementSepRegexp.Split(s, -1) {
+		if stmt = strings.TrimSpace(stmt); stmt != "" {
+			ret = append(ret, stmt)
+		}
+	}
+	return ret
+}
+
+type Option struct {
+	// Table records applied versions. The default is "schema_migrations".
+	Table string
+}
+
+func (opt *Option) table() string {
+	if opt == nil || opt.Table == "" {
+		return "schema_migrations"
+	}
+	return opt.Table
+}
+
+// Migrator applies migrations to a database.
+// Only one Migrator should run against a database at a time.
+type Migrator struct {
+	db         *sql.DB
+	migrations []*Migration
+	opt        *Option
+}
+
+func New(db *sql.DB, fsys fs.FS, option *Option) (*Migrator, error) {
+	if db == nil {
+		panic("db must be non-nil pointer")
+	}
+
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{db: db, migrations: migrations, opt: option}, nil
+}
+
+// Latest returns the version of the last migration or zero if there are none.
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Version returns the current schema version. Zero means no migrations are applied.
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	if err := m.init(ctx); err != nil {
+		return 0, err
+	}
+
+	var version sql.NullInt64
+	q := fmt.Sprintf("SELECT MAX(version) FROM %s", m.opt.table())
+	if err := m.db.QueryRowContext(ctx, q).Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to get schema version: %w", err)
+	}
+	return int(version.Int64), nil
+}
+
+func (m *Migrator) init(ctx context.Context) error {
+	stmt := fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS %s (version BIGINT NOT NULL PRIMARY KEY, applied_at BIGINT NOT NULL)",
+		m.opt.table(),
+	)
+	if _, err := m.db.ExecContext(ctx, stmt); err != nil {
+		return fmt.Errorf("failed to create %s: %w", m.opt.table(), err)
+	}
+	return nil
+}
+
+// Migrate applies pending migrations in order and returns the new schema version.
+// It refuses to run against a schema newer than the migrations it knows.
+//
+// Each migration runs in a transaction, but DDL is not transactional on some databases
+// such as MySQL, so statements should be idempotent (e.g. CREATE TABLE IF NOT EXISTS).
+func (m *Migrator) Migrate(ctx context.Context) (int, error) {
+	current, err := m.Version(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if current > m.Latest() {
+		return current, fmt.Errorf(
+			"%w: version %d > %d",
+			ErrUnknownVersion,
+			current,
+			m.Latest(),
+		)
+	}
+
+	for _, mig := range m.migrations {
+		if mig.Version <= current {
+			continue
+		}
+		if err := m.apply(ctx, mig); err != nil {
+			return current, err
+		}
+		current = mig.Version
+	}
+
+	return current, nil
+}
+
+func (m *Migrator) apply(ctx context.Context, mig *Migration) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range mig.Statements {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to apply migration %d_%s: %w", mig.Version, mig.Name, err)
+		}
+	}
+
+	// Values are formatted inline since placeholders differ between databases.
+	stmt := fmt.Sprintf(
+		"INSERT INTO %s (version, applied_at) VALUES (%d, %d)",
+		m.opt.table(),
+		mig.Version,
+		time.Now().Unix(),
+	)
+	if _, err := tx.ExecContext(ctx, stmt); err != nil {
+		return fmt.Errorf("failed to record migration %d_%s: %w", mig.Version, mig.Name, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit migration %d_%s: %w", mig.Version, mig.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package migrate
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		fsys    fstest.MapFS
+		want    []*Migration
+		wantErr bool
+	}{
+		{
+			name: "ok",
+			fsys: fstest.MapFS{
+				"0002_index.sql": {Data: []byte("CREATE INDEX a ON t (a);\n")},
+				"0001_init.sql": {
+					Data: []byte("CREATE TABLE t (a INT);\r\n\nINSERT INTO t VALUES (1);  \n"),
+				},
+				"README.md": {Data: []byte("doc")},
+			},
+			want: []*Migration{
+				{
+					Version:    1,
+					Name:       "init",
+					Statements: []string{"CREATE TABLE t (a INT)", "INSERT INTO t VALUES (1)"},
+				},
+				{Version: 2, Name: "index", Statements: []string{"CREATE INDEX a ON t (a)"}},
+			},
+		},
+		{
+			name: "empty",
+			fsys: fstest.MapFS{},
+		},
+		{
+			name:    "invalid name",
+			fsys:    fstest.MapFS{"init.sql": {Data: []byte("SELECT 1")}},
+			wantErr: true,
+		},
+		{
+			name:    "zero version",
+			fsys:    fstest.MapFS{"0000_init.sql": {Data: []byte("SELECT 1")}},
+			wantErr: true,
+		},
+		{
+			name: "duplicate version",
+			fsys: fstest.MapFS{
+				"0001_a.sql": {Data: []byte("SELECT 1")},
+				"1_b.sql":    {Data: []byte("SELECT 1")},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Load(tt.fsys)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want []string
+	}{
+		{
+			name: "multi line",
+			in:   "CREATE TABLE t (\n\ta INT,\n\tb TEXT\n);\n\nSELECT 1;",
+			want: []string{"CREATE TABLE t (\n\ta INT,\n\tb TEXT\n)", "SELECT 1"},
+		},
+		{
+			name: "semicolon in line",
+			in:   "INSERT INTO t VALUES ('a;b');\n",
+			want: []string{"INSERT INTO t VALUES ('a;b')"},
+		},
+		{
+			name: "empty",
+			in:   "\n\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, splitStatements(tt.in))
+		})
+	}
+}
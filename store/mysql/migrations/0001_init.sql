@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS events (
+	id CHAR(64) CHARACTER SET ascii COLLATE ascii_bin NOT NULL,
+	pubkey CHAR(64) CHARACTER SET ascii COLLATE ascii_bin NOT NULL,
+	created_at BIGINT NOT NULL,
+	kind BIGINT NOT NULL,
+	replace_key CHAR(64) CHARACTER SET ascii COLLATE ascii_bin NULL,
+	raw MEDIUMTEXT CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL,
+	PRIMARY KEY (id),
+	UNIQUE KEY events_replace_key (replace_key),
+	KEY events_pubkey_kind_created_at (pubkey, kind, created_at),
+	KEY events_kind_created_at (kind, created_at),
+	KEY events_created_at (created_at)
+) ENGINE=InnoDB;
+
+CREATE TABLE IF NOT EXISTS event_tags (
+	event_id CHAR(64) CHARACTER SET ascii COLLATE ascii_bin NOT NULL,
+	name CHAR(1) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL,
+	value VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL,
+	created_at BIGINT NOT NULL,
+	PRIMARY KEY (event_id, name, value),
+	KEY event_tags_name_value_created_at (name, value, created_at),
+	CONSTRAINT event_tags_event_id FOREIGN KEY (event_id)
+		REFERENCES events (id) ON DELETE CASCADE
+) ENGINE=InnoDB;
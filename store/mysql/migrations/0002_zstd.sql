@@ -0,0 +1,8 @@
+ALTER TABLE events MODIFY raw MEDIUMBLOB NOT NULL;
+
+CREATE TABLE IF NOT EXISTS zstd_dicts (
+	id INT UNSIGNED NOT NULL,
+	dict MEDIUMBLOB NOT NULL,
+	created_at BIGINT NOT NULL,
+	PRIMARY KEY (id)
+) ENGINE=InnoDB;
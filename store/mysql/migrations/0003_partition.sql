@@ -0,0 +1,19 @@
+ALTER TABLE event_tags DROP FOREIGN KEY event_tags_event_id;
+
+ALTER TABLE event_tags
+	DROP PRIMARY KEY,
+	ADD PRIMARY KEY (event_id, name, value, created_at);
+
+ALTER TABLE events
+	DROP PRIMARY KEY,
+	ADD PRIMARY KEY (id, created_at),
+	DROP INDEX events_replace_key,
+	ADD KEY events_replace_key (replace_key);
+
+ALTER TABLE events PARTITION BY RANGE (created_at) (
+	PARTITION pmax VALUES LESS THAN MAXVALUE
+);
+
+ALTER TABLE event_tags PARTITION BY RANGE (created_at) (
+	PARTITION pmax VALUES LESS THAN MAXVALUE
+);
@@ -0,0 +1 @@
+ALTER TABLE event_tags MODIFY name VARCHAR(64) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL;
@@ -0,0 +1,19 @@
+ALTER TABLE events
+	ADD COLUMN namespace VARCHAR(64) CHARACTER SET ascii COLLATE ascii_bin NOT NULL DEFAULT '' FIRST,
+	DROP PRIMARY KEY,
+	ADD PRIMARY KEY (namespace, id, created_at),
+	DROP INDEX events_replace_key,
+	ADD KEY events_replace_key (namespace, replace_key),
+	DROP INDEX events_pubkey_kind_created_at,
+	ADD KEY events_pubkey_kind_created_at (namespace, pubkey, kind, created_at),
+	DROP INDEX events_kind_created_at,
+	ADD KEY events_kind_created_at (namespace, kind, created_at),
+	DROP INDEX events_created_at,
+	ADD KEY events_created_at (namespace, created_at);
+
+ALTER TABLE event_tags
+	ADD COLUMN namespace VARCHAR(64) CHARACTER SET ascii COLLATE ascii_bin NOT NULL DEFAULT '' FIRST,
+	DROP PRIMARY KEY,
+	ADD PRIMARY KEY (namespace, event_id, name, value, created_at),
+	DROP INDEX event_tags_name_value_created_at,
+	ADD KEY event_tags_name_value_created_at (namespace, name, value, created_at);
@@ -0,0 +1,633 @@
+// Package mysql is a mocrelay.EventStore on MySQL and MariaDB.
+//
+// It works on any *sql.DB opened with a MySQL driver such as github.com/go-sql-driver/mysql.
+// Single-letter tags, or the ones matching Option.TagNamePattern, are indexed in their own
+// table for tag filters.
+// Event blobs are compressed with zstd, optionally with a dictionary trained by TrainDict.
+// Tables are partitioned by created_at, and retention drops whole partitions (see MaintainPartitions).
+package mysql
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/high-moctane/mocrelay"
+	"github.com/high-moctane/mocrelay/store/migrate"
+)
+
+// MaxTagValueLength is the max length of indexed tag values.
+// Longer values are not indexed and never match tag filters.
+const MaxTagValueLength = 255
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// Migrations returns the schema migrations for package migrate.
+func Migrations() fs.FS {
+	sub, err := fs.Sub(migrations, "migrations")
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
+
+type Option struct {
+	// DefaultLimit is the limit of filters without limit. The default is 500.
+	DefaultLimit int64
+	// DisableCompression stores new events as plain JSON instead of zstd.
+	// Compressed events are still readable.
+	DisableCompression bool
+
+	// PartitionWindow is the time range of created_at in a partition. The default is 7 days.
+	PartitionWindow time.Duration
+	// Retention drops partitions whose events are all older than it. Zero keeps events forever.
+	Retention time.Duration
+
+	// IDMatchMode is how the ids and authors of filters match events.
+	// With mocrelay.IDMatchPrefix, values shorter than 64 chars match by prefix.
+	IDMatchMode mocrelay.IDMatchMode
+	// TagNamePattern matches the tag names indexed for tag filters.
+	// It should be the same as the one of the relay. The default is mocrelay.StrictTagNamePattern.
+	TagNamePattern *regexp.Regexp
+}
+
+func (opt *Option) compression() bool {
+	return opt == nil || !opt.DisableCompression
+}
+
+func (opt *Option) partitionWindow() time.Duration {
+	if opt == nil || opt.PartitionWindow == 0 {
+		return 7 * 24 * time.Hour
+	}
+	return opt.PartitionWindow
+}
+
+func (opt *Option) retention() time.Duration {
+	if opt == nil {
+		return 0
+	}
+	return opt.Retention
+}
+
+func (opt *Option) idMatchMode() mocrelay.IDMatchMode {
+	if opt == nil {
+		return mocrelay.IDMatchExact
+	}
+	return opt.IDMatchMode
+}
+
+func (opt *Option) tagNamePattern() *regexp.Regexp {
+	if opt == nil || opt.TagNamePattern == nil {
+		return mocrelay.StrictTagNamePattern
+	}
+	return opt.TagNamePattern
+}
+
+func (opt *Option) defaultLimit() int64 {
+	if opt == nil || opt.DefaultLimit == 0 {
+		return 500
+	}
+	return opt.DefaultLimit
+}
+
+var _ mocrelay.EventStore = (*Store)(nil)
+
+// Store is a mocrelay.EventStore on MySQL. Stores of the namespaces share the tables
+// and the connections.
+type Store struct {
+	db    *sql.DB
+	opt   *Option
+	codec *codec
+	ns    string
+}
+
+func New(db *sql.DB, option *Option) *Store {
+	if db == nil {
+		panic("db must be non-nil pointer")
+	}
+	return &Store{db: db, opt: option, codec: newCodec()}
+}
+
+// Namespace returns the Store of ns, which must be at most 64 ASCII characters.
+func (s *Store) Namespace(ns string) mocrelay.EventStore {
+	ret := *s
+	ret.ns = ns
+	return &ret
+}
+
+// Migrate applies pending schema migrations and loads zstd dictionaries.
+func (s *Store) Migrate(ctx context.Context) error {
+	m, err := migrate.New(s.db, Migrations(), nil)
+	if err != nil {
+		return err
+	}
+	if _, err := m.Migrate(ctx); err != nil {
+		return fmt.Errorf("failed to migrate: %w", err)
+	}
+	return s.loadDicts(ctx)
+}
+
+// replaceKey returns the hashed key of replaceable events or false for the others.
+func replaceKey(event *mocrelay.Event) (string, bool) {
+	var key string
+
+	switch event.EventType() {
+	case mocrelay.EventTypeRegular:
+		return "", true
+
+	case mocrelay.EventTypeReplaceable:
+		key = fmt.Sprintf("%s:%d", event.Pubkey, event.Kind)
+
+	case mocrelay.EventTypeParamReplaceable:
+		d, ok := event.Tags.DTag()
+		if !ok {
+			return "", false
+		}
+		key = fmt.Sprintf("%s:%d:%s", event.Pubkey, event.Kind, d)
+
+	default:
+		return "", false
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:]), true
+}
+
+func (s *Store) Save(ctx context.Context, event *mocrelay.Event) (bool, error) {
+	key, ok := replaceKey(event)
+	if !ok {
+		return false, nil
+	}
+
+	raw, err := event.MarshalRaw()
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal event: %w", err)
+	}
+	if s.opt.compression() {
+		raw = s.codec.encode(raw)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	var replaceKey sql.NullString
+	if key != "" {
+		replaceKey = sql.NullString{String: key, Valid: true}
+
+		var oldID string
+		var oldCreatedAt int64
+		err := tx.QueryRowContext(
+			ctx,
+			"SELECT id, created_at FROM events WHERE namespace = ? AND replace_key = ? FOR UPDATE",
+			s.ns,
+			key,
+		).Scan(&oldID, &oldCreatedAt)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+		case err != nil:
+			return false, fmt.Errorf("failed to find replaceable event: %w", err)
+		case mocrelay.ResolveReplaceable(
+			&mocrelay.Event{ID: oldID, CreatedAt: oldCreatedAt},
+			event,
+		) == mocrelay.KeepExisting:
+			return false, nil
+		default:
+			if _, err := deleteEvents(ctx, tx, s.ns, "id = ?", oldID); err != nil {
+				return false, fmt.Errorf("failed to delete replaced event: %w", err)
+			}
+		}
+	}
+
+	res, err := tx.ExecContext(
+		ctx,
+		"INSERT IGNORE INTO events (namespace, id, pubkey, created_at, kind, replace_key, raw) "+
+			"VALUES (?, ?, ?, ?, ?, ?, ?)",
+		s.ns,
+		event.ID,
+		event.Pubkey,
+		event.CreatedAt,
+		event.Kind,
+		replaceKey,
+		raw,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to insert event: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return false, fmt.Errorf("failed to insert event: %w", err)
+	} else if n == 0 {
+		return false, nil
+	}
+
+	if query, args := buildInsertTags(s.ns, event, s.opt.tagNamePattern()); query != "" {
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return false, fmt.Errorf("failed to insert tags: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit: %w", err)
+	}
+	return true, nil
+}
+
+func buildInsertTags(ns string, event *mocrelay.Event, names *regexp.Regexp) (string, []any) {
+	type tag struct{ name, value string }
+	seen := make(map[tag]bool)
+
+	var values []string
+	var args []any
+	for _, t := range event.Tags {
+		if len(t) < 2 || !names.MatchString(t[0]) {
+			continue
+		}
+		v := tagValue(t[0], t[1])
+		if len(v) > MaxTagValueLength || seen[tag{t[0], v}] {
+			continue
+		}
+		seen[tag{t[0], v}] = true
+
+		values = append(values, "(?, ?, ?, ?, ?)")
+		args = append(args, ns, event.ID, t[0], v, event.CreatedAt)
+	}
+	if len(values) == 0 {
+		return "", nil
+	}
+
+	query := "INSERT IGNORE INTO event_tags (namespace, event_id, name, value, created_at) VALUES " +
+		strings.Join(
+			values,
+			", ",
+		)
+	return query, args
+}
+
+// tagValue returns the indexed form of the value of tag name.
+// Addresses of "a" tags are normalized to match filters component-wise.
+func tagValue(name, value string) string {
+	if name != "a" {
+		return value
+	}
+	addr, err := mocrelay.ParseNaddr(value)
+	if err != nil {
+		return value
+	}
+	return addr.Naddr()
+}
+
+func (s *Store) Delete(ctx context.Context, deletion *mocrelay.Event) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, tag := range deletion.Tags {
+		if len(tag) < 2 {
+			continue
+		}
+
+		switch tag[0] {
+		case "e":
+			_, err := deleteEvents(ctx, tx, s.ns, "id = ? AND pubkey = ?", tag[1], deletion.Pubkey)
+			if err != nil {
+				return fmt.Errorf("failed to delete event: %w", err)
+			}
+
+		case "a":
+			key, ok := naddrReplaceKey(tag[1], deletion.Pubkey)
+			if !ok {
+				continue
+			}
+			_, err := deleteEvents(
+				ctx,
+				tx,
+				s.ns,
+				"replace_key = ? AND created_at <= ?",
+				key,
+				deletion.CreatedAt,
+			)
+			if err != nil {
+				return fmt.Errorf("failed to delete event: %w", err)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit: %w", err)
+	}
+	return nil
+}
+
+// deleteEvents deletes the events of ns matching where and their tags,
+// which are not deleted by foreign keys since partitioned tables cannot have them.
+func deleteEvents(
+	ctx context.Context,
+	tx *sql.Tx,
+	ns string,
+	where string,
+	args ...any,
+) (int64, error) {
+	where = "namespace = ? AND " + where
+	args = append([]any{ns}, args...)
+
+	_, err := tx.ExecContext(
+		ctx,
+		"DELETE FROM event_tags WHERE namespace = ? AND event_id IN "+
+			"(SELECT id FROM events WHERE "+where+")",
+		append([]any{ns}, args...)...,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM events WHERE "+where, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// naddrReplaceKey returns the replace key of "<kind>:<pubkey>:<d>"
+// if it is authored by pubkey.
+func naddrReplaceKey(naddr, pubkey string) (string, bool) {
+	addr, err := mocrelay.ParseNaddr(naddr)
+	if err != nil || addr.Pubkey != pubkey {
+		return "", false
+	}
+
+	return replaceKey(&mocrelay.Event{
+		Pubkey: pubkey,
+		Kind:   addr.Kind,
+		Tags:   []mocrelay.Tag{{"d", addr.Identifier}},
+	})
+}
+
+func (s *Store) PurgePubkey(ctx context.Context, pubkey string) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	n, err := deleteEvents(ctx, tx, s.ns, "pubkey = ?", pubkey)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge pubkey: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit: %w", err)
+	}
+	return int(n), nil
+}
+
+func (s *Store) Query(
+	ctx context.Context,
+	filters []*mocrelay.ReqFilter,
+) ([]*mocrelay.Event, error) {
+	var ret []*mocrelay.Event
+	seen := make(map[string]bool)
+
+	for _, f := range filters {
+		query, args, ok := buildQuery(s.ns, f, s.opt.defaultLimit(), s.opt.idMatchMode())
+		if !ok {
+			continue
+		}
+
+		evs, err := s.query(ctx, query, args)
+		if err != nil {
+			return nil, err
+		}
+		for _, ev := range evs {
+			if !seen[ev.ID] {
+				seen[ev.ID] = true
+				ret = append(ret, ev)
+			}
+		}
+	}
+
+	slices.SortStableFunc(ret, func(a, b *mocrelay.Event) int {
+		return cmp.Compare(b.CreatedAt, a.CreatedAt)
+	})
+	return ret, nil
+}
+
+func (s *Store) query(ctx context.Context, query string, args []any) ([]*mocrelay.Event, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query events: %w", err)
+	}
+	defer rows.Close()
+
+	var ret []*mocrelay.Event
+	for rows.Next() {
+		var b []byte
+		if err := rows.Scan(&b); err != nil {
+			return nil, fmt.Errorf("failed to scan event: %w", err)
+		}
+		raw, err := s.decode(ctx, b)
+		if err != nil {
+			return nil, err
+		}
+		ev := new(mocrelay.Event)
+		if err := json.Unmarshal(raw, ev); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal event: %w", err)
+		}
+		ret = append(ret, ev)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query events: %w", err)
+	}
+	return ret, nil
+}
+
+func (s *Store) Count(ctx context.Context, filters []*mocrelay.ReqFilter) (uint64, error) {
+	query, args, ok := buildCount(s.ns, filters, s.opt.idMatchMode())
+	if !ok {
+		return 0, nil
+	}
+
+	var n uint64
+	if err := s.db.QueryRowContext(ctx, query, args...).Scan(&n); err != nil {
+		return 0, fmt.Errorf("failed to count events: %w", err)
+	}
+	return n, nil
+}
+
+// buildQuery returns the SELECT statement of f in ns or false if f matches nothing.
+func buildQuery(
+	ns string,
+	f *mocrelay.ReqFilter,
+	defaultLimit int64,
+	mode mocrelay.IDMatchMode,
+) (string, []any, bool) {
+	limit := defaultLimit
+	if f.Limit != nil {
+		limit = min(*f.Limit, defaultLimit)
+	}
+	if limit <= 0 {
+		return "", nil, false
+	}
+
+	where, args, ok := buildWhere(ns, f, mode)
+	if !ok {
+		return "", nil, false
+	}
+
+	query := "SELECT raw FROM events WHERE namespace = ?"
+	if where != "" {
+		query += " AND " + where
+	}
+	query += " ORDER BY created_at DESC, id ASC LIMIT ?"
+	args = append([]any{ns}, args...)
+	return query, append(args, limit), true
+}
+
+// buildCount returns the COUNT statement of filters in ns or false if they match nothing.
+// Limits are ignored.
+func buildCount(
+	ns string,
+	filters []*mocrelay.ReqFilter,
+	mode mocrelay.IDMatchMode,
+) (string, []any, bool) {
+	var wheres []string
+	var args []any
+	for _, f := range filters {
+		where, a, ok := buildWhere(ns, f, mode)
+		if !ok {
+			continue
+		}
+		if where == "" {
+			return "SELECT COUNT(*) FROM events WHERE namespace = ?", []any{ns}, true
+		}
+		wheres = append(wheres, "("+where+")")
+		args = append(args, a...)
+	}
+	if len(wheres) == 0 {
+		return "", nil, false
+	}
+
+	query := "SELECT COUNT(*) FROM events WHERE namespace = ? AND (" +
+		strings.Join(wheres, " OR ") + ")"
+	return query, append([]any{ns}, args...), true
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// buildWhere returns the conditions of f or false if f matches nothing.
+// An empty string means f matches everything. The namespace is only applied to tags.
+func buildWhere(ns string, f *mocrelay.ReqFilter, mode mocrelay.IDMatchMode) (string, []any, bool) {
+	var conds []string
+	var args []any
+
+	in := func(column string, n int) string {
+		return column + " IN (" + strings.TrimSuffix(strings.Repeat("?, ", n), ", ") + ")"
+	}
+
+	var timeConds []string
+	var timeArgs []any
+	if f.Since != nil {
+		timeConds = append(timeConds, "created_at >= ?")
+		timeArgs = append(timeArgs, *f.Since)
+	}
+	if f.Until != nil {
+		timeConds = append(timeConds, "created_at <= ?")
+		timeArgs = append(timeArgs, *f.Until)
+	}
+
+	// idCond returns the condition of ids or authors, which may be prefixes in IDMatchPrefix.
+	idCond := func(column string, values []string) (string, []any) {
+		var exact, prefixes []any
+		for _, v := range values {
+			if mode == mocrelay.IDMatchPrefix && len(v) < 64 {
+				prefixes = append(prefixes, likeEscaper.Replace(v)+"%")
+			} else {
+				exact = append(exact, v)
+			}
+		}
+
+		var ors []string
+		if len(exact) > 0 {
+			ors = append(ors, in(column, len(exact)))
+		}
+		for range prefixes {
+			ors = append(ors, column+" LIKE ?")
+		}
+		if len(ors) == 1 {
+			return ors[0], append(exact, prefixes...)
+		}
+		return "(" + strings.Join(ors, " OR ") + ")", append(exact, prefixes...)
+	}
+
+	if f.IDs != nil {
+		if len(f.IDs) == 0 {
+			return "", nil, false
+		}
+		cond, a := idCond("id", f.IDs)
+		conds = append(conds, cond)
+		args = append(args, a...)
+	}
+
+	if f.Authors != nil {
+		if len(f.Authors) == 0 {
+			return "", nil, false
+		}
+		cond, a := idCond("pubkey", f.Authors)
+		conds = append(conds, cond)
+		args = append(args, a...)
+	}
+
+	if f.Kinds != nil {
+		if len(f.Kinds) == 0 {
+			return "", nil, false
+		}
+		conds = append(conds, in("kind", len(f.Kinds)))
+		for _, kind := range f.Kinds {
+			args = append(args, kind)
+		}
+	}
+
+	names := make([]string, 0, len(f.Tags))
+	for name := range f.Tags {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		values := f.Tags[name]
+		if len(name) < 2 || len(values) == 0 {
+			return "", nil, false
+		}
+		// The time range is repeated in the subquery to prune partitions of event_tags.
+		sub := "SELECT event_id FROM event_tags WHERE namespace = ? AND name = ? AND " +
+			in("value", len(values))
+		if len(timeConds) > 0 {
+			sub += " AND " + strings.Join(timeConds, " AND ")
+		}
+		conds = append(conds, "id IN ("+sub+")")
+		args = append(args, ns, name[1:])
+		for _, v := range values {
+			args = append(args, tagValue(name[1:], v))
+		}
+		args = append(args, timeArgs...)
+	}
+
+	conds = append(conds, timeConds...)
+	args = append(args, timeArgs...)
+
+	return strings.Join(conds, " AND "), args, true
+}
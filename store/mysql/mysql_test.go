@@ -0,0 +1,245 @@
+package mysql
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+
+	"github.com/high-moctane/mocrelay"
+	"github.com/high-moctane/mocrelay/store/migrate"
+	"github.com/stretchr/testify/assert"
+)
+
+func toPtr[T any](v T) *T { return &v }
+
+func TestBuildQuery(t *testing.T) {
+	tests := []struct {
+		name     string
+		filter   *mocrelay.ReqFilter
+		wantSQL  string
+		wantArgs []any
+		wantOK   bool
+	}{
+		{
+			name:     "empty",
+			filter:   &mocrelay.ReqFilter{},
+			wantSQL:  "SELECT raw FROM events WHERE namespace = ? ORDER BY created_at DESC, id ASC LIMIT ?",
+			wantArgs: []any{"ns", int64(500)},
+			wantOK:   true,
+		},
+		{
+			name: "all",
+			filter: &mocrelay.ReqFilter{
+				IDs:     []string{"id0", "id1"},
+				Authors: []string{"pub"},
+				Kinds:   []int64{1, 7},
+				Tags:    map[string][]string{"#p": {"p0"}, "#e": {"e0", "e1"}},
+				Since:   toPtr(int64(10)),
+				Until:   toPtr(int64(20)),
+				Limit:   toPtr(int64(5)),
+			},
+			wantSQL: "SELECT raw FROM events WHERE namespace = ? AND " +
+				"id IN (?, ?) AND pubkey IN (?) AND kind IN (?, ?) AND " +
+				"id IN (SELECT event_id FROM event_tags WHERE namespace = ? AND name = ? AND " +
+				"value IN (?, ?) AND created_at >= ? AND created_at <= ?) AND " +
+				"id IN (SELECT event_id FROM event_tags WHERE namespace = ? AND name = ? AND " +
+				"value IN (?) AND " +
+				"created_at >= ? AND created_at <= ?) AND " +
+				"created_at >= ? AND created_at <= ? ORDER BY created_at DESC, id ASC LIMIT ?",
+			wantArgs: []any{
+				"ns",
+				"id0", "id1", "pub", int64(1), int64(7),
+				"ns", "e", "e0", "e1", int64(10), int64(20),
+				"ns", "p", "p0", int64(10), int64(20),
+				int64(10), int64(20), int64(5),
+			},
+			wantOK: true,
+		},
+		{
+			name:     "limit is capped",
+			filter:   &mocrelay.ReqFilter{Limit: toPtr(int64(10000))},
+			wantSQL:  "SELECT raw FROM events WHERE namespace = ? ORDER BY created_at DESC, id ASC LIMIT ?",
+			wantArgs: []any{"ns", int64(500)},
+			wantOK:   true,
+		},
+		{
+			name:   "zero limit",
+			filter: &mocrelay.ReqFilter{Limit: toPtr(int64(0))},
+			wantOK: false,
+		},
+		{
+			name:   "empty ids",
+			filter: &mocrelay.ReqFilter{IDs: []string{}},
+			wantOK: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sql, args, ok := buildQuery("ns", tt.filter, 500, mocrelay.IDMatchExact)
+			assert.Equal(t, tt.wantOK, ok)
+			if !ok {
+				return
+			}
+			assert.Equal(t, tt.wantSQL, sql)
+			assert.Equal(t, tt.wantArgs, args)
+		})
+	}
+}
+
+func TestBuildCount(t *testing.T) {
+	sql, args, ok := buildCount("ns", []*mocrelay.ReqFilter{
+		{Kinds: []int64{1}, Limit: toPtr(int64(1))},
+		{IDs: []string{}},
+		{Authors: []string{"pub"}},
+	}, mocrelay.IDMatchExact)
+	assert.True(t, ok)
+	assert.Equal(
+		t,
+		"SELECT COUNT(*) FROM events WHERE namespace = ? AND ((kind IN (?)) OR (pubkey IN (?)))",
+		sql,
+	)
+	assert.Equal(t, []any{"ns", int64(1), "pub"}, args)
+
+	sql, args, ok = buildCount(
+		"ns",
+		[]*mocrelay.ReqFilter{{Kinds: []int64{1}}, {}},
+		mocrelay.IDMatchExact,
+	)
+	assert.True(t, ok)
+	assert.Equal(t, "SELECT COUNT(*) FROM events WHERE namespace = ?", sql)
+	assert.Equal(t, []any{"ns"}, args)
+
+	_, _, ok = buildCount("ns", []*mocrelay.ReqFilter{{IDs: []string{}}}, mocrelay.IDMatchExact)
+	assert.False(t, ok)
+}
+
+func TestBuildQuery_prefix(t *testing.T) {
+	id := strings.Repeat("a", 64)
+	f := &mocrelay.ReqFilter{IDs: []string{id, "ab"}, Authors: []string{"c_"}}
+
+	sql, args, ok := buildQuery("", f, 500, mocrelay.IDMatchPrefix)
+	assert.True(t, ok)
+	assert.Equal(
+		t,
+		"SELECT raw FROM events WHERE namespace = ? AND (id IN (?) OR id LIKE ?) AND pubkey LIKE ? "+
+			"ORDER BY created_at DESC, id ASC LIMIT ?",
+		sql,
+	)
+	assert.Equal(t, []any{"", id, "ab%", `c\_%`, int64(500)}, args)
+
+	// Short values match nothing in the exact mode.
+	sql, args, ok = buildQuery("", f, 500, mocrelay.IDMatchExact)
+	assert.True(t, ok)
+	assert.Equal(
+		t,
+		"SELECT raw FROM events WHERE namespace = ? AND id IN (?, ?) AND pubkey IN (?) "+
+			"ORDER BY created_at DESC, id ASC LIMIT ?",
+		sql,
+	)
+	assert.Equal(t, []any{"", id, "ab", "c_", int64(500)}, args)
+}
+
+func TestBuildInsertTags(t *testing.T) {
+	event := &mocrelay.Event{
+		ID:        "id",
+		CreatedAt: 1,
+		Tags: []mocrelay.Tag{
+			{"e", "e0"},
+			{"e", "e0"},
+			{"p"},
+			{"expiration", "100"},
+			{"t", "nostr"},
+		},
+	}
+	sql, args := buildInsertTags("ns", event, mocrelay.StrictTagNamePattern)
+	assert.Equal(
+		t,
+		"INSERT IGNORE INTO event_tags (namespace, event_id, name, value, created_at) "+
+			"VALUES (?, ?, ?, ?, ?), (?, ?, ?, ?, ?)",
+		sql,
+	)
+	assert.Equal(
+		t,
+		[]any{"ns", "id", "e", "e0", int64(1), "ns", "id", "t", "nostr", int64(1)},
+		args,
+	)
+
+	_, args = buildInsertTags("ns", event, mocrelay.AnyTagNamePattern)
+	assert.Equal(t, []any{
+		"ns", "id", "e", "e0", int64(1),
+		"ns", "id", "expiration", "100", int64(1),
+		"ns", "id", "t", "nostr", int64(1),
+	}, args)
+
+	sql, _ = buildInsertTags(
+		"ns",
+		&mocrelay.Event{Tags: []mocrelay.Tag{}},
+		mocrelay.StrictTagNamePattern,
+	)
+	assert.Empty(t, sql)
+}
+
+func TestTagValue(t *testing.T) {
+	pub := strings.Repeat("a", 64)
+	assert.Equal(t, "30023:"+pub+":x:y", tagValue("a", "030023:"+pub+":x:y"))
+	assert.Equal(t, "invalid", tagValue("a", "invalid"))
+	assert.Equal(t, "030023", tagValue("t", "030023"))
+}
+
+func TestReplaceKey(t *testing.T) {
+	key, ok := replaceKey(&mocrelay.Event{Kind: 1})
+	assert.True(t, ok)
+	assert.Empty(t, key)
+
+	_, ok = replaceKey(&mocrelay.Event{Kind: 20000})
+	assert.False(t, ok)
+
+	_, ok = replaceKey(&mocrelay.Event{Kind: 30000})
+	assert.False(t, ok)
+
+	pub := strings.Repeat("a", 64)
+	a, ok := replaceKey(&mocrelay.Event{Pubkey: pub, Kind: 0})
+	assert.True(t, ok)
+	assert.Len(t, a, 64)
+
+	b, ok := replaceKey(&mocrelay.Event{
+		Pubkey: pub,
+		Kind:   30000,
+		Tags:   []mocrelay.Tag{{"d", "x"}},
+	})
+	assert.True(t, ok)
+	assert.NotEqual(t, a, b)
+
+	c, ok := naddrReplaceKey("30000:"+pub+":x", pub)
+	assert.True(t, ok)
+	assert.Equal(t, b, c)
+
+	_, ok = naddrReplaceKey("30000:"+strings.Repeat("b", 64)+":x", pub)
+	assert.False(t, ok)
+
+	// The d-tag may contain colons.
+	c, ok = naddrReplaceKey("30000:"+pub+":x:y", pub)
+	assert.True(t, ok)
+	assert.NotEqual(t, b, c)
+}
+
+func TestMigrations(t *testing.T) {
+	migrations, err := migrate.Load(Migrations())
+	assert.NoError(t, err)
+	if assert.NotEmpty(t, migrations) {
+		assert.Equal(t, 1, migrations[0].Version)
+		assert.Len(t, migrations[0].Statements, 2)
+	}
+}
+
+func TestStore_Namespace(t *testing.T) {
+	s := New(&sql.DB{}, nil)
+	assert.Equal(t, "", s.ns)
+
+	ns := s.Namespace("tenant").(*Store)
+	assert.Equal(t, "tenant", ns.ns)
+	assert.Same(t, s.db, ns.db)
+	assert.Same(t, s.codec, ns.codec)
+	assert.Equal(t, "", s.ns)
+}
@@ -0,0 +1,142 @@
+package mysql
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// partitionedTables are partitioned by RANGE (created_at) with the same bounds.
+var partitionedTables = []string{"events", "event_tags"}
+
+// partitionsAhead is the number of future partitions created in advance.
+const partitionsAhead = 2
+
+// MaintainPartitions creates partitions for upcoming events and,
+// if Retention is set, drops partitions whose events are all older than it.
+// It should be called periodically, e.g. hourly.
+func (s *Store) MaintainPartitions(ctx context.Context, now time.Time) error {
+	window := int64(s.opt.partitionWindow() / time.Second)
+
+	for _, table := range partitionedTables {
+		bounds, err := s.partitionBounds(ctx, table)
+		if err != nil {
+			return err
+		}
+
+		if add := partitionsToAdd(bounds, now.Unix(), window, partitionsAhead); len(add) > 0 {
+			if _, err := s.db.ExecContext(ctx, buildAddPartitions(table, add)); err != nil {
+				return fmt.Errorf("failed to add partitions of %s: %w", table, err)
+			}
+		}
+
+		if retention := s.opt.retention(); retention > 0 {
+			cutoff := now.Add(-retention).Unix()
+			if drop := partitionsToDrop(bounds, cutoff); len(drop) > 0 {
+				if _, err := s.db.ExecContext(ctx, buildDropPartitions(table, drop)); err != nil {
+					return fmt.Errorf("failed to drop partitions of %s: %w", table, err)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// partitionBounds returns the upper bounds of partitions of table in ascending order
+// except for the MAXVALUE one.
+func (s *Store) partitionBounds(ctx context.Context, table string) ([]int64, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		"SELECT PARTITION_DESCRIPTION FROM information_schema.PARTITIONS "+
+			"WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND PARTITION_NAME IS NOT NULL "+
+			"ORDER BY PARTITION_ORDINAL_POSITION",
+		table,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get partitions of %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	var ret []int64
+	for rows.Next() {
+		var desc string
+		if err := rows.Scan(&desc); err != nil {
+			return nil, fmt.Errorf("failed to scan partition: %w", err)
+		}
+		if desc == "MAXVALUE" {
+			continue
+		}
+		bound, err := strconv.ParseInt(desc, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid partition bound %q of %s", desc, table)
+		}
+		ret = append(ret, bound)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get partitions of %s: %w", table, err)
+	}
+	return ret, nil
+}
+
+// partitionsToAdd returns the bounds of new partitions aligned to window
+// so that partitions cover ahead windows after the one containing now.
+// Events older than the first partition go into it.
+func partitionsToAdd(bounds []int64, now, window int64, ahead int) []int64 {
+	current := now - now%window + window
+	last := current + int64(ahead)*window
+
+	next := current
+	if len(bounds) > 0 {
+		next = max(bounds[len(bounds)-1]+window, current)
+		next -= next % window
+	}
+
+	var ret []int64
+	for b := next; b <= last; b += window {
+		ret = append(ret, b)
+	}
+	return ret
+}
+
+// partitionsToDrop returns the bounds of partitions whose events are all older than cutoff.
+func partitionsToDrop(bounds []int64, cutoff int64) []int64 {
+	var ret []int64
+	for _, b := range bounds {
+		if b <= cutoff {
+			ret = append(ret, b)
+		}
+	}
+	return ret
+}
+
+func partitionName(bound int64) string {
+	return "p" + strconv.FormatInt(bound, 10)
+}
+
+func buildAddPartitions(table string, bounds []int64) string {
+	parts := make([]string, 0, len(bounds)+1)
+	for _, b := range bounds {
+		parts = append(
+			parts,
+			fmt.Sprintf("PARTITION %s VALUES LESS THAN (%d)", partitionName(b), b),
+		)
+	}
+	parts = append(parts, "PARTITION pmax VALUES LESS THAN MAXVALUE")
+
+	return fmt.Sprintf(
+		"ALTER TABLE %s REORGANIZE PARTITION pmax INTO (%s)",
+		table,
+		strings.Join(parts, ", "),
+	)
+}
+
+func buildDropPartitions(table string, bounds []int64) string {
+	names := make([]string, len(bounds))
+	for i, b := range bounds {
+		names[i] = partitionName(b)
+	}
+	return fmt.Sprintf("ALTER TABLE %s DROP PARTITION %s", table, strings.Join(names, ", "))
+}
@@ -0,0 +1,71 @@
+package mysql
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPartitionsToAdd(t *testing.T) {
+	tests := []struct {
+		name   string
+		bounds []int64
+		now    int64
+		want   []int64
+	}{
+		{
+			name: "no partitions",
+			now:  105,
+			want: []int64{110, 120, 130},
+		},
+		{
+			name:   "up to date",
+			bounds: []int64{110, 120, 130},
+			now:    105,
+		},
+		{
+			name:   "extend",
+			bounds: []int64{110, 120, 130},
+			now:    115,
+			want:   []int64{140},
+		},
+		{
+			name:   "gap",
+			bounds: []int64{110},
+			now:    155,
+			want:   []int64{160, 170, 180},
+		},
+		{
+			name:   "unaligned",
+			bounds: []int64{115},
+			now:    105,
+			want:   []int64{120, 130},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, partitionsToAdd(tt.bounds, tt.now, 10, 2))
+		})
+	}
+}
+
+func TestPartitionsToDrop(t *testing.T) {
+	assert.Equal(t, []int64{110, 120}, partitionsToDrop([]int64{110, 120, 130}, 125))
+	assert.Nil(t, partitionsToDrop([]int64{110, 120, 130}, 105))
+}
+
+func TestBuildPartitions(t *testing.T) {
+	assert.Equal(
+		t,
+		"ALTER TABLE events REORGANIZE PARTITION pmax INTO ("+
+			"PARTITION p110 VALUES LESS THAN (110), PARTITION p120 VALUES LESS THAN (120), "+
+			"PARTITION pmax VALUES LESS THAN MAXVALUE)",
+		buildAddPartitions("events", []int64{110, 120}),
+	)
+	assert.Equal(
+		t,
+		"ALTER TABLE event_tags DROP PARTITION p110, p120",
+		buildDropPartitions("event_tags", []int64{110, 120}),
+	)
+}
@@ -0,0 +1,179 @@
+package mysql
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/dict"
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstdMagic is the head of zstd frames. Raw JSON never starts with it,
+// so uncompressed events stored before compression are read as is.
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// codec compresses event blobs with the latest trained dictionary
+// and decompresses them with any of the dictionaries.
+type codec struct {
+	mu  sync.RWMutex
+	enc *zstd.Encoder
+	dec *zstd.Decoder
+}
+
+func newCodec() *codec {
+	c := new(codec)
+	if err := c.setDicts(nil); err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// setDicts replaces the dictionaries. The last one is used for compression.
+func (c *codec) setDicts(dicts [][]byte) error {
+	var eopts []zstd.EOption
+	if len(dicts) > 0 {
+		eopts = append(eopts, zstd.WithEncoderDict(dicts[len(dicts)-1]))
+	}
+	enc, err := zstd.NewWriter(nil, eopts...)
+	if err != nil {
+		return fmt.Errorf("failed to create zstd encoder: %w", err)
+	}
+
+	dec, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(0), zstd.WithDecoderDicts(dicts...))
+	if err != nil {
+		enc.Close()
+		return fmt.Errorf("failed to create zstd decoder: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.enc != nil {
+		c.enc.Close()
+		c.dec.Close()
+	}
+	c.enc, c.dec = enc, dec
+	return nil
+}
+
+func (c *codec) encode(raw []byte) []byte {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.enc.EncodeAll(raw, nil)
+}
+
+// decode returns b as is if it is not compressed.
+func (c *codec) decode(b []byte) ([]byte, error) {
+	if !bytes.HasPrefix(b, zstdMagic) {
+		return b, nil
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.dec.DecodeAll(b, nil)
+}
+
+// decode decompresses b, reloading dictionaries once if another process trained a new one.
+func (s *Store) decode(ctx context.Context, b []byte) ([]byte, error) {
+	raw, err := s.codec.decode(b)
+	if errors.Is(err, zstd.ErrUnknownDictionary) {
+		if err := s.loadDicts(ctx); err != nil {
+			return nil, err
+		}
+		raw, err = s.codec.decode(b)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress event: %w", err)
+	}
+	return raw, nil
+}
+
+func (s *Store) loadDicts(ctx context.Context) error {
+	rows, err := s.db.QueryContext(ctx, "SELECT dict FROM zstd_dicts ORDER BY created_at, id")
+	if err != nil {
+		return fmt.Errorf("failed to load zstd dicts: %w", err)
+	}
+	defer rows.Close()
+
+	var dicts [][]byte
+	for rows.Next() {
+		var d []byte
+		if err := rows.Scan(&d); err != nil {
+			return fmt.Errorf("failed to scan zstd dict: %w", err)
+		}
+		dicts = append(dicts, d)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to load zstd dicts: %w", err)
+	}
+
+	return s.codec.setDicts(dicts)
+}
+
+// TrainDict builds a zstd dictionary from the latest samples events,
+// stores it and uses it to compress new events. It returns the dictionary id.
+// Old dictionaries are kept to read events compressed with them.
+func (s *Store) TrainDict(ctx context.Context, samples int) (uint32, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		"SELECT raw FROM events ORDER BY created_at DESC LIMIT ?",
+		samples,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to sample events: %w", err)
+	}
+	defer rows.Close()
+
+	var input [][]byte
+	for rows.Next() {
+		var b []byte
+		if err := rows.Scan(&b); err != nil {
+			return 0, fmt.Errorf("failed to scan event: %w", err)
+		}
+		raw, err := s.decode(ctx, b)
+		if err != nil {
+			return 0, err
+		}
+		input = append(input, raw)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to sample events: %w", err)
+	}
+	rows.Close()
+
+	d, err := dict.BuildZstdDict(input, dict.Options{
+		MaxDictSize: 64 << 10,
+		HashBytes:   6,
+		ZstdLevel:   zstd.SpeedDefault,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to build zstd dict: %w", err)
+	}
+	info, err := zstd.InspectDictionary(d)
+	if err != nil {
+		return 0, fmt.Errorf("failed to build zstd dict: %w", err)
+	}
+	id := info.ID()
+
+	_, err = s.db.ExecContext(
+		ctx,
+		"INSERT INTO zstd_dicts (id, dict, created_at) VALUES (?, ?, ?)",
+		id,
+		d,
+		time.Now().Unix(),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to save zstd dict: %w", err)
+	}
+
+	if err := s.loadDicts(ctx); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
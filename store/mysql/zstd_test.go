@@ -0,0 +1,51 @@
+package mysql
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/klauspost/compress/dict"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCodec(t *testing.T) {
+	var samples [][]byte
+	for i := 0; i < 200; i++ {
+		samples = append(samples, []byte(fmt.Sprintf(
+			`{"id":"%064x","pubkey":"%064x","created_at":%d,"kind":1,"tags":[["p","%064x"]],`+
+				`"content":"hello nostr %d","sig":"%0128x"}`,
+			i, i%10, 1700000000+i, i%7, i, i,
+		)))
+	}
+	d, err := dict.BuildZstdDict(samples, dict.Options{MaxDictSize: 8 << 10, HashBytes: 6})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	raw := samples[0]
+
+	c := newCodec()
+	plain := c.encode(raw)
+	got, err := c.decode(plain)
+	assert.NoError(t, err)
+	assert.Equal(t, raw, got)
+
+	got, err = c.decode(raw)
+	assert.NoError(t, err)
+	assert.Equal(t, raw, got, "uncompressed blobs are read as is")
+
+	assert.NoError(t, c.setDicts([][]byte{d}))
+	withDict := c.encode(raw)
+	assert.Less(t, len(withDict), len(plain))
+	got, err = c.decode(withDict)
+	assert.NoError(t, err)
+	assert.Equal(t, raw, got)
+
+	got, err = c.decode(plain)
+	assert.NoError(t, err)
+	assert.Equal(t, raw, got)
+
+	_, err = newCodec().decode(withDict)
+	assert.ErrorIs(t, err, zstd.ErrUnknownDictionary)
+}
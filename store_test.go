@@ -0,0 +1,155 @@
+package mocrelay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var _ EventStore = (*testEventStore)(nil)
+
+// testEventStore is an EventStore on eventCache.
+type testEventStore struct {
+	c   *eventCache
+	err error
+	// delay is the time taken by each query.
+	delay time.Duration
+
+	// namespaces are shared by the stores of all namespaces.
+	namespaces map[string]*testEventStore
+}
+
+func newTestEventStore() *testEventStore {
+	s := &testEventStore{c: newEventCache(10)}
+	s.namespaces = map[string]*testEventStore{"": s}
+	return s
+}
+
+func (s *testEventStore) Namespace(ns string) EventStore {
+	ret, ok := s.namespaces[ns]
+	if !ok {
+		ret = &testEventStore{c: newEventCache(10), namespaces: s.namespaces}
+		s.namespaces[ns] = ret
+	}
+	return ret
+}
+
+func (s *testEventStore) Save(ctx context.Context, event *Event) (bool, error) {
+	if s.err != nil {
+		return false, s.err
+	}
+	return s.c.Add(event), nil
+}
+
+func (s *testEventStore) Delete(ctx context.Context, deletion *Event) error {
+	for _, tag := range deletion.Tags {
+		if len(tag) >= 2 && tag[0] == "e" {
+			s.c.DeleteID(tag[1], deletion.Pubkey)
+		}
+	}
+	return s.err
+}
+
+func (s *testEventStore) Query(ctx context.Context, filters []*ReqFilter) ([]*Event, error) {
+	time.Sleep(s.delay)
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.c.FindContext(ctx, NewReqFiltersEventMatchers(filters))
+}
+
+func (s *testEventStore) Count(ctx context.Context, filters []*ReqFilter) (uint64, error) {
+	evs, err := s.Query(ctx, filters)
+	return uint64(len(evs)), err
+}
+
+func (s *testEventStore) PurgePubkey(ctx context.Context, pubkey string) (int, error) {
+	return s.c.DeletePubkey(pubkey), s.err
+}
+
+func TestStoreHandler(t *testing.T) {
+	event := &Event{ID: "id", Pubkey: "pubkey", CreatedAt: 1, Kind: 1, Tags: []Tag{}}
+	deletion := &Event{ID: "del", Pubkey: "pubkey", CreatedAt: 2, Kind: 5, Tags: []Tag{{"e", "id"}}}
+	req := &ClientReqMsg{SubscriptionID: "sub", ReqFilters: []*ReqFilter{{Kinds: []int64{1}}}}
+	count := &ClientCountMsg{SubscriptionID: "cnt", ReqFilters: []*ReqFilter{{Kinds: []int64{1}}}}
+
+	tests := []struct {
+		name  string
+		err   error
+		input []ClientMsg
+		want  []ServerMsg
+	}{
+		{
+			name: "event req count",
+			input: []ClientMsg{
+				&ClientEventMsg{Event: event},
+				&ClientEventMsg{Event: event},
+				req,
+				count,
+			},
+			want: []ServerMsg{
+				NewServerOKMsg("id", true, "", ""),
+				NewServerOKMsg("id", false, ServerOKMsgPrefixDuplicate, "already have this event"),
+				NewServerEventMsg("sub", event),
+				NewServerEOSEMsg("sub"),
+				NewServerCountMsg("cnt", 1, nil),
+			},
+		},
+		{
+			name: "deletion",
+			input: []ClientMsg{
+				&ClientEventMsg{Event: event},
+				&ClientEventMsg{Event: deletion},
+				req,
+			},
+			want: []ServerMsg{
+				NewServerOKMsg("id", true, "", ""),
+				NewServerOKMsg("del", true, "", ""),
+				NewServerEOSEMsg("sub"),
+			},
+		},
+		{
+			name:  "error",
+			err:   errors.New("down"),
+			input: []ClientMsg{&ClientEventMsg{Event: event}, req, count},
+			want: []ServerMsg{
+				NewServerOKMsg("id", false, ServerOkMsgPrefixError, "failed to save event"),
+				NewServerNoticeMsg("failed to query: sub"),
+				NewServerEOSEMsg("sub"),
+				NewServerCountMsg("cnt", 0, toPtr(true)),
+			},
+		},
+		{
+			name:  "ok error",
+			err:   fmt.Errorf("wrapped: %w", NewOKError(ServerOkMsgPrefixRestricted, "read only")),
+			input: []ClientMsg{&ClientEventMsg{Event: event}},
+			want: []ServerMsg{
+				NewServerOKMsg("id", false, ServerOkMsgPrefixRestricted, "read only"),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestEventStore()
+			store.err = tt.err
+			h := newSimpleStoreHandler(store, nil)
+			r, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, "/", nil)
+
+			var got []ServerMsg
+			for _, msg := range tt.input {
+				smsgCh, err := h.HandleClientMsg(r, msg)
+				assert.NoError(t, err)
+				for smsg := range smsgCh {
+					got = append(got, smsg)
+				}
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
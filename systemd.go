@@ -0,0 +1,86 @@
+package mocrelay
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// States sent by SystemdNotify.
+const (
+	SystemdReady    = "READY=1"
+	SystemdStopping = "STOPPING=1"
+)
+
+// systemdListenFDsStart is the first file descriptor passed by socket activation.
+const systemdListenFDsStart = 3
+
+// SystemdListeners returns the sockets passed by systemd socket activation in order.
+// It returns nil if the process is not socket activated.
+// The activation variables are unset so that child processes don't inherit them.
+func SystemdListeners() ([]net.Listener, error) {
+	n, err := systemdListenFDs(os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), os.Getpid())
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if err != nil || n == 0 {
+		return nil, err
+	}
+
+	ret := make([]net.Listener, 0, n)
+	for fd := systemdListenFDsStart; fd < systemdListenFDsStart+n; fd++ {
+		f := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, ln := range ret {
+				ln.Close()
+			}
+			return nil, fmt.Errorf("failed to use socket activated fd %d: %w", fd, err)
+		}
+		ret = append(ret, ln)
+	}
+	return ret, nil
+}
+
+// systemdListenFDs returns the number of sockets passed to the process of pid.
+func systemdListenFDs(listenPID, listenFDs string, pid int) (int, error) {
+	if listenPID == "" || listenFDs == "" {
+		return 0, nil
+	}
+
+	p, err := strconv.Atoi(listenPID)
+	if err != nil {
+		return 0, fmt.Errorf("invalid LISTEN_PID %q: %w", listenPID, err)
+	}
+	if p != pid {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(listenFDs)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid LISTEN_FDS %q", listenFDs)
+	}
+	return n, nil
+}
+
+// SystemdNotify sends state such as SystemdReady to the service manager.
+// It does nothing if NOTIFY_SOCKET is not set.
+func SystemdNotify(state string) error {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return nil
+	}
+
+	conn, err := net.Dial("unixgram", addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to NOTIFY_SOCKET: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("failed to notify %q: %w", state, err)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package mocrelay
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSystemdListenFDs(t *testing.T) {
+	tests := []struct {
+		name      string
+		listenPID string
+		listenFDs string
+		want      int
+		wantErr   bool
+	}{
+		{name: "not activated", want: 0},
+		{name: "ok", listenPID: "42", listenFDs: "2", want: 2},
+		{name: "other process", listenPID: "43", listenFDs: "2", want: 0},
+		{name: "invalid pid", listenPID: "powa", listenFDs: "2", wantErr: true},
+		{name: "invalid fds", listenPID: "42", listenFDs: "-1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := systemdListenFDs(tt.listenPID, tt.listenFDs, 42)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, n)
+		})
+	}
+}
+
+func TestSystemdListeners_notActivated(t *testing.T) {
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "1")
+
+	lns, err := SystemdListeners()
+	assert.NoError(t, err)
+	assert.Nil(t, lns)
+	assert.Empty(t, os.Getenv("LISTEN_FDS"))
+}
+
+func TestSystemdNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	assert.NoError(t, SystemdNotify(SystemdReady))
+
+	addr := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: addr, Net: "unixgram"})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	t.Setenv("NOTIFY_SOCKET", addr)
+	require.NoError(t, SystemdNotify(SystemdReady))
+
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, SystemdReady, string(buf[:n]))
+}
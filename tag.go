@@ -0,0 +1,120 @@
+package mocrelay
+
+import (
+	"strconv"
+	"time"
+)
+
+// Tags is the tags of an event.
+type Tags []Tag
+
+// FindFirst returns the first tag named name or nil if there is none.
+func (tags Tags) FindFirst(name string) Tag {
+	for _, tag := range tags {
+		if len(tag) >= 1 && tag[0] == name {
+			return tag
+		}
+	}
+	return nil
+}
+
+// Values returns the values, i.e. the second elements, of the tags named name.
+func (tags Tags) Values(name string) []string {
+	var ret []string
+	for _, tag := range tags {
+		if len(tag) >= 2 && tag[0] == name {
+			ret = append(ret, tag[1])
+		}
+	}
+	return ret
+}
+
+// DTag returns the value of the first "d" tag.
+// A "d" tag without a value has the empty value. ok is false if there is no "d" tag.
+func (tags Tags) DTag() (d string, ok bool) {
+	tag := tags.FindFirst("d")
+	if tag == nil {
+		return "", false
+	}
+	if len(tag) >= 2 {
+		d = tag[1]
+	}
+	return d, true
+}
+
+// ExpirationTag returns the NIP-40 expiration of the first "expiration" tag.
+// ok is false if there is no valid one.
+func (tags Tags) ExpirationTag() (at time.Time, ok bool) {
+	tag := tags.FindFirst("expiration")
+	if len(tag) < 2 {
+		return
+	}
+	n, err := strconv.ParseInt(tag[1], 10, 64)
+	if err != nil {
+		return
+	}
+	return time.Unix(n, 0), true
+}
+
+// ETag is an "e" tag referring to an event.
+type ETag struct {
+	ID string
+	// Relay is the normalized relay hint or empty if it is missing or invalid.
+	Relay string
+	// Marker is "root", "reply" or "mention" as NIP-10.
+	Marker string
+	// Pubkey is the author of the event as NIP-10.
+	Pubkey string
+}
+
+// ETags returns the "e" tags with valid event ids.
+func (tags Tags) ETags() []ETag {
+	var ret []ETag
+	for _, tag := range tags {
+		if len(tag) < 2 || tag[0] != "e" || !validID(tag[1]) {
+			continue
+		}
+		e := ETag{ID: tag[1], Relay: relayHint(tag, 2)}
+		if len(tag) >= 4 {
+			e.Marker = tag[3]
+		}
+		if len(tag) >= 5 && validPubkey(tag[4]) {
+			e.Pubkey = tag[4]
+		}
+		ret = append(ret, e)
+	}
+	return ret
+}
+
+// PTag is a "p" tag referring to a pubkey.
+type PTag struct {
+	Pubkey string
+	// Relay is the normalized relay hint or empty if it is missing or invalid.
+	Relay   string
+	Petname string
+}
+
+// PTags returns the "p" tags with valid pubkeys.
+func (tags Tags) PTags() []PTag {
+	var ret []PTag
+	for _, tag := range tags {
+		if len(tag) < 2 || tag[0] != "p" || !validPubkey(tag[1]) {
+			continue
+		}
+		p := PTag{Pubkey: tag[1], Relay: relayHint(tag, 2)}
+		if len(tag) >= 4 {
+			p.Petname = tag[3]
+		}
+		ret = append(ret, p)
+	}
+	return ret
+}
+
+// relayHint returns the normalized relay URL at tag[i] or empty if it is invalid.
+func relayHint(tag Tag, i int) string {
+	if len(tag) <= i || tag[i] == "" {
+		return ""
+	}
+	u, _ := NormalizeRelayURL(tag[i])
+	return u
+}
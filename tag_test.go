@@ -0,0 +1,53 @@
+package mocrelay
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTags(t *testing.T) {
+	id := strings.Repeat("1", 64)
+	pubkey := strings.Repeat("ab", 32)
+
+	tags := Tags{
+		{"d"},
+		{"d", "second"},
+		{"t", "nostr"},
+		{"t", "mocrelay"},
+		{"t"},
+		{"expiration", "1700000000"},
+		{"e", id, "wss://Relay.Example.com", "reply", pubkey},
+		{"e", "invalid"},
+		{"e", id, "invalid"},
+		{"p", pubkey, "", "powa"},
+		{"p", strings.ToUpper(pubkey)},
+	}
+
+	assert.Equal(t, Tag{"t", "nostr"}, tags.FindFirst("t"))
+	assert.Nil(t, tags.FindFirst("a"))
+
+	assert.Equal(t, []string{"nostr", "mocrelay"}, tags.Values("t"))
+	assert.Nil(t, tags.Values("a"))
+
+	d, ok := tags.DTag()
+	assert.True(t, ok)
+	assert.Equal(t, "", d)
+	_, ok = Tags{}.DTag()
+	assert.False(t, ok)
+
+	at, ok := tags.ExpirationTag()
+	assert.True(t, ok)
+	assert.Equal(t, time.Unix(1700000000, 0), at)
+	_, ok = Tags{{"expiration", "powa"}}.ExpirationTag()
+	assert.False(t, ok)
+
+	assert.Equal(t, []ETag{
+		{ID: id, Relay: "wss://relay.example.com", Marker: "reply", Pubkey: pubkey},
+		{ID: id},
+	}, tags.ETags())
+
+	assert.Equal(t, []PTag{{Pubkey: pubkey, Petname: "powa"}}, tags.PTags())
+}
@@ -0,0 +1,368 @@
+package mocrelay
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+var ErrInvalidTombstone = errors.New("invalid tombstone")
+
+type TombstonesOption struct {
+	// Path is the file tombstones are loaded from and appended to.
+	// If empty, they are kept in memory only.
+	Path string
+
+	// ExpectedItems sizes the bloom filter for a 1% false positive rate.
+	// More items only make lookups slower. The default is 1 << 20.
+	ExpectedItems int
+}
+
+func (opt *TombstonesOption) path() string {
+	if opt == nil {
+		return ""
+	}
+	return opt.Path
+}
+
+func (opt *TombstonesOption) expectedItems() int {
+	if opt == nil || opt.ExpectedItems <= 0 {
+		return 1 << 20
+	}
+	return opt.ExpectedItems
+}
+
+// tombstoneRecordSize is the size of a record in the file, an id followed by a pubkey.
+const tombstoneRecordSize = 64
+
+// Tombstones are the ids of deleted events which must not be accepted again
+// even after they are evicted from the cache or the relay restarts.
+// Most lookups of live events are answered by a bloom filter without locks.
+type Tombstones struct {
+	bloom *bloomFilter
+
+	// pubkeys is the number of tombstones of pubkeys.
+	pubkeys atomic.Int64
+
+	mu sync.RWMutex
+	// Keys are records of ids and pubkeys. The zero pubkey matches events of any author
+	// and the zero id matches any event of the pubkey.
+	m   map[[tombstoneRecordSize]byte]struct{}
+	f   *os.File
+	err error
+}
+
+// OpenTombstones loads the tombstones from option.Path if any.
+func OpenTombstones(option *TombstonesOption) (*Tombstones, error) {
+	t := &Tombstones{
+		bloom: newBloomFilter(option.expectedItems(), 0.01),
+		m:     make(map[[tombstoneRecordSize]byte]struct{}),
+	}
+
+	path := option.path()
+	if path == "" {
+		return t, nil
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open tombstones: %w", err)
+	}
+	b, err := io.ReadAll(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to read tombstones: %w", err)
+	}
+
+	n := len(b) / tombstoneRecordSize
+	for i := 0; i < n; i++ {
+		t.set([tombstoneRecordSize]byte(b[i*tombstoneRecordSize:]))
+	}
+	// A partial record written on a crash is dropped to keep records aligned.
+	if err := f.Truncate(int64(n * tombstoneRecordSize)); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to truncate tombstones: %w", err)
+	}
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to seek tombstones: %w", err)
+	}
+
+	t.f = f
+	return t, nil
+}
+
+func tombstoneRecord(id, pubkey [32]byte) [tombstoneRecordSize]byte {
+	var rec [tombstoneRecordSize]byte
+	copy(rec[:32], id[:])
+	copy(rec[32:], pubkey[:])
+	return rec
+}
+
+// set adds a tombstone and reports whether it is new.
+func (t *Tombstones) set(rec [tombstoneRecordSize]byte) bool {
+	if _, ok := t.m[rec]; ok {
+		return false
+	}
+	t.m[rec] = struct{}{}
+	if id := [32]byte(rec[:32]); id == ([32]byte{}) {
+		t.pubkeys.Add(1)
+	} else {
+		t.bloom.add(id)
+	}
+	return true
+}
+
+// Add adds the tombstone of id deleted by pubkey.
+// An empty pubkey rejects the event of any author such as one an admin deleted.
+func (t *Tombstones) Add(id, pubkey string) error {
+	bid, ok := decodeHex32(id)
+	if !ok {
+		return fmt.Errorf("%w: invalid id %q", ErrInvalidTombstone, id)
+	}
+	var bpubkey [32]byte
+	if pubkey != "" {
+		if bpubkey, ok = decodeHex32(pubkey); !ok {
+			return fmt.Errorf("%w: invalid pubkey %q", ErrInvalidTombstone, pubkey)
+		}
+	}
+
+	return t.add(tombstoneRecord(bid, bpubkey))
+}
+
+// AddPubkey adds the tombstone of all events by pubkey such as one an admin purged.
+func (t *Tombstones) AddPubkey(pubkey string) error {
+	bpubkey, ok := decodeHex32(pubkey)
+	if !ok {
+		return fmt.Errorf("%w: invalid pubkey %q", ErrInvalidTombstone, pubkey)
+	}
+	return t.add(tombstoneRecord([32]byte{}, bpubkey))
+}
+
+func (t *Tombstones) add(rec [tombstoneRecordSize]byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.set(rec) || t.f == nil || t.err != nil {
+		return t.err
+	}
+	if _, err := t.f.Write(rec[:]); err != nil {
+		t.err = fmt.Errorf("failed to write tombstone: %w", err)
+	}
+	return t.err
+}
+
+// Contains reports whether event is deleted.
+func (t *Tombstones) Contains(event *Event) bool {
+	id, ok := decodeHex32(event.ID)
+	if !ok {
+		return false
+	}
+	byID := t.bloom.contains(id)
+	if !byID && t.pubkeys.Load() == 0 {
+		return false
+	}
+
+	pubkey, _ := decodeHex32(event.Pubkey)
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	_, byAuthor := t.m[tombstoneRecord(id, pubkey)]
+	_, byAdmin := t.m[tombstoneRecord(id, [32]byte{})]
+	_, byPubkey := t.m[tombstoneRecord([32]byte{}, pubkey)]
+	return byAuthor || byAdmin || byPubkey
+}
+
+func (t *Tombstones) Len() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.m)
+}
+
+// Err returns the first write error. Tombstones are still kept in memory after an error.
+func (t *Tombstones) Err() error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.err
+}
+
+func (t *Tombstones) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.f == nil {
+		return nil
+	}
+	err := t.f.Close()
+	t.f = nil
+	return err
+}
+
+func decodeHex32(s string) ([32]byte, bool) {
+	var ret [32]byte
+	if len(s) != 64 {
+		return ret, false
+	}
+	if _, err := hex.Decode(ret[:], []byte(s)); err != nil {
+		return ret, false
+	}
+	return ret, true
+}
+
+// bloomFilter is a bloom filter of hashes such as event ids.
+// It is safe for concurrent use.
+type bloomFilter struct {
+	words []atomic.Uint64
+	k     int
+}
+
+func newBloomFilter(n int, fpRate float64) *bloomFilter {
+	bits := math.Ceil(-float64(n) * math.Log(fpRate) / (math.Ln2 * math.Ln2))
+	k := max(1, int(math.Round(bits/float64(n)*math.Ln2)))
+	return &bloomFilter{
+		words: make([]atomic.Uint64, (int(bits)+63)/64),
+		k:     k,
+	}
+}
+
+// indexes calls f with the bit indexes of h by double hashing.
+// h must be uniformly distributed like sha256 hashes.
+func (b *bloomFilter) indexes(h [32]byte, f func(i uint64) bool) {
+	m := uint64(len(b.words)) * 64
+	h1 := binary.LittleEndian.Uint64(h[:8])
+	h2 := binary.LittleEndian.Uint64(h[8:16]) | 1
+	for i := 0; i < b.k; i++ {
+		if !f((h1 + uint64(i)*h2) % m) {
+			return
+		}
+	}
+}
+
+func (b *bloomFilter) add(h [32]byte) {
+	b.indexes(h, func(i uint64) bool {
+		w, bit := &b.words[i/64], uint64(1)<<(i%64)
+		for {
+			old := w.Load()
+			if old&bit != 0 || w.CompareAndSwap(old, old|bit) {
+				return true
+			}
+		}
+	})
+}
+
+func (b *bloomFilter) contains(h [32]byte) bool {
+	ret := true
+	b.indexes(h, func(i uint64) bool {
+		ret = b.words[i/64].Load()&(uint64(1)<<(i%64)) != 0
+		return ret
+	})
+	return ret
+}
+
+type TombstoneMiddleware Middleware
+
+// NewTombstoneMiddleware rejects and hides deleted events in t and adds the targets of
+// e tags of deletion requests (NIP-09) the handler accepts.
+// Deletions are bound to the pubkey of the requests so that others' events
+// cannot be deleted by them.
+func NewTombstoneMiddleware(t *Tombstones) TombstoneMiddleware {
+	if t == nil {
+		panic("tombstones must be non-nil pointer")
+	}
+
+	return func(h Handler) Handler {
+		return HandlerFunc(
+			func(r *http.Request, recv <-chan ClientMsg, send chan<- ServerMsg) error {
+				sm := newSimpleTombstoneMiddleware(t)
+				m := NewSimpleMiddleware(sm)
+				return m(h).Handle(r, recv, send)
+			},
+		)
+	}
+}
+
+var _ SimpleMiddlewareInterface = (*simpleTombstoneMiddleware)(nil)
+
+type simpleTombstoneMiddleware struct {
+	t *Tombstones
+
+	mu sync.Mutex
+	// map[eventID]event
+	pending map[string]*Event
+}
+
+func newSimpleTombstoneMiddleware(t *Tombstones) *simpleTombstoneMiddleware {
+	return &simpleTombstoneMiddleware{
+		t:       t,
+		pending: make(map[string]*Event),
+	}
+}
+
+func (m *simpleTombstoneMiddleware) HandleStart(r *http.Request) (*http.Request, error) {
+	return r, nil
+}
+
+func (m *simpleTombstoneMiddleware) HandleStop(r *http.Request) error {
+	return nil
+}
+
+func (m *simpleTombstoneMiddleware) HandleClientMsg(
+	r *http.Request,
+	msg ClientMsg,
+) (<-chan ClientMsg, <-chan ServerMsg, error) {
+	if msg, ok := msg.(*ClientEventMsg); ok {
+		if m.t.Contains(msg.Event) {
+			okMsg := NewServerOKMsg(
+				msg.Event.ID,
+				false,
+				ServerOkMsgPrefixBlocked,
+				"event is deleted",
+			)
+			return nil, newClosedBufCh[ServerMsg](okMsg), nil
+		}
+
+		if msg.Event.Kind == 5 {
+			m.mu.Lock()
+			m.pending[msg.Event.ID] = msg.Event
+			m.mu.Unlock()
+		}
+	}
+
+	return newClosedBufCh(msg), nil, nil
+}
+
+func (m *simpleTombstoneMiddleware) HandleServerMsg(
+	r *http.Request,
+	msg ServerMsg,
+) (<-chan ServerMsg, error) {
+	// Stored copies of events deleted by admins are not served.
+	if eventMsg, ok := msg.(*ServerEventMsg); ok && m.t.Contains(eventMsg.Event) {
+		return nil, nil
+	}
+
+	if okMsg, ok := msg.(*ServerOKMsg); ok {
+		m.mu.Lock()
+		event, found := m.pending[okMsg.EventID]
+		delete(m.pending, okMsg.EventID)
+		m.mu.Unlock()
+
+		if found && okMsg.Accepted {
+			for _, tag := range event.Tags {
+				if len(tag) >= 2 && tag[0] == "e" {
+					// Write errors are reported by Tombstones.Err.
+					m.t.Add(tag[1], event.Pubkey)
+				}
+			}
+		}
+	}
+
+	return newClosedBufCh(msg), nil
+}
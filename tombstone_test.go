@@ -0,0 +1,172 @@
+package mocrelay
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testTombstoneHex(c string) string { return strings.Repeat(c, 64) }
+
+func TestTombstones(t *testing.T) {
+	id1, id2, id3 := testTombstoneHex("1"), testTombstoneHex("2"), testTombstoneHex("3")
+	alice, bob := testTombstoneHex("a"), testTombstoneHex("b")
+
+	ts, err := OpenTombstones(nil)
+	require.NoError(t, err)
+
+	require.NoError(t, ts.Add(id1, alice))
+	require.NoError(t, ts.Add(id1, alice))
+	require.NoError(t, ts.Add(id2, ""))
+	assert.ErrorIs(t, ts.Add("invalid", alice), ErrInvalidTombstone)
+	assert.ErrorIs(t, ts.Add(id3, "invalid"), ErrInvalidTombstone)
+	assert.Equal(t, 2, ts.Len())
+
+	assert.True(t, ts.Contains(&Event{ID: id1, Pubkey: alice}))
+	assert.False(t, ts.Contains(&Event{ID: id1, Pubkey: bob}))
+	assert.True(t, ts.Contains(&Event{ID: id2, Pubkey: bob}))
+	assert.False(t, ts.Contains(&Event{ID: id3, Pubkey: alice}))
+	assert.False(t, ts.Contains(&Event{ID: "invalid", Pubkey: alice}))
+
+	// Forged deletions of bob do not make the event of alice deleted.
+	ts, err = OpenTombstones(nil)
+	require.NoError(t, err)
+	require.NoError(t, ts.Add(id1, bob))
+	require.NoError(t, ts.Add(id1, testTombstoneHex("c")))
+	assert.False(t, ts.Contains(&Event{ID: id1, Pubkey: alice}))
+	require.NoError(t, ts.Add(id1, alice))
+	assert.True(t, ts.Contains(&Event{ID: id1, Pubkey: alice}))
+
+	// Purged pubkeys reject all of their events.
+	ts, err = OpenTombstones(nil)
+	require.NoError(t, err)
+	require.NoError(t, ts.AddPubkey(alice))
+	assert.ErrorIs(t, ts.AddPubkey("invalid"), ErrInvalidTombstone)
+	assert.True(t, ts.Contains(&Event{ID: id1, Pubkey: alice}))
+	assert.True(t, ts.Contains(&Event{ID: id2, Pubkey: alice}))
+	assert.False(t, ts.Contains(&Event{ID: id1, Pubkey: bob}))
+}
+
+func TestOpenTombstones_persistence(t *testing.T) {
+	id1, id2 := testTombstoneHex("1"), testTombstoneHex("2")
+	alice, bob := testTombstoneHex("a"), testTombstoneHex("b")
+	path := filepath.Join(t.TempDir(), "tombstones")
+
+	ts, err := OpenTombstones(&TombstonesOption{Path: path})
+	require.NoError(t, err)
+	require.NoError(t, ts.Add(id1, alice))
+	require.NoError(t, ts.Add(id1, alice))
+	require.NoError(t, ts.Add(id2, ""))
+	require.NoError(t, ts.AddPubkey(bob))
+	require.NoError(t, ts.Close())
+
+	// A partial record written on a crash.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{1, 2, 3})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	ts, err = OpenTombstones(&TombstonesOption{Path: path})
+	require.NoError(t, err)
+	assert.Equal(t, 3, ts.Len())
+	assert.True(t, ts.Contains(&Event{ID: id1, Pubkey: alice}))
+	assert.True(t, ts.Contains(&Event{ID: id2, Pubkey: alice}))
+	assert.True(t, ts.Contains(&Event{ID: testTombstoneHex("3"), Pubkey: bob}))
+
+	require.NoError(t, ts.Add(testTombstoneHex("3"), alice))
+	require.NoError(t, ts.Err())
+	require.NoError(t, ts.Close())
+
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.EqualValues(t, 4*tombstoneRecordSize, fi.Size())
+}
+
+func TestBloomFilter(t *testing.T) {
+	b := newBloomFilter(100, 0.01)
+
+	var added, other [32]byte
+	added[0], added[8] = 1, 2
+	other[0], other[8] = 3, 4
+
+	assert.False(t, b.contains(added))
+	b.add(added)
+	assert.True(t, b.contains(added))
+	assert.False(t, b.contains(other))
+}
+
+func TestTombstoneMiddleware(t *testing.T) {
+	target, other := testTombstoneHex("1"), testTombstoneHex("2")
+	alice := testTombstoneHex("a")
+
+	ts, err := OpenTombstones(nil)
+	require.NoError(t, err)
+	m := newSimpleTombstoneMiddleware(ts)
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	deletion := &Event{
+		ID:     testTombstoneHex("d"),
+		Pubkey: alice,
+		Kind:   5,
+		Tags:   Tags{{"e", target}, {"p", alice}},
+	}
+	rejected := &Event{
+		ID:     testTombstoneHex("e"),
+		Pubkey: alice,
+		Kind:   5,
+		Tags:   Tags{{"e", other}},
+	}
+
+	for _, event := range []*Event{deletion, rejected} {
+		cmsgCh, smsgCh, err := m.HandleClientMsg(r, &ClientEventMsg{Event: event})
+		require.NoError(t, err)
+		assert.Nil(t, smsgCh)
+		assert.Len(t, cmsgCh, 1)
+	}
+
+	_, err = m.HandleServerMsg(r, NewServerOKMsg(deletion.ID, true, "", ""))
+	require.NoError(t, err)
+	_, err = m.HandleServerMsg(r, NewServerOKMsg(rejected.ID, false, "", ""))
+	require.NoError(t, err)
+	assert.Equal(t, 1, ts.Len())
+
+	cmsgCh, smsgCh, err := m.HandleClientMsg(
+		r,
+		&ClientEventMsg{Event: &Event{ID: target, Pubkey: alice, Kind: 1}},
+	)
+	require.NoError(t, err)
+	assert.Nil(t, cmsgCh)
+	assert.Equal(
+		t,
+		NewServerOKMsg(target, false, ServerOkMsgPrefixBlocked, "event is deleted"),
+		<-smsgCh,
+	)
+
+	cmsgCh, smsgCh, err = m.HandleClientMsg(
+		r,
+		&ClientEventMsg{Event: &Event{ID: other, Pubkey: alice, Kind: 1}},
+	)
+	require.NoError(t, err)
+	assert.Nil(t, smsgCh)
+	assert.Len(t, cmsgCh, 1)
+
+	smsgCh, err = m.HandleServerMsg(
+		r,
+		NewServerEventMsg("sub", &Event{ID: target, Pubkey: alice, Kind: 1}),
+	)
+	require.NoError(t, err)
+	assert.Nil(t, smsgCh)
+	smsgCh, err = m.HandleServerMsg(
+		r,
+		NewServerEventMsg("sub", &Event{ID: other, Pubkey: alice, Kind: 1}),
+	)
+	require.NoError(t, err)
+	assert.Len(t, smsgCh, 1)
+}
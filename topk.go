@@ -0,0 +1,128 @@
+package mocrelay
+
+import (
+	"cmp"
+	"container/heap"
+	"slices"
+	"sync"
+)
+
+// TopK estimates the most frequent keys such as pubkeys and IPs in bounded memory
+// with the Space-Saving algorithm. A key counted more than 1/capacity of the total
+// is always kept. It is safe for concurrent use. A nil TopK counts nothing.
+type TopK struct {
+	mu sync.Mutex
+	h  topKHeap
+	m  map[string]*topKItem
+	// capacity is the max number of keys kept.
+	capacity int
+}
+
+// TopKEntry is an estimated count of a key.
+// The true count is between Count-Error and Count.
+type TopKEntry struct {
+	Key   string `json:"key"`
+	Count uint64 `json:"count"`
+	Error uint64 `json:"error"`
+}
+
+type topKItem struct {
+	TopKEntry
+	idx int
+}
+
+// NewTopK returns a TopK keeping up to capacity keys. The default is 1000.
+func NewTopK(capacity int) *TopK {
+	if capacity <= 0 {
+		capacity = 1000
+	}
+	return &TopK{
+		m:        make(map[string]*topKItem),
+		capacity: capacity,
+	}
+}
+
+// Add counts key once.
+func (t *TopK) Add(key string) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if item, ok := t.m[key]; ok {
+		item.Count++
+		heap.Fix(&t.h, item.idx)
+		return
+	}
+
+	if len(t.h) < t.capacity {
+		item := &topKItem{TopKEntry: TopKEntry{Key: key, Count: 1}}
+		t.m[key] = item
+		heap.Push(&t.h, item)
+		return
+	}
+
+	// Replace the least counted key, which key may have been counted as.
+	item := t.h[0]
+	delete(t.m, item.Key)
+	item.Key = key
+	item.Error = item.Count
+	item.Count++
+	t.m[key] = item
+	heap.Fix(&t.h, 0)
+}
+
+// Top returns up to n entries with the largest counts in descending order.
+// All the entries are returned if n is not positive.
+func (t *TopK) Top(n int) []TopKEntry {
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	ret := make([]TopKEntry, len(t.h))
+	for i, item := range t.h {
+		ret[i] = item.TopKEntry
+	}
+	t.mu.Unlock()
+
+	slices.SortFunc(ret, func(a, b TopKEntry) int {
+		if c := cmp.Compare(b.Count, a.Count); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Key, b.Key)
+	})
+	if n > 0 && len(ret) > n {
+		ret = ret[:n]
+	}
+	return ret
+}
+
+// topKHeap is a min-heap of items ordered by their counts.
+type topKHeap []*topKItem
+
+func (h topKHeap) Len() int           { return len(h) }
+func (h topKHeap) Less(i, j int) bool { return h[i].Count < h[j].Count }
+
+func (h topKHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].idx = i
+	h[j].idx = j
+}
+
+func (h *topKHeap) Push(x any) {
+	item := x.(*topKItem)
+	item.idx = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *topKHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return item
+}
@@ -0,0 +1,56 @@
+package mocrelay
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTopK(t *testing.T) {
+	topK := NewTopK(3)
+
+	for i := 0; i < 3; i++ {
+		topK.Add("a")
+	}
+	topK.Add("b")
+	topK.Add("b")
+	topK.Add("c")
+
+	assert.Equal(t, []TopKEntry{
+		{Key: "a", Count: 3},
+		{Key: "b", Count: 2},
+	}, topK.Top(2))
+
+	// "d" replaces "c", the least counted one.
+	topK.Add("d")
+	assert.Equal(t, []TopKEntry{
+		{Key: "a", Count: 3},
+		{Key: "b", Count: 2},
+		{Key: "d", Count: 2, Error: 1},
+	}, topK.Top(0))
+}
+
+func TestTopK_heavyHitter(t *testing.T) {
+	topK := NewTopK(10)
+
+	for i := 0; i < 1000; i++ {
+		topK.Add(strconv.Itoa(i))
+		if i%3 == 0 {
+			topK.Add("spammer")
+		}
+	}
+
+	top := topK.Top(1)
+	if assert.Len(t, top, 1) {
+		assert.Equal(t, "spammer", top[0].Key)
+		assert.GreaterOrEqual(t, top[0].Count, uint64(334))
+		assert.LessOrEqual(t, top[0].Count-top[0].Error, uint64(334))
+	}
+}
+
+func TestTopK_nil(t *testing.T) {
+	var topK *TopK
+	topK.Add("a")
+	assert.Nil(t, topK.Top(1))
+}
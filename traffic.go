@@ -0,0 +1,500 @@
+package mocrelay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	TrafficDirRecv = "recv"
+	TrafficDirSend = "send"
+)
+
+type TrafficRecord struct {
+	ConnID string          `json:"conn"`
+	Time   time.Time       `json:"time"`
+	Dir    string          `json:"dir"`
+	Msg    json.RawMessage `json:"msg"`
+}
+
+type TrafficRecorderOption struct {
+	// SampleRate is the fraction of connections to record in [0, 1].
+	// Default is 1.
+	SampleRate float64
+}
+
+func (opt *TrafficRecorderOption) sampleRate() float64 {
+	if opt == nil || opt.SampleRate <= 0 || opt.SampleRate > 1 {
+		return 1
+	}
+	return opt.SampleRate
+}
+
+// TrafficRecorder writes raw client messages and OK and CLOSED responses of sampled
+// connections as JSON lines. Connections are sampled as a whole so that
+// recorded traffic can be replayed with TrafficReplayer.
+type TrafficRecorder struct {
+	threshold uint32
+
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error
+}
+
+func NewTrafficRecorder(w io.Writer, option *TrafficRecorderOption) *TrafficRecorder {
+	return &TrafficRecorder{
+		threshold: uint32(option.sampleRate() * math.MaxUint32),
+		enc:       json.NewEncoder(w),
+	}
+}
+
+func (rec *TrafficRecorder) sampled(connID string) bool {
+	if rec.threshold == math.MaxUint32 {
+		return true
+	}
+	h := fnv.New32a()
+	io.WriteString(h, connID)
+	return h.Sum32() < rec.threshold
+}
+
+func (rec *TrafficRecorder) record(ctx context.Context, dir string, msg []byte) {
+	if rec == nil {
+		return
+	}
+
+	connID := GetRequestID(ctx)
+	if !rec.sampled(connID) {
+		return
+	}
+
+	r := TrafficRecord{
+		ConnID: connID,
+		Time:   time.Now(),
+		Dir:    dir,
+		Msg:    msg,
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	if rec.err != nil {
+		return
+	}
+	rec.err = rec.enc.Encode(&r)
+}
+
+func (rec *TrafficRecorder) recordRecv(ctx context.Context, payload []byte) {
+	rec.record(ctx, TrafficDirRecv, payload)
+}
+
+func (rec *TrafficRecorder) recordSend(ctx context.Context, msg ServerMsg, jsonMsg []byte) {
+	switch msg.(type) {
+	case *ServerOKMsg, *ServerClosedMsg:
+		rec.record(ctx, TrafficDirSend, jsonMsg)
+	}
+}
+
+// Err returns the first write error. Recording stops after an error.
+func (rec *TrafficRecorder) Err() error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return rec.err
+}
+
+type TrafficReplayer struct {
+	Handler Handler
+
+	// Speed scales the recorded intervals between messages.
+	// 0 sends messages without waiting.
+	Speed float64
+
+	// Timeout is how long to wait for OK, EOSE and CLOSED responses after
+	// the last message of a connection. Default is 1 second.
+	Timeout time.Duration
+
+	ContentPolicy *ContentPolicy
+}
+
+func (rp *TrafficReplayer) timeout() time.Duration {
+	const defaultTimeout = 1 * time.Second
+
+	if rp.Timeout == 0 {
+		return defaultTimeout
+	}
+	return rp.Timeout
+}
+
+type ReplayReport struct {
+	Events  int
+	Matched int
+
+	// Subscriptions are REQ and COUNT subscriptions whose CLOSED outcomes are compared.
+	Subscriptions        int
+	MatchedSubscriptions int
+
+	Diffs []ReplayDiff
+}
+
+// ReplayDiff is an event whose OK outcome or a subscription whose CLOSED outcome
+// differs between the recording and the replay.
+// Recorded or Replayed is nil if no OK was observed, and RecordedClosed or
+// ReplayedClosed is nil if the subscription was not closed by the relay.
+type ReplayDiff struct {
+	ConnID   string
+	EventID  string
+	Recorded *ServerOKMsg
+	Replayed *ServerOKMsg
+
+	SubscriptionID string
+	RecordedClosed *ServerClosedMsg
+	ReplayedClosed *ServerClosedMsg
+}
+
+type replayConn struct {
+	id      string
+	records []*TrafficRecord
+}
+
+// Replay runs the recorded traffic against rp.Handler and compares OK and CLOSED outcomes.
+// Each recorded connection is replayed concurrently on its own Handle call.
+func (rp *TrafficReplayer) Replay(ctx context.Context, r io.Reader) (*ReplayReport, error) {
+	if rp.Handler == nil {
+		return nil, errors.New("replayer handler is nil")
+	}
+
+	conns, err := readTrafficRecords(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	report := new(ReplayReport)
+
+	for _, conn := range conns {
+		conn := conn
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			r := rp.replayConn(ctx, conn)
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Events += r.Events
+			report.Matched += r.Matched
+			report.Subscriptions += r.Subscriptions
+			report.MatchedSubscriptions += r.MatchedSubscriptions
+			report.Diffs = append(report.Diffs, r.Diffs...)
+		}()
+	}
+
+	wg.Wait()
+
+	return report, ctx.Err()
+}
+
+func readTrafficRecords(r io.Reader) ([]*replayConn, error) {
+	var ret []*replayConn
+	conns := make(map[string]*replayConn)
+
+	dec := json.NewDecoder(r)
+	for {
+		var record TrafficRecord
+		if err := dec.Decode(&record); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read traffic record: %w", err)
+		}
+
+		conn, ok := conns[record.ConnID]
+		if !ok {
+			conn = &replayConn{id: record.ConnID}
+			conns[record.ConnID] = conn
+			ret = append(ret, conn)
+		}
+		conn.records = append(conn.records, &record)
+	}
+
+	return ret, nil
+}
+
+func (rp *TrafficReplayer) replayConn(ctx context.Context, conn *replayConn) *ReplayReport {
+	// map[eventID]ok
+	recorded := make(map[string]*ServerOKMsg)
+	// map[subID]closed
+	recordedClosed := make(map[string]*ServerClosedMsg)
+
+	for _, record := range conn.records {
+		if record.Dir != TrafficDirSend {
+			continue
+		}
+		if ok := parseRecordedOKMsg(record.Msg); ok != nil {
+			recorded[ok.EventID] = ok
+		} else if closed := parseRecordedClosedMsg(record.Msg); closed != nil {
+			recordedClosed[closed.SubscriptionID] = closed
+		}
+	}
+
+	replayed := rp.runConn(ctx, conn)
+	report := new(ReplayReport)
+
+	for _, id := range replayed.eventIDs {
+		report.Events++
+		rec, rep := recorded[id], replayed.oks[id]
+		if sameOKOutcome(rec, rep) {
+			report.Matched++
+			continue
+		}
+		report.Diffs = append(report.Diffs, ReplayDiff{
+			ConnID:   conn.id,
+			EventID:  id,
+			Recorded: rec,
+			Replayed: rep,
+		})
+	}
+
+	for _, id := range replayed.subIDs {
+		report.Subscriptions++
+		rec, rep := recordedClosed[id], replayed.closed[id]
+		if sameClosedOutcome(rec, rep) {
+			report.MatchedSubscriptions++
+			continue
+		}
+		report.Diffs = append(report.Diffs, ReplayDiff{
+			ConnID:         conn.id,
+			SubscriptionID: id,
+			RecordedClosed: rec,
+			ReplayedClosed: rep,
+		})
+	}
+
+	return report
+}
+
+// replayResult is the responses to a replayed connection.
+type replayResult struct {
+	eventIDs []string
+	subIDs   []string
+	// map[eventID]ok
+	oks map[string]*ServerOKMsg
+	// map[subID]closed
+	closed map[string]*ServerClosedMsg
+}
+
+func (rp *TrafficReplayer) runConn(ctx context.Context, conn *replayConn) *replayResult {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+	req = req.WithContext(ctxWithSession(req.Context(), newSession(req, nil, "")))
+
+	recv := make(chan ClientMsg)
+	send := make(chan ServerMsg)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		rp.Handler.Handle(req, recv, send)
+	}()
+
+	var mu sync.Mutex
+	ret := &replayResult{
+		oks:    make(map[string]*ServerOKMsg),
+		closed: make(map[string]*ServerClosedMsg),
+	}
+	// Events waiting for OK and subscriptions waiting for EOSE, COUNT or CLOSED.
+	pending := make(map[string]bool)
+	pendingSubs := make(map[string]bool)
+	allDone := make(chan struct{})
+	var fed bool
+
+	checkDone := func() {
+		if fed && len(pending) == 0 && len(pendingSubs) == 0 {
+			select {
+			case <-allDone:
+			default:
+				close(allDone)
+			}
+		}
+	}
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg := <-send:
+				mu.Lock()
+				switch msg := msg.(type) {
+				case *ServerOKMsg:
+					ret.oks[msg.EventID] = msg
+					delete(pending, msg.EventID)
+				case *ServerClosedMsg:
+					ret.closed[msg.SubscriptionID] = msg
+					delete(pendingSubs, msg.SubscriptionID)
+				case *ServerEOSEMsg:
+					delete(pendingSubs, msg.SubscriptionID)
+				case *ServerCountMsg:
+					delete(pendingSubs, msg.SubscriptionID)
+				}
+				checkDone()
+				mu.Unlock()
+			}
+		}
+	}()
+
+	addSub := func(id string) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if !slices.Contains(ret.subIDs, id) {
+			ret.subIDs = append(ret.subIDs, id)
+		}
+		// A REQ with the same id replaces the subscription and its outcome.
+		delete(ret.closed, id)
+		pendingSubs[id] = true
+	}
+
+	var prev time.Time
+	for _, record := range conn.records {
+		if record.Dir != TrafficDirRecv {
+			continue
+		}
+
+		if rp.Speed > 0 && !prev.IsZero() {
+			d := time.Duration(float64(record.Time.Sub(prev)) / rp.Speed)
+			select {
+			case <-ctx.Done():
+				return ret
+			case <-time.After(d):
+			}
+		}
+		prev = record.Time
+
+		msg, err := ParseClientMsg(record.Msg)
+		if err != nil {
+			continue
+		}
+		opt := &CheckClientMsgOption{ContentPolicy: rp.ContentPolicy}
+		if ok, err := CheckClientMsgWithOption(msg, opt); errors.Is(err, ErrContentPolicy) {
+			m := msg.(*ClientEventMsg)
+			mu.Lock()
+			ret.eventIDs = append(ret.eventIDs, m.Event.ID)
+			ret.oks[m.Event.ID] = NewServerOKMsg(
+				m.Event.ID,
+				false,
+				ServerOkMsgPrefixRateInvalid,
+				contentPolicyReason(err),
+			)
+			mu.Unlock()
+			continue
+		} else if err != nil || !ok {
+			continue
+		}
+
+		switch m := msg.(type) {
+		case *ClientEventMsg:
+			mu.Lock()
+			ret.eventIDs = append(ret.eventIDs, m.Event.ID)
+			pending[m.Event.ID] = true
+			mu.Unlock()
+		case *ClientReqMsg:
+			addSub(m.SubscriptionID)
+		case *ClientCountMsg:
+			addSub(m.SubscriptionID)
+		case *ClientCloseMsg:
+			mu.Lock()
+			delete(pendingSubs, m.SubscriptionID)
+			mu.Unlock()
+		}
+
+		if !sendCtx(ctx, recv, msg) {
+			break
+		}
+	}
+
+	mu.Lock()
+	fed = true
+	checkDone()
+	mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+	case <-allDone:
+	case <-time.After(rp.timeout()):
+	}
+
+	cancel()
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	return ret
+}
+
+func parseRecordedOKMsg(b []byte) *ServerOKMsg {
+	var elems []any
+	if err := json.Unmarshal(b, &elems); err != nil || len(elems) != 4 || elems[0] != "OK" {
+		return nil
+	}
+
+	id, ok1 := elems[1].(string)
+	accepted, ok2 := elems[2].(bool)
+	msg, ok3 := elems[3].(string)
+	if !ok1 || !ok2 || !ok3 {
+		return nil
+	}
+
+	prefix, msg := splitRecordedMsgPrefix(msg)
+	return NewServerOKMsg(id, accepted, prefix, msg)
+}
+
+func parseRecordedClosedMsg(b []byte) *ServerClosedMsg {
+	var elems []any
+	if err := json.Unmarshal(b, &elems); err != nil || len(elems) != 3 || elems[0] != "CLOSED" {
+		return nil
+	}
+
+	id, ok1 := elems[1].(string)
+	msg, ok2 := elems[2].(string)
+	if !ok1 || !ok2 {
+		return nil
+	}
+
+	prefix, msg := splitRecordedMsgPrefix(msg)
+	return NewServerClosedMsg(id, prefix, msg)
+}
+
+// splitRecordedMsgPrefix splits the machine-readable prefix such as "blocked: " from msg.
+func splitRecordedMsgPrefix(msg string) (prefix, rest string) {
+	if i := strings.Index(msg, ": "); i >= 0 && !strings.Contains(msg[:i], " ") {
+		return msg[:i+2], msg[i+2:]
+	}
+	return "", msg
+}
+
+func sameOKOutcome(a, b *ServerOKMsg) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Accepted == b.Accepted && a.MsgPrefix == b.MsgPrefix
+}
+
+func sameClosedOutcome(a, b *ServerClosedMsg) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.MsgPrefix == b.MsgPrefix
+}
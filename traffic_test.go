@@ -0,0 +1,197 @@
+package mocrelay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrafficRecorder_sampled(t *testing.T) {
+	all := NewTrafficRecorder(new(bytes.Buffer), nil)
+	none := NewTrafficRecorder(new(bytes.Buffer), &TrafficRecorderOption{SampleRate: 1e-9})
+	half := NewTrafficRecorder(new(bytes.Buffer), &TrafficRecorderOption{SampleRate: 0.5})
+
+	var n int
+	for i := 0; i < 1000; i++ {
+		ctx := ctxWithTestSession(context.Background(), "")
+		id := GetRequestID(ctx)
+		assert.True(t, all.sampled(id))
+		assert.False(t, none.sampled(id))
+		if half.sampled(id) {
+			n++
+		}
+		assert.Equal(t, half.sampled(id), half.sampled(id))
+	}
+	assert.InDelta(t, 500, n, 100)
+}
+
+func TestTrafficReplayer_Replay(t *testing.T) {
+	event1 := signTestEvent(
+		t,
+		&Event{CreatedAt: time.Now().Unix(), Kind: 1, Tags: []Tag{}, Content: "1"},
+	)
+	event2 := signTestEvent(
+		t,
+		&Event{CreatedAt: time.Now().Unix(), Kind: 1, Tags: []Tag{}, Content: "2"},
+	)
+
+	var buf bytes.Buffer
+	rec := NewTrafficRecorder(&buf, nil)
+	ctx := ctxWithTestSession(context.Background(), "")
+
+	for _, ev := range []*Event{event1, event2} {
+		payload, err := json.Marshal([]any{"EVENT", ev})
+		assert.NoError(t, err)
+		rec.recordRecv(ctx, payload)
+
+		ok := NewServerOKMsg(ev.ID, true, ServerOKMsgPrefixNoPrefix, "")
+		jsonMsg, err := ok.MarshalJSON()
+		assert.NoError(t, err)
+		rec.recordSend(ctx, ok, jsonMsg)
+		rec.recordSend(ctx, NewServerNoticeMsg("ignored"), []byte(`["NOTICE","ignored"]`))
+	}
+	rec.recordRecv(ctx, []byte(`["REQ","sub",{}]`))
+	assert.NoError(t, rec.Err())
+
+	recorded := buf.Bytes()
+
+	t.Run("same outcome", func(t *testing.T) {
+		rp := &TrafficReplayer{Handler: NewCacheHandler(10), Timeout: time.Second}
+		report, err := rp.Replay(context.Background(), bytes.NewReader(recorded))
+		assert.NoError(t, err)
+		assert.Equal(t, 2, report.Events)
+		assert.Equal(t, 2, report.Matched)
+		assert.Equal(t, 1, report.Subscriptions)
+		assert.Equal(t, 1, report.MatchedSubscriptions)
+		assert.Empty(t, report.Diffs)
+	})
+
+	t.Run("changed closed outcome", func(t *testing.T) {
+		var buf bytes.Buffer
+		rec := NewTrafficRecorder(&buf, nil)
+		ctx := ctxWithTestSession(context.Background(), "")
+
+		rec.recordRecv(ctx, []byte(`["REQ","closed",{}]`))
+		closed := NewServerClosedMsg("closed", ServerClosedMsgPrefixAuthRequired, "dm")
+		jsonMsg, err := closed.MarshalJSON()
+		assert.NoError(t, err)
+		rec.recordSend(ctx, closed, jsonMsg)
+		rec.recordRecv(ctx, []byte(`["REQ","open",{}]`))
+		assert.NoError(t, rec.Err())
+
+		rp := &TrafficReplayer{Handler: NewCacheHandler(10), Timeout: time.Second}
+		report, err := rp.Replay(context.Background(), &buf)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, report.Subscriptions)
+		assert.Equal(t, 1, report.MatchedSubscriptions)
+		if assert.Len(t, report.Diffs, 1) {
+			diff := report.Diffs[0]
+			assert.Equal(t, "closed", diff.SubscriptionID)
+			assert.Equal(t, ServerClosedMsgPrefixAuthRequired, diff.RecordedClosed.MsgPrefix)
+			assert.Nil(t, diff.ReplayedClosed)
+		}
+	})
+
+	t.Run("changed outcome", func(t *testing.T) {
+		moderator := NewModerator(nil)
+		moderator.DeleteEvent(event2.ID, "spam")
+
+		rp := &TrafficReplayer{
+			Handler: NewModerationMiddleware(moderator)(NewCacheHandler(10)),
+			Speed:   100,
+			Timeout: time.Second,
+		}
+		report, err := rp.Replay(context.Background(), bytes.NewReader(recorded))
+		assert.NoError(t, err)
+		assert.Equal(t, 2, report.Events)
+		assert.Equal(t, 1, report.Matched)
+		if assert.Len(t, report.Diffs, 1) {
+			diff := report.Diffs[0]
+			assert.Equal(t, GetRequestID(ctx), diff.ConnID)
+			assert.Equal(t, event2.ID, diff.EventID)
+			assert.True(t, diff.Recorded.Accepted)
+			assert.False(t, diff.Replayed.Accepted)
+			assert.Equal(t, ServerOkMsgPrefixBlocked, diff.Replayed.MsgPrefix)
+		}
+	})
+
+	t.Run("content policy", func(t *testing.T) {
+		rp := &TrafficReplayer{
+			Handler:       NewCacheHandler(10),
+			Timeout:       time.Second,
+			ContentPolicy: &ContentPolicy{MaxContentLength: 0},
+		}
+		report, err := rp.Replay(context.Background(), bytes.NewReader(recorded))
+		assert.NoError(t, err)
+		assert.Equal(t, 2, report.Matched)
+
+		rp.ContentPolicy.MaxContentLength = 1
+		event3 := signTestEvent(
+			t,
+			&Event{CreatedAt: time.Now().Unix(), Kind: 1, Tags: []Tag{}, Content: "33"},
+		)
+		payload, err := json.Marshal([]any{"EVENT", event3})
+		assert.NoError(t, err)
+
+		var buf bytes.Buffer
+		rec := NewTrafficRecorder(&buf, nil)
+		rec.recordRecv(ctxWithTestSession(context.Background(), ""), payload)
+
+		report, err = rp.Replay(context.Background(), &buf)
+		assert.NoError(t, err)
+		if assert.Len(t, report.Diffs, 1) {
+			assert.Nil(t, report.Diffs[0].Recorded)
+			assert.Equal(t, ServerOkMsgPrefixRateInvalid, report.Diffs[0].Replayed.MsgPrefix)
+		}
+	})
+}
+
+func TestParseRecordedOKMsg(t *testing.T) {
+	tests := []struct {
+		in   string
+		want *ServerOKMsg
+	}{
+		{`["OK","id",true,""]`, NewServerOKMsg("id", true, "", "")},
+		{
+			`["OK","id",false,"blocked: banned pubkey"]`,
+			NewServerOKMsg("id", false, "blocked: ", "banned pubkey"),
+		},
+		{
+			`["OK","id",false,"you are: blocked"]`,
+			NewServerOKMsg("id", false, "", "you are: blocked"),
+		},
+		{`["NOTICE","id"]`, nil},
+		{`["OK","id","true",""]`, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			assert.Equal(t, tt.want, parseRecordedOKMsg([]byte(tt.in)))
+		})
+	}
+}
+
+func TestParseRecordedClosedMsg(t *testing.T) {
+	tests := []struct {
+		in   string
+		want *ServerClosedMsg
+	}{
+		{`["CLOSED","sub",""]`, NewServerClosedMsg("sub", "", "")},
+		{
+			`["CLOSED","sub","auth-required: dm"]`,
+			NewServerClosedMsg("sub", "auth-required: ", "dm"),
+		},
+		{`["OK","id",true,""]`, nil},
+		{`["CLOSED","sub",1]`, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			assert.Equal(t, tt.want, parseRecordedClosedMsg([]byte(tt.in)))
+		})
+	}
+}
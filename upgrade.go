@@ -0,0 +1,176 @@
+package mocrelay
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+)
+
+var ErrUpgradeRejected = errors.New("upgrade rejected")
+
+type UpgradePolicy struct {
+	// AllowedOrigins is a list of host patterns (path.Match syntax) of the Origin header.
+	// Requests without an Origin header are not browsers and are always allowed.
+	// If empty, any origin is allowed.
+	AllowedOrigins []string
+
+	// AllowedHosts is a list of host patterns (path.Match syntax) of the Host header.
+	// If empty, any host is allowed.
+	AllowedHosts []string
+
+	// Rules are checked in order after the checks above.
+	// The first matching rule allows or rejects the request.
+	Rules []*UpgradeRule
+
+	// DenyByDefault rejects requests which match no rules.
+	// By default, they are allowed.
+	DenyByDefault bool
+
+	// ReportOnly only logs and counts rejected requests without rejecting them.
+	ReportOnly bool
+}
+
+// UpgradeRule matches upgrade requests by their headers.
+// A request matches if all the non-empty conditions match.
+// A rule without conditions matches any request.
+type UpgradeRule struct {
+	// Name identifies the rule in errors and metrics.
+	Name string
+
+	// Origins is a list of host patterns (path.Match syntax) of the Origin header.
+	Origins []string
+
+	// UserAgents is a list of case-insensitive substrings of the User-Agent header.
+	UserAgents []string
+
+	// Headers maps header names to value patterns (path.Match syntax).
+	Headers map[string]string
+
+	// Func is a custom condition if not nil.
+	Func func(r *http.Request) bool
+
+	// Allow allows matching requests. Otherwise, they are rejected.
+	Allow bool
+
+	// Matched counts requests decided by the rule if not nil.
+	Matched Counter
+}
+
+func (rule *UpgradeRule) match(r *http.Request) bool {
+	if len(rule.Origins) > 0 {
+		u, err := url.Parse(r.Header.Get("Origin"))
+		if err != nil || u.Host == "" || !matchHostPatterns(rule.Origins, u.Host) {
+			return false
+		}
+	}
+
+	if len(rule.UserAgents) > 0 {
+		ua := strings.ToLower(r.UserAgent())
+		if !slices.ContainsFunc(rule.UserAgents, func(s string) bool {
+			return strings.Contains(ua, strings.ToLower(s))
+		}) {
+			return false
+		}
+	}
+
+	for name, pattern := range rule.Headers {
+		if ok, _ := path.Match(pattern, r.Header.Get(name)); !ok {
+			return false
+		}
+	}
+
+	if rule.Func != nil && !rule.Func(r) {
+		return false
+	}
+
+	return true
+}
+
+func (p *UpgradePolicy) Check(r *http.Request) (status int, err error) {
+	if p == nil {
+		return http.StatusOK, nil
+	}
+
+	if r.Method != http.MethodGet {
+		return http.StatusMethodNotAllowed, fmt.Errorf(
+			"%w: invalid method %q",
+			ErrUpgradeRejected,
+			r.Method,
+		)
+	}
+
+	if r.ContentLength > 0 || len(r.TransferEncoding) > 0 {
+		return http.StatusBadRequest, fmt.Errorf("%w: request has a body", ErrUpgradeRejected)
+	}
+
+	if len(p.AllowedHosts) > 0 && !matchHostPatterns(p.AllowedHosts, r.Host) {
+		return http.StatusForbidden, fmt.Errorf(
+			"%w: host %q is not allowed",
+			ErrUpgradeRejected,
+			r.Host,
+		)
+	}
+
+	if origin := r.Header.Get("Origin"); origin != "" && len(p.AllowedOrigins) > 0 {
+		u, err := url.Parse(origin)
+		if err != nil || !matchHostPatterns(p.AllowedOrigins, u.Host) {
+			return http.StatusForbidden, fmt.Errorf(
+				"%w: origin %q is not allowed",
+				ErrUpgradeRejected,
+				origin,
+			)
+		}
+	}
+
+	return p.checkRules(r)
+}
+
+func (p *UpgradePolicy) checkRules(r *http.Request) (status int, err error) {
+	for _, rule := range p.Rules {
+		if !rule.match(r) {
+			continue
+		}
+
+		incCounter(rule.Matched)
+		if rule.Allow {
+			return http.StatusOK, nil
+		}
+		return http.StatusForbidden, fmt.Errorf(
+			"%w: rejected by rule %q",
+			ErrUpgradeRejected,
+			rule.Name,
+		)
+	}
+
+	if p.DenyByDefault {
+		return http.StatusForbidden, fmt.Errorf(
+			"%w: no rule allows the request",
+			ErrUpgradeRejected,
+		)
+	}
+	return http.StatusOK, nil
+}
+
+func matchHostPatterns(patterns []string, host string) bool {
+	host = strings.ToLower(host)
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if ok, _ := path.Match(pattern, host); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, hostname); ok {
+			return true
+		}
+	}
+	return false
+}
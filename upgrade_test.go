@@ -0,0 +1,221 @@
+package mocrelay
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpgradePolicy_Check(t *testing.T) {
+	policy := &UpgradePolicy{
+		AllowedOrigins: []string{"example.com", "*.example.com"},
+		AllowedHosts:   []string{"relay.example.com"},
+	}
+
+	newReq := func(method, host, origin string) *http.Request {
+		r := httptest.NewRequest(method, "/", nil)
+		r.Host = host
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		return r
+	}
+
+	tests := []struct {
+		name   string
+		policy *UpgradePolicy
+		req    *http.Request
+		status int
+	}{
+		{
+			name:   "ok",
+			policy: policy,
+			req:    newReq(http.MethodGet, "relay.example.com", "https://app.example.com"),
+			status: http.StatusOK,
+		},
+		{
+			name:   "ok: host with port",
+			policy: policy,
+			req:    newReq(http.MethodGet, "Relay.Example.com:443", "https://example.com"),
+			status: http.StatusOK,
+		},
+		{
+			name:   "ok: no origin",
+			policy: policy,
+			req:    newReq(http.MethodGet, "relay.example.com", ""),
+			status: http.StatusOK,
+		},
+		{
+			name:   "ok: nil policy",
+			policy: nil,
+			req:    newReq(http.MethodPost, "evil.example.org", "https://evil.example.org"),
+			status: http.StatusOK,
+		},
+		{
+			name:   "ng: method",
+			policy: policy,
+			req:    newReq(http.MethodPost, "relay.example.com", ""),
+			status: http.StatusMethodNotAllowed,
+		},
+		{
+			name:   "ng: body",
+			policy: policy,
+			req: func() *http.Request {
+				r := httptest.NewRequest(http.MethodGet, "/", strings.NewReader("smuggled"))
+				r.Host = "relay.example.com"
+				return r
+			}(),
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "ng: host",
+			policy: policy,
+			req:    newReq(http.MethodGet, "evil.example.org", ""),
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "ng: origin",
+			policy: policy,
+			req:    newReq(http.MethodGet, "relay.example.com", "https://evil.example.org"),
+			status: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, err := tt.policy.Check(tt.req)
+			assert.Equal(t, tt.status, status)
+			if tt.status == http.StatusOK {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, ErrUpgradeRejected)
+			}
+		})
+	}
+}
+
+func TestUpgradePolicy_Check_rules(t *testing.T) {
+	var scraper, app testCounter
+	rules := []*UpgradeRule{
+		{Name: "scraper", UserAgents: []string{"ScrapeBot"}, Matched: &scraper},
+		{
+			Name:    "app",
+			Origins: []string{"*.example.com"},
+			Headers: map[string]string{"X-App-Version": "2.*"},
+			Allow:   true,
+			Matched: &app,
+		},
+		{
+			Name: "custom",
+			Func: func(r *http.Request) bool { return r.URL.Query().Has("blocked") },
+		},
+	}
+
+	newReq := func(target, ua, origin, version string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		r.Header.Set("User-Agent", ua)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		if version != "" {
+			r.Header.Set("X-App-Version", version)
+		}
+		return r
+	}
+
+	tests := []struct {
+		name          string
+		denyByDefault bool
+		req           *http.Request
+		status        int
+	}{
+		{
+			name:   "ok: no rules match",
+			req:    newReq("/", "curl/8.0", "", ""),
+			status: http.StatusOK,
+		},
+		{
+			name:          "ng: no rules match",
+			denyByDefault: true,
+			req:           newReq("/", "curl/8.0", "", ""),
+			status:        http.StatusForbidden,
+		},
+		{
+			name:          "ok: allowed by rule",
+			denyByDefault: true,
+			req:           newReq("/", "Mozilla/5.0", "https://app.example.com", "2.1"),
+			status:        http.StatusOK,
+		},
+		{
+			name:   "ng: user agent",
+			req:    newReq("/", "mozilla/5.0 (compatible; scrapebot/1.0)", "", ""),
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "ng: first matching rule decides",
+			req:    newReq("/", "ScrapeBot", "https://app.example.com", "2.1"),
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "ng: custom",
+			req:    newReq("/?blocked", "Mozilla/5.0", "https://app.example.com", "1.0"),
+			status: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := &UpgradePolicy{Rules: rules, DenyByDefault: tt.denyByDefault}
+			status, err := policy.Check(tt.req)
+			assert.Equal(t, tt.status, status)
+			if tt.status == http.StatusOK {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, ErrUpgradeRejected)
+			}
+		})
+	}
+
+	assert.Equal(t, 2, scraper.n)
+	assert.Equal(t, 1, app.n)
+}
+
+type testCounter struct{ n int }
+
+func (c *testCounter) Inc() { c.n++ }
+
+func TestRelay_checkUpgrade(t *testing.T) {
+	tests := []struct {
+		name       string
+		reportOnly bool
+		want       bool
+		status     int
+	}{
+		{name: "enforce", reportOnly: false, want: false, status: http.StatusForbidden},
+		{name: "report only", reportOnly: true, want: true, status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var counter testCounter
+			relay := NewRelay(nil, &RelayOption{
+				UpgradePolicy: &UpgradePolicy{
+					AllowedHosts: []string{"relay.example.com"},
+					ReportOnly:   tt.reportOnly,
+				},
+				Metrics: &RelayMetrics{UpgradeRejectedTotal: &counter},
+			})
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Host = "evil.example.org"
+
+			status, ok := relay.checkUpgrade(r)
+			assert.Equal(t, tt.want, ok)
+			assert.Equal(t, tt.status, status)
+			assert.Equal(t, 1, counter.n)
+		})
+	}
+}
@@ -2,9 +2,11 @@ package mocrelay
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"slices"
 	"time"
+	"unicode/utf8"
 )
 
 func panicf(format string, a ...any) {
@@ -139,3 +141,49 @@ func validHexString(s string) bool {
 
 	return true
 }
+
+var errInvalidUTF8 = errors.New("invalid utf-8 sequence")
+
+type utf8Validator struct {
+	pending []byte
+}
+
+func (v *utf8Validator) Write(p []byte) (n int, err error) {
+	n = len(p)
+
+	for len(v.pending) > 0 && len(p) > 0 {
+		v.pending = append(v.pending, p[0])
+		p = p[1:]
+
+		if utf8.FullRune(v.pending) {
+			if !utf8.Valid(v.pending) {
+				return 0, errInvalidUTF8
+			}
+			v.pending = v.pending[:0]
+		}
+	}
+
+	cut := len(p)
+	for i := len(p) - 1; i >= 0 && i >= len(p)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(p[i]) {
+			if !utf8.FullRune(p[i:]) {
+				cut = i
+			}
+			break
+		}
+	}
+
+	if !utf8.Valid(p[:cut]) {
+		return 0, errInvalidUTF8
+	}
+	v.pending = append(v.pending, p[cut:]...)
+
+	return
+}
+
+func (v *utf8Validator) Close() error {
+	if len(v.pending) > 0 {
+		return errInvalidUTF8
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package mocrelay
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUTF8Validator(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks [][]byte
+		err    error
+	}{
+		{
+			name:   "empty",
+			chunks: nil,
+			err:    nil,
+		},
+		{
+			name:   "ascii",
+			chunks: [][]byte{[]byte(`["REQ",`), []byte(`"sub",{}]`)},
+			err:    nil,
+		},
+		{
+			name:   "multibyte",
+			chunks: [][]byte{[]byte("ぽわ〜")},
+			err:    nil,
+		},
+		{
+			name:   "multibyte: split",
+			chunks: [][]byte{[]byte("ぽわ〜")[:4], []byte("ぽわ〜")[4:]},
+			err:    nil,
+		},
+		{
+			name: "multibyte: split into bytes",
+			chunks: [][]byte{
+				{0xe3}, {0x81}, {0xbd}, {0xe3}, {0x82}, {0x8f},
+			},
+			err: nil,
+		},
+		{
+			name:   "ng: invalid byte",
+			chunks: [][]byte{{'a', 0xff, 'b'}},
+			err:    errInvalidUTF8,
+		},
+		{
+			name:   "ng: invalid continuation across chunks",
+			chunks: [][]byte{{'a', 0xe3}, {'b', 'c'}},
+			err:    errInvalidUTF8,
+		},
+		{
+			name:   "ng: truncated",
+			chunks: [][]byte{{'a', 0xe3, 0x81}},
+			err:    errInvalidUTF8,
+		},
+		{
+			name:   "ng: surrogate",
+			chunks: [][]byte{{0xed, 0xa0}, {0x80}},
+			err:    errInvalidUTF8,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v utf8Validator
+			var err error
+			for _, c := range tt.chunks {
+				if _, err = v.Write(c); err != nil {
+					break
+				}
+			}
+			if err == nil {
+				err = v.Close()
+			}
+			assert.ErrorIs(t, err, tt.err)
+		})
+	}
+}
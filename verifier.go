@@ -0,0 +1,340 @@
+package mocrelay
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+)
+
+var (
+	ErrVerifierStopped   = errors.New("verifier stopped")
+	ErrVerifierQueueFull = errors.New("verifier queue is full")
+)
+
+type VerifierOption struct {
+	// Workers is the number of verification goroutines.
+	// Default is runtime.GOMAXPROCS(0).
+	Workers int
+
+	// QueueSize is the max number of events waiting for verification
+	// across all connections.
+	QueueSize int
+
+	// SigVerifier verifies signatures. Default is BtcecSigVerifier.
+	SigVerifier SigVerifier
+
+	// BatchSize is the max number of events a worker verifies at once
+	// when SigVerifier is a BatchSigVerifier.
+	BatchSize int
+
+	// CacheSize is the number of signature results cached so that events
+	// broadcast by many clients are verified once. Zero disables the cache.
+	CacheSize int
+	// CacheHits and CacheMisses count cache lookups if not nil.
+	CacheHits, CacheMisses Counter
+}
+
+func (opt *VerifierOption) workers() int {
+	if opt == nil || opt.Workers <= 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return opt.Workers
+}
+
+func (opt *VerifierOption) queueSize() int {
+	const defaultQueueSize = 1024
+
+	if opt == nil || opt.QueueSize <= 0 {
+		return defaultQueueSize
+	}
+	return opt.QueueSize
+}
+
+// Verifier verifies event signatures on a bounded worker pool shared by connections,
+// which bounds the CPU spent on verification. Jobs are queued per key and
+// taken in round-robin order of the keys. A relay connection waits for each
+// of its events, so it has at most one job queued at a time.
+// Jobs whose callers have given up are dropped without verification.
+type Verifier struct {
+	queueSize   int
+	sigVerifier SigVerifier
+	batchSize   int
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// map[key]jobs
+	queues map[string][]*verifyJob
+	// keys which have pending jobs in round-robin order
+	keys     []string
+	depth    int
+	inFlight int
+	stopped  bool
+
+	wg sync.WaitGroup
+
+	cacheMu sync.Mutex
+	// map[id+sig]ok, nil if disabled
+	cache *lruCache[string, bool]
+}
+
+type verifyJob struct {
+	// ctx is the context of the caller. The job is dropped if it is done.
+	ctx   context.Context
+	event *Event
+	ret   chan verifyResult
+}
+
+type verifyResult struct {
+	ok  bool
+	err error
+}
+
+func (opt *VerifierOption) sigVerifier() SigVerifier {
+	if opt == nil || opt.SigVerifier == nil {
+		return BtcecSigVerifier{}
+	}
+	return opt.SigVerifier
+}
+
+func (opt *VerifierOption) batchSize() int {
+	const defaultBatchSize = 16
+
+	if opt == nil || opt.BatchSize <= 0 {
+		return defaultBatchSize
+	}
+	return opt.BatchSize
+}
+
+func (opt *VerifierOption) cacheSize() int {
+	if opt == nil {
+		return 0
+	}
+	return opt.CacheSize
+}
+
+func NewVerifier(option *VerifierOption) *Verifier {
+	v := &Verifier{
+		queueSize:   option.queueSize(),
+		sigVerifier: option.sigVerifier(),
+		batchSize:   1,
+		queues:      make(map[string][]*verifyJob),
+	}
+	if _, ok := v.sigVerifier.(BatchSigVerifier); ok {
+		v.batchSize = option.batchSize()
+	}
+	v.cond = sync.NewCond(&v.mu)
+	if size := option.cacheSize(); size > 0 {
+		v.cache = newLRUCache[string, bool](size)
+		v.cache.Hits, v.cache.Misses = option.CacheHits, option.CacheMisses
+	}
+
+	for i := 0; i < option.workers(); i++ {
+		v.wg.Add(1)
+		go v.work()
+	}
+
+	return v
+}
+
+// Verify verifies the event on the pool. Key identifies the connection
+// the event came from and is used for scheduling.
+func (v *Verifier) Verify(ctx context.Context, key string, event *Event) (bool, error) {
+	if v.cache == nil {
+		return v.verifyOnPool(ctx, key, event)
+	}
+
+	// The id must be verified before the cache lookup since the cache is keyed by id and sig.
+	// Otherwise a forged event with a valid id and sig of another event would hit.
+	if _, _, _, ok, err := event.sigInputs(); err != nil || !ok {
+		return false, err
+	}
+	cacheKey := event.ID + event.Sig
+
+	v.cacheMu.Lock()
+	ok, found := v.cache.Get(cacheKey)
+	v.cacheMu.Unlock()
+	if found {
+		return ok, nil
+	}
+
+	ok, err := v.verifyOnPool(ctx, key, event)
+	if err != nil {
+		return ok, err
+	}
+
+	v.cacheMu.Lock()
+	v.cache.Set(cacheKey, ok)
+	v.cacheMu.Unlock()
+
+	return ok, nil
+}
+
+func (v *Verifier) verifyOnPool(ctx context.Context, key string, event *Event) (bool, error) {
+	job := &verifyJob{
+		ctx:   ctx,
+		event: event,
+		ret:   make(chan verifyResult, 1),
+	}
+
+	if err := v.enqueue(key, job); err != nil {
+		return false, err
+	}
+
+	select {
+	case <-ctx.Done():
+		return false, ctx.Err()
+	case res := <-job.ret:
+		return res.ok, res.err
+	}
+}
+
+func (v *Verifier) enqueue(key string, job *verifyJob) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.stopped {
+		return ErrVerifierStopped
+	}
+	if v.depth >= v.queueSize {
+		return ErrVerifierQueueFull
+	}
+
+	if len(v.queues[key]) == 0 {
+		v.keys = append(v.keys, key)
+	}
+	v.queues[key] = append(v.queues[key], job)
+	v.depth++
+
+	v.cond.Signal()
+
+	return nil
+}
+
+// dequeue takes at most max jobs. It returns nil after the verifier is stopped.
+func (v *Verifier) dequeue(max int) []*verifyJob {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	var ret []*verifyJob
+
+	for len(ret) == 0 {
+		for len(v.keys) == 0 && !v.stopped {
+			v.cond.Wait()
+		}
+		if v.stopped {
+			return nil
+		}
+
+		for len(ret) < max && len(v.keys) > 0 {
+			key := v.keys[0]
+			v.keys = v.keys[1:]
+
+			jobs := v.queues[key]
+			job := jobs[0]
+			jobs[0] = nil
+			if jobs = jobs[1:]; len(jobs) > 0 {
+				v.queues[key] = jobs
+				v.keys = append(v.keys, key)
+			} else {
+				delete(v.queues, key)
+			}
+			v.depth--
+
+			if job.ctx != nil && job.ctx.Err() != nil {
+				continue
+			}
+			ret = append(ret, job)
+		}
+	}
+
+	v.inFlight += len(ret)
+
+	return ret
+}
+
+func (v *Verifier) work() {
+	defer v.wg.Done()
+
+	for {
+		jobs := v.dequeue(v.batchSize)
+		if jobs == nil {
+			return
+		}
+
+		v.verify(jobs)
+
+		v.mu.Lock()
+		v.inFlight -= len(jobs)
+		v.mu.Unlock()
+	}
+}
+
+func (v *Verifier) verify(jobs []*verifyJob) {
+	bv, ok := v.sigVerifier.(BatchSigVerifier)
+	if !ok || len(jobs) == 1 {
+		for _, job := range jobs {
+			ok, err := job.event.VerifyWith(v.sigVerifier)
+			job.ret <- verifyResult{ok: ok, err: err}
+		}
+		return
+	}
+
+	var batch []*verifyJob
+	var ids, pubkeys, sigs [][]byte
+
+	for _, job := range jobs {
+		id, pubkey, sig, ok, err := job.event.sigInputs()
+		if err != nil || !ok {
+			job.ret <- verifyResult{ok: ok, err: err}
+			continue
+		}
+		batch = append(batch, job)
+		ids = append(ids, id)
+		pubkeys = append(pubkeys, pubkey)
+		sigs = append(sigs, sig)
+	}
+	if len(batch) == 0 {
+		return
+	}
+
+	for i, ok := range bv.VerifySigBatch(ids, pubkeys, sigs) {
+		batch[i].ret <- verifyResult{ok: ok}
+	}
+}
+
+func (v *Verifier) QueueDepth() int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.depth
+}
+
+func (v *Verifier) QueueSize() int { return v.queueSize }
+
+func (v *Verifier) InFlight() int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.inFlight
+}
+
+// Stop stops the workers. Pending jobs fail with ErrVerifierStopped.
+func (v *Verifier) Stop() {
+	v.mu.Lock()
+	if v.stopped {
+		v.mu.Unlock()
+		return
+	}
+	v.stopped = true
+	for _, jobs := range v.queues {
+		for _, job := range jobs {
+			job.ret <- verifyResult{err: ErrVerifierStopped}
+		}
+	}
+	v.queues = make(map[string][]*verifyJob)
+	v.keys = nil
+	v.depth = 0
+	v.cond.Broadcast()
+	v.mu.Unlock()
+
+	v.wg.Wait()
+}
@@ -0,0 +1,202 @@
+package mocrelay
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestVerifierWithoutWorkers(queueSize int) *Verifier {
+	v := &Verifier{
+		queueSize:   queueSize,
+		sigVerifier: BtcecSigVerifier{},
+		batchSize:   1,
+		queues:      make(map[string][]*verifyJob),
+	}
+	v.cond = sync.NewCond(&v.mu)
+	return v
+}
+
+func TestVerifier_Verify(t *testing.T) {
+	v := NewVerifier(&VerifierOption{Workers: 2})
+	defer v.Stop()
+
+	valid := signTestEvent(
+		t,
+		&Event{CreatedAt: 1693157791, Kind: 1, Tags: []Tag{}, Content: "powa"},
+	)
+	invalid := *valid
+	invalid.Content = "meu"
+
+	ctx := context.Background()
+
+	ok, err := v.Verify(ctx, "conn", valid)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = v.Verify(ctx, "conn", &invalid)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	assert.Equal(t, 0, v.QueueDepth())
+	assert.Equal(t, 0, v.InFlight())
+}
+
+func TestVerifier_cache(t *testing.T) {
+	var hits, misses testCounter
+	v := NewVerifier(
+		&VerifierOption{Workers: 1, CacheSize: 10, CacheHits: &hits, CacheMisses: &misses},
+	)
+	defer v.Stop()
+
+	valid := signTestEvent(
+		t,
+		&Event{CreatedAt: 1693157791, Kind: 1, Tags: []Tag{}, Content: "powa"},
+	)
+	forged := *valid
+	forged.Content = "meu"
+	badSig := *valid
+	badSig.Sig = strings.Repeat("0", 128)
+
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		ok, err := v.Verify(ctx, "conn", valid)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+
+		ok, err = v.Verify(ctx, "conn", &badSig)
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	}
+	assert.Equal(t, 2, hits.n)
+	assert.Equal(t, 2, misses.n)
+
+	// The forged event has the id and sig of valid but never hits the cache.
+	ok, err := v.Verify(ctx, "conn", &forged)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, 2, hits.n)
+}
+
+func TestVerifier_fairness(t *testing.T) {
+	v := newTestVerifierWithoutWorkers(10)
+
+	events := map[string][]*Event{
+		"a": {{Content: "a1"}, {Content: "a2"}, {Content: "a3"}},
+		"b": {{Content: "b1"}, {Content: "b2"}},
+		"c": {{Content: "c1"}},
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		for _, ev := range events[key] {
+			assert.NoError(t, v.enqueue(key, &verifyJob{event: ev}))
+		}
+	}
+	assert.Equal(t, 6, v.QueueDepth())
+
+	var got []string
+	for _, job := range v.dequeue(4) {
+		got = append(got, job.event.Content)
+	}
+	assert.Equal(t, []string{"a1", "b1", "c1", "a2"}, got)
+	assert.Equal(t, 2, v.QueueDepth())
+	assert.Equal(t, 4, v.InFlight())
+
+	got = nil
+	for v.QueueDepth() > 0 {
+		got = append(got, v.dequeue(1)[0].event.Content)
+	}
+	assert.Equal(t, []string{"b2", "a3"}, got)
+	assert.Equal(t, 6, v.InFlight())
+}
+
+func TestVerifier_dequeueCancelled(t *testing.T) {
+	v := newTestVerifierWithoutWorkers(10)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	assert.NoError(t, v.enqueue("a", &verifyJob{ctx: ctx, event: &Event{Content: "a1"}}))
+	assert.NoError(t, v.enqueue("b", &verifyJob{event: &Event{Content: "b1"}}))
+	assert.NoError(t, v.enqueue("a", &verifyJob{ctx: ctx, event: &Event{Content: "a2"}}))
+
+	jobs := v.dequeue(4)
+	if assert.Len(t, jobs, 1) {
+		assert.Equal(t, "b1", jobs[0].event.Content)
+	}
+	assert.Equal(t, 0, v.QueueDepth())
+	assert.Equal(t, 1, v.InFlight())
+}
+
+type testBatchSigVerifier struct {
+	BtcecSigVerifier
+	batches []int
+}
+
+func (sv *testBatchSigVerifier) VerifySigBatch(ids, pubkeys, sigs [][]byte) []bool {
+	sv.batches = append(sv.batches, len(ids))
+	ret := make([]bool, len(ids))
+	for i := range ids {
+		ret[i], _ = sv.VerifySig(ids[i], pubkeys[i], sigs[i])
+	}
+	return ret
+}
+
+func TestVerifier_verifyBatch(t *testing.T) {
+	sv := new(testBatchSigVerifier)
+	v := newTestVerifierWithoutWorkers(10)
+	v.sigVerifier = sv
+
+	valid := signTestEvent(
+		t,
+		&Event{CreatedAt: 1693157791, Kind: 1, Tags: []Tag{}, Content: "powa"},
+	)
+	badID := *valid
+	badID.Content = "meu"
+	badSig := *valid
+	badSig.Sig = signTestEvent(t, &Event{Kind: 1, Tags: []Tag{}, Content: "meu"}).Sig
+
+	events := []*Event{valid, &badID, &badSig, valid}
+	jobs := make([]*verifyJob, len(events))
+	for i, ev := range events {
+		jobs[i] = &verifyJob{event: ev, ret: make(chan verifyResult, 1)}
+	}
+
+	v.verify(jobs)
+
+	var got []bool
+	for _, job := range jobs {
+		res := <-job.ret
+		assert.NoError(t, res.err)
+		got = append(got, res.ok)
+	}
+	assert.Equal(t, []bool{true, false, false, true}, got)
+	assert.Equal(t, []int{3}, sv.batches)
+}
+
+func TestVerifier_queueFull(t *testing.T) {
+	v := newTestVerifierWithoutWorkers(2)
+
+	assert.NoError(t, v.enqueue("a", &verifyJob{event: &Event{}}))
+	assert.NoError(t, v.enqueue("b", &verifyJob{event: &Event{}}))
+	assert.ErrorIs(t, v.enqueue("c", &verifyJob{event: &Event{}}), ErrVerifierQueueFull)
+}
+
+func TestVerifier_Stop(t *testing.T) {
+	v := newTestVerifierWithoutWorkers(10)
+
+	job := &verifyJob{event: &Event{}, ret: make(chan verifyResult, 1)}
+	assert.NoError(t, v.enqueue("a", job))
+
+	v.Stop()
+
+	res := <-job.ret
+	assert.ErrorIs(t, res.err, ErrVerifierStopped)
+	assert.Equal(t, 0, v.QueueDepth())
+
+	_, err := v.Verify(context.Background(), "a", &Event{})
+	assert.ErrorIs(t, err, ErrVerifierStopped)
+}
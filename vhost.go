@@ -0,0 +1,50 @@
+package mocrelay
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// VirtualHostMux serves several relays with different identities, policies and
+// storages on one listener, selected by the host of requests.
+type VirtualHostMux struct {
+	// Hosts maps host names to their handlers. Host names are normalized by
+	// NormalizeHost before lookup, so keys must be normalized too.
+	Hosts map[string]http.Handler
+
+	// Default serves requests to the other hosts.
+	// If nil, they get 421 Misdirected Request.
+	Default http.Handler
+}
+
+func (mux *VirtualHostMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host := NormalizeHost(r.Host)
+
+	// A TLS connection is bound to the SNI host. Requests for other hosts on it
+	// are rejected so that a client cannot reach a relay it has no certificate of.
+	if r.TLS != nil && r.TLS.ServerName != "" && NormalizeHost(r.TLS.ServerName) != host {
+		http.Error(w, "host does not match sni", http.StatusMisdirectedRequest)
+		return
+	}
+
+	h := mux.Hosts[host]
+	if h == nil {
+		h = mux.Default
+	}
+	if h == nil {
+		http.Error(w, "unknown host", http.StatusMisdirectedRequest)
+		return
+	}
+	h.ServeHTTP(w, r)
+}
+
+// NormalizeHost returns the lower-case host name of host without the port and
+// the trailing dot, such as "relay.example.com" for "Relay.Example.com.:443".
+func NormalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
@@ -0,0 +1,115 @@
+package mocrelay
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeHost(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"relay.example.com", "relay.example.com"},
+		{"Relay.Example.COM", "relay.example.com"},
+		{"relay.example.com:443", "relay.example.com"},
+		{"relay.example.com.", "relay.example.com"},
+		{"relay.example.com.:8080", "relay.example.com"},
+		{"[::1]:8080", "::1"},
+		{"[::1]", "::1"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			assert.Equal(t, tt.want, NormalizeHost(tt.in))
+		})
+	}
+}
+
+func TestVirtualHostMux(t *testing.T) {
+	handler := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, name)
+		})
+	}
+
+	tests := []struct {
+		name       string
+		noDefault  bool
+		host       string
+		sni        string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "host",
+			host:       "a.example.com",
+			wantStatus: http.StatusOK,
+			wantBody:   "a",
+		},
+		{
+			name:       "normalized host",
+			host:       "B.example.com.:443",
+			wantStatus: http.StatusOK,
+			wantBody:   "b",
+		},
+		{
+			name:       "default",
+			host:       "c.example.com",
+			wantStatus: http.StatusOK,
+			wantBody:   "default",
+		},
+		{
+			name:       "no default",
+			noDefault:  true,
+			host:       "c.example.com",
+			wantStatus: http.StatusMisdirectedRequest,
+		},
+		{
+			name:       "sni",
+			host:       "a.example.com",
+			sni:        "a.example.com",
+			wantStatus: http.StatusOK,
+			wantBody:   "a",
+		},
+		{
+			name:       "sni mismatch",
+			host:       "b.example.com",
+			sni:        "a.example.com",
+			wantStatus: http.StatusMisdirectedRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := &VirtualHostMux{
+				Hosts: map[string]http.Handler{
+					"a.example.com": handler("a"),
+					"b.example.com": handler("b"),
+				},
+			}
+			if !tt.noDefault {
+				mux.Default = handler("default")
+			}
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Host = tt.host
+			if tt.sni != "" {
+				r.TLS = &tls.ConnectionState{ServerName: tt.sni}
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantBody != "" {
+				assert.Equal(t, tt.wantBody, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,254 @@
+package mocrelay
+
+import (
+	"net/http"
+	"sync"
+)
+
+const ContactListEventKind = 3
+
+type WebOfTrustOption struct {
+	// Seeds are the pubkeys the graph starts from.
+	Seeds []string
+	// Depth is the max follow distance from the seeds. The default is 2.
+	Depth int
+}
+
+func (opt *WebOfTrustOption) depth() int {
+	if opt == nil || opt.Depth == 0 {
+		return 2
+	}
+	return opt.Depth
+}
+
+// WebOfTrust is a follow graph built from kind-3 contact lists of the seed pubkeys.
+type WebOfTrust struct {
+	seeds []string
+	depth int
+
+	mu sync.RWMutex
+	// map[pubkey]contacts
+	contacts map[string]*wotContacts
+	// map[pubkey]distance
+	dist map[string]int
+}
+
+type wotContacts struct {
+	createdAt int64
+	follows   []string
+}
+
+func NewWebOfTrust(option *WebOfTrustOption) *WebOfTrust {
+	w := &WebOfTrust{
+		depth:    option.depth(),
+		contacts: make(map[string]*wotContacts),
+	}
+	if option != nil {
+		w.seeds = option.Seeds
+	}
+	w.rebuild()
+	return w
+}
+
+// Update applies the contact list event to the graph.
+// It returns true if the event is newer than the known contact list of its author.
+func (w *WebOfTrust) Update(event *Event) bool {
+	if event == nil || event.Kind != ContactListEventKind {
+		return false
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	old := w.contacts[event.Pubkey]
+	if old != nil && old.createdAt >= event.CreatedAt {
+		return false
+	}
+
+	c := &wotContacts{
+		createdAt: event.CreatedAt,
+		follows:   wotFollows(event),
+	}
+	w.contacts[event.Pubkey] = c
+
+	d, ok := w.dist[event.Pubkey]
+	if !ok || d >= w.depth {
+		return true
+	}
+
+	if old != nil && wotRemoved(old.follows, c.follows) {
+		w.rebuild()
+	} else {
+		w.expand(event.Pubkey)
+	}
+	return true
+}
+
+func wotFollows(event *Event) []string {
+	var ret []string
+	for _, p := range event.Tags.PTags() {
+		ret = append(ret, p.Pubkey)
+	}
+	return ret
+}
+
+func wotRemoved(old, new []string) bool {
+	set := make(map[string]bool, len(new))
+	for _, pubkey := range new {
+		set[pubkey] = true
+	}
+	for _, pubkey := range old {
+		if !set[pubkey] {
+			return true
+		}
+	}
+	return false
+}
+
+// rebuild recomputes the whole graph from the seeds. w.mu must be locked.
+func (w *WebOfTrust) rebuild() {
+	w.dist = make(map[string]int)
+	for _, seed := range w.seeds {
+		w.dist[seed] = 0
+	}
+	for _, seed := range w.seeds {
+		w.expand(seed)
+	}
+}
+
+// expand relaxes the distances reachable from pubkey in breadth-first order.
+// w.mu must be locked.
+func (w *WebOfTrust) expand(pubkey string) {
+	queue := []string{pubkey}
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+
+		d := w.dist[p]
+		if d >= w.depth {
+			continue
+		}
+		c := w.contacts[p]
+		if c == nil {
+			continue
+		}
+		for _, f := range c.follows {
+			if fd, ok := w.dist[f]; ok && fd <= d+1 {
+				continue
+			}
+			w.dist[f] = d + 1
+			queue = append(queue, f)
+		}
+	}
+}
+
+// Contains reports whether pubkey is in the graph.
+func (w *WebOfTrust) Contains(pubkey string) bool {
+	_, ok := w.Distance(pubkey)
+	return ok
+}
+
+// Distance returns the follow distance of pubkey from the nearest seed.
+func (w *WebOfTrust) Distance(pubkey string) (int, bool) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	d, ok := w.dist[pubkey]
+	return d, ok
+}
+
+// Len returns the number of pubkeys in the graph.
+func (w *WebOfTrust) Len() int {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	return len(w.dist)
+}
+
+type WebOfTrustMiddleware Middleware
+
+// NewWebOfTrustMiddleware rejects events from pubkeys out of w
+// and updates w with contact lists the handler accepts.
+func NewWebOfTrustMiddleware(w *WebOfTrust) WebOfTrustMiddleware {
+	if w == nil {
+		panic("web of trust must be non-nil pointer")
+	}
+
+	return func(h Handler) Handler {
+		return HandlerFunc(
+			func(r *http.Request, recv <-chan ClientMsg, send chan<- ServerMsg) error {
+				sm := newSimpleWebOfTrustMiddleware(w)
+				m := NewSimpleMiddleware(sm)
+				return m(h).Handle(r, recv, send)
+			},
+		)
+	}
+}
+
+var _ SimpleMiddlewareInterface = (*simpleWebOfTrustMiddleware)(nil)
+
+type simpleWebOfTrustMiddleware struct {
+	wot *WebOfTrust
+
+	mu sync.Mutex
+	// map[eventID]event
+	pending map[string]*Event
+}
+
+func newSimpleWebOfTrustMiddleware(w *WebOfTrust) *simpleWebOfTrustMiddleware {
+	return &simpleWebOfTrustMiddleware{
+		wot:     w,
+		pending: make(map[string]*Event),
+	}
+}
+
+func (m *simpleWebOfTrustMiddleware) HandleStart(r *http.Request) (*http.Request, error) {
+	return r, nil
+}
+
+func (m *simpleWebOfTrustMiddleware) HandleStop(r *http.Request) error {
+	return nil
+}
+
+func (m *simpleWebOfTrustMiddleware) HandleClientMsg(
+	r *http.Request,
+	msg ClientMsg,
+) (<-chan ClientMsg, <-chan ServerMsg, error) {
+	if msg, ok := msg.(*ClientEventMsg); ok {
+		if !m.wot.Contains(msg.Event.Pubkey) {
+			okMsg := NewServerOKMsg(
+				msg.Event.ID,
+				false,
+				ServerOkMsgPrefixRestricted,
+				"not in web of trust",
+			)
+			return nil, newClosedBufCh[ServerMsg](okMsg), nil
+		}
+
+		if msg.Event.Kind == ContactListEventKind {
+			m.mu.Lock()
+			m.pending[msg.Event.ID] = msg.Event
+			m.mu.Unlock()
+		}
+	}
+
+	return newClosedBufCh(msg), nil, nil
+}
+
+func (m *simpleWebOfTrustMiddleware) HandleServerMsg(
+	r *http.Request,
+	msg ServerMsg,
+) (<-chan ServerMsg, error) {
+	if msg, ok := msg.(*ServerOKMsg); ok {
+		m.mu.Lock()
+		event := m.pending[msg.EventID]
+		delete(m.pending, msg.EventID)
+		m.mu.Unlock()
+
+		if event != nil && msg.Accepted {
+			m.wot.Update(event)
+		}
+	}
+
+	return newClosedBufCh[ServerMsg](msg), nil
+}
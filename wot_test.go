@@ -0,0 +1,108 @@
+package mocrelay
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testWoTPubkey(c string) string { return strings.Repeat(c, 64) }
+
+func testWoTContacts(pubkey string, createdAt int64, follows ...string) *Event {
+	tags := []Tag{{"r", "wss://relay.example.com"}, {"p", "invalid"}}
+	for _, f := range follows {
+		tags = append(tags, Tag{"p", f})
+	}
+	return &Event{
+		ID:        pubkey[:8] + string(rune('0'+createdAt)),
+		Pubkey:    pubkey,
+		CreatedAt: createdAt,
+		Kind:      ContactListEventKind,
+		Tags:      tags,
+	}
+}
+
+func TestWebOfTrust(t *testing.T) {
+	a, b, c, d, e := testWoTPubkey(
+		"a",
+	), testWoTPubkey(
+		"b",
+	), testWoTPubkey(
+		"c",
+	), testWoTPubkey(
+		"d",
+	), testWoTPubkey(
+		"e",
+	)
+
+	w := NewWebOfTrust(&WebOfTrustOption{Seeds: []string{a}, Depth: 2})
+	assert.True(t, w.Contains(a))
+	assert.False(t, w.Contains(b))
+
+	// Contact lists out of the graph are kept for later.
+	assert.True(t, w.Update(testWoTContacts(c, 1, d)))
+	assert.False(t, w.Contains(c))
+
+	assert.True(t, w.Update(testWoTContacts(a, 1, b)))
+	assert.Equal(t, 2, w.Len())
+
+	assert.True(t, w.Update(testWoTContacts(b, 1, c)))
+	dist, ok := w.Distance(c)
+	assert.True(t, ok)
+	assert.Equal(t, 2, dist)
+	// d is beyond the depth.
+	assert.False(t, w.Contains(d))
+
+	// A shortcut lowers the distance and pulls d in.
+	assert.True(t, w.Update(testWoTContacts(a, 2, b, c)))
+	dist, _ = w.Distance(c)
+	assert.Equal(t, 1, dist)
+	assert.True(t, w.Contains(d))
+
+	// Stale and non contact list events are ignored.
+	assert.False(t, w.Update(testWoTContacts(a, 1, e)))
+	assert.False(t, w.Update(&Event{Pubkey: a, Kind: 1, Tags: []Tag{{"p", e}}}))
+	assert.False(t, w.Contains(e))
+
+	// Unfollowing shrinks the graph.
+	assert.True(t, w.Update(testWoTContacts(a, 3)))
+	assert.Equal(t, 1, w.Len())
+	assert.False(t, w.Contains(b))
+}
+
+func TestWebOfTrustMiddleware(t *testing.T) {
+	a, b := testWoTPubkey("a"), testWoTPubkey("b")
+
+	w := NewWebOfTrust(&WebOfTrustOption{Seeds: []string{a}})
+	m := newSimpleWebOfTrustMiddleware(w)
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	cmsgCh, smsgCh, err := m.HandleClientMsg(r, &ClientEventMsg{Event: &Event{ID: "1", Pubkey: b}})
+	require.NoError(t, err)
+	assert.Nil(t, cmsgCh)
+	assert.Equal(
+		t,
+		NewServerOKMsg("1", false, ServerOkMsgPrefixRestricted, "not in web of trust"),
+		<-smsgCh,
+	)
+
+	contacts := testWoTContacts(a, 1, b)
+	cmsgCh, smsgCh, err = m.HandleClientMsg(r, &ClientEventMsg{Event: contacts})
+	require.NoError(t, err)
+	assert.Nil(t, smsgCh)
+	assert.Len(t, cmsgCh, 1)
+	assert.False(t, w.Contains(b))
+
+	_, err = m.HandleServerMsg(r, NewServerOKMsg(contacts.ID, true, "", ""))
+	require.NoError(t, err)
+	assert.True(t, w.Contains(b))
+
+	cmsgCh, smsgCh, err = m.HandleClientMsg(r, &ClientEventMsg{Event: &Event{ID: "2", Pubkey: b}})
+	require.NoError(t, err)
+	assert.Nil(t, smsgCh)
+	assert.Len(t, cmsgCh, 1)
+}
@@ -0,0 +1,167 @@
+package mocrelay
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type WriteCoalesceOption struct {
+	// Interval is how long written frames are held to be flushed together.
+	Interval time.Duration
+	// MaxBytes flushes the held frames when they exceed it. The default is 64 KiB.
+	MaxBytes int
+}
+
+func (opt *WriteCoalesceOption) interval() time.Duration {
+	if opt == nil {
+		return 0
+	}
+	return opt.Interval
+}
+
+func (opt *WriteCoalesceOption) maxBytes() int {
+	if opt == nil || opt.MaxBytes == 0 {
+		return 64 * 1024
+	}
+	return opt.MaxBytes
+}
+
+// coalescingResponseWriter makes the hijacked connection a coalescingConn.
+type coalescingResponseWriter struct {
+	http.ResponseWriter
+	opt *WriteCoalesceOption
+	// writeTimeout is the write deadline of each flush.
+	writeTimeout time.Duration
+}
+
+func (w *coalescingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("http.ResponseWriter does not implement http.Hijacker")
+	}
+
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := brw.Flush(); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	cc := newCoalescingConn(conn, w.opt, w.writeTimeout)
+	return cc, bufio.NewReadWriter(brw.Reader, bufio.NewWriter(cc)), nil
+}
+
+// coalescingConn holds written bytes for a short interval and writes them at once,
+// so that a burst of websocket frames costs a few syscalls instead of one per frame.
+// Write errors are returned by the following Write or Flush.
+// A flush which fails or takes more than writeTimeout closes the connection,
+// so that a stalled peer is disconnected even if nothing more is written.
+type coalescingConn struct {
+	net.Conn
+	interval     time.Duration
+	maxBytes     int
+	writeTimeout time.Duration
+
+	// writeMu serializes writes to Conn.
+	writeMu sync.Mutex
+
+	mu    sync.Mutex
+	buf   []byte
+	spare []byte
+	timer *time.Timer
+	err   error
+}
+
+func newCoalescingConn(
+	conn net.Conn,
+	opt *WriteCoalesceOption,
+	writeTimeout time.Duration,
+) *coalescingConn {
+	return &coalescingConn{
+		Conn:         conn,
+		interval:     opt.interval(),
+		maxBytes:     opt.maxBytes(),
+		writeTimeout: writeTimeout,
+	}
+}
+
+func (c *coalescingConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return 0, c.err
+	}
+
+	c.buf = append(c.buf, p...)
+	full := len(c.buf) >= c.maxBytes
+	if !full && c.timer == nil {
+		c.timer = time.AfterFunc(c.interval, func() { c.Flush() })
+	}
+	c.mu.Unlock()
+
+	if full {
+		if err := c.Flush(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush writes the held bytes.
+func (c *coalescingConn) Flush() error {
+	return c.flush(c.writeTimeout)
+}
+
+// flush writes the held bytes within timeout if it is positive.
+func (c *coalescingConn) flush(timeout time.Duration) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.mu.Lock()
+	buf := c.buf
+	c.buf = c.spare[:0]
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	err := c.err
+	c.mu.Unlock()
+
+	if err != nil || len(buf) == 0 {
+		return err
+	}
+
+	if timeout > 0 {
+		c.Conn.SetWriteDeadline(time.Now().Add(timeout))
+	}
+	_, err = c.Conn.Write(buf)
+
+	c.mu.Lock()
+	c.spare = buf[:0]
+	if err != nil && c.err == nil {
+		c.err = err
+	}
+	c.mu.Unlock()
+
+	if err != nil {
+		c.Conn.Close()
+	}
+	return err
+}
+
+// Close flushes the held bytes and closes the connection.
+// A flush blocked by a slow peer gives up after a second.
+func (c *coalescingConn) Close() error {
+	c.Conn.SetWriteDeadline(time.Now().Add(time.Second))
+	if err := c.flush(time.Second); err != nil {
+		// The connection is closed by the failed flush.
+		return err
+	}
+	return c.Conn.Close()
+}
@@ -0,0 +1,205 @@
+package mocrelay
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"nhooyr.io/websocket"
+)
+
+type testWriteConn struct {
+	net.Conn
+
+	mu     sync.Mutex
+	writes []string
+	err    error
+	closed bool
+}
+
+func (c *testWriteConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return 0, c.err
+	}
+	c.writes = append(c.writes, string(p))
+	return len(p), nil
+}
+
+func (c *testWriteConn) Writes() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.writes...)
+}
+
+func (c *testWriteConn) SetWriteDeadline(time.Time) error { return nil }
+
+func (c *testWriteConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+func TestCoalescingConn(t *testing.T) {
+	t.Run("interval", func(t *testing.T) {
+		conn := new(testWriteConn)
+		c := newCoalescingConn(conn, &WriteCoalesceOption{Interval: 10 * time.Millisecond}, 0)
+
+		for _, s := range []string{"a", "b", "c"} {
+			n, err := c.Write([]byte(s))
+			assert.NoError(t, err)
+			assert.Equal(t, 1, n)
+		}
+		assert.Empty(t, conn.Writes())
+
+		assert.Eventually(
+			t,
+			func() bool { return len(conn.Writes()) == 1 },
+			time.Second,
+			time.Millisecond,
+		)
+		assert.Equal(t, []string{"abc"}, conn.Writes())
+	})
+
+	t.Run("max bytes", func(t *testing.T) {
+		conn := new(testWriteConn)
+		c := newCoalescingConn(conn, &WriteCoalesceOption{Interval: time.Hour, MaxBytes: 4}, 0)
+
+		c.Write([]byte("ab"))
+		assert.Empty(t, conn.Writes())
+		c.Write([]byte("cd"))
+		assert.Equal(t, []string{"abcd"}, conn.Writes())
+	})
+
+	t.Run("close", func(t *testing.T) {
+		conn := new(testWriteConn)
+		c := newCoalescingConn(conn, &WriteCoalesceOption{Interval: time.Hour}, 0)
+
+		c.Write([]byte("ab"))
+		assert.NoError(t, c.Close())
+		assert.Equal(t, []string{"ab"}, conn.Writes())
+		assert.True(t, conn.closed)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		errWrite := errors.New("write error")
+		conn := &testWriteConn{err: errWrite}
+		c := newCoalescingConn(conn, &WriteCoalesceOption{Interval: time.Hour}, 0)
+
+		_, err := c.Write([]byte("ab"))
+		assert.NoError(t, err)
+		assert.ErrorIs(t, c.Flush(), errWrite)
+		_, err = c.Write([]byte("cd"))
+		assert.ErrorIs(t, err, errWrite)
+	})
+}
+
+func TestCoalescingConn_stalled(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	c := newCoalescingConn(
+		server,
+		&WriteCoalesceOption{Interval: time.Millisecond},
+		10*time.Millisecond,
+	)
+
+	// The client reads nothing.
+	_, err := c.Write([]byte("ab"))
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		_, err := c.Write([]byte("cd"))
+		return errors.Is(err, os.ErrDeadlineExceeded)
+	}, time.Second, time.Millisecond)
+
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = client.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF, "the stalled peer is disconnected")
+}
+
+func TestRelay_writeCoalesce(t *testing.T) {
+	relay := NewRelay(NewRouterHandler(10), &RelayOption{
+		WriteCoalesce: &WriteCoalesceOption{Interval: time.Millisecond},
+	})
+	srv := httptest.NewServer(relay)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	conn, _, err := websocket.Dial(ctx, url, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close(websocket.StatusNormalClosure, "")
+
+	for _, subID := range []string{"sub1", "sub2"} {
+		err = conn.Write(ctx, websocket.MessageText, []byte(`["REQ","`+subID+`",{}]`))
+		assert.NoError(t, err)
+	}
+	for _, subID := range []string{"sub1", "sub2"} {
+		_, b, err := conn.Read(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, `["EOSE","`+subID+`"]`, string(b))
+	}
+}
+
+func TestRelay_writeCoalesceStalled(t *testing.T) {
+	var sendTimeouts testAtomicCounter
+	router := NewRouterHandler(100)
+	defer router.Stop()
+	relay := NewRelay(router, &RelayOption{
+		SendTimeout:   50 * time.Millisecond,
+		WriteCoalesce: &WriteCoalesceOption{Interval: time.Millisecond},
+		Metrics:       &RelayMetrics{SendTimeoutTotal: &sendTimeouts},
+	})
+	srv := httptest.NewServer(relay)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	conn, _, err := websocket.Dial(ctx, url, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close(websocket.StatusNormalClosure, "")
+	conn.SetReadLimit(1 << 20)
+
+	err = conn.Write(ctx, websocket.MessageText, []byte(`["REQ","sub",{}]`))
+	assert.NoError(t, err)
+	_, b, err := conn.Read(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, `["EOSE","sub"]`, string(b))
+
+	// The client stops reading until the socket buffers are full.
+	event := &Event{ID: "id", Kind: 1, Content: strings.Repeat("a", 64*1024)}
+	for sendTimeouts.n.Load() == 0 {
+		select {
+		case <-ctx.Done():
+			t.Fatal("timeout")
+		case <-time.After(time.Millisecond):
+			router.Publish(event)
+		}
+	}
+
+	for {
+		if _, _, err := conn.Read(ctx); err != nil {
+			assert.NoError(t, ctx.Err(), "the stalled peer is disconnected")
+			break
+		}
+	}
+}
@@ -0,0 +1,212 @@
+// Package gobwas adapts connections upgraded by github.com/gobwas/ws to mocrelay.WSConn.
+//
+// Upgrade the request with ws.UpgradeHTTP and pass the connection to
+// mocrelay.Relay.ServeConn:
+//
+//	conn, _, _, err := ws.UpgradeHTTP(r, w)
+//	if err != nil {
+//		return
+//	}
+//	relay.ServeConn(r, gobwas.NewConn(conn))
+package gobwas
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/high-moctane/mocrelay"
+)
+
+var ErrReadLimit = errors.New("message exceeds the read limit")
+
+// closeTimeout is how long Close waits to send the close frame.
+const closeTimeout = time.Second
+
+// Conn is a mocrelay.WSConn on a server side connection.
+type Conn struct {
+	conn  net.Conn
+	r     *wsutil.Reader
+	limit atomic.Int64
+	pong  chan struct{}
+
+	writeMu   sync.Mutex
+	closeOnce sync.Once
+	closeErr  error
+}
+
+var (
+	_ mocrelay.WSConn         = (*Conn)(nil)
+	_ mocrelay.WSBinaryWriter = (*Conn)(nil)
+)
+
+// NewConn returns a Conn on conn, which must have completed the server side handshake.
+func NewConn(conn net.Conn) *Conn {
+	c := &Conn{
+		conn: conn,
+		pong: make(chan struct{}, 1),
+	}
+	c.r = wsutil.NewServerSideReader(bufio.NewReader(conn))
+	c.r.OnIntermediate = c.handleControl
+	return c
+}
+
+func (c *Conn) Reader(ctx context.Context) (mocrelay.WSMessageType, io.Reader, error) {
+	stop := context.AfterFunc(ctx, func() { c.conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	for {
+		hdr, err := c.r.NextFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if hdr.OpCode.IsControl() {
+			if err := c.handleControl(hdr, c.r); err != nil {
+				return 0, nil, err
+			}
+			continue
+		}
+
+		typ := mocrelay.WSMessageBinary
+		if hdr.OpCode == ws.OpText {
+			typ = mocrelay.WSMessageText
+		}
+
+		var r io.Reader = c.r
+		if n := c.limit.Load(); n > 0 {
+			r = &limitedReader{c: c, r: c.r, n: n}
+		}
+		return typ, r, nil
+	}
+}
+
+// handleControl replies to a ping or a close frame and notifies a pong to Ping.
+func (c *Conn) handleControl(hdr ws.Header, r io.Reader) error {
+	if hdr.OpCode == ws.OpPong {
+		select {
+		case c.pong <- struct{}{}:
+		default:
+		}
+	}
+
+	h := wsutil.ControlHandler{
+		Src:                 r,
+		Dst:                 lockedWriter{c},
+		State:               ws.StateServerSide,
+		DisableSrcCiphering: true,
+	}
+	return h.Handle(hdr)
+}
+
+func (c *Conn) Write(ctx context.Context, b []byte) error {
+	return c.write(ctx, ws.OpText, b)
+}
+
+func (c *Conn) WriteBinary(ctx context.Context, b []byte) error {
+	return c.write(ctx, ws.OpBinary, b)
+}
+
+func (c *Conn) write(ctx context.Context, op ws.OpCode, b []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	deadline, _ := ctx.Deadline()
+	if err := c.conn.SetWriteDeadline(deadline); err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() { c.conn.SetWriteDeadline(time.Now()) })
+	defer stop()
+
+	return wsutil.WriteServerMessage(c.conn, op, b)
+}
+
+// Ping sends a ping and waits for the pong.
+// The pong is received while the connection is being read.
+func (c *Conn) Ping(ctx context.Context) error {
+	select {
+	case <-c.pong:
+	default:
+	}
+
+	if err := c.writeFrame(ctx, ws.NewPingFrame(nil)); err != nil {
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.pong:
+		return nil
+	}
+}
+
+func (c *Conn) writeFrame(ctx context.Context, f ws.Frame) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	deadline, _ := ctx.Deadline()
+	if err := c.conn.SetWriteDeadline(deadline); err != nil {
+		return err
+	}
+	return ws.WriteFrame(c.conn, f)
+}
+
+// SetReadLimit sets the max length of a message. Zero means no limit.
+func (c *Conn) SetReadLimit(n int64) { c.limit.Store(n) }
+
+// Close sends a close frame and closes the connection.
+// It doesn't wait for the close frame of the peer.
+func (c *Conn) Close(code mocrelay.WSStatusCode, reason string) error {
+	c.closeOnce.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+		defer cancel()
+
+		// A blocked writer would keep the close frame waiting, so close the connection anyway.
+		stop := context.AfterFunc(ctx, func() { c.conn.Close() })
+		defer stop()
+
+		body := ws.NewCloseFrameBody(ws.StatusCode(code), reason)
+		c.writeFrame(ctx, ws.NewCloseFrame(body))
+		c.closeErr = c.conn.Close()
+	})
+	return c.closeErr
+}
+
+type lockedWriter struct {
+	c *Conn
+}
+
+func (w lockedWriter) Write(p []byte) (int, error) {
+	w.c.writeMu.Lock()
+	defer w.c.writeMu.Unlock()
+	return w.c.conn.Write(p)
+}
+
+// limitedReader closes the connection when the message exceeds n bytes.
+type limitedReader struct {
+	c *Conn
+	r io.Reader
+	n int64
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > r.n+1 {
+		p = p[:r.n+1]
+	}
+
+	n, err := r.r.Read(p)
+	r.n -= int64(n)
+	if r.n < 0 {
+		r.c.Close(mocrelay.WSStatusMessageTooBig, ErrReadLimit.Error())
+		return 0, ErrReadLimit
+	}
+	return n, err
+}
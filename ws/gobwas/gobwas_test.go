@@ -0,0 +1,81 @@
+package gobwas
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	nhooyr "nhooyr.io/websocket"
+
+	"github.com/high-moctane/mocrelay"
+)
+
+func dial(t *testing.T, h http.Handler) (context.Context, *nhooyr.Conn) {
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	conn, _, err := nhooyr.Dial(ctx, url, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close(nhooyr.StatusNormalClosure, "") })
+
+	return ctx, conn
+}
+
+func TestConn_ServeConn(t *testing.T) {
+	relay := mocrelay.NewRelay(mocrelay.NewRouterHandler(10), &mocrelay.RelayOption{
+		MaxMessageLength: 64,
+	})
+
+	ctx, conn := dial(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, _, _, err := ws.UpgradeHTTP(r, w)
+		if err != nil {
+			return
+		}
+		relay.ServeConn(r, NewConn(c))
+	}))
+
+	err := conn.Write(ctx, nhooyr.MessageText, []byte(`["REQ","sub",{}]`))
+	require.NoError(t, err)
+	_, b, err := conn.Read(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, `["EOSE","sub"]`, string(b))
+
+	err = conn.Write(ctx, nhooyr.MessageText, []byte(`["REQ","`+strings.Repeat("a", 64)+`",{}]`))
+	require.NoError(t, err)
+	_, _, err = conn.Read(ctx)
+	assert.Equal(t, nhooyr.StatusMessageTooBig, nhooyr.CloseStatus(err))
+}
+
+func TestConn_Ping(t *testing.T) {
+	pinged := make(chan error, 1)
+
+	ctx, conn := dial(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, _, _, err := ws.UpgradeHTTP(r, w)
+		if err != nil {
+			return
+		}
+		wc := NewConn(c)
+		defer wc.Close(mocrelay.WSStatusNormalClosure, "")
+
+		go wc.Reader(context.Background())
+		pinged <- wc.Ping(r.Context())
+	}))
+	conn.CloseRead(ctx)
+
+	select {
+	case err := <-pinged:
+		assert.NoError(t, err)
+	case <-ctx.Done():
+		t.Fatal("pong is not received")
+	}
+}
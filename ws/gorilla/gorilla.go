@@ -0,0 +1,130 @@
+// Package gorilla adapts github.com/gorilla/websocket connections to mocrelay.WSConn.
+//
+// Upgrade the request with a websocket.Upgrader and pass the connection to
+// mocrelay.Relay.ServeConn:
+//
+//	c, err := upgrader.Upgrade(w, r, nil)
+//	if err != nil {
+//		return
+//	}
+//	relay.ServeConn(r, gorilla.NewConn(c))
+package gorilla
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/high-moctane/mocrelay"
+)
+
+// closeTimeout is how long Close waits to send the close frame.
+const closeTimeout = time.Second
+
+// Conn is a mocrelay.WSConn on a gorilla connection.
+// It replaces the pong handler of the connection.
+type Conn struct {
+	conn *websocket.Conn
+	pong chan struct{}
+
+	// writeMu serializes data frames since gorilla allows only one concurrent writer.
+	writeMu   sync.Mutex
+	closeOnce sync.Once
+	closeErr  error
+}
+
+var (
+	_ mocrelay.WSConn         = (*Conn)(nil)
+	_ mocrelay.WSBinaryWriter = (*Conn)(nil)
+)
+
+func NewConn(conn *websocket.Conn) *Conn {
+	c := &Conn{
+		conn: conn,
+		pong: make(chan struct{}, 1),
+	}
+	conn.SetPongHandler(func(string) error {
+		select {
+		case c.pong <- struct{}{}:
+		default:
+		}
+		return nil
+	})
+	return c
+}
+
+func (c *Conn) Reader(ctx context.Context) (mocrelay.WSMessageType, io.Reader, error) {
+	stop := context.AfterFunc(ctx, func() {
+		c.conn.UnderlyingConn().SetReadDeadline(time.Now())
+	})
+	defer stop()
+
+	typ, r, err := c.conn.NextReader()
+	if err != nil {
+		return 0, nil, err
+	}
+	if typ == websocket.TextMessage {
+		return mocrelay.WSMessageText, r, nil
+	}
+	return mocrelay.WSMessageBinary, r, nil
+}
+
+func (c *Conn) Write(ctx context.Context, b []byte) error {
+	return c.write(ctx, websocket.TextMessage, b)
+}
+
+func (c *Conn) WriteBinary(ctx context.Context, b []byte) error {
+	return c.write(ctx, websocket.BinaryMessage, b)
+}
+
+func (c *Conn) write(ctx context.Context, typ int, b []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	deadline, _ := ctx.Deadline()
+	if err := c.conn.SetWriteDeadline(deadline); err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() {
+		c.conn.UnderlyingConn().SetWriteDeadline(time.Now())
+	})
+	defer stop()
+
+	return c.conn.WriteMessage(typ, b)
+}
+
+// Ping sends a ping and waits for the pong.
+// The pong is received while the connection is being read.
+func (c *Conn) Ping(ctx context.Context) error {
+	select {
+	case <-c.pong:
+	default:
+	}
+
+	deadline, _ := ctx.Deadline()
+	if err := c.conn.WriteControl(websocket.PingMessage, nil, deadline); err != nil {
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.pong:
+		return nil
+	}
+}
+
+func (c *Conn) SetReadLimit(n int64) { c.conn.SetReadLimit(n) }
+
+// Close sends a close frame and closes the connection.
+// It doesn't wait for the close frame of the peer.
+func (c *Conn) Close(code mocrelay.WSStatusCode, reason string) error {
+	c.closeOnce.Do(func() {
+		msg := websocket.FormatCloseMessage(int(code), reason)
+		c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(closeTimeout))
+		c.closeErr = c.conn.Close()
+	})
+	return c.closeErr
+}
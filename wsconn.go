@@ -0,0 +1,85 @@
+package mocrelay
+
+import (
+	"context"
+	"io"
+
+	"nhooyr.io/websocket"
+)
+
+// WSMessageType is the type of a websocket data message.
+type WSMessageType int
+
+const (
+	WSMessageText   WSMessageType = 1
+	WSMessageBinary WSMessageType = 2
+)
+
+// WSStatusCode is a websocket close status code.
+type WSStatusCode int
+
+const (
+	WSStatusNormalClosure           WSStatusCode = 1000
+	WSStatusGoingAway               WSStatusCode = 1001
+	WSStatusInvalidFramePayloadData WSStatusCode = 1007
+	WSStatusPolicyViolation         WSStatusCode = 1008
+	WSStatusMessageTooBig           WSStatusCode = 1009
+	WSStatusInternalError           WSStatusCode = 1011
+	WSStatusTryAgainLater           WSStatusCode = 1013
+)
+
+// WSConn is a server side websocket connection served by Relay.
+// Write, Ping and Close may be called concurrently with each other and with reading.
+//
+// The ws/gorilla and ws/gobwas packages adapt other websocket libraries.
+type WSConn interface {
+	// Reader returns the type and the payload of the next data message.
+	// Control frames are handled by the implementation.
+	Reader(ctx context.Context) (WSMessageType, io.Reader, error)
+
+	// Write writes a text message.
+	Write(ctx context.Context, b []byte) error
+
+	// Ping sends a ping and waits for the pong.
+	Ping(ctx context.Context) error
+
+	// SetReadLimit sets the max length of a message in bytes.
+	SetReadLimit(n int64)
+
+	// Close closes the connection with the status code and the reason.
+	Close(code WSStatusCode, reason string) error
+}
+
+// NewNhooyrWSConn wraps a nhooyr.io/websocket connection.
+func NewNhooyrWSConn(conn *websocket.Conn) WSConn { return &nhooyrWSConn{conn: conn} }
+
+type nhooyrWSConn struct {
+	conn *websocket.Conn
+}
+
+func (c *nhooyrWSConn) Reader(ctx context.Context) (WSMessageType, io.Reader, error) {
+	typ, r, err := c.conn.Reader(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+	if typ == websocket.MessageText {
+		return WSMessageText, r, nil
+	}
+	return WSMessageBinary, r, nil
+}
+
+func (c *nhooyrWSConn) Write(ctx context.Context, b []byte) error {
+	return c.conn.Write(ctx, websocket.MessageText, b)
+}
+
+func (c *nhooyrWSConn) WriteBinary(ctx context.Context, b []byte) error {
+	return c.conn.Write(ctx, websocket.MessageBinary, b)
+}
+
+func (c *nhooyrWSConn) Ping(ctx context.Context) error { return c.conn.Ping(ctx) }
+
+func (c *nhooyrWSConn) SetReadLimit(n int64) { c.conn.SetReadLimit(n) }
+
+func (c *nhooyrWSConn) Close(code WSStatusCode, reason string) error {
+	return c.conn.Close(websocket.StatusCode(code), reason)
+}